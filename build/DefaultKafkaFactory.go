@@ -3,12 +3,19 @@ package build
 import (
 	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
 	cbuild "github.com/pip-services3-gox/pip-services3-components-gox/build"
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
 	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	lock "github.com/pip-services3-gox/pip-services3-kafka-gox/lock"
 	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	retention "github.com/pip-services3-gox/pip-services3-kafka-gox/retention"
+	scaling "github.com/pip-services3-gox/pip-services3-kafka-gox/scaling"
+	store "github.com/pip-services3-gox/pip-services3-kafka-gox/store"
 )
 
-// Creates KafkaMessageQueue components by their descriptors.
+// Creates KafkaMessageQueue, MemoryKafkaMessageQueue and PriorityKafkaMessageQueue components by their descriptors.
 // See KafkaMessageQueue
+// See MemoryKafkaMessageQueue
+// See PriorityKafkaMessageQueue
 type DefaultKafkaFactory struct {
 	*cbuild.Factory
 }
@@ -21,10 +28,44 @@ func NewDefaultKafkaFactory() *DefaultKafkaFactory {
 	kafkaQueueFactoryDescriptor := cref.NewDescriptor("pip-services", "queue-factory", "kafka", "*", "1.0")
 	kafkaConnectionDescriptor := cref.NewDescriptor("pip-services", "connection", "kafka", "*", "1.0")
 	kafkaQueueDescriptor := cref.NewDescriptor("pip-services", "message-queue", "kafka", "*", "1.0")
+	memoryKafkaQueueDescriptor := cref.NewDescriptor("pip-services", "message-queue", "memory-kafka", "*", "1.0")
+	priorityKafkaQueueDescriptor := cref.NewDescriptor("pip-services", "message-queue", "priority-kafka", "*", "1.0")
+	kafkaProducerDescriptor := cref.NewDescriptor("pip-services", "producer", "kafka", "*", "1.0")
+	kafkaConsumerDescriptor := cref.NewDescriptor("pip-services", "consumer", "kafka", "*", "1.0")
+	kafkaBridgeDescriptor := cref.NewDescriptor("pip-services", "bridge", "kafka", "*", "1.0")
+	kafkaReplayerDescriptor := cref.NewDescriptor("pip-services", "replayer", "kafka", "*", "1.0")
+	kafkaBrowserDescriptor := cref.NewDescriptor("pip-services", "browser", "kafka", "*", "1.0")
+	kafkaRequestReplyClientDescriptor := cref.NewDescriptor("pip-services", "request-reply-client", "kafka", "*", "1.0")
+	kafkaRequestReplyServerDescriptor := cref.NewDescriptor("pip-services", "request-reply-server", "kafka", "*", "1.0")
+	kafkaEventBusDescriptor := cref.NewDescriptor("pip-services", "event-bus", "kafka", "*", "1.0")
+	kafkaLockDescriptor := cref.NewDescriptor("pip-services", "lock", "kafka", "*", "1.0")
+	kafkaLeaderElectionDescriptor := cref.NewDescriptor("pip-services", "leader-election", "kafka", "*", "1.0")
+	kafkaKeyValueStoreDescriptor := cref.NewDescriptor("pip-services", "key-value-store", "kafka", "*", "1.0")
+	kafkaEventLogDescriptor := cref.NewDescriptor("pip-services", "event-log", "kafka", "*", "1.0")
+	kafkaConfigReloaderDescriptor := cref.NewDescriptor("pip-services", "config-reloader", "kafka", "*", "1.0")
+	kafkaPartitionAdvisorDescriptor := cref.NewDescriptor("pip-services", "partition-advisor", "kafka", "*", "1.0")
+	kafkaDeadLetterRetentionDescriptor := cref.NewDescriptor("pip-services", "dead-letter-retention", "kafka", "*", "1.0")
+	memoryKeyStoreDescriptor := cref.NewDescriptor("pip-services", "key-store", "memory", "*", "1.0")
 
 	c.RegisterType(kafkaQueueFactoryDescriptor, NewKafkaMessageQueueFactory)
 
 	c.RegisterType(kafkaConnectionDescriptor, connect.NewKafkaConnection)
+	c.RegisterType(kafkaProducerDescriptor, clients.NewKafkaProducer)
+	c.RegisterType(kafkaConsumerDescriptor, clients.NewKafkaConsumer)
+	c.RegisterType(kafkaBridgeDescriptor, clients.NewKafkaTopicBridge)
+	c.RegisterType(kafkaReplayerDescriptor, clients.NewKafkaReplayer)
+	c.RegisterType(kafkaBrowserDescriptor, clients.NewKafkaTopicBrowser)
+	c.RegisterType(kafkaRequestReplyClientDescriptor, clients.NewKafkaRequestReplyClient)
+	c.RegisterType(kafkaRequestReplyServerDescriptor, clients.NewKafkaRequestReplyServer)
+	c.RegisterType(kafkaEventBusDescriptor, clients.NewKafkaEventBus)
+	c.RegisterType(kafkaLockDescriptor, lock.NewKafkaLock)
+	c.RegisterType(kafkaLeaderElectionDescriptor, lock.NewKafkaLeaderElection)
+	c.RegisterType(kafkaKeyValueStoreDescriptor, store.NewKafkaKeyValueStore)
+	c.RegisterType(kafkaEventLogDescriptor, store.NewKafkaEventLog)
+	c.RegisterType(kafkaConfigReloaderDescriptor, connect.NewKafkaConfigReloader)
+	c.RegisterType(kafkaPartitionAdvisorDescriptor, scaling.NewKafkaPartitionAdvisor)
+	c.RegisterType(kafkaDeadLetterRetentionDescriptor, retention.NewKafkaDeadLetterRetention)
+	c.RegisterType(memoryKeyStoreDescriptor, queues.NewMemoryKafkaKeyStore)
 
 	c.Register(kafkaQueueDescriptor, func(locator interface{}) interface{} {
 		name := ""
@@ -36,5 +77,25 @@ func NewDefaultKafkaFactory() *DefaultKafkaFactory {
 		return queues.NewKafkaMessageQueue(name)
 	})
 
+	c.Register(memoryKafkaQueueDescriptor, func(locator interface{}) interface{} {
+		name := ""
+		descriptor, ok := locator.(*cref.Descriptor)
+		if ok {
+			name = descriptor.Name()
+		}
+
+		return queues.NewMemoryKafkaMessageQueue(name)
+	})
+
+	c.Register(priorityKafkaQueueDescriptor, func(locator interface{}) interface{} {
+		name := ""
+		descriptor, ok := locator.(*cref.Descriptor)
+		if ok {
+			name = descriptor.Name()
+		}
+
+		return queues.NewPriorityKafkaMessageQueue(name)
+	})
+
 	return &c
 }
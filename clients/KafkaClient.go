@@ -0,0 +1,225 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	ccount "github.com/pip-services3-gox/pip-services3-components-gox/count"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+)
+
+//	KafkaClient is the base class for clients that call commands of KafkaCommandableService
+//	over Kafka topics. It is the Kafka analog of RestClient and GrpcClient:
+//	commands are sent as requests with JSON arguments and their results are returned from replies.
+//
+//	Configuration parameters:
+//
+//		- topic:                         (optional) topic of command requests (default: topic set in the constructor)
+//		- reply_topic:                   (optional) topic of replies (default: <topic>.replies)
+//		- connection(s), credential(s): see KafkaProducer
+//		- options:
+//			- timeout:              	(optional) number of milliseconds to wait for a command result (default: 10000)
+//			- see KafkaConnection
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:counters:*:*:1.0           (optional)  ICounters components to pass collected measurements
+//		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
+//		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
+//		- *:connection:kafka:*:1.0      (optional) Shared connection to Kafka service
+//
+//	Example:
+//		type MyKafkaClient struct {
+//			*clients.KafkaClient
+//		}
+//
+//		func NewMyKafkaClient() *MyKafkaClient {
+//			return &MyKafkaClient{KafkaClient: clients.NewKafkaClient("v1.mycontroller")}
+//		}
+//
+//		func (c *MyKafkaClient) GetData(ctx context.Context, correlationId string, id string) (*MyData, error) {
+//			reply, err := c.CallCommand(ctx, correlationId, "get_data", map[string]any{"id": id})
+//			return clients.HandleKafkaReply[*MyData](reply, err)
+//		}
+type KafkaClient struct {
+	lock    sync.Mutex
+	topic   string
+	timeout int
+
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The performance counters.
+	Counters *ccount.CompositeCounters
+	// The request-reply client that sends commands.
+	Client *KafkaRequestReplyClient
+}
+
+//	NewKafkaClient creates a new instance of the client.
+//	Parameters:
+//		- topic string	a topic of command requests
+//	Returns: *KafkaClient
+func NewKafkaClient(topic string) *KafkaClient {
+	return &KafkaClient{
+		topic:    topic,
+		timeout:  10000,
+		Logger:   clog.NewCompositeLogger(),
+		Counters: ccount.NewCompositeCounters(),
+		Client:   NewKafkaRequestReplyClient(),
+	}
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaClient) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	c.Logger.Configure(ctx, config)
+
+	c.lock.Lock()
+	c.topic = config.GetAsStringWithDefault("topic", c.topic)
+	c.timeout = config.GetAsIntegerWithDefault("options.timeout", c.timeout)
+	topic, timeout := c.topic, c.timeout
+	c.lock.Unlock()
+
+	// The timeout is passed as the reply timeout, so connections do not reject it
+	config = cconf.NewConfigParamsFromMaps(config.Value())
+	config.Remove("options.timeout")
+	config = config.Override(cconf.NewConfigParamsFromTuples(
+		"topic", topic,
+		"options.reply_timeout", timeout,
+	))
+	c.Client.Configure(ctx, config)
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaClient) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.Logger.SetReferences(ctx, references)
+	c.Counters.SetReferences(ctx, references)
+	c.Client.SetReferences(ctx, references)
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaClient) IsOpen() bool {
+	return c.Client.IsOpen()
+}
+
+//	Opens the component.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaClient) Open(ctx context.Context, correlationId string) error {
+	if c.IsOpen() {
+		return nil
+	}
+
+	err := c.Client.Open(ctx, correlationId)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	topic := c.topic
+	c.lock.Unlock()
+
+	c.Logger.Debug(ctx, correlationId, "Kafka client connected to %s", topic)
+	return nil
+}
+
+//	Closes component and frees used resources.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaClient) Close(ctx context.Context, correlationId string) error {
+	return c.Client.Close(ctx, correlationId)
+}
+
+//	Adds instrumentation to log calls and measure call time.
+//	It returns a CounterTiming object that is used to end the time measurement.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- name string	a method name.
+//	Returns: *ccount.CounterTiming object to end the time measurement.
+func (c *KafkaClient) Instrument(ctx context.Context, correlationId string, name string) *ccount.CounterTiming {
+	c.Logger.Trace(ctx, correlationId, "Executing %s method", name)
+	c.Counters.IncrementOne(ctx, name+".call_count")
+	return c.Counters.BeginTiming(ctx, name+".call_time")
+}
+
+//	Adds instrumentation to error handling.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- name string	a method name.
+//		- err error	an occured error
+func (c *KafkaClient) InstrumentError(ctx context.Context, correlationId string, name string, err error) {
+	if err != nil {
+		c.Logger.Error(ctx, correlationId, err, "Failed to call %s method", name)
+		c.Counters.IncrementOne(ctx, name+".call_errors")
+	}
+}
+
+//	Calls a command of the remote KafkaCommandableService.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- name string	a command name
+//		- args any	(optional) command arguments encoded as JSON object
+//	Returns: the JSON encoded command result or error returned by the command,
+//	InvocationError REPLY_TIMEOUT when the result didn't arrive in time.
+func (c *KafkaClient) CallCommand(ctx context.Context, correlationId string, name string, args any) ([]byte, error) {
+	c.lock.Lock()
+	topic := c.topic
+	c.lock.Unlock()
+
+	method := topic + "." + name
+	timing := c.Instrument(ctx, correlationId, method)
+	defer timing.EndTiming(ctx)
+
+	var payload []byte
+	if args != nil {
+		var err error
+		payload, err = json.Marshal(args)
+		if err != nil {
+			err = cerr.NewBadRequestError(correlationId, "INVALID_ARGS", "Failed to encode command arguments").
+				WithDetails("command", name).
+				WithCause(err)
+			c.InstrumentError(ctx, correlationId, method, err)
+			return nil, err
+		}
+	}
+
+	reply, err := c.Client.CallWithHeaders(ctx, correlationId, "", map[string]string{CommandHeader: name}, payload)
+	c.InstrumentError(ctx, correlationId, method, err)
+	return reply, err
+}
+
+//	Decodes the JSON result of a command call.
+//	Parameters:
+//		- reply []byte	a result returned by CallCommand
+//		- err error	an error returned by CallCommand
+//	Returns: the decoded result or error.
+func HandleKafkaReply[T any](reply []byte, err error) (T, error) {
+	var result T
+	if err != nil || len(reply) == 0 {
+		return result, err
+	}
+
+	err = json.Unmarshal(reply, &result)
+	if err != nil {
+		return result, cerr.NewUnknownError("", "INVALID_REPLY", "Failed to decode command result").
+			WithCause(err)
+	}
+	return result, nil
+}
@@ -0,0 +1,472 @@
+package clients
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	ccount "github.com/pip-services3-gox/pip-services3-components-gox/count"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+)
+
+//	KafkaConsumer is a component that consumes raw records from Kafka topics
+//	with explicit offset control. Unlike KafkaMessageQueue it doesn't follow
+//	the IMessageQueue contract and passes records with their keys, headers
+//	and partition information to handlers.
+//
+//	Configuration parameters:
+//
+//		- group_id:                      (optional) consumer group id (default: default)
+//		- from_beginning:                (optional) reads topics from the beginning when the group has no committed offsets (default: false)
+//		- autocommit:                    (optional) commits records after handlers return successfully (default: true)
+//		- connection(s):
+//			- discovery_key:               (optional) a key to retrieve the connection from  IDiscovery
+//			- host:                        host name or IP address
+//			- port:                        port number
+//			- uri:                         resource URI or connection string with all parameters in it
+//		- credential(s):
+//			- store_key:                   (optional) a key to retrieve the credentials from  ICredentialStore
+//			- username:                    user name
+//			- password:                    user password
+//		- options:
+//			- see KafkaConnection
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:counters:*:*:1.0           (optional)  ICounters components to pass collected measurements
+//		- IKafkaMetrics                 (optional) Components to pass labeled measurements, like Prometheus adapters
+//		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
+//		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
+//		- *:connection:kafka:*:1.0      (optional) Shared connection to Kafka service
+//
+//	Example:
+//		consumer := clients.NewKafkaConsumer()
+//		consumer.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"group_id", "billing",
+//			"autocommit", false,
+//			"connection.host", "localhost",
+//			"connection.port", 9092,
+//		))
+//		_ = consumer.Open(ctx, "123")
+//
+//		err := consumer.Subscribe(ctx, "123", []string{"orders", "payments"},
+//			func(ctx context.Context, record *clients.KafkaRecord) error {
+//				...
+//				return consumer.Commit(ctx, record)
+//			})
+type KafkaConsumer struct {
+	defaultConfig   *cconf.ConfigParams
+	config          *cconf.ConfigParams
+	references      cref.IReferences
+	lock            sync.Mutex
+	opened          bool
+	localConnection bool
+
+	// The dependency resolver.
+	DependencyResolver *cref.DependencyResolver
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The performance counters.
+	Counters *ccount.CompositeCounters
+	// The labeled metrics passed to IKafkaMetrics components.
+	Metrics *connect.CompositeKafkaMetrics
+	// The Kafka connection component.
+	Connection connect.IKafkaConnection
+
+	groupId       string
+	fromBeginning bool
+	autoCommit    bool
+	listeners     map[string]*kafkaConsumerListener
+}
+
+//	NewKafkaConsumer creates a new instance of the consumer component.
+//	Returns: *KafkaConsumer
+func NewKafkaConsumer() *KafkaConsumer {
+	c := &KafkaConsumer{
+		defaultConfig: cconf.NewConfigParamsFromTuples(
+			"group_id", "default",
+			"from_beginning", false,
+			"autocommit", true,
+			"dependencies.connection", "*:connection:kafka:*:1.0",
+		),
+		Logger:     clog.NewCompositeLogger(),
+		Counters:   ccount.NewCompositeCounters(),
+		Metrics:    connect.NewCompositeKafkaMetrics(),
+		groupId:    "default",
+		autoCommit: true,
+		listeners:  make(map[string]*kafkaConsumerListener),
+	}
+	c.DependencyResolver = cref.NewDependencyResolver()
+	c.DependencyResolver.Configure(context.Background(), c.defaultConfig)
+	return c
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaConsumer) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	config = config.SetDefaults(c.defaultConfig)
+	c.config = config
+
+	c.DependencyResolver.Configure(ctx, config)
+	c.Logger.Configure(ctx, config)
+
+	c.groupId = config.GetAsStringWithDefault("group_id", c.groupId)
+	c.fromBeginning = config.GetAsBooleanWithDefault("from_beginning", c.fromBeginning)
+	c.autoCommit = config.GetAsBooleanWithDefault("autocommit", c.autoCommit)
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaConsumer) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.references = references
+	c.Logger.SetReferences(ctx, references)
+	c.Counters.SetReferences(ctx, references)
+	c.Metrics.SetReferences(ctx, references)
+
+	// Get connection
+	c.DependencyResolver.SetReferences(ctx, references)
+	result := c.DependencyResolver.GetOneOptional("connection")
+	if dep, ok := result.(connect.IKafkaConnection); ok {
+		c.Connection = dep
+	}
+	// Or create a local one
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	} else {
+		c.localConnection = false
+	}
+}
+
+//	Unsets (clears) previously set references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+func (c *KafkaConsumer) UnsetReferences(ctx context.Context) {
+	c.Connection = nil
+}
+
+func (c *KafkaConsumer) createConnection() *connect.KafkaConnection {
+	connection := connect.NewKafkaConnection()
+
+	if c.config != nil {
+		connection.Configure(context.Background(), c.config)
+	}
+
+	if c.references != nil {
+		connection.SetReferences(context.Background(), c.references)
+	}
+	return connection
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaConsumer) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.opened
+}
+
+//	Opens the component.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaConsumer) Open(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.opened {
+		return nil
+	}
+
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	}
+
+	if c.localConnection {
+		err := c.Connection.Open(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !c.Connection.IsOpen() {
+		return cerr.NewConnectionError(correlationId, "CONNECT_FAILED", "Kafka connection is not opened")
+	}
+
+	c.opened = true
+	return nil
+}
+
+//	Closes component, unsubscribes from all topics and frees used resources.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaConsumer) Close(ctx context.Context, correlationId string) error {
+	if !c.IsOpen() {
+		return nil
+	}
+
+	err := c.Unsubscribe(ctx, correlationId, c.Topics())
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.localConnection {
+		err = c.Connection.Close(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.opened = false
+	return nil
+}
+
+//	Gets the subscribed topics.
+//	Returns: topic names.
+func (c *KafkaConsumer) Topics() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	topics := make([]string, 0, len(c.listeners))
+	for topic := range c.listeners {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+//	Subscribes a handler to topics. Each topic can have only one handler.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- topics []string	topic names
+//		- handler KafkaRecordHandler	a handler of received records
+//	Returns: error or nil for success.
+func (c *KafkaConsumer) Subscribe(ctx context.Context, correlationId string, topics []string, handler KafkaRecordHandler) error {
+	if !c.IsOpen() {
+		return cerr.NewInvalidStateError(correlationId, "NOT_OPENED", "The consumer is not opened")
+	}
+
+	config := kafka.NewConfig()
+	config.Consumer.Offsets.AutoCommit.Enable = c.autoCommit
+	if c.fromBeginning {
+		config.Consumer.Offsets.Initial = kafka.OffsetOldest
+	}
+
+	for _, topic := range topics {
+		c.lock.Lock()
+		_, subscribed := c.listeners[topic]
+		c.lock.Unlock()
+		if subscribed {
+			return cerr.NewConflictError(correlationId, "ALREADY_SUBSCRIBED",
+				"Consumer is already subscribed to topic "+topic).WithDetails("topic", topic)
+		}
+
+		listener := &kafkaConsumerListener{
+			consumer: c,
+			topic:    topic,
+			handler:  handler,
+			ready:    make(chan bool),
+		}
+
+		err := c.Connection.Subscribe(ctx, topic, c.groupId, config, listener)
+		if err != nil {
+			c.Logger.Error(ctx, correlationId, err, "Failed to subscribe to topic "+topic)
+			return err
+		}
+
+		c.lock.Lock()
+		c.listeners[topic] = listener
+		c.lock.Unlock()
+	}
+
+	return nil
+}
+
+//	Unsubscribes from topics.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- topics []string	topic names
+//	Returns: error or nil for success.
+func (c *KafkaConsumer) Unsubscribe(ctx context.Context, correlationId string, topics []string) error {
+	for _, topic := range topics {
+		c.lock.Lock()
+		listener, ok := c.listeners[topic]
+		delete(c.listeners, topic)
+		c.lock.Unlock()
+
+		if !ok {
+			continue
+		}
+
+		err := c.Connection.Unsubscribe(ctx, topic, c.groupId, listener)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//	Commits the offset of a record so it won't be received again by the consumer group.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- record *KafkaRecord	a record to commit
+//	Returns: error or nil for success.
+func (c *KafkaConsumer) Commit(ctx context.Context, record *KafkaRecord) error {
+	if record == nil || record.session == nil {
+		return nil
+	}
+
+	record.session.MarkMessage(record.Message, "")
+	record.session.Commit()
+	return nil
+}
+
+//	Resets the committed offset of the record partition to the record,
+//	so the record and all following records of the partition are received again
+//	after the next rebalance.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- record *KafkaRecord	a record to seek to
+//	Returns: error or nil for success.
+func (c *KafkaConsumer) Seek(ctx context.Context, record *KafkaRecord) error {
+	if record == nil || record.session == nil {
+		return nil
+	}
+
+	record.session.ResetOffset(record.Topic, record.Partition, record.Offset, "")
+	record.session.Commit()
+	return nil
+}
+
+//	Pauses consumption of a topic while keeping the consumer group membership.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- topic string	a topic name
+//	Returns: error or nil for success.
+func (c *KafkaConsumer) Pause(ctx context.Context, topic string) error {
+	c.lock.Lock()
+	listener, ok := c.listeners[topic]
+	c.lock.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return c.Connection.Pause(topic, c.groupId, listener)
+}
+
+//	Resumes consumption of a paused topic.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- topic string	a topic name
+//	Returns: error or nil for success.
+func (c *KafkaConsumer) Resume(ctx context.Context, topic string) error {
+	c.lock.Lock()
+	listener, ok := c.listeners[topic]
+	c.lock.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return c.Connection.Resume(topic, c.groupId, listener)
+}
+
+// Passes records of a subscribed topic to its handler
+func (c *KafkaConsumer) handleRecord(ctx context.Context, topic string, handler KafkaRecordHandler,
+	session kafka.ConsumerGroupSession, msg *kafka.ConsumerMessage) {
+
+	record := newKafkaRecord(session, msg)
+	c.Counters.IncrementOne(ctx, "consumer."+topic+".received_records")
+	c.Metrics.Increment(connect.MetricConsumerReceivedRecords, map[string]string{
+		"topic":     topic,
+		"group":     c.groupId,
+		"partition": strconv.Itoa(int(msg.Partition)),
+	}, 1)
+
+	err := c.callHandler(ctx, handler, record)
+	if err != nil {
+		c.Logger.Error(ctx, "", err, "Failed to process record %d:%d from %s", msg.Partition, msg.Offset, topic)
+		return
+	}
+
+	if c.autoCommit {
+		session.MarkMessage(msg, "")
+	}
+}
+
+func (c *KafkaConsumer) callHandler(ctx context.Context, handler KafkaRecordHandler, record *KafkaRecord) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = cerr.NewUnknownError("", "PROCESSING_FAILED", "Record handler panicked").WithDetails("panic", r)
+		}
+	}()
+
+	return handler(ctx, record)
+}
+
+// Consumer group listener of a subscribed topic
+type kafkaConsumerListener struct {
+	consumer *KafkaConsumer
+	topic    string
+	handler  KafkaRecordHandler
+	lock     sync.Mutex
+	ready    chan bool
+}
+
+func (c *kafkaConsumerListener) Setup(kafka.ConsumerGroupSession) error {
+	// Mark the consumer as ready without blocking on rebalances
+	ready := c.Ready()
+	select {
+	case ready <- true:
+	default:
+	}
+	close(ready)
+	return nil
+}
+
+func (c *kafkaConsumerListener) Cleanup(kafka.ConsumerGroupSession) error {
+	return nil
+}
+
+func (c *kafkaConsumerListener) ConsumeClaim(session kafka.ConsumerGroupSession, claim kafka.ConsumerGroupClaim) error {
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			c.consumer.handleRecord(session.Context(), c.topic, c.handler, session, msg)
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (c *kafkaConsumerListener) Ready() chan bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ready
+}
+
+func (c *kafkaConsumerListener) SetReady(chFlag chan bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ready = chFlag
+}
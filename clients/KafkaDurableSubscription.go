@@ -0,0 +1,24 @@
+package clients
+
+//	KafkaDurableSubscription describes a named subscription of KafkaEventBus.
+//	Its consumer group id is derived from the subscription name, so the subscription
+//	keeps its committed offsets across restarts and is shared by instances of the subscriber.
+type KafkaDurableSubscription struct {
+	// The name of the subscription.
+	Name string `json:"name"`
+	// The type of received events.
+	EventType string `json:"event_type"`
+	// The topic of received events.
+	Topic string `json:"topic"`
+	// The consumer group id derived from the name.
+	GroupId string `json:"group_id"`
+	// True when receiving events is paused.
+	Paused bool `json:"paused"`
+}
+
+// Subscription with its handler and consumer
+type kafkaDurableSubscription struct {
+	info     KafkaDurableSubscription
+	handler  KafkaRecordHandler
+	consumer *KafkaConsumer
+}
@@ -0,0 +1,553 @@
+package clients
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	ccount "github.com/pip-services3-gox/pip-services3-components-gox/count"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+)
+
+// EventTypeHeader is the Kafka header that carries the type of events published by KafkaEventBus
+const EventTypeHeader = "event_type"
+
+//	KafkaEventBus publishes domain events and delivers them to subscribed handlers.
+//	Events are sent to the default topic or to topics mapped to their types,
+//	and the event type is carried in the event_type header, so several types can share a topic.
+//
+//	Every subscriber (usually a service) uses its own consumer group set by group_id,
+//	so each subscriber receives all events, while instances of the same subscriber share them.
+//	All handlers of an event type are called, and the event is redelivered to all of them
+//	when any handler fails.
+//
+//	Durable subscriptions are declared by names and consume events by their own consumer groups
+//	with ids "subscription.<name>", so they keep their positions independently of other handlers.
+//	They can be listed, paused, resumed and deleted together with their consumer groups.
+//
+//	Configuration parameters:
+//
+//		- topic:                         (optional) default topic of events (default: events)
+//		- topics:                        (optional) topics of event types
+//			- <event_type>:             topic of the event type
+//		- group_id:                      (optional) consumer group id of the subscriber (default: default)
+//		- from_beginning:                (optional) receives events from the beginning when the group has no committed offsets (default: false)
+//		- connection(s), credential(s), options: see KafkaConsumer
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:counters:*:*:1.0           (optional)  ICounters components to pass collected measurements
+//		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
+//		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
+//		- *:connection:kafka:*:1.0      (optional) Shared connection to Kafka service
+//
+//	Example:
+//		bus := clients.NewKafkaEventBus()
+//		bus.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"group_id", "invoices",
+//			"topics.order_created", "orders",
+//			"connection.host", "localhost",
+//			"connection.port", 9092,
+//		))
+//		_ = bus.Open(ctx, "123")
+//
+//		_ = bus.Subscribe(ctx, "123", "order_created", func(ctx context.Context, event *clients.KafkaRecord) error {
+//			...
+//			return nil
+//		})
+//		_ = bus.Publish(ctx, "123", "order_created", []byte(`{"order_id": "1"}`))
+type KafkaEventBus struct {
+	lock          sync.Mutex
+	opened        bool
+	config        *cconf.ConfigParams
+	topic         string
+	topics        map[string]string
+	handlers      map[string][]KafkaRecordHandler
+	subscriptions map[string]*kafkaDurableSubscription
+
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The performance counters.
+	Counters *ccount.CompositeCounters
+	// The consumer of subscribed events.
+	Consumer *KafkaConsumer
+	// The producer of published events. It shares the connection of the consumer.
+	Producer *KafkaProducer
+}
+
+//	NewKafkaEventBus creates a new instance of the event bus component.
+//	Returns: *KafkaEventBus
+func NewKafkaEventBus() *KafkaEventBus {
+	return &KafkaEventBus{
+		config:        cconf.NewEmptyConfigParams(),
+		topic:         "events",
+		topics:        make(map[string]string),
+		handlers:      make(map[string][]KafkaRecordHandler),
+		subscriptions: make(map[string]*kafkaDurableSubscription),
+		Logger:        clog.NewCompositeLogger(),
+		Counters:      ccount.NewCompositeCounters(),
+		Consumer:      NewKafkaConsumer(),
+		Producer:      NewKafkaProducer(),
+	}
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaEventBus) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	c.Logger.Configure(ctx, config)
+
+	c.lock.Lock()
+	c.config = config
+	c.topic = config.GetAsStringWithDefault("topic", c.topic)
+	topics := config.GetSection("topics")
+	for _, eventType := range topics.Keys() {
+		c.topics[eventType] = topics.GetAsString(eventType)
+	}
+	c.lock.Unlock()
+
+	c.Consumer.Configure(ctx, config)
+	c.Producer.Configure(ctx, config)
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaEventBus) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.Logger.SetReferences(ctx, references)
+	c.Counters.SetReferences(ctx, references)
+	c.Consumer.SetReferences(ctx, references)
+	c.Producer.SetReferences(ctx, references)
+	shareConnection(c.Consumer, c.Producer)
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaEventBus) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.opened
+}
+
+//	Opens the component and subscribes to topics of events with registered handlers.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaEventBus) Open(ctx context.Context, correlationId string) error {
+	if c.IsOpen() {
+		return nil
+	}
+
+	err := c.Consumer.Open(ctx, correlationId)
+	if err != nil {
+		return err
+	}
+
+	if c.Producer.Connection == nil {
+		c.Producer.Connection = c.Consumer.Connection
+	}
+	err = c.Producer.Open(ctx, correlationId)
+	if err != nil {
+		_ = c.Consumer.Close(ctx, correlationId)
+		return err
+	}
+
+	c.lock.Lock()
+	c.opened = true
+	topics := make(map[string]bool)
+	for eventType := range c.handlers {
+		topics[c.getTopic(eventType)] = true
+	}
+	subscriptions := make([]*kafkaDurableSubscription, 0, len(c.subscriptions))
+	for _, subscription := range c.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	c.lock.Unlock()
+
+	for topic := range topics {
+		err = c.subscribeTopic(ctx, correlationId, topic)
+		if err != nil {
+			_ = c.Close(ctx, correlationId)
+			return err
+		}
+	}
+	for _, subscription := range subscriptions {
+		err = c.openSubscription(ctx, correlationId, subscription)
+		if err != nil {
+			_ = c.Close(ctx, correlationId)
+			return err
+		}
+	}
+	return nil
+}
+
+//	Closes component and stops receiving events. Registered handlers are kept
+//	and subscribed again when the component is reopened.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaEventBus) Close(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	if !c.opened {
+		c.lock.Unlock()
+		return nil
+	}
+	c.opened = false
+	subscriptions := make([]*kafkaDurableSubscription, 0, len(c.subscriptions))
+	for _, subscription := range c.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	c.lock.Unlock()
+
+	for _, subscription := range subscriptions {
+		if subscription.consumer == nil {
+			continue
+		}
+		err := subscription.consumer.Close(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := c.Consumer.Close(ctx, correlationId)
+	if err != nil {
+		return err
+	}
+	return c.Producer.Close(ctx, correlationId)
+}
+
+//	Gets the topic of an event type.
+//	Parameters:
+//		- eventType string	an event type
+//	Returns: the mapped topic or the default topic.
+func (c *KafkaEventBus) GetTopic(eventType string) string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.getTopic(eventType)
+}
+
+func (c *KafkaEventBus) getTopic(eventType string) string {
+	if topic, ok := c.topics[eventType]; ok && topic != "" {
+		return topic
+	}
+	return c.topic
+}
+
+//	Publishes an event to the topic of its type.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- eventType string	an event type
+//		- payload []byte	an event payload
+//	Returns: error or nil for success.
+func (c *KafkaEventBus) Publish(ctx context.Context, correlationId string, eventType string, payload []byte) error {
+	if !c.IsOpen() {
+		return cerr.NewInvalidStateError(correlationId, "NOT_OPENED", "The event bus is not opened")
+	}
+	if eventType == "" {
+		return cerr.NewBadRequestError(correlationId, "NO_EVENT_TYPE", "Event type is not set")
+	}
+
+	headers := map[string]string{
+		EventTypeHeader:            eventType,
+		RequestCorrelationIdHeader: correlationId,
+	}
+	err := c.Producer.Send(ctx, correlationId, c.GetTopic(eventType), "", headers, payload)
+	if err != nil {
+		return err
+	}
+
+	c.Counters.IncrementOne(ctx, "event_bus."+eventType+".published_events")
+	return nil
+}
+
+//	Subscribes a handler to events of a type. Handlers can be subscribed before the component is opened.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- eventType string	an event type
+//		- handler KafkaRecordHandler	a handler of received events
+//	Returns: error or nil for success.
+func (c *KafkaEventBus) Subscribe(ctx context.Context, correlationId string, eventType string, handler KafkaRecordHandler) error {
+	if eventType == "" {
+		return cerr.NewBadRequestError(correlationId, "NO_EVENT_TYPE", "Event type is not set")
+	}
+
+	c.lock.Lock()
+	c.handlers[eventType] = append(c.handlers[eventType], handler)
+	opened, topic := c.opened, c.getTopic(eventType)
+	c.lock.Unlock()
+
+	if !opened {
+		return nil
+	}
+	return c.subscribeTopic(ctx, correlationId, topic)
+}
+
+//	Removes all handlers of an event type. The topic is unsubscribed
+//	when no other subscribed event types are sent to it.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- eventType string	an event type
+//	Returns: error or nil for success.
+func (c *KafkaEventBus) Unsubscribe(ctx context.Context, correlationId string, eventType string) error {
+	c.lock.Lock()
+	delete(c.handlers, eventType)
+	topic := c.getTopic(eventType)
+	used := false
+	for otherType := range c.handlers {
+		used = used || c.getTopic(otherType) == topic
+	}
+	opened := c.opened
+	c.lock.Unlock()
+
+	if !opened || used {
+		return nil
+	}
+	return c.Consumer.Unsubscribe(ctx, correlationId, []string{topic})
+}
+
+// Subscribes the consumer to a topic unless it is already subscribed
+func (c *KafkaEventBus) subscribeTopic(ctx context.Context, correlationId string, topic string) error {
+	for _, subscribed := range c.Consumer.Topics() {
+		if subscribed == topic {
+			return nil
+		}
+	}
+	return c.Consumer.Subscribe(ctx, correlationId, []string{topic}, c.handleEvent)
+}
+
+// Passes a received event to handlers of its type. Events without handlers are skipped.
+func (c *KafkaEventBus) handleEvent(ctx context.Context, record *KafkaRecord) error {
+	eventType := record.Headers[EventTypeHeader]
+
+	c.lock.Lock()
+	handlers := c.handlers[eventType]
+	c.lock.Unlock()
+
+	if len(handlers) == 0 {
+		return nil
+	}
+	c.Counters.IncrementOne(ctx, "event_bus."+eventType+".received_events")
+
+	for _, handler := range handlers {
+		err := handler(ctx, record)
+		if err != nil {
+			c.Counters.IncrementOne(ctx, "event_bus."+eventType+".failed_events")
+			c.Logger.Error(ctx, record.Headers[RequestCorrelationIdHeader], err,
+				"Failed to handle %s event %d:%d from %s", eventType, record.Partition, record.Offset, record.Topic)
+			return err
+		}
+	}
+	return nil
+}
+
+//	Gets the consumer group id of a durable subscription.
+//	Parameters:
+//		- name string	a subscription name
+//	Returns: the consumer group id.
+func (c *KafkaEventBus) GetSubscriptionGroupId(name string) string {
+	return "subscription." + name
+}
+
+//	Declares a durable subscription of a handler to events of a type.
+//	Subscriptions can be declared before the component is opened.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- name string	a unique subscription name
+//		- eventType string	an event type
+//		- handler KafkaRecordHandler	a handler of received events
+//	Returns: error or nil for success, ConflictError SUBSCRIPTION_EXISTS when the name is already used.
+func (c *KafkaEventBus) SubscribeDurable(ctx context.Context, correlationId string, name string,
+	eventType string, handler KafkaRecordHandler) error {
+
+	if name == "" {
+		return cerr.NewBadRequestError(correlationId, "NO_SUBSCRIPTION_NAME", "Subscription name is not set")
+	}
+	if eventType == "" {
+		return cerr.NewBadRequestError(correlationId, "NO_EVENT_TYPE", "Event type is not set")
+	}
+
+	c.lock.Lock()
+	if _, ok := c.subscriptions[name]; ok {
+		c.lock.Unlock()
+		return cerr.NewConflictError(correlationId, "SUBSCRIPTION_EXISTS",
+			"Subscription "+name+" already exists").WithDetails("name", name)
+	}
+	subscription := &kafkaDurableSubscription{
+		info: KafkaDurableSubscription{
+			Name:      name,
+			EventType: eventType,
+			Topic:     c.getTopic(eventType),
+			GroupId:   c.GetSubscriptionGroupId(name),
+		},
+		handler: handler,
+	}
+	c.subscriptions[name] = subscription
+	opened := c.opened
+	c.lock.Unlock()
+
+	if !opened {
+		return nil
+	}
+
+	err := c.openSubscription(ctx, correlationId, subscription)
+	if err != nil {
+		c.lock.Lock()
+		delete(c.subscriptions, name)
+		c.lock.Unlock()
+	}
+	return err
+}
+
+//	Gets declared durable subscriptions.
+//	Returns: descriptions of subscriptions sorted by names.
+func (c *KafkaEventBus) GetSubscriptions() []*KafkaDurableSubscription {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	result := make([]*KafkaDurableSubscription, 0, len(c.subscriptions))
+	for _, subscription := range c.subscriptions {
+		info := subscription.info
+		result = append(result, &info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+//	Pauses receiving events by a durable subscription. Paused subscriptions
+//	stay paused when the component is reopened.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- name string	a subscription name
+//	Returns: error or nil for success, NotFoundError SUBSCRIPTION_NOT_FOUND for unknown subscriptions.
+func (c *KafkaEventBus) PauseSubscription(ctx context.Context, correlationId string, name string) error {
+	return c.setSubscriptionPaused(ctx, correlationId, name, true)
+}
+
+//	Resumes receiving events by a paused durable subscription.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- name string	a subscription name
+//	Returns: error or nil for success, NotFoundError SUBSCRIPTION_NOT_FOUND for unknown subscriptions.
+func (c *KafkaEventBus) ResumeSubscription(ctx context.Context, correlationId string, name string) error {
+	return c.setSubscriptionPaused(ctx, correlationId, name, false)
+}
+
+func (c *KafkaEventBus) setSubscriptionPaused(ctx context.Context, correlationId string, name string, paused bool) error {
+	c.lock.Lock()
+	subscription, ok := c.subscriptions[name]
+	if !ok {
+		c.lock.Unlock()
+		return cerr.NewNotFoundError(correlationId, "SUBSCRIPTION_NOT_FOUND",
+			"Subscription "+name+" was not found").WithDetails("name", name)
+	}
+	subscription.info.Paused = paused
+	consumer, topic := subscription.consumer, subscription.info.Topic
+	c.lock.Unlock()
+
+	if consumer == nil || !consumer.IsOpen() {
+		return nil
+	}
+	if paused {
+		return consumer.Pause(ctx, topic)
+	}
+	return consumer.Resume(ctx, topic)
+}
+
+//	Deletes a durable subscription and its consumer group with committed offsets.
+//	The consumer group is deleted only when the component is opened.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- name string	a subscription name
+//	Returns: error or nil for success, NotFoundError SUBSCRIPTION_NOT_FOUND for unknown subscriptions.
+func (c *KafkaEventBus) DeleteSubscription(ctx context.Context, correlationId string, name string) error {
+	c.lock.Lock()
+	subscription, ok := c.subscriptions[name]
+	if !ok {
+		c.lock.Unlock()
+		return cerr.NewNotFoundError(correlationId, "SUBSCRIPTION_NOT_FOUND",
+			"Subscription "+name+" was not found").WithDetails("name", name)
+	}
+	delete(c.subscriptions, name)
+	opened := c.opened
+	c.lock.Unlock()
+
+	if subscription.consumer != nil {
+		err := subscription.consumer.Close(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+	if !opened {
+		return nil
+	}
+
+	// The group leaves asynchronously, so deleting an active group is retried by the caller
+	err := c.Consumer.Connection.DeleteGroup(subscription.info.GroupId)
+	if err != nil {
+		return cerr.NewConnectionError(correlationId, "DELETE_FAILED",
+			"Failed to delete consumer group of subscription "+name).
+			WithDetails("group_id", subscription.info.GroupId).
+			WithCause(err)
+	}
+
+	c.Logger.Info(ctx, correlationId, "Deleted subscription %s", name)
+	return nil
+}
+
+// Opens the consumer of a durable subscription on the connection of the bus
+func (c *KafkaEventBus) openSubscription(ctx context.Context, correlationId string,
+	subscription *kafkaDurableSubscription) error {
+
+	c.lock.Lock()
+	config := c.config.Override(cconf.NewConfigParamsFromTuples("group_id", subscription.info.GroupId))
+	info := subscription.info
+	c.lock.Unlock()
+
+	consumer := NewKafkaConsumer()
+	consumer.Configure(ctx, config)
+	consumer.Logger = c.Logger
+	consumer.Counters = c.Counters
+	consumer.Connection = c.Consumer.Connection
+
+	err := consumer.Open(ctx, correlationId)
+	if err != nil {
+		return err
+	}
+
+	err = consumer.Subscribe(ctx, correlationId, []string{info.Topic},
+		func(ctx context.Context, record *KafkaRecord) error {
+			if record.Headers[EventTypeHeader] != info.EventType {
+				return nil
+			}
+			return subscription.handler(ctx, record)
+		})
+	if err == nil && info.Paused {
+		err = consumer.Pause(ctx, info.Topic)
+	}
+	if err != nil {
+		_ = consumer.Close(ctx, correlationId)
+		return err
+	}
+
+	c.lock.Lock()
+	subscription.consumer = consumer
+	c.lock.Unlock()
+	return nil
+}
@@ -0,0 +1,290 @@
+package clients
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	ccount "github.com/pip-services3-gox/pip-services3-components-gox/count"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+)
+
+//	KafkaProducer is a lightweight component that publishes messages to any Kafka topic.
+//	It is used by services that publish to many topics and don't need
+//	the queue semantics of KafkaMessageQueue.
+//
+//	Configuration parameters:
+//
+//		- connection(s):
+//			- discovery_key:               (optional) a key to retrieve the connection from  IDiscovery
+//			- host:                        host name or IP address
+//			- port:                        port number
+//			- uri:                         resource URI or connection string with all parameters in it
+//		- credential(s):
+//			- store_key:                   (optional) a key to retrieve the credentials from  ICredentialStore
+//			- username:                    user name
+//			- password:                    user password
+//		- options:
+//			- see KafkaConnection
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:counters:*:*:1.0           (optional)  ICounters components to pass collected measurements
+//		- IKafkaMetrics                 (optional) Components to pass labeled measurements, like Prometheus adapters
+//		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
+//		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
+//		- *:connection:kafka:*:1.0      (optional) Shared connection to Kafka service
+//
+//	Example:
+//		producer := clients.NewKafkaProducer()
+//		producer.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"connection.host", "localhost",
+//			"connection.port", 9092,
+//		))
+//		_ = producer.Open(ctx, "123")
+//
+//		err := producer.Send(ctx, "123", "orders", "order1", map[string]string{"type": "created"}, []byte("{...}"))
+type KafkaProducer struct {
+	defaultConfig   *cconf.ConfigParams
+	config          *cconf.ConfigParams
+	references      cref.IReferences
+	lock            sync.Mutex
+	opened          bool
+	localConnection bool
+
+	// The dependency resolver.
+	DependencyResolver *cref.DependencyResolver
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The performance counters.
+	Counters *ccount.CompositeCounters
+	// The labeled metrics passed to IKafkaMetrics components.
+	Metrics *connect.CompositeKafkaMetrics
+	// The Kafka connection component.
+	Connection connect.IKafkaConnection
+
+	// Numbers of partitions by topics of keyed messages
+	partitionCounts map[string]int
+}
+
+//	NewKafkaProducer creates a new instance of the producer component.
+//	Returns: *KafkaProducer
+func NewKafkaProducer() *KafkaProducer {
+	c := &KafkaProducer{
+		defaultConfig: cconf.NewConfigParamsFromTuples(
+			"dependencies.connection", "*:connection:kafka:*:1.0",
+		),
+		Logger:          clog.NewCompositeLogger(),
+		Counters:        ccount.NewCompositeCounters(),
+		Metrics:         connect.NewCompositeKafkaMetrics(),
+		partitionCounts: make(map[string]int),
+	}
+	c.DependencyResolver = cref.NewDependencyResolver()
+	c.DependencyResolver.Configure(context.Background(), c.defaultConfig)
+	return c
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaProducer) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	config = config.SetDefaults(c.defaultConfig)
+	c.config = config
+
+	c.DependencyResolver.Configure(ctx, config)
+	c.Logger.Configure(ctx, config)
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaProducer) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.references = references
+	c.Logger.SetReferences(ctx, references)
+	c.Counters.SetReferences(ctx, references)
+	c.Metrics.SetReferences(ctx, references)
+
+	// Get connection
+	c.DependencyResolver.SetReferences(ctx, references)
+	result := c.DependencyResolver.GetOneOptional("connection")
+	if dep, ok := result.(connect.IKafkaConnection); ok {
+		c.Connection = dep
+	}
+	// Or create a local one
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	} else {
+		c.localConnection = false
+	}
+}
+
+//	Unsets (clears) previously set references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+func (c *KafkaProducer) UnsetReferences(ctx context.Context) {
+	c.Connection = nil
+}
+
+func (c *KafkaProducer) createConnection() *connect.KafkaConnection {
+	connection := connect.NewKafkaConnection()
+
+	if c.config != nil {
+		connection.Configure(context.Background(), c.config)
+	}
+
+	if c.references != nil {
+		connection.SetReferences(context.Background(), c.references)
+	}
+	return connection
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaProducer) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.opened
+}
+
+//	Opens the component.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaProducer) Open(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.opened {
+		return nil
+	}
+
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	}
+
+	if c.localConnection {
+		err := c.Connection.Open(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !c.Connection.IsOpen() {
+		return cerr.NewConnectionError(correlationId, "CONNECT_FAILED", "Kafka connection is not opened")
+	}
+
+	c.partitionCounts = make(map[string]int)
+	c.opened = true
+	return nil
+}
+
+//	Closes component and frees used resources.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaProducer) Close(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.opened {
+		return nil
+	}
+
+	if c.localConnection {
+		err := c.Connection.Close(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.opened = false
+	return nil
+}
+
+//	Sends a message to a topic.
+//	Keyed messages are partitioned by KeyPartition. Partition counts of topics are read
+//	once while the producer is open, so added partitions are used after it is reopened.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- topic string	a topic name
+//		- key string	(optional) a message key that selects the partition
+//		- headers map[string]string	(optional) message headers
+//		- payload []byte	a message payload
+//	Returns: error or nil for success.
+func (c *KafkaProducer) Send(ctx context.Context, correlationId string, topic string, key string,
+	headers map[string]string, payload []byte) error {
+
+	if !c.IsOpen() {
+		return cerr.NewInvalidStateError(correlationId, "NOT_OPENED", "The producer is not opened")
+	}
+
+	msg := &kafka.ProducerMessage{
+		Topic:     topic,
+		Value:     kafka.ByteEncoder(payload),
+		Timestamp: time.Now(),
+	}
+	if key != "" {
+		msg.Key = kafka.StringEncoder(key)
+
+		// The connection uses manual partitioning, so keyed messages are partitioned here
+		count, err := c.getPartitionCount(topic)
+		if err != nil {
+			return err
+		}
+		msg.Partition = connect.KeyPartition(key, count)
+	}
+	for name, value := range headers {
+		msg.Headers = append(msg.Headers, kafka.RecordHeader{
+			Key:   []byte(name),
+			Value: []byte(value),
+		})
+	}
+
+	err := c.Connection.Publish(ctx, topic, []*kafka.ProducerMessage{msg})
+	if err != nil {
+		c.Logger.Error(ctx, correlationId, err, "Failed to send message to %s", topic)
+		return err
+	}
+
+	c.Counters.IncrementOne(ctx, "producer."+topic+".sent_messages")
+	c.Metrics.Increment(connect.MetricProducerSentMessages, map[string]string{"topic": topic}, 1)
+	c.Logger.Trace(ctx, correlationId, "Sent message to %s", topic)
+	return nil
+}
+
+// Gets the number of partitions of a topic. Numbers are cached while the producer is open,
+// so keyed messages don't read topic metadata on every send.
+func (c *KafkaProducer) getPartitionCount(topic string) (int, error) {
+	c.lock.Lock()
+	count, ok := c.partitionCounts[topic]
+	c.lock.Unlock()
+	if ok {
+		return count, nil
+	}
+
+	partitions, err := c.Connection.ReadPartitions(topic)
+	if err != nil {
+		return 0, err
+	}
+
+	// Topics without partitions are not created yet
+	if len(partitions) > 0 {
+		c.lock.Lock()
+		c.partitionCounts[topic] = len(partitions)
+		c.lock.Unlock()
+	}
+	return len(partitions), nil
+}
@@ -0,0 +1,55 @@
+package clients
+
+import (
+	"context"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+)
+
+// KafkaRecord is a raw record received by KafkaConsumer with its partition information.
+type KafkaRecord struct {
+	// Topic of the record
+	Topic string
+	// Partition of the record
+	Partition int32
+	// Offset of the record in the partition
+	Offset int64
+	// Record key
+	Key []byte
+	// Record value
+	Value []byte
+	// Record headers
+	Headers map[string]string
+	// Time the record was produced
+	Timestamp time.Time
+	// Original consumer message
+	Message *kafka.ConsumerMessage
+
+	session kafka.ConsumerGroupSession
+}
+
+// KafkaRecordHandler processes records received by KafkaConsumer.
+// Records are committed after the handler returns nil when autocommit is on.
+type KafkaRecordHandler func(ctx context.Context, record *KafkaRecord) error
+
+func newKafkaRecord(session kafka.ConsumerGroupSession, msg *kafka.ConsumerMessage) *KafkaRecord {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, header := range msg.Headers {
+		if header != nil {
+			headers[string(header.Key)] = string(header.Value)
+		}
+	}
+
+	return &KafkaRecord{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   headers,
+		Timestamp: msg.Timestamp,
+		Message:   msg,
+		session:   session,
+	}
+}
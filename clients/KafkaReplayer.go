@@ -0,0 +1,458 @@
+package clients
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	ccount "github.com/pip-services3-gox/pip-services3-components-gox/count"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+)
+
+// KafkaReplayProgress reports progress of a replay
+type KafkaReplayProgress struct {
+	// Topic being replayed
+	Topic string
+	// Number of records in the replayed range
+	Total int64
+	// Number of records passed to the handler
+	Processed int64
+	// Next offsets to replay by partitions, used to resume interrupted replays
+	Positions map[int32]int64
+	// End offsets of the replayed range by partitions
+	Ends map[int32]int64
+	// Time the replay started
+	StartTime time.Time
+}
+
+//	Gets the completed part of the replay.
+//	Returns: a number from 0 to 1.
+func (c *KafkaReplayProgress) Completion() float64 {
+	if c.Total == 0 {
+		return 1
+	}
+	return float64(c.Processed) / float64(c.Total)
+}
+
+//	KafkaReplayer reads historical records of a topic between two timestamps or offsets
+//	and passes them to a handler at a limited rate for reprocessing.
+//	Records are read outside of consumer groups, so groups of running consumers are not affected.
+//	Partitions are replayed one by one in the order of offsets.
+//	A replay stops at the first handler error, its progress keeps positions to resume from.
+//
+//	Configuration parameters:
+//
+//		- topic:                         topic name to replay
+//		- connection(s):
+//			- discovery_key:               (optional) a key to retrieve the connection from  IDiscovery
+//			- host:                        host name or IP address
+//			- port:                        port number
+//			- uri:                         resource URI or connection string with all parameters in it
+//		- credential(s):
+//			- store_key:                   (optional) a key to retrieve the credentials from  ICredentialStore
+//			- username:                    user name
+//			- password:                    user password
+//		- options:
+//			- rate:                        (optional) maximum number of records per second, 0 for unlimited (default: 0)
+//			- batch_size:                  (optional) number of records read at once (default: 100)
+//			- progress_interval:           (optional) interval in milliseconds to log progress, 0 to disable (default: 10000)
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:counters:*:*:1.0           (optional)  ICounters components to pass collected measurements
+//		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
+//		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
+//		- *:connection:kafka:*:1.0      (optional) Shared connection to Kafka service
+//
+//	Example:
+//		replayer := clients.NewKafkaReplayer()
+//		replayer.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"topic", "orders",
+//			"options.rate", 500,
+//			"connection.host", "localhost",
+//			"connection.port", 9092,
+//		))
+//		_ = replayer.Open(ctx, "123")
+//
+//		progress, err := replayer.ReplayTime(ctx, "123", from, to,
+//			func(ctx context.Context, record *clients.KafkaRecord) error {
+//				return rebuildProjection(record)
+//			})
+type KafkaReplayer struct {
+	defaultConfig   *cconf.ConfigParams
+	config          *cconf.ConfigParams
+	references      cref.IReferences
+	lock            sync.Mutex
+	opened          bool
+	localConnection bool
+
+	// The dependency resolver.
+	DependencyResolver *cref.DependencyResolver
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The performance counters.
+	Counters *ccount.CompositeCounters
+	// The Kafka connection component.
+	Connection connect.IKafkaConnection
+
+	topic            string
+	rate             int
+	batchSize        int
+	progressInterval time.Duration
+	progress         *KafkaReplayProgress
+}
+
+//	NewKafkaReplayer creates a new instance of the replayer component.
+//	Returns: *KafkaReplayer
+func NewKafkaReplayer() *KafkaReplayer {
+	c := &KafkaReplayer{
+		defaultConfig: cconf.NewConfigParamsFromTuples(
+			"options.rate", 0,
+			"options.batch_size", 100,
+			"options.progress_interval", 10000,
+			"dependencies.connection", "*:connection:kafka:*:1.0",
+		),
+		Logger:           clog.NewCompositeLogger(),
+		Counters:         ccount.NewCompositeCounters(),
+		batchSize:        100,
+		progressInterval: 10 * time.Second,
+	}
+	c.DependencyResolver = cref.NewDependencyResolver()
+	c.DependencyResolver.Configure(context.Background(), c.defaultConfig)
+	return c
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaReplayer) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	config = config.SetDefaults(c.defaultConfig)
+	c.config = config
+
+	c.DependencyResolver.Configure(ctx, config)
+	c.Logger.Configure(ctx, config)
+
+	c.topic = config.GetAsStringWithDefault("topic", c.topic)
+	c.rate = config.GetAsIntegerWithDefault("options.rate", c.rate)
+	c.batchSize = config.GetAsIntegerWithDefault("options.batch_size", c.batchSize)
+	c.progressInterval = time.Duration(config.GetAsIntegerWithDefault("options.progress_interval",
+		int(c.progressInterval.Milliseconds()))) * time.Millisecond
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaReplayer) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.references = references
+	c.Logger.SetReferences(ctx, references)
+	c.Counters.SetReferences(ctx, references)
+
+	// Get connection
+	c.DependencyResolver.SetReferences(ctx, references)
+	result := c.DependencyResolver.GetOneOptional("connection")
+	if dep, ok := result.(connect.IKafkaConnection); ok {
+		c.Connection = dep
+	}
+	// Or create a local one
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	} else {
+		c.localConnection = false
+	}
+}
+
+//	Unsets (clears) previously set references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+func (c *KafkaReplayer) UnsetReferences(ctx context.Context) {
+	c.Connection = nil
+}
+
+func (c *KafkaReplayer) createConnection() *connect.KafkaConnection {
+	connection := connect.NewKafkaConnection()
+	connection.AllowOptions("rate", "batch_size", "progress_interval")
+
+	if c.config != nil {
+		connection.Configure(context.Background(), c.config)
+	}
+
+	if c.references != nil {
+		connection.SetReferences(context.Background(), c.references)
+	}
+	return connection
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaReplayer) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.opened
+}
+
+//	Opens the component.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaReplayer) Open(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.opened {
+		return nil
+	}
+
+	if c.topic == "" {
+		return cerr.NewConfigError(correlationId, "NO_TOPIC", "Topic is not set")
+	}
+
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	}
+
+	if c.localConnection {
+		err := c.Connection.Open(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !c.Connection.IsOpen() {
+		return cerr.NewConnectionError(correlationId, "CONNECT_FAILED", "Kafka connection is not opened")
+	}
+
+	c.opened = true
+	return nil
+}
+
+//	Closes component and frees used resources.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaReplayer) Close(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.opened {
+		return nil
+	}
+
+	if c.localConnection {
+		err := c.Connection.Close(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.opened = false
+	return nil
+}
+
+//	Gets progress of the current or the last replay.
+//	Returns: a copy of the progress or nil if nothing was replayed.
+func (c *KafkaReplayer) Progress() *KafkaReplayProgress {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.progress == nil {
+		return nil
+	}
+
+	progress := *c.progress
+	progress.Positions = make(map[int32]int64, len(c.progress.Positions))
+	for partition, offset := range c.progress.Positions {
+		progress.Positions[partition] = offset
+	}
+	return &progress
+}
+
+//	Replays records with timestamps from the start time inclusive to the end time exclusive.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- from time.Time	the start time
+//		- to time.Time	the end time or zero time to replay to the end of the topic
+//		- handler KafkaRecordHandler	a handler of replayed records
+//	Returns: the replay progress or error.
+func (c *KafkaReplayer) ReplayTime(ctx context.Context, correlationId string, from time.Time, to time.Time,
+	handler KafkaRecordHandler) (*KafkaReplayProgress, error) {
+
+	if !c.IsOpen() {
+		return nil, cerr.NewInvalidStateError(correlationId, "NOT_OPENED", "The replayer is not opened")
+	}
+
+	starts, err := c.readOffsets(correlationId, from.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+
+	endTime := int64(kafka.OffsetNewest)
+	if !to.IsZero() {
+		endTime = to.UnixMilli()
+	}
+	ends, err := c.readOffsets(correlationId, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ReplayOffsets(ctx, correlationId, starts, ends, handler)
+}
+
+//	Replays records between offsets of partitions.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- from map[int32]int64	start offsets by partitions inclusive
+//		- to map[int32]int64	(optional) end offsets by partitions exclusive (default: ends of partitions)
+//		- handler KafkaRecordHandler	a handler of replayed records
+//	Returns: the replay progress or error.
+func (c *KafkaReplayer) ReplayOffsets(ctx context.Context, correlationId string, from map[int32]int64, to map[int32]int64,
+	handler KafkaRecordHandler) (*KafkaReplayProgress, error) {
+
+	if !c.IsOpen() {
+		return nil, cerr.NewInvalidStateError(correlationId, "NOT_OPENED", "The replayer is not opened")
+	}
+
+	if to == nil {
+		ends, err := c.readOffsets(correlationId, kafka.OffsetNewest)
+		if err != nil {
+			return nil, err
+		}
+		to = ends
+	}
+
+	progress := &KafkaReplayProgress{
+		Topic:     c.topic,
+		Positions: make(map[int32]int64, len(from)),
+		Ends:      make(map[int32]int64, len(from)),
+		StartTime: time.Now(),
+	}
+	partitions := make([]int32, 0, len(from))
+	for partition, start := range from {
+		end, ok := to[partition]
+		if !ok || end <= start {
+			continue
+		}
+		partitions = append(partitions, partition)
+		progress.Positions[partition] = start
+		progress.Ends[partition] = end
+		progress.Total += end - start
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+	c.lock.Lock()
+	c.progress = progress
+	c.lock.Unlock()
+
+	c.Logger.Info(ctx, correlationId, "Started replay of %d records from %s", progress.Total, c.topic)
+
+	lastReport := time.Now()
+	for _, partition := range partitions {
+		for {
+			c.lock.Lock()
+			position := progress.Positions[partition]
+			c.lock.Unlock()
+
+			end := progress.Ends[partition]
+			if position >= end {
+				break
+			}
+
+			messages, err := c.Connection.ReadMessages(c.topic, partition, position, c.batchSize)
+			if err != nil {
+				return c.Progress(), err
+			}
+			if len(messages) == 0 {
+				// Records were removed by retention or compaction
+				break
+			}
+
+			for _, msg := range messages {
+				if msg.Offset >= end {
+					break
+				}
+
+				err = c.throttle(ctx, progress)
+				if err != nil {
+					return c.Progress(), err
+				}
+
+				err = handler(ctx, newKafkaRecord(nil, msg))
+				if err != nil {
+					c.Logger.Error(ctx, correlationId, err, "Failed to replay record %d:%d from %s", partition, msg.Offset, c.topic)
+					return c.Progress(), err
+				}
+
+				c.lock.Lock()
+				progress.Positions[partition] = msg.Offset + 1
+				progress.Processed++
+				c.lock.Unlock()
+				c.Counters.IncrementOne(ctx, "replay."+c.topic+".replayed_records")
+
+				if c.progressInterval > 0 && time.Since(lastReport) >= c.progressInterval {
+					lastReport = time.Now()
+					c.Logger.Info(ctx, correlationId, "Replayed %d of %d records from %s", progress.Processed, progress.Total, c.topic)
+				}
+			}
+
+			// Skip gaps left by compaction
+			last := messages[len(messages)-1].Offset
+			c.lock.Lock()
+			if progress.Positions[partition] <= last {
+				progress.Positions[partition] = last + 1
+			}
+			c.lock.Unlock()
+		}
+	}
+
+	c.Logger.Info(ctx, correlationId, "Completed replay of %d records from %s", progress.Processed, c.topic)
+	return c.Progress(), nil
+}
+
+// Waits to keep the configured rate or until the context is done
+func (c *KafkaReplayer) throttle(ctx context.Context, progress *KafkaReplayProgress) error {
+	if c.rate <= 0 {
+		return ctx.Err()
+	}
+
+	c.lock.Lock()
+	processed := progress.Processed
+	c.lock.Unlock()
+
+	due := progress.StartTime.Add(time.Duration(processed) * time.Second / time.Duration(c.rate))
+	wait := time.Until(due)
+	if wait <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reads offsets of the topic partitions by time when the connection supports it
+func (c *KafkaReplayer) readOffsets(correlationId string, time int64) (map[int32]int64, error) {
+	reader, ok := c.Connection.(connect.IKafkaOffsetReader)
+	if !ok {
+		return nil, connect.NewUnsupportedOperationError(correlationId, "ReadOffsets")
+	}
+	return reader.ReadOffsets(c.topic, nil, time)
+}
@@ -0,0 +1,329 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cdata "github.com/pip-services3-gox/pip-services3-commons-gox/data"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	ccount "github.com/pip-services3-gox/pip-services3-components-gox/count"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+)
+
+// Headers of request and reply records
+const (
+	// Correlation id of the call
+	RequestCorrelationIdHeader = "correlation_id"
+	// Unique id of the request copied to its reply
+	RequestIdHeader = "request_id"
+	// Topic the server sends the reply to
+	ReplyTopicHeader = "reply_topic"
+	// Error description of a failed request as JSON
+	ReplyErrorHeader = "error"
+	// Name of the command invoked by the request, see KafkaCommandableService
+	CommandHeader = "command"
+)
+
+//	KafkaRequestReplyClient sends requests to a topic served by KafkaRequestReplyServer
+//	and waits for replies on its reply topic. Every request carries a unique request id
+//	and the reply topic in its headers, so replies are matched to pending calls.
+//
+//	The reply topic is consumed by a unique consumer group of every client instance,
+//	so all instances can share the reply topic. The client should be opened before calls,
+//	so the reply consumer joins the topic before the first replies arrive.
+//
+//	Configuration parameters:
+//
+//		- topic:                         topic of requests
+//		- reply_topic:                   (optional) topic of replies (default: <topic>.replies)
+//		- group_id:                      (optional) consumer group id of replies (default: unique id of the client)
+//		- connection(s), credential(s): see KafkaProducer
+//		- options:
+//			- reply_timeout:        	(optional) number of milliseconds to wait for a reply (default: 30000)
+//			- see KafkaConnection
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:counters:*:*:1.0           (optional)  ICounters components to pass collected measurements
+//		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
+//		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
+//		- *:connection:kafka:*:1.0      (optional) Shared connection to Kafka service
+//
+//	Example:
+//		client := clients.NewKafkaRequestReplyClient()
+//		client.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"topic", "prices",
+//			"connection.host", "localhost",
+//			"connection.port", 9092,
+//		))
+//		_ = client.Open(ctx, "123")
+//
+//		reply, err := client.Call(ctx, "123", "product1", []byte(`{"product_id": "product1"}`))
+type KafkaRequestReplyClient struct {
+	lock         sync.Mutex
+	opened       bool
+	topic        string
+	replyTopic   string
+	replyTimeout time.Duration
+	pending      map[string]chan *KafkaRecord
+
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The performance counters.
+	Counters *ccount.CompositeCounters
+	// The consumer of replies.
+	Consumer *KafkaConsumer
+	// The producer of requests. It shares the connection of the consumer.
+	Producer *KafkaProducer
+}
+
+//	NewKafkaRequestReplyClient creates a new instance of the client component.
+//	Returns: *KafkaRequestReplyClient
+func NewKafkaRequestReplyClient() *KafkaRequestReplyClient {
+	c := &KafkaRequestReplyClient{
+		replyTimeout: 30000 * time.Millisecond,
+		pending:      make(map[string]chan *KafkaRecord),
+		Logger:       clog.NewCompositeLogger(),
+		Counters:     ccount.NewCompositeCounters(),
+		Consumer:     NewKafkaConsumer(),
+		Producer:     NewKafkaProducer(),
+	}
+	c.Consumer.groupId = "reply-" + cdata.IdGenerator.NextLong()
+	return c
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaRequestReplyClient) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	c.Logger.Configure(ctx, config)
+
+	c.lock.Lock()
+	c.topic = config.GetAsStringWithDefault("topic", c.topic)
+	c.replyTopic = config.GetAsStringWithDefault("reply_topic", c.replyTopic)
+	c.replyTimeout = time.Duration(config.GetAsIntegerWithDefault("options.reply_timeout",
+		int(c.replyTimeout.Milliseconds()))) * time.Millisecond
+	c.lock.Unlock()
+
+	// Client options are removed, so connections do not reject them
+	config = cconf.NewConfigParamsFromMaps(config.Value())
+	config.Remove("options.reply_timeout")
+	c.Producer.Configure(ctx, config)
+
+	// Only replies sent after the client started are awaited
+	config = config.Override(cconf.NewConfigParamsFromTuples(
+		"from_beginning", false,
+		"autocommit", true,
+	))
+	if _, ok := config.GetAsNullableString("group_id"); !ok {
+		config.SetAsObject("group_id", c.Consumer.groupId)
+	}
+	c.Consumer.Configure(ctx, config)
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaRequestReplyClient) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.Logger.SetReferences(ctx, references)
+	c.Counters.SetReferences(ctx, references)
+	c.Consumer.SetReferences(ctx, references)
+	c.Producer.SetReferences(ctx, references)
+	shareConnection(c.Consumer, c.Producer)
+}
+
+// Makes the producer use the connection of the consumer
+func shareConnection(consumer *KafkaConsumer, producer *KafkaProducer) {
+	if producer.localConnection {
+		producer.Connection = consumer.Connection
+		producer.localConnection = false
+	}
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaRequestReplyClient) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.opened
+}
+
+//	Opens the component and subscribes to the reply topic.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaRequestReplyClient) Open(ctx context.Context, correlationId string) error {
+	if c.IsOpen() {
+		return nil
+	}
+
+	if c.topic == "" {
+		return cerr.NewConfigError(correlationId, "NO_TOPIC", "Request topic is not set")
+	}
+	if c.replyTopic == "" {
+		c.replyTopic = c.topic + ".replies"
+	}
+
+	err := c.Consumer.Open(ctx, correlationId)
+	if err != nil {
+		return err
+	}
+
+	if c.Producer.Connection == nil {
+		c.Producer.Connection = c.Consumer.Connection
+	}
+	err = c.Producer.Open(ctx, correlationId)
+	if err != nil {
+		_ = c.Consumer.Close(ctx, correlationId)
+		return err
+	}
+
+	err = c.Consumer.Subscribe(ctx, correlationId, []string{c.replyTopic}, c.handleReply)
+	if err != nil {
+		_ = c.Producer.Close(ctx, correlationId)
+		_ = c.Consumer.Close(ctx, correlationId)
+		return err
+	}
+
+	c.lock.Lock()
+	c.opened = true
+	c.lock.Unlock()
+	return nil
+}
+
+//	Closes component and cancels pending calls.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaRequestReplyClient) Close(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	if !c.opened {
+		c.lock.Unlock()
+		return nil
+	}
+	c.opened = false
+	for requestId, reply := range c.pending {
+		close(reply)
+		delete(c.pending, requestId)
+	}
+	c.lock.Unlock()
+
+	err := c.Producer.Close(ctx, correlationId)
+	if err != nil {
+		return err
+	}
+	return c.Consumer.Close(ctx, correlationId)
+}
+
+//	Sends a request and waits for its reply.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- key string	(optional) a request key that selects the partition
+//		- payload []byte	a request payload
+//	Returns: the reply payload or error returned by the server handler,
+//	InvocationError REPLY_TIMEOUT when the reply didn't arrive in time.
+func (c *KafkaRequestReplyClient) Call(ctx context.Context, correlationId string, key string, payload []byte) ([]byte, error) {
+	return c.CallWithHeaders(ctx, correlationId, key, nil, payload)
+}
+
+//	Sends a request with additional headers and waits for its reply.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- key string	(optional) a request key that selects the partition
+//		- headers map[string]string	(optional) additional request headers
+//		- payload []byte	a request payload
+//	Returns: the reply payload or error returned by the server handler,
+//	InvocationError REPLY_TIMEOUT when the reply didn't arrive in time.
+func (c *KafkaRequestReplyClient) CallWithHeaders(ctx context.Context, correlationId string, key string,
+	headers map[string]string, payload []byte) ([]byte, error) {
+
+	requestId := cdata.IdGenerator.NextLong()
+	reply := make(chan *KafkaRecord, 1)
+
+	c.lock.Lock()
+	if !c.opened {
+		c.lock.Unlock()
+		return nil, cerr.NewInvalidStateError(correlationId, "NOT_OPENED", "The client is not opened")
+	}
+	c.pending[requestId] = reply
+	topic, replyTopic, replyTimeout := c.topic, c.replyTopic, c.replyTimeout
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.pending, requestId)
+		c.lock.Unlock()
+	}()
+
+	requestHeaders := map[string]string{
+		RequestCorrelationIdHeader: correlationId,
+		RequestIdHeader:            requestId,
+		ReplyTopicHeader:           replyTopic,
+	}
+	for name, value := range headers {
+		if _, ok := requestHeaders[name]; !ok {
+			requestHeaders[name] = value
+		}
+	}
+	err := c.Producer.Send(ctx, correlationId, topic, key, requestHeaders, payload)
+	if err != nil {
+		return nil, err
+	}
+	c.Counters.IncrementOne(ctx, "request_reply."+topic+".calls")
+
+	timer := time.NewTimer(replyTimeout)
+	defer timer.Stop()
+
+	select {
+	case record, ok := <-reply:
+		if !ok {
+			return nil, cerr.NewInvalidStateError(correlationId, "NOT_OPENED", "The client was closed")
+		}
+		if description, ok := record.Headers[ReplyErrorHeader]; ok {
+			return nil, decodeReplyError(correlationId, description)
+		}
+		return record.Value, nil
+	case <-timer.C:
+		c.Counters.IncrementOne(ctx, "request_reply."+topic+".timeouts")
+		return nil, cerr.NewInvocationError(correlationId, "REPLY_TIMEOUT", "Reply to request was not received in time").
+			WithDetails("topic", topic).
+			WithDetails("timeout", replyTimeout.Milliseconds())
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Passes a reply to its pending call. Replies of other clients and timed out calls are ignored.
+func (c *KafkaRequestReplyClient) handleReply(ctx context.Context, record *KafkaRecord) error {
+	requestId := record.Headers[RequestIdHeader]
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if reply, ok := c.pending[requestId]; ok {
+		reply <- record
+		delete(c.pending, requestId)
+	}
+	return nil
+}
+
+// Restores an error sent by the server
+func decodeReplyError(correlationId string, value string) error {
+	description := &cerr.ErrorDescription{}
+	err := json.Unmarshal([]byte(value), description)
+	if err != nil {
+		return cerr.NewUnknownError(correlationId, "REQUEST_FAILED", value)
+	}
+	return cerr.ApplicationErrorFactory.Create(description)
+}
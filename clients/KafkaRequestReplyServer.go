@@ -0,0 +1,237 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	ccount "github.com/pip-services3-gox/pip-services3-components-gox/count"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+)
+
+// KafkaRequestHandler processes a request received by KafkaRequestReplyServer
+// and returns the reply payload. Returned errors are sent back to the client.
+type KafkaRequestHandler func(ctx context.Context, request *KafkaRecord) ([]byte, error)
+
+//	KafkaRequestReplyServer consumes requests sent by KafkaRequestReplyClient, invokes the handler
+//	and sends replies to the reply topics of the requests. Handler errors are sent to clients
+//	as error descriptions and restored there as application errors.
+//	Requests without the reply topic header are processed without replies.
+//
+//	Configuration parameters:
+//
+//		- topic:                         topic of requests
+//		- group_id:                      (optional) consumer group id of server instances (default: default)
+//		- from_beginning:                (optional) processes requests from the beginning when the group has no committed offsets (default: false)
+//		- connection(s), credential(s), options: see KafkaConsumer
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:counters:*:*:1.0           (optional)  ICounters components to pass collected measurements
+//		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
+//		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
+//		- *:connection:kafka:*:1.0      (optional) Shared connection to Kafka service
+//
+//	Example:
+//		server := clients.NewKafkaRequestReplyServer()
+//		server.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"topic", "prices",
+//			"group_id", "prices",
+//			"connection.host", "localhost",
+//			"connection.port", 9092,
+//		))
+//		server.SetHandler(func(ctx context.Context, request *clients.KafkaRecord) ([]byte, error) {
+//			return []byte(`{"price": 10}`), nil
+//		})
+//		_ = server.Open(ctx, "123")
+type KafkaRequestReplyServer struct {
+	lock    sync.Mutex
+	opened  bool
+	topic   string
+	handler KafkaRequestHandler
+
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The performance counters.
+	Counters *ccount.CompositeCounters
+	// The consumer of requests.
+	Consumer *KafkaConsumer
+	// The producer of replies. It shares the connection of the consumer.
+	Producer *KafkaProducer
+}
+
+//	NewKafkaRequestReplyServer creates a new instance of the server component.
+//	Returns: *KafkaRequestReplyServer
+func NewKafkaRequestReplyServer() *KafkaRequestReplyServer {
+	return &KafkaRequestReplyServer{
+		Logger:   clog.NewCompositeLogger(),
+		Counters: ccount.NewCompositeCounters(),
+		Consumer: NewKafkaConsumer(),
+		Producer: NewKafkaProducer(),
+	}
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaRequestReplyServer) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	c.Logger.Configure(ctx, config)
+
+	c.lock.Lock()
+	c.topic = config.GetAsStringWithDefault("topic", c.topic)
+	c.lock.Unlock()
+
+	c.Consumer.Configure(ctx, config)
+	c.Producer.Configure(ctx, config)
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaRequestReplyServer) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.Logger.SetReferences(ctx, references)
+	c.Counters.SetReferences(ctx, references)
+	c.Consumer.SetReferences(ctx, references)
+	c.Producer.SetReferences(ctx, references)
+	shareConnection(c.Consumer, c.Producer)
+}
+
+//	Sets the handler of requests. It must be set before the server is opened.
+//	Parameters:
+//		- handler KafkaRequestHandler	a request handler
+func (c *KafkaRequestReplyServer) SetHandler(handler KafkaRequestHandler) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.handler = handler
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaRequestReplyServer) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.opened
+}
+
+//	Opens the component and starts serving requests.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaRequestReplyServer) Open(ctx context.Context, correlationId string) error {
+	if c.IsOpen() {
+		return nil
+	}
+
+	c.lock.Lock()
+	topic, handler := c.topic, c.handler
+	c.lock.Unlock()
+
+	if topic == "" {
+		return cerr.NewConfigError(correlationId, "NO_TOPIC", "Request topic is not set")
+	}
+	if handler == nil {
+		return cerr.NewConfigError(correlationId, "NO_HANDLER", "Request handler is not set")
+	}
+
+	err := c.Consumer.Open(ctx, correlationId)
+	if err != nil {
+		return err
+	}
+
+	if c.Producer.Connection == nil {
+		c.Producer.Connection = c.Consumer.Connection
+	}
+	err = c.Producer.Open(ctx, correlationId)
+	if err != nil {
+		_ = c.Consumer.Close(ctx, correlationId)
+		return err
+	}
+
+	err = c.Consumer.Subscribe(ctx, correlationId, []string{topic}, c.handleRequest)
+	if err != nil {
+		_ = c.Producer.Close(ctx, correlationId)
+		_ = c.Consumer.Close(ctx, correlationId)
+		return err
+	}
+
+	c.lock.Lock()
+	c.opened = true
+	c.lock.Unlock()
+
+	c.Logger.Info(ctx, correlationId, "Started serving requests from %s", topic)
+	return nil
+}
+
+//	Closes component and stops serving requests.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaRequestReplyServer) Close(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	if !c.opened {
+		c.lock.Unlock()
+		return nil
+	}
+	c.opened = false
+	c.lock.Unlock()
+
+	err := c.Consumer.Close(ctx, correlationId)
+	if err != nil {
+		return err
+	}
+	return c.Producer.Close(ctx, correlationId)
+}
+
+// Invokes the handler and sends the reply
+func (c *KafkaRequestReplyServer) handleRequest(ctx context.Context, request *KafkaRecord) error {
+	c.lock.Lock()
+	handler := c.handler
+	c.lock.Unlock()
+
+	correlationId := request.Headers[RequestCorrelationIdHeader]
+	reply, err := c.callHandler(ctx, handler, request)
+	c.Counters.IncrementOne(ctx, "request_reply."+request.Topic+".requests")
+
+	replyTopic := request.Headers[ReplyTopicHeader]
+	if replyTopic == "" {
+		if err != nil {
+			c.Logger.Error(ctx, correlationId, err, "Failed to process request %d:%d from %s",
+				request.Partition, request.Offset, request.Topic)
+		}
+		return nil
+	}
+
+	headers := map[string]string{
+		RequestCorrelationIdHeader: correlationId,
+		RequestIdHeader:            request.Headers[RequestIdHeader],
+	}
+	if err != nil {
+		c.Counters.IncrementOne(ctx, "request_reply."+request.Topic+".failed_requests")
+		description, _ := json.Marshal(cerr.ErrorDescriptionFactory.Create(err))
+		headers[ReplyErrorHeader] = string(description)
+		reply = nil
+	}
+
+	return c.Producer.Send(ctx, correlationId, replyTopic, "", headers, reply)
+}
+
+func (c *KafkaRequestReplyServer) callHandler(ctx context.Context, handler KafkaRequestHandler,
+	request *KafkaRecord) (reply []byte, err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = cerr.NewUnknownError(request.Headers[RequestCorrelationIdHeader], "PROCESSING_FAILED",
+				"Request handler panicked").WithDetails("panic", r)
+		}
+	}()
+
+	return handler(ctx, request)
+}
@@ -0,0 +1,253 @@
+package clients
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	ccount "github.com/pip-services3-gox/pip-services3-components-gox/count"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+)
+
+// KafkaRecordTransform converts a source record before it is produced to the target topic.
+// It returns nil to skip the record.
+type KafkaRecordTransform func(ctx context.Context, record *KafkaRecord) (*KafkaRecord, error)
+
+//	KafkaTopicBridge is a lightweight in-process mirror that consumes records
+//	from a topic of the source cluster and produces them to a topic of the target cluster.
+//	Source offsets are committed only after records are produced to the target,
+//	so the bridge resumes from the last checkpoint after restart without losing records.
+//	Failed sends are retried until they succeed or the bridge is closed.
+//
+//	Configuration parameters:
+//
+//		- source:
+//			- topic:                       source topic name
+//			- group_id:                    (optional) consumer group id that stores checkpoints (default: default)
+//			- from_beginning:              (optional) mirrors the topic from the beginning when there are no checkpoints (default: true)
+//			- connection(s), credential(s), options: see KafkaConsumer
+//		- target:
+//			- topic:                       (optional) target topic name (default: source topic)
+//			- connection(s), credential(s), options: see KafkaProducer
+//		- options:
+//			- retry_timeout:               (optional) timeout in milliseconds between retries of failed sends (default: 1000)
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:counters:*:*:1.0           (optional)  ICounters components to pass collected measurements
+//		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
+//		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
+//
+//	Example:
+//		bridge := clients.NewKafkaTopicBridge()
+//		bridge.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"source.topic", "orders",
+//			"source.group_id", "migration",
+//			"source.connection.uri", "old-cluster:9092",
+//			"target.topic", "orders",
+//			"target.connection.uri", "new-cluster:9092",
+//		))
+//		bridge.SetTransform(func(ctx context.Context, record *clients.KafkaRecord) (*clients.KafkaRecord, error) {
+//			record.Headers["migrated"] = "true"
+//			return record, nil
+//		})
+//		_ = bridge.Open(ctx, "123")
+type KafkaTopicBridge struct {
+	lock         sync.Mutex
+	opened       bool
+	cancel       context.CancelFunc
+	sourceTopic  string
+	targetTopic  string
+	retryTimeout time.Duration
+	transform    KafkaRecordTransform
+
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The performance counters.
+	Counters *ccount.CompositeCounters
+	// The consumer of the source cluster.
+	Consumer *KafkaConsumer
+	// The producer of the target cluster.
+	Producer *KafkaProducer
+}
+
+//	NewKafkaTopicBridge creates a new instance of the bridge component.
+//	Returns: *KafkaTopicBridge
+func NewKafkaTopicBridge() *KafkaTopicBridge {
+	c := &KafkaTopicBridge{
+		retryTimeout: 1000 * time.Millisecond,
+		Logger:       clog.NewCompositeLogger(),
+		Counters:     ccount.NewCompositeCounters(),
+		Consumer:     NewKafkaConsumer(),
+		Producer:     NewKafkaProducer(),
+	}
+	c.Consumer.fromBeginning = true
+	c.Consumer.autoCommit = false
+	return c
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaTopicBridge) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	c.Logger.Configure(ctx, config)
+
+	source := config.GetSection("source")
+	c.sourceTopic = source.GetAsStringWithDefault("topic", c.sourceTopic)
+	// Offsets are checkpointed by the bridge after records are mirrored
+	source = source.SetDefaults(cconf.NewConfigParamsFromTuples("from_beginning", true))
+	source = source.Override(cconf.NewConfigParamsFromTuples("autocommit", false))
+	c.Consumer.Configure(ctx, source)
+
+	target := config.GetSection("target")
+	c.targetTopic = target.GetAsStringWithDefault("topic", c.targetTopic)
+	c.Producer.Configure(ctx, target)
+
+	c.retryTimeout = time.Duration(config.GetAsIntegerWithDefault("options.retry_timeout",
+		int(c.retryTimeout.Milliseconds()))) * time.Millisecond
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaTopicBridge) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.Logger.SetReferences(ctx, references)
+	c.Counters.SetReferences(ctx, references)
+	c.Consumer.SetReferences(ctx, references)
+	c.Producer.SetReferences(ctx, references)
+}
+
+//	Sets a transformation of mirrored records.
+//	Parameters:
+//		- transform KafkaRecordTransform	a record transformation or nil to mirror records as is
+func (c *KafkaTopicBridge) SetTransform(transform KafkaRecordTransform) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.transform = transform
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaTopicBridge) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.opened
+}
+
+//	Opens the component and starts mirroring.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaTopicBridge) Open(ctx context.Context, correlationId string) error {
+	if c.IsOpen() {
+		return nil
+	}
+
+	if c.sourceTopic == "" {
+		return cerr.NewConfigError(correlationId, "NO_TOPIC", "Source topic is not set")
+	}
+	if c.targetTopic == "" {
+		c.targetTopic = c.sourceTopic
+	}
+
+	err := c.Producer.Open(ctx, correlationId)
+	if err != nil {
+		return err
+	}
+
+	err = c.Consumer.Open(ctx, correlationId)
+	if err != nil {
+		_ = c.Producer.Close(ctx, correlationId)
+		return err
+	}
+
+	mirrorCtx, cancel := context.WithCancel(context.Background())
+	c.lock.Lock()
+	c.cancel = cancel
+	c.opened = true
+	c.lock.Unlock()
+
+	err = c.Consumer.Subscribe(ctx, correlationId, []string{c.sourceTopic},
+		func(ctx context.Context, record *KafkaRecord) error {
+			return c.mirror(mirrorCtx, ctx, record)
+		})
+	if err != nil {
+		_ = c.Close(ctx, correlationId)
+		return err
+	}
+
+	c.Logger.Info(ctx, correlationId, "Started mirroring topic %s to %s", c.sourceTopic, c.targetTopic)
+	return nil
+}
+
+//	Closes component and stops mirroring.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaTopicBridge) Close(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	if !c.opened {
+		c.lock.Unlock()
+		return nil
+	}
+	c.opened = false
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
+	c.lock.Unlock()
+
+	err := c.Consumer.Close(ctx, correlationId)
+	if err != nil {
+		return err
+	}
+	return c.Producer.Close(ctx, correlationId)
+}
+
+// Produces a record to the target topic and checkpoints its source offset
+func (c *KafkaTopicBridge) mirror(mirrorCtx context.Context, ctx context.Context, record *KafkaRecord) error {
+	c.lock.Lock()
+	transform := c.transform
+	c.lock.Unlock()
+
+	target := record
+	if transform != nil {
+		var err error
+		target, err = transform(ctx, record)
+		if err != nil {
+			return err
+		}
+	}
+
+	if target != nil {
+		for {
+			err := c.Producer.Send(ctx, "", c.targetTopic, string(target.Key), target.Headers, target.Value)
+			if err == nil {
+				break
+			}
+
+			c.Logger.Error(ctx, "", err, "Failed to mirror record %d:%d from %s", record.Partition, record.Offset, record.Topic)
+			c.Counters.IncrementOne(ctx, "bridge."+c.sourceTopic+".failed_records")
+
+			// Retry until the record is mirrored to keep the order of records
+			select {
+			case <-time.After(c.retryTimeout):
+			case <-ctx.Done():
+				return err
+			case <-mirrorCtx.Done():
+				return err
+			}
+		}
+		c.Counters.IncrementOne(ctx, "bridge."+c.sourceTopic+".mirrored_records")
+	}
+
+	return c.Consumer.Commit(ctx, record)
+}
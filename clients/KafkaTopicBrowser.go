@@ -0,0 +1,390 @@
+package clients
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	ccount "github.com/pip-services3-gox/pip-services3-components-gox/count"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+// KafkaTopicPage is a page of messages read by KafkaTopicBrowser
+type KafkaTopicPage struct {
+	// Topic being browsed
+	Topic string
+	// Decoded messages of the page
+	Messages []*cqueues.MessageEnvelope
+	// Offsets to read the next page from by partitions, empty when the range is read to the end
+	Next map[int32]int64
+	// End offsets of the browsed range by partitions
+	Ends map[int32]int64
+}
+
+//	Checks if the browsed range has more messages after this page.
+//	Returns: true if the next page can be read.
+func (c *KafkaTopicPage) HasMore() bool {
+	return len(c.Next) > 0
+}
+
+//	KafkaTopicBrowser pages through messages of a topic by offset or time ranges
+//	and returns them decoded as message envelopes.
+//	Messages are read outside of consumer groups and no offsets are committed,
+//	so the browser is a safe read-only tool to inspect topics of running systems.
+//	Partitions are browsed one by one in the order of offsets.
+//
+//	Configuration parameters:
+//
+//		- topic:                         topic name to browse
+//		- connection(s):
+//			- discovery_key:               (optional) a key to retrieve the connection from  IDiscovery
+//			- host:                        host name or IP address
+//			- port:                        port number
+//			- uri:                         resource URI or connection string with all parameters in it
+//		- credential(s):
+//			- store_key:                   (optional) a key to retrieve the credentials from  ICredentialStore
+//			- username:                    user name
+//			- password:                    user password
+//		- options:
+//			- page_size:                   (optional) maximum number of messages in a page (default: 100)
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:counters:*:*:1.0           (optional)  ICounters components to pass collected measurements
+//		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
+//		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
+//		- *:connection:kafka:*:1.0      (optional) Shared connection to Kafka service
+//
+//	Example:
+//		browser := clients.NewKafkaTopicBrowser()
+//		browser.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"topic", "orders",
+//			"options.page_size", 20,
+//			"connection.host", "localhost",
+//			"connection.port", 9092,
+//		))
+//		_ = browser.Open(ctx, "123")
+//
+//		page, err := browser.BrowseTime(ctx, "123", from, to)
+//		for err == nil {
+//			printMessages(page.Messages)
+//			if !page.HasMore() {
+//				break
+//			}
+//			page, err = browser.BrowseOffsets(ctx, "123", page.Next, page.Ends)
+//		}
+type KafkaTopicBrowser struct {
+	defaultConfig   *cconf.ConfigParams
+	config          *cconf.ConfigParams
+	references      cref.IReferences
+	lock            sync.Mutex
+	opened          bool
+	localConnection bool
+
+	// The dependency resolver.
+	DependencyResolver *cref.DependencyResolver
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The performance counters.
+	Counters *ccount.CompositeCounters
+	// The Kafka connection component.
+	Connection connect.IKafkaConnection
+
+	topic    string
+	pageSize int
+}
+
+//	NewKafkaTopicBrowser creates a new instance of the topic browser component.
+//	Returns: *KafkaTopicBrowser
+func NewKafkaTopicBrowser() *KafkaTopicBrowser {
+	c := &KafkaTopicBrowser{
+		defaultConfig: cconf.NewConfigParamsFromTuples(
+			"options.page_size", 100,
+			"dependencies.connection", "*:connection:kafka:*:1.0",
+		),
+		Logger:   clog.NewCompositeLogger(),
+		Counters: ccount.NewCompositeCounters(),
+		pageSize: 100,
+	}
+	c.DependencyResolver = cref.NewDependencyResolver()
+	c.DependencyResolver.Configure(context.Background(), c.defaultConfig)
+	return c
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaTopicBrowser) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	config = config.SetDefaults(c.defaultConfig)
+	c.config = config
+
+	c.DependencyResolver.Configure(ctx, config)
+	c.Logger.Configure(ctx, config)
+
+	c.topic = config.GetAsStringWithDefault("topic", c.topic)
+	c.pageSize = config.GetAsIntegerWithDefault("options.page_size", c.pageSize)
+	if c.pageSize <= 0 {
+		c.pageSize = 100
+	}
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaTopicBrowser) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.references = references
+	c.Logger.SetReferences(ctx, references)
+	c.Counters.SetReferences(ctx, references)
+
+	// Get connection
+	c.DependencyResolver.SetReferences(ctx, references)
+	result := c.DependencyResolver.GetOneOptional("connection")
+	if dep, ok := result.(connect.IKafkaConnection); ok {
+		c.Connection = dep
+	}
+	// Or create a local one
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	} else {
+		c.localConnection = false
+	}
+}
+
+//	Unsets (clears) previously set references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+func (c *KafkaTopicBrowser) UnsetReferences(ctx context.Context) {
+	c.Connection = nil
+}
+
+func (c *KafkaTopicBrowser) createConnection() *connect.KafkaConnection {
+	connection := connect.NewKafkaConnection()
+	connection.AllowOptions("page_size")
+
+	if c.config != nil {
+		connection.Configure(context.Background(), c.config)
+	}
+
+	if c.references != nil {
+		connection.SetReferences(context.Background(), c.references)
+	}
+	return connection
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaTopicBrowser) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.opened
+}
+
+//	Opens the component.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaTopicBrowser) Open(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.opened {
+		return nil
+	}
+
+	if c.topic == "" {
+		return cerr.NewConfigError(correlationId, "NO_TOPIC", "Topic is not set")
+	}
+
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	}
+
+	if c.localConnection {
+		err := c.Connection.Open(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !c.Connection.IsOpen() {
+		return cerr.NewConnectionError(correlationId, "CONNECT_FAILED", "Kafka connection is not opened")
+	}
+
+	c.opened = true
+	return nil
+}
+
+//	Closes component and frees used resources.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaTopicBrowser) Close(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.opened {
+		return nil
+	}
+
+	if c.localConnection {
+		err := c.Connection.Close(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.opened = false
+	return nil
+}
+
+//	Reads the first page of messages with timestamps from the start time inclusive to the end time exclusive.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- from time.Time	the start time
+//		- to time.Time	the end time or zero time to browse to the end of the topic
+//	Returns: the page of messages or error.
+func (c *KafkaTopicBrowser) BrowseTime(ctx context.Context, correlationId string, from time.Time,
+	to time.Time) (*KafkaTopicPage, error) {
+
+	if !c.IsOpen() {
+		return nil, cerr.NewInvalidStateError(correlationId, "NOT_OPENED", "The browser is not opened")
+	}
+
+	starts, err := c.readOffsets(correlationId, from.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+
+	endTime := int64(kafka.OffsetNewest)
+	if !to.IsZero() {
+		endTime = to.UnixMilli()
+	}
+	ends, err := c.readOffsets(correlationId, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.BrowseOffsets(ctx, correlationId, starts, ends)
+}
+
+//	Reads a page of messages between offsets of partitions.
+//	Pass Next and Ends of the returned page to read the following page.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- from map[int32]int64	start offsets by partitions inclusive
+//		- to map[int32]int64	(optional) end offsets by partitions exclusive (default: ends of partitions)
+//	Returns: the page of messages or error.
+func (c *KafkaTopicBrowser) BrowseOffsets(ctx context.Context, correlationId string, from map[int32]int64,
+	to map[int32]int64) (*KafkaTopicPage, error) {
+
+	if !c.IsOpen() {
+		return nil, cerr.NewInvalidStateError(correlationId, "NOT_OPENED", "The browser is not opened")
+	}
+
+	if to == nil {
+		ends, err := c.readOffsets(correlationId, kafka.OffsetNewest)
+		if err != nil {
+			return nil, err
+		}
+		to = ends
+	}
+
+	page := &KafkaTopicPage{
+		Topic:    c.topic,
+		Messages: make([]*cqueues.MessageEnvelope, 0),
+		Next:     make(map[int32]int64, len(from)),
+		Ends:     make(map[int32]int64, len(from)),
+	}
+	partitions := make([]int32, 0, len(from))
+	for partition, start := range from {
+		end, ok := to[partition]
+		if !ok || end <= start {
+			continue
+		}
+		partitions = append(partitions, partition)
+		page.Next[partition] = start
+		page.Ends[partition] = end
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+	for _, partition := range partitions {
+		for len(page.Messages) < c.pageSize {
+			position := page.Next[partition]
+			end := page.Ends[partition]
+			if position >= end {
+				break
+			}
+
+			messages, err := c.Connection.ReadMessages(c.topic, partition, position, c.pageSize-len(page.Messages))
+			if err != nil {
+				return nil, err
+			}
+			if len(messages) == 0 {
+				// Records were removed by retention or compaction
+				page.Next[partition] = end
+				break
+			}
+
+			for _, msg := range messages {
+				if msg.Offset >= end || len(page.Messages) >= c.pageSize {
+					break
+				}
+				page.Messages = append(page.Messages, toMessageEnvelope(msg))
+				page.Next[partition] = msg.Offset + 1
+			}
+
+			// Skip gaps left by compaction
+			last := messages[len(messages)-1].Offset
+			if len(page.Messages) < c.pageSize && page.Next[partition] <= last {
+				page.Next[partition] = last + 1
+			}
+		}
+
+		if page.Next[partition] >= page.Ends[partition] {
+			delete(page.Next, partition)
+		}
+	}
+
+	c.Counters.IncrementOne(ctx, "browse."+c.topic+".pages")
+	c.Logger.Debug(ctx, correlationId, "Browsed %d messages from %s", len(page.Messages), c.topic)
+	return page, nil
+}
+
+// Decodes a record into a message envelope the same way KafkaMessageQueue does
+func toMessageEnvelope(msg *kafka.ConsumerMessage) *cqueues.MessageEnvelope {
+	record := newKafkaRecord(nil, msg)
+
+	message := cqueues.NewMessageEnvelope(record.Headers["correlation_id"], record.Headers["message_type"], nil)
+	message.MessageId = string(msg.Key)
+	if !msg.Timestamp.IsZero() {
+		message.SentTime = msg.Timestamp
+	}
+	message.Message = msg.Value
+	message.SetReference(&connect.KafkaMessage{Message: msg})
+	return message
+}
+
+// Reads offsets of the topic partitions by time when the connection supports it
+func (c *KafkaTopicBrowser) readOffsets(correlationId string, time int64) (map[int32]int64, error) {
+	reader, ok := c.Connection.(connect.IKafkaOffsetReader)
+	if !ok {
+		return nil, connect.NewUnsupportedOperationError(correlationId, "ReadOffsets")
+	}
+	return reader.ReadOffsets(c.topic, nil, time)
+}
@@ -0,0 +1,129 @@
+package connect
+
+import (
+	"context"
+
+	kafka "github.com/Shopify/sarama"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+)
+
+// IKafkaConnection defines the Kafka connection operations used by message queues.
+// It allows to share alternative connection implementations
+// or to inject fake connections in tests.
+//
+// Optional operations are defined by IKafkaOffsetReader, IKafkaGroupDescriber,
+// IKafkaRecordDeleter, IKafkaOffsetTransfer and IKafkaPartitionPauser.
+// Components check them with type assertions and fail with UnsupportedError
+// when a connection doesn't implement them.
+//
+//	See KafkaConnection
+type IKafkaConnection interface {
+	// Checks if the connection is opened.
+	IsOpen() bool
+
+	// Opens the connection.
+	Open(ctx context.Context, correlationId string) error
+
+	// Closes the connection.
+	Close(ctx context.Context, correlationId string) error
+
+	// Reads a list of registered queue names.
+	ReadQueueNames() ([]string, error)
+
+	// Reads a sorted page of queue names selected by a filter.
+	ReadQueueNamesByFilter(filter *KafkaTopicFilter, skip int, take int) ([]string, error)
+
+	// Reads partition indexes of a topic.
+	ReadPartitions(name string) ([]int32, error)
+
+	// Creates a message queue.
+	CreateQueue(name string) error
+
+	// Deletes a message queue.
+	DeleteQueue(name string) error
+
+	// Increases the number of partitions of a topic.
+	CreatePartitions(name string, count int) error
+
+	// Reads differences between the live topic and its declared configuration.
+	ReadQueueDrift(name string) ([]string, error)
+
+	// Alters a topic to match its declared configuration.
+	AlignQueue(name string) error
+
+	// Reads lags of a consumer group on a topic.
+	ReadLags(topic string, groupId string, partitions []int32) (map[int32]int64, error)
+
+	// Reads messages of a topic without committing them.
+	PeekMessages(topic string, groupId string, partitions []int32, maxCount int) ([]*kafka.ConsumerMessage, error)
+
+	// Reads earliest and latest offsets of topic partitions.
+	ListOffsets(topic string) (map[int32]*KafkaPartitionOffsets, error)
+
+	// Deletes a consumer group with its committed offsets.
+	DeleteGroup(groupId string) error
+
+	// Reads messages of a topic partition starting from an offset.
+	ReadMessages(topic string, partition int32, offset int64, maxCount int) ([]*kafka.ConsumerMessage, error)
+
+	// Publishes messages to a topic.
+	Publish(ctx context.Context, topic string, messages []*kafka.ProducerMessage) error
+
+	// Subscribes a listener to a topic.
+	Subscribe(ctx context.Context, topic string, groupId string, config *kafka.Config, listener IKafkaMessageListener) error
+
+	// Unsubscribes a listener from a topic.
+	Unsubscribe(ctx context.Context, topic string, groupId string, listener IKafkaMessageListener) error
+
+	// Pauses consumption of a subscription.
+	Pause(topic string, groupId string, listener IKafkaMessageListener) error
+
+	// Resumes consumption of a paused subscription.
+	Resume(topic string, groupId string, listener IKafkaMessageListener) error
+}
+
+// IKafkaOffsetReader is implemented by connections that read offsets by time.
+type IKafkaOffsetReader interface {
+	// Reads offsets of topic partitions by time.
+	ReadOffsets(topic string, partitions []int32, time int64) (map[int32]int64, error)
+}
+
+// IKafkaGroupDescriber is implemented by connections that describe consumer groups.
+type IKafkaGroupDescriber interface {
+	// Describes a consumer group with its coordinator and live members.
+	DescribeGroup(groupId string) (*KafkaGroupDescription, error)
+}
+
+// IKafkaRecordDeleter is implemented by connections that delete topic records.
+type IKafkaRecordDeleter interface {
+	// Deletes records of topic partitions before offsets.
+	DeleteRecords(topic string, offsets map[int32]int64) error
+}
+
+// IKafkaOffsetTransfer is implemented by connections that export and import committed offsets.
+type IKafkaOffsetTransfer interface {
+	// Exports committed offsets of a consumer group on a topic.
+	ExportOffsets(topic string, groupId string) (*KafkaOffsetSnapshot, error)
+
+	// Imports committed offsets of a consumer group on a topic.
+	ImportOffsets(snapshot *KafkaOffsetSnapshot) error
+}
+
+// IKafkaPartitionPauser is implemented by connections that pause single partitions of subscriptions.
+type IKafkaPartitionPauser interface {
+	// Pauses fetching messages from partitions of a subscription by names of received topics.
+	PausePartitions(topic string, groupId string, listener IKafkaMessageListener, partitions map[string][]int32) error
+
+	// Resumes fetching messages from paused partitions of a subscription by names of received topics.
+	ResumePartitions(topic string, groupId string, listener IKafkaMessageListener, partitions map[string][]int32) error
+}
+
+//	Creates an error returned when a connection doesn't implement an optional operation.
+//	Parameters:
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- operation string	a name of the operation
+//	Returns: UnsupportedError with UNSUPPORTED_OPERATION code.
+func NewUnsupportedOperationError(correlationId string, operation string) error {
+	return cerr.NewUnsupportedError(correlationId, "UNSUPPORTED_OPERATION",
+		"The Kafka connection doesn't support "+operation).WithDetails("operation", operation)
+}
@@ -0,0 +1,9 @@
+package connect
+
+// IKafkaErrorListener is an optional interface of message listeners
+// that are notified about asynchronous consumer failures,
+// such as commit errors or rebalance problems.
+type IKafkaErrorListener interface {
+	// OnError is called for every error returned by the consumer.
+	OnError(err error)
+}
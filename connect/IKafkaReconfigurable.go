@@ -0,0 +1,16 @@
+package connect
+
+import (
+	"context"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+)
+
+// IKafkaReconfigurable is implemented by Kafka components that apply
+// a subset of their configuration while they are opened.
+//
+//	See KafkaConfigReloader
+type IKafkaReconfigurable interface {
+	// Applies hot reloadable options without closing connections.
+	Reconfigure(ctx context.Context, config *cconf.ConfigParams)
+}
@@ -0,0 +1,119 @@
+package connect
+
+import (
+	kafka "github.com/Shopify/sarama"
+)
+
+// Names of broker features that can be required by components
+const (
+	// Record headers, available since Kafka 0.11
+	FeatureHeaders = "headers"
+	// Idempotent producers, available since Kafka 0.11
+	FeatureIdempotence = "idempotence"
+	// Transactional producers, available since Kafka 0.11
+	FeatureTransactions = "transactions"
+	// Zstandard compression, available since Kafka 2.1
+	FeatureZstd = "zstd"
+	// Incremental cooperative rebalancing of consumer groups, available since Kafka 2.4
+	FeatureIncrementalRebalance = "incremental_rebalance"
+)
+
+// Kafka protocol API keys used to detect features
+const (
+	apiKeyProduce            int16 = 0
+	apiKeyJoinGroup          int16 = 11
+	apiKeyInitProducerId     int16 = 22
+	apiKeyAddPartitionsToTxn int16 = 24
+	apiKeyEndTxn             int16 = 26
+)
+
+// KafkaApiVersion is a range of versions of a Kafka protocol API supported by brokers
+type KafkaApiVersion struct {
+	// The minimum supported version
+	Min int16 `json:"min"`
+	// The maximum supported version
+	Max int16 `json:"max"`
+}
+
+//	KafkaBrokerFeatures describes capabilities of a Kafka cluster negotiated with ApiVersions requests.
+//	Versions are intersected across all brokers, so features are reported only when every broker
+//	of a cluster in the middle of a rolling upgrade supports them.
+type KafkaBrokerFeatures struct {
+	// Supported versions by API keys
+	ApiVersions map[int16]*KafkaApiVersion `json:"api_versions"`
+	// The lowest Kafka version that matches the supported APIs, like "2.4.0"
+	Version string `json:"version"`
+}
+
+// Creates features from API versions reported by brokers
+func newKafkaBrokerFeatures(apiVersions map[int16]*KafkaApiVersion) *KafkaBrokerFeatures {
+	c := &KafkaBrokerFeatures{
+		ApiVersions: apiVersions,
+	}
+	c.Version = c.detectVersion().String()
+	return c
+}
+
+//	Checks if a protocol API is supported with at least the given version.
+//	Parameters:
+//		- apiKey int16	a Kafka protocol API key
+//		- version int16	the minimum required version
+//	Returns: true if the API version is supported.
+func (c *KafkaBrokerFeatures) SupportsApi(apiKey int16, version int16) bool {
+	api, ok := c.ApiVersions[apiKey]
+	return ok && api.Max >= version
+}
+
+//	Checks if a feature is supported by brokers.
+//	Parameters:
+//		- feature string	a feature name, see Feature constants
+//	Returns: true if the feature is supported.
+func (c *KafkaBrokerFeatures) Supports(feature string) bool {
+	switch feature {
+	case FeatureHeaders:
+		return c.SupportsApi(apiKeyProduce, 3)
+	case FeatureIdempotence:
+		return c.SupportsApi(apiKeyInitProducerId, 0)
+	case FeatureTransactions:
+		return c.SupportsApi(apiKeyInitProducerId, 0) && c.SupportsApi(apiKeyAddPartitionsToTxn, 0) &&
+			c.SupportsApi(apiKeyEndTxn, 0)
+	case FeatureZstd:
+		return c.SupportsApi(apiKeyProduce, 7)
+	case FeatureIncrementalRebalance:
+		return c.SupportsApi(apiKeyJoinGroup, 6)
+	default:
+		return false
+	}
+}
+
+// Gets the Kafka version required by a feature
+func requiredKafkaVersion(feature string) kafka.KafkaVersion {
+	switch feature {
+	case FeatureHeaders, FeatureIdempotence, FeatureTransactions:
+		return kafka.V0_11_0_0
+	case FeatureZstd:
+		return kafka.V2_1_0_0
+	case FeatureIncrementalRebalance:
+		return kafka.V2_4_0_0
+	default:
+		return kafka.MaxVersion
+	}
+}
+
+// Finds the lowest Kafka version that matches supported versions of the produce and join group APIs
+func (c *KafkaBrokerFeatures) detectVersion() kafka.KafkaVersion {
+	switch {
+	case c.SupportsApi(apiKeyProduce, 8) && c.SupportsApi(apiKeyJoinGroup, 6):
+		return kafka.V2_4_0_0
+	case c.SupportsApi(apiKeyProduce, 7):
+		return kafka.V2_1_0_0
+	case c.SupportsApi(apiKeyProduce, 6):
+		return kafka.V2_0_0_0
+	case c.SupportsApi(apiKeyProduce, 5):
+		return kafka.V1_0_0_0
+	case c.SupportsApi(apiKeyProduce, 3):
+		return kafka.V0_11_0_0
+	default:
+		return kafka.V0_10_0_0
+	}
+}
@@ -0,0 +1,178 @@
+package connect
+
+import (
+	"context"
+	"sync"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	crun "github.com/pip-services3-gox/pip-services3-commons-gox/run"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+)
+
+// IKafkaConfigReader reads configuration and notifies about its changes.
+// It has the same methods as IConfigReader of the components config package,
+// so any config reader can be referenced.
+type IKafkaConfigReader interface {
+	// Reads configuration and parameterizes it with given values.
+	ReadConfig(ctx context.Context, correlationId string, parameters *cconf.ConfigParams) (*cconf.ConfigParams, error)
+
+	// Adds a listener that will be notified when configuration is changed.
+	AddChangeListener(ctx context.Context, listener crun.INotifiable)
+
+	// Removes a previously added change listener.
+	RemoveChangeListener(ctx context.Context, listener crun.INotifiable)
+}
+
+//	KafkaConfigReloader listens to configuration changes of a config reader
+//	and applies hot reloadable options to Kafka components without closing their connections.
+//
+//	Configuration parameters:
+//
+//		- section:                     (optional) name of the configuration section with options of Kafka components (default: entire configuration)
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional) ILogger components to pass log messages
+//		- *:config-reader:*:*:1.0      IConfigReader that notifies about configuration changes
+//		- IKafkaReconfigurable         components that are reconfigured, like KafkaConnection and KafkaMessageQueue
+//
+//	Example:
+//		reloader := connect.NewKafkaConfigReloader()
+//		reloader.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"section", "kafka",
+//		))
+//		reloader.SetReferences(ctx, references)
+//		_ = reloader.Open(ctx, "123")
+type KafkaConfigReloader struct {
+	lock    sync.Mutex
+	opened  bool
+	section string
+
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The config reader that notifies about changes.
+	Reader IKafkaConfigReader
+	// The reconfigured components.
+	Components []IKafkaReconfigurable
+}
+
+//	NewKafkaConfigReloader creates a new instance of the reloader.
+//	Returns: *KafkaConfigReloader
+func NewKafkaConfigReloader() *KafkaConfigReloader {
+	return &KafkaConfigReloader{
+		Logger:     clog.NewCompositeLogger(),
+		Components: []IKafkaReconfigurable{},
+	}
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaConfigReloader) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	c.section = config.GetAsStringWithDefault("section", c.section)
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaConfigReloader) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.Logger.SetReferences(ctx, references)
+
+	readers := references.GetOptional(cref.NewDescriptor("*", "config-reader", "*", "*", "*"))
+	for _, reader := range readers {
+		if reader, ok := reader.(IKafkaConfigReader); ok {
+			c.Reader = reader
+			break
+		}
+	}
+
+	components := references.GetOptional(cref.NewDescriptor("*", "*", "*", "*", "*"))
+	for _, component := range components {
+		if component, ok := component.(IKafkaReconfigurable); ok {
+			c.Components = append(c.Components, component)
+		}
+	}
+}
+
+//	Unsets (clears) previously set references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+func (c *KafkaConfigReloader) UnsetReferences(ctx context.Context) {
+	c.Reader = nil
+	c.Components = []IKafkaReconfigurable{}
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaConfigReloader) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.opened
+}
+
+//	Opens the component and starts listening to configuration changes.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaConfigReloader) Open(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.opened {
+		return nil
+	}
+
+	if c.Reader == nil {
+		return cerr.NewConfigError(correlationId, "NO_CONFIG_READER", "Config reader is not set")
+	}
+
+	c.Reader.AddChangeListener(ctx, c)
+	c.opened = true
+	return nil
+}
+
+//	Closes component and stops listening to configuration changes.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaConfigReloader) Close(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.opened {
+		return nil
+	}
+
+	c.Reader.RemoveChangeListener(ctx, c)
+	c.opened = false
+	return nil
+}
+
+//	Notifies the reloader about changed configuration.
+//	The configuration is read again and applied to all referenced components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//		- args	notification arguments
+func (c *KafkaConfigReloader) Notify(ctx context.Context, correlationId string, args *crun.Parameters) {
+	config, err := c.Reader.ReadConfig(ctx, correlationId, nil)
+	if err != nil {
+		c.Logger.Error(ctx, correlationId, err, "Failed to read changed configuration")
+		return
+	}
+
+	if c.section != "" {
+		config = config.GetSection(c.section)
+	}
+
+	for _, component := range c.Components {
+		component.Reconfigure(ctx, config)
+	}
+	c.Logger.Info(ctx, correlationId, "Reloaded configuration of %d Kafka components", len(c.Components))
+}
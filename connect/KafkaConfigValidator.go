@@ -0,0 +1,171 @@
+package connect
+
+import (
+	"sort"
+	"strings"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+)
+
+// KafkaConnectionOptions are names of options supported by KafkaConnection without the "options." prefix.
+var KafkaConnectionOptions = []string{
+	"acks", "num_partitions", "replication_factor", "retention_ms", "cleanup_policy", "min_insync_replicas",
+	"topic_prefix", "topic_suffix", "log_level", "connect_timeout", "open_timeout", "read_timeout", "write_timeout",
+	"metadata_refresh_interval", "keep_alive", "max_idle_time", "max_retries", "retry_timeout", "request_timeout",
+	"rtt_interval", "shared_consumer", "failover_timeout", "failback_interval", "failover_consumers",
+	"throttle_slowdown", "required_features", "retry_backoff", "delivery_timeout",
+	"metadata_cache_ttl", "hide_internal_topics", "write_internal_topics",
+}
+
+//	ValidateKafkaOptions checks that the options section contains only known options.
+//	Misspelled options fail with a ConfigError that suggests the closest known option.
+//	Parameters:
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- config *cconf.ConfigParams	configuration parameters to validate
+//		- knownOptions ...string	names of known options without the "options." prefix
+//	Returns: error or nil when all options are known
+func ValidateKafkaOptions(correlationId string, config *cconf.ConfigParams, knownOptions ...string) error {
+	known := make(map[string]bool, len(knownOptions))
+	for _, option := range knownOptions {
+		known[option] = true
+	}
+
+	keys := config.GetSection("options").Keys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		// Nested options are validated by their first segment
+		name := strings.SplitN(key, ".", 2)[0]
+		if known[name] {
+			continue
+		}
+
+		message := "Unknown option options." + key
+		if suggestion := closestOption(name, knownOptions); suggestion != "" {
+			message += ", did you mean options." + suggestion + "?"
+		}
+		return cerr.NewConfigError(correlationId, "UNKNOWN_OPTION", message).
+			WithDetails("option", key)
+	}
+	return nil
+}
+
+// Validates values and combinations of connection options
+func validateConnectionConfig(correlationId string, config *cconf.ConfigParams, hasSecondary bool) error {
+	acks := config.GetAsIntegerWithDefault("options.acks", -1)
+	if acks < -1 || acks > 1 {
+		return cerr.NewConfigError(correlationId, "INVALID_OPTION",
+			"Option options.acks must be -1, 0 or 1").WithDetails("acks", acks)
+	}
+
+	for _, option := range []string{"connect_timeout", "open_timeout", "read_timeout", "write_timeout", "request_timeout"} {
+		if value, ok := config.GetAsNullableInteger("options." + option); ok && value <= 0 {
+			return cerr.NewConfigError(correlationId, "INVALID_OPTION",
+				"Option options."+option+" must be a positive number of milliseconds").WithDetails(option, value)
+		}
+	}
+
+	for _, option := range []string{"retry_timeout", "max_retries", "metadata_refresh_interval", "max_idle_time",
+		"rtt_interval", "failover_timeout", "failback_interval", "retry_backoff", "delivery_timeout",
+		"metadata_cache_ttl"} {
+		if value, ok := config.GetAsNullableInteger("options." + option); ok && value < 0 {
+			return cerr.NewConfigError(correlationId, "INVALID_OPTION",
+				"Option options."+option+" must not be negative").WithDetails(option, value)
+		}
+	}
+
+	for _, option := range []string{"num_partitions", "replication_factor"} {
+		if value, ok := config.GetAsNullableInteger("options." + option); ok && value < 1 {
+			return cerr.NewConfigError(correlationId, "INVALID_OPTION",
+				"Option options."+option+" must be at least 1").WithDetails(option, value)
+		}
+	}
+
+	if policy, ok := config.GetAsNullableString("options.cleanup_policy"); ok && policy != "" {
+		switch strings.ReplaceAll(policy, " ", "") {
+		case "delete", "compact", "compact,delete", "delete,compact":
+		default:
+			return cerr.NewConfigError(correlationId, "INVALID_OPTION",
+				"Option options.cleanup_policy must be delete, compact or compact,delete").
+				WithDetails("cleanup_policy", policy)
+		}
+	}
+
+	if features, ok := config.GetAsNullableString("options.required_features"); ok {
+		for _, feature := range strings.Split(features, ";") {
+			feature = strings.TrimSpace(feature)
+			if feature != "" && requiredKafkaVersion(feature) == kafka.MaxVersion {
+				return cerr.NewConfigError(correlationId, "INVALID_OPTION",
+					"Option options.required_features contains unknown feature "+feature).
+					WithDetails("feature", feature)
+			}
+		}
+	}
+
+	// Contradictory combinations
+	if config.GetAsBooleanWithDefault("options.failover_consumers", false) && !hasSecondary {
+		return cerr.NewConfigError(correlationId, "CONTRADICTORY_OPTIONS",
+			"Option options.failover_consumers requires the secondary cluster to be configured")
+	}
+	if acks == 0 && hasSecondary {
+		return cerr.NewConfigError(correlationId, "CONTRADICTORY_OPTIONS",
+			"Failover to the secondary cluster requires options.acks -1 or 1, since failures are not reported with acks 0")
+	}
+
+	return nil
+}
+
+// Validates the SASL mechanism of credentials
+func validateMechanism(correlationId string, mechanism string) error {
+	switch strings.ToLower(mechanism) {
+	case "", "plain", "plaintext", "scram-sha-256", "scram-sha-512":
+		return nil
+	default:
+		return cerr.NewConfigError(correlationId, "UNSUPPORTED_MECHANISM",
+			"Authentication mechanism "+mechanism+" is not supported, use plain, scram-sha-256 or scram-sha-512").
+			WithDetails("mechanism", mechanism)
+	}
+}
+
+// Finds the known option closest to a misspelled one
+func closestOption(name string, knownOptions []string) string {
+	closest := ""
+	minDistance := len(name)/2 + 1
+	for _, option := range knownOptions {
+		distance := editDistance(name, option)
+		if distance < minDistance {
+			minDistance = distance
+			closest = option
+		}
+	}
+	return closest
+}
+
+// Calculates the Levenshtein distance between two strings
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j] + 1
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+			if previous[j-1]+cost < current[j] {
+				current[j] = previous[j-1] + cost
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -2,8 +2,14 @@ package connect
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	kafka "github.com/Shopify/sarama"
@@ -11,6 +17,7 @@ import (
 	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
 	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
 	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	ccount "github.com/pip-services3-gox/pip-services3-components-gox/count"
 	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
 )
 
@@ -29,26 +36,113 @@ import (
 //		  - store_key:                 (optional) a key to retrieve the credentials from ICredentialStore
 //		  - username:                  user name
 //		  - password:                  user password
+//		- secondary:                   (optional) standby cluster used on sustained failures of the primary cluster
+//		  - connection(s):             connection parameters of the standby cluster, like above
+//		  - credential(s):             credential parameters of the standby cluster, like above
 //		- options:
 //			- acks                  (optional) control the number of required acks: -1 - all, 0 - none, 1 - only leader (default: -1)
 //			- num_partitions:       (optional) number of partitions of the created topic (default: 1)
 //			- replication_factor:   (optional) kafka replication factor of the topic (default: 1)
+//			- retention_ms:         (optional) retention.ms of the created topic (default: broker default)
+//			- cleanup_policy:       (optional) cleanup.policy of the created topic: "delete", "compact" or "compact,delete" (default: broker default)
+//			- min_insync_replicas:  (optional) min.insync.replicas of the created topic (default: broker default)
+//			- topic_prefix:         (optional) prefix added to all topic names, like "staging." (default: none)
+//			- topic_suffix:         (optional) suffix added to all topic names (default: none)
+//			- hide_internal_topics: (optional) true to exclude internal topics, like __consumer_offsets or _schemas, from queue names (default: false)
+//			- write_internal_topics: (optional) true to allow publishing to internal topics and changing them (default: false)
 //		  	- log_level:            (optional) log level 0 - None, 1 - Error, 2 - Warn, 3 - Info, 4 - Debug (default: 1)
 //		  	- connect_timeout:      (optional) number of milliseconds to connect to broker (default: 1000)
-//		  	- max_retries:          (optional) maximum retry attempts (default: 5)
+//		  	- open_timeout:         (optional) number of milliseconds to wait until brokers respond on open (default: 10000)
+//		  	- read_timeout:         (optional) number of milliseconds to wait for a response from broker (default: 30000)
+//		  	- write_timeout:        (optional) number of milliseconds to wait for a request to be sent to broker (default: 30000)
+//		  	- metadata_refresh_interval: (optional) number of milliseconds between background refreshes of cluster metadata and partition leaders, 0 to disable (default: 600000)
+//		  	- metadata_cache_ttl:   (optional) number of milliseconds to cache queue names and partitions read from brokers, 0 to disable (default: 0)
+//		  	- keep_alive:           (optional) number of milliseconds between TCP keep-alive probes, 0 for the system default, negative to disable (default: 0)
+//		  	- max_idle_time:        (optional) number of milliseconds a producer may stay idle before it is reconnected on the next send, 0 to disable (default: 0)
+//		  	- max_retries:          (optional) maximum retry attempts of producer and admin requests, like Kafka retries (default: 5)
+//		  	- retry_backoff:        (optional) number of milliseconds between producer retries, like Kafka retry.backoff.ms (default: 100)
+//		  	- delivery_timeout:     (optional) number of milliseconds a publish may keep retrying messages failed on transient errors, like Kafka delivery.timeout.ms, 0 to disable (default: 0)
 //		  	- retry_timeout:        (optional) number of milliseconds to wait on each reconnection attempt (default: 30000)
 //		  	- request_timeout:      (optional) number of milliseconds to wait on flushing messages (default: 30000)
+//		  	- rtt_interval:         (optional) number of milliseconds between metadata probes of brokers, 0 to disable (default: 30000)
+//		  	- shared_consumer:      (optional) true to consume all topics of a consumer group by one shared consumer, a topic can have only one listener in the group (default: false)
+//		  	- failover_timeout:     (optional) number of milliseconds of continuous failures before switching to the secondary cluster (default: 30000)
+//		  	- failback_interval:    (optional) number of milliseconds between health checks of the primary cluster after failover (default: 60000)
+//		  	- failover_consumers:   (optional) true to move consumers to the secondary cluster as well (default: false)
+//		  	- throttle_slowdown:    (optional) true to delay sends while brokers throttle the producer for exceeding quotas (default: false)
+//		  	- required_features:    (optional) list of broker features required on open: "headers", "idempotence", "transactions", "zstd" or "incremental_rebalance" (default: none, set for example: "headers;zstd")
+//
+//	### Failover ###
+//	When the secondary cluster is configured, producers switch to it after publishing or consuming
+//	fails for longer than the failover timeout, and switch back once the primary cluster is healthy again.
+//	Consumers are moved only when failover_consumers is set. Committed offsets are not translated
+//	between clusters, so moved consumers continue from the offsets their group has on the target
+//	cluster, or from the initial offset when the group has none there.
+//
+//	### Broker features ###
+//	On open the connection queries supported API versions from all brokers and keeps the capabilities
+//	common to the whole cluster. Components check them with RequireFeature to fail with a clear error
+//	instead of obscure protocol errors on older clusters. When brokers cannot be queried,
+//	features are unknown and are not checked.
+//
+//	### Reconnects ###
+//	When publishing fails because brokers are unreachable, the producer is recreated from freshly
+//	resolved connection parameters and the messages are sent once more. Host names of brokers are
+//	resolved again on every reconnect, and consumers are recreated the same way when they die,
+//	so brokers that changed their IPs, like Kubernetes pods, are found without a restart.
+//	Reconnects are counted as connection.reconnects.
+//
+//	### Delivery retries ###
+//	The producer retries failed requests max_retries times with retry_backoff between attempts.
+//	When delivery_timeout is set, messages that still fail on transient errors, like leader elections
+//	or missing in-sync replicas, are sent again until the delivery timeout expires,
+//	so short cluster disruptions are not reported to applications as send failures.
+//	Only failed messages are sent again, so delivered messages are not duplicated.
+//
+//	### Parallel fetching ###
+//	Subscribed consumers fetch records by the Sarama consumer, which runs one fetcher per broker
+//	that leads assigned partitions, so a slow broker doesn't delay records of other brokers.
+//	Every claimed partition is handled by its own goroutine. PeekMessages groups partitions
+//	by their leaders and fetches them concurrently in the same way.
+//
+//	### Internal topics ###
+//	Topics with names starting with "_", like __consumer_offsets, __transaction_state or _schemas
+//	of the schema registry, are internal for Kafka and its ecosystem. Publishing to them, creating,
+//	deleting or extending them and deleting their records fail with INTERNAL_TOPIC error,
+//	unless write_internal_topics is set, so they are not damaged by mistake.
+//
+//	### Metadata cache ###
+//	When metadata_cache_ttl is set, ReadQueueNames and ReadPartitions keep their results,
+//	including missing topics, for the configured time, so components that check topics repeatedly,
+//	like factories and health checks, don't send metadata requests to brokers on every call.
+//	Expired entries are read from brokers again. Entries are invalidated when the connection
+//	creates, deletes or extends topics, and can be invalidated explicitly with Invalidate
+//	when topics are changed by other clients.
+//
+//	### Broker metrics ###
+//	Round-trip times of requests in milliseconds are reported as ICounters intervals:
+//		- connection.broker.<id>.metadata_rtt:  time of periodic metadata probes of the broker
+//		- connection.broker.<id>.produce_rtt:   time of publishing messages to partitions led by the broker
+//	Switches between clusters are counted as connection.failovers and connection.failbacks.
 //
 //	### References ###
 //		- *:logger:*:*:1.0           (optional) ILogger components to pass log messages
+//		- *:counters:*:*:1.0         (optional) ICounters components to pass collected measurements
+//		- IKafkaMetrics               (optional) Components to pass labeled measurements, see KafkaMetricDescriptors
 //		- *:discovery:*:*:1.0        (optional) IDiscovery services
 //		- *:credential-store:*:*:1.0 (optional) Credential stores to resolve credentials
 type KafkaConnection struct {
 	defaultConfig *cconf.ConfigParams
 	// The logger.
 	Logger *clog.CompositeLogger
+	// The performance counters.
+	Counters *ccount.CompositeCounters
+	// The labeled metrics passed to IKafkaMetrics components.
+	Metrics *CompositeKafkaMetrics
 	// The connection resolver.
 	ConnectionResolver *KafkaConnectionResolver
+	// The connection resolver of the secondary cluster.
+	SecondaryResolver *KafkaConnectionResolver
 	// The configuration options.
 	Options *cconf.ConfigParams
 
@@ -63,17 +157,64 @@ type KafkaConnection struct {
 
 	// Topic subscriptions
 	subscriptions []*KafkaSubscription
+	lock          sync.Mutex
+	// Serializes changes of shared consumers
+	sharedLock     sync.Mutex
+	sharedConsumer bool
 
 	clientId          string
 	logLevel          int
 	connectTimeout    int
+	openTimeout       int
+	readTimeout       int
+	writeTimeout      int
+	metadataRefresh   int
+	keepAlive         int
+	maxIdleTime       int
+	publishedAt       time.Time
 	maxRetries        int
 	retryTimeout      int
+	retryBackoff      int
+	deliveryTimeout   int
 	requestTimeout    int
 	numPartitions     int
 	replicationFactor int
+	topicPrefix       string
+	topicSuffix       string
+	hideInternal      bool
+	writeInternal     bool
+	topicConfig       map[string]*string
+	rttInterval       int
+
+	// Cached topic names and partitions
+	metadata *kafkaMetadataCache
+
+	probeStop chan struct{}
+	probes    sync.WaitGroup
+
+	hasSecondary      bool
+	failedOver        bool
+	failingSince      time.Time
+	failoverTimeout   int
+	failbackInterval  int
+	failoverConsumers bool
+	failbackStop      chan struct{}
+	failbacks         sync.WaitGroup
+	reconnectedAt     time.Time
+	// Serializes switches between clusters
+	failoverLock sync.Mutex
+
+	throttleSlowdown bool
+	throttledUntil   time.Time
+	throttleLock     sync.Mutex
+
+	features         *KafkaBrokerFeatures
+	requiredFeatures []string
 
 	acks int
+
+	allowedOptions []string
+	configErr      error
 }
 
 //	NewKafkaConnection creates a new instance of the connection component.
@@ -83,24 +224,44 @@ func NewKafkaConnection() *KafkaConnection {
 			// "client_id", nil,
 			"options.log_level", 1,
 			"options.connect_timeout", 1000,
+			"options.open_timeout", 10000,
+			"options.read_timeout", 30000,
+			"options.write_timeout", 30000,
+			"options.metadata_refresh_interval", 600000,
 			"options.retry_timeout", 30000,
 			"options.max_retries", 5,
 			"options.request_timeout", 30000,
+			"options.rtt_interval", 30000,
+			"options.failover_timeout", 30000,
+			"options.failback_interval", 60000,
 		),
 
 		Logger:             clog.NewCompositeLogger(),
+		Counters:           ccount.NewCompositeCounters(),
+		Metrics:            NewCompositeKafkaMetrics(),
 		ConnectionResolver: NewKafkaConnectionResolver(),
+		SecondaryResolver:  NewKafkaConnectionResolver(),
 		Options:            cconf.NewEmptyConfigParams(),
 
 		subscriptions: []*KafkaSubscription{},
+		metadata:      newKafkaMetadataCache(),
 
 		logLevel:          1,
 		connectTimeout:    100,
+		openTimeout:       10000,
+		readTimeout:       30000,
+		writeTimeout:      30000,
+		metadataRefresh:   600000,
 		maxRetries:        3,
 		retryTimeout:      30000,
+		retryBackoff:      100,
 		requestTimeout:    30000,
 		numPartitions:     1,
 		replicationFactor: 1,
+		topicConfig:       map[string]*string{},
+		rttInterval:       30000,
+		failoverTimeout:   30000,
+		failbackInterval:  60000,
 		acks:              -1,
 	}
 
@@ -117,14 +278,51 @@ func (c *KafkaConnection) Configure(ctx context.Context, config *cconf.ConfigPar
 	config = config.SetDefaults(c.defaultConfig)
 	c.ConnectionResolver.Configure(ctx, config)
 
+	secondary := config.GetSection("secondary")
+	if len(secondary.Keys()) > 0 {
+		c.SecondaryResolver.Configure(ctx, secondary)
+		c.hasSecondary = true
+	}
+
+	// Invalid configuration is reported on open
+	c.configErr = ValidateKafkaOptions("", config, append(c.allowedOptions, KafkaConnectionOptions...)...)
+	if c.configErr == nil {
+		c.configErr = validateConnectionConfig("", config, c.hasSecondary)
+	}
+
 	c.Options = c.Options.Override(config.GetSection("options"))
 
 	c.clientId = config.GetAsStringWithDefault("client_id", c.clientId)
 	c.logLevel = config.GetAsIntegerWithDefault("options.log_level", c.logLevel)
 	c.connectTimeout = config.GetAsIntegerWithDefault("options.connect_timeout", c.connectTimeout)
+	c.openTimeout = config.GetAsIntegerWithDefault("options.open_timeout", c.openTimeout)
+	c.readTimeout = config.GetAsIntegerWithDefault("options.read_timeout", c.readTimeout)
+	c.writeTimeout = config.GetAsIntegerWithDefault("options.write_timeout", c.writeTimeout)
+	c.metadataRefresh = config.GetAsIntegerWithDefault("options.metadata_refresh_interval", c.metadataRefresh)
+	c.keepAlive = config.GetAsIntegerWithDefault("options.keep_alive", c.keepAlive)
+	c.maxIdleTime = config.GetAsIntegerWithDefault("options.max_idle_time", c.maxIdleTime)
 	c.maxRetries = config.GetAsIntegerWithDefault("options.max_retries", c.maxRetries)
 	c.retryTimeout = config.GetAsIntegerWithDefault("options.retry_timeout", c.retryTimeout)
+	c.retryBackoff = config.GetAsIntegerWithDefault("options.retry_backoff", c.retryBackoff)
+	c.deliveryTimeout = config.GetAsIntegerWithDefault("options.delivery_timeout", c.deliveryTimeout)
 	c.requestTimeout = config.GetAsIntegerWithDefault("options.request_timeout", c.requestTimeout)
+	c.rttInterval = config.GetAsIntegerWithDefault("options.rtt_interval", c.rttInterval)
+	if ttl, ok := config.GetAsNullableInteger("options.metadata_cache_ttl"); ok {
+		c.metadata.setTtl(time.Millisecond * time.Duration(ttl))
+	}
+	c.sharedConsumer = config.GetAsBooleanWithDefault("options.shared_consumer", c.sharedConsumer)
+	c.failoverTimeout = config.GetAsIntegerWithDefault("options.failover_timeout", c.failoverTimeout)
+	c.failbackInterval = config.GetAsIntegerWithDefault("options.failback_interval", c.failbackInterval)
+	c.failoverConsumers = config.GetAsBooleanWithDefault("options.failover_consumers", c.failoverConsumers)
+	c.throttleSlowdown = config.GetAsBooleanWithDefault("options.throttle_slowdown", c.throttleSlowdown)
+	if features, ok := config.GetAsNullableString("options.required_features"); ok {
+		c.requiredFeatures = []string{}
+		for _, feature := range strings.Split(features, ";") {
+			if feature = strings.TrimSpace(feature); feature != "" {
+				c.requiredFeatures = append(c.requiredFeatures, feature)
+			}
+		}
+	}
 
 	c.numPartitions = config.GetAsIntegerWithDefault("options.num_partitions", c.numPartitions)
 	c.replicationFactor = config.GetAsIntegerWithDefault("options.replication_factor",
@@ -132,6 +330,90 @@ func (c *KafkaConnection) Configure(ctx context.Context, config *cconf.ConfigPar
 
 	c.acks = config.GetAsIntegerWithDefault("options.acks",
 		c.acks)
+
+	for option, entry := range map[string]string{
+		"options.retention_ms":        "retention.ms",
+		"options.cleanup_policy":      "cleanup.policy",
+		"options.min_insync_replicas": "min.insync.replicas",
+	} {
+		if value, ok := config.GetAsNullableString(option); ok && value != "" {
+			c.topicConfig[entry] = &value
+		}
+	}
+
+	c.topicPrefix = config.GetAsStringWithDefault("options.topic_prefix", c.topicPrefix)
+	c.topicSuffix = config.GetAsStringWithDefault("options.topic_suffix", c.topicSuffix)
+	c.hideInternal = config.GetAsBooleanWithDefault("options.hide_internal_topics", c.hideInternal)
+	c.writeInternal = config.GetAsBooleanWithDefault("options.write_internal_topics", c.writeInternal)
+}
+
+//	Allows options of a component that owns a local connection, so they are not reported as unknown.
+//	Must be called before Configure.
+//	Parameters:
+//		- options ...string	names of allowed options without the "options." prefix
+func (c *KafkaConnection) AllowOptions(options ...string) {
+	c.allowedOptions = append(c.allowedOptions, options...)
+}
+
+//	Applies hot reloadable options while the connection is opened:
+//	log_level, retry_timeout, request_timeout and rtt_interval.
+//	Other options take effect only after the connection is reopened.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be applied.
+func (c *KafkaConnection) Reconfigure(ctx context.Context, config *cconf.ConfigParams) {
+	c.lock.Lock()
+	c.logLevel = config.GetAsIntegerWithDefault("options.log_level", c.logLevel)
+	c.retryTimeout = config.GetAsIntegerWithDefault("options.retry_timeout", c.retryTimeout)
+	c.requestTimeout = config.GetAsIntegerWithDefault("options.request_timeout", c.requestTimeout)
+	rttInterval := config.GetAsIntegerWithDefault("options.rtt_interval", c.rttInterval)
+	rttChanged := rttInterval != c.rttInterval
+	c.rttInterval = rttInterval
+	c.lock.Unlock()
+
+	c.Logger.SetLevel(toLogLevel(c.logLevel))
+
+	// Restart broker probes with the new interval
+	if rttChanged && c.IsOpen() {
+		c.stopProbes()
+		c.startProbes()
+	}
+
+	c.Logger.Debug(ctx, "", "Reconfigured Kafka connection")
+}
+
+// Converts the log_level option into the logger level
+func toLogLevel(logLevel int) clog.LevelType {
+	switch logLevel {
+	case 0:
+		return clog.LevelNone
+	case 1:
+		return clog.LevelError
+	case 2:
+		return clog.LevelWarn
+	case 3:
+		return clog.LevelInfo
+	default:
+		return clog.LevelDebug
+	}
+}
+
+//	Converts a topic name into the name used in Kafka by adding the configured prefix and suffix.
+//	Parameters:
+//		- name string	a topic name
+//	Returns: the topic name in Kafka.
+func (c *KafkaConnection) ResolveTopic(name string) string {
+	return c.topicPrefix + name + c.topicSuffix
+}
+
+// Converts a topic name used in Kafka back into the topic name.
+// Returns false for topics that do not have the configured prefix and suffix.
+func (c *KafkaConnection) unresolveTopic(topic string) (string, bool) {
+	if !strings.HasPrefix(topic, c.topicPrefix) || !strings.HasSuffix(topic, c.topicSuffix) ||
+		len(topic) <= len(c.topicPrefix)+len(c.topicSuffix) {
+		return "", false
+	}
+	return topic[len(c.topicPrefix) : len(topic)-len(c.topicSuffix)], true
 }
 
 //	Sets references to dependent components.
@@ -140,17 +422,35 @@ func (c *KafkaConnection) Configure(ctx context.Context, config *cconf.ConfigPar
 //  	- references 	references to locate the component dependencies.
 func (c *KafkaConnection) SetReferences(ctx context.Context, references cref.IReferences) {
 	c.Logger.SetReferences(ctx, references)
+	c.Counters.SetReferences(ctx, references)
+	c.Metrics.SetReferences(ctx, references)
 	c.ConnectionResolver.SetReferences(ctx, references)
+	c.SecondaryResolver.SetReferences(ctx, references)
 }
 
 //	Checks if the component is opened.
 //	Returns: true if the component has been opened and false otherwise.
 func (c *KafkaConnection) IsOpen() bool {
-	return c.connection != nil
+	return c.producer() != nil
 }
 
+// Creates configuration of the active cluster
 func (c *KafkaConnection) createConfig() ([]string, *kafka.Config, error) {
-	options, err := c.ConnectionResolver.Resolve("")
+	c.lock.Lock()
+	secondary := c.failedOver
+	c.lock.Unlock()
+
+	return c.createClusterConfig(secondary)
+}
+
+// Creates configuration of the primary or the secondary cluster
+func (c *KafkaConnection) createClusterConfig(secondary bool) ([]string, *kafka.Config, error) {
+	resolver := c.ConnectionResolver
+	if secondary {
+		resolver = c.SecondaryResolver
+	}
+
+	options, err := resolver.Resolve("")
 	if err != nil {
 		return nil, nil, err
 	}
@@ -164,11 +464,17 @@ func (c *KafkaConnection) createConfig() ([]string, *kafka.Config, error) {
 	config.Producer.Return.Errors = true
 	config.Producer.Return.Successes = true
 	config.Producer.Retry.Max = c.maxRetries
+	config.Producer.Retry.Backoff = time.Millisecond * time.Duration(c.retryBackoff)
 	config.Consumer.Return.Errors = true
 	config.Producer.Partitioner = kafka.NewManualPartitioner
 	config.Producer.RequiredAcks = kafka.RequiredAcks(c.acks)
 
 	config.Net.DialTimeout = time.Millisecond * time.Duration(c.connectTimeout)
+	config.Net.ReadTimeout = time.Millisecond * time.Duration(c.readTimeout)
+	config.Net.WriteTimeout = time.Millisecond * time.Duration(c.writeTimeout)
+	config.Metadata.RefreshFrequency = time.Millisecond * time.Duration(c.metadataRefresh)
+	config.Net.KeepAlive = time.Millisecond * time.Duration(c.keepAlive)
+	config.MetricRegistry = newKafkaThrottleRegistry(c.onThrottle)
 
 	username := options.GetAsString("username")
 	password := options.GetAsString("password")
@@ -178,7 +484,11 @@ func (c *KafkaConnection) createConfig() ([]string, *kafka.Config, error) {
 		config.Net.SASL.Enable = true
 
 		mechanism := options.GetAsString("mechanism")
-		switch mechanism {
+		err = validateMechanism("", mechanism)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch strings.ToLower(mechanism) {
 		case "scram-sha-256":
 			config.Net.SASL.Mechanism = kafka.SASLTypeSCRAMSHA256
 		case "scram-sha-512":
@@ -197,67 +507,131 @@ func (c *KafkaConnection) createConfig() ([]string, *kafka.Config, error) {
 //   	- correlationId 	(optional) transaction id to trace execution through call chain.
 //   	- Return 			error or nil no errors occured.
 func (c *KafkaConnection) Open(ctx context.Context, correlationId string) error {
+	if c.configErr != nil {
+		return c.configErr
+	}
+
 	brokers, config, err := c.createConfig()
 	if err != nil {
 		return err
 	}
 
 	uri := strings.Join(brokers, ",")
-	connection, err := kafka.NewSyncProducer(brokers, config)
+	connection, err := c.openProducer(correlationId, brokers, config)
 	if err != nil {
 		c.Logger.Error(ctx, correlationId, err, "Failed to connect to Kafka broker at "+uri)
-		return err
+		if !c.hasSecondary {
+			return err
+		}
+
+		// Start on the secondary cluster when the primary one is down
+		brokers, config, err = c.createClusterConfig(true)
+		if err != nil {
+			return err
+		}
+		uri = strings.Join(brokers, ",")
+		connection, err = c.openProducer(correlationId, brokers, config)
+		if err != nil {
+			c.Logger.Error(ctx, correlationId, err, "Failed to connect to secondary Kafka broker at "+uri)
+			return err
+		}
+
+		c.lock.Lock()
+		c.failedOver = true
+		c.lock.Unlock()
+		c.startFailback()
 	}
 
+	c.lock.Lock()
 	c.connection = connection
+	c.publishedAt = time.Now()
+	c.lock.Unlock()
 
 	c.Logger.Debug(ctx, correlationId, "Connected to Kafka broker at "+uri)
 
+	c.detectFeatures(ctx, correlationId)
+	for _, feature := range c.requiredFeatures {
+		err = c.RequireFeature(correlationId, feature)
+		if err != nil {
+			c.Close(ctx, correlationId)
+			return err
+		}
+	}
+
+	c.startProbes()
+
 	return nil
 }
 
-//	Closes component and frees used resources.
-//	Parameters:
-//		- ctx context.Context	operation context
-//   	- correlationId 	(optional) transaction id to trace execution through call chain.
-// Return			 error or nil no errors occured
-func (c *KafkaConnection) Close(ctx context.Context, correlationId string) error {
-	if c.connection == nil {
-		return nil
+// Creates a producer and fails when brokers do not respond within the open timeout.
+func (c *KafkaConnection) openProducer(correlationId string, brokers []string, config *kafka.Config) (kafka.SyncProducer, error) {
+	opened, err := c.openWithTimeout(correlationId, brokers, config, func() (io.Closer, error) {
+		return kafka.NewSyncProducer(brokers, config)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return opened.(kafka.SyncProducer), nil
+}
 
-	// Close admin client
-	if c.adminClient != nil {
-		c.adminClient.Close()
-		c.adminClient = nil
+// Creates a client, a producer or another object connected to brokers and fails when brokers
+// do not respond within the open timeout. Errors include the bootstrap brokers,
+// the authentication mechanism and the last broker error.
+func (c *KafkaConnection) openWithTimeout(correlationId string, brokers []string, config *kafka.Config,
+	open func() (io.Closer, error)) (io.Closer, error) {
+
+	type result struct {
+		opened io.Closer
+		err    error
 	}
 
-	// Close producer
-	c.connection.Close()
-	c.Logger.Debug(ctx, correlationId, "Disconnected to Kafka broker")
+	done := make(chan result, 1)
+	go func() {
+		opened, err := open()
+		done <- result{opened: opened, err: err}
+	}()
 
-	// Close all consumers
-	for _, subscription := range c.subscriptions {
-		if subscription.Handler != nil {
-			(*subscription.Handler).Close()
+	timer := time.NewTimer(time.Millisecond * time.Duration(c.openTimeout))
+	defer timer.Stop()
+
+	var err error
+	select {
+	case result := <-done:
+		if result.err == nil {
+			return result.opened, nil
 		}
+		err = result.err
+	case <-timer.C:
+		err = fmt.Errorf("brokers did not respond in %d milliseconds", c.openTimeout)
+		// Close the object when it is created too late
+		go func() {
+			if result := <-done; result.err == nil {
+				result.opened.Close()
+			}
+		}()
 	}
 
-	c.connection = nil
-	c.subscriptions = []*KafkaSubscription{}
-
-	return nil
-}
+	uri := strings.Join(brokers, ",")
+	mechanism := "none"
+	if config.Net.SASL.Enable {
+		mechanism = string(config.Net.SASL.Mechanism)
+	}
 
-// Returns connection object
-func (c *KafkaConnection) GetConnection() kafka.SyncProducer {
-	return c.connection
+	return nil, cerr.NewConnectionError(correlationId, "CONNECT_FAILED",
+		"Failed to connect to Kafka brokers "+uri+" with "+mechanism+" authentication: "+err.Error()).
+		WithCause(err).WithDetails("brokers", uri).WithDetails("mechanism", mechanism)
 }
 
-func (c *KafkaConnection) connectToAdmin() error {
-	err := c.checkOpen()
-	if err != nil {
-		return err
+//	Checks connectivity without opening the connection: resolves the configuration,
+//	connects and authenticates to brokers, fetches cluster metadata and disconnects.
+//	Deployment pipelines use it to validate configuration before rolling a service.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil when brokers are reachable with the configured credentials.
+func (c *KafkaConnection) CheckConnection(ctx context.Context, correlationId string) error {
+	if c.configErr != nil {
+		return c.configErr
 	}
 
 	brokers, config, err := c.createConfig()
@@ -265,176 +639,2041 @@ func (c *KafkaConnection) connectToAdmin() error {
 		return err
 	}
 
-	uri := strings.Join(brokers, ",")
-	client, err := kafka.NewClient(brokers, config)
+	opened, err := c.openWithTimeout(correlationId, brokers, config, func() (io.Closer, error) {
+		return kafka.NewClient(brokers, config)
+	})
 	if err != nil {
-		c.Logger.Error(context.Background(), "", err, "Failed to connect to Kafka broker at "+uri)
 		return err
 	}
 
-	admin, err := kafka.NewClusterAdminFromClient(client)
+	client := opened.(kafka.Client)
+	defer client.Close()
+
+	uri := strings.Join(brokers, ",")
+	err = client.RefreshMetadata()
 	if err != nil {
-		c.Logger.Error(context.Background(), "", err, "Failed Kafka admin broker creation at "+uri)
-		return err
+		return cerr.NewConnectionError(correlationId, "METADATA_FAILED",
+			"Failed to fetch metadata from Kafka brokers "+uri).WithCause(err).WithDetails("brokers", uri)
 	}
 
-	c.client = client
-	c.adminClient = admin
+	c.Logger.Debug(ctx, correlationId, "Checked connection to %d Kafka brokers at %s", len(client.Brokers()), uri)
 	return nil
 }
 
-//	Reads a list of registered queue names.
-//	If connection doesn't support this function returnes an empty list.
-//	Returns queue names.
-func (c *KafkaConnection) ReadQueueNames() ([]string, error) {
-	err := c.connectToAdmin()
+// Queries API versions supported by all brokers of the cluster
+func (c *KafkaConnection) detectFeatures(ctx context.Context, correlationId string) {
+	client, _, err := c.connectToAdmin()
 	if err != nil {
-		return nil, err
+		c.Logger.Warn(ctx, correlationId, "Failed to detect Kafka broker features: %v", err)
+		return
 	}
 
-	return c.client.Topics()
-}
+	var apiVersions map[int16]*KafkaApiVersion
+	for _, broker := range client.Brokers() {
+		if connected, _ := broker.Connected(); !connected {
+			err = broker.Open(client.Config())
+			if err != nil && err != kafka.ErrAlreadyConnected {
+				c.Logger.Warn(ctx, correlationId, "Failed to detect features of Kafka broker %d: %v", broker.ID(), err)
+				return
+			}
+		}
 
-//	Creates a message queue.
-//	If connection doesn't support this function it exists without error.
-//	Parameters:
-//		- name string	the name of the queue to be created.
-//	Returns: the name of the queue to be created.
-func (c *KafkaConnection) CreateQueue(name string) error {
-	err := c.checkOpen()
-	if err != nil {
-		return err
-	}
+		response, err := broker.ApiVersions(&kafka.ApiVersionsRequest{})
+		if err == nil && response.ErrorCode != int16(kafka.ErrNoError) {
+			err = kafka.KError(response.ErrorCode)
+		}
+		if err != nil {
+			c.Logger.Warn(ctx, correlationId, "Failed to detect features of Kafka broker %d: %v", broker.ID(), err)
+			return
+		}
 
-	err = c.connectToAdmin()
-	if err != nil {
-		return err
+		// Keep only versions supported by every broker
+		versions := make(map[int16]*KafkaApiVersion, len(response.ApiKeys))
+		for _, key := range response.ApiKeys {
+			version := &KafkaApiVersion{Min: key.MinVersion, Max: key.MaxVersion}
+			if apiVersions != nil {
+				common, ok := apiVersions[key.ApiKey]
+				if !ok {
+					continue
+				}
+				if common.Min > version.Min {
+					version.Min = common.Min
+				}
+				if common.Max < version.Max {
+					version.Max = common.Max
+				}
+			}
+			versions[key.ApiKey] = version
+		}
+		apiVersions = versions
+	}
+	if apiVersions == nil {
+		return
 	}
 
-	err = c.adminClient.CreateTopic(name, &kafka.TopicDetail{
-		NumPartitions:     int32(c.numPartitions),
-		ReplicationFactor: int16(c.replicationFactor),
-	}, false)
+	features := newKafkaBrokerFeatures(apiVersions)
+	c.lock.Lock()
+	c.features = features
+	c.lock.Unlock()
+	c.Logger.Debug(ctx, correlationId, "Detected Kafka brokers compatible with version %s", features.Version)
+}
 
-	return err
+//	Gets capabilities of the connected Kafka cluster.
+//	Returns: the broker features or nil if they are unknown.
+func (c *KafkaConnection) GetFeatures() *KafkaBrokerFeatures {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.features
 }
 
-//	Deletes a message queue.
-//	If connection doesn't support this function it exists without error.
+//	Checks that a feature is supported by the connected Kafka cluster.
+//	Features are not checked when brokers could not be queried.
 //	Parameters:
-//		- name string	the name of the queue to be deleted.
-func (c *KafkaConnection) DeleteQueue(name string) error {
-	err := c.checkOpen()
-	if err != nil {
-		return err
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- feature string	a feature name: "headers", "idempotence", "transactions", "zstd" or "incremental_rebalance"
+//	Returns: UnsupportedError when the feature is not supported or nil otherwise.
+func (c *KafkaConnection) RequireFeature(correlationId string, feature string) error {
+	features := c.GetFeatures()
+	if features == nil || features.Supports(feature) {
+		return nil
 	}
 
-	err = c.connectToAdmin()
-	if err != nil {
-		return err
+	required := requiredKafkaVersion(feature)
+	message := "Feature " + feature + " is not supported by Kafka brokers compatible with version " + features.Version
+	if required != kafka.MaxVersion {
+		message += ", it requires Kafka " + required.String() + " or newer"
 	}
-
-	return c.adminClient.DeleteTopic(name)
+	return cerr.NewUnsupportedError(correlationId, "UNSUPPORTED_FEATURE", message).
+		WithDetails("feature", feature).WithDetails("version", features.Version)
 }
 
-func (c *KafkaConnection) checkOpen() error {
-	if c.connection != nil {
+//	Closes component and frees used resources.
+//	Parameters:
+//		- ctx context.Context	operation context
+//   	- correlationId 	(optional) transaction id to trace execution through call chain.
+// Return			 error or nil no errors occured
+func (c *KafkaConnection) Close(ctx context.Context, correlationId string) error {
+	if !c.IsOpen() {
 		return nil
 	}
 
-	return cerr.NewInvalidStateError(
-		"",
-		"NOT_OPEN",
-		"Connection was not opened",
-	)
+	c.stopProbes()
+	c.stopFailback()
+
+	// Close admin client
+	c.lock.Lock()
+	admin := c.adminClient
+	c.adminClient = nil
+	c.client = nil
+	c.lock.Unlock()
+	if admin != nil {
+		admin.Close()
+	}
+
+	// Close producer
+	c.producer().Close()
+	c.Logger.Debug(ctx, correlationId, "Disconnected to Kafka broker")
+
+	// Close all consumers and wait for their goroutines
+	c.lock.Lock()
+	subscriptions := c.subscriptions
+	c.subscriptions = []*KafkaSubscription{}
+	c.lock.Unlock()
+
+	for _, subscription := range subscriptions {
+		subscription.close()
+	}
+
+	c.metadata.invalidate()
+
+	c.lock.Lock()
+	c.connection = nil
+	c.features = nil
+	c.failedOver = false
+	c.failingSince = time.Time{}
+	c.lock.Unlock()
+
+	return nil
 }
 
-//	Publish a message to a specified topic
-//
-//	Parameters:
-//		- ctx context.Context	operation context
-//		- topic a topic name
-//		- messages messages to be published
-//	Returns: error or nil for success
-func (c *KafkaConnection) Publish(ctx context.Context, topic string, messages []*kafka.ProducerMessage) error {
-	// Check for open connection
-	err := c.checkOpen()
-	if err != nil {
-		return err
+func (c *KafkaConnection) startProbes() {
+	c.lock.Lock()
+	rttInterval := c.rttInterval
+	if rttInterval <= 0 || c.probeStop != nil {
+		c.lock.Unlock()
+		return
 	}
+	stop := make(chan struct{})
+	c.probeStop = stop
+	c.lock.Unlock()
+
+	c.probes.Add(1)
+	go func() {
+		defer c.probes.Done()
+
+		ticker := time.NewTicker(time.Millisecond * time.Duration(rttInterval))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				c.probeBrokers()
+			}
+		}
+	}()
+}
 
-	// Assign topic to messages
-	for _, message := range messages {
-		message.Topic = topic
+func (c *KafkaConnection) stopProbes() {
+	c.lock.Lock()
+	stop := c.probeStop
+	c.probeStop = nil
+	c.lock.Unlock()
+
+	if stop != nil {
+		close(stop)
 	}
+	c.probes.Wait()
+}
 
-	return c.connection.SendMessages(messages)
+//	Checks if the connection has switched to the secondary cluster.
+//	Returns: true if the secondary cluster is used and false otherwise.
+func (c *KafkaConnection) IsFailedOver() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.failedOver
 }
 
-//	Subscribe to a topic
-//
-//	Parameters:
-//		- ctx context.Context	operation context
-//		- topic a subject (topic) name
-//		- groupId (optional) a consumer group id
-//		- config consumer configuration parameters
-//		- listener a message listener
-//	Returns: err or nil for success
-func (c *KafkaConnection) Subscribe(ctx context.Context, topic string, groupId string, config *kafka.Config, listener IKafkaMessageListener) error {
-	// Check for open connection
-	err := c.checkOpen()
-	if err != nil {
-		return err
+// Returns the producer of the active cluster
+func (c *KafkaConnection) producer() kafka.SyncProducer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.connection
+}
+
+// Clears the failure time after a successful request
+func (c *KafkaConnection) recordSuccess() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.failingSince = time.Time{}
+}
+
+// Tracks continuous failures of the primary cluster and fails over
+// to the secondary one when they last longer than the failover timeout.
+func (c *KafkaConnection) recordFailure(ctx context.Context) {
+	if !c.hasSecondary {
+		return
 	}
 
-	brokers, consumerConfig, err := c.createConfig()
+	c.lock.Lock()
+	if c.failedOver || c.connection == nil {
+		c.lock.Unlock()
+		return
+	}
+	now := time.Now()
+	if c.failingSince.IsZero() {
+		c.failingSince = now
+	}
+	failingFor := now.Sub(c.failingSince)
+	c.lock.Unlock()
+
+	if failingFor < time.Millisecond*time.Duration(c.failoverTimeout) {
+		return
+	}
+
+	err := c.switchCluster(ctx, true)
 	if err != nil {
-		return err
+		c.Logger.Error(ctx, "", err, "Failed to switch to secondary Kafka cluster")
+		return
 	}
+	c.startFailback()
+}
 
-	uri := strings.Join(brokers, ",")
+// Moves the producer, and consumers when enabled, to the primary or the secondary cluster
+func (c *KafkaConnection) switchCluster(ctx context.Context, secondary bool) error {
+	c.failoverLock.Lock()
+	defer c.failoverLock.Unlock()
 
-	consumerConfig.Consumer.Offsets.AutoCommit.Enable = config.Consumer.Offsets.AutoCommit.Enable
-	consumerConfig.Consumer.Return.Errors = true
+	if c.IsFailedOver() == secondary {
+		return nil
+	}
 
-	consumer, err := kafka.NewConsumerGroup(brokers, groupId, consumerConfig)
-	if err != nil {
-		c.Logger.Error(ctx, "", err, "Failed to connect Kafka consumer at "+uri)
+	uri, err := c.replaceProducer(secondary)
+	if err != nil || uri == "" {
 		return err
 	}
 
-	// Consume messages in a separate thread
-	go func(ctx context.Context, consumer kafka.ConsumerGroup, topic string, listener IKafkaMessageListener) {
-		err = consumer.Consume(ctx, []string{topic}, listener)
+	c.lock.Lock()
+	subscriptions := c.subscriptions
+	c.lock.Unlock()
+
+	if secondary {
+		c.Logger.Warn(ctx, "", "Failed over to secondary Kafka cluster at %s", uri)
+		c.Counters.IncrementOne(ctx, "connection.failovers")
+		c.Metrics.Increment(MetricConnectionFailovers, nil, 1)
+	} else {
+		c.Logger.Info(ctx, "", "Failed back to primary Kafka cluster at %s", uri)
+		c.Counters.IncrementOne(ctx, "connection.failbacks")
+		c.Metrics.Increment(MetricConnectionFailbacks, nil, 1)
+	}
+
+	if !c.failoverConsumers {
+		return nil
+	}
+
+	for _, subscription := range subscriptions {
+		brokers, consumerConfig, err := c.createConsumerConfig(subscription.config)
 		if err != nil {
-			c.Logger.Error(ctx, "", err, "Failed to consume messages "+uri)
+			return err
 		}
-
-		// check if context was cancelled, signaling that the consumer should stop
-		if ctx.Err() != nil {
-			return
+		consumer, err := kafka.NewConsumerGroup(brokers, subscription.GroupId, consumerConfig)
+		if err != nil {
+			c.Logger.Error(ctx, "", err, "Failed to move Kafka consumer to "+uri)
+			continue
 		}
+		// The consume loop continues with the new consumer when the previous one is closed
+		if subscription.replace(consumer) {
+			c.logConsumerErrors(ctx, subscription, consumer)
+		}
+	}
+	return nil
+}
 
-		listener.SetReady(make(chan bool))
-	}(ctx, consumer, topic, listener)
+// Replaces the producer and the admin client with new ones connected to the primary or the secondary cluster.
+// Connection parameters and host names of brokers are resolved again.
+// Returns the new broker uri, or an empty uri when the connection was closed meanwhile.
+func (c *KafkaConnection) replaceProducer(secondary bool) (string, error) {
+	brokers, config, err := c.createClusterConfig(secondary)
+	if err != nil {
+		return "", err
+	}
 
-	<-listener.Ready() // wait cunsumer start
+	connection, err := c.openProducer("", brokers, config)
+	if err != nil {
+		return "", err
+	}
 
-	// Create the subscription
-	subscription := &KafkaSubscription{
-		Topic:    topic,
-		GroupId:  groupId,
-		Listener: listener,
-		Handler:  &consumer,
+	c.lock.Lock()
+	if c.connection == nil {
+		c.lock.Unlock()
+		connection.Close()
+		return "", nil
+	}
+	previous := c.connection
+	admin := c.adminClient
+	c.connection = connection
+	c.adminClient = nil
+	c.client = nil
+	c.failedOver = secondary
+	c.failingSince = time.Time{}
+	c.reconnectedAt = time.Now()
+	c.lock.Unlock()
+
+	previous.Close()
+	if admin != nil {
+		admin.Close()
 	}
 
-	// Add the subscription
-	c.subscriptions = append(c.subscriptions, subscription)
-	return nil
+	return strings.Join(brokers, ","), nil
 }
 
-//	Unsubscribe from a previously subscribed topic topic
-//
+// Reconnects the producer to the active cluster when brokers became unreachable.
+// New clients resolve broker addresses again, so brokers that changed their IPs
+// are found without restarting the service. Reconnects happen at most once per connect timeout.
+// Returns true when the producer was reconnected.
+func (c *KafkaConnection) reconnect(ctx context.Context) bool {
+	c.failoverLock.Lock()
+	defer c.failoverLock.Unlock()
+
+	c.lock.Lock()
+	secondary := c.failedOver
+	recent := time.Since(c.reconnectedAt) < time.Millisecond*time.Duration(c.connectTimeout)
+	c.lock.Unlock()
+
+	if recent {
+		return false
+	}
+
+	uri, err := c.replaceProducer(secondary)
+	if err != nil {
+		c.Logger.Error(ctx, "", err, "Failed to reconnect to Kafka broker")
+		return false
+	}
+	if uri == "" {
+		return false
+	}
+
+	c.Logger.Info(ctx, "", "Reconnected to Kafka broker at %s", uri)
+	c.Counters.IncrementOne(ctx, "connection.reconnects")
+	c.Metrics.Increment(MetricConnectionReconnects, nil, 1)
+	return true
+}
+
+// Checks if the producer has been idle longer than the max idle time
+func (c *KafkaConnection) isIdle() bool {
+	if c.maxIdleTime <= 0 {
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	lastUsed := c.publishedAt
+	if c.reconnectedAt.After(lastUsed) {
+		lastUsed = c.reconnectedAt
+	}
+	return !lastUsed.IsZero() && time.Since(lastUsed) > time.Millisecond*time.Duration(c.maxIdleTime)
+}
+
+// Checks if an error is caused by unreachable brokers.
+// Brokers that went away drop open connections with EOF.
+func isBrokerUnavailable(err error) bool {
+	if errs, ok := err.(kafka.ProducerErrors); ok {
+		for _, producerErr := range errs {
+			if isBrokerUnavailable(producerErr.Err) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.Is(err, kafka.ErrOutOfBrokers) || errors.Is(err, kafka.ErrNotConnected) ||
+		errors.Is(err, kafka.ErrBrokerNotAvailable) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
+
+// Periodically checks health of the primary cluster while the secondary one is used
+// and fails back when the primary cluster responds.
+func (c *KafkaConnection) startFailback() {
+	c.lock.Lock()
+	if c.failbackStop != nil || c.failbackInterval <= 0 {
+		c.lock.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	c.failbackStop = stop
+	c.lock.Unlock()
+
+	c.failbacks.Add(1)
+	go func() {
+		defer c.failbacks.Done()
+		defer func() {
+			c.lock.Lock()
+			if c.failbackStop == stop {
+				c.failbackStop = nil
+			}
+			c.lock.Unlock()
+		}()
+
+		ticker := time.NewTicker(time.Millisecond * time.Duration(c.failbackInterval))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			brokers, config, err := c.createClusterConfig(false)
+			if err != nil {
+				continue
+			}
+			client, err := kafka.NewClient(brokers, config)
+			if err != nil {
+				continue
+			}
+			client.Close()
+
+			err = c.switchCluster(context.Background(), false)
+			if err == nil {
+				return
+			}
+			c.Logger.Error(context.Background(), "", err, "Failed to switch back to primary Kafka cluster")
+		}
+	}()
+}
+
+func (c *KafkaConnection) stopFailback() {
+	c.lock.Lock()
+	stop := c.failbackStop
+	c.failbackStop = nil
+	c.lock.Unlock()
+
+	if stop != nil {
+		close(stop)
+	}
+	c.failbacks.Wait()
+}
+
+// Measures round-trip times of metadata requests to every broker.
+// Probes request a single topic to keep responses small on large clusters.
+func (c *KafkaConnection) probeBrokers() {
+	ctx := context.Background()
+
+	client, _, err := c.connectToAdmin()
+	if err != nil {
+		return
+	}
+
+	topics, err := client.Topics()
+	if err != nil || len(topics) == 0 {
+		return
+	}
+	sort.Strings(topics)
+
+	_, config, err := c.createConfig()
+	if err != nil {
+		return
+	}
+
+	for _, broker := range client.Brokers() {
+		// Brokers are opened lazily by the client
+		if connected, _ := broker.Connected(); !connected {
+			_ = broker.Open(config)
+		}
+
+		start := time.Now()
+		_, err := broker.GetMetadata(&kafka.MetadataRequest{Version: 1, Topics: topics[:1]})
+		if err != nil {
+			c.Logger.Debug(ctx, "", "Failed to probe Kafka broker %s: %s", broker.Addr(), err)
+			continue
+		}
+		rtt := float64(time.Since(start).Milliseconds())
+		c.Counters.EndTiming(ctx, fmt.Sprintf("connection.broker.%d.metadata_rtt", broker.ID()), rtt)
+		c.Metrics.Observe(MetricConnectionMetadataRtt, brokerMetricLabels(broker.ID()), rtt)
+	}
+}
+
+// Returns connection object
+func (c *KafkaConnection) GetConnection() kafka.SyncProducer {
+	return c.producer()
+}
+
+// Gets the shared client and admin client, creating them on first use.
+// Clients are created outside the lock and the ones that lose a concurrent creation are closed.
+// Callers use the returned clients, since the producer replacement resets the shared ones.
+func (c *KafkaConnection) connectToAdmin() (kafka.Client, kafka.ClusterAdmin, error) {
+	err := c.checkOpen()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Reuse already created admin client
+	c.lock.Lock()
+	client, admin := c.client, c.adminClient
+	c.lock.Unlock()
+	if admin != nil {
+		return client, admin, nil
+	}
+
+	brokers, config, err := c.createConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	uri := strings.Join(brokers, ",")
+	client, err = kafka.NewClient(brokers, config)
+	if err != nil {
+		c.Logger.Error(context.Background(), "", err, "Failed to connect to Kafka broker at "+uri)
+		return nil, nil, err
+	}
+
+	admin, err = kafka.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		c.Logger.Error(context.Background(), "", err, "Failed Kafka admin broker creation at "+uri)
+		return nil, nil, err
+	}
+
+	c.lock.Lock()
+	if c.connection == nil {
+		c.lock.Unlock()
+		admin.Close()
+		return nil, nil, cerr.NewInvalidStateError("", "NOT_OPEN", "Connection was closed")
+	}
+	if c.adminClient != nil {
+		// Another caller created the clients meanwhile
+		sharedClient, sharedAdmin := c.client, c.adminClient
+		c.lock.Unlock()
+		admin.Close()
+		return sharedClient, sharedAdmin, nil
+	}
+	c.client = client
+	c.adminClient = admin
+	c.lock.Unlock()
+	return client, admin, nil
+}
+
+//	Reads a list of registered queue names.
+//	If connection doesn't support this function returnes an empty list.
+//	Returns queue names.
+func (c *KafkaConnection) ReadQueueNames() ([]string, error) {
+	client, _, err := c.connectToAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	if names, ok := c.metadata.getNames(); ok {
+		return names, nil
+	}
+
+	// Cached names are read from brokers, not from the client metadata refreshed in the background
+	if c.metadata.isEnabled() {
+		err = client.RefreshMetadata()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	topics, err := client.Topics()
+	if err != nil {
+		return nil, err
+	}
+
+	// Return only topics with the configured prefix and suffix
+	names := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		if c.hideInternal && IsInternalTopic(topic) {
+			continue
+		}
+		if name, ok := c.unresolveTopic(topic); ok {
+			names = append(names, name)
+		}
+	}
+
+	if c.metadata.isEnabled() {
+		c.metadata.setNames(names)
+	}
+	return names, nil
+}
+
+//	Reads a sorted page of queue names selected by a filter. On clusters with many topics
+//	it avoids passing full lists of names to callers that need only some of them.
+//	Parameters:
+//		- filter *KafkaTopicFilter	(optional) a filter of names, nil to select all
+//		- skip int	a number of selected names to skip
+//		- take int	a maximum number of returned names, 0 for all
+//	Returns: queue names or error.
+func (c *KafkaConnection) ReadQueueNamesByFilter(filter *KafkaTopicFilter, skip int, take int) ([]string, error) {
+	names, err := c.ReadQueueNames()
+	if err != nil {
+		return nil, err
+	}
+	return filter.Select(names, skip, take)
+}
+
+//	Reads partition indexes of a topic.
+//	Parameters:
+//		- name string	a topic name
+//	Returns: partition indexes or error.
+func (c *KafkaConnection) ReadPartitions(name string) ([]int32, error) {
+	client, _, err := c.connectToAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	topic := c.ResolveTopic(name)
+	if !c.metadata.isEnabled() {
+		return client.Partitions(topic)
+	}
+
+	if partitions, err, ok := c.metadata.getPartitions(topic); ok {
+		return partitions, err
+	}
+
+	err = client.RefreshMetadata(topic)
+	var partitions []int32
+	if err == nil {
+		partitions, err = client.Partitions(topic)
+	}
+	// Missing topics are cached too, other errors are not
+	if err == nil || errors.Is(err, kafka.ErrUnknownTopicOrPartition) {
+		c.metadata.setPartitions(topic, partitions, err)
+	}
+	return partitions, err
+}
+
+//	Invalidates cached queue names and partitions, so they are read from brokers on the next call.
+//	Use it when topics are created, deleted or extended by other clients.
+//	Parameters:
+//		- names ...string	names of queues to invalidate, none to invalidate all of them
+func (c *KafkaConnection) Invalidate(names ...string) {
+	topics := make([]string, len(names))
+	for i, name := range names {
+		topics[i] = c.ResolveTopic(name)
+	}
+	c.metadata.invalidate(topics...)
+}
+
+//	Creates a message queue.
+//	The topic is created with the configured number of partitions, replication factor,
+//	retention, cleanup policy and minimum in-sync replicas.
+//	If connection doesn't support this function it exists without error.
+//	Parameters:
+//		- name string	the name of the queue to be created.
+//	Returns: the name of the queue to be created.
+func (c *KafkaConnection) CreateQueue(name string) error {
+	err := c.checkOpen()
+	if err != nil {
+		return err
+	}
+
+	_, admin, err := c.connectToAdmin()
+	if err != nil {
+		return err
+	}
+
+	topic := c.ResolveTopic(name)
+	err = c.checkWritable(topic)
+	if err != nil {
+		return err
+	}
+
+	err = admin.CreateTopic(topic, &kafka.TopicDetail{
+		NumPartitions:     int32(c.numPartitions),
+		ReplicationFactor: int16(c.replicationFactor),
+		ConfigEntries:     c.topicConfig,
+	}, false)
+
+	c.metadata.invalidate(topic)
+	return err
+}
+
+//	Reads differences between the live topic and its declared configuration:
+//	the number of partitions, the replication factor and the configured topic entries.
+//	Parameters:
+//		- name string	the name of the queue to be checked.
+//	Returns: human-readable differences, empty when the topic matches, or error.
+func (c *KafkaConnection) ReadQueueDrift(name string) ([]string, error) {
+	err := c.checkOpen()
+	if err != nil {
+		return nil, err
+	}
+
+	_, admin, err := c.connectToAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	topic := c.ResolveTopic(name)
+	drift := make([]string, 0)
+
+	metadata, err := admin.DescribeTopics([]string{topic})
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata) == 1 && metadata[0].Err == kafka.ErrNoError {
+		partitions := metadata[0].Partitions
+		if len(partitions) != c.numPartitions {
+			drift = append(drift, fmt.Sprintf("partitions: %d, declared: %d",
+				len(partitions), c.numPartitions))
+		}
+		if len(partitions) > 0 && len(partitions[0].Replicas) != c.replicationFactor {
+			drift = append(drift, fmt.Sprintf("replication factor: %d, declared: %d",
+				len(partitions[0].Replicas), c.replicationFactor))
+		}
+	} else if len(metadata) == 1 {
+		return nil, metadata[0].Err
+	}
+
+	if len(c.topicConfig) == 0 {
+		return drift, nil
+	}
+
+	entries, err := admin.DescribeConfig(kafka.ConfigResource{
+		Type: kafka.TopicResource,
+		Name: topic,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		values[entry.Name] = entry.Value
+	}
+	for entry, value := range c.topicConfig {
+		if values[entry] != *value {
+			drift = append(drift, fmt.Sprintf("%s: %s, declared: %s", entry, values[entry], *value))
+		}
+	}
+	sort.Strings(drift)
+
+	return drift, nil
+}
+
+//	Alters a topic to match its declared configuration.
+//	Missing partitions are added and configured topic entries are updated.
+//	Kafka can't reduce the number of partitions or change the replication factor
+//	of existing topics, so these differences remain.
+//	Parameters:
+//		- name string	the name of the queue to be altered.
+func (c *KafkaConnection) AlignQueue(name string) error {
+	err := c.checkOpen()
+	if err != nil {
+		return err
+	}
+
+	client, admin, err := c.connectToAdmin()
+	if err != nil {
+		return err
+	}
+
+	topic := c.ResolveTopic(name)
+	err = c.checkWritable(topic)
+	if err != nil {
+		return err
+	}
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return err
+	}
+	if len(partitions) < c.numPartitions {
+		err = admin.CreatePartitions(topic, int32(c.numPartitions), nil, false)
+		c.metadata.invalidate(topic)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(c.topicConfig) == 0 {
+		return nil
+	}
+
+	// Incremental updates need the client to speak Kafka 2.3
+	if !client.Config().Version.IsAtLeast(kafka.V2_3_0_0) {
+		return c.alterTopicConfig(admin, topic)
+	}
+
+	entries := make(map[string]kafka.IncrementalAlterConfigsEntry, len(c.topicConfig))
+	for entry, value := range c.topicConfig {
+		entries[entry] = kafka.IncrementalAlterConfigsEntry{
+			Operation: kafka.IncrementalAlterConfigsOperationSet,
+			Value:     value,
+		}
+	}
+	return admin.IncrementalAlterConfig(kafka.TopicResource, topic, entries, false)
+}
+
+// Sets declared config entries of a topic with a legacy AlterConfigs request.
+// The request replaces all entries overridden for the topic, so the current overrides are sent as well.
+func (c *KafkaConnection) alterTopicConfig(admin kafka.ClusterAdmin, topic string) error {
+	current, err := admin.DescribeConfig(kafka.ConfigResource{
+		Type: kafka.TopicResource,
+		Name: topic,
+	})
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[string]*string, len(current)+len(c.topicConfig))
+	for _, entry := range current {
+		// Values of sensitive entries are not returned, so they can't be kept
+		if entry.Default || entry.ReadOnly || entry.Sensitive ||
+			(entry.Source != kafka.SourceTopic && entry.Source != kafka.SourceUnknown) {
+			continue
+		}
+		value := entry.Value
+		entries[entry.Name] = &value
+	}
+	for entry, value := range c.topicConfig {
+		entries[entry] = value
+	}
+
+	return admin.AlterConfig(kafka.TopicResource, topic, entries, false)
+}
+
+//	Deletes a message queue.
+//	If connection doesn't support this function it exists without error.
+//	Parameters:
+//		- name string	the name of the queue to be deleted.
+func (c *KafkaConnection) DeleteQueue(name string) error {
+	err := c.checkOpen()
+	if err != nil {
+		return err
+	}
+
+	_, admin, err := c.connectToAdmin()
+	if err != nil {
+		return err
+	}
+
+	topic := c.ResolveTopic(name)
+	err = c.checkWritable(topic)
+	if err != nil {
+		return err
+	}
+
+	defer c.metadata.invalidate(topic)
+	return admin.DeleteTopic(topic)
+}
+
+//	Increases the number of partitions of a topic.
+//	Kafka can't reduce the number of partitions, and keys of existing records
+//	are mapped to other partitions after the increase.
+//	Parameters:
+//		- name string	a topic name
+//		- count int	a new number of partitions
+//	Returns: error or nil for success.
+func (c *KafkaConnection) CreatePartitions(name string, count int) error {
+	err := c.checkOpen()
+	if err != nil {
+		return err
+	}
+
+	_, admin, err := c.connectToAdmin()
+	if err != nil {
+		return err
+	}
+
+	topic := c.ResolveTopic(name)
+	err = c.checkWritable(topic)
+	if err != nil {
+		return err
+	}
+
+	defer c.metadata.invalidate(topic)
+	return admin.CreatePartitions(topic, int32(count), nil, false)
+}
+
+//	Reads lags of a consumer group on a topic.
+//	The lag of a partition is a difference between its high watermark and the committed offset.
+//	Partitions without committed offsets have no lag since the group starts reading them
+//	from the latest offset.
+//	Parameters:
+//		- topic string	a topic name
+//		- groupId string	a consumer group id
+//		- partitions []int32	(optional) partitions to be read (default: all)
+//	Returns: lags by partition indexes or error.
+func (c *KafkaConnection) ReadLags(topic string, groupId string, partitions []int32) (map[int32]int64, error) {
+	client, admin, err := c.connectToAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	topic = c.ResolveTopic(topic)
+
+	if len(partitions) == 0 {
+		partitions, err = client.Partitions(topic)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	offsets, err := admin.ListConsumerGroupOffsets(groupId, map[string][]int32{topic: partitions})
+	if err != nil {
+		return nil, err
+	}
+
+	lags := make(map[int32]int64, len(partitions))
+	for _, partition := range partitions {
+		lags[partition] = 0
+
+		block := offsets.GetBlock(topic, partition)
+		if block == nil || block.Offset < 0 {
+			continue
+		}
+		if block.Err != kafka.ErrNoError {
+			return nil, block.Err
+		}
+
+		highWatermark, err := client.GetOffset(topic, partition, kafka.OffsetNewest)
+		if err != nil {
+			return nil, err
+		}
+
+		if highWatermark > block.Offset {
+			lags[partition] = highWatermark - block.Offset
+		}
+	}
+
+	return lags, nil
+}
+
+//	Describes a consumer group with its coordinator broker and live members with their assignments.
+//	Topic names in the assignments are converted back from names used in Kafka.
+//	Parameters:
+//		- groupId string	a consumer group id
+//	Returns: the group description or error.
+func (c *KafkaConnection) DescribeGroup(groupId string) (*KafkaGroupDescription, error) {
+	client, admin, err := c.connectToAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	coordinator, err := client.Coordinator(groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := admin.DescribeConsumerGroups([]string{groupId})
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return nil, cerr.NewNotFoundError("", "GROUP_NOT_FOUND", "Consumer group "+groupId+" was not found").
+			WithDetails("group", groupId)
+	}
+	group := groups[0]
+	if group.Err != kafka.ErrNoError {
+		return nil, group.Err
+	}
+
+	description := &KafkaGroupDescription{
+		GroupId:       group.GroupId,
+		State:         group.State,
+		Protocol:      group.Protocol,
+		CoordinatorId: coordinator.ID(),
+		Coordinator:   coordinator.Addr(),
+		Members:       make([]*KafkaGroupMember, 0, len(group.Members)),
+	}
+	for memberId, member := range group.Members {
+		assignment, err := member.GetMemberAssignment()
+		if err != nil {
+			return nil, err
+		}
+
+		groupMember := &KafkaGroupMember{
+			MemberId:    memberId,
+			ClientId:    member.ClientId,
+			ClientHost:  member.ClientHost,
+			Assignments: make(map[string][]int32),
+		}
+		if assignment != nil {
+			for topic, partitions := range assignment.Topics {
+				if name, ok := c.unresolveTopic(topic); ok {
+					topic = name
+				}
+				groupMember.Assignments[topic] = partitions
+			}
+		}
+		description.Members = append(description.Members, groupMember)
+	}
+	sort.Slice(description.Members, func(i, j int) bool {
+		return description.Members[i].MemberId < description.Members[j].MemberId
+	})
+
+	return description, nil
+}
+
+//	Deletes a consumer group with its committed offsets.
+//	The group must have no active members.
+//	Parameters:
+//		- groupId string	a consumer group id
+//	Returns: error or nil for success.
+func (c *KafkaConnection) DeleteGroup(groupId string) error {
+	err := c.checkOpen()
+	if err != nil {
+		return err
+	}
+
+	_, admin, err := c.connectToAdmin()
+	if err != nil {
+		return err
+	}
+
+	return admin.DeleteConsumerGroup(groupId)
+}
+
+//	Reads earliest and latest offsets of all partitions of a topic.
+//	Parameters:
+//		- topic string	a topic name
+//	Returns: offset ranges by partition indexes or error.
+func (c *KafkaConnection) ListOffsets(topic string) (map[int32]*KafkaPartitionOffsets, error) {
+	client, _, err := c.connectToAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	topic = c.ResolveTopic(topic)
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[int32]*KafkaPartitionOffsets, len(partitions))
+	for _, partition := range partitions {
+		earliest, err := client.GetOffset(topic, partition, kafka.OffsetOldest)
+		if err != nil {
+			return nil, err
+		}
+		latest, err := client.GetOffset(topic, partition, kafka.OffsetNewest)
+		if err != nil {
+			return nil, err
+		}
+		offsets[partition] = &KafkaPartitionOffsets{Earliest: earliest, Latest: latest}
+	}
+
+	return offsets, nil
+}
+
+//	Reads messages from a topic without consuming them.
+//	Messages are read by a separate consumer outside of the consumer group
+//	starting from the committed offsets. Partitions without committed offsets
+//	are read from their latest messages. The offsets are never committed.
+//	Partitions are grouped by their leaders and fetched by one goroutine per broker,
+//	so reading from large clusters is not limited by serial requests to every partition.
+//	Messages are returned in the order of partitions.
+//	Parameters:
+//		- topic string	a topic name
+//		- groupId string	a consumer group id
+//		- partitions []int32	(optional) partitions to be read (default: all)
+//		- maxCount int	a maximum number of messages to read
+//	Returns: read messages or error.
+func (c *KafkaConnection) PeekMessages(topic string, groupId string, partitions []int32, maxCount int) ([]*kafka.ConsumerMessage, error) {
+	client, admin, err := c.connectToAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	topic = c.ResolveTopic(topic)
+
+	if len(partitions) == 0 {
+		partitions, err = client.Partitions(topic)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	offsets, err := admin.ListConsumerGroupOffsets(groupId, map[string][]int32{topic: partitions})
+	if err != nil {
+		return nil, err
+	}
+
+	consumer, err := kafka.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, err
+	}
+	defer consumer.Close()
+
+	// Partitions without known leaders are fetched together by a separate goroutine
+	brokerPartitions := make(map[int32][]int32)
+	for _, partition := range partitions {
+		brokerId := int32(-1)
+		if leader, err := client.Leader(topic, partition); err == nil {
+			brokerId = leader.ID()
+		}
+		brokerPartitions[brokerId] = append(brokerPartitions[brokerId], partition)
+	}
+
+	var lock sync.Mutex
+	var workers sync.WaitGroup
+	var fetchErr error
+	results := make(map[int32][]*kafka.ConsumerMessage, len(partitions))
+
+	for _, fetched := range brokerPartitions {
+		workers.Add(1)
+		go func(fetched []int32) {
+			defer workers.Done()
+
+			count := 0
+			for _, partition := range fetched {
+				if count >= maxCount {
+					return
+				}
+
+				messages, err := c.peekCommittedPartition(client, consumer, topic, partition,
+					offsets.GetBlock(topic, partition), maxCount-count)
+
+				lock.Lock()
+				if err != nil && fetchErr == nil {
+					fetchErr = err
+				}
+				results[partition] = messages
+				lock.Unlock()
+
+				if err != nil {
+					return
+				}
+				count += len(messages)
+			}
+		}(fetched)
+	}
+	workers.Wait()
+
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	messages := []*kafka.ConsumerMessage{}
+	for _, partition := range partitions {
+		for _, message := range results[partition] {
+			if len(messages) >= maxCount {
+				return messages, nil
+			}
+			messages = append(messages, message)
+		}
+	}
+
+	return messages, nil
+}
+
+//	Reads offsets of topic partitions by time.
+//	Parameters:
+//		- topic string	a topic name
+//		- partitions []int32	(optional) partitions to be read (default: all)
+//		- time int64	time in Unix milliseconds to get the first offsets with later timestamps,
+//			kafka.OffsetNewest to get the ends of partitions or kafka.OffsetOldest to get their beginnings
+//	Returns: offsets by partitions or error. Partitions without later messages have their end offsets.
+func (c *KafkaConnection) ReadOffsets(topic string, partitions []int32, time int64) (map[int32]int64, error) {
+	client, _, err := c.connectToAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	topic = c.ResolveTopic(topic)
+
+	if len(partitions) == 0 {
+		partitions, err = client.Partitions(topic)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	offsets := make(map[int32]int64, len(partitions))
+	for _, partition := range partitions {
+		offset, err := client.GetOffset(topic, partition, time)
+		if err != nil {
+			return nil, err
+		}
+
+		// No messages after the time
+		if offset < 0 {
+			offset, err = client.GetOffset(topic, partition, kafka.OffsetNewest)
+			if err != nil {
+				return nil, err
+			}
+		}
+		offsets[partition] = offset
+	}
+
+	return offsets, nil
+}
+
+//	Deletes records of topic partitions before offsets. Deleted records
+//	are not returned to consumers and the earliest offsets of the partitions move forward.
+//	Parameters:
+//		- topic string	a topic name
+//		- offsets map[int32]int64	offsets by partitions before which records are deleted
+//	Returns: error or nil for success.
+func (c *KafkaConnection) DeleteRecords(topic string, offsets map[int32]int64) error {
+	err := c.checkOpen()
+	if err != nil {
+		return err
+	}
+
+	_, admin, err := c.connectToAdmin()
+	if err != nil {
+		return err
+	}
+
+	topic = c.ResolveTopic(topic)
+	err = c.checkWritable(topic)
+	if err != nil {
+		return err
+	}
+
+	return admin.DeleteRecords(topic, offsets)
+}
+
+//	Reads messages of a topic partition starting from the offset up to the end of the partition.
+//	Messages are read by a separate consumer outside of consumer groups.
+//	Parameters:
+//		- topic string	a topic name
+//		- partition int32	a partition number
+//		- offset int64	an offset to start from or kafka.OffsetOldest to read the partition from the beginning
+//		- maxCount int	a maximum number of messages to read
+//	Returns: read messages or error.
+func (c *KafkaConnection) ReadMessages(topic string, partition int32, offset int64, maxCount int) ([]*kafka.ConsumerMessage, error) {
+	client, _, err := c.connectToAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	topic = c.ResolveTopic(topic)
+
+	highWatermark, err := client.GetOffset(topic, partition, kafka.OffsetNewest)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 {
+		offset, err = client.GetOffset(topic, partition, kafka.OffsetOldest)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if highWatermark <= offset {
+		return []*kafka.ConsumerMessage{}, nil
+	}
+
+	consumer, err := kafka.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, err
+	}
+	defer consumer.Close()
+
+	return c.peekPartition(consumer, topic, partition, offset, highWatermark, maxCount)
+}
+
+//	Exports committed offsets of a consumer group on a topic.
+//	Parameters:
+//		- topic string	a topic name
+//		- groupId string	a consumer group id
+//	Returns: a snapshot of committed offsets or error.
+func (c *KafkaConnection) ExportOffsets(topic string, groupId string) (*KafkaOffsetSnapshot, error) {
+	client, admin, err := c.connectToAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := NewKafkaOffsetSnapshot(topic, groupId)
+	topic = c.ResolveTopic(topic)
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets, err := admin.ListConsumerGroupOffsets(groupId, map[string][]int32{topic: partitions})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, partition := range partitions {
+		block := offsets.GetBlock(topic, partition)
+		if block == nil {
+			continue
+		}
+		if block.Err != kafka.ErrNoError {
+			return nil, block.Err
+		}
+		if block.Offset >= 0 {
+			snapshot.Offsets[partition] = block.Offset
+			if block.Metadata != "" {
+				snapshot.Metadata[partition] = block.Metadata
+			}
+		}
+	}
+
+	return snapshot, nil
+}
+
+//	Imports committed offsets of a consumer group on a topic.
+//	Offsets can be moved backward and forward. The consumer group must have no active members,
+//	otherwise the broker rejects the commit.
+//	Parameters:
+//		- snapshot *KafkaOffsetSnapshot	a snapshot of committed offsets
+//	Returns: error or nil no errors occured.
+func (c *KafkaConnection) ImportOffsets(snapshot *KafkaOffsetSnapshot) error {
+	client, _, err := c.connectToAdmin()
+	if err != nil {
+		return err
+	}
+
+	topic := c.ResolveTopic(snapshot.Topic)
+
+	manager, err := kafka.NewOffsetManagerFromClient(snapshot.GroupId, client)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	for partition, offset := range snapshot.Offsets {
+		partitionManager, err := manager.ManagePartition(topic, partition)
+		if err != nil {
+			return err
+		}
+		// Marking moves the offset forward and resetting moves it backward
+		partitionManager.MarkOffset(offset, snapshot.Metadata[partition])
+		partitionManager.ResetOffset(offset, snapshot.Metadata[partition])
+	}
+	manager.Commit()
+
+	// Commit errors are not returned by the offset manager, so the offsets are read back
+	imported, err := c.ExportOffsets(snapshot.Topic, snapshot.GroupId)
+	if err != nil {
+		return err
+	}
+	for partition, offset := range snapshot.Offsets {
+		if imported.Offsets[partition] != offset {
+			return cerr.NewConflictError(
+				"",
+				"IMPORT_FAILED",
+				"Failed to import offsets of group "+snapshot.GroupId+" on topic "+topic+
+					", the group may have active members",
+			).WithDetails("partition", partition)
+		}
+	}
+
+	return nil
+}
+
+// Reads messages of a partition from the committed offset up to the end of the partition
+func (c *KafkaConnection) peekCommittedPartition(client kafka.Client, consumer kafka.Consumer, topic string, partition int32,
+	block *kafka.OffsetFetchResponseBlock, maxCount int) ([]*kafka.ConsumerMessage, error) {
+
+	highWatermark, err := client.GetOffset(topic, partition, kafka.OffsetNewest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Partitions without committed offsets are read from their latest messages
+	offset := int64(-1)
+	if block != nil {
+		offset = block.Offset
+	}
+	if offset < 0 {
+		oldest, err := client.GetOffset(topic, partition, kafka.OffsetOldest)
+		if err != nil {
+			return nil, err
+		}
+		offset = highWatermark - int64(maxCount)
+		if offset < oldest {
+			offset = oldest
+		}
+	}
+
+	if highWatermark <= offset {
+		return nil, nil
+	}
+
+	return c.peekPartition(consumer, topic, partition, offset, highWatermark, maxCount)
+}
+
+func (c *KafkaConnection) peekPartition(consumer kafka.Consumer, topic string, partition int32,
+	offset int64, highWatermark int64, maxCount int) ([]*kafka.ConsumerMessage, error) {
+
+	partitionConsumer, err := consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer partitionConsumer.Close()
+
+	timeout := time.After(time.Millisecond * time.Duration(c.requestTimeout))
+	messages := []*kafka.ConsumerMessage{}
+	for len(messages) < maxCount {
+		select {
+		case msg := <-partitionConsumer.Messages():
+			messages = append(messages, msg)
+			if msg.Offset >= highWatermark-1 {
+				return messages, nil
+			}
+		case err := <-partitionConsumer.Errors():
+			return nil, err
+		case <-timeout:
+			return messages, nil
+		}
+	}
+
+	return messages, nil
+}
+
+func (c *KafkaConnection) checkOpen() error {
+	if c.IsOpen() {
+		return nil
+	}
+
+	return cerr.NewInvalidStateError(
+		"",
+		"NOT_OPEN",
+		"Connection was not opened",
+	)
+}
+
+// Checks that the topic can be changed, refusing internal topics unless writes to them are allowed
+func (c *KafkaConnection) checkWritable(topic string) error {
+	if c.writeInternal || !IsInternalTopic(topic) {
+		return nil
+	}
+
+	return cerr.NewBadRequestError(
+		"",
+		"INTERNAL_TOPIC",
+		"Writes to internal topic "+topic+" are not allowed",
+	).WithDetails("topic", topic)
+}
+
+//	Publish a message to a specified topic.
+//	When the context has a deadline, publishing fails with SEND_TIMEOUT as soon as it passes,
+//	independently of the produce timeouts of the connection.
+//
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- topic a topic name
+//		- messages messages to be published
+//	Returns: error or nil for success
+func (c *KafkaConnection) Publish(ctx context.Context, topic string, messages []*kafka.ProducerMessage) error {
+	// Check for open connection
+	err := c.checkOpen()
+	if err != nil {
+		return err
+	}
+
+	// Assign topic to messages
+	topic = c.ResolveTopic(topic)
+	err = c.checkWritable(topic)
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		message.Topic = topic
+	}
+
+	// Connections of quiet producers may be silently dropped by load balancers
+	if c.isIdle() {
+		c.reconnect(ctx)
+	}
+
+	// Give throttled brokers time to recover instead of sending into the quota delay
+	if c.throttleSlowdown {
+		err = c.waitThrottle(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	err = c.sendMessages(ctx, messages)
+
+	// Retry once with a new producer when brokers are unreachable
+	if err != nil && isBrokerUnavailable(err) && c.reconnect(ctx) {
+		start = time.Now()
+		err = c.sendMessages(ctx, messages)
+	}
+
+	// Send messages failed on transient errors again until the delivery timeout
+	if err != nil && c.deliveryTimeout > 0 {
+		err = c.redeliver(ctx, start, err)
+	}
+
+	if err != nil {
+		c.recordFailure(ctx)
+		return err
+	}
+
+	c.recordSuccess()
+	c.lock.Lock()
+	c.publishedAt = time.Now()
+	c.lock.Unlock()
+	c.recordProduceTime(messages, time.Since(start))
+	return nil
+}
+
+// Sends messages and stops waiting for acknowledgements when the context deadline passes.
+// Messages of timed out sends can still be delivered, since produce requests cannot be canceled.
+func (c *KafkaConnection) sendMessages(ctx context.Context, messages []*kafka.ProducerMessage) error {
+	producer := c.producer()
+	if _, ok := ctx.Deadline(); !ok || len(messages) == 0 {
+		return producer.SendMessages(messages)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- producer.SendMessages(messages)
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return cerr.NewInvocationError("", "SEND_TIMEOUT", "Sending messages to "+messages[0].Topic+" timed out").
+			WithCause(ctx.Err())
+	}
+}
+
+// Sends messages failed on transient errors again with backoff until the delivery timeout expires
+func (c *KafkaConnection) redeliver(ctx context.Context, start time.Time, err error) error {
+	deadline := start.Add(time.Millisecond * time.Duration(c.deliveryTimeout))
+	backoff := time.Millisecond * time.Duration(c.retryBackoff)
+
+	for isTransientProduceError(err) {
+		failed := []*kafka.ProducerMessage{}
+		for _, producerErr := range err.(kafka.ProducerErrors) {
+			failed = append(failed, producerErr.Msg)
+		}
+
+		wait := backoff
+		if remaining := time.Until(deadline); remaining < wait {
+			wait = remaining
+		}
+		if wait <= 0 {
+			return err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+
+		c.Logger.Debug(ctx, "", "Redelivering %d messages failed on transient errors: %s", len(failed), err)
+		err = c.sendMessages(ctx, failed)
+		// Back off exponentially up to the retry timeout
+		backoff *= 2
+		if maxBackoff := time.Millisecond * time.Duration(c.retryTimeout); backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+	return err
+}
+
+// Checks if all messages failed on errors that disappear after leader elections or broker restarts
+func isTransientProduceError(err error) bool {
+	errs, ok := err.(kafka.ProducerErrors)
+	if !ok || len(errs) == 0 {
+		return false
+	}
+
+	for _, producerErr := range errs {
+		if producerErr.Msg == nil || !isBrokerUnavailable(producerErr.Err) &&
+			!errors.Is(producerErr.Err, kafka.ErrNotLeaderForPartition) &&
+			!errors.Is(producerErr.Err, kafka.ErrLeaderNotAvailable) &&
+			!errors.Is(producerErr.Err, kafka.ErrRequestTimedOut) &&
+			!errors.Is(producerErr.Err, kafka.ErrNotEnoughReplicas) &&
+			!errors.Is(producerErr.Err, kafka.ErrNotEnoughReplicasAfterAppend) &&
+			!errors.Is(producerErr.Err, kafka.ErrKafkaStorageError) {
+			return false
+		}
+	}
+	return true
+}
+
+// Records throttle time reported by a broker that enforces client quotas
+func (c *KafkaConnection) onThrottle(brokerId int32, throttleTime time.Duration) {
+	ctx := context.Background()
+	c.Counters.IncrementOne(ctx, "connection.throttled")
+	c.Counters.EndTiming(ctx, fmt.Sprintf("connection.broker.%d.throttle_time", brokerId),
+		float64(throttleTime.Milliseconds()))
+	c.Metrics.Increment(MetricConnectionThrottled, brokerMetricLabels(brokerId), 1)
+	c.Metrics.Observe(MetricConnectionThrottleTime, brokerMetricLabels(brokerId), float64(throttleTime.Milliseconds()))
+	c.Logger.Debug(ctx, "", "Broker %d throttled the producer for %v", brokerId, throttleTime)
+
+	c.throttleLock.Lock()
+	defer c.throttleLock.Unlock()
+	until := time.Now().Add(throttleTime)
+	if until.After(c.throttledUntil) {
+		c.throttledUntil = until
+	}
+}
+
+//	Checks if brokers currently throttle the producer for exceeding quotas.
+//	Returns: true if the last reported throttle time has not passed yet.
+func (c *KafkaConnection) IsThrottled() bool {
+	c.throttleLock.Lock()
+	defer c.throttleLock.Unlock()
+	return time.Now().Before(c.throttledUntil)
+}
+
+// Waits until the reported throttle time passes or the context is done
+func (c *KafkaConnection) waitThrottle(ctx context.Context) error {
+	c.throttleLock.Lock()
+	wait := time.Until(c.throttledUntil)
+	c.throttleLock.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reports produce time to the leaders of written partitions.
+// Leaders are known only when the admin client has read the cluster metadata.
+func (c *KafkaConnection) recordProduceTime(messages []*kafka.ProducerMessage, elapsed time.Duration) {
+	c.lock.Lock()
+	client := c.client
+	c.lock.Unlock()
+	if client == nil {
+		return
+	}
+
+	brokers := map[int32]bool{}
+	for _, message := range messages {
+		leader, err := client.Leader(message.Topic, message.Partition)
+		if err != nil || brokers[leader.ID()] {
+			continue
+		}
+		brokers[leader.ID()] = true
+		c.Counters.EndTiming(context.Background(), fmt.Sprintf("connection.broker.%d.produce_rtt", leader.ID()),
+			float64(elapsed.Milliseconds()))
+		c.Metrics.Observe(MetricConnectionProduceRtt, brokerMetricLabels(leader.ID()), float64(elapsed.Milliseconds()))
+	}
+}
+
+//	Subscribe to a topic
+//
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- topic a subject (topic) name
+//		- groupId (optional) a consumer group id
+//		- config consumer configuration parameters
+//		- listener a message listener
+//	Returns: err or nil for success
+func (c *KafkaConnection) Subscribe(ctx context.Context, topic string, groupId string, config *kafka.Config, listener IKafkaMessageListener) error {
+	// Check for open connection
+	err := c.checkOpen()
+	if err != nil {
+		return err
+	}
+
+	if c.sharedConsumer {
+		return c.subscribeShared(ctx, topic, groupId, config, listener)
+	}
+
+	subscription := &KafkaSubscription{
+		Topic:    topic,
+		GroupId:  groupId,
+		Listener: listener,
+		config:   config,
+	}
+	return c.startSubscription(ctx, subscription, config, listener.Ready())
+}
+
+// Adds a topic to the consumer shared by the group.
+// The shared consumer rejoins the group to receive claims of the added topic.
+// The consumer configuration is taken from the first subscription of the group.
+func (c *KafkaConnection) subscribeShared(ctx context.Context, topic string, groupId string, config *kafka.Config, listener IKafkaMessageListener) error {
+	c.sharedLock.Lock()
+	defer c.sharedLock.Unlock()
+
+	resolvedTopic := c.ResolveTopic(topic)
+
+	c.lock.Lock()
+	var subscription *KafkaSubscription
+	for _, item := range c.subscriptions {
+		if item.shared != nil && item.GroupId == groupId {
+			subscription = item
+			break
+		}
+	}
+	c.lock.Unlock()
+
+	// Start a shared consumer for the first topic of the group
+	if subscription == nil {
+		shared := newKafkaSharedListener()
+		shared.add(resolvedTopic, listener)
+		subscription = &KafkaSubscription{
+			GroupId:  groupId,
+			Listener: shared,
+			shared:   shared,
+			config:   config,
+		}
+		return c.startSubscription(ctx, subscription, config, listener.Ready())
+	}
+
+	// Claims of a topic are passed to one listener, so the topic can't be consumed twice by the group
+	current := subscription.shared.listener(resolvedTopic)
+	if current == listener {
+		return nil
+	}
+	if current != nil {
+		return cerr.NewConflictError("", "ALREADY_SUBSCRIBED",
+			"Topic "+topic+" is already consumed by another listener of the shared consumer of group "+groupId).
+			WithDetails("topic", topic).WithDetails("group", groupId)
+	}
+
+	// The ready channel is taken after the listener is added,
+	// so renewals of the shared listener don't replace it
+	subscription.shared.add(resolvedTopic, listener)
+	ready := listener.Ready()
+	subscription.restart()
+
+	timer := time.NewTimer(time.Millisecond * time.Duration(c.requestTimeout))
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		subscription.shared.remove(resolvedTopic)
+		subscription.restart()
+		return cerr.NewConnectionError("", "CONSUME_FAILED", "Shared Kafka consumer did not start consuming topic "+topic)
+	}
+}
+
+// Starts consuming by a new subscription and waits until the consumer is ready
+func (c *KafkaConnection) startSubscription(ctx context.Context, subscription *KafkaSubscription, config *kafka.Config, ready chan bool) error {
+	brokers, consumerConfig, err := c.createConsumerConfig(config)
+	if err != nil {
+		return err
+	}
+
+	uri := strings.Join(brokers, ",")
+
+	consumer, err := kafka.NewConsumerGroup(brokers, subscription.GroupId, consumerConfig)
+	if err != nil {
+		c.Logger.Error(ctx, "", err, "Failed to connect Kafka consumer at "+uri)
+		return err
+	}
+
+	// The consumer lifetime is controlled by the subscription, not by the caller context
+	consumeCtx, cancel := context.WithCancel(context.Background())
+	subscription.Handler = &consumer
+	subscription.cancel = cancel
+
+	stopped := make(chan error, 1)
+
+	c.logConsumerErrors(consumeCtx, subscription, consumer)
+
+	// Consume messages in a separate thread
+	subscription.workers.Add(1)
+	go func() {
+		defer subscription.workers.Done()
+		err := c.consume(consumeCtx, subscription, ready)
+		stopped <- err
+	}()
+
+	// Wait consumer start
+	select {
+	case <-ready:
+	case err = <-stopped:
+		subscription.close()
+		if err == nil {
+			err = cerr.NewConnectionError("", "CONSUME_FAILED", "Kafka consumer stopped before start")
+		}
+		return err
+	}
+
+	// Add the subscription
+	c.lock.Lock()
+	c.subscriptions = append(c.subscriptions, subscription)
+	c.lock.Unlock()
+
+	return nil
+}
+
+// Creates configuration of a consumer on the active cluster with settings of the subscription
+func (c *KafkaConnection) createConsumerConfig(config *kafka.Config) ([]string, *kafka.Config, error) {
+	brokers, consumerConfig, err := c.createConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	consumerConfig.Consumer.Offsets.AutoCommit.Enable = config.Consumer.Offsets.AutoCommit.Enable
+	consumerConfig.Consumer.Offsets.Initial = config.Consumer.Offsets.Initial
+	consumerConfig.ChannelBufferSize = config.ChannelBufferSize
+	consumerConfig.Consumer.Return.Errors = true
+	return brokers, consumerConfig, nil
+}
+
+// Logs consumer errors and passes them to the listener until the consumer is closed
+func (c *KafkaConnection) logConsumerErrors(ctx context.Context, subscription *KafkaSubscription, consumer kafka.ConsumerGroup) {
+	errorListener, _ := subscription.Listener.(IKafkaErrorListener)
+
+	subscription.workers.Add(1)
+	go func() {
+		defer subscription.workers.Done()
+		for err := range consumer.Errors() {
+			c.Logger.Error(ctx, "", err, "Failed to consume messages from topic "+strings.Join(subscription.topics(), ","))
+			if errorListener != nil {
+				errorListener.OnError(err)
+			}
+		}
+	}()
+}
+
+// Consumes messages until the subscription is closed.
+// Consume returns on every rebalance, so it is called again.
+// When the consumer dies after it was started, it is recreated with exponential backoff
+// limited by the retry timeout. Errors before the start are returned to the caller.
+func (c *KafkaConnection) consume(ctx context.Context, subscription *KafkaSubscription, ready chan bool) error {
+	maxBackoff := time.Millisecond * time.Duration(c.retryTimeout)
+
+	for {
+		consumer := subscription.consumer()
+		topics := subscription.topics()
+		if subscription.shared == nil {
+			topics = []string{c.ResolveTopic(subscription.Topic)}
+		}
+		sessionCtx := subscription.beginSession(ctx)
+		err := consumer.Consume(sessionCtx, topics, subscription.Listener)
+
+		// check if consumer was stopped
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// Rejoin after rebalances, restarts of the session and replacements of the consumer on failover
+		if err == nil || sessionCtx.Err() != nil || (err == kafka.ErrClosedConsumerGroup && subscription.consumer() != consumer) {
+			subscription.Listener.SetReady(make(chan bool))
+			continue
+		}
+
+		if err == kafka.ErrClosedConsumerGroup {
+			return nil
+		}
+
+		c.Logger.Error(ctx, "", err, "Failed to consume messages from "+strings.Join(topics, ","))
+		c.recordFailure(ctx)
+
+		// Fail if the consumer has never started
+		select {
+		case <-ready:
+		default:
+			return err
+		}
+
+		// Recreate the consumer
+		backoff := time.Second
+		for {
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			c.Logger.Info(ctx, "", "Restarting Kafka consumer of %s in %s", strings.Join(topics, ","), backoff)
+
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-timer.C:
+			}
+
+			// Configuration is created again to follow failovers
+			brokers, config, err := c.createConsumerConfig(subscription.config)
+			if err == nil {
+				consumer, err = kafka.NewConsumerGroup(brokers, subscription.GroupId, config)
+			}
+			if err != nil {
+				c.Logger.Error(ctx, "", err, "Failed to restart Kafka consumer of "+strings.Join(topics, ","))
+				backoff *= 2
+				continue
+			}
+
+			if !subscription.replace(consumer) {
+				return nil
+			}
+			c.logConsumerErrors(ctx, subscription, consumer)
+			break
+		}
+
+		subscription.Listener.SetReady(make(chan bool))
+	}
+}
+
+//	Pauses fetching messages by a subscription while keeping the consumer group membership
+//
+//	Parameters:
+//		- topic a topic name
+//		- groupId (optional) a consumer group id
+//		- listener a message listener
+//	Returns: err or nil for success
+func (c *KafkaConnection) Pause(topic string, groupId string, listener IKafkaMessageListener) error {
+	subscription := c.findSubscription(topic, groupId, listener)
+	if subscription == nil {
+		return nil
+	}
+
+	// Pause only the topic of a shared consumer
+	if subscription.shared != nil {
+		partitions, err := c.ReadPartitions(topic)
+		if err != nil {
+			return err
+		}
+		subscription.consumer().Pause(map[string][]int32{c.ResolveTopic(topic): partitions})
+		return nil
+	}
+
+	subscription.consumer().PauseAll()
+	return nil
+}
+
+//	Resumes fetching messages by a previously paused subscription
+//
+//	Parameters:
+//		- topic a topic name
+//		- groupId (optional) a consumer group id
+//		- listener a message listener
+//	Returns: err or nil for success
+func (c *KafkaConnection) Resume(topic string, groupId string, listener IKafkaMessageListener) error {
+	subscription := c.findSubscription(topic, groupId, listener)
+	if subscription == nil {
+		return nil
+	}
+
+	if subscription.shared != nil {
+		partitions, err := c.ReadPartitions(topic)
+		if err != nil {
+			return err
+		}
+		subscription.consumer().Resume(map[string][]int32{c.ResolveTopic(topic): partitions})
+		return nil
+	}
+
+	subscription.consumer().ResumeAll()
+	return nil
+}
+
+//	Pauses fetching messages from partitions of a subscription while keeping other partitions consumed.
+//	Records already fetched from the partitions are still delivered.
+//
+//	Parameters:
+//		- topic a topic name
+//		- groupId (optional) a consumer group id
+//		- listener a message listener
+//		- partitions partitions to pause by names of received topics
+//	Returns: err or nil for success
+func (c *KafkaConnection) PausePartitions(topic string, groupId string, listener IKafkaMessageListener, partitions map[string][]int32) error {
+	subscription := c.findSubscription(topic, groupId, listener)
+	if subscription == nil {
+		return nil
+	}
+
+	subscription.consumer().Pause(partitions)
+	return nil
+}
+
+//	Resumes fetching messages from previously paused partitions of a subscription
+//
+//	Parameters:
+//		- topic a topic name
+//		- groupId (optional) a consumer group id
+//		- listener a message listener
+//		- partitions partitions to resume by names of received topics
+//	Returns: err or nil for success
+func (c *KafkaConnection) ResumePartitions(topic string, groupId string, listener IKafkaMessageListener, partitions map[string][]int32) error {
+	subscription := c.findSubscription(topic, groupId, listener)
+	if subscription == nil {
+		return nil
+	}
+
+	subscription.consumer().Resume(partitions)
+	return nil
+}
+
+func (c *KafkaConnection) findSubscription(topic string, groupId string, listener IKafkaMessageListener) *KafkaSubscription {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, subscription := range c.subscriptions {
+		if subscription.Topic == topic && subscription.GroupId == groupId && subscription.Listener == listener {
+			return subscription
+		}
+		if subscription.shared != nil && subscription.GroupId == groupId &&
+			subscription.shared.contains(c.ResolveTopic(topic), listener) {
+			return subscription
+		}
+	}
+	return nil
+}
+
+//	Unsubscribe from a previously subscribed topic topic
+//
 //	Parameters:
 //		- ctx context.Context	operation context
 //		- topic a topic name
@@ -442,8 +2681,13 @@ func (c *KafkaConnection) Subscribe(ctx context.Context, topic string, groupId s
 //		- listener a message listener
 //	Returns: err or nil for success
 func (c *KafkaConnection) Unsubscribe(ctx context.Context, topic string, groupId string, listener IKafkaMessageListener) error {
+	if c.sharedConsumer {
+		return c.unsubscribeShared(topic, groupId, listener)
+	}
+
 	// Remove the subscription
 	var removedSubscription *KafkaSubscription
+	c.lock.Lock()
 	for index, subscription := range c.subscriptions {
 		if subscription.Topic == topic && subscription.GroupId == groupId && subscription.Listener == listener {
 			removedSubscription = subscription
@@ -451,6 +2695,7 @@ func (c *KafkaConnection) Unsubscribe(ctx context.Context, topic string, groupId
 			break
 		}
 	}
+	c.lock.Unlock()
 
 	// If nothing to remove then skip
 	if removedSubscription == nil {
@@ -458,9 +2703,33 @@ func (c *KafkaConnection) Unsubscribe(ctx context.Context, topic string, groupId
 	}
 
 	// Unsubscribe from the topic
-	if removedSubscription.Handler != nil {
-		return (*removedSubscription.Handler).Close()
+	return removedSubscription.close()
+}
+
+// Removes a topic from the consumer shared by the group.
+// The shared consumer is closed with the last topic.
+func (c *KafkaConnection) unsubscribeShared(topic string, groupId string, listener IKafkaMessageListener) error {
+	c.sharedLock.Lock()
+	defer c.sharedLock.Unlock()
+
+	subscription := c.findSubscription(topic, groupId, listener)
+	if subscription == nil || subscription.shared == nil {
+		return nil
 	}
 
-	return nil
+	if subscription.shared.remove(c.ResolveTopic(topic)) > 0 {
+		subscription.restart()
+		return nil
+	}
+
+	c.lock.Lock()
+	for index, item := range c.subscriptions {
+		if item == subscription {
+			c.subscriptions = append(c.subscriptions[:index], c.subscriptions[index+1:]...)
+			break
+		}
+	}
+	c.lock.Unlock()
+
+	return subscription.close()
 }
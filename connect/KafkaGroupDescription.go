@@ -0,0 +1,45 @@
+package connect
+
+//	KafkaGroupMember describes a live member of a consumer group and its partition assignments.
+type KafkaGroupMember struct {
+	// The member id assigned by the group coordinator.
+	MemberId string `json:"member_id"`
+	// The client id of the member.
+	ClientId string `json:"client_id"`
+	// The host the member connected from.
+	ClientHost string `json:"client_host"`
+	// Assigned partitions by topic names.
+	Assignments map[string][]int32 `json:"assignments"`
+}
+
+//	KafkaGroupDescription describes a consumer group with its coordinator and live members.
+type KafkaGroupDescription struct {
+	// The consumer group id.
+	GroupId string `json:"group_id"`
+	// The group state, like Stable, PreparingRebalance, CompletingRebalance or Empty.
+	State string `json:"state"`
+	// The partition assignment strategy, like range or roundrobin.
+	Protocol string `json:"protocol"`
+	// The id of the broker that coordinates the group.
+	CoordinatorId int32 `json:"coordinator_id"`
+	// The address of the broker that coordinates the group.
+	Coordinator string `json:"coordinator"`
+	// The live members of the group.
+	Members []*KafkaGroupMember `json:"members"`
+}
+
+//	Finds the group member that owns a topic partition.
+//	Parameters:
+//		- topic string	a topic name
+//		- partition int32	a partition index
+//	Returns: the owning member or nil if the partition is not assigned.
+func (c *KafkaGroupDescription) FindOwner(topic string, partition int32) *KafkaGroupMember {
+	for _, member := range c.Members {
+		for _, assigned := range member.Assignments[topic] {
+			if assigned == partition {
+				return member
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package connect
+
+import (
+	"sync"
+	"time"
+)
+
+// Caches topic names and partitions read by KafkaConnection for a configured time,
+// so repeated checks of topics don't send metadata requests to brokers every time.
+// Missing topics are cached as well, since checks of them refresh metadata on every call.
+type kafkaMetadataCache struct {
+	ttl time.Duration
+
+	lock        sync.Mutex
+	names       []string
+	namesExpire time.Time
+	partitions  map[string]*kafkaCachedPartitions
+}
+
+// Partitions of a topic or an error of reading them
+type kafkaCachedPartitions struct {
+	partitions []int32
+	err        error
+	expire     time.Time
+}
+
+// Creates a new disabled cache
+func newKafkaMetadataCache() *kafkaMetadataCache {
+	return &kafkaMetadataCache{
+		partitions: make(map[string]*kafkaCachedPartitions),
+	}
+}
+
+// Checks if caching is enabled
+func (c *kafkaMetadataCache) isEnabled() bool {
+	return c.ttl > 0
+}
+
+// Sets the time to keep cached entries, 0 to disable caching
+func (c *kafkaMetadataCache) setTtl(ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.ttl = ttl
+	c.names = nil
+	c.partitions = make(map[string]*kafkaCachedPartitions)
+}
+
+// Gets a copy of cached queue names when they haven't expired yet
+func (c *kafkaMetadataCache) getNames() ([]string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.names == nil || time.Now().After(c.namesExpire) {
+		return nil, false
+	}
+	return append([]string{}, c.names...), true
+}
+
+func (c *kafkaMetadataCache) setNames(names []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.names = append([]string{}, names...)
+	c.namesExpire = time.Now().Add(c.ttl)
+}
+
+// Gets a copy of cached partitions of a topic when they haven't expired yet
+func (c *kafkaMetadataCache) getPartitions(topic string) ([]int32, error, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.partitions[topic]
+	if !ok || time.Now().After(entry.expire) {
+		return nil, nil, false
+	}
+	if entry.err != nil {
+		return nil, entry.err, true
+	}
+	return append([]int32{}, entry.partitions...), nil, true
+}
+
+func (c *kafkaMetadataCache) setPartitions(topic string, partitions []int32, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.partitions[topic] = &kafkaCachedPartitions{
+		partitions: append([]int32{}, partitions...),
+		err:        err,
+		expire:     time.Now().Add(c.ttl),
+	}
+}
+
+// Removes cached entries of topics. Queue names are removed as well,
+// since created and deleted topics change them. Without topics all entries are removed.
+func (c *kafkaMetadataCache) invalidate(topics ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.names = nil
+	if len(topics) == 0 {
+		c.partitions = make(map[string]*kafkaCachedPartitions)
+		return
+	}
+	for _, topic := range topics {
+		delete(c.partitions, topic)
+	}
+}
@@ -0,0 +1,247 @@
+package connect
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+)
+
+// Types of Kafka metrics
+const (
+	// Monotonic counter, like Prometheus counter
+	KafkaMetricCounter = "counter"
+	// Current value, like Prometheus gauge
+	KafkaMetricGauge = "gauge"
+	// Measured durations in milliseconds, like Prometheus histogram or summary
+	KafkaMetricTiming = "timing"
+)
+
+// Names of Kafka metrics. They follow Prometheus naming conventions and are stable across releases.
+const (
+	MetricQueueSentMessages      = "kafka_queue_sent_messages_total"
+	MetricQueueReceivedMessages  = "kafka_queue_received_messages_total"
+	MetricQueueSkippedMessages   = "kafka_queue_skipped_messages_total"
+	MetricQueueUnhandledMessages = "kafka_queue_unhandled_messages_total"
+	MetricQueueRejectedMessages  = "kafka_queue_rejected_messages_total"
+	MetricQueueUpcastMessages    = "kafka_queue_upcast_messages_total"
+	MetricQueueErrors            = "kafka_queue_errors_total"
+	MetricQueueCommitFailures    = "kafka_queue_commit_failures_total"
+	MetricQueueStuckConsumers    = "kafka_queue_stuck_consumers_total"
+	MetricQueueIdleConsumers     = "kafka_queue_idle_consumers_total"
+	MetricQueueProcessingRate    = "kafka_queue_processing_rate"
+	MetricQueueConsumerLag       = "kafka_queue_consumer_lag"
+	MetricQueueMessageAge        = "kafka_queue_message_age_milliseconds"
+	MetricQueueMessageLatency    = "kafka_queue_message_latency_milliseconds"
+
+	MetricConsumerReceivedRecords = "kafka_consumer_received_records_total"
+	MetricProducerSentMessages    = "kafka_producer_sent_messages_total"
+
+	MetricConnectionReconnects   = "kafka_connection_reconnects_total"
+	MetricConnectionFailovers    = "kafka_connection_failovers_total"
+	MetricConnectionFailbacks    = "kafka_connection_failbacks_total"
+	MetricConnectionThrottled    = "kafka_connection_throttled_total"
+	MetricConnectionThrottleTime = "kafka_connection_throttle_time_milliseconds"
+	MetricConnectionMetadataRtt  = "kafka_connection_metadata_rtt_milliseconds"
+	MetricConnectionProduceRtt   = "kafka_connection_produce_rtt_milliseconds"
+)
+
+//	KafkaMetricDescriptor describes a Kafka metric with its fixed set of labels.
+type KafkaMetricDescriptor struct {
+	// The metric name.
+	Name string `json:"name"`
+	// The metric type: counter, gauge or timing.
+	Type string `json:"type"`
+	// The metric description.
+	Help string `json:"help"`
+	// The names of labels passed with every measurement.
+	Labels []string `json:"labels"`
+}
+
+var queueMetricLabels = []string{"queue", "topic", "group", "partition"}
+var latencyMetricLabels = []string{"queue", "topic", "group", "partition", "message_type"}
+
+//	KafkaMetricDescriptors lists all metrics emitted via IKafkaMetrics, so adapters
+//	can register them in advance. Measurements always carry all labels of their metrics,
+//	labels that don't apply to a measurement, like partitions of sent messages, are empty.
+var KafkaMetricDescriptors = []*KafkaMetricDescriptor{
+	{MetricQueueSentMessages, KafkaMetricCounter, "Messages sent by a queue", queueMetricLabels},
+	{MetricQueueReceivedMessages, KafkaMetricCounter, "Messages received by a queue", queueMetricLabels},
+	{MetricQueueSkippedMessages, KafkaMetricCounter, "Messages skipped by filters of a queue", queueMetricLabels},
+	{MetricQueueUnhandledMessages, KafkaMetricCounter, "Received messages without handlers", queueMetricLabels},
+	{MetricQueueRejectedMessages, KafkaMetricCounter, "Sent messages rejected by schemas", queueMetricLabels},
+	{MetricQueueUpcastMessages, KafkaMetricCounter, "Received messages upcast to the latest schema version", queueMetricLabels},
+	{MetricQueueErrors, KafkaMetricCounter, "Errors of a queue", queueMetricLabels},
+	{MetricQueueCommitFailures, KafkaMetricCounter, "Offset commits that failed after all retries", queueMetricLabels},
+	{MetricQueueStuckConsumers, KafkaMetricCounter, "Times a consumer got stuck in a message handler", queueMetricLabels},
+	{MetricQueueIdleConsumers, KafkaMetricCounter, "Times a consumer got idle while having lag", queueMetricLabels},
+	{MetricQueueProcessingRate, KafkaMetricGauge, "Messages processed per second", queueMetricLabels},
+	{MetricQueueConsumerLag, KafkaMetricGauge, "Messages not yet committed by the consumer group", queueMetricLabels},
+	{MetricQueueMessageAge, KafkaMetricTiming, "Time from producing till receiving of messages", latencyMetricLabels},
+	{MetricQueueMessageLatency, KafkaMetricTiming, "Time from producing till completed processing of messages", latencyMetricLabels},
+
+	{MetricConsumerReceivedRecords, KafkaMetricCounter, "Records received by a consumer", []string{"topic", "group", "partition"}},
+	{MetricProducerSentMessages, KafkaMetricCounter, "Messages sent by a producer", []string{"topic"}},
+
+	{MetricConnectionReconnects, KafkaMetricCounter, "Reconnects of a connection", []string{}},
+	{MetricConnectionFailovers, KafkaMetricCounter, "Failovers of a connection to the secondary cluster", []string{}},
+	{MetricConnectionFailbacks, KafkaMetricCounter, "Failbacks of a connection to the primary cluster", []string{}},
+	{MetricConnectionThrottled, KafkaMetricCounter, "Requests throttled by brokers", []string{"broker"}},
+	{MetricConnectionThrottleTime, KafkaMetricTiming, "Time requests were throttled by brokers", []string{"broker"}},
+	{MetricConnectionMetadataRtt, KafkaMetricTiming, "Round trip time of metadata requests to brokers", []string{"broker"}},
+	{MetricConnectionProduceRtt, KafkaMetricTiming, "Round trip time of produce requests to partition leaders", []string{"broker"}},
+}
+
+// IKafkaMetrics receives labeled measurements of Kafka components.
+// Implementations can register KafkaMetricDescriptors as Prometheus collectors
+// and update them directly, without translating dotted ICounters names.
+type IKafkaMetrics interface {
+	// Increments a counter.
+	Increment(name string, labels map[string]string, value int64)
+
+	// Sets the current value of a gauge.
+	Set(name string, labels map[string]string, value float64)
+
+	// Records a measured duration in milliseconds.
+	Observe(name string, labels map[string]string, value float64)
+}
+
+//	CompositeKafkaMetrics passes measurements to all referenced IKafkaMetrics components.
+//	Missing labels of measurements are set to empty values, so every measurement
+//	has the labels declared by the metric descriptor.
+//
+//	References:
+//
+//		- IKafkaMetrics                (optional) components that receive measurements
+type CompositeKafkaMetrics struct {
+	lock    sync.RWMutex
+	metrics []IKafkaMetrics
+}
+
+// Descriptors of metrics by names
+var kafkaMetricDescriptorsByName = func() map[string]*KafkaMetricDescriptor {
+	result := make(map[string]*KafkaMetricDescriptor, len(KafkaMetricDescriptors))
+	for _, descriptor := range KafkaMetricDescriptors {
+		result[descriptor.Name] = descriptor
+	}
+	return result
+}()
+
+//	NewCompositeKafkaMetrics creates a new instance of composite metrics.
+//	Returns: *CompositeKafkaMetrics
+func NewCompositeKafkaMetrics() *CompositeKafkaMetrics {
+	return &CompositeKafkaMetrics{
+		metrics: []IKafkaMetrics{},
+	}
+}
+
+//	Sets references to IKafkaMetrics components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *CompositeKafkaMetrics) SetReferences(ctx context.Context, references cref.IReferences) {
+	metrics := []IKafkaMetrics{}
+	for _, component := range references.GetAll() {
+		if component, ok := component.(IKafkaMetrics); ok {
+			if _, composite := component.(*CompositeKafkaMetrics); !composite {
+				metrics = append(metrics, component)
+			}
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.metrics = metrics
+}
+
+//	Adds a component that receives measurements.
+//	Parameters:
+//		- metrics IKafkaMetrics	a metrics component
+func (c *CompositeKafkaMetrics) Add(metrics IKafkaMetrics) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.metrics = append(c.metrics, metrics)
+}
+
+//	Increments a counter.
+//	Parameters:
+//		- name string	a metric name
+//		- labels map[string]string	metric labels
+//		- value int64	an increment
+func (c *CompositeKafkaMetrics) Increment(name string, labels map[string]string, value int64) {
+	metrics := c.getMetrics()
+	if len(metrics) == 0 {
+		return
+	}
+
+	labels = completeMetricLabels(name, labels)
+	for _, item := range metrics {
+		item.Increment(name, labels, value)
+	}
+}
+
+//	Sets the current value of a gauge.
+//	Parameters:
+//		- name string	a metric name
+//		- labels map[string]string	metric labels
+//		- value float64	a gauge value
+func (c *CompositeKafkaMetrics) Set(name string, labels map[string]string, value float64) {
+	metrics := c.getMetrics()
+	if len(metrics) == 0 {
+		return
+	}
+
+	labels = completeMetricLabels(name, labels)
+	for _, item := range metrics {
+		item.Set(name, labels, value)
+	}
+}
+
+//	Records a measured duration in milliseconds.
+//	Parameters:
+//		- name string	a metric name
+//		- labels map[string]string	metric labels
+//		- value float64	a duration in milliseconds
+func (c *CompositeKafkaMetrics) Observe(name string, labels map[string]string, value float64) {
+	metrics := c.getMetrics()
+	if len(metrics) == 0 {
+		return
+	}
+
+	labels = completeMetricLabels(name, labels)
+	for _, item := range metrics {
+		item.Observe(name, labels, value)
+	}
+}
+
+//	Checks if any component receives measurements, so callers can skip composing labels.
+//	Returns: true if measurements are passed anywhere and false otherwise.
+func (c *CompositeKafkaMetrics) IsEnabled() bool {
+	return len(c.getMetrics()) > 0
+}
+
+func (c *CompositeKafkaMetrics) getMetrics() []IKafkaMetrics {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.metrics
+}
+
+// Composes labels of broker metrics
+func brokerMetricLabels(brokerId int32) map[string]string {
+	return map[string]string{"broker": strconv.Itoa(int(brokerId))}
+}
+
+// Keeps declared labels of a metric and sets missing ones to empty values
+func completeMetricLabels(name string, labels map[string]string) map[string]string {
+	descriptor, ok := kafkaMetricDescriptorsByName[name]
+	if !ok {
+		return labels
+	}
+
+	result := make(map[string]string, len(descriptor.Labels))
+	for _, label := range descriptor.Labels {
+		result[label] = labels[label]
+	}
+	return result
+}
@@ -0,0 +1,37 @@
+package connect
+
+import (
+	"time"
+)
+
+//	KafkaOffsetSnapshot keeps committed offsets of a consumer group on a topic.
+//	It is serialized to JSON to be stored between blue/green cutovers
+//	or in disaster recovery runbooks and restored later.
+type KafkaOffsetSnapshot struct {
+	// The topic name without prefix and suffix.
+	Topic string `json:"topic"`
+	// The consumer group id.
+	GroupId string `json:"group_id"`
+	// The time when the snapshot was taken.
+	Time time.Time `json:"time"`
+	// The committed offsets by partitions. Partitions without committed offsets are omitted.
+	Offsets map[int32]int64 `json:"offsets"`
+	// The metadata committed with offsets by partitions, like processing hosts or checkpoint ids.
+	// Partitions without metadata are omitted.
+	Metadata map[int32]string `json:"metadata,omitempty"`
+}
+
+//	NewKafkaOffsetSnapshot creates a new empty offset snapshot.
+//	Parameters:
+//		- topic string	a topic name
+//		- groupId string	a consumer group id
+//	Returns: *KafkaOffsetSnapshot
+func NewKafkaOffsetSnapshot(topic string, groupId string) *KafkaOffsetSnapshot {
+	return &KafkaOffsetSnapshot{
+		Topic:    topic,
+		GroupId:  groupId,
+		Time:     time.Now().UTC(),
+		Offsets:  make(map[int32]int64),
+		Metadata: make(map[int32]string),
+	}
+}
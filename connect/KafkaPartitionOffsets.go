@@ -0,0 +1,10 @@
+package connect
+
+//	KafkaPartitionOffsets keeps the range of offsets available in a topic partition.
+//	The number of messages in the partition is Latest - Earliest.
+type KafkaPartitionOffsets struct {
+	// The offset of the oldest message that was not removed by retention.
+	Earliest int64 `json:"earliest"`
+	// The offset of the next produced message, also known as the high watermark.
+	Latest int64 `json:"latest"`
+}
@@ -0,0 +1,27 @@
+package connect
+
+import (
+	"hash/fnv"
+)
+
+//	KeyPartition chooses a partition by the FNV-1a hash of the message key like the Sarama hash partitioner.
+//	It differs from the murmur2 partitioner of the Java client, so the same keys may go to other partitions
+//	than in messages sent by Java producers. The connection uses manual partitioning,
+//	so keyed messages are partitioned by their producers.
+//	Parameters:
+//		- key string	a message key
+//		- count int	a number of topic partitions
+//	Returns: a partition number.
+func KeyPartition(key string, count int) int32 {
+	if count == 0 {
+		return 0
+	}
+
+	hasher := fnv.New32a()
+	hasher.Write([]byte(key))
+	partition := int32(hasher.Sum32()) % int32(count)
+	if partition < 0 {
+		partition = -partition
+	}
+	return partition
+}
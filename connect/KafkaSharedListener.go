@@ -0,0 +1,170 @@
+package connect
+
+import (
+	"sort"
+	"sync"
+
+	kafka "github.com/Shopify/sarama"
+)
+
+// Listener of a consumer shared by several subscriptions of the same group.
+// It passes claims to listeners of their topics and shows every listener
+// only the claims of its topic.
+type kafkaSharedListener struct {
+	lock      sync.Mutex
+	ready     chan bool
+	listeners map[string]IKafkaMessageListener
+	// Listeners that were set up by the current session
+	started map[string]bool
+}
+
+func newKafkaSharedListener() *kafkaSharedListener {
+	return &kafkaSharedListener{
+		ready:     make(chan bool),
+		listeners: make(map[string]IKafkaMessageListener),
+		started:   make(map[string]bool),
+	}
+}
+
+// Adds a listener of a topic in Kafka
+func (c *kafkaSharedListener) add(topic string, listener IKafkaMessageListener) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.listeners[topic] = listener
+}
+
+// Removes a listener and returns the number of remaining listeners
+func (c *kafkaSharedListener) remove(topic string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.listeners, topic)
+	delete(c.started, topic)
+	return len(c.listeners)
+}
+
+func (c *kafkaSharedListener) listener(topic string) IKafkaMessageListener {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.listeners[topic]
+}
+
+func (c *kafkaSharedListener) contains(topic string, listener IKafkaMessageListener) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.listeners[topic] == listener
+}
+
+// Returns sorted topics of all listeners
+func (c *kafkaSharedListener) topics() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	topics := make([]string, 0, len(c.listeners))
+	for topic := range c.listeners {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func (c *kafkaSharedListener) Setup(session kafka.ConsumerGroupSession) error {
+	c.lock.Lock()
+	listeners := make(map[string]IKafkaMessageListener, len(c.listeners))
+	for topic, listener := range c.listeners {
+		listeners[topic] = listener
+		c.started[topic] = true
+	}
+	c.lock.Unlock()
+
+	for topic, listener := range listeners {
+		err := listener.Setup(&kafkaTopicSession{ConsumerGroupSession: session, topic: topic})
+		if err != nil {
+			return err
+		}
+	}
+
+	select {
+	case c.ready <- true:
+	default:
+	}
+	close(c.ready)
+	return nil
+}
+
+func (c *kafkaSharedListener) Cleanup(session kafka.ConsumerGroupSession) error {
+	c.lock.Lock()
+	listeners := make(map[string]IKafkaMessageListener, len(c.listeners))
+	for topic, listener := range c.listeners {
+		listeners[topic] = listener
+	}
+	c.lock.Unlock()
+
+	for topic, listener := range listeners {
+		err := listener.Cleanup(&kafkaTopicSession{ConsumerGroupSession: session, topic: topic})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *kafkaSharedListener) ConsumeClaim(session kafka.ConsumerGroupSession, claim kafka.ConsumerGroupClaim) error {
+	// Claims of topics without listeners are held until the session ends,
+	// since returning from a claim ends the whole session
+	listener := c.listener(claim.Topic())
+	if listener == nil {
+		<-session.Context().Done()
+		return nil
+	}
+	return listener.ConsumeClaim(&kafkaTopicSession{ConsumerGroupSession: session, topic: claim.Topic()}, claim)
+}
+
+func (c *kafkaSharedListener) Ready() chan bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ready
+}
+
+// Renews ready channels for the next session. Listeners that were not set up yet
+// keep their channels, since their subscribers still wait for them.
+func (c *kafkaSharedListener) SetReady(chFlag chan bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.ready = chFlag
+	for topic, listener := range c.listeners {
+		if c.started[topic] {
+			listener.SetReady(make(chan bool))
+		}
+	}
+	c.started = make(map[string]bool)
+}
+
+func (c *kafkaSharedListener) OnError(err error) {
+	c.lock.Lock()
+	listeners := make([]IKafkaMessageListener, 0, len(c.listeners))
+	for _, listener := range c.listeners {
+		listeners = append(listeners, listener)
+	}
+	c.lock.Unlock()
+
+	for _, listener := range listeners {
+		if errorListener, ok := listener.(IKafkaErrorListener); ok {
+			errorListener.OnError(err)
+		}
+	}
+}
+
+// Session that shows only claims of one topic
+type kafkaTopicSession struct {
+	kafka.ConsumerGroupSession
+	topic string
+}
+
+func (c *kafkaTopicSession) Claims() map[string][]int32 {
+	claims := make(map[string][]int32)
+	if partitions, ok := c.ConsumerGroupSession.Claims()[c.topic]; ok {
+		claims[c.topic] = partitions
+	}
+	return claims
+}
@@ -1,6 +1,9 @@
 package connect
 
 import (
+	"context"
+	"sync"
+
 	kafka "github.com/Shopify/sarama"
 )
 
@@ -10,4 +13,92 @@ type KafkaSubscription struct {
 	GroupId  string
 	Listener IKafkaMessageListener
 	Handler  *kafka.ConsumerGroup
+
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+	lock    sync.Mutex
+	closed  bool
+
+	// Consumer settings of the subscription
+	config *kafka.Config
+	// Listeners of a consumer shared by several topics
+	shared *kafkaSharedListener
+	// Ends the current consumer session
+	cancelSession context.CancelFunc
+}
+
+// Returns topics consumed by the subscription
+func (c *KafkaSubscription) topics() []string {
+	if c.shared != nil {
+		return c.shared.topics()
+	}
+	return []string{c.Topic}
+}
+
+// Starts a new consumer session that can be ended by restart
+func (c *KafkaSubscription) beginSession(ctx context.Context) context.Context {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.cancelSession != nil {
+		c.cancelSession()
+	}
+	sessionCtx, cancel := context.WithCancel(ctx)
+	c.cancelSession = cancel
+	return sessionCtx
+}
+
+// Ends the current consumer session to rejoin the group with changed topics
+func (c *KafkaSubscription) restart() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.cancelSession != nil {
+		c.cancelSession()
+	}
+}
+
+// Returns the current consumer of the subscription
+func (c *KafkaSubscription) consumer() kafka.ConsumerGroup {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return *c.Handler
+}
+
+// Replaces a dead consumer with a new one.
+// Returns false and closes the new consumer if the subscription was already closed.
+func (c *KafkaSubscription) replace(consumer kafka.ConsumerGroup) bool {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		consumer.Close()
+		return false
+	}
+	previous := *c.Handler
+	c.Handler = &consumer
+	c.lock.Unlock()
+
+	previous.Close()
+	return true
+}
+
+// Stops consuming, leaves the consumer group and waits until
+// all goroutines started for the subscription exit.
+func (c *KafkaSubscription) close() error {
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	c.lock.Lock()
+	c.closed = true
+	handler := c.Handler
+	c.lock.Unlock()
+
+	var err error
+	if handler != nil {
+		err = (*handler).Close()
+	}
+
+	c.workers.Wait()
+	return err
 }
@@ -0,0 +1,72 @@
+package connect
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// Name prefix of the histograms where brokers report throttle time of produce responses
+const throttleMetricPrefix = "throttle-time-in-ms-for-broker-"
+
+// Listener of throttle time reported by brokers
+type throttleListener func(brokerId int32, throttleTime time.Duration)
+
+// kafkaThrottleRegistry is a metrics registry that passes throttle time reported by brokers to a listener.
+// Sarama reports quota throttling only through metrics, so the histograms are intercepted on registration.
+type kafkaThrottleRegistry struct {
+	metrics.Registry
+	listener throttleListener
+}
+
+func newKafkaThrottleRegistry(listener throttleListener) *kafkaThrottleRegistry {
+	return &kafkaThrottleRegistry{
+		Registry: metrics.NewRegistry(),
+		listener: listener,
+	}
+}
+
+// Gets an existing metric or registers the given one, wrapping throttle time histograms
+func (c *kafkaThrottleRegistry) GetOrRegister(name string, metric interface{}) interface{} {
+	if !strings.HasPrefix(name, throttleMetricPrefix) {
+		return c.Registry.GetOrRegister(name, metric)
+	}
+
+	brokerId, err := strconv.ParseInt(strings.TrimPrefix(name, throttleMetricPrefix), 10, 32)
+	if err != nil {
+		return c.Registry.GetOrRegister(name, metric)
+	}
+
+	return c.Registry.GetOrRegister(name, func() metrics.Histogram {
+		var histogram metrics.Histogram
+		switch m := metric.(type) {
+		case func() metrics.Histogram:
+			histogram = m()
+		case metrics.Histogram:
+			histogram = m
+		default:
+			histogram = metrics.NewHistogram(metrics.NewUniformSample(1024))
+		}
+		return &throttleHistogram{
+			Histogram: histogram,
+			brokerId:  int32(brokerId),
+			listener:  c.listener,
+		}
+	})
+}
+
+// throttleHistogram passes updates of throttle time to a listener
+type throttleHistogram struct {
+	metrics.Histogram
+	brokerId int32
+	listener throttleListener
+}
+
+func (c *throttleHistogram) Update(value int64) {
+	c.Histogram.Update(value)
+	if value > 0 {
+		c.listener(c.brokerId, time.Duration(value)*time.Millisecond)
+	}
+}
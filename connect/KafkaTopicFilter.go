@@ -0,0 +1,86 @@
+package connect
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+)
+
+//	KafkaTopicFilter selects queue names read by ReadQueueNamesByFilter.
+//	All set conditions must match. Names are matched without topic prefixes and suffixes.
+//
+//	Example:
+//		filter := NewKafkaTopicFilter()
+//		filter.Prefix = "orders."
+//		filter.ExcludeInternal = true
+//		names, err := connection.ReadQueueNamesByFilter(filter, 0, 100)
+type KafkaTopicFilter struct {
+	// The required prefix of names.
+	Prefix string
+	// The regular expression that names must match.
+	Pattern string
+	// True to exclude internal topics, like __consumer_offsets or _schemas.
+	ExcludeInternal bool
+}
+
+//	NewKafkaTopicFilter creates a new filter that selects all topics.
+//	Returns: *KafkaTopicFilter
+func NewKafkaTopicFilter() *KafkaTopicFilter {
+	return &KafkaTopicFilter{}
+}
+
+//	IsInternalTopic checks if a topic is internal for Kafka or its ecosystem,
+//	like __consumer_offsets, __transaction_state or _schemas of the schema registry.
+//	Parameters:
+//		- name string	a topic name
+//	Returns: true if the topic is internal and false otherwise.
+func IsInternalTopic(name string) bool {
+	return strings.HasPrefix(name, "_")
+}
+
+//	Selects names that match the filter, sorts them and returns a page of them.
+//	Parameters:
+//		- names []string	names to select from
+//		- skip int	a number of selected names to skip
+//		- take int	a maximum number of returned names, 0 for all
+//	Returns: a page of selected names or error when the pattern is invalid.
+func (c *KafkaTopicFilter) Select(names []string, skip int, take int) ([]string, error) {
+	var pattern *regexp.Regexp
+	if c != nil && c.Pattern != "" {
+		var err error
+		pattern, err = regexp.Compile(c.Pattern)
+		if err != nil {
+			return nil, cerr.NewBadRequestError("", "INVALID_PATTERN",
+				"Invalid topic pattern "+c.Pattern).WithCause(err)
+		}
+	}
+
+	selected := make([]string, 0)
+	for _, name := range names {
+		if c != nil && c.Prefix != "" && !strings.HasPrefix(name, c.Prefix) {
+			continue
+		}
+		if c != nil && c.ExcludeInternal && IsInternalTopic(name) {
+			continue
+		}
+		if pattern != nil && !pattern.MatchString(name) {
+			continue
+		}
+		selected = append(selected, name)
+	}
+	sort.Strings(selected)
+
+	if skip < 0 {
+		skip = 0
+	}
+	if skip >= len(selected) {
+		return []string{}, nil
+	}
+	selected = selected[skip:]
+	if take > 0 && take < len(selected) {
+		selected = selected[:take]
+	}
+	return selected, nil
+}
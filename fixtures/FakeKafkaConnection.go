@@ -0,0 +1,495 @@
+package fixtures
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	kafka "github.com/Shopify/sarama"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+)
+
+// FakeKafkaConnection is a broker-free connect.IKafkaConnection for unit tests
+// that records published messages. Exported fields may be set up before the connection is used.
+// While components use it, read them with Get methods, which are safe for concurrent use.
+type FakeKafkaConnection struct {
+	lock      sync.Mutex
+	opened    bool
+	Topics    map[string]int32
+	Drift     []string
+	Aligned   []string
+	Published map[string][]*kafka.ProducerMessage
+	// Subscribed listeners by topic
+	Listeners map[string]connect.IKafkaMessageListener
+	// Consumer groups of subscribed listeners by topic
+	SubscribedGroups map[string]string
+	// Committed offsets by groups and topics
+	Committed map[string]map[string]map[int32]int64
+	// Metadata of committed offsets by groups and topics
+	CommittedMetadata map[string]map[string]map[int32]string
+	// Consumer group lags by partitions returned for all topics
+	Lags map[int32]int64
+	// Consumer group descriptions by group ids
+	Groups map[string]*connect.KafkaGroupDescription
+	// Offsets before which records were deleted by topics and partitions
+	Deleted map[string]map[int32]int64
+	// Paused partitions by topics
+	PausedPartitions map[string][]int32
+}
+
+func NewFakeKafkaConnection(topics ...string) *FakeKafkaConnection {
+	c := &FakeKafkaConnection{
+		Topics:            make(map[string]int32),
+		Published:         make(map[string][]*kafka.ProducerMessage),
+		Listeners:         make(map[string]connect.IKafkaMessageListener),
+		SubscribedGroups:  make(map[string]string),
+		Committed:         make(map[string]map[string]map[int32]int64),
+		CommittedMetadata: make(map[string]map[string]map[int32]string),
+		Groups:            make(map[string]*connect.KafkaGroupDescription),
+		Deleted:           make(map[string]map[int32]int64),
+		PausedPartitions:  make(map[string][]int32),
+	}
+	for _, topic := range topics {
+		c.Topics[topic] = 1
+	}
+	return c
+}
+
+func (c *FakeKafkaConnection) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.opened
+}
+
+func (c *FakeKafkaConnection) Open(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.opened = true
+	return nil
+}
+
+func (c *FakeKafkaConnection) Close(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.opened = false
+	return nil
+}
+
+// Gets messages published to a topic
+func (c *FakeKafkaConnection) GetPublished(topic string) []*kafka.ProducerMessage {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]*kafka.ProducerMessage{}, c.Published[topic]...)
+}
+
+// Gets the listener subscribed to a topic or nil
+func (c *FakeKafkaConnection) GetListener(topic string) connect.IKafkaMessageListener {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.Listeners[topic]
+}
+
+// Gets the consumer group of the listener subscribed to a topic
+func (c *FakeKafkaConnection) GetSubscribedGroup(topic string) string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.SubscribedGroups[topic]
+}
+
+// Gets offsets committed by a consumer group to a topic
+func (c *FakeKafkaConnection) GetCommitted(groupId string, topic string) map[int32]int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	offsets := make(map[int32]int64, len(c.Committed[groupId][topic]))
+	for partition, offset := range c.Committed[groupId][topic] {
+		offsets[partition] = offset
+	}
+	return offsets
+}
+
+// Gets paused partitions of a topic
+func (c *FakeKafkaConnection) GetPausedPartitions(topic string) []int32 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]int32{}, c.PausedPartitions[topic]...)
+}
+
+// Gets names of aligned topics
+func (c *FakeKafkaConnection) GetAligned() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string{}, c.Aligned...)
+}
+
+func (c *FakeKafkaConnection) ReadQueueNames() ([]string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	names := make([]string, 0, len(c.Topics))
+	for name := range c.Topics {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (c *FakeKafkaConnection) ReadQueueNamesByFilter(filter *connect.KafkaTopicFilter, skip int, take int) ([]string, error) {
+	names, _ := c.ReadQueueNames()
+	return filter.Select(names, skip, take)
+}
+
+func (c *FakeKafkaConnection) ReadPartitions(name string) ([]int32, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	partitions := make([]int32, 0, c.Topics[name])
+	for i := int32(0); i < c.Topics[name]; i++ {
+		partitions = append(partitions, i)
+	}
+	return partitions, nil
+}
+
+func (c *FakeKafkaConnection) CreateQueue(name string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.Topics[name] = 1
+	return nil
+}
+
+func (c *FakeKafkaConnection) DeleteQueue(name string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.Topics, name)
+	return nil
+}
+
+func (c *FakeKafkaConnection) CreatePartitions(name string, count int) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.Topics[name] = int32(count)
+	return nil
+}
+
+func (c *FakeKafkaConnection) ReadQueueDrift(name string) ([]string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string{}, c.Drift...), nil
+}
+
+func (c *FakeKafkaConnection) AlignQueue(name string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.Aligned = append(c.Aligned, name)
+	c.Drift = nil
+	return nil
+}
+
+func (c *FakeKafkaConnection) ReadLags(topic string, groupId string, partitions []int32) (map[int32]int64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	lags := make(map[int32]int64, len(c.Lags))
+	for partition, lag := range c.Lags {
+		lags[partition] = lag
+	}
+	return lags, nil
+}
+
+// Reads published messages of partitions from committed offsets of the group,
+// or the latest messages of partitions without committed offsets
+func (c *FakeKafkaConnection) PeekMessages(topic string, groupId string, partitions []int32, maxCount int) ([]*kafka.ConsumerMessage, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	published := make(map[int32][]*kafka.ProducerMessage)
+	for _, message := range c.Published[topic] {
+		published[message.Partition] = append(published[message.Partition], message)
+	}
+	if len(partitions) == 0 {
+		for partition := range published {
+			partitions = append(partitions, partition)
+		}
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	}
+
+	messages := []*kafka.ConsumerMessage{}
+	for _, partition := range partitions {
+		start := int64(len(published[partition]) - maxCount)
+		if offset, ok := c.Committed[groupId][topic][partition]; ok {
+			start = offset
+		}
+
+		for _, message := range published[partition] {
+			if len(messages) >= maxCount {
+				return messages, nil
+			}
+			if message.Offset >= start {
+				messages = append(messages, toFakeConsumerMessage(message))
+			}
+		}
+	}
+	return messages, nil
+}
+
+func toFakeConsumerMessage(message *kafka.ProducerMessage) *kafka.ConsumerMessage {
+	result := &kafka.ConsumerMessage{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Timestamp: message.Timestamp,
+	}
+	if message.Key != nil {
+		result.Key, _ = message.Key.Encode()
+	}
+	if message.Value != nil {
+		result.Value, _ = message.Value.Encode()
+	}
+	for index := range message.Headers {
+		header := message.Headers[index]
+		result.Headers = append(result.Headers, &header)
+	}
+	return result
+}
+
+func (c *FakeKafkaConnection) Publish(ctx context.Context, topic string, messages []*kafka.ProducerMessage) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Assign offsets like the broker does
+	for _, message := range messages {
+		message.Topic = topic
+		message.Offset = 0
+		for _, published := range c.Published[topic] {
+			if published.Partition == message.Partition {
+				message.Offset++
+			}
+		}
+		c.Published[topic] = append(c.Published[topic], message)
+	}
+	return nil
+}
+
+func (c *FakeKafkaConnection) ReadOffsets(topic string, partitions []int32, time int64) (map[int32]int64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(partitions) == 0 {
+		for i := int32(0); i < c.Topics[topic]; i++ {
+			partitions = append(partitions, i)
+		}
+	}
+
+	offsets := make(map[int32]int64, len(partitions))
+	for _, partition := range partitions {
+		offsets[partition] = -1
+		count := int64(0)
+		for _, published := range c.Published[topic] {
+			if published.Partition != partition {
+				continue
+			}
+			count++
+			if offsets[partition] < 0 && time >= 0 && published.Timestamp.UnixMilli() >= time {
+				offsets[partition] = published.Offset
+			}
+		}
+
+		if time == kafka.OffsetOldest {
+			offsets[partition] = 0
+		} else if offsets[partition] < 0 {
+			offsets[partition] = count
+		}
+	}
+	return offsets, nil
+}
+
+func (c *FakeKafkaConnection) ListOffsets(topic string) (map[int32]*connect.KafkaPartitionOffsets, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	offsets := make(map[int32]*connect.KafkaPartitionOffsets, c.Topics[topic])
+	for partition := int32(0); partition < c.Topics[topic]; partition++ {
+		offsets[partition] = &connect.KafkaPartitionOffsets{}
+	}
+	for _, published := range c.Published[topic] {
+		if offsets[published.Partition] == nil {
+			offsets[published.Partition] = &connect.KafkaPartitionOffsets{}
+		}
+		offsets[published.Partition].Latest++
+	}
+	for partition, offset := range c.Deleted[topic] {
+		if offsets[partition] != nil {
+			offsets[partition].Earliest = offset
+		}
+	}
+	return offsets, nil
+}
+
+func (c *FakeKafkaConnection) DescribeGroup(groupId string) (*connect.KafkaGroupDescription, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if group, ok := c.Groups[groupId]; ok {
+		return group, nil
+	}
+	// Kafka describes unknown groups as dead groups without members
+	return &connect.KafkaGroupDescription{
+		GroupId: groupId,
+		State:   "Dead",
+		Members: []*connect.KafkaGroupMember{},
+	}, nil
+}
+
+func (c *FakeKafkaConnection) DeleteGroup(groupId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.Groups, groupId)
+	delete(c.Committed, groupId)
+	return nil
+}
+
+func (c *FakeKafkaConnection) DeleteRecords(topic string, offsets map[int32]int64) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.Deleted[topic] == nil {
+		c.Deleted[topic] = make(map[int32]int64)
+	}
+	for partition, offset := range offsets {
+		c.Deleted[topic][partition] = offset
+	}
+	return nil
+}
+
+func (c *FakeKafkaConnection) ReadMessages(topic string, partition int32, offset int64, maxCount int) ([]*kafka.ConsumerMessage, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	messages := []*kafka.ConsumerMessage{}
+	for _, published := range c.Published[topic] {
+		if len(messages) >= maxCount {
+			break
+		}
+		if published.Partition != partition || published.Offset < offset {
+			continue
+		}
+
+		message := &kafka.ConsumerMessage{
+			Topic:     topic,
+			Partition: partition,
+			Offset:    published.Offset,
+			Timestamp: published.Timestamp,
+		}
+		if published.Key != nil {
+			message.Key, _ = published.Key.Encode()
+		}
+		if published.Value != nil {
+			message.Value, _ = published.Value.Encode()
+		}
+		for i := range published.Headers {
+			message.Headers = append(message.Headers, &published.Headers[i])
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+func (c *FakeKafkaConnection) ExportOffsets(topic string, groupId string) (*connect.KafkaOffsetSnapshot, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	snapshot := connect.NewKafkaOffsetSnapshot(topic, groupId)
+	for partition, offset := range c.Committed[groupId][topic] {
+		snapshot.Offsets[partition] = offset
+	}
+	for partition, metadata := range c.CommittedMetadata[groupId][topic] {
+		snapshot.Metadata[partition] = metadata
+	}
+	return snapshot, nil
+}
+
+func (c *FakeKafkaConnection) ImportOffsets(snapshot *connect.KafkaOffsetSnapshot) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.Committed[snapshot.GroupId] == nil {
+		c.Committed[snapshot.GroupId] = make(map[string]map[int32]int64)
+	}
+	if c.Committed[snapshot.GroupId][snapshot.Topic] == nil {
+		c.Committed[snapshot.GroupId][snapshot.Topic] = make(map[int32]int64)
+	}
+	if c.CommittedMetadata[snapshot.GroupId] == nil {
+		c.CommittedMetadata[snapshot.GroupId] = make(map[string]map[int32]string)
+	}
+	if c.CommittedMetadata[snapshot.GroupId][snapshot.Topic] == nil {
+		c.CommittedMetadata[snapshot.GroupId][snapshot.Topic] = make(map[int32]string)
+	}
+	for partition, offset := range snapshot.Offsets {
+		c.Committed[snapshot.GroupId][snapshot.Topic][partition] = offset
+		c.CommittedMetadata[snapshot.GroupId][snapshot.Topic][partition] = snapshot.Metadata[partition]
+	}
+	return nil
+}
+
+func (c *FakeKafkaConnection) Subscribe(ctx context.Context, topic string, groupId string, config *kafka.Config, listener connect.IKafkaMessageListener) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.Listeners[topic] = listener
+	c.SubscribedGroups[topic] = groupId
+	return nil
+}
+
+func (c *FakeKafkaConnection) Unsubscribe(ctx context.Context, topic string, groupId string, listener connect.IKafkaMessageListener) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.Listeners, topic)
+	delete(c.SubscribedGroups, topic)
+	return nil
+}
+
+func (c *FakeKafkaConnection) Pause(topic string, groupId string, listener connect.IKafkaMessageListener) error {
+	return nil
+}
+
+func (c *FakeKafkaConnection) Resume(topic string, groupId string, listener connect.IKafkaMessageListener) error {
+	return nil
+}
+
+func (c *FakeKafkaConnection) PausePartitions(topic string, groupId string, listener connect.IKafkaMessageListener, partitions map[string][]int32) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for name, indexes := range partitions {
+		c.PausedPartitions[name] = append(c.PausedPartitions[name], indexes...)
+	}
+	return nil
+}
+
+func (c *FakeKafkaConnection) ResumePartitions(topic string, groupId string, listener connect.IKafkaMessageListener, partitions map[string][]int32) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for name, indexes := range partitions {
+		paused := make([]int32, 0)
+		for _, partition := range c.PausedPartitions[name] {
+			resumed := false
+			for _, index := range indexes {
+				resumed = resumed || index == partition
+			}
+			if !resumed {
+				paused = append(paused, partition)
+			}
+		}
+		if len(paused) == 0 {
+			delete(c.PausedPartitions, name)
+		} else {
+			c.PausedPartitions[name] = paused
+		}
+	}
+	return nil
+}
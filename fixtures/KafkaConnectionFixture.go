@@ -0,0 +1,134 @@
+package fixtures
+
+import (
+	"context"
+	"testing"
+
+	kafka "github.com/Shopify/sarama"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	"github.com/stretchr/testify/assert"
+)
+
+// KafkaConnectionFixture is a conformance suite of Kafka connection scenarios.
+// It runs against KafkaConnection or alternative IKafkaConnection implementations.
+//
+//	Example:
+//		fixture := fixtures.NewKafkaConnectionFixture(connection, "test")
+//		t.Run("Create Delete Queue", fixture.TestCreateDeleteQueue)
+type KafkaConnectionFixture struct {
+	connection connect.IKafkaConnection
+	topic      string
+}
+
+func NewKafkaConnectionFixture(connection connect.IKafkaConnection, topic string) *KafkaConnectionFixture {
+	c := KafkaConnectionFixture{
+		connection: connection,
+		topic:      topic,
+	}
+	return &c
+}
+
+func (c *KafkaConnectionFixture) TestOpenClose(t *testing.T) {
+	err := c.connection.Open(context.Background(), "")
+	assert.Nil(t, err)
+	assert.True(t, c.connection.IsOpen())
+
+	err = c.connection.Close(context.Background(), "")
+	assert.Nil(t, err)
+	assert.False(t, c.connection.IsOpen())
+}
+
+func (c *KafkaConnectionFixture) TestCreateDeleteQueue(t *testing.T) {
+	err := c.connection.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer c.connection.Close(context.Background(), "")
+
+	err = c.connection.CreateQueue(c.topic)
+	assert.Nil(t, err)
+
+	names, err := c.connection.ReadQueueNames()
+	assert.Nil(t, err)
+	assert.Contains(t, names, c.topic)
+
+	err = c.connection.DeleteQueue(c.topic)
+	assert.Nil(t, err)
+}
+
+func (c *KafkaConnectionFixture) TestPublish(t *testing.T) {
+	err := c.connection.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer c.connection.Close(context.Background(), "")
+
+	names, err := c.connection.ReadQueueNames()
+	assert.Nil(t, err)
+	if !contains(names, c.topic) {
+		err = c.connection.CreateQueue(c.topic)
+		assert.Nil(t, err)
+	}
+
+	err = c.connection.Publish(context.Background(), c.topic, []*kafka.ProducerMessage{
+		{
+			Topic: c.topic,
+			Key:   kafka.StringEncoder("123"),
+			Value: kafka.ByteEncoder("Test message"),
+		},
+	})
+	assert.Nil(t, err)
+}
+
+func (c *KafkaConnectionFixture) TestListOffsets(t *testing.T) {
+	err := c.connection.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer c.connection.Close(context.Background(), "")
+
+	names, err := c.connection.ReadQueueNames()
+	assert.Nil(t, err)
+	if !contains(names, c.topic) {
+		err = c.connection.CreateQueue(c.topic)
+		assert.Nil(t, err)
+	}
+
+	before, err := c.connection.ListOffsets(c.topic)
+	assert.Nil(t, err)
+	assert.Contains(t, before, int32(0))
+
+	err = c.connection.Publish(context.Background(), c.topic, []*kafka.ProducerMessage{
+		{
+			Partition: 0,
+			Value:     kafka.ByteEncoder("Test message"),
+		},
+	})
+	assert.Nil(t, err)
+
+	after, err := c.connection.ListOffsets(c.topic)
+	assert.Nil(t, err)
+	assert.Equal(t, before[0].Latest+1, after[0].Latest)
+	assert.LessOrEqual(t, after[0].Earliest, after[0].Latest)
+}
+
+func (c *KafkaConnectionFixture) TestDescribeGroup(t *testing.T) {
+	err := c.connection.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer c.connection.Close(context.Background(), "")
+
+	describer, ok := c.connection.(connect.IKafkaGroupDescriber)
+	if !ok {
+		t.Skip("The connection doesn't describe consumer groups")
+	}
+
+	group, err := describer.DescribeGroup("unknown-group")
+	assert.Nil(t, err)
+	assert.Equal(t, "unknown-group", group.GroupId)
+	assert.Equal(t, "Dead", group.State)
+	assert.Len(t, group.Members, 0)
+	assert.Nil(t, group.FindOwner(c.topic, 0))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
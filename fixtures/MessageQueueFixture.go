@@ -1,4 +1,4 @@
-package test_queues
+package fixtures
 
 import (
 	"context"
@@ -10,6 +10,12 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// MessageQueueFixture is a conformance suite of message queue scenarios.
+// Connectors and services can run it against their queue configurations.
+//
+//	Example:
+//		fixture := fixtures.NewMessageQueueFixture(queue)
+//		t.Run("Send Receive Message", fixture.TestSendReceiveMessage)
 type MessageQueueFixture struct {
 	queue queues.IMessageQueue
 }
@@ -81,6 +87,25 @@ func (c *MessageQueueFixture) TestReceiveCompleteMessage(t *testing.T) {
 	assert.Nil(t, envelope2.GetReference())
 }
 
+// TestCompleteNoRedelivery checks that completed messages are not received again.
+// The queue shall be configured with autocommit turned off.
+func (c *MessageQueueFixture) TestCompleteNoRedelivery(t *testing.T) {
+	envelope1 := queues.NewMessageEnvelope("123", "Test", []byte("Test message"))
+	sndErr := c.queue.Send(context.Background(), "", envelope1)
+	assert.Nil(t, sndErr)
+
+	envelope2, rcvErr := c.queue.Receive(context.Background(), "", 10000*time.Millisecond)
+	assert.Nil(t, rcvErr)
+	assert.NotNil(t, envelope2)
+
+	cplErr := c.queue.Complete(context.Background(), envelope2)
+	assert.Nil(t, cplErr)
+
+	envelope3, rcvErr := c.queue.Receive(context.Background(), "", 500*time.Millisecond)
+	assert.Nil(t, rcvErr)
+	assert.Nil(t, envelope3)
+}
+
 func (c *MessageQueueFixture) TestReceiveAbandonMessage(t *testing.T) {
 	envelope1 := queues.NewMessageEnvelope("123", "Test", []byte("Test message"))
 	sndErr := c.queue.Send(context.Background(), "", envelope1)
@@ -166,6 +191,7 @@ func (c *MessageQueueFixture) TestOnMessage(t *testing.T) {
 	c.queue.EndListen(context.Background(), "")
 }
 
+// TestMsgReceiver is a message receiver that keeps the last received message.
 type TestMsgReceiver struct {
 	_envelope *queues.MessageEnvelope
 	Lock      sync.Mutex
@@ -0,0 +1,139 @@
+package load
+
+import (
+	"context"
+	"encoding/binary"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cdata "github.com/pip-services3-gox/pip-services3-commons-gox/data"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+// Message type of generated load messages
+const LoadMessageType = "kafka_load"
+
+// Size of the sequence number and the send time at the beginning of every payload
+const loadHeaderSize = 16
+
+//	KafkaLoadGenerator produces messages of a configurable size at a configurable rate
+//	through a message queue to test capacity of Kafka clusters.
+//	Every message carries its sequence number and send time so KafkaLoadVerifier
+//	can detect lost and duplicated messages and measure latency.
+//
+//	Configuration parameters:
+//
+//		- options:
+//			- message_size:         	(optional) size of message payloads in bytes, at least 16 (default: 1024)
+//			- rate:                 	(optional) number of messages sent per second, 0 to send without pauses (default: 100)
+//			- message_count:        	(optional) number of messages to send, 0 to send until the duration ends (default: 1000)
+//			- duration:             	(optional) maximum number of milliseconds to send messages (default: 60000)
+//
+//	Example:
+//		generator := load.NewKafkaLoadGenerator(queue)
+//		generator.Configure(ctx, cconf.NewConfigParamsFromTuples("options.rate", 1000))
+//		sent, err := generator.Run(ctx, "123")
+type KafkaLoadGenerator struct {
+	queue        cqueues.IMessageQueue
+	messageSize  int
+	rate         int
+	messageCount int64
+	duration     time.Duration
+	runId        string
+	sent         int64
+	failed       int64
+}
+
+//	NewKafkaLoadGenerator creates a new instance of the load generator.
+//	Parameters:
+//		- queue cqueues.IMessageQueue	a queue to send messages to
+//	Returns: *KafkaLoadGenerator
+func NewKafkaLoadGenerator(queue cqueues.IMessageQueue) *KafkaLoadGenerator {
+	return &KafkaLoadGenerator{
+		queue:        queue,
+		messageSize:  1024,
+		rate:         100,
+		messageCount: 1000,
+		duration:     60000 * time.Millisecond,
+	}
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaLoadGenerator) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	c.messageSize = config.GetAsIntegerWithDefault("options.message_size", c.messageSize)
+	if c.messageSize < loadHeaderSize {
+		c.messageSize = loadHeaderSize
+	}
+	c.rate = config.GetAsIntegerWithDefault("options.rate", c.rate)
+	c.messageCount = config.GetAsLongWithDefault("options.message_count", c.messageCount)
+	c.duration = time.Duration(config.GetAsIntegerWithDefault("options.duration",
+		int(c.duration.Milliseconds()))) * time.Millisecond
+}
+
+//	Gets the number of messages sent by the last run.
+func (c *KafkaLoadGenerator) Sent() int64 {
+	return atomic.LoadInt64(&c.sent)
+}
+
+//	Gets the number of messages that failed to be sent by the last run.
+func (c *KafkaLoadGenerator) Failed() int64 {
+	return atomic.LoadInt64(&c.failed)
+}
+
+//	Sends messages until the message count is reached, the duration ends or the context is canceled.
+//	Send errors are counted and don't stop the run.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//	Returns: the number of sent messages or error if the context was canceled.
+func (c *KafkaLoadGenerator) Run(ctx context.Context, correlationId string) (int64, error) {
+	atomic.StoreInt64(&c.sent, 0)
+	atomic.StoreInt64(&c.failed, 0)
+	c.runId = cdata.IdGenerator.NextShort()
+
+	ctx, cancel := context.WithTimeout(ctx, c.duration)
+	defer cancel()
+
+	var ticker *time.Ticker
+	if c.rate > 0 {
+		ticker = time.NewTicker(time.Second / time.Duration(c.rate))
+		defer ticker.Stop()
+	}
+
+	for seq := int64(0); c.messageCount <= 0 || seq < c.messageCount; seq++ {
+		if ticker != nil {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return c.Sent(), nil
+			}
+		} else if ctx.Err() != nil {
+			return c.Sent(), nil
+		}
+
+		envelope := cqueues.NewMessageEnvelope(correlationId, LoadMessageType, c.newPayload(seq))
+		envelope.MessageId = c.runId + "-" + strconv.FormatInt(seq, 10)
+
+		err := c.queue.Send(ctx, correlationId, envelope)
+		if err != nil {
+			atomic.AddInt64(&c.failed, 1)
+			continue
+		}
+		atomic.AddInt64(&c.sent, 1)
+	}
+
+	return c.Sent(), nil
+}
+
+// Creates a payload with the sequence number and the send time followed by padding
+func (c *KafkaLoadGenerator) newPayload(seq int64) []byte {
+	payload := make([]byte, c.messageSize)
+	binary.BigEndian.PutUint64(payload[0:8], uint64(seq))
+	binary.BigEndian.PutUint64(payload[8:16], uint64(time.Now().UnixNano()))
+	return payload
+}
@@ -0,0 +1,154 @@
+package load
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+	"sync"
+	"time"
+
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+// KafkaLoadReport contains results of a load test.
+type KafkaLoadReport struct {
+	Sent       int64
+	Received   int64
+	Lost       int64
+	Duplicated int64
+	LatencyP50 time.Duration
+	LatencyP95 time.Duration
+	LatencyP99 time.Duration
+	LatencyMax time.Duration
+}
+
+//	KafkaLoadVerifier consumes messages produced by KafkaLoadGenerator
+//	and checks them for loss, duplication and end-to-end latency.
+//	Latency is measured against the send time of the generator, so clocks
+//	of generating and verifying hosts shall be synchronized.
+//
+//	Example:
+//		verifier := load.NewKafkaLoadVerifier(queue)
+//		verifier.Start(ctx, "123")
+//		sent, _ := generator.Run(ctx, "123")
+//		report := verifier.Stop(ctx, "123", sent)
+type KafkaLoadVerifier struct {
+	queue     cqueues.IMessageQueue
+	lock      sync.Mutex
+	seen      map[int64]bool
+	received  int64
+	duplicate int64
+	latencies []time.Duration
+}
+
+//	NewKafkaLoadVerifier creates a new instance of the verifier.
+//	Parameters:
+//		- queue cqueues.IMessageQueue	a queue to receive messages from
+//	Returns: *KafkaLoadVerifier
+func NewKafkaLoadVerifier(queue cqueues.IMessageQueue) *KafkaLoadVerifier {
+	c := &KafkaLoadVerifier{
+		queue: queue,
+	}
+	c.reset()
+	return c
+}
+
+func (c *KafkaLoadVerifier) reset() {
+	c.seen = make(map[int64]bool)
+	c.received = 0
+	c.duplicate = 0
+	c.latencies = make([]time.Duration, 0)
+}
+
+//	Starts listening the queue and clears previous results.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+func (c *KafkaLoadVerifier) Start(ctx context.Context, correlationId string) {
+	c.lock.Lock()
+	c.reset()
+	c.lock.Unlock()
+
+	c.queue.BeginListen(ctx, correlationId, c)
+}
+
+//	Stops listening and reports results.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- sent int64	the number of messages sent by the generator
+//	Returns: the load test report.
+func (c *KafkaLoadVerifier) Stop(ctx context.Context, correlationId string, sent int64) *KafkaLoadReport {
+	c.queue.EndListen(ctx, correlationId)
+	return c.Report(sent)
+}
+
+//	Reports results collected so far.
+//	Parameters:
+//		- sent int64	the number of messages sent by the generator
+//	Returns: the load test report.
+func (c *KafkaLoadVerifier) Report(sent int64) *KafkaLoadReport {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	report := &KafkaLoadReport{
+		Sent:       sent,
+		Received:   c.received,
+		Duplicated: c.duplicate,
+	}
+	if lost := sent - int64(len(c.seen)); lost > 0 {
+		report.Lost = lost
+	}
+
+	latencies := append([]time.Duration{}, c.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.LatencyP50 = percentile(latencies, 0.50)
+	report.LatencyP95 = percentile(latencies, 0.95)
+	report.LatencyP99 = percentile(latencies, 0.99)
+	report.LatencyMax = percentile(latencies, 1)
+
+	return report
+}
+
+//	ReceiveMessage registers a received load message. Other messages are ignored.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- envelope *cqueues.MessageEnvelope	a received message
+//		- queue cqueues.IMessageQueue	a queue the message came from
+//	Returns: error or nil for success.
+func (c *KafkaLoadVerifier) ReceiveMessage(ctx context.Context, envelope *cqueues.MessageEnvelope, queue cqueues.IMessageQueue) error {
+	now := time.Now()
+	if envelope.MessageType != LoadMessageType || len(envelope.Message) < loadHeaderSize {
+		return nil
+	}
+
+	seq := int64(binary.BigEndian.Uint64(envelope.Message[0:8]))
+	sentTime := time.Unix(0, int64(binary.BigEndian.Uint64(envelope.Message[8:16])))
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.received++
+	if c.seen[seq] {
+		c.duplicate++
+		return nil
+	}
+	c.seen[seq] = true
+	c.latencies = append(c.latencies, now.Sub(sentTime))
+	return nil
+}
+
+// Gets a percentile of sorted values
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(float64(len(sorted))*p+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return sorted[index]
+}
@@ -0,0 +1,375 @@
+package lock
+
+import (
+	"context"
+	"sync"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+)
+
+//	KafkaLeaderElection elects a single leader among instances of a service.
+//	All instances join the same consumer group on an election topic, and the instance
+//	assigned to partition 0 of the topic is the leader. When the leader stops or fails,
+//	the group rebalances and another instance gets the partition.
+//
+//	The elected callback receives a context that is canceled when the leadership is lost,
+//	so singleton jobs started by the callback stop on resignation. Callbacks are called
+//	during rebalances and must not block, so long running jobs shall be started in goroutines.
+//	Leadership changes are detected by the group coordinator after the session timeout,
+//	so a failed leader may overlap with the next one for that time.
+//
+//	Configuration parameters:
+//
+//		- topic:                         (optional) election topic name, created when missing (default: elections)
+//		- group_id:                      (optional) consumer group id of the election (default: leader_election)
+//		- connection(s):
+//			- discovery_key:               (optional) a key to retrieve the connection from  IDiscovery
+//			- host:                        host name or IP address
+//			- port:                        port number
+//			- uri:                         resource URI or connection string with all parameters in it
+//		- credential(s):
+//			- store_key:                   (optional) a key to retrieve the credentials from  ICredentialStore
+//			- username:                    user name
+//			- password:                    user password
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
+//		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
+//		- *:connection:kafka:*:1.0      (optional) Shared connection to Kafka service
+//
+//	Example:
+//		election := NewKafkaLeaderElection()
+//		election.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"group_id", "billing_scheduler",
+//			"connection.host", "localhost",
+//			"connection.port", 9092,
+//		))
+//		election.SetElectedCallback(func(ctx context.Context) {
+//			go runScheduler(ctx)
+//		})
+//		_ = election.Open(ctx, "123")
+type KafkaLeaderElection struct {
+	defaultConfig   *cconf.ConfigParams
+	config          *cconf.ConfigParams
+	references      cref.IReferences
+	mtx             sync.Mutex
+	opened          bool
+	localConnection bool
+
+	// The dependency resolver.
+	DependencyResolver *cref.DependencyResolver
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The Kafka connection component.
+	Connection connect.IKafkaConnection
+
+	topic            string
+	groupId          string
+	leader           bool
+	cancelLeadership context.CancelFunc
+	electedCallback  func(ctx context.Context)
+	resignedCallback func(ctx context.Context)
+	listener         *kafkaElectionListener
+}
+
+//	NewKafkaLeaderElection creates a new instance of the leader election component.
+//	Returns: *KafkaLeaderElection
+func NewKafkaLeaderElection() *KafkaLeaderElection {
+	c := &KafkaLeaderElection{
+		defaultConfig: cconf.NewConfigParamsFromTuples(
+			"topic", "elections",
+			"group_id", "leader_election",
+			"dependencies.connection", "*:connection:kafka:*:1.0",
+		),
+		Logger:  clog.NewCompositeLogger(),
+		topic:   "elections",
+		groupId: "leader_election",
+	}
+	c.DependencyResolver = cref.NewDependencyResolver()
+	c.DependencyResolver.Configure(context.Background(), c.defaultConfig)
+	return c
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaLeaderElection) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	config = config.SetDefaults(c.defaultConfig)
+	c.config = config
+
+	c.DependencyResolver.Configure(ctx, config)
+	c.Logger.Configure(ctx, config)
+
+	c.topic = config.GetAsStringWithDefault("topic", c.topic)
+	c.groupId = config.GetAsStringWithDefault("group_id", c.groupId)
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaLeaderElection) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.references = references
+	c.Logger.SetReferences(ctx, references)
+
+	// Get connection
+	c.DependencyResolver.SetReferences(ctx, references)
+	result := c.DependencyResolver.GetOneOptional("connection")
+	if dep, ok := result.(connect.IKafkaConnection); ok {
+		c.Connection = dep
+	}
+	// Or create a local one
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	} else {
+		c.localConnection = false
+	}
+}
+
+//	Unsets (clears) previously set references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+func (c *KafkaLeaderElection) UnsetReferences(ctx context.Context) {
+	c.Connection = nil
+}
+
+func (c *KafkaLeaderElection) createConnection() *connect.KafkaConnection {
+	connection := connect.NewKafkaConnection()
+
+	if c.config != nil {
+		connection.Configure(context.Background(), c.config)
+	}
+
+	if c.references != nil {
+		connection.SetReferences(context.Background(), c.references)
+	}
+	return connection
+}
+
+//	Sets a callback called when this instance becomes the leader.
+//	Parameters:
+//		- callback func(ctx context.Context)	a callback that receives a context canceled on resignation
+func (c *KafkaLeaderElection) SetElectedCallback(callback func(ctx context.Context)) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.electedCallback = callback
+}
+
+//	Sets a callback called when this instance loses the leadership.
+//	Parameters:
+//		- callback func(ctx context.Context)	a callback
+func (c *KafkaLeaderElection) SetResignedCallback(callback func(ctx context.Context)) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.resignedCallback = callback
+}
+
+//	Checks if this instance is the leader.
+//	Returns: true if this instance is the leader and false otherwise.
+func (c *KafkaLeaderElection) IsLeader() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.leader
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaLeaderElection) IsOpen() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.opened
+}
+
+//	Opens the component and joins the election.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaLeaderElection) Open(ctx context.Context, correlationId string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.opened {
+		return nil
+	}
+
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	}
+
+	if c.localConnection {
+		err := c.Connection.Open(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !c.Connection.IsOpen() {
+		return cerr.NewConnectionError(correlationId, "CONNECT_FAILED", "Kafka connection is not opened")
+	}
+
+	partitions, err := c.Connection.ReadPartitions(c.topic)
+	if err != nil {
+		return err
+	}
+	if len(partitions) == 0 {
+		err = c.Connection.CreateQueue(c.topic)
+		if err != nil {
+			return err
+		}
+	} else if len(partitions) > 1 {
+		c.Logger.Warn(ctx, correlationId, "Election topic %s has %d partitions, only partition 0 elects the leader",
+			c.topic, len(partitions))
+	}
+
+	listener := &kafkaElectionListener{
+		election: c,
+		ready:    make(chan bool),
+	}
+	// The subscription waits for the first session, so the lock is released while joining
+	c.mtx.Unlock()
+	err = c.Connection.Subscribe(ctx, c.topic, c.groupId, kafka.NewConfig(), listener)
+	c.mtx.Lock()
+	if err != nil {
+		return err
+	}
+
+	c.listener = listener
+	c.opened = true
+	c.Logger.Debug(ctx, correlationId, "Joined leader election %s", c.groupId)
+	return nil
+}
+
+//	Closes component and leaves the election. The leadership is resigned.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaLeaderElection) Close(ctx context.Context, correlationId string) error {
+	c.mtx.Lock()
+	if !c.opened {
+		c.mtx.Unlock()
+		return nil
+	}
+	c.opened = false
+	listener := c.listener
+	c.listener = nil
+	c.mtx.Unlock()
+
+	err := c.Connection.Unsubscribe(ctx, c.topic, c.groupId, listener)
+	c.resign(ctx)
+	if err != nil {
+		return err
+	}
+
+	if c.localConnection {
+		return c.Connection.Close(ctx, correlationId)
+	}
+	return nil
+}
+
+// Makes this instance the leader
+func (c *KafkaLeaderElection) elect(ctx context.Context) {
+	c.mtx.Lock()
+	if c.leader {
+		c.mtx.Unlock()
+		return
+	}
+	c.leader = true
+	leadershipCtx, cancel := context.WithCancel(ctx)
+	c.cancelLeadership = cancel
+	callback := c.electedCallback
+	c.mtx.Unlock()
+
+	c.Logger.Info(ctx, "", "Elected as the leader of %s", c.groupId)
+	if callback != nil {
+		callback(leadershipCtx)
+	}
+}
+
+// Gives up the leadership of this instance
+func (c *KafkaLeaderElection) resign(ctx context.Context) {
+	c.mtx.Lock()
+	if !c.leader {
+		c.mtx.Unlock()
+		return
+	}
+	c.leader = false
+	cancel := c.cancelLeadership
+	c.cancelLeadership = nil
+	callback := c.resignedCallback
+	c.mtx.Unlock()
+
+	cancel()
+	c.Logger.Info(ctx, "", "Resigned as the leader of %s", c.groupId)
+	if callback != nil {
+		callback(ctx)
+	}
+}
+
+// Consumer group listener that tracks assignments of the election partition
+type kafkaElectionListener struct {
+	election *KafkaLeaderElection
+	lock     sync.Mutex
+	ready    chan bool
+}
+
+func (c *kafkaElectionListener) Setup(session kafka.ConsumerGroupSession) error {
+	for _, partitions := range session.Claims() {
+		for _, partition := range partitions {
+			if partition == 0 {
+				c.election.elect(session.Context())
+			}
+		}
+	}
+
+	// Mark the listener as ready without blocking on rebalances
+	ready := c.Ready()
+	select {
+	case ready <- true:
+	default:
+	}
+	close(ready)
+	return nil
+}
+
+func (c *kafkaElectionListener) Cleanup(session kafka.ConsumerGroupSession) error {
+	c.election.resign(context.Background())
+	return nil
+}
+
+func (c *kafkaElectionListener) ConsumeClaim(session kafka.ConsumerGroupSession, claim kafka.ConsumerGroupClaim) error {
+	// The election topic carries no data, so records are skipped
+	for {
+		select {
+		case _, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (c *kafkaElectionListener) Ready() chan bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ready
+}
+
+func (c *kafkaElectionListener) SetReady(chFlag chan bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ready = chFlag
+}
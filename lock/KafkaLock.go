@@ -0,0 +1,456 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cdata "github.com/pip-services3-gox/pip-services3-commons-gox/data"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	clock "github.com/pip-services3-gox/pip-services3-components-gox/lock"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+)
+
+//	KafkaLock is a distributed lock implemented on top of a compacted Kafka topic.
+//
+//	Every lock attempt appends a claim to the partition of the lock key.
+//	The partition leader totally orders claims, so all lock instances replay
+//	the same sequence and agree on the holder: a claim wins when the lock is free
+//	or the previous holder expired at the time of the claim. Releases and losing
+//	claims are removed by tombstones, so the compacted topic keeps only active claims.
+//	The offset of the winning claim is a fencing token that grows with every acquisition.
+//
+//	The lock topic must exist and should be compacted with min.compaction.lag.ms
+//	not less than the longest lock ttl. Expiration relies on clocks of the lock
+//	instances, so they shall be synchronized.
+//
+//	Configuration parameters:
+//
+//		- topic:                         (optional) lock topic name (default: locks)
+//		- connection(s):
+//			- discovery_key:               (optional) a key to retrieve the connection from  IDiscovery
+//			- host:                        host name or IP address
+//			- port:                        port number
+//			- uri:                         resource URI or connection string with all parameters in it
+//		- credential(s):
+//			- store_key:                   (optional) a key to retrieve the credentials from  ICredentialStore
+//			- username:                    user name
+//			- password:                    user password
+//		- options:
+//			- retry_timeout:               (optional) timeout in milliseconds to retry lock acquisition (default: 100)
+//			- read_attempts:               (optional) number of attempts to read own claims from the topic (default: 3)
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
+//		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
+//		- *:connection:kafka:*:1.0      (optional) Shared connection to Kafka service
+//
+//	Example:
+//		lock := NewKafkaLock()
+//		lock.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"topic", "locks",
+//			"connection.host", "localhost",
+//			"connection.port", 9092,
+//		))
+//		_ = lock.Open(ctx, "123")
+//
+//		err := lock.AcquireLock(ctx, "123", "key1", 10000, 1000)
+//		if err == nil {
+//			defer lock.ReleaseLock(ctx, "123", "key1")
+//			// Processing...
+//		}
+type KafkaLock struct {
+	*clock.Lock
+
+	defaultConfig   *cconf.ConfigParams
+	config          *cconf.ConfigParams
+	references      cref.IReferences
+	mtx             sync.Mutex
+	opened          bool
+	localConnection bool
+
+	// The dependency resolver.
+	DependencyResolver *cref.DependencyResolver
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The Kafka connection component.
+	Connection connect.IKafkaConnection
+
+	topic        string
+	readAttempts int
+	owner        string
+	partitions   int
+	positions    map[int32]int64
+	holders      map[string]*kafkaLockClaim
+}
+
+// Claim of a lock stored in the lock topic
+type kafkaLockClaim struct {
+	Key        string `json:"key"`
+	Owner      string `json:"owner"`
+	Token      string `json:"token"`
+	Time       int64  `json:"time"`
+	ExpireTime int64  `json:"expire_time"`
+
+	offset   int64
+	replaced *kafkaLockClaim
+}
+
+func (c *kafkaLockClaim) recordKey() string {
+	return c.Key + "/" + c.Token
+}
+
+//	NewKafkaLock creates a new instance of the lock component.
+//	Returns: *KafkaLock
+func NewKafkaLock() *KafkaLock {
+	c := &KafkaLock{
+		defaultConfig: cconf.NewConfigParamsFromTuples(
+			"topic", "locks",
+			"options.read_attempts", 3,
+			"dependencies.connection", "*:connection:kafka:*:1.0",
+		),
+		Logger:       clog.NewCompositeLogger(),
+		topic:        "locks",
+		readAttempts: 3,
+		owner:        cdata.IdGenerator.NextLong(),
+		positions:    make(map[int32]int64),
+		holders:      make(map[string]*kafkaLockClaim),
+	}
+	c.Lock = clock.InheritLock(c)
+	c.DependencyResolver = cref.NewDependencyResolver()
+	c.DependencyResolver.Configure(context.Background(), c.defaultConfig)
+	return c
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaLock) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	config = config.SetDefaults(c.defaultConfig)
+	c.config = config
+
+	c.Lock.Configure(ctx, config)
+	c.DependencyResolver.Configure(ctx, config)
+	c.Logger.Configure(ctx, config)
+
+	c.topic = config.GetAsStringWithDefault("topic", c.topic)
+	c.readAttempts = config.GetAsIntegerWithDefault("options.read_attempts", c.readAttempts)
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaLock) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.references = references
+	c.Logger.SetReferences(ctx, references)
+
+	// Get connection
+	c.DependencyResolver.SetReferences(ctx, references)
+	result := c.DependencyResolver.GetOneOptional("connection")
+	if dep, ok := result.(connect.IKafkaConnection); ok {
+		c.Connection = dep
+	}
+	// Or create a local one
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	} else {
+		c.localConnection = false
+	}
+}
+
+//	Unsets (clears) previously set references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+func (c *KafkaLock) UnsetReferences(ctx context.Context) {
+	c.Connection = nil
+}
+
+func (c *KafkaLock) createConnection() *connect.KafkaConnection {
+	connection := connect.NewKafkaConnection()
+	connection.AllowOptions("read_attempts")
+
+	if c.config != nil {
+		connection.Configure(context.Background(), c.config)
+	}
+
+	if c.references != nil {
+		connection.SetReferences(context.Background(), c.references)
+	}
+	return connection
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaLock) IsOpen() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.opened
+}
+
+//	Opens the component.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaLock) Open(ctx context.Context, correlationId string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.opened {
+		return nil
+	}
+
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	}
+
+	if c.localConnection {
+		err := c.Connection.Open(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !c.Connection.IsOpen() {
+		return cerr.NewConnectionError(correlationId, "CONNECT_FAILED", "Kafka connection is not opened")
+	}
+
+	partitions, err := c.Connection.ReadPartitions(c.topic)
+	if err != nil {
+		return err
+	}
+	if len(partitions) == 0 {
+		return cerr.NewNotFoundError(correlationId, "TOPIC_NOT_FOUND", "Lock topic "+c.topic+" does not exist").
+			WithDetails("topic", c.topic)
+	}
+
+	c.partitions = len(partitions)
+	c.positions = make(map[int32]int64)
+	c.holders = make(map[string]*kafkaLockClaim)
+	c.opened = true
+	return nil
+}
+
+//	Closes component and frees used resources.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaLock) Close(ctx context.Context, correlationId string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.opened {
+		return nil
+	}
+
+	if c.localConnection {
+		err := c.Connection.Close(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.opened = false
+	return nil
+}
+
+//	Makes a single attempt to acquire a lock by its key.
+//	It returns immediately a positive or negative result.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- key string	a unique lock key to acquire.
+//		- ttl int64	a lock timeout (time to live) in milliseconds.
+//	Returns: true if the lock is acquired or error.
+func (c *KafkaLock) TryAcquireLock(ctx context.Context, correlationId string, key string, ttl int64) (bool, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.opened {
+		return false, cerr.NewInvalidStateError(correlationId, "NOT_OPENED", "The lock is not opened")
+	}
+
+	now := time.Now()
+	claim := &kafkaLockClaim{
+		Key:        key,
+		Owner:      c.owner,
+		Token:      cdata.IdGenerator.NextLong(),
+		Time:       now.UnixMilli(),
+		ExpireTime: now.Add(time.Duration(ttl) * time.Millisecond).UnixMilli(),
+	}
+	value, err := json.Marshal(claim)
+	if err != nil {
+		return false, err
+	}
+
+	partition := connect.KeyPartition(key, c.partitions)
+	offset, err := c.publish(ctx, partition, claim.recordKey(), value)
+	if err != nil {
+		return false, err
+	}
+
+	err = c.readPartition(correlationId, partition, offset)
+	if err != nil {
+		return false, err
+	}
+
+	holder := c.holders[key]
+	if holder == nil || holder.Token != claim.Token {
+		// Remove the losing claim from the compacted topic
+		_, err = c.publish(ctx, partition, claim.recordKey(), nil)
+		if err != nil {
+			c.Logger.Warn(ctx, correlationId, "Failed to remove claim of lock %s: %s", key, err.Error())
+		}
+		return false, nil
+	}
+
+	// Remove the expired claim replaced by this one
+	if holder.replaced != nil {
+		_, err = c.publish(ctx, partition, holder.replaced.recordKey(), nil)
+		if err != nil {
+			c.Logger.Warn(ctx, correlationId, "Failed to remove expired claim of lock %s: %s", key, err.Error())
+		}
+		holder.replaced = nil
+	}
+
+	return true, nil
+}
+
+//	Releases a lock acquired by this instance.
+//	Locks held by other instances are not affected.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- key string	the key of the lock that is to be released.
+//	Returns: error or nil for success.
+func (c *KafkaLock) ReleaseLock(ctx context.Context, correlationId string, key string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.opened {
+		return cerr.NewInvalidStateError(correlationId, "NOT_OPENED", "The lock is not opened")
+	}
+
+	holder := c.holders[key]
+	if holder == nil || holder.Owner != c.owner {
+		return nil
+	}
+
+	partition := connect.KeyPartition(key, c.partitions)
+	offset, err := c.publish(ctx, partition, holder.recordKey(), nil)
+	if err != nil {
+		return err
+	}
+
+	return c.readPartition(correlationId, partition, offset)
+}
+
+//	Gets a fencing token of a lock acquired by this instance.
+//	Tokens grow with every acquisition of the lock, so resources protected by the lock
+//	can reject requests with tokens older than the last one they have seen.
+//	Parameters:
+//		- key string	a lock key.
+//	Returns: the fencing token and true if the lock is held by this instance.
+func (c *KafkaLock) GetFencingToken(key string) (int64, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	holder := c.holders[key]
+	if holder == nil || holder.Owner != c.owner || holder.ExpireTime <= time.Now().UnixMilli() {
+		return 0, false
+	}
+	return holder.offset, true
+}
+
+// Appends a claim or a tombstone to the lock topic and returns its offset
+func (c *KafkaLock) publish(ctx context.Context, partition int32, key string, value []byte) (int64, error) {
+	msg := &kafka.ProducerMessage{
+		Key:       kafka.StringEncoder(key),
+		Partition: partition,
+		Timestamp: time.Now(),
+	}
+	if value != nil {
+		msg.Value = kafka.ByteEncoder(value)
+	}
+
+	err := c.Connection.Publish(ctx, c.topic, []*kafka.ProducerMessage{msg})
+	if err != nil {
+		return 0, err
+	}
+	return msg.Offset, nil
+}
+
+// Replays claims of the partition up to and including the offset
+func (c *KafkaLock) readPartition(correlationId string, partition int32, offset int64) error {
+	position, ok := c.positions[partition]
+	if !ok {
+		position = kafka.OffsetOldest
+	}
+
+	attempts := 0
+	for position <= offset {
+		messages, err := c.Connection.ReadMessages(c.topic, partition, position, 1000)
+		if err != nil {
+			return err
+		}
+
+		if len(messages) == 0 {
+			attempts++
+			if attempts >= c.readAttempts {
+				return cerr.NewInternalError(correlationId, "LOCK_READ_FAILED",
+					"Failed to read claims from lock topic "+c.topic).
+					WithDetails("partition", partition).WithDetails("offset", offset)
+			}
+			continue
+		}
+
+		for _, msg := range messages {
+			c.apply(msg)
+			position = msg.Offset + 1
+		}
+		c.positions[partition] = position
+	}
+
+	return nil
+}
+
+// Applies a claim or a tombstone to the lock holders
+func (c *KafkaLock) apply(msg *kafka.ConsumerMessage) {
+	if msg.Value == nil {
+		recordKey := string(msg.Key)
+		index := strings.LastIndex(recordKey, "/")
+		if index < 0 {
+			return
+		}
+		key, token := recordKey[:index], recordKey[index+1:]
+		if holder := c.holders[key]; holder != nil && holder.Token == token {
+			delete(c.holders, key)
+		}
+		return
+	}
+
+	claim := &kafkaLockClaim{}
+	if err := json.Unmarshal(msg.Value, claim); err != nil {
+		return
+	}
+
+	holder := c.holders[claim.Key]
+	if holder == nil || holder.ExpireTime <= claim.Time {
+		claim.offset = msg.Offset
+		claim.replaced = holder
+		c.holders[claim.Key] = claim
+	}
+}
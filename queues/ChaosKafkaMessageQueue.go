@@ -0,0 +1,303 @@
+package queues
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+//	ChaosKafkaMessageQueue is a decorator that injects faults into sent and received messages
+//	of another queue. It is used in tests to verify that consumers are idempotent
+//	and resilient to latency, duplicates, reordering and transient errors.
+//
+//	Configuration parameters:
+//
+//		- options:
+//			- latency_min:          	(optional) minimum number of milliseconds added to every send and receive (default: 0)
+//			- latency_max:          	(optional) maximum number of milliseconds added to every send and receive (default: 0)
+//			- duplicate_rate:       	(optional) probability from 0 to 1 to deliver a message twice (default: 0)
+//			- reorder_rate:         	(optional) probability from 0 to 1 to deliver a message after the next one (default: 0)
+//			- send_error_rate:      	(optional) probability from 0 to 1 to fail sending a message (default: 0)
+//			- receive_error_rate:   	(optional) probability from 0 to 1 to fail receiving or processing a message (default: 0)
+//			- seed:                 	(optional) seed of the random generator to reproduce faults (default: current time)
+//
+//	Example:
+//		queue := NewChaosKafkaMessageQueue(NewKafkaMessageQueue("myqueue"))
+//		queue.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"topic", "mytopic",
+//			"options.duplicate_rate", 0.1,
+//			"options.receive_error_rate", 0.05,
+//		))
+type ChaosKafkaMessageQueue struct {
+	IKafkaMessageQueue
+
+	lock             sync.Mutex
+	random           *rand.Rand
+	latencyMin       time.Duration
+	latencyMax       time.Duration
+	duplicateRate    float32
+	reorderRate      float32
+	sendErrorRate    float32
+	receiveErrorRate float32
+	heldSent         *cqueues.MessageEnvelope
+	received         []*cqueues.MessageEnvelope
+}
+
+//	NewChaosKafkaMessageQueue creates a new instance of the decorator.
+//	Parameters:
+//		- queue IKafkaMessageQueue	a decorated queue
+//	Returns: *ChaosKafkaMessageQueue
+func NewChaosKafkaMessageQueue(queue IKafkaMessageQueue) *ChaosKafkaMessageQueue {
+	return &ChaosKafkaMessageQueue{
+		IKafkaMessageQueue: queue,
+		random:             rand.New(rand.NewSource(time.Now().UnixNano())),
+		received:           make([]*cqueues.MessageEnvelope, 0),
+	}
+}
+
+//	Configures the decorator and the decorated queue.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *ChaosKafkaMessageQueue) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	if configurable, ok := c.IKafkaMessageQueue.(interface {
+		Configure(ctx context.Context, config *cconf.ConfigParams)
+	}); ok {
+		configurable.Configure(ctx, config)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.latencyMin = time.Duration(config.GetAsIntegerWithDefault("options.latency_min",
+		int(c.latencyMin.Milliseconds()))) * time.Millisecond
+	c.latencyMax = time.Duration(config.GetAsIntegerWithDefault("options.latency_max",
+		int(c.latencyMax.Milliseconds()))) * time.Millisecond
+	c.duplicateRate = config.GetAsFloatWithDefault("options.duplicate_rate", c.duplicateRate)
+	c.reorderRate = config.GetAsFloatWithDefault("options.reorder_rate", c.reorderRate)
+	c.sendErrorRate = config.GetAsFloatWithDefault("options.send_error_rate", c.sendErrorRate)
+	c.receiveErrorRate = config.GetAsFloatWithDefault("options.receive_error_rate", c.receiveErrorRate)
+
+	if seed, ok := config.GetAsNullableLong("options.seed"); ok {
+		c.random = rand.New(rand.NewSource(seed))
+	}
+}
+
+// Returns true with the given probability
+func (c *ChaosKafkaMessageQueue) chance(rate float32) bool {
+	if rate <= 0 {
+		return false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.random.Float32() < rate
+}
+
+// Sleeps for a random latency or until the context is done
+func (c *ChaosKafkaMessageQueue) delay(ctx context.Context) {
+	c.lock.Lock()
+	latency := c.latencyMin
+	if c.latencyMax > c.latencyMin {
+		latency += time.Duration(c.random.Int63n(int64(c.latencyMax - c.latencyMin)))
+	}
+	c.lock.Unlock()
+
+	if latency <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+//	Closes the decorated queue and sends a message held for reordering.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *ChaosKafkaMessageQueue) Close(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	held := c.heldSent
+	c.heldSent = nil
+	c.received = make([]*cqueues.MessageEnvelope, 0)
+	c.lock.Unlock()
+
+	if held != nil {
+		_ = c.IKafkaMessageQueue.Send(ctx, correlationId, held)
+	}
+
+	return c.IKafkaMessageQueue.Close(ctx, correlationId)
+}
+
+//	Sends a message with injected latency, errors, duplicates and reordering.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- envelope *cqueues.MessageEnvelope  a message envelop to be sent.
+//	Returns: error or nil for success.
+func (c *ChaosKafkaMessageQueue) Send(ctx context.Context, correlationId string, envelope *cqueues.MessageEnvelope) error {
+	c.delay(ctx)
+
+	if c.chance(c.sendErrorRate) {
+		return cerr.NewUnknownError(correlationId, "CHAOS_SEND_FAILED", "Injected failure to send the message")
+	}
+
+	// Hold the message to send it after the next one
+	if c.chance(c.reorderRate) {
+		c.lock.Lock()
+		if c.heldSent == nil {
+			c.heldSent = envelope
+			c.lock.Unlock()
+			return nil
+		}
+		c.lock.Unlock()
+	}
+
+	err := c.IKafkaMessageQueue.Send(ctx, correlationId, envelope)
+	if err != nil {
+		return err
+	}
+
+	if c.chance(c.duplicateRate) {
+		err = c.IKafkaMessageQueue.Send(ctx, correlationId, envelope)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.lock.Lock()
+	held := c.heldSent
+	c.heldSent = nil
+	c.lock.Unlock()
+
+	if held != nil {
+		return c.IKafkaMessageQueue.Send(ctx, correlationId, held)
+	}
+	return nil
+}
+
+//	Sends an object into the queue through the injected faults.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- messageType string	a message type
+//		- value any	an object value to be sent
+//	Returns: error or nil for success.
+func (c *ChaosKafkaMessageQueue) SendAsObject(ctx context.Context, correlationId string, messageType string, value any) error {
+	envelope := cqueues.NewMessageEnvelopeFromObject(correlationId, messageType, value)
+	return c.Send(ctx, correlationId, envelope)
+}
+
+//	Receives a message with injected latency, errors, duplicates and reordering.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- waitTimeout time.Duration	a timeout in milliseconds to wait for a message to come.
+//	Returns: a message or error.
+func (c *ChaosKafkaMessageQueue) Receive(ctx context.Context, correlationId string, waitTimeout time.Duration) (*cqueues.MessageEnvelope, error) {
+	c.delay(ctx)
+
+	if c.chance(c.receiveErrorRate) {
+		return nil, cerr.NewUnknownError(correlationId, "CHAOS_RECEIVE_FAILED", "Injected failure to receive the message")
+	}
+
+	// Return duplicated or postponed messages first
+	c.lock.Lock()
+	if len(c.received) > 0 {
+		message := c.received[0]
+		c.received = c.received[1:]
+		c.lock.Unlock()
+		return message, nil
+	}
+	c.lock.Unlock()
+
+	message, err := c.IKafkaMessageQueue.Receive(ctx, correlationId, waitTimeout)
+	if err != nil || message == nil {
+		return message, err
+	}
+
+	// Postpone the message and return the next one instead
+	if c.chance(c.reorderRate) {
+		next, err := c.IKafkaMessageQueue.Receive(ctx, correlationId, waitTimeout)
+		if err == nil && next != nil {
+			c.lock.Lock()
+			c.received = append(c.received, message)
+			c.lock.Unlock()
+			message = next
+		}
+	}
+
+	if c.chance(c.duplicateRate) {
+		c.lock.Lock()
+		c.received = append(c.received, message)
+		c.lock.Unlock()
+	}
+
+	return message, nil
+}
+
+//	Listens for incoming messages and passes them to the receiver
+//	with injected latency, errors and duplicates.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId   string  (optional) transaction id to trace execution through call chain.
+//		- receiver    cqueues.IMessageReceiver      a receiver to receive incoming messages.
+func (c *ChaosKafkaMessageQueue) Listen(ctx context.Context, correlationId string, receiver cqueues.IMessageReceiver) error {
+	return c.IKafkaMessageQueue.Listen(ctx, correlationId, &chaosMessageReceiver{queue: c, receiver: receiver})
+}
+
+//	Listens for incoming messages without blocking the current thread.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId   string  (optional) transaction id to trace execution through call chain.
+//		- receiver    cqueues.IMessageReceiver      a receiver to receive incoming messages.
+func (c *ChaosKafkaMessageQueue) BeginListen(ctx context.Context, correlationId string, receiver cqueues.IMessageReceiver) {
+	go func() {
+		_ = c.Listen(ctx, correlationId, receiver)
+	}()
+}
+
+// Receiver that injects faults into delivered messages
+type chaosMessageReceiver struct {
+	queue    *ChaosKafkaMessageQueue
+	receiver cqueues.IMessageReceiver
+	lock     sync.Mutex
+	held     *cqueues.MessageEnvelope
+}
+
+func (c *chaosMessageReceiver) ReceiveMessage(ctx context.Context, envelope *cqueues.MessageEnvelope, queue cqueues.IMessageQueue) error {
+	c.queue.delay(ctx)
+
+	if c.queue.chance(c.queue.receiveErrorRate) {
+		return cerr.NewUnknownError(envelope.CorrelationId, "CHAOS_RECEIVE_FAILED", "Injected failure to process the message")
+	}
+
+	// Hold the message to deliver it after the next one
+	c.lock.Lock()
+	if c.held == nil && c.queue.chance(c.queue.reorderRate) {
+		c.held = envelope
+		c.lock.Unlock()
+		return nil
+	}
+	held := c.held
+	c.held = nil
+	c.lock.Unlock()
+
+	err := c.receiver.ReceiveMessage(ctx, envelope, c.queue)
+	if err == nil && c.queue.chance(c.queue.duplicateRate) {
+		err = c.receiver.ReceiveMessage(ctx, envelope, c.queue)
+	}
+	if err == nil && held != nil {
+		err = c.receiver.ReceiveMessage(ctx, held, c.queue)
+	}
+	return err
+}
@@ -0,0 +1,190 @@
+package queues
+
+import (
+	"context"
+	"encoding/json"
+
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+// Operations of Debezium change events. They are used as message types of decoded changes.
+const (
+	DebeziumCreate   = "create"
+	DebeziumUpdate   = "update"
+	DebeziumDelete   = "delete"
+	DebeziumRead     = "read"
+	DebeziumTruncate = "truncate"
+	// Tombstones that follow deletes to let compaction remove the keys
+	DebeziumTombstone = "tombstone"
+)
+
+var debeziumOperations = map[string]string{
+	"c": DebeziumCreate,
+	"u": DebeziumUpdate,
+	"d": DebeziumDelete,
+	"r": DebeziumRead,
+	"t": DebeziumTruncate,
+}
+
+// DebeziumSource describes the origin of a Debezium change event
+type DebeziumSource struct {
+	Connector string `json:"connector,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Database  string `json:"db,omitempty"`
+	Schema    string `json:"schema,omitempty"`
+	Table     string `json:"table,omitempty"`
+	TsMs      int64  `json:"ts_ms,omitempty"`
+	Snapshot  any    `json:"snapshot,omitempty"`
+}
+
+// DebeziumChangeEvent is a typed change record decoded from a Debezium envelope
+type DebeziumChangeEvent struct {
+	// Operation: create, update, delete, read or truncate
+	Operation string `json:"operation"`
+	// Row state before the change, nil for creates and reads
+	Before map[string]any `json:"before"`
+	// Row state after the change, nil for deletes
+	After map[string]any `json:"after"`
+	// Origin of the change
+	Source *DebeziumSource `json:"source,omitempty"`
+	// Time the change was processed by the connector in milliseconds
+	TsMs int64 `json:"ts_ms,omitempty"`
+}
+
+// Debezium envelope with or without the schema
+type debeziumEnvelope struct {
+	Before  map[string]any  `json:"before"`
+	After   map[string]any  `json:"after"`
+	Op      string          `json:"op"`
+	Source  *DebeziumSource `json:"source,omitempty"`
+	TsMs    int64           `json:"ts_ms,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+//	DebeziumMessageTransformer decodes Debezium change-event envelopes (before/after/op/source)
+//	into DebeziumChangeEvent records. The operation becomes the message type,
+//	so handlers can be registered per operation, and tombstones get DebeziumTombstone type.
+//	Envelopes produced by the JSON converter with schemas enabled are unwrapped from their payload.
+//	Encode converts DebeziumChangeEvent records back to Debezium envelopes without schemas.
+//
+//	Example:
+//		queue.Pipeline.SetSteps("debezium")
+//		queue.RegisterHandler(DebeziumUpdate, receiver)
+//		...
+//		change, err := GetDebeziumChangeEvent(message)
+type DebeziumMessageTransformer struct{}
+
+// Creates a new instance of the Debezium transformer.
+func NewDebeziumMessageTransformer() *DebeziumMessageTransformer {
+	return &DebeziumMessageTransformer{}
+}
+
+//	Converts a DebeziumChangeEvent record into a Debezium envelope.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message	a message to be sent
+//	Returns: error or nil for success.
+func (c *DebeziumMessageTransformer) Encode(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	if len(message.Message) == 0 {
+		return nil
+	}
+
+	change := &DebeziumChangeEvent{}
+	err := json.Unmarshal(message.Message, change)
+	if err != nil {
+		return err
+	}
+
+	op := ""
+	for code, operation := range debeziumOperations {
+		if operation == change.Operation {
+			op = code
+		}
+	}
+	if op == "" {
+		return cerr.NewBadRequestError(message.CorrelationId, "UNKNOWN_OPERATION",
+			"Unknown Debezium operation "+change.Operation).WithDetails("operation", change.Operation)
+	}
+
+	data, err := json.Marshal(&debeziumEnvelope{
+		Before: change.Before,
+		After:  change.After,
+		Op:     op,
+		Source: change.Source,
+		TsMs:   change.TsMs,
+	})
+	if err != nil {
+		return err
+	}
+
+	message.Message = data
+	return nil
+}
+
+//	Converts a received Debezium envelope into a DebeziumChangeEvent record.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message	a received message
+//	Returns: error or nil for success.
+func (c *DebeziumMessageTransformer) Decode(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	if len(message.Message) == 0 || string(message.Message) == "null" {
+		message.MessageType = DebeziumTombstone
+		message.Message = nil
+		return nil
+	}
+
+	envelope := &debeziumEnvelope{}
+	err := json.Unmarshal(message.Message, envelope)
+	if err != nil {
+		return err
+	}
+
+	// Unwrap envelopes with schemas
+	if len(envelope.Payload) > 0 {
+		payload := envelope.Payload
+		envelope = &debeziumEnvelope{}
+		err = json.Unmarshal(payload, envelope)
+		if err != nil {
+			return err
+		}
+	}
+
+	operation, ok := debeziumOperations[envelope.Op]
+	if !ok {
+		return cerr.NewBadRequestError(message.CorrelationId, "UNKNOWN_OPERATION",
+			"Unknown Debezium operation "+envelope.Op).WithDetails("operation", envelope.Op)
+	}
+
+	data, err := json.Marshal(&DebeziumChangeEvent{
+		Operation: operation,
+		Before:    envelope.Before,
+		After:     envelope.After,
+		Source:    envelope.Source,
+		TsMs:      envelope.TsMs,
+	})
+	if err != nil {
+		return err
+	}
+
+	message.MessageType = operation
+	message.Message = data
+	return nil
+}
+
+//	GetDebeziumChangeEvent gets a change record from a message decoded by DebeziumMessageTransformer.
+//	Parameters:
+//		- message *cqueues.MessageEnvelope	a decoded message
+//	Returns: the change record, nil for tombstones, or error.
+func GetDebeziumChangeEvent(message *cqueues.MessageEnvelope) (*DebeziumChangeEvent, error) {
+	if message.MessageType == DebeziumTombstone || len(message.Message) == 0 {
+		return nil, nil
+	}
+
+	change := &DebeziumChangeEvent{}
+	err := json.Unmarshal(message.Message, change)
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
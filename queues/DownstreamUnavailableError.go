@@ -0,0 +1,30 @@
+package queues
+
+import (
+	"errors"
+
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+)
+
+// DownstreamUnavailable is the error code that message receivers return
+// to signal that a downstream dependency is unavailable.
+// The queue pauses consumption and redelivers the message after it resumes.
+const DownstreamUnavailable = "DOWNSTREAM_UNAVAILABLE"
+
+//	NewDownstreamUnavailableError creates an error that pauses message consumption.
+//	Parameters:
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- message string	a human-readable description of the error.
+//	Returns: *cerr.ApplicationError
+func NewDownstreamUnavailableError(correlationId string, message string) *cerr.ApplicationError {
+	return cerr.NewConnectionError(correlationId, DownstreamUnavailable, message)
+}
+
+//	IsDownstreamUnavailableError checks if the error signals unavailable downstream dependency.
+//	Parameters:
+//		- err error	an error to check
+//	Returns: true if the error has DownstreamUnavailable code and false otherwise.
+func IsDownstreamUnavailableError(err error) bool {
+	var appErr *cerr.ApplicationError
+	return errors.As(err, &appErr) && appErr.Code == DownstreamUnavailable
+}
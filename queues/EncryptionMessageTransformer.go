@@ -0,0 +1,150 @@
+package queues
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+// Version of the encrypted payload format
+const encryptionFormatVersion byte = 1
+
+//	EncryptionMessageTransformer encrypts message payloads with AES-GCM using a separate data key
+//	for every record key. Record keys are message ids, so services that send messages about
+//	a subject (like a user) use the subject id as the message id.
+//	Deleting the subject key from the key store renders all historical messages of the subject
+//	unreadable (crypto-shredding), which erases personal data from immutable topics.
+//	Messages of deleted subjects are received with empty payloads.
+//
+//	The transformer is the built-in "encrypt" step of KafkaMessagePipeline.
+//
+//	References:
+//
+//		- *:key-store:*:*:1.0	IKafkaKeyStore with data keys of subjects
+type EncryptionMessageTransformer struct {
+	// The store of data keys.
+	KeyStore IKafkaKeyStore
+}
+
+// Creates a new instance of the encryption transformer.
+// The key store is set directly or resolved from references.
+func NewEncryptionMessageTransformer(keyStore IKafkaKeyStore) *EncryptionMessageTransformer {
+	return &EncryptionMessageTransformer{
+		KeyStore: keyStore,
+	}
+}
+
+//	Sets references to the key store.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references	references to locate the key store.
+func (c *EncryptionMessageTransformer) SetReferences(ctx context.Context, references cref.IReferences) {
+	descriptor := cref.NewDescriptor("*", "key-store", "*", "*", "1.0")
+	if keyStore, ok := references.GetOneOptional(descriptor).(IKafkaKeyStore); ok {
+		c.KeyStore = keyStore
+	}
+}
+
+//	Encrypts the message payload with the key of the message id.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message	a message to be sent
+//	Returns: error or nil for success.
+func (c *EncryptionMessageTransformer) Encode(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	err := c.checkMessage(message)
+	if err != nil {
+		return err
+	}
+
+	key, err := c.KeyStore.CreateKey(ctx, message.CorrelationId, message.MessageId)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := newGcm(key)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+
+	// The message id is authenticated, so payloads cannot be moved between subjects
+	payload := make([]byte, 0, 1+len(nonce)+len(message.Message)+gcm.Overhead())
+	payload = append(payload, encryptionFormatVersion)
+	payload = append(payload, nonce...)
+	message.Message = gcm.Seal(payload, nonce, message.Message, []byte(message.MessageId))
+	return nil
+}
+
+//	Decrypts the message payload with the key of the message id.
+//	Payloads of subjects with deleted keys are cleared.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message	a received message
+//	Returns: error or nil for success.
+func (c *EncryptionMessageTransformer) Decode(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	err := c.checkMessage(message)
+	if err != nil {
+		return err
+	}
+
+	key, err := c.KeyStore.GetKey(ctx, message.CorrelationId, message.MessageId)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		// The subject was erased
+		message.Message = nil
+		return nil
+	}
+
+	gcm, err := newGcm(key)
+	if err != nil {
+		return err
+	}
+
+	payload := message.Message
+	if len(payload) < 1+gcm.NonceSize() || payload[0] != encryptionFormatVersion {
+		return cerr.NewBadRequestError(message.CorrelationId, "NOT_ENCRYPTED", "Message payload is not encrypted").
+			WithDetails("message_id", message.MessageId)
+	}
+
+	nonce := payload[1 : 1+gcm.NonceSize()]
+	data, err := gcm.Open(nil, nonce, payload[1+gcm.NonceSize():], []byte(message.MessageId))
+	if err != nil {
+		return err
+	}
+
+	message.Message = data
+	return nil
+}
+
+// Checks that the key store is set and the message has a subject
+func (c *EncryptionMessageTransformer) checkMessage(message *cqueues.MessageEnvelope) error {
+	if c.KeyStore == nil {
+		return cerr.NewConfigError(message.CorrelationId, "NO_KEY_STORE", "Key store is not set")
+	}
+	if message.MessageId == "" {
+		return cerr.NewBadRequestError(message.CorrelationId, "NO_MESSAGE_ID",
+			"Message id is required to select the encryption key")
+	}
+	return nil
+}
+
+// Creates an AES-GCM cipher for a key
+func newGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,77 @@
+package queues
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+// GzipMessageTransformer compresses message payloads with gzip on send
+// and decompresses them on receive. Buffers and gzip writers and readers
+// are reused between messages to reduce allocations.
+type GzipMessageTransformer struct{}
+
+// Creates a new instance of the gzip transformer.
+func NewGzipMessageTransformer() *GzipMessageTransformer {
+	return &GzipMessageTransformer{}
+}
+
+//	Compresses the message payload.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message	a message to be sent
+//	Returns: error or nil for success.
+func (c *GzipMessageTransformer) Encode(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+
+	writer := gzipWriterPool.Get().(*gzip.Writer)
+	defer gzipWriterPool.Put(writer)
+	writer.Reset(buffer)
+
+	_, err := writer.Write(message.Message)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	message.Message = detachBuffer(buffer)
+	return nil
+}
+
+//	Decompresses the message payload.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message	a received message
+//	Returns: error or nil for success.
+func (c *GzipMessageTransformer) Decode(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	var reader *gzip.Reader
+	var err error
+	if pooled, ok := gzipReaderPool.Get().(*gzip.Reader); ok {
+		reader = pooled
+		err = reader.Reset(bytes.NewReader(message.Message))
+	} else {
+		reader, err = gzip.NewReader(bytes.NewReader(message.Message))
+	}
+	if err != nil {
+		return err
+	}
+	defer gzipReaderPool.Put(reader)
+	defer reader.Close()
+
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+
+	_, err = buffer.ReadFrom(reader)
+	if err != nil {
+		return err
+	}
+
+	message.Message = detachBuffer(buffer)
+	return nil
+}
@@ -0,0 +1,19 @@
+package queues
+
+import (
+	"context"
+)
+
+// IKafkaKeyStore keeps data encryption keys of subjects used by EncryptionMessageTransformer.
+// Deleting a subject key renders all messages encrypted with it unreadable,
+// which erases personal data from immutable topics (crypto-shredding).
+type IKafkaKeyStore interface {
+	// Gets the key of a subject or nil when the subject has no key or the key was deleted.
+	GetKey(ctx context.Context, correlationId string, subject string) ([]byte, error)
+
+	// Gets the key of a subject and creates a new one when the subject has no key.
+	CreateKey(ctx context.Context, correlationId string, subject string) ([]byte, error)
+
+	// Deletes the key of a subject.
+	DeleteKey(ctx context.Context, correlationId string, subject string) error
+}
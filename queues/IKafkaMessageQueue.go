@@ -0,0 +1,42 @@
+package queues
+
+import (
+	"context"
+	"time"
+
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+// IKafkaMessageQueue is the public surface of Kafka message queues.
+// Services can depend on it instead of KafkaMessageQueue
+// to replace the queue with MockKafkaMessageQueue in unit tests.
+//
+//	See KafkaMessageQueue
+//	See MockKafkaMessageQueue
+type IKafkaMessageQueue interface {
+	cqueues.IMessageQueue
+
+	// Clears messages collected by the queue.
+	Clear(ctx context.Context, correlationId string) error
+
+	// Registers a receiver for messages of a specific type.
+	RegisterHandler(messageType string, receiver cqueues.IMessageReceiver)
+
+	// Unregisters a receiver of a specific message type.
+	UnregisterHandler(messageType string)
+
+	// Registers a fallback receiver for messages without type specific handlers.
+	RegisterDefaultHandler(receiver cqueues.IMessageReceiver)
+
+	// Sets a predicate that accepts received messages.
+	SetFilter(predicate func(message *cqueues.MessageEnvelope) bool)
+
+	// Pauses consumption of messages.
+	Pause(ctx context.Context, correlationId string, timeout time.Duration) error
+
+	// Resumes previously paused consumption of messages.
+	Resume(ctx context.Context, correlationId string) error
+
+	// Checks if consumption of messages is paused.
+	IsPaused() bool
+}
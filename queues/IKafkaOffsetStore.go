@@ -0,0 +1,19 @@
+package queues
+
+import (
+	"context"
+)
+
+// IKafkaOffsetStore keeps consumed offsets of KafkaMessageQueue outside of Kafka,
+// for example in the service's own database. When the store saves offsets in the same
+// transaction as business writes, received messages are processed exactly once.
+// The context passed to Complete reaches SaveOffset, so the store can join
+// a transaction carried by the context.
+type IKafkaOffsetStore interface {
+	// ReadOffsets reads the next offsets to consume by partitions.
+	// Partitions without saved offsets are omitted.
+	ReadOffsets(ctx context.Context, correlationId string, topic string, groupId string) (map[int32]int64, error)
+
+	// SaveOffset saves the next offset to consume from a partition.
+	SaveOffset(ctx context.Context, correlationId string, topic string, groupId string, partition int32, offset int64) error
+}
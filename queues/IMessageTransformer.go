@@ -0,0 +1,17 @@
+package queues
+
+import (
+	"context"
+
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+// IMessageTransformer is a step of the message transformation pipeline,
+// like compression, encryption or schema upcasting.
+type IMessageTransformer interface {
+	// Encode transforms a message before it is sent.
+	Encode(ctx context.Context, message *cqueues.MessageEnvelope) error
+
+	// Decode applies the inverse transformation to a received message.
+	Decode(ctx context.Context, message *cqueues.MessageEnvelope) error
+}
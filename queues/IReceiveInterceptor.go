@@ -0,0 +1,20 @@
+package queues
+
+import (
+	"context"
+
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+// ReceiveHandler delivers a received message or passes it to the next interceptor in the chain.
+type ReceiveHandler func(ctx context.Context, message *cqueues.MessageEnvelope) error
+
+// IReceiveInterceptor intercepts messages received by KafkaMessageQueue
+// before they are delivered to receivers or put into the queue.
+// Interceptors are called in the order they were added.
+// Kafka headers of the message are available through GetMessageHeader.
+// An interceptor that returns without calling next drops the message.
+type IReceiveInterceptor interface {
+	// InterceptReceive is called for every received message.
+	InterceptReceive(ctx context.Context, message *cqueues.MessageEnvelope, next ReceiveHandler) error
+}
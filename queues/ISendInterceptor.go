@@ -0,0 +1,22 @@
+package queues
+
+import (
+	"context"
+
+	kafka "github.com/Shopify/sarama"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+// SendHandler sends a message to Kafka or passes it to the next interceptor in the chain.
+type SendHandler func(ctx context.Context, correlationId string, envelope *cqueues.MessageEnvelope, msg *kafka.ProducerMessage) error
+
+// ISendInterceptor intercepts messages sent by KafkaMessageQueue.
+// Interceptors are called in the order they were added and can audit, measure
+// or enrich messages and stamp Kafka headers before calling the next handler.
+// An interceptor that returns without calling next cancels sending.
+type ISendInterceptor interface {
+	// InterceptSend is called for every sent message with the envelope
+	// and the Kafka message created from it.
+	InterceptSend(ctx context.Context, correlationId string, envelope *cqueues.MessageEnvelope,
+		msg *kafka.ProducerMessage, next SendHandler) error
+}
@@ -0,0 +1,174 @@
+package queues
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	ccount "github.com/pip-services3-gox/pip-services3-components-gox/count"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+// Sinks of the audit trail of sent messages
+const (
+	// Audit records are written to the logger at info level
+	AuditLog = "log"
+	// Audit records are published as JSON to the audit topic
+	AuditTopic = "topic"
+	// Audit records are counted by topics and results via ICounters
+	AuditCounters = "counters"
+)
+
+// Results of audited sends
+const (
+	AuditSent   = "sent"
+	AuditFailed = "failed"
+)
+
+// KafkaAuditRecord describes a sent message in the audit trail
+type KafkaAuditRecord struct {
+	// Time the message was sent
+	Time time.Time `json:"time"`
+	// Topic the message was sent to
+	Topic string `json:"topic"`
+	// Partition the message was written to, -1 when sending failed
+	Partition int32 `json:"partition"`
+	// Offset of the written message, -1 when sending failed
+	Offset int64 `json:"offset"`
+	// Record key
+	Key string `json:"key"`
+	// Message id of the envelope
+	MessageId string `json:"message_id"`
+	// Correlation id of the envelope
+	CorrelationId string `json:"correlation_id"`
+	// Message type of the envelope
+	MessageType string `json:"message_type"`
+	// Size of the record value in bytes
+	Size int `json:"size"`
+	// Result of sending: "sent" or "failed"
+	Result string `json:"result"`
+	// Error of a failed send
+	Error string `json:"error,omitempty"`
+}
+
+//	KafkaAuditInterceptor records every message sent by KafkaMessageQueue to audit sinks:
+//	the logger, an audit topic or performance counters.
+//	The queue adds it as the last send interceptor when options.audit is set,
+//	so records reflect the final messages after other interceptors.
+//	Failures to write audit records are logged and do not fail sending.
+//
+//	Audit counters:
+//
+//		- audit.<topic>.sent:         number of sent messages
+//		- audit.<topic>.failed:       number of messages that failed to be sent
+//		- audit.<topic>.sent_bytes:   total size of sent messages
+type KafkaAuditInterceptor struct {
+	// Names of audit sinks: "log", "topic" or "counters".
+	Sinks []string
+	// Topic of audit records for the "topic" sink.
+	Topic string
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The performance counters.
+	Counters *ccount.CompositeCounters
+	// The Kafka connection to publish audit records.
+	Connection connect.IKafkaConnection
+}
+
+//	Creates a new instance of the audit interceptor.
+//	Parameters:
+//		- sinks ...string	names of audit sinks: "log", "topic" or "counters"
+//	Returns: *KafkaAuditInterceptor
+func NewKafkaAuditInterceptor(sinks ...string) *KafkaAuditInterceptor {
+	return &KafkaAuditInterceptor{
+		Sinks:    sinks,
+		Logger:   clog.NewCompositeLogger(),
+		Counters: ccount.NewCompositeCounters(),
+	}
+}
+
+//	Sends the message and records the result in the audit sinks.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- envelope	a message envelope
+//		- msg	the Kafka message created from the envelope
+//		- next	the next handler in the chain
+//	Returns: the error of sending or nil for success.
+func (c *KafkaAuditInterceptor) InterceptSend(ctx context.Context, correlationId string, envelope *cqueues.MessageEnvelope,
+	msg *kafka.ProducerMessage, next SendHandler) error {
+
+	err := next(ctx, correlationId, envelope, msg)
+
+	record := &KafkaAuditRecord{
+		Time:          time.Now().UTC(),
+		Topic:         msg.Topic,
+		Partition:     msg.Partition,
+		Offset:        msg.Offset,
+		MessageId:     envelope.MessageId,
+		CorrelationId: envelope.CorrelationId,
+		MessageType:   envelope.MessageType,
+		Result:        AuditSent,
+	}
+	if msg.Key != nil {
+		if key, keyErr := msg.Key.Encode(); keyErr == nil {
+			record.Key = string(key)
+		}
+	}
+	if msg.Value != nil {
+		record.Size = msg.Value.Length()
+	}
+	if err != nil {
+		record.Result = AuditFailed
+		record.Error = err.Error()
+		record.Partition = -1
+		record.Offset = -1
+	}
+
+	c.writeRecord(ctx, correlationId, record)
+	return err
+}
+
+// Writes an audit record to all sinks
+func (c *KafkaAuditInterceptor) writeRecord(ctx context.Context, correlationId string, record *KafkaAuditRecord) {
+	for _, sink := range c.Sinks {
+		switch sink {
+		case AuditLog:
+			c.Logger.Info(ctx, record.CorrelationId, "Audit: %s message %s of type %s to %s:%d:%d, key %s, %d bytes",
+				record.Result, record.MessageId, record.MessageType, record.Topic, record.Partition, record.Offset,
+				record.Key, record.Size)
+		case AuditCounters:
+			c.Counters.IncrementOne(ctx, "audit."+record.Topic+"."+record.Result)
+			if record.Result == AuditSent {
+				c.Counters.Increment(ctx, "audit."+record.Topic+".sent_bytes", int64(record.Size))
+			}
+		case AuditTopic:
+			err := c.publishRecord(ctx, record)
+			if err != nil {
+				c.Logger.Error(ctx, correlationId, err, "Failed to write audit record of message %s to %s",
+					record.MessageId, c.Topic)
+			}
+		}
+	}
+}
+
+// Publishes an audit record to the audit topic
+func (c *KafkaAuditInterceptor) publishRecord(ctx context.Context, record *KafkaAuditRecord) error {
+	if c.Connection == nil || c.Topic == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return c.Connection.Publish(ctx, c.Topic, []*kafka.ProducerMessage{{
+		Key:       kafka.StringEncoder(record.MessageId),
+		Value:     kafka.ByteEncoder(data),
+		Timestamp: record.Time,
+	}})
+}
@@ -0,0 +1,50 @@
+package queues
+
+import (
+	"bytes"
+	"compress/gzip"
+	"sync"
+)
+
+// Buffers larger than this are not returned to the pool, so rare huge payloads don't pin memory
+const maxPooledBufferSize = 1 << 20
+
+// Reusable byte buffers of payload transformations
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+// Reusable gzip writers, each of them allocates large compression tables
+var gzipWriterPool = sync.Pool{
+	New: func() any {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// Reusable gzip readers
+var gzipReaderPool = sync.Pool{}
+
+// Takes an empty buffer from the pool
+func getBuffer() *bytes.Buffer {
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	buffer.Reset()
+	return buffer
+}
+
+// Returns a buffer to the pool. The buffer content must not be used after that.
+func putBuffer(buffer *bytes.Buffer) {
+	if buffer.Cap() > maxPooledBufferSize {
+		return
+	}
+	bufferPool.Put(buffer)
+}
+
+// Copies the buffer content into a new slice of the exact size,
+// so the result stays valid after the buffer is returned to the pool
+func detachBuffer(buffer *bytes.Buffer) []byte {
+	result := make([]byte, buffer.Len())
+	copy(result, buffer.Bytes())
+	return result
+}
@@ -0,0 +1,38 @@
+package queues
+
+import (
+	"context"
+)
+
+// Canary modes of KafkaMessageQueue
+const (
+	// The canary consumes a fraction of topic partitions
+	CanaryPartitions = "partitions"
+	// The canary consumes a random sample of messages from all partitions
+	CanaryMessages = "messages"
+)
+
+type canaryContextKey struct{}
+
+//	WithCanary returns a copy of the context that makes Listen run a canary consumer.
+//	The canary joins a separate consumer group, never commits offsets and handles
+//	only a fraction of messages set by options.canary_fraction, so a new handler version
+//	can run alongside production consumers without affecting them.
+//	Parameters:
+//		- ctx context.Context	a parent context
+//	Returns: a context with the canary flag.
+func WithCanary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, canaryContextKey{}, true)
+}
+
+//	IsCanary checks if the context has the canary flag.
+//	Parameters:
+//		- ctx context.Context	a context
+//	Returns: true if the context was created by WithCanary and false otherwise.
+func IsCanary(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	canary, _ := ctx.Value(canaryContextKey{}).(bool)
+	return canary
+}
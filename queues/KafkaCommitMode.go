@@ -0,0 +1,130 @@
+package queues
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+)
+
+// Modes of committing offsets of consumed messages by KafkaMessageQueue
+const (
+	// Offsets are committed within the consume loop, which waits for every commit
+	CommitSync = "sync"
+	// Offsets are committed by a background committer with periodic synchronous commits
+	CommitAsync = "async"
+)
+
+// Commits offsets of a consumer group session in the background, so the consume loop
+// only marks offsets. Offsets marked between commits are committed together.
+// When the last synchronous commit is older than the sync interval, the next commit
+// is made synchronously, so the consume loop can't get far ahead of committed offsets.
+type kafkaAsyncCommitter struct {
+	session      kafka.ConsumerGroupSession
+	syncInterval time.Duration
+	onCommit     func(ctx context.Context, offsets map[string]map[int32]int64, err error)
+
+	lock     sync.Mutex
+	pending  map[string]map[int32]int64
+	lastSync time.Time
+	signal   chan struct{}
+	stopped  chan struct{}
+}
+
+// Creates a committer of the session and starts committing in the background
+func newKafkaAsyncCommitter(session kafka.ConsumerGroupSession, syncInterval time.Duration,
+	onCommit func(ctx context.Context, offsets map[string]map[int32]int64, err error)) *kafkaAsyncCommitter {
+
+	c := &kafkaAsyncCommitter{
+		session:      session,
+		syncInterval: syncInterval,
+		onCommit:     onCommit,
+		pending:      make(map[string]map[int32]int64),
+		lastSync:     time.Now(),
+		signal:       make(chan struct{}, 1),
+		stopped:      make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// Requests a commit of the marked offset
+func (c *kafkaAsyncCommitter) commit(topic string, partition int32, offset int64) {
+	c.lock.Lock()
+	if c.pending[topic] == nil {
+		c.pending[topic] = make(map[int32]int64)
+	}
+	c.pending[topic][partition] = offset
+	due := c.syncInterval > 0 && time.Since(c.lastSync) >= c.syncInterval
+	c.lock.Unlock()
+
+	if due {
+		c.flush(true)
+		return
+	}
+
+	select {
+	case c.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Commits pending offsets until the session ends
+func (c *kafkaAsyncCommitter) run() {
+	defer close(c.stopped)
+	for {
+		select {
+		case <-c.signal:
+			c.flush(false)
+		case <-c.session.Context().Done():
+			return
+		}
+	}
+}
+
+// Commits pending offsets and reports them. Synchronous commits restart the sync interval.
+func (c *kafkaAsyncCommitter) flush(sync bool) {
+	c.lock.Lock()
+	offsets := c.pending
+	c.pending = make(map[string]map[int32]int64)
+	if sync {
+		c.lastSync = time.Now()
+	}
+	c.lock.Unlock()
+
+	if len(offsets) == 0 {
+		return
+	}
+	c.session.Commit()
+	c.onCommit(c.session.Context(), offsets, nil)
+}
+
+// Checks if a consumer error is a failed offset commit that succeeds when it is sent again,
+// like commits during rebalances or after the group coordinator moved
+func isRetriableCommitError(err error) bool {
+	var kerr kafka.KError
+	if !errors.As(err, &kerr) {
+		return false
+	}
+	switch kerr {
+	case kafka.ErrRebalanceInProgress, kafka.ErrNotCoordinatorForConsumer, kafka.ErrConsumerCoordinatorNotAvailable,
+		kafka.ErrOffsetsLoadInProgress, kafka.ErrRequestTimedOut, kafka.ErrIncompleteResponse:
+		return true
+	default:
+		return false
+	}
+}
+
+// Checks if the session is the committed one. Shared consumers wrap sessions
+// for every listener, so sessions are compared by their contexts.
+func (c *kafkaAsyncCommitter) owns(session kafka.ConsumerGroupSession) bool {
+	return session != nil && c.session.Context() == session.Context()
+}
+
+// Waits for the background committer and commits remaining offsets synchronously
+func (c *kafkaAsyncCommitter) stop() {
+	<-c.stopped
+	c.flush(true)
+}
@@ -0,0 +1,50 @@
+package queues
+
+import (
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+// Policies of handling received records that fail pipeline decoding or upcasting
+const (
+	// The partition stops consuming at the record without committing it, so it is read again after restart
+	DeserializeErrorFail = "fail"
+	// The record is reported and committed, so the partition moves on and the record is lost
+	DeserializeErrorSkip = "skip"
+	// The record is copied to the dead letter topic and committed
+	DeserializeErrorDeadLetter = "dead_letter"
+	// The record is received as a raw message with its original bytes, see IsRawMessage
+	DeserializeErrorRaw = "raw"
+)
+
+// ContentTypeHeader is the Kafka header that describes the format of the record value
+const ContentTypeHeader = "content_type"
+
+// ContentTypeRaw marks records received as raw bytes because they could not be decoded
+const ContentTypeRaw = "raw"
+
+//	IsRawMessage checks if a received message carries raw bytes of a record that could not be decoded,
+//	like truncated JSON, invalid UTF-8 or a foreign format. Such messages are received
+//	when options.on_deserialize_error is "raw", so topics with mixed producers remain consumable.
+//	Parameters:
+//		- message *cqueues.MessageEnvelope	a received message
+//	Returns: true if the message has the raw content type and false otherwise.
+func IsRawMessage(message *cqueues.MessageEnvelope) bool {
+	return GetMessageHeader(message, ContentTypeHeader) == ContentTypeRaw
+}
+
+// DeadLetterSuffix is added to the topic name to get the default dead letter topic
+const DeadLetterSuffix = ".dlq"
+
+// Headers added to records moved to dead letter topics
+const (
+	// Topic the record was received from
+	DeadLetterTopicHeader = "dlq_topic"
+	// Partition the record was received from
+	DeadLetterPartitionHeader = "dlq_partition"
+	// Offset of the record in the original partition
+	DeadLetterOffsetHeader = "dlq_offset"
+	// Consumer group that moved the record
+	DeadLetterGroupHeader = "dlq_group"
+	// Reason the record was moved to the dead letter topic
+	DeadLetterErrorHeader = "dlq_error"
+)
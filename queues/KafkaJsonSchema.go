@@ -0,0 +1,248 @@
+package queues
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"sync"
+
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cvalid "github.com/pip-services3-gox/pip-services3-commons-gox/validate"
+)
+
+//	KafkaJsonSchema validates JSON payloads with a JSON Schema document.
+//	It implements ISchema, so it is used wherever pip-services validation schemas are accepted.
+//
+//	Supported keywords: type, enum, const, required, properties, additionalProperties (true or false),
+//	items, minItems, maxItems, minLength, maxLength, pattern, minimum, maximum.
+//	Other keywords are ignored.
+//
+//	Example:
+//		schema, err := queues.NewKafkaJsonSchema(`{
+//			"type": "object",
+//			"required": ["id", "amount"],
+//			"properties": {
+//				"id": {"type": "string"},
+//				"amount": {"type": "number", "minimum": 0}
+//			}
+//		}`)
+//		queue.SetSchema("order_created", schema)
+type KafkaJsonSchema struct {
+	schema   map[string]any
+	patterns map[string]*regexp.Regexp
+	lock     sync.Mutex
+}
+
+//	Creates a new schema from a JSON Schema document.
+//	Parameters:
+//		- document string	a JSON Schema document
+//	Returns: the schema or error when the document is not valid JSON.
+func NewKafkaJsonSchema(document string) (*KafkaJsonSchema, error) {
+	schema := map[string]any{}
+	err := json.Unmarshal([]byte(document), &schema)
+	if err != nil {
+		return nil, cerr.NewConfigError("", "INVALID_SCHEMA", "JSON schema is not a valid JSON document").WithCause(err)
+	}
+	return &KafkaJsonSchema{
+		schema:   schema,
+		patterns: make(map[string]*regexp.Regexp),
+	}, nil
+}
+
+//	Validates a value against the schema.
+//	Parameters:
+//		- value any	a decoded JSON value or a JSON document as []byte
+//	Returns: a list of validation results.
+func (c *KafkaJsonSchema) Validate(value any) []*cvalid.ValidationResult {
+	if data, ok := value.([]byte); ok {
+		err := json.Unmarshal(data, &value)
+		if err != nil {
+			return []*cvalid.ValidationResult{
+				cvalid.NewValidationResult("", cvalid.Error, "INVALID_JSON", "Value is not a valid JSON document", nil, nil),
+			}
+		}
+	}
+
+	results := make([]*cvalid.ValidationResult, 0)
+	c.validateValue("", c.schema, value, &results)
+	return results
+}
+
+//	Validates a value and returns ValidationException if errors were found.
+//	Parameters:
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- value any	a value to be validated
+//		- strict bool	true to treat warnings as errors
+//	Returns: the validation error or nil when the value is valid.
+func (c *KafkaJsonSchema) ValidateAndReturnError(correlationId string, value any, strict bool) *cerr.ApplicationError {
+	return cvalid.NewValidationErrorFromResults(correlationId, c.Validate(value), strict)
+}
+
+//	Validates a value and panics with ValidationException if errors were found.
+//	Parameters:
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- value any	a value to be validated
+//		- strict bool	true to treat warnings as errors
+func (c *KafkaJsonSchema) ValidateAndThrowError(correlationId string, value any, strict bool) {
+	cvalid.ThrowValidationErrorIfNeeded(correlationId, c.Validate(value), strict)
+}
+
+func (c *KafkaJsonSchema) validateValue(path string, schema map[string]any, value any, results *[]*cvalid.ValidationResult) {
+	addError := func(code string, message string, expected any) {
+		name := path
+		if name == "" {
+			name = "value"
+		}
+		*results = append(*results, cvalid.NewValidationResult(path, cvalid.Error, code, name+" "+message, expected, value))
+	}
+
+	if expected, ok := schema["type"]; ok && !matchesJsonType(expected, value) {
+		addError("VALUE_TYPE_MISMATCH", fmt.Sprintf("must be of type %v", expected), expected)
+		return
+	}
+
+	if values, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, item := range values {
+			found = found || jsonEqual(item, value)
+		}
+		if !found {
+			addError("VALUE_NOT_INCLUDED", fmt.Sprintf("must be one of %v", values), values)
+		}
+	}
+	if expected, ok := schema["const"]; ok && !jsonEqual(expected, value) {
+		addError("VALUE_NOT_EQUAL", fmt.Sprintf("must be equal to %v", expected), expected)
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		c.validateObject(path, schema, v, results, addError)
+	case []any:
+		if limit, ok := schema["minItems"].(float64); ok && float64(len(v)) < limit {
+			addError("TOO_FEW_ITEMS", fmt.Sprintf("must have at least %v items", limit), limit)
+		}
+		if limit, ok := schema["maxItems"].(float64); ok && float64(len(v)) > limit {
+			addError("TOO_MANY_ITEMS", fmt.Sprintf("must have at most %v items", limit), limit)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				c.validateValue(path+"["+strconv.Itoa(i)+"]", items, item, results)
+			}
+		}
+	case string:
+		length := float64(len([]rune(v)))
+		if limit, ok := schema["minLength"].(float64); ok && length < limit {
+			addError("TOO_SHORT", fmt.Sprintf("must be at least %v characters long", limit), limit)
+		}
+		if limit, ok := schema["maxLength"].(float64); ok && length > limit {
+			addError("TOO_LONG", fmt.Sprintf("must be at most %v characters long", limit), limit)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re := c.getPattern(pattern); re != nil && !re.MatchString(v) {
+				addError("PATTERN_MISMATCH", "must match pattern "+pattern, pattern)
+			}
+		}
+	case float64:
+		if limit, ok := schema["minimum"].(float64); ok && v < limit {
+			addError("TOO_SMALL", fmt.Sprintf("must be at least %v", limit), limit)
+		}
+		if limit, ok := schema["maximum"].(float64); ok && v > limit {
+			addError("TOO_BIG", fmt.Sprintf("must be at most %v", limit), limit)
+		}
+	}
+}
+
+func (c *KafkaJsonSchema) validateObject(path string, schema map[string]any, value map[string]any,
+	results *[]*cvalid.ValidationResult, addError func(code string, message string, expected any)) {
+
+	if required, ok := schema["required"].([]any); ok {
+		for _, name := range required {
+			if property, ok := name.(string); ok {
+				if _, exists := value[property]; !exists {
+					*results = append(*results, cvalid.NewValidationResult(joinJsonPath(path, property), cvalid.Error,
+						"VALUE_IS_NULL", joinJsonPath(path, property)+" must not be null", "NOT NULL", nil))
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+	for name, propertyValue := range value {
+		if propertySchema, ok := properties[name].(map[string]any); ok {
+			c.validateValue(joinJsonPath(path, name), propertySchema, propertyValue, results)
+		} else if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+			*results = append(*results, cvalid.NewValidationResult(joinJsonPath(path, name), cvalid.Error,
+				"UNEXPECTED_PROPERTY", "Found unexpected property "+joinJsonPath(path, name), nil, name))
+		}
+	}
+}
+
+// Gets a compiled pattern, invalid patterns are ignored
+func (c *KafkaJsonSchema) getPattern(pattern string) *regexp.Regexp {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if re, ok := c.patterns[pattern]; ok {
+		return re
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		re = nil
+	}
+	c.patterns[pattern] = re
+	return re
+}
+
+// Checks if a decoded JSON value matches one or several JSON Schema types
+func matchesJsonType(expected any, value any) bool {
+	if types, ok := expected.([]any); ok {
+		for _, typ := range types {
+			if matchesJsonType(typ, value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch expected {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	default:
+		return true
+	}
+}
+
+// Compares decoded JSON values
+func jsonEqual(a any, b any) bool {
+	left, _ := json.Marshal(a)
+	right, _ := json.Marshal(b)
+	return string(left) == string(right)
+}
+
+// Adds a property name to a path of a JSON value
+func joinJsonPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,117 @@
+package queues
+
+import (
+	"strings"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+//	KafkaMessageFilter selects received messages by their types and header values.
+//	Messages that do not match the filter are skipped and committed without invoking the receiver.
+//
+//	Configuration parameters:
+//
+//		- options:
+//			- filter_message_types:	(optional) list of accepted message types (default: all, set for example: "order.created;order.updated")
+//			- filter_headers:		(optional) list of required header values (default: none, set for example: "tenant=abc;source=web")
+type KafkaMessageFilter struct {
+	// Accepted message types
+	MessageTypes []string
+	// Required header values
+	Headers map[string]string
+}
+
+//	Creates a new instance of the message filter.
+func NewKafkaMessageFilter() *KafkaMessageFilter {
+	return &KafkaMessageFilter{
+		MessageTypes: make([]string, 0),
+		Headers:      make(map[string]string),
+	}
+}
+
+//	Creates a new message filter from configuration parameters.
+//	Parameters:
+//		- config	configuration parameters
+//	Returns: a created filter.
+func NewKafkaMessageFilterFromConfig(config *cconf.ConfigParams) *KafkaMessageFilter {
+	c := NewKafkaMessageFilter()
+
+	if types, ok := config.GetAsNullableString("options.filter_message_types"); ok {
+		for _, messageType := range strings.Split(types, ";") {
+			messageType = strings.TrimSpace(messageType)
+			if messageType != "" {
+				c.MessageTypes = append(c.MessageTypes, messageType)
+			}
+		}
+	}
+
+	if headers, ok := config.GetAsNullableString("options.filter_headers"); ok {
+		for _, header := range strings.Split(headers, ";") {
+			pos := strings.Index(header, "=")
+			if pos <= 0 {
+				continue
+			}
+			c.Headers[strings.TrimSpace(header[:pos])] = strings.TrimSpace(header[pos+1:])
+		}
+	}
+
+	return c
+}
+
+//	Checks if the filter has no conditions and accepts all messages.
+//	Returns: true if the filter is empty and false otherwise.
+func (c *KafkaMessageFilter) IsEmpty() bool {
+	return len(c.MessageTypes) == 0 && len(c.Headers) == 0
+}
+
+//	Checks if a message matches the filter.
+//	Parameters:
+//		- message	a message to check
+//	Returns: true if the message matches and false otherwise.
+func (c *KafkaMessageFilter) Match(message *cqueues.MessageEnvelope) bool {
+	if len(c.MessageTypes) > 0 {
+		found := false
+		for _, messageType := range c.MessageTypes {
+			if messageType == message.MessageType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for key, value := range c.Headers {
+		if GetMessageHeader(message, key) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+//	Gets a Kafka header of a received message.
+//	Parameters:
+//		- message	a received message
+//		- key	a header key
+//	Returns: the header value or empty string if the header is not set.
+func GetMessageHeader(message *cqueues.MessageEnvelope, key string) string {
+	msg, ok := message.GetReference().(*connect.KafkaMessage)
+	if !ok || msg == nil || msg.Message == nil {
+		return ""
+	}
+	return getHeaderByKey(msg.Message.Headers, key)
+}
+
+func getHeaderByKey(headers []*kafka.RecordHeader, key string) string {
+	for _, header := range headers {
+		if key == string(header.Key) {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
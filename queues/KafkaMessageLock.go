@@ -0,0 +1,33 @@
+package queues
+
+import (
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+)
+
+// A partition of a received topic
+type kafkaPartition struct {
+	topic     string
+	partition int32
+}
+
+// Emulates a message lock of RenewLock. While a message holds the lock, its partition
+// is paused and commits of later messages from the partition are deferred, since committing
+// a later offset would commit the locked message as well.
+type kafkaMessageLock struct {
+	session kafka.ConsumerGroupSession
+	offset  int64
+	expires time.Time
+	timer   *time.Timer
+
+	// The commit of the latest completed message after the locked one
+	deferred       func()
+	deferredOffset int64
+}
+
+// Checks if the lock was taken in the session. Shared consumers wrap sessions
+// for every listener, so sessions are compared by their contexts.
+func (c *kafkaMessageLock) ownedBy(session kafka.ConsumerGroupSession) bool {
+	return c.session != nil && session != nil && c.session.Context() == session.Context()
+}
@@ -0,0 +1,164 @@
+package queues
+
+import (
+	"context"
+	"strings"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+//	KafkaMessagePipeline is an ordered list of named transformation steps.
+//	Received messages are decoded by the steps in the pipeline order
+//	and sent messages are encoded by the steps in the reverse order.
+//
+//	Built-in steps:
+//		- gzip:	compresses message payloads
+//		- debezium:	decodes Debezium change-event envelopes, see DebeziumMessageTransformer
+//		- encrypt:	encrypts message payloads with keys of record keys, see EncryptionMessageTransformer
+//
+//	Configuration parameters:
+//
+//		- options:
+//			- pipeline:	(optional) list of transformation step names (default: none, set for example: "gzip;decrypt;upcast")
+//
+//	References:
+//
+//		- *:message-transformer:<name>:*:1.0	(optional) IMessageTransformer steps resolved by their names
+//		- *:key-store:*:*:1.0	(optional) IKafkaKeyStore used by the encrypt step
+type KafkaMessagePipeline struct {
+	names        []string
+	transformers map[string]IMessageTransformer
+}
+
+//	Creates a new instance of the pipeline with built-in steps.
+func NewKafkaMessagePipeline() *KafkaMessagePipeline {
+	return &KafkaMessagePipeline{
+		names: make([]string, 0),
+		transformers: map[string]IMessageTransformer{
+			"gzip":     NewGzipMessageTransformer(),
+			"debezium": NewDebeziumMessageTransformer(),
+			"encrypt":  NewEncryptionMessageTransformer(nil),
+		},
+	}
+}
+
+//	Configures the pipeline by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config	configuration parameters to be set.
+func (c *KafkaMessagePipeline) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	if pipeline, ok := config.GetAsNullableString("options.pipeline"); ok {
+		c.names = make([]string, 0)
+		for _, name := range strings.Split(pipeline, ";") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				c.names = append(c.names, name)
+			}
+		}
+	}
+}
+
+//	Sets references to transformation steps.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references	references to locate the steps.
+func (c *KafkaMessagePipeline) SetReferences(ctx context.Context, references cref.IReferences) {
+	for _, transformer := range c.transformers {
+		if referenceable, ok := transformer.(cref.IReferenceable); ok {
+			referenceable.SetReferences(ctx, references)
+		}
+	}
+
+	for _, name := range c.names {
+		descriptor := cref.NewDescriptor("*", "message-transformer", name, "*", "1.0")
+		if transformer, ok := references.GetOneOptional(descriptor).(IMessageTransformer); ok {
+			c.transformers[name] = transformer
+		}
+	}
+}
+
+//	Registers a named transformation step.
+//	Parameters:
+//		- name string	a step name used in the pipeline configuration
+//		- transformer IMessageTransformer	the step implementation
+func (c *KafkaMessagePipeline) Register(name string, transformer IMessageTransformer) {
+	c.transformers[name] = transformer
+}
+
+//	Sets the ordered list of steps.
+//	Parameters:
+//		- names ...string	step names
+func (c *KafkaMessagePipeline) SetSteps(names ...string) {
+	c.names = names
+}
+
+//	Validates that all steps of the pipeline are registered.
+//	Returns: error or nil if the pipeline is valid.
+func (c *KafkaMessagePipeline) Validate() error {
+	for _, name := range c.names {
+		if _, ok := c.transformers[name]; !ok {
+			return cerr.NewConfigError("", "UNKNOWN_TRANSFORMER",
+				"Message transformer "+name+" is not registered").WithDetails("name", name)
+		}
+	}
+	return nil
+}
+
+//	Checks if the pipeline has no steps.
+//	Returns: true if the pipeline is empty and false otherwise.
+func (c *KafkaMessagePipeline) IsEmpty() bool {
+	return len(c.names) == 0
+}
+
+//	Encodes a message to be sent by all steps in the reverse order.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message	a message to be sent
+//	Returns: error or nil for success.
+func (c *KafkaMessagePipeline) Encode(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	for i := len(c.names) - 1; i >= 0; i-- {
+		err := c.transform(ctx, c.names[i], message, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//	Decodes a received message by all steps in the pipeline order.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message	a received message
+//	Returns: error or nil for success.
+func (c *KafkaMessagePipeline) Decode(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	for _, name := range c.names {
+		err := c.transform(ctx, name, message, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *KafkaMessagePipeline) transform(ctx context.Context, name string, message *cqueues.MessageEnvelope, encode bool) error {
+	transformer, ok := c.transformers[name]
+	if !ok {
+		return cerr.NewConfigError(message.CorrelationId, "UNKNOWN_TRANSFORMER",
+			"Message transformer "+name+" is not registered").WithDetails("name", name)
+	}
+
+	var err error
+	if encode {
+		err = transformer.Encode(ctx, message)
+	} else {
+		err = transformer.Decode(ctx, message)
+	}
+	if err != nil {
+		return cerr.NewBadRequestError(message.CorrelationId, "TRANSFORMATION_FAILED",
+			"Message transformation "+name+" failed").WithCause(err).WithDetails("name", name)
+	}
+	return nil
+}
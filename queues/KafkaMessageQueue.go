@@ -1,16 +1,25 @@
 package queues
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	kafka "github.com/Shopify/sarama"
 	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
 	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
 	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	cvalid "github.com/pip-services3-gox/pip-services3-commons-gox/validate"
 	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
 	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
 	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
@@ -38,19 +47,124 @@ import (
 //			- read_partitions:      	(optional) list of partition indexes to be read (default: all, set for example: "1;5;7")
 //			- write_partition:		(optional) list of partition indexes to be read (default: auto (-1))
 //			- autosubscribe:        	(optional) true to automatically subscribe on option (default: false)
+//			- autocreate:           	(optional) true to automatically create the topic on open, otherwise open fails when the topic does not exist (default: true)
+//			- reconcile:            	(optional) action on differences between an existing topic and its declared partitions, replication and config: "none", "warn", "fail" or "alter" (default: none)
+//			- num_partitions:       	(optional) number of partitions of the created topic, and the minimum number of partitions of an existing topic when autocreate is off (default: 1)
+//			- replication_factor:   	(optional) replication factor of the created topic (default: 1)
+//			- retention_ms:         	(optional) retention.ms of the created topic (default: broker default)
+//			- cleanup_policy:       	(optional) cleanup.policy of the created topic (default: broker default)
+//			- min_insync_replicas:  	(optional) min.insync.replicas of the created topic (default: broker default)
 //			- log_level:            	(optional) log level 0 - None, 1 - Error, 2 - Warn, 3 - Info, 4 - Debug (default: 1)
 //			- connect_timeout:      	(optional) number of milliseconds to connect to broker (default: 1000)
+//			- open_timeout:         	(optional) number of milliseconds to wait until brokers respond on open (default: 10000)
+//			- read_timeout:         	(optional) number of milliseconds to wait for a response from broker (default: 30000)
+//			- write_timeout:        	(optional) number of milliseconds to wait for a request to be sent to broker (default: 30000)
+//			- metadata_refresh_interval: (optional) number of milliseconds between background refreshes of cluster metadata and partition leaders, 0 to disable (default: 600000)
+//			- metadata_cache_ttl:   	(optional) number of milliseconds to cache queue names and partitions read from brokers, 0 to disable (default: 0)
+//			- keep_alive:           	(optional) number of milliseconds between TCP keep-alive probes, 0 for the system default, negative to disable (default: 0)
+//			- max_idle_time:        	(optional) number of milliseconds a producer may stay idle before it is reconnected on the next send, 0 to disable (default: 0)
 //			- max_retries:          	(optional) maximum retry attempts (default: 5)
+//			- retry_backoff:        	(optional) number of milliseconds between producer retries (default: 100)
+//			- delivery_timeout:     	(optional) number of milliseconds a send may keep retrying on transient errors like leader elections, 0 to disable (default: 0)
 //			- retry_timeout:        	(optional) number of milliseconds to wait on each reconnection attempt (default: 30000)
 //			- request_timeout:      	(optional) number of milliseconds to wait on flushing messages (default: 30000)
+//			- throttle_slowdown:    	(optional) true to delay sends while brokers throttle the producer for exceeding quotas (default: false)
+//			- required_features:    	(optional) list of broker features required on open, like "headers;zstd" (default: none)
+//			- topic_prefix:         	(optional) prefix added to the topic name, like "staging." (default: none)
+//			- topic_suffix:         	(optional) suffix added to the topic name (default: none)
+//			- hide_internal_topics: 	(optional) true to exclude internal topics, like __consumer_offsets or _schemas, from queue names (default: false)
+//			- write_internal_topics:	(optional) true to allow sending to internal topics and changing them (default: false)
+//			- drain_timeout:        	(optional) number of milliseconds to wait for in-flight messages on shutdown (default: 10000)
+//			- max_poll_interval:    	(optional) number of milliseconds a handler may process a message before the consumer is reported stuck (default: 300000)
+//			- pause_timeout:        	(optional) number of milliseconds to pause consumption when a receiver reports unavailable downstream (default: 30000)
+//			- metrics_interval:     	(optional) number of milliseconds between publishing of scaling metrics while subscribed, 0 to disable (default: 10000)
+//			- idle_timeout:         	(optional) number of milliseconds without received messages while the lag is non-zero before the consumer is reported idle, 0 to disable (default: 0)
+//			- commit_mode:          	(optional) "sync" to commit offsets within the consume loop or "async" to commit them in the background (default: sync)
+//			- commit_sync_interval: 	(optional) number of milliseconds between synchronous commits in the async commit mode, 0 to disable (default: 5000)
+//			- commit_metadata:      	(optional) metadata committed with offsets, like a processing host or an app version, returned by ExportOffsets (default: none)
+//			- commit_retries:       	(optional) maximum attempts to send again offset commits failed on rebalances or coordinator changes (default: 3)
+//			- commit_retry_backoff: 	(optional) number of milliseconds before the first commit retry, doubled on every attempt (default: 100)
+//			- max_poll_records:     	(optional) maximum number of records fetched ahead per partition and buffered for Receive, like Kafka max.poll.records, 0 for no limit (default: 0)
+//			- receive_mode:         	(optional) mode of passing messages to several receivers: "round_robin" or "broadcast" (default: round_robin)
+//			- filter_message_types: 	(optional) list of accepted message types (default: all, set for example: "type1;type2")
+//			- filter_headers:       	(optional) list of required header values (default: none, set for example: "key1=value1;key2=value2")
+//			- tenancy:              	(optional) tenant separation mode: "none", "topic" for <topic>.<tenant> topics checked or created on first sends, or "header" for a shared topic (default: none)
+//			- tenant_id:            	(optional) tenant id of consumed messages. Without it topic mode queues consume the base topic and header mode queues consume all tenants (default: none)
+//			- tenant_field:         	(optional) JSON path of the tenant id in sent payloads used when the context has no tenant, like "$.tenant_id"
+//			- pipeline:             	(optional) list of message transformation steps, see KafkaMessagePipeline (set for example: "gzip")
+//			- audit:                	(optional) list of audit sinks of sent messages: "log", "topic" or "counters", see KafkaAuditInterceptor (default: none, set for example: "log;counters")
+//			- audit_topic:          	(optional) topic of audit records required by the "topic" sink
+//			- tap:                  	(optional) list of directions of messages copied to the tap topic: "sent" and/or "received", see KafkaMessageTap (default: none)
+//			- tap_topic:            	(optional) debug topic of tapped messages required by options.tap
+//			- tap_rate:             	(optional) fraction of tapped messages from 0 to 1 (default: 1)
+//			- shadow_topic:         	(optional) topic that receives copies of sent messages marked with the shadow header, see IsShadowMessage (default: none)
+//			- canary_group:         	(optional) consumer group of canary listeners started with WithCanary (default: <group_id>.canary)
+//			- canary_fraction:      	(optional) fraction of partitions or messages handled by canary listeners from 0 to 1 (default: 0.1)
+//			- on_deserialize_error: 	(optional) handling of records that fail pipeline decoding or upcasting: "fail" to stop the partition, "skip" to commit and drop them (the records are lost and counted as malformed_messages), "dead_letter" or "raw" to receive original bytes, see IsRawMessage (default: fail)
+//			- dead_letter_topic:    	(optional) topic of records moved by MoveToDeadLetter or on deserialization errors (default: <topic>.dlq)
+//			- canary_mode:          	(optional) selection of canary messages: "partitions" for a fraction of partitions or "messages" for a random sample (default: partitions)
+//			- schema:               	(optional) JSON Schema document to validate payloads of sent messages, see KafkaJsonSchema
+//			- schema_ref:           	(optional) name of a validation schema resolved from references as *:schema:<name>:*:1.0
+//			- schema_version:       	(optional) schema version of sent messages without registered upcasters, see KafkaUpcasterRegistry (default: none)
+//		- dependencies:
+//			- offset_store:          	(optional) descriptor of IKafkaOffsetStore to keep consumed offsets outside of Kafka (default: none)
+//		- routes:                        (optional) content-based routes, see KafkaMessageRoute
+//			- <index>:
+//				- handler:               name of the route handler or "skip" to skip matched messages
+//				- when:                  route expression, like: header.tenant == "abc" && $.total != 0
 //
 //	References:
 //
 //		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
 //		- *:counters:*:*:1.0           (optional)  ICounters components to pass collected measurements
+//		- IKafkaMetrics                 (optional) Components to pass labeled measurements, like Prometheus adapters
 //		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
 //		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
 //		- *:connection:kafka:*:1.0      (optional) Shared connection to Kafka service
+//		- *:message-transformer:*:*:1.0 (optional) Message transformation steps of the pipeline
+//		- *:schema:<schema_ref>:*:1.0   (optional) Validation schema of sent messages set by options.schema_ref
+//		- IKafkaOffsetStore             (optional) External offset store set by dependencies.offset_store
+//
+//	Scaling metrics:
+//
+//	While the queue is subscribed it periodically publishes gauges via ICounters
+//	that autoscalers like KEDA or HPA external metrics adapters can consume:
+//
+//		- queue.<name>.consumer_lag:     total number of messages not yet committed by the consumer group
+//		- queue.<name>.processing_rate:  number of messages processed per second during the last interval
+//
+//	Latency metrics:
+//
+//	Received messages are timed from their record timestamps set by producers
+//	and reported as ICounters intervals in milliseconds by topics and message types:
+//
+//		- topic.<topic>.<message_type>.age:      time from producing till receiving of a message
+//		- topic.<topic>.<message_type>.latency:  time from producing till completed processing of a message
+//
+//	Message locks:
+//
+//	Kafka has no message locks, so RenewLock emulates them for handlers that process messages
+//	for a long time. The partition of a locked message is paused, so no more records are fetched
+//	from it, and the handler is not reported stuck while it renews the lock. When offsets are committed
+//	manually, completed messages received from the partition after the locked one are committed
+//	only when the locked message is completed, since Kafka commits all offsets before a committed one.
+//	Only one message of a partition can be locked at a time, locks of others fail with PARTITION_LOCKED.
+//	Locks are released by Complete, Abandon and MoveToDeadLetter, or when they expire without renewal.
+//	Expired locks resume the partition and drop deferred commits, so those messages come again
+//	after a restart or a rebalance if the locked message is not completed.
+//
+//	Labeled metrics:
+//
+//	The same measurements are passed to referenced IKafkaMetrics components under stable names
+//	with queue, topic, group and partition labels, so a Prometheus registry can expose them as is.
+//	Latencies additionally have the message_type label. See connect.KafkaMetricDescriptors:
+//
+//		- kafka_queue_sent_messages_total, kafka_queue_received_messages_total
+//		- kafka_queue_skipped_messages_total, kafka_queue_unhandled_messages_total
+//		- kafka_queue_rejected_messages_total, kafka_queue_upcast_messages_total
+//		- kafka_queue_errors_total, kafka_queue_stuck_consumers_total, kafka_queue_idle_consumers_total
+//		- kafka_queue_processing_rate, kafka_queue_consumer_lag
+//		- kafka_queue_message_age_milliseconds, kafka_queue_message_latency_milliseconds
 //
 //	See MessageQueue
 //	See MessagingCapabilities
@@ -87,24 +201,224 @@ type KafkaMessageQueue struct {
 	DependencyResolver *cref.DependencyResolver
 	// The logger.
 	Logger *clog.CompositeLogger
-	// The Kafka connection component.
-	Connection *connect.KafkaConnection
+	// The Kafka connection component. The field was *connect.KafkaConnection in 1.0.x,
+	// use GetKafkaConnection to get the concrete connection.
+	Connection connect.IKafkaConnection
+	// The labeled metrics passed to IKafkaMetrics components.
+	Metrics *connect.CompositeKafkaMetrics
+	// The external offset store. When set, offsets are saved there instead of Kafka.
+	OffsetStore IKafkaOffsetStore
 
 	topic         string
 	groupId       string
 	fromBeginning bool
 	autoCommit    bool
 	autoSubscribe bool
+	autoCreate    bool
+	reconcile     string
 	subscribed    bool
+	subscribeLock sync.Mutex
 	messages      []*cqueues.MessageEnvelope
 	receiver      cqueues.IMessageReceiver
+	receivers     []cqueues.IMessageReceiver
+	receiveMode   string
+	nextReceiver  int
+	listenStop    chan struct{}
+	messageSignal chan struct{}
+	drainTimeout  time.Duration
+	draining      bool
+	inFlight      int
+	// Signals that in-flight messages are finished while draining
+	drainSignal chan struct{}
+
+	// Signals that Receive took messages when the queue is limited by max_poll_records
+	spaceSignal    chan struct{}
+	maxPollRecords int
+
+	commitMode         string
+	commitSyncInterval time.Duration
+	commitCallback     func(ctx context.Context, offsets map[string]map[int32]int64, err error)
+	committer          *kafkaAsyncCommitter
+	commitMetadata     string
+	commitRetries      int
+	commitRetryBackoff time.Duration
+	// The last session that committed offsets and offsets requested to commit by topics and partitions
+	commitSession   kafka.ConsumerGroupSession
+	commitRequested map[string]map[int32]int64
+	commitRetrying  map[string]map[int32]bool
+
+	maxPollInterval time.Duration
+	handlingSince   map[int32]time.Time
+	stuck           bool
+	stuckCallback   func(ctx context.Context, stuckFor time.Duration)
+	idleTimeout     time.Duration
+	idleStop        chan struct{}
+	lastReceived    time.Time
+	idle            bool
+	idleCallback    func(ctx context.Context, idleFor time.Duration, lag int64)
+	errorCallback   func(ctx context.Context, correlationId string, err error)
+
+	pauseTimeout time.Duration
+	paused       bool
+	resumeSignal chan struct{}
+	pauseTimer   *time.Timer
+
+	// Partitions locked by messages with renewed locks
+	locks map[kafkaPartition]*kafkaMessageLock
+
+	filter          *KafkaMessageFilter
+	filterPredicate func(message *cqueues.MessageEnvelope) bool
+
+	handlers       map[string]cqueues.IMessageReceiver
+	defaultHandler cqueues.IMessageReceiver
+	routes         []*KafkaMessageRoute
+	routesErr      error
+	configErr      error
+	routeHandlers  map[string]cqueues.IMessageReceiver
+
+	sendInterceptors    []ISendInterceptor
+	receiveInterceptors []IReceiveInterceptor
+	auditInterceptor    *KafkaAuditInterceptor
+	tapInterceptor      *KafkaMessageTap
+	shadowTopic         string
+
+	onDeserializeError string
+	deadLetterTopic    string
+
+	canary           bool
+	canaryGroupId    string
+	canaryFraction   float64
+	canaryMode       string
+	canaryPartitions map[int32]bool
+	subscribedGroup  string
+
+	schemas   map[string]cvalid.ISchema
+	schemaRef string
+
+	// The message transformation pipeline.
+	Pipeline *KafkaMessagePipeline
+	// The upcasters of received messages by schema versions.
+	Upcasters *KafkaUpcasterRegistry
+
+	tenancy     string
+	tenantId    string
+	tenantField string
+	// Tenant topics that exist in Kafka
+	tenantTopics map[string]bool
+	watchdogStop chan struct{}
+	workers      sync.WaitGroup
+
+	metricsInterval time.Duration
+	metricsStop     chan struct{}
+	processed       int64
 
 	writePartition     int
 	readablePartitions []int32
+	numPartitions      int
 
 	ready chan bool
 }
 
+// Keys of envelope headers shared by all sent records, the producer doesn't modify them
+var (
+	correlationIdHeaderKey = []byte("correlation_id")
+	messageTypeHeaderKey   = []byte("message_type")
+)
+
+// Options supported by KafkaMessageQueue in addition to the connection options
+var queueOptions = []string{
+	"read_partitions", "write_partition", "autosubscribe", "autocreate", "reconcile", "drain_timeout",
+	"max_poll_interval", "max_poll_records", "commit_mode", "commit_sync_interval", "commit_metadata", "commit_retries",
+	"commit_retry_backoff", "pause_timeout", "metrics_interval", "idle_timeout", "receive_mode",
+	"filter_message_types", "filter_headers", "tenancy", "tenant_id", "tenant_field", "pipeline",
+	"audit", "audit_topic", "tap", "tap_topic", "tap_rate", "shadow_topic", "canary_group", "canary_fraction",
+	"canary_mode", "on_deserialize_error", "dead_letter_topic", "schema", "schema_ref", "schema_version",
+	// Accepted for compatibility with configurations of other language ports, has no effect
+	"listen_connection",
+}
+
+// Validates options of the queue and their combinations
+func validateQueueConfig(config *cconf.ConfigParams) error {
+	err := connect.ValidateKafkaOptions("", config, append(queueOptions, connect.KafkaConnectionOptions...)...)
+	if err != nil {
+		return err
+	}
+
+	for option, values := range map[string][]string{
+		"reconcile":            {ReconcileNone, ReconcileWarn, ReconcileFail, ReconcileAlter},
+		"tenancy":              {TenancyNone, TenancyTopic, TenancyHeader},
+		"receive_mode":         {ReceiveRoundRobin, ReceiveBroadcast},
+		"canary_mode":          {CanaryPartitions, CanaryMessages},
+		"on_deserialize_error": {DeserializeErrorFail, DeserializeErrorSkip, DeserializeErrorDeadLetter, DeserializeErrorRaw},
+		"commit_mode":          {CommitSync, CommitAsync},
+	} {
+		value, ok := config.GetAsNullableString("options." + option)
+		if !ok || value == "" {
+			continue
+		}
+		valid := false
+		for _, item := range values {
+			valid = valid || item == value
+		}
+		if !valid {
+			return cerr.NewConfigError("", "INVALID_OPTION",
+				"Option options."+option+" must be one of "+strings.Join(values, ", ")).WithDetails(option, value)
+		}
+	}
+
+	for _, option := range []string{"drain_timeout", "max_poll_interval", "max_poll_records", "commit_sync_interval",
+		"commit_retries", "commit_retry_backoff", "pause_timeout", "metrics_interval", "idle_timeout", "schema_version"} {
+		if value, ok := config.GetAsNullableInteger("options." + option); ok && value < 0 {
+			return cerr.NewConfigError("", "INVALID_OPTION",
+				"Option options."+option+" must not be negative").WithDetails(option, value)
+		}
+	}
+
+	if value, ok := config.GetAsNullableInteger("options.write_partition"); ok && value < -1 {
+		return cerr.NewConfigError("", "INVALID_OPTION",
+			"Option options.write_partition must be a partition index or -1").WithDetails("write_partition", value)
+	}
+
+	for _, sink := range splitOptionList(config.GetAsStringWithDefault("options.audit", "")) {
+		switch sink {
+		case AuditLog, AuditCounters:
+		case AuditTopic:
+			if config.GetAsStringWithDefault("options.audit_topic", "") == "" {
+				return cerr.NewConfigError("", "INVALID_OPTION",
+					"Option options.audit_topic is required by the topic audit sink")
+			}
+		default:
+			return cerr.NewConfigError("", "INVALID_OPTION",
+				"Option options.audit must be a list of log, topic or counters").WithDetails("audit", sink)
+		}
+	}
+
+	for _, direction := range splitOptionList(config.GetAsStringWithDefault("options.tap", "")) {
+		if direction != TapSent && direction != TapReceived {
+			return cerr.NewConfigError("", "INVALID_OPTION",
+				"Option options.tap must be a list of sent or received").WithDetails("tap", direction)
+		}
+		if config.GetAsStringWithDefault("options.tap_topic", "") == "" {
+			return cerr.NewConfigError("", "INVALID_OPTION",
+				"Option options.tap_topic is required by options.tap")
+		}
+	}
+	for _, option := range []string{"tap_rate", "canary_fraction"} {
+		if value, ok := config.GetAsNullableDouble("options." + option); ok && (value < 0 || value > 1) {
+			return cerr.NewConfigError("", "INVALID_OPTION",
+				"Option options."+option+" must be between 0 and 1").WithDetails(option, value)
+		}
+	}
+
+	tenancy := config.GetAsStringWithDefault("options.tenancy", TenancyNone)
+	if config.GetAsStringWithDefault("options.tenant_id", "") != "" && tenancy == TenancyNone {
+		return cerr.NewConfigError("", "CONTRADICTORY_OPTIONS",
+			"Option options.tenant_id requires options.tenancy to be topic or header")
+	}
+
+	return nil
+}
+
 //	Creates a new instance of the queue component.
 //	Parameters:
 //		- name    (optional) a queue name.
@@ -117,21 +431,64 @@ func NewKafkaMessageQueue(name string) *KafkaMessageQueue {
 			"read_partitions", 1,
 			"autocommit", true,
 			"options.autosubscribe", false,
+			"options.autocreate", true,
+			"options.reconcile", ReconcileNone,
 			"options.log_level", 1,
 			"options.connect_timeout", 1000,
+			"options.open_timeout", 10000,
+			"options.read_timeout", 30000,
+			"options.write_timeout", 30000,
+			"options.metadata_refresh_interval", 600000,
 			"options.retry_timeout", 30000,
 			"options.max_retries", 5,
 			"options.request_timeout", 30000,
+			"options.drain_timeout", 10000,
+			"options.max_poll_interval", 300000,
+			"options.pause_timeout", 30000,
+			"options.metrics_interval", 10000,
+			"options.idle_timeout", 0,
+			"options.receive_mode", ReceiveRoundRobin,
 		),
-		Logger: clog.NewCompositeLogger(),
+		Logger:    clog.NewCompositeLogger(),
+		Metrics:   connect.NewCompositeKafkaMetrics(),
+		Pipeline:  NewKafkaMessagePipeline(),
+		Upcasters: NewKafkaUpcasterRegistry(),
 
+		autoCreate:         true,
+		reconcile:          ReconcileNone,
 		writePartition:     -1,
+		numPartitions:      1,
+		tenancy:            TenancyNone,
+		tenantTopics:       make(map[string]bool),
+		receiveMode:        ReceiveRoundRobin,
+		canaryGroupId:      "default.canary",
+		canaryFraction:     0.1,
+		canaryMode:         CanaryPartitions,
+		onDeserializeError: DeserializeErrorFail,
+		commitMode:         CommitSync,
+		commitSyncInterval: 5000 * time.Millisecond,
+		commitRetries:      3,
+		commitRetryBackoff: 100 * time.Millisecond,
+		commitRequested:    make(map[string]map[int32]int64),
+		commitRetrying:     make(map[string]map[int32]bool),
 		readablePartitions: make([]int32, 0),
-
-		ready: make(chan bool),
+		drainTimeout:       10000 * time.Millisecond,
+		maxPollInterval:    300000 * time.Millisecond,
+		pauseTimeout:       30000 * time.Millisecond,
+		metricsInterval:    10000 * time.Millisecond,
+		handlingSince:      make(map[int32]time.Time),
+		locks:              make(map[kafkaPartition]*kafkaMessageLock),
+		filter:             NewKafkaMessageFilter(),
+		handlers:           make(map[string]cqueues.IMessageReceiver),
+		routeHandlers:      make(map[string]cqueues.IMessageReceiver),
+		schemas:            make(map[string]cvalid.ISchema),
+
+		ready:         make(chan bool),
+		messageSignal: make(chan struct{}, 1),
+		spaceSignal:   make(chan struct{}, 1),
 	}
 	c.MessageQueue = cqueues.InheritMessageQueue(&c, name,
-		cqueues.NewMessagingCapabilities(false, true, true, true, true, false, false, false, true))
+		cqueues.NewMessagingCapabilities(true, true, true, true, true, true, false, true, false))
 	c.DependencyResolver = cref.NewDependencyResolver()
 	c.DependencyResolver.Configure(context.Background(), c.defaultConfig)
 
@@ -155,8 +512,73 @@ func (c *KafkaMessageQueue) Configure(ctx context.Context, config *cconf.ConfigP
 	c.fromBeginning = config.GetAsBooleanWithDefault("from_beginning", c.fromBeginning)
 	c.autoCommit = config.GetAsBooleanWithDefault("autocommit", c.autoCommit)
 	c.autoSubscribe = config.GetAsBooleanWithDefault("options.autosubscribe", c.autoSubscribe)
+	c.autoCreate = config.GetAsBooleanWithDefault("options.autocreate", c.autoCreate)
+	c.reconcile = config.GetAsStringWithDefault("options.reconcile", c.reconcile)
 
 	c.writePartition = config.GetAsIntegerWithDefault("options.write_partition", c.writePartition)
+	c.numPartitions = config.GetAsIntegerWithDefault("options.num_partitions", c.numPartitions)
+	c.drainTimeout = time.Duration(config.GetAsIntegerWithDefault("options.drain_timeout",
+		int(c.drainTimeout.Milliseconds()))) * time.Millisecond
+	c.maxPollInterval = time.Duration(config.GetAsIntegerWithDefault("options.max_poll_interval",
+		int(c.maxPollInterval.Milliseconds()))) * time.Millisecond
+	c.pauseTimeout = time.Duration(config.GetAsIntegerWithDefault("options.pause_timeout",
+		int(c.pauseTimeout.Milliseconds()))) * time.Millisecond
+	c.metricsInterval = time.Duration(config.GetAsIntegerWithDefault("options.metrics_interval",
+		int(c.metricsInterval.Milliseconds()))) * time.Millisecond
+	c.idleTimeout = time.Duration(config.GetAsIntegerWithDefault("options.idle_timeout",
+		int(c.idleTimeout.Milliseconds()))) * time.Millisecond
+	c.maxPollRecords = config.GetAsIntegerWithDefault("options.max_poll_records", c.maxPollRecords)
+	c.commitMode = config.GetAsStringWithDefault("options.commit_mode", c.commitMode)
+	c.commitSyncInterval = time.Duration(config.GetAsIntegerWithDefault("options.commit_sync_interval",
+		int(c.commitSyncInterval.Milliseconds()))) * time.Millisecond
+	c.commitMetadata = config.GetAsStringWithDefault("options.commit_metadata", c.commitMetadata)
+	c.commitRetries = config.GetAsIntegerWithDefault("options.commit_retries", c.commitRetries)
+	c.commitRetryBackoff = time.Duration(config.GetAsIntegerWithDefault("options.commit_retry_backoff",
+		int(c.commitRetryBackoff.Milliseconds()))) * time.Millisecond
+
+	c.receiveMode = config.GetAsStringWithDefault("options.receive_mode", c.receiveMode)
+	c.tenancy = config.GetAsStringWithDefault("options.tenancy", c.tenancy)
+	c.tenantId = config.GetAsStringWithDefault("options.tenant_id", c.tenantId)
+	c.tenantField = config.GetAsStringWithDefault("options.tenant_field", c.tenantField)
+
+	if sinks := splitOptionList(config.GetAsStringWithDefault("options.audit", "")); len(sinks) > 0 {
+		c.auditInterceptor = NewKafkaAuditInterceptor(sinks...)
+		c.auditInterceptor.Topic = config.GetAsStringWithDefault("options.audit_topic", "")
+		c.auditInterceptor.Logger = c.Logger
+		c.auditInterceptor.Counters = c.Counters
+	}
+
+	if directions := splitOptionList(config.GetAsStringWithDefault("options.tap", "")); len(directions) > 0 {
+		c.tapInterceptor = NewKafkaMessageTap(directions...)
+		c.tapInterceptor.Topic = config.GetAsStringWithDefault("options.tap_topic", "")
+		c.tapInterceptor.Rate = config.GetAsDoubleWithDefault("options.tap_rate", c.tapInterceptor.Rate)
+		c.tapInterceptor.Logger = c.Logger
+	}
+
+	c.Pipeline.Configure(ctx, config)
+	c.filter = NewKafkaMessageFilterFromConfig(config)
+	c.routes, c.routesErr = NewKafkaMessageRoutesFromConfig(config)
+	c.configErr = validateQueueConfig(config)
+
+	c.schemaRef = config.GetAsStringWithDefault("options.schema_ref", c.schemaRef)
+	c.shadowTopic = config.GetAsStringWithDefault("options.shadow_topic", c.shadowTopic)
+	c.onDeserializeError = config.GetAsStringWithDefault("options.on_deserialize_error", c.onDeserializeError)
+	c.deadLetterTopic = config.GetAsStringWithDefault("options.dead_letter_topic", c.deadLetterTopic)
+	c.canaryGroupId = config.GetAsStringWithDefault("options.canary_group", c.groupId+".canary")
+	c.canaryFraction = config.GetAsDoubleWithDefault("options.canary_fraction", c.canaryFraction)
+	c.canaryMode = config.GetAsStringWithDefault("options.canary_mode", c.canaryMode)
+	if version, ok := config.GetAsNullableInteger("options.schema_version"); ok {
+		c.Upcasters.SetVersion("", version)
+	}
+	if document := config.GetAsStringWithDefault("options.schema", ""); document != "" {
+		schema, err := NewKafkaJsonSchema(document)
+		if err != nil && c.configErr == nil {
+			c.configErr = err
+		}
+		if err == nil {
+			c.SetSchema("", schema)
+		}
+	}
 
 	if partitions, ok := config.GetAsNullableString("options.read_partitions"); ok {
 		for _, strVal := range strings.Split(partitions, ";") {
@@ -169,6 +591,58 @@ func (c *KafkaMessageQueue) Configure(ctx context.Context, config *cconf.ConfigP
 	}
 }
 
+//	Applies hot reloadable options while the queue is opened:
+//	drain_timeout, max_poll_interval, pause_timeout, metrics_interval, idle_timeout,
+//	message filters and routes. Options of the shared connection are applied to the connection.
+//	Other options take effect only after the queue is reopened.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be applied.
+func (c *KafkaMessageQueue) Reconfigure(ctx context.Context, config *cconf.ConfigParams) {
+	c.Lock.Lock()
+	c.drainTimeout = time.Duration(config.GetAsIntegerWithDefault("options.drain_timeout",
+		int(c.drainTimeout.Milliseconds()))) * time.Millisecond
+	c.maxPollInterval = time.Duration(config.GetAsIntegerWithDefault("options.max_poll_interval",
+		int(c.maxPollInterval.Milliseconds()))) * time.Millisecond
+	c.pauseTimeout = time.Duration(config.GetAsIntegerWithDefault("options.pause_timeout",
+		int(c.pauseTimeout.Milliseconds()))) * time.Millisecond
+	c.metricsInterval = time.Duration(config.GetAsIntegerWithDefault("options.metrics_interval",
+		int(c.metricsInterval.Milliseconds()))) * time.Millisecond
+	c.idleTimeout = time.Duration(config.GetAsIntegerWithDefault("options.idle_timeout",
+		int(c.idleTimeout.Milliseconds()))) * time.Millisecond
+
+	_, hasTypes := config.GetAsNullableString("options.filter_message_types")
+	_, hasHeaders := config.GetAsNullableString("options.filter_headers")
+	if hasTypes || hasHeaders {
+		c.filter = NewKafkaMessageFilterFromConfig(config)
+	}
+	if len(config.GetSection("routes").Keys()) > 0 {
+		// Invalid routes keep the previous ones
+		routes, err := NewKafkaMessageRoutesFromConfig(config)
+		if err != nil {
+			c.Logger.Error(ctx, "", err, "Failed to reload routes of %s", c.Name())
+		} else {
+			c.routes = routes
+		}
+	}
+
+	// Restart background checks with the new intervals
+	if c.subscribed {
+		c.stopChecks()
+		c.startWatchdog()
+		c.startMetrics()
+		c.startIdleCheck()
+	}
+	c.Lock.Unlock()
+
+	// A shared connection is reconfigured by its owner
+	if reconfigurable, ok := c.Connection.(connect.IKafkaReconfigurable); ok && c.localConnection {
+		reconfigurable.Reconfigure(ctx, config)
+	}
+
+	c.Logger.Debug(ctx, "", "Reconfigured %s", c.Name())
+}
+
 //	Sets references to dependent components.
 //	Parameters:
 //		- ctx context.Context
@@ -176,11 +650,22 @@ func (c *KafkaMessageQueue) Configure(ctx context.Context, config *cconf.ConfigP
 func (c *KafkaMessageQueue) SetReferences(ctx context.Context, references cref.IReferences) {
 	c.references = references
 	c.Logger.SetReferences(ctx, references)
+	c.Counters.SetReferences(ctx, references)
+	c.Metrics.SetReferences(ctx, references)
+	c.Pipeline.SetReferences(ctx, references)
+
+	// Get the registered schema of sent messages
+	if c.schemaRef != "" {
+		descriptor := cref.NewDescriptor("*", "schema", c.schemaRef, "*", "1.0")
+		if schema, ok := references.GetOneOptional(descriptor).(cvalid.ISchema); ok {
+			c.SetSchema("", schema)
+		}
+	}
 
 	// Get connection
 	c.DependencyResolver.SetReferences(ctx, references)
 	result := c.DependencyResolver.GetOneOptional("connection")
-	if dep, ok := result.(*connect.KafkaConnection); ok {
+	if dep, ok := result.(connect.IKafkaConnection); ok {
 		c.Connection = dep
 	}
 	// Or create a local one
@@ -190,6 +675,11 @@ func (c *KafkaMessageQueue) SetReferences(ctx context.Context, references cref.I
 	} else {
 		c.localConnection = false
 	}
+
+	// Get external offset store
+	if store, ok := c.DependencyResolver.GetOneOptional("offset_store").(IKafkaOffsetStore); ok {
+		c.OffsetStore = store
+	}
 }
 
 //	Unsets (clears) previously set references to dependent components.
@@ -201,6 +691,7 @@ func (c *KafkaMessageQueue) UnsetReferences(ctx context.Context) {
 
 func (c *KafkaMessageQueue) createConnection() *connect.KafkaConnection {
 	connection := connect.NewKafkaConnection()
+	connection.AllowOptions(queueOptions...)
 
 	if c.config != nil {
 		connection.Configure(context.Background(), c.config)
@@ -245,29 +736,26 @@ func (c *KafkaMessageQueue) Open(ctx context.Context, correlationId string) (err
 		err = cerr.NewConnectionError(correlationId, "CONNECT_FAILED", "Kafka connection is not opened")
 	}
 
-	if err != nil {
-		return err
+	if err == nil && c.configErr != nil {
+		err = c.configErr
 	}
 
-	// Create topic if it does not exist
-	topics, err := c.Connection.ReadQueueNames()
-	if err != nil {
-		return err
+	if err == nil && c.routesErr != nil {
+		err = c.routesErr
 	}
 
-	found := false
-	for _, v := range topics {
-		if v == c.getTopic() {
-			found = true
-			break
-		}
+	if err == nil {
+		err = c.Pipeline.Validate()
 	}
 
-	if !found {
-		err := c.Connection.CreateQueue(c.getTopic())
-		if err != nil {
-			return err
-		}
+	if err != nil {
+		return err
+	}
+
+	// Create topic if it does not exist or validate it
+	err = c.checkTopic(ctx, correlationId)
+	if err != nil {
+		return err
 	}
 
 	// Automatically subscribe if needed
@@ -295,6 +783,12 @@ func (c *KafkaMessageQueue) Close(ctx context.Context, correlationId string) (er
 		return cerr.NewInvalidStateError(correlationId, "NO_CONNECTION", "Kafka connection is missing")
 	}
 
+	// Finish in-flight messages and unsubscribe from topic
+	err = c.drain(ctx, correlationId)
+	if err != nil {
+		return err
+	}
+
 	if c.localConnection {
 		err = c.Connection.Close(ctx, correlationId)
 	}
@@ -302,201 +796,1621 @@ func (c *KafkaMessageQueue) Close(ctx context.Context, correlationId string) (er
 		return err
 	}
 
-	// Unsubscribe from topic
-	if c.subscribed {
-		topic := c.getTopic()
-		c.Connection.Unsubscribe(ctx, topic, c.groupId, c)
-		c.subscribed = false
-	}
-
 	c.Lock.Lock()
 	defer c.Lock.Unlock()
 	c.opened = false
-	c.receiver = nil
+	c.stopListening()
+	c.clearPause()
 	c.messages = make([]*cqueues.MessageEnvelope, 0)
+	c.signalSpace()
 
 	return nil
 }
 
-func (c *KafkaMessageQueue) getTopic() string {
-	if c.topic != "" {
-		return c.topic
+//	Switches the queue to another topic without recreating the component.
+//	The current subscription is drained and the queue subscribes to the new topic
+//	with the same consumer group. Messages that were fetched but not yet received
+//	stay in the queue.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- topic string	a new topic name
+//	Returns: error or nil no errors occured.
+func (c *KafkaMessageQueue) SetTopic(ctx context.Context, correlationId string, topic string) error {
+	err := c.CheckOpen(correlationId)
+	if err != nil {
+		return err
 	}
-	return c.Name()
-}
 
-func (c *KafkaMessageQueue) subscribe(ctx context.Context, correlationId string) error {
 	c.Lock.Lock()
-	defer c.Lock.Unlock()
+	oldTopic := c.topic
+	subscribed := c.subscribed
+	c.Lock.Unlock()
 
-	// Check if already were subscribed
-	if c.subscribed {
+	if topic == oldTopic {
 		return nil
 	}
 
-	// Subscribe to the topic
-	topic := c.getTopic()
-	config := kafka.NewConfig()
-	config.Consumer.Offsets.AutoCommit.Enable = c.autoCommit
-	// config.Consumer.Offsets.Initial = kafka.OffsetOldest
+	// Finish in-flight messages and leave the old topic
+	err = c.drain(ctx, correlationId)
+	if err != nil {
+		return err
+	}
+
+	c.Lock.Lock()
+	c.topic = topic
+	c.ready = make(chan bool)
+	c.Lock.Unlock()
 
-	err := c.Connection.Subscribe(ctx, topic, c.groupId, config, c)
+	err = c.checkTopic(ctx, correlationId)
+	if err == nil && subscribed {
+		err = c.subscribe(ctx, correlationId)
+	}
 	if err != nil {
-		c.Logger.Error(ctx, correlationId, err, "Failed to subscribe to topic "+topic)
+		c.Logger.Error(ctx, correlationId, err, "Failed to switch %s to topic %s", c.Name(), topic)
+
+		// Return to the old topic
+		c.Lock.Lock()
+		c.topic = oldTopic
+		c.Lock.Unlock()
+		if subscribed {
+			_ = c.subscribe(ctx, correlationId)
+		}
 		return err
 	}
 
-	c.subscribed = true
+	c.Logger.Info(ctx, correlationId, "Switched %s from topic %s to %s", c.Name(), oldTopic, topic)
 	return nil
 }
 
-// Set bool channel with ready flag for consumer
-//	Parameters:
-//		- chFlag	bool channel
-func (c *KafkaMessageQueue) SetReady(chFlag chan bool) {
-	c.Lock.Lock()
-	defer c.Lock.Unlock()
-	c.ready = chFlag
+// Gets the consumed topic, which is the tenant topic in the topic tenancy mode
+func (c *KafkaMessageQueue) getTopic() string {
+	return c.getTenantTopic(c.tenantId)
 }
 
-// Returns: channel with bool flag ready
-func (c *KafkaMessageQueue) Ready() chan bool {
-	return c.ready
-}
+// Creates the topic when autocreate is on, otherwise checks that the topic exists
+// with the declared number of partitions, reconciles its configuration
+// and checks the configured read and write partitions.
+func (c *KafkaMessageQueue) checkTopic(ctx context.Context, correlationId string) error {
+	topic := c.getTopic()
 
-func (c *KafkaMessageQueue) fromMessage(message *cqueues.MessageEnvelope) (*kafka.ProducerMessage, error) {
-	if message == nil {
-		return nil, nil
+	found, err := c.findTopic(topic)
+	if err != nil {
+		return err
 	}
 
-	headers := []kafka.RecordHeader{
-		{
-			Key:   []byte("correlation_id"),
-			Value: []byte(message.CorrelationId),
-		},
-		{
-			Key:   []byte("message_type"),
-			Value: []byte(message.MessageType),
-		},
+	if !found && c.autoCreate {
+		return c.Connection.CreateQueue(topic)
 	}
 
-	msg := &kafka.ProducerMessage{}
-	msg.Topic = c.getTopic()
-	msg.Key = kafka.StringEncoder(message.MessageId)
-	msg.Value = kafka.ByteEncoder(message.Message)
-	msg.Headers = headers
-
-	msg.Timestamp = time.Now()
-
-	return msg, nil
-}
+	if !found {
+		return cerr.NewConfigError(correlationId, "TOPIC_NOT_FOUND",
+			"Kafka topic "+topic+" does not exist and autocreate is disabled").
+			WithDetails("topic", topic)
+	}
 
-func (c *KafkaMessageQueue) toMessage(msg *connect.KafkaMessage) (*cqueues.MessageEnvelope, error) {
-	messageType := c.getHeaderByKey(msg.Message.Headers, "message_type")
-	correlationId := c.getHeaderByKey(msg.Message.Headers, "correlation_id")
+	err = c.reconcileTopic(ctx, correlationId, topic)
+	if err != nil {
+		return err
+	}
 
-	message := cqueues.NewMessageEnvelope(correlationId, messageType, nil)
-	message.MessageId = string(msg.Message.Key)
-	message.SentTime = msg.Message.Timestamp
-	message.Message = msg.Message.Value
-	message.SetReference(msg)
+	partitions, err := c.Connection.ReadPartitions(topic)
+	if err != nil {
+		return err
+	}
 
-	return message, nil
-}
+	// Topics that are not created by the queue must have the declared number of partitions
+	if !c.autoCreate && len(partitions) < c.numPartitions {
+		return cerr.NewConfigError(correlationId, "NOT_ENOUGH_PARTITIONS",
+			fmt.Sprintf("Kafka topic %s has %d partitions, but %d are expected", topic, len(partitions), c.numPartitions)).
+			WithDetails("topic", topic).WithDetails("partitions", len(partitions)).
+			WithDetails("num_partitions", c.numPartitions)
+	}
 
-func (c *KafkaMessageQueue) getHeaderByKey(headers []*kafka.RecordHeader, key string) string {
-	for _, header := range headers {
-		if key == string(header.Key) {
-			return string(header.Value)
+	expected := append([]int32{}, c.readablePartitions...)
+	if c.writePartition != -1 {
+		expected = append(expected, int32(c.writePartition))
+	}
+	for _, partition := range expected {
+		exists := false
+		for _, p := range partitions {
+			if p == partition {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			return cerr.NewConfigError(correlationId, "PARTITION_NOT_FOUND",
+				fmt.Sprintf("Kafka topic %s has no partition %d", topic, partition)).
+				WithDetails("topic", topic).WithDetails("partition", partition)
 		}
 	}
-	return ""
-}
-
-//	Setup is run at the beginning of a new session, before ConsumeClaim
-//	Send ready flag into channel
-//	Returns: error
-func (c *KafkaMessageQueue) Setup(kafka.ConsumerGroupSession) error {
-	// Mark the consumer as ready
-	c.ready <- true
-	close(c.ready)
-	return nil
-}
 
-// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
-func (c *KafkaMessageQueue) Cleanup(kafka.ConsumerGroupSession) error {
 	return nil
 }
 
-// ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages().
-func (c *KafkaMessageQueue) ConsumeClaim(session kafka.ConsumerGroupSession, claim kafka.ConsumerGroupClaim) error {
-	// NOTE:
-	// Do not move the code below to a goroutine.
-	// The `ConsumeClaim` itself is called within a goroutine, see:
-	// https://github.com/Shopify/sarama/blob/master/consumer_group.go#L27-L29
-
-	for {
-		// if len(c.readablePartitions) == 0 || slices.Contains(c.readablePartitions, claim.Partition()) {
-		select {
-		case msg := <-claim.Messages():
-			if msg != nil {
-				message := &connect.KafkaMessage{
-					Message: msg,
-					Session: session,
-				}
-
-				c.OnMessage(session.Context(), message)
+// Checks if a topic exists in Kafka
+func (c *KafkaMessageQueue) findTopic(topic string) (bool, error) {
+	topics, err := c.Connection.ReadQueueNames()
+	if err != nil {
+		return false, err
+	}
 
-				if c.autoCommit {
-					session.MarkMessage(msg, "")
-					session.Commit()
-				}
-			}
-		// Should return when `session.Context()` is done.
-		// If not, will raise `ErrRebalanceInProgress` or `read tcp <ip>:<port>: i/o timeout` when kafka rebalance. see:
-		// https://github.com/Shopify/sarama/issues/1192
-		case <-session.Context().Done():
-			return nil
+	for _, v := range topics {
+		if v == topic {
+			return true, nil
 		}
-		// }
 	}
+	return false, nil
 }
 
-//	Callback for processing messages from kafka
-//	Parameters:
-//		- ctx context.Context	operation context
-//		- msg *connect.KafkaMessage	consumer message
-func (c *KafkaMessageQueue) OnMessage(ctx context.Context, msg *connect.KafkaMessage) {
-	// // Skip if it came from a wrong topic
-	// expectedTopic := c.getTopic()
-	// if !strings.Contains(expectedTopic, "*") && expectedTopic != msg.Topic {
-	// 	return
-	// }
-
-	// Deserialize message
-	message, err := c.toMessage(msg)
+// Creates a tenant topic on the first send when autocreate is on, otherwise checks that it exists.
+// Tenants are not known on open, so their topics are checked only once they are used.
+func (c *KafkaMessageQueue) checkTenantTopic(correlationId string, topic string) error {
+	c.Lock.Lock()
+	checked := c.tenantTopics[topic]
+	c.Lock.Unlock()
+	if checked {
+		return nil
+	}
 
-	if message == nil || err != nil {
-		c.Logger.Error(ctx, "", err, "Failed to read received message")
-		return
+	found, err := c.findTopic(topic)
+	if err != nil {
+		return err
 	}
 
-	c.Counters.IncrementOne(ctx, "queue."+c.Name()+".received_messages")
-	c.Logger.Debug(ctx, message.CorrelationId, "Received message %s via %s", message, c.Name())
+	if !found && !c.autoCreate {
+		return cerr.NewConfigError(correlationId, "TOPIC_NOT_FOUND",
+			"Kafka topic "+topic+" does not exist and autocreate is disabled").
+			WithDetails("topic", topic)
+	}
+	if !found {
+		err = c.Connection.CreateQueue(topic)
+		if err != nil {
+			return err
+		}
+	}
 
-	// Send message to receiver if its set or put it into the queue
 	c.Lock.Lock()
-	if c.receiver != nil {
-		receiver := c.receiver
-		c.Lock.Unlock()
-		c.sendMessageToReceiver(ctx, receiver, message)
-	} else {
-		c.messages = append(c.messages, message)
-		c.Lock.Unlock()
-	}
+	c.tenantTopics[topic] = true
+	c.Lock.Unlock()
+	return nil
 }
 
-//	Clear method are clears component state.
+// Compares the live topic with its declared configuration according to the reconcile mode
+func (c *KafkaMessageQueue) reconcileTopic(ctx context.Context, correlationId string, topic string) error {
+	if c.reconcile == ReconcileNone {
+		return nil
+	}
+
+	drift, err := c.Connection.ReadQueueDrift(topic)
+	if err != nil || len(drift) == 0 {
+		return err
+	}
+
+	switch c.reconcile {
+	case ReconcileFail:
+		return cerr.NewConfigError(correlationId, "TOPIC_CONFIG_DRIFT",
+			"Kafka topic "+topic+" doesn't match its declared configuration: "+strings.Join(drift, "; ")).
+			WithDetails("topic", topic).WithDetails("drift", drift)
+	case ReconcileAlter:
+		c.Logger.Info(ctx, correlationId, "Altering Kafka topic %s to match its declared configuration: %s",
+			topic, strings.Join(drift, "; "))
+		return c.Connection.AlignQueue(topic)
+	default:
+		c.Logger.Warn(ctx, correlationId, "Kafka topic %s doesn't match its declared configuration: %s",
+			topic, strings.Join(drift, "; "))
+		return nil
+	}
+}
+
+func (c *KafkaMessageQueue) getBaseTopic() string {
+	if c.topic != "" {
+		return c.topic
+	}
+	return c.Name()
+}
+
+func (c *KafkaMessageQueue) getTenantTopic(tenantId string) string {
+	if c.tenancy == TenancyTopic && tenantId != "" {
+		return c.getBaseTopic() + "." + tenantId
+	}
+	return c.getBaseTopic()
+}
+
+// Gets the tenant of a sent message from the context or the payload
+func (c *KafkaMessageQueue) getSentTenant(ctx context.Context, message *cqueues.MessageEnvelope) string {
+	tenantId := GetTenant(ctx)
+	if tenantId == "" && c.tenantField != "" {
+		tenantId = getPayloadValue(message, c.tenantField)
+	}
+	return tenantId
+}
+
+func (c *KafkaMessageQueue) subscribe(ctx context.Context, correlationId string) error {
+	// Subscriptions are serialized by their own lock, since the queue lock
+	// is released while the connection waits for the consumer to start
+	c.subscribeLock.Lock()
+	defer c.subscribeLock.Unlock()
+
+	c.Lock.Lock()
+	// Check if already were subscribed
+	if c.subscribed {
+		c.Lock.Unlock()
+		return nil
+	}
+
+	// Subscribe to the topic
+	topic := c.getTopic()
+	config := kafka.NewConfig()
+	config.Consumer.Offsets.AutoCommit.Enable = c.autoCommit
+	// config.Consumer.Offsets.Initial = kafka.OffsetOldest
+	if c.maxPollRecords > 0 {
+		config.ChannelBufferSize = c.maxPollRecords
+	}
+
+	// Canaries join their own group and never commit, so the main group is not affected
+	groupId := c.groupId
+	if c.canary {
+		groupId = c.canaryGroupId
+		config.Consumer.Offsets.AutoCommit.Enable = false
+		err := c.selectCanaryPartitions(topic)
+		if err != nil {
+			c.Lock.Unlock()
+			return err
+		}
+	}
+	// Channels of previous subscriptions were closed by their sessions
+	c.ready = make(chan bool)
+	c.Lock.Unlock()
+
+	// The consumer calls Setup and SetReady of the queue before Subscribe returns,
+	// so the queue lock is not held while waiting
+	err := c.Connection.Subscribe(ctx, topic, groupId, config, c)
+	if err != nil {
+		c.Logger.Error(ctx, correlationId, err, "Failed to subscribe to topic "+topic)
+		return err
+	}
+
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	c.subscribed = true
+	c.subscribedGroup = groupId
+	c.startWatchdog()
+	c.startMetrics()
+	c.startIdleCheck()
+	return nil
+}
+
+// Selects partitions consumed by a canary. Must be called under the lock.
+func (c *KafkaMessageQueue) selectCanaryPartitions(topic string) error {
+	c.canaryPartitions = map[int32]bool{}
+	if c.canaryMode != CanaryPartitions {
+		return nil
+	}
+
+	partitions, err := c.Connection.ReadPartitions(topic)
+	if err != nil {
+		return err
+	}
+
+	// Take the fraction of partitions, but at least one
+	count := int(math.Ceil(c.canaryFraction * float64(len(partitions))))
+	if count < 1 && c.canaryFraction > 0 {
+		count = 1
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	for i := 0; i < count && i < len(partitions); i++ {
+		c.canaryPartitions[partitions[i]] = true
+	}
+	return nil
+}
+
+// Checks if the queue is subscribed as a canary
+func (c *KafkaMessageQueue) isCanary() bool {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.canary
+}
+
+// Checks if a canary handles a message from the partition
+func (c *KafkaMessageQueue) matchCanary(partition int32) bool {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	if c.canaryMode == CanaryMessages {
+		return c.canaryFraction > 0 && rand.Float64() < c.canaryFraction
+	}
+	return c.canaryPartitions[partition]
+}
+
+func (c *KafkaMessageQueue) unsubscribe(ctx context.Context, correlationId string) error {
+	c.subscribeLock.Lock()
+	defer c.subscribeLock.Unlock()
+
+	c.Lock.Lock()
+	// Check if already were unsubscribed
+	if !c.subscribed {
+		c.Lock.Unlock()
+		return nil
+	}
+	c.subscribed = false
+	c.stopChecks()
+	c.idle = false
+	groupId := c.subscribedGroup
+	c.canary = false
+	c.Lock.Unlock()
+	c.workers.Wait()
+
+	// Unsubscribe from the topic and leave the consumer group.
+	// The lock is released since closing the consumer waits for running handlers.
+	topic := c.getTopic()
+	err := c.Connection.Unsubscribe(ctx, topic, groupId, c)
+	if err != nil {
+		c.Logger.Error(ctx, correlationId, err, "Failed to unsubscribe from topic "+topic)
+		return err
+	}
+
+	return nil
+}
+
+// Stops fetching new messages, waits up to the drain timeout for in-flight handlers
+// to finish and commit their offsets and then leaves the consumer group.
+func (c *KafkaMessageQueue) drain(ctx context.Context, correlationId string) error {
+	c.Lock.Lock()
+	if !c.subscribed {
+		c.Lock.Unlock()
+		return nil
+	}
+	c.draining = true
+	var done chan struct{}
+	if c.inFlight > 0 {
+		done = make(chan struct{})
+		c.drainSignal = done
+	}
+	c.Lock.Unlock()
+
+	if done != nil {
+		timer := time.NewTimer(c.drainTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-done:
+		case <-timer.C:
+			c.Logger.Warn(ctx, correlationId, "Timed out waiting for in-flight messages at %s", c.Name())
+		}
+	}
+
+	err := c.unsubscribe(ctx, correlationId)
+
+	c.Lock.Lock()
+	c.draining = false
+	c.drainSignal = nil
+	c.Lock.Unlock()
+
+	return err
+}
+
+// Registers an in-flight message unless the queue is draining
+func (c *KafkaMessageQueue) beginHandling(partition int32) bool {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	if c.draining {
+		return false
+	}
+	c.inFlight++
+	c.handlingSince[partition] = time.Now()
+	return true
+}
+
+// Unregisters a processed in-flight message
+func (c *KafkaMessageQueue) endHandling(partition int32) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	delete(c.handlingSince, partition)
+	c.inFlight--
+	if c.inFlight == 0 && c.drainSignal != nil {
+		close(c.drainSignal)
+		c.drainSignal = nil
+	}
+}
+
+//	Checks if the consumer is stuck in a message handler longer than the max poll interval.
+//	Returns: true if the consumer is stuck and false otherwise.
+func (c *KafkaMessageQueue) IsStuck() bool {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.stuck
+}
+
+//	Sets a callback that is called when the consumer gets stuck in a message handler
+//	longer than the max poll interval.
+//	Parameters:
+//		- callback	a function that receives the time the consumer is stuck for
+func (c *KafkaMessageQueue) SetStuckCallback(callback func(ctx context.Context, stuckFor time.Duration)) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.stuckCallback = callback
+}
+
+//	Checks if the consumer has received no messages longer than the idle timeout while the lag is non-zero.
+//	Returns: true if the consumer is idle and false otherwise.
+func (c *KafkaMessageQueue) IsIdle() bool {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.idle
+}
+
+//	Sets a callback that is called when the consumer receives no messages
+//	longer than the idle timeout while the lag is non-zero.
+//	Parameters:
+//		- callback	a function that receives the time the consumer is idle for and the current lag
+func (c *KafkaMessageQueue) SetIdleCallback(callback func(ctx context.Context, idleFor time.Duration, lag int64)) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.idleCallback = callback
+}
+
+//	Sets a predicate that selects received messages.
+//	Messages rejected by the predicate or by the configured filter are skipped
+//	and committed without invoking the receiver.
+//	Parameters:
+//		- predicate	a function that returns true for messages to be received, or nil to accept all
+func (c *KafkaMessageQueue) SetFilter(predicate func(message *cqueues.MessageEnvelope) bool) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.filterPredicate = predicate
+}
+
+func (c *KafkaMessageQueue) matchFilter(message *cqueues.MessageEnvelope) bool {
+	c.Lock.Lock()
+	filter := c.filter
+	predicate := c.filterPredicate
+	c.Lock.Unlock()
+
+	if !filter.IsEmpty() && !filter.Match(message) {
+		return false
+	}
+	return predicate == nil || predicate(message)
+}
+
+//	Sets a callback that is called on asynchronous failures inside the listen loop,
+//	such as deserialization, processing, commit or rebalance errors.
+//	Parameters:
+//		- callback	a function that receives the failure
+func (c *KafkaMessageQueue) SetErrorCallback(callback func(ctx context.Context, correlationId string, err error)) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.errorCallback = callback
+}
+
+//	Sets a callback that is called when offsets of consumed messages are committed.
+//	In the async commit mode offsets of several messages are committed and reported together.
+//	Commits that failed on rebalances or coordinator changes are sent again up to commit_retries times.
+//	Commits that failed after all retries are reported with COMMIT_FAILED errors,
+//	counted as queue.<name>.commit_failures and passed to the error callback,
+//	so the following reprocessing of messages is not a surprise.
+//	Parameters:
+//		- callback	a function that receives committed offsets by topics and partitions
+//			and an error when the commit failed
+func (c *KafkaMessageQueue) SetCommitCallback(callback func(ctx context.Context, offsets map[string]map[int32]int64, err error)) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.commitCallback = callback
+}
+
+//	Sets metadata committed with offsets of consumed messages, like a processing host,
+//	an app version or a checkpoint id. The metadata is returned by ExportOffsets,
+//	so audits can find who committed offsets and when.
+//	Parameters:
+//		- metadata string	the offset metadata
+func (c *KafkaMessageQueue) SetCommitMetadata(metadata string) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.commitMetadata = metadata
+}
+
+//	Gets the Kafka connection when the queue uses the KafkaConnection implementation.
+//	Returns: the connection or nil when another IKafkaConnection is used.
+func (c *KafkaMessageQueue) GetKafkaConnection() *connect.KafkaConnection {
+	connection, _ := c.Connection.(*connect.KafkaConnection)
+	return connection
+}
+
+//	Gets metadata committed with offsets of consumed messages.
+//	Returns: the offset metadata set by options.commit_metadata or SetCommitMetadata.
+func (c *KafkaMessageQueue) GetCommitMetadata() string {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.commitMetadata
+}
+
+//	Callback for asynchronous consumer errors
+//	Parameters:
+//		- err error	consumer error
+func (c *KafkaMessageQueue) OnError(err error) {
+	c.reportError(context.Background(), "", err)
+
+	var consumerErr *kafka.ConsumerError
+	if errors.As(err, &consumerErr) && isRetriableCommitError(consumerErr.Err) {
+		c.retryCommit(consumerErr.Topic, consumerErr.Partition, consumerErr.Err)
+	}
+}
+
+func (c *KafkaMessageQueue) reportError(ctx context.Context, correlationId string, err error) {
+	c.Counters.IncrementOne(ctx, "queue."+c.Name()+".errors")
+	c.Metrics.Increment(connect.MetricQueueErrors, c.metricLabels(nil), 1)
+
+	c.Lock.Lock()
+	callback := c.errorCallback
+	c.Lock.Unlock()
+
+	if callback != nil && err != nil {
+		callback(ctx, correlationId, err)
+	}
+}
+
+// Stops background checks of the subscription. Must be called under the lock.
+func (c *KafkaMessageQueue) stopChecks() {
+	if c.watchdogStop != nil {
+		close(c.watchdogStop)
+		c.watchdogStop = nil
+	}
+	if c.metricsStop != nil {
+		close(c.metricsStop)
+		c.metricsStop = nil
+	}
+	if c.idleStop != nil {
+		close(c.idleStop)
+		c.idleStop = nil
+	}
+}
+
+// Starts the watchdog that detects stuck handlers. Must be called under the lock.
+func (c *KafkaMessageQueue) startWatchdog() {
+	if c.maxPollInterval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	c.watchdogStop = stop
+
+	c.workers.Add(1)
+	go func() {
+		defer c.workers.Done()
+
+		ticker := time.NewTicker(c.maxPollInterval / 4)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				c.checkStuck()
+			}
+		}
+	}()
+}
+
+func (c *KafkaMessageQueue) checkStuck() {
+	ctx := context.Background()
+
+	c.Lock.Lock()
+	stuckFor := time.Duration(0)
+	for _, since := range c.handlingSince {
+		if elapsed := time.Since(since); elapsed > stuckFor {
+			stuckFor = elapsed
+		}
+	}
+	wasStuck := c.stuck
+	c.stuck = stuckFor > c.maxPollInterval
+	stuck := c.stuck
+	callback := c.stuckCallback
+	c.Lock.Unlock()
+
+	if stuck && !wasStuck {
+		c.Counters.IncrementOne(ctx, "queue."+c.Name()+".stuck_consumers")
+		c.Metrics.Increment(connect.MetricQueueStuckConsumers, c.metricLabels(nil), 1)
+		c.Logger.Error(ctx, "", nil, "Consumer at %s is stuck in a message handler for %s", c.Name(), stuckFor)
+		if callback != nil {
+			callback(ctx, stuckFor)
+		}
+	} else if !stuck && wasStuck {
+		c.Logger.Info(ctx, "", "Consumer at %s recovered from a stuck message handler", c.Name())
+	}
+}
+
+func (c *KafkaMessageQueue) startIdleCheck() {
+	if c.idleTimeout <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	c.idleStop = stop
+	c.lastReceived = time.Now()
+
+	c.workers.Add(1)
+	go func() {
+		defer c.workers.Done()
+
+		ticker := time.NewTicker(c.idleTimeout / 4)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				c.checkIdle()
+			}
+		}
+	}()
+}
+
+// Reports consumers that receive no messages while there are messages to receive
+func (c *KafkaMessageQueue) checkIdle() {
+	ctx := context.Background()
+
+	c.Lock.Lock()
+	idleFor := time.Since(c.lastReceived)
+	wasIdle := c.idle
+	callback := c.idleCallback
+	c.Lock.Unlock()
+
+	lag := int64(0)
+	if idleFor > c.idleTimeout {
+		var err error
+		lag, _, err = c.readLag()
+		if err != nil {
+			c.Logger.Debug(ctx, "", "Failed to read consumer lag of %s: %s", c.Name(), err)
+			return
+		}
+	}
+	idle := lag > 0
+
+	c.Lock.Lock()
+	c.idle = idle
+	c.Lock.Unlock()
+
+	if idle && !wasIdle {
+		c.Counters.IncrementOne(ctx, "queue."+c.Name()+".idle_consumers")
+		c.Metrics.Increment(connect.MetricQueueIdleConsumers, c.metricLabels(nil), 1)
+		c.Logger.Warn(ctx, "", "Consumer at %s received no messages for %s while the lag is %d", c.Name(), idleFor, lag)
+		if callback != nil {
+			callback(ctx, idleFor, lag)
+		}
+	} else if !idle && wasIdle {
+		c.Logger.Info(ctx, "", "Consumer at %s resumed receiving messages", c.Name())
+	}
+}
+
+func (c *KafkaMessageQueue) startMetrics() {
+	if c.metricsInterval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	c.metricsStop = stop
+
+	c.workers.Add(1)
+	go func() {
+		defer c.workers.Done()
+
+		ticker := time.NewTicker(c.metricsInterval)
+		defer ticker.Stop()
+
+		last := time.Now()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				c.publishMetrics(now.Sub(last))
+				last = now
+			}
+		}
+	}()
+}
+
+// Publishes consumer lag and processing rate gauges for autoscalers
+func (c *KafkaMessageQueue) publishMetrics(elapsed time.Duration) {
+	ctx := context.Background()
+
+	processed := atomic.SwapInt64(&c.processed, 0)
+	if elapsed > 0 {
+		c.Counters.Last(ctx, "queue."+c.Name()+".processing_rate", float64(processed)/elapsed.Seconds())
+		c.Metrics.Set(connect.MetricQueueProcessingRate, c.metricLabels(nil), float64(processed)/elapsed.Seconds())
+	}
+
+	lag, _, err := c.readLag()
+	if err != nil {
+		c.Logger.Debug(ctx, "", "Failed to read consumer lag of %s: %s", c.Name(), err)
+		return
+	}
+	c.Counters.Last(ctx, "queue."+c.Name()+".consumer_lag", float64(lag))
+	c.Metrics.Set(connect.MetricQueueConsumerLag, c.metricLabels(nil), float64(lag))
+}
+
+// Set bool channel with ready flag for consumer
+//	Parameters:
+//		- chFlag	bool channel
+func (c *KafkaMessageQueue) SetReady(chFlag chan bool) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.ready = chFlag
+}
+
+// Returns: channel with bool flag ready
+func (c *KafkaMessageQueue) Ready() chan bool {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.ready
+}
+
+func (c *KafkaMessageQueue) fromMessage(message *cqueues.MessageEnvelope) (*kafka.ProducerMessage, error) {
+	if message == nil {
+		return nil, nil
+	}
+
+	headers := []kafka.RecordHeader{
+		{
+			Key:   correlationIdHeaderKey,
+			Value: []byte(message.CorrelationId),
+		},
+		{
+			Key:   messageTypeHeaderKey,
+			Value: []byte(message.MessageType),
+		},
+	}
+
+	msg := &kafka.ProducerMessage{}
+	msg.Topic = c.getTopic()
+	msg.Key = kafka.StringEncoder(message.MessageId)
+	// The producer encodes the payload as is without copying
+	msg.Value = kafka.ByteEncoder(message.Message)
+	msg.Headers = headers
+
+	// Keep the sent time of the envelope in the record timestamp
+	msg.Timestamp = message.SentTime
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+
+	return msg, nil
+}
+
+func (c *KafkaMessageQueue) toMessage(msg *connect.KafkaMessage) (*cqueues.MessageEnvelope, error) {
+	// Read envelope headers in one pass without converting other header keys to strings
+	var messageType, correlationId []byte
+	for _, header := range msg.Message.Headers {
+		if header == nil {
+			continue
+		}
+		if messageType == nil && bytes.Equal(header.Key, messageTypeHeaderKey) {
+			messageType = header.Value
+		} else if correlationId == nil && bytes.Equal(header.Key, correlationIdHeaderKey) {
+			correlationId = header.Value
+		}
+	}
+
+	// The message id is taken from the record key, so it is not generated
+	message := cqueues.NewEmptyMessageEnvelope()
+	// Ids of records from foreign producers may be not valid UTF-8, which breaks logs and JSON
+	message.MessageType = strings.ToValidUTF8(string(messageType), "\uFFFD")
+	message.CorrelationId = strings.ToValidUTF8(string(correlationId), "\uFFFD")
+	message.MessageId = strings.ToValidUTF8(string(msg.Message.Key), "\uFFFD")
+	// The record timestamp is either the producer CreateTime or the broker LogAppendTime
+	if !msg.Message.Timestamp.IsZero() {
+		message.SentTime = msg.Message.Timestamp
+	}
+	// The payload shares memory with the record, so it is not copied on every message
+	message.Message = msg.Message.Value
+	message.SetReference(msg)
+
+	return message, nil
+}
+
+// Wraps original bytes of an undecodable record into a message marked with the raw content type
+func (c *KafkaMessageQueue) toRawMessage(msg *connect.KafkaMessage) (*cqueues.MessageEnvelope, error) {
+	headers := make([]*kafka.RecordHeader, 0, len(msg.Message.Headers)+1)
+	for _, header := range msg.Message.Headers {
+		if header != nil && string(header.Key) != ContentTypeHeader {
+			headers = append(headers, header)
+		}
+	}
+	msg.Message.Headers = append(headers, &kafka.RecordHeader{
+		Key:   []byte(ContentTypeHeader),
+		Value: []byte(ContentTypeRaw),
+	})
+
+	return c.toMessage(msg)
+}
+
+//	Setup is run at the beginning of a new session, before ConsumeClaim
+//	Send ready flag into channel
+//	Returns: error
+func (c *KafkaMessageQueue) Setup(session kafka.ConsumerGroupSession) error {
+	// Restore offsets from the external store before claims start consuming
+	if c.OffsetStore != nil {
+		for topic, partitions := range session.Claims() {
+			offsets, err := c.OffsetStore.ReadOffsets(session.Context(), "", topic, c.groupId)
+			if err != nil {
+				c.Logger.Error(session.Context(), "", err, "Failed to read offsets of topic "+topic)
+				return err
+			}
+			for _, partition := range partitions {
+				if offset, ok := offsets[partition]; ok {
+					// Marking moves the offset forward and resetting moves it backward
+					session.MarkOffset(topic, partition, offset, c.GetCommitMetadata())
+					session.ResetOffset(topic, partition, offset, c.GetCommitMetadata())
+				}
+			}
+		}
+	}
+
+	// Offsets of the session are committed in the background in the async commit mode
+	if c.commitMode == CommitAsync && c.OffsetStore == nil {
+		c.Lock.Lock()
+		c.committer = newKafkaAsyncCommitter(session, c.commitSyncInterval, c.onCommit)
+		c.Lock.Unlock()
+	}
+
+	// Mark the consumer as ready without blocking on rebalances
+	ready := c.Ready()
+	select {
+	case ready <- true:
+	default:
+	}
+	close(ready)
+	return nil
+}
+
+// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited.
+// Offsets left by the async committer or by failed commits are committed before partitions are revoked.
+func (c *KafkaMessageQueue) Cleanup(session kafka.ConsumerGroupSession) error {
+	c.Lock.Lock()
+	committer := c.committer
+	if committer != nil && committer.owns(session) {
+		c.committer = nil
+	}
+	// Partitions of ended sessions are not paused anymore
+	for key, lock := range c.locks {
+		if lock.ownedBy(session) {
+			lock.timer.Stop()
+			delete(c.locks, key)
+		}
+	}
+	c.Lock.Unlock()
+
+	if committer != nil && committer.owns(session) {
+		committer.stop()
+	} else if c.OffsetStore == nil {
+		// Commits send only offsets that were not committed yet, so nothing is sent when all are committed
+		session.Commit()
+	}
+	return nil
+}
+
+// Commits the marked offset of the session synchronously or passes it to the async committer
+func (c *KafkaMessageQueue) commitOffset(ctx context.Context, session kafka.ConsumerGroupSession,
+	topic string, partition int32, offset int64) {
+
+	c.Lock.Lock()
+	committer := c.committer
+	c.commitSession = session
+	if c.commitRequested[topic] == nil {
+		c.commitRequested[topic] = make(map[int32]int64)
+	}
+	c.commitRequested[topic][partition] = offset
+	c.Lock.Unlock()
+
+	// Messages of previous sessions are committed synchronously
+	if committer != nil && committer.owns(session) {
+		committer.commit(topic, partition, offset)
+		return
+	}
+
+	session.Commit()
+	c.onCommit(ctx, map[string]map[int32]int64{topic: {partition: offset}}, nil)
+}
+
+// Sends again a failed commit of the partition with exponential backoff, until the committed offset
+// reaches the requested one or retries are exhausted. Failed commits stay marked in sessions,
+// so they are sent again by any following commit.
+func (c *KafkaMessageQueue) retryCommit(topic string, partition int32, reason error) {
+	c.Lock.Lock()
+	session := c.commitSession
+	offset, requested := c.commitRequested[topic][partition]
+	if session == nil || !requested || c.commitRetrying[topic][partition] {
+		c.Lock.Unlock()
+		return
+	}
+	if c.commitRetrying[topic] == nil {
+		c.commitRetrying[topic] = make(map[int32]bool)
+	}
+	c.commitRetrying[topic][partition] = true
+	retries := c.commitRetries
+	backoff := c.commitRetryBackoff
+	c.Lock.Unlock()
+
+	go func() {
+		defer func() {
+			c.Lock.Lock()
+			delete(c.commitRetrying[topic], partition)
+			c.Lock.Unlock()
+		}()
+
+		ctx := context.Background()
+		offsets := map[string]map[int32]int64{topic: {partition: offset}}
+		for attempt := 0; attempt < retries; attempt++ {
+			time.Sleep(backoff << attempt)
+
+			c.Counters.IncrementOne(ctx, "queue."+c.Name()+".commit_retries")
+			session.Commit()
+			if c.isCommitted(partition, offset) {
+				c.Logger.Info(ctx, "", "Committed offset %d of partition %d of %s after %d retries",
+					offset, partition, topic, attempt+1)
+				c.onCommit(ctx, offsets, nil)
+				return
+			}
+		}
+
+		err := cerr.NewInvocationError("", "COMMIT_FAILED",
+			fmt.Sprintf("Failed to commit offset %d of partition %d of %s", offset, partition, topic)).
+			WithDetails("partition", partition).
+			WithCause(reason)
+		c.Logger.Error(ctx, "", err, "Gave up committing offset %d of partition %d of %s, messages will be received again",
+			offset, partition, topic)
+		c.Counters.IncrementOne(ctx, "queue."+c.Name()+".commit_failures")
+		c.Metrics.Increment(connect.MetricQueueCommitFailures, c.metricLabels(nil), 1)
+		c.reportError(ctx, "", err)
+		c.onCommit(ctx, offsets, err)
+	}()
+}
+
+// Checks if the committed offset of the partition reached the requested offset.
+// The check is skipped for wildcard topics and connections that can't read offsets back.
+func (c *KafkaMessageQueue) isCommitted(partition int32, offset int64) bool {
+	topic := c.getTopic()
+	transfer, ok := c.Connection.(connect.IKafkaOffsetTransfer)
+	if !ok || strings.Contains(topic, "*") {
+		return true
+	}
+
+	snapshot, err := transfer.ExportOffsets(topic, c.subscribedGroup)
+	if err != nil {
+		return false
+	}
+	committed, ok := snapshot.Offsets[partition]
+	return ok && committed >= offset
+}
+
+// Reports committed offsets to the commit callback
+func (c *KafkaMessageQueue) onCommit(ctx context.Context, offsets map[string]map[int32]int64, err error) {
+	c.Lock.Lock()
+	callback := c.commitCallback
+	c.Lock.Unlock()
+
+	if callback != nil {
+		callback(ctx, offsets, err)
+	}
+}
+
+// ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages().
+func (c *KafkaMessageQueue) ConsumeClaim(session kafka.ConsumerGroupSession, claim kafka.ConsumerGroupClaim) error {
+	// NOTE:
+	// Do not move the code below to a goroutine.
+	// The `ConsumeClaim` itself is called within a goroutine, see:
+	// https://github.com/Shopify/sarama/blob/master/consumer_group.go#L27-L29
+
+	for {
+		// if len(c.readablePartitions) == 0 || slices.Contains(c.readablePartitions, claim.Partition()) {
+		select {
+		case msg := <-claim.Messages():
+			if msg != nil && !c.consumeMessage(session, msg) {
+				return nil
+			}
+		// Should return when `session.Context()` is done.
+		// If not, will raise `ErrRebalanceInProgress` or `read tcp <ip>:<port>: i/o timeout` when kafka rebalance. see:
+		// https://github.com/Shopify/sarama/issues/1192
+		case <-session.Context().Done():
+			return nil
+		}
+		// }
+	}
+}
+
+// Processes a claimed message and commits it on autocommit.
+// When the receiver reports unavailable downstream the consumption is paused
+// and the message is processed again after resume.
+// Returns false if the claim shall stop consuming.
+func (c *KafkaMessageQueue) consumeMessage(session kafka.ConsumerGroupSession, msg *kafka.ConsumerMessage) bool {
+	canary := c.isCanary()
+	if canary && !c.matchCanary(msg.Partition) {
+		return true
+	}
+
+	for {
+		// Stop fetching new messages while draining
+		if !c.beginHandling(msg.Partition) {
+			return false
+		}
+
+		message := &connect.KafkaMessage{
+			Message: msg,
+			Session: session,
+		}
+
+		err := c.handleMessage(session.Context(), message)
+		c.endHandling(msg.Partition)
+
+		// Stop the partition at records that cannot be read
+		var deserializeErr *kafkaDeserializeError
+		if errors.As(err, &deserializeErr) {
+			c.Logger.Error(session.Context(), "", deserializeErr.err,
+				"Stopped consuming partition %d of %s at malformed record %d", msg.Partition, msg.Topic, msg.Offset)
+			return false
+		}
+
+		// Stop the partition at records routed to unregistered handlers
+		var routeErr *kafkaRouteError
+		if errors.As(err, &routeErr) {
+			c.Logger.Error(session.Context(), "", routeErr.err,
+				"Stopped consuming partition %d of %s at unroutable record %d", msg.Partition, msg.Topic, msg.Offset)
+			c.reportError(session.Context(), "", routeErr.err)
+			return false
+		}
+
+		if !IsDownstreamUnavailableError(err) {
+			break
+		}
+
+		err = c.Pause(session.Context(), "", c.pauseTimeout)
+		if err != nil {
+			// The message is retried after the pause timeout without pausing the consumer
+			c.Logger.Error(session.Context(), "", err, "Failed to pause consumption at %s", c.Name())
+			c.reportError(session.Context(), "", err)
+			if !c.waitRetry(session.Context(), c.pauseTimeout) {
+				return false
+			}
+			continue
+		}
+		if !c.waitResume(session.Context()) {
+			return false
+		}
+	}
+
+	atomic.AddInt64(&c.processed, 1)
+
+	// Listeners don't complete autocommitted messages, so their locks are released on return
+	c.Lock.Lock()
+	listening := c.listenStop != nil
+	c.Lock.Unlock()
+	if c.autoCommit && listening {
+		c.releaseLock(session.Context(), &connect.KafkaMessage{Message: msg, Session: session}, false)
+	}
+
+	if c.autoCommit && !canary {
+		if c.OffsetStore != nil {
+			_ = c.saveOffset(session.Context(), msg, msg.Offset+1)
+		} else {
+			session.MarkMessage(msg, c.GetCommitMetadata())
+			c.commitOffset(session.Context(), session, msg.Topic, msg.Partition, msg.Offset+1)
+		}
+	}
+
+	return true
+}
+
+// Saves the next offset to consume from the message partition into the external offset store
+func (c *KafkaMessageQueue) saveOffset(ctx context.Context, msg *kafka.ConsumerMessage, offset int64) error {
+	err := c.OffsetStore.SaveOffset(ctx, "", msg.Topic, c.groupId, msg.Partition, offset)
+	if err != nil {
+		c.Logger.Error(ctx, "", err, "Failed to save offset of topic %s partition %d", msg.Topic, msg.Partition)
+		c.reportError(ctx, "", err)
+	}
+	return err
+}
+
+//	Callback for processing messages from kafka
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- msg *connect.KafkaMessage	consumer message
+func (c *KafkaMessageQueue) OnMessage(ctx context.Context, msg *connect.KafkaMessage) {
+	c.handleMessage(ctx, msg)
+}
+
+// Deserializes a message and passes it to the receiver or puts it into the queue.
+// Returns an error from the receiver.
+func (c *KafkaMessageQueue) handleMessage(ctx context.Context, msg *connect.KafkaMessage) error {
+	c.Lock.Lock()
+	c.lastReceived = time.Now()
+	c.Lock.Unlock()
+
+	// // Skip if it came from a wrong topic
+	// expectedTopic := c.getTopic()
+	// if !strings.Contains(expectedTopic, "*") && expectedTopic != msg.Topic {
+	// 	return
+	// }
+
+	// Deserialize message
+	message, err := c.toMessage(msg)
+
+	if message != nil && err == nil {
+		err = c.Pipeline.Decode(ctx, message)
+	}
+	if message != nil && err == nil {
+		err = c.upcastMessage(ctx, message)
+	}
+
+	// Pass records that cannot be decoded as raw bytes
+	if (message == nil || err != nil) && c.onDeserializeError == DeserializeErrorRaw {
+		c.Logger.Warn(ctx, "", "Received undecodable record %d of %s as a raw message: %v",
+			msg.Message.Offset, msg.Message.Topic, err)
+		c.Counters.IncrementOne(ctx, "queue."+c.Name()+".malformed_messages")
+		message, err = c.toRawMessage(msg)
+	}
+
+	if message == nil || err != nil {
+		c.Logger.Error(ctx, "", err, "Failed to read received message")
+		if err == nil {
+			err = cerr.NewBadRequestError("", "BAD_MESSAGE", "Failed to read received message")
+		}
+		c.reportError(ctx, "", err)
+		return c.handleDeserializeError(ctx, msg, err)
+	}
+
+	// Skip and commit messages that do not match the filter or routed to skip
+	if !c.matchFilter(message) {
+		c.skipMessage(ctx, msg, message)
+		return nil
+	}
+	if route := c.matchRoute(message); route != nil && route.IsSkip() {
+		c.skipMessage(ctx, msg, message)
+		return nil
+	}
+
+	// Skip messages of other tenants and pass the tenant to receivers
+	if tenantId := GetMessageHeader(message, TenantHeader); tenantId != "" {
+		if c.tenancy == TenancyHeader && c.tenantId != "" && tenantId != c.tenantId {
+			c.skipMessage(ctx, msg, message)
+			return nil
+		}
+		ctx = WithTenant(ctx, tenantId)
+	}
+
+	c.Counters.IncrementOne(ctx, "queue."+c.Name()+".received_messages")
+	c.Metrics.Increment(connect.MetricQueueReceivedMessages, c.metricLabels(message), 1)
+	c.recordLatency(ctx, message, "age")
+	c.Logger.Debug(ctx, message.CorrelationId, "Received message %s via %s", message, c.Name())
+
+	c.Lock.Lock()
+	interceptors := c.receiveInterceptors
+	// Tap messages as they were received before other interceptors
+	if c.tapInterceptor != nil {
+		c.tapInterceptor.Connection = c.Connection
+		interceptors = append([]IReceiveInterceptor{c.tapInterceptor}, interceptors...)
+	}
+	c.Lock.Unlock()
+
+	// Chain interceptors from the last to the first
+	handler := c.deliverMessage
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, message *cqueues.MessageEnvelope) error {
+			return interceptor.InterceptReceive(ctx, message, next)
+		}
+	}
+
+	return handler(ctx, message)
+}
+
+// Sends message to receiver if the queue is listening or puts it into the queue.
+// When the queue holds max_poll_records messages it waits until Receive takes some,
+// so the consumer doesn't fetch more records than the application processes.
+func (c *KafkaMessageQueue) deliverMessage(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	c.Lock.Lock()
+	for c.listenStop == nil && c.maxPollRecords > 0 && len(c.messages) >= c.maxPollRecords {
+		c.Lock.Unlock()
+		select {
+		case <-c.spaceSignal:
+		case <-ctx.Done():
+			// Keep the message on shutdown or rebalance, so it is not lost
+			c.Lock.Lock()
+			c.messages = append(c.messages, message)
+			c.Lock.Unlock()
+			c.signalMessage()
+			return nil
+		}
+		c.Lock.Lock()
+	}
+
+	if c.listenStop != nil {
+		c.Lock.Unlock()
+		return c.dispatchMessage(ctx, message)
+	}
+
+	c.messages = append(c.messages, message)
+	c.Lock.Unlock()
+	c.signalMessage()
+	return nil
+}
+
+func (c *KafkaMessageQueue) skipMessage(ctx context.Context, msg *connect.KafkaMessage, message *cqueues.MessageEnvelope) {
+	c.Counters.IncrementOne(ctx, "queue."+c.Name()+".skipped_messages")
+	c.Metrics.Increment(connect.MetricQueueSkippedMessages, c.metricLabels(message), 1)
+	c.Logger.Trace(ctx, message.CorrelationId, "Skipped message %s via %s", message, c.Name())
+	c.commitMessage(ctx, msg)
+}
+
+// Commits a message that is not passed to receivers. Messages are committed on return on autocommit.
+func (c *KafkaMessageQueue) commitMessage(ctx context.Context, msg *connect.KafkaMessage) {
+	if c.autoCommit || c.isCanary() {
+		return
+	}
+	if c.deferCommit(msg, func() { c.markMessage(ctx, msg) }) {
+		return
+	}
+	c.markMessage(ctx, msg)
+	c.releaseLock(ctx, msg, true)
+}
+
+// Marks and commits the offset after a message
+func (c *KafkaMessageQueue) markMessage(ctx context.Context, msg *connect.KafkaMessage) {
+	if c.OffsetStore != nil {
+		_ = c.saveOffset(ctx, msg.Message, msg.Message.Offset+1)
+	} else if msg.Session != nil {
+		msg.Session.MarkMessage(msg.Message, c.GetCommitMetadata())
+		c.commitOffset(ctx, msg.Session, msg.Message.Topic, msg.Message.Partition, msg.Message.Offset+1)
+	}
+}
+
+// Error of a record that cannot be read, which stops consuming of its partition
+type kafkaDeserializeError struct {
+	err error
+}
+
+func (e *kafkaDeserializeError) Error() string {
+	return e.err.Error()
+}
+
+// Error of a message routed to a handler that is not registered
+type kafkaRouteError struct {
+	err error
+}
+
+func (e *kafkaRouteError) Error() string {
+	return e.err.Error()
+}
+
+// Handles a record that cannot be read according to the deserialization error policy
+func (c *KafkaMessageQueue) handleDeserializeError(ctx context.Context, msg *connect.KafkaMessage, err error) error {
+	switch c.onDeserializeError {
+	case DeserializeErrorFail:
+		return &kafkaDeserializeError{err: err}
+	case DeserializeErrorDeadLetter:
+		// Records are kept in the partition when they cannot be saved elsewhere
+		dlqErr := c.publishDeadLetter(ctx, msg.Message, err)
+		if dlqErr != nil {
+			c.Logger.Error(ctx, "", dlqErr, "Failed to move malformed record %d of %s to the dead letter topic",
+				msg.Message.Offset, msg.Message.Topic)
+			return &kafkaDeserializeError{err: err}
+		}
+	}
+
+	c.Counters.IncrementOne(ctx, "queue."+c.Name()+".malformed_messages")
+	c.commitMessage(ctx, msg)
+	return nil
+}
+
+// Gets the dead letter topic of the queue
+func (c *KafkaMessageQueue) getDeadLetterTopic() string {
+	if c.deadLetterTopic != "" {
+		return c.deadLetterTopic
+	}
+	return c.getBaseTopic() + DeadLetterSuffix
+}
+
+// Copies a received record to the dead letter topic with its origin and the reason
+func (c *KafkaMessageQueue) publishDeadLetter(ctx context.Context, msg *kafka.ConsumerMessage, reason error) error {
+	headers := make([]kafka.RecordHeader, 0, len(msg.Headers)+5)
+	for _, header := range msg.Headers {
+		if header != nil {
+			headers = append(headers, *header)
+		}
+	}
+	headers = append(headers,
+		kafka.RecordHeader{Key: []byte(DeadLetterTopicHeader), Value: []byte(msg.Topic)},
+		kafka.RecordHeader{Key: []byte(DeadLetterPartitionHeader), Value: []byte(strconv.Itoa(int(msg.Partition)))},
+		kafka.RecordHeader{Key: []byte(DeadLetterOffsetHeader), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		kafka.RecordHeader{Key: []byte(DeadLetterGroupHeader), Value: []byte(c.groupId)},
+	)
+	if reason != nil {
+		headers = append(headers, kafka.RecordHeader{Key: []byte(DeadLetterErrorHeader), Value: []byte(reason.Error())})
+	}
+
+	record := &kafka.ProducerMessage{
+		Value:     kafka.ByteEncoder(msg.Value),
+		Headers:   headers,
+		Timestamp: time.Now(),
+	}
+	if msg.Key != nil {
+		record.Key = kafka.ByteEncoder(msg.Key)
+	}
+
+	err := c.Connection.Publish(ctx, c.getDeadLetterTopic(), []*kafka.ProducerMessage{record})
+	if err == nil {
+		c.Counters.IncrementOne(ctx, "queue."+c.Name()+".dead_letter_messages")
+	}
+	return err
+}
+
+// Finds the first route that matches the message
+func (c *KafkaMessageQueue) matchRoute(message *cqueues.MessageEnvelope) *KafkaMessageRoute {
+	c.Lock.Lock()
+	routes := c.routes
+	c.Lock.Unlock()
+
+	if len(routes) == 0 {
+		return nil
+	}
+
+	// The payload is parsed once for all routes
+	getPayload := newPayloadGetter(message)
+	for _, route := range routes {
+		if route.matchPayload(message, getPayload) {
+			return route
+		}
+	}
+	return nil
+}
+
+// Sends a message to the handler of the matched route, the handler registered for its type,
+// the default handler or the listening receiver.
+func (c *KafkaMessageQueue) dispatchMessage(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	route := c.matchRoute(message)
+	if route != nil && route.IsSkip() {
+		return nil
+	}
+
+	c.Lock.Lock()
+	var receivers []cqueues.IMessageReceiver
+	if route != nil {
+		receiver := c.routeHandlers[route.Handler]
+		if receiver == nil {
+			c.Lock.Unlock()
+			// Keep the message uncommitted until the handler is registered
+			err := cerr.NewConfigError(message.CorrelationId, "ROUTE_HANDLER_NOT_FOUND",
+				"Route handler "+route.Handler+" is not registered at "+c.Name()).
+				WithDetails("handler", route.Handler).
+				WithDetails("expression", route.Expression)
+			return &kafkaRouteError{err: err}
+		}
+		receivers = []cqueues.IMessageReceiver{receiver}
+	} else {
+		receiver, ok := c.handlers[message.MessageType]
+		if !ok {
+			receiver = c.defaultHandler
+		}
+		if receiver != nil {
+			receivers = []cqueues.IMessageReceiver{receiver}
+		} else {
+			receivers = c.selectReceivers()
+		}
+	}
+	c.Lock.Unlock()
+
+	if len(receivers) == 0 {
+		c.Counters.IncrementOne(ctx, "queue."+c.Name()+".unhandled_messages")
+		c.Metrics.Increment(connect.MetricQueueUnhandledMessages, c.metricLabels(message), 1)
+		c.Logger.Warn(ctx, message.CorrelationId, "No handler for message type %s at %s", message.MessageType, c.Name())
+		return nil
+	}
+
+	// In broadcast mode all receivers get the message and the first error is returned
+	var err error
+	for _, receiver := range receivers {
+		receiverErr := c.sendMessageToReceiver(ctx, receiver, message)
+		if err == nil {
+			err = receiverErr
+		}
+	}
+	return err
+}
+
+// Selects listening receivers for the next message according to the receive mode.
+// Must be called under the lock.
+func (c *KafkaMessageQueue) selectReceivers() []cqueues.IMessageReceiver {
+	receivers := make([]cqueues.IMessageReceiver, 0, len(c.receivers)+1)
+	if c.receiver != nil {
+		receivers = append(receivers, c.receiver)
+	}
+	receivers = append(receivers, c.receivers...)
+
+	if len(receivers) <= 1 || c.receiveMode == ReceiveBroadcast {
+		return receivers
+	}
+
+	receiver := receivers[c.nextReceiver%len(receivers)]
+	c.nextReceiver++
+	return []cqueues.IMessageReceiver{receiver}
+}
+
+//	Adds a receiver that shares messages with the receiver passed to Listen.
+//	While the queue is listening messages without type or route handlers are passed
+//	to the receivers in turn or to all of them, depending on the receive_mode option.
+//	All receivers are served by the same consumer.
+//	Parameters:
+//		- receiver cqueues.IMessageReceiver	a receiver to add
+func (c *KafkaMessageQueue) AddReceiver(receiver cqueues.IMessageReceiver) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.receivers = append(c.receivers, receiver)
+}
+
+//	Unsubscribes one of the receivers at runtime while the queue keeps its subscription.
+//	The receiver may be added by AddReceiver or passed to Listen.
+//	When the last receiver is removed listening is ended and new messages are collected
+//	by the queue until the next Listen.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId  string   (optional) transaction id to trace execution through call chain.
+//		- receiver cqueues.IMessageReceiver	a receiver to remove
+func (c *KafkaMessageQueue) Unsubscribe(ctx context.Context, correlationId string, receiver cqueues.IMessageReceiver) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	removed := false
+	if c.receiver == receiver {
+		c.receiver = nil
+		removed = true
+	}
+	for index, item := range c.receivers {
+		if item == receiver {
+			c.receivers = append(c.receivers[:index:index], c.receivers[index+1:]...)
+			removed = true
+			break
+		}
+	}
+	if !removed {
+		return
+	}
+
+	c.Logger.Trace(ctx, correlationId, "Unsubscribed a receiver from %s", c.Name())
+
+	if c.receiver == nil && len(c.receivers) == 0 {
+		c.stopListening()
+	}
+}
+
+// Records time from producing of the message till now by its topic and type
+func (c *KafkaMessageQueue) recordLatency(ctx context.Context, message *cqueues.MessageEnvelope, metric string) {
+	msg, ok := message.GetReference().(*connect.KafkaMessage)
+	if !ok || msg == nil || msg.Message == nil || msg.Message.Timestamp.IsZero() {
+		return
+	}
+
+	messageType := message.MessageType
+	if messageType == "" {
+		messageType = "unknown"
+	}
+
+	elapsed := time.Since(msg.Message.Timestamp)
+	c.Counters.EndTiming(ctx, "topic."+msg.Message.Topic+"."+messageType+"."+metric, float64(elapsed.Milliseconds()))
+
+	if !c.Metrics.IsEnabled() {
+		return
+	}
+	labels := c.metricLabels(message)
+	labels["message_type"] = messageType
+	if metric == "age" {
+		c.Metrics.Observe(connect.MetricQueueMessageAge, labels, float64(elapsed.Milliseconds()))
+	} else {
+		c.Metrics.Observe(connect.MetricQueueMessageLatency, labels, float64(elapsed.Milliseconds()))
+	}
+}
+
+// Composes labels of queue metrics. Topic and partition are taken from received messages
+func (c *KafkaMessageQueue) metricLabels(message *cqueues.MessageEnvelope) map[string]string {
+	// Labels are not composed for every message when nobody receives them
+	if !c.Metrics.IsEnabled() {
+		return nil
+	}
+
+	labels := map[string]string{
+		"queue": c.Name(),
+		"topic": c.getTopic(),
+		"group": c.groupId,
+	}
+	if message != nil {
+		if msg, ok := message.GetReference().(*connect.KafkaMessage); ok && msg != nil && msg.Message != nil {
+			labels["topic"] = msg.Message.Topic
+			labels["partition"] = strconv.Itoa(int(msg.Message.Partition))
+		}
+	}
+	return labels
+}
+
+//	Registers a receiver for messages of a specific type.
+//	While the queue is listening messages are dispatched to receivers registered for their types,
+//	other messages go to the default handler or to the receiver passed to Listen.
+//	Parameters:
+//		- messageType string	a message type
+//		- receiver cqueues.IMessageReceiver	a receiver for the messages
+func (c *KafkaMessageQueue) RegisterHandler(messageType string, receiver cqueues.IMessageReceiver) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.handlers[messageType] = receiver
+}
+
+//	Unregisters a receiver of a specific message type.
+//	Parameters:
+//		- messageType string	a message type
+func (c *KafkaMessageQueue) UnregisterHandler(messageType string) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	delete(c.handlers, messageType)
+}
+
+//	Registers a named receiver for messages matched by configured routes.
+//	Parameters:
+//		- name string	a handler name used in the routes configuration
+//		- receiver cqueues.IMessageReceiver	a receiver for the messages
+func (c *KafkaMessageQueue) RegisterRouteHandler(name string, receiver cqueues.IMessageReceiver) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.routeHandlers[name] = receiver
+}
+
+//	Adds a content-based route evaluated after the configured ones.
+//	Parameters:
+//		- route *KafkaMessageRoute	a route to add
+func (c *KafkaMessageQueue) AddRoute(route *KafkaMessageRoute) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.routes = append(c.routes, route)
+}
+
+//	Registers a fallback receiver for messages without type specific handlers.
+//	Parameters:
+//		- receiver cqueues.IMessageReceiver	a receiver for the messages, or nil to use the Listen receiver
+func (c *KafkaMessageQueue) RegisterDefaultHandler(receiver cqueues.IMessageReceiver) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.defaultHandler = receiver
+}
+
+// Wakes up a waiting receiver without blocking when nobody waits
+func (c *KafkaMessageQueue) signalMessage() {
+	select {
+	case c.messageSignal <- struct{}{}:
+	default:
+	}
+}
+
+// Wakes up a consumer waiting for free space in the queue without blocking when nobody waits
+func (c *KafkaMessageQueue) signalSpace() {
+	select {
+	case c.spaceSignal <- struct{}{}:
+	default:
+	}
+}
+
+//	Capabilities method are gets capabilities of the queue with its current configuration.
+//	Messages can be abandoned only when offsets are committed manually, and canaries
+//	don't lock, abandon or move messages to the dead letter topic.
+//	The queue can't be cleared, since Clear drops only fetched messages and keeps them in the topic.
+//	Returns: the queue's capabilities object.
+func (c *KafkaMessageQueue) Capabilities() *cqueues.MessagingCapabilities {
+	canary := c.isCanary()
+	return cqueues.NewMessagingCapabilities(true, true, true, true, true, !canary, !c.autoCommit && !canary, !canary, false)
+}
+
+//	Clear method are clears component state.
 //	Parameters:
 //		- ctx context.Context	operation context
 //		- correlationId 	string (optional) transaction id to trace execution through call chain.
@@ -506,47 +2420,157 @@ func (c *KafkaMessageQueue) Clear(ctx context.Context, correlationId string) err
 	defer c.Lock.Unlock()
 
 	c.messages = make([]*cqueues.MessageEnvelope, 0)
+	c.signalSpace()
 
 	return nil
 }
 
 //	ReadMessageCount method are reads the current number of messages in the queue to be delivered.
+//	The count is the consumer group lag summed across the read partitions
+//	plus messages that were already fetched and committed but not yet received.
 //	Returns number of messages or error.
 func (c *KafkaMessageQueue) ReadMessageCount() (int64, error) {
+	err := c.CheckOpen("")
+	if err != nil {
+		return 0, err
+	}
+
+	lags, err := c.Connection.ReadLags(c.getTopic(), c.groupId, c.readablePartitions)
+	if err != nil {
+		return 0, err
+	}
+
+	count := int64(0)
+	for _, lag := range lags {
+		count += lag
+	}
+
+	// Uncommitted fetched messages are already included into the lag
 	c.Lock.Lock()
-	defer c.Lock.Unlock()
+	if c.autoCommit {
+		count += (int64)(len(c.messages))
+	}
+	c.Lock.Unlock()
 
-	count := (int64)(len(c.messages))
 	return count, nil
 }
 
-//	Peek method are peeks a single incoming message from the queue without removing it.
-//	If there are no messages available in the queue it returns nil.
+//	Gets the current lag of the queue consumer group.
 //	Parameters:
 //		- ctx context.Context	operation context
-//		- correlationId  string  (optional) transaction id to trace execution through call chain.
-//	Returns: result *cqueues.MessageEnvelope, err error
-//	message or error.
-func (c *KafkaMessageQueue) Peek(ctx context.Context, correlationId string) (*cqueues.MessageEnvelope, error) {
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//	Returns: total lag, lags by partitions or error.
+func (c *KafkaMessageQueue) GetLag(ctx context.Context, correlationId string) (int64, map[int32]int64, error) {
+	err := c.CheckOpen(correlationId)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return c.readLag()
+}
+
+func (c *KafkaMessageQueue) readLag() (int64, map[int32]int64, error) {
+	lags, err := c.Connection.ReadLags(c.getTopic(), c.groupId, c.readablePartitions)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	total := int64(0)
+	for _, lag := range lags {
+		total += lag
+	}
+	return total, lags, nil
+}
+
+//	Exports committed offsets of the queue consumer group.
+//	The snapshot can be serialized to JSON and restored later by ImportOffsets.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//	Returns: a snapshot of committed offsets or error.
+func (c *KafkaMessageQueue) ExportOffsets(ctx context.Context, correlationId string) (*connect.KafkaOffsetSnapshot, error) {
 	err := c.CheckOpen(correlationId)
 	if err != nil {
 		return nil, err
 	}
 
-	// Subscribe if needed
-	err = c.subscribe(ctx, correlationId)
+	transfer, ok := c.Connection.(connect.IKafkaOffsetTransfer)
+	if !ok {
+		return nil, connect.NewUnsupportedOperationError(correlationId, "ExportOffsets")
+	}
+
+	snapshot, err := transfer.ExportOffsets(c.getTopic(), c.groupId)
 	if err != nil {
+		c.Logger.Error(ctx, correlationId, err, "Failed to export offsets of group "+c.groupId)
 		return nil, err
 	}
+	return snapshot, nil
+}
+
+//	Imports committed offsets into the queue consumer group.
+//	The offsets are applied to the queue topic and group, so a snapshot taken
+//	from another deployment can be restored. The queue must not be listening
+//	and other members of the group must be stopped.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- snapshot *connect.KafkaOffsetSnapshot	a snapshot of committed offsets
+//	Returns: error or nil no errors occured.
+func (c *KafkaMessageQueue) ImportOffsets(ctx context.Context, correlationId string, snapshot *connect.KafkaOffsetSnapshot) error {
+	err := c.CheckOpen(correlationId)
+	if err != nil {
+		return err
+	}
 
-	var message *cqueues.MessageEnvelope
+	transfer, ok := c.Connection.(connect.IKafkaOffsetTransfer)
+	if !ok {
+		return connect.NewUnsupportedOperationError(correlationId, "ImportOffsets")
+	}
 
-	// Pick a message
 	c.Lock.Lock()
-	if len(c.messages) > 0 {
-		message = c.messages[0]
-	}
+	subscribed := c.subscribed
 	c.Lock.Unlock()
+	if subscribed {
+		return cerr.NewInvalidStateError(correlationId, "ALREADY_SUBSCRIBED",
+			"Offsets cannot be imported while the queue is subscribed")
+	}
+
+	imported := connect.NewKafkaOffsetSnapshot(c.getTopic(), c.groupId)
+	imported.Time = snapshot.Time
+	for partition, offset := range snapshot.Offsets {
+		imported.Offsets[partition] = offset
+	}
+	for partition, metadata := range snapshot.Metadata {
+		imported.Metadata[partition] = metadata
+	}
+
+	err = transfer.ImportOffsets(imported)
+	if err != nil {
+		c.Logger.Error(ctx, correlationId, err, "Failed to import offsets of group "+c.groupId)
+		return err
+	}
+
+	c.Logger.Info(ctx, correlationId, "Imported offsets of group %s on topic %s", c.groupId, imported.Topic)
+	return nil
+}
+
+//	Peek method are peeks a single incoming message from the queue without removing it.
+//	If there are no messages available in the queue it returns nil.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId  string  (optional) transaction id to trace execution through call chain.
+//	Returns: result *cqueues.MessageEnvelope, err error
+//	message or error.
+func (c *KafkaMessageQueue) Peek(ctx context.Context, correlationId string) (*cqueues.MessageEnvelope, error) {
+	messages, err := c.peekMessages(ctx, correlationId, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var message *cqueues.MessageEnvelope
+	if len(messages) > 0 {
+		message = messages[0]
+	}
 
 	if message != nil {
 		c.Logger.Trace(ctx, message.CorrelationId, "Peeked message %s on %s", message, c.String())
@@ -557,46 +2581,93 @@ func (c *KafkaMessageQueue) Peek(ctx context.Context, correlationId string) (*cq
 
 //	PeekBatch method are peeks multiple incoming messages from the queue without removing them.
 //	If there are no messages available in the queue it returns an empty list.
-//	Important: This method is not supported by Kafka.
 //	Parameters:
 //		- ctx context.Context	operation context
 //		- correlationId     (optional) transaction id to trace execution through call chain.
 //		- messageCount      a maximum number of messages to peek.
 //	Returns:          callback function that receives a list with messages or error.
 func (c *KafkaMessageQueue) PeekBatch(ctx context.Context, correlationId string, messageCount int64) ([]*cqueues.MessageEnvelope, error) {
-	err := c.CheckOpen(correlationId)
+	messages, err := c.peekMessages(ctx, correlationId, int(messageCount))
 	if err != nil {
 		return nil, err
 	}
 
-	// Subscribe if needed
-	err = c.subscribe(ctx, correlationId)
+	c.Logger.Trace(ctx, correlationId, "Peeked %d messages on %s", len(messages), c.Name())
+
+	return messages, nil
+}
+
+// Peeks already fetched messages first and then reads the rest
+// from the topic without joining the consumer group and committing offsets.
+func (c *KafkaMessageQueue) peekMessages(ctx context.Context, correlationId string, messageCount int) ([]*cqueues.MessageEnvelope, error) {
+	err := c.CheckOpen(correlationId)
 	if err != nil {
 		return nil, err
 	}
 
+	messages := []*cqueues.MessageEnvelope{}
+	// The last fetched offsets by partitions
+	fetched := map[int32]int64{}
+
 	c.Lock.Lock()
-	batchMessages := c.messages
-	if messageCount <= (int64)(len(batchMessages)) {
-		batchMessages = batchMessages[0:messageCount]
+	for _, message := range c.messages {
+		if msg, ok := message.GetReference().(*connect.KafkaMessage); ok && msg != nil {
+			fetched[msg.Message.Partition] = msg.Message.Offset
+		}
+		if len(messages) < messageCount {
+			messages = append(messages, message)
+		}
 	}
 	c.Lock.Unlock()
 
-	messages := []*cqueues.MessageEnvelope{}
-	messages = append(messages, batchMessages...)
+	if len(messages) >= messageCount {
+		return messages, nil
+	}
 
-	c.Logger.Trace(ctx, correlationId, "Peeked %d messages on %s", len(messages), c.Name())
+	// Fetched messages are not committed yet, so they are peeked again and skipped by their offsets.
+	// All of them are returned above, so the requested count covers them.
+	msgs, err := c.Connection.PeekMessages(c.getTopic(), c.groupId, c.readablePartitions, messageCount)
+	if err != nil {
+		c.Logger.Error(ctx, correlationId, err, "Failed to peek messages from topic "+c.getTopic())
+		return nil, err
+	}
+
+	for _, msg := range msgs {
+		if len(messages) >= messageCount {
+			break
+		}
+
+		// Skip messages that were already fetched by the consumer group
+		if offset, ok := fetched[msg.Partition]; ok && msg.Offset <= offset {
+			continue
+		}
+
+		message, err := c.toMessage(&connect.KafkaMessage{Message: msg})
+		if err == nil {
+			err = c.Pipeline.Decode(ctx, message)
+		}
+		if err == nil {
+			err = c.upcastMessage(ctx, message)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Peeked messages can not be completed or abandoned
+		message.SetReference(nil)
+		messages = append(messages, message)
+	}
 
 	return messages, nil
 }
 
 //	Receive method are receives an incoming message and removes it from the queue.
+//	The call blocks until a message arrives, the wait timeout expires or the context deadline is reached.
 //	Parameters:
 //		- ctx context.Context	operation context
 //		- correlationId   string   (optional) transaction id to trace execution through call chain.
 //		- waitTimeout  time.Duration     a timeout in milliseconds to wait for a message to come.
 //	Returns:  result *cqueues.MessageEnvelope, err error
-//	receives a message or error.
+//	receives a message, nil on expired timeout or error.
 func (c *KafkaMessageQueue) Receive(ctx context.Context, correlationId string, waitTimeout time.Duration) (*cqueues.MessageEnvelope, error) {
 	err := c.CheckOpen(correlationId)
 	if err != nil {
@@ -609,83 +2680,435 @@ func (c *KafkaMessageQueue) Receive(ctx context.Context, correlationId string, w
 		return nil, err
 	}
 
-	messageReceived := false
-	var message *cqueues.MessageEnvelope
-	elapsedTime := time.Duration(0)
+	timer := time.NewTimer(waitTimeout)
+	defer timer.Stop()
 
-	for elapsedTime < waitTimeout && !messageReceived {
+	for {
+		// Get message from the queue
 		c.Lock.Lock()
-		if len(c.messages) == 0 {
+		if len(c.messages) > 0 {
+			message := c.messages[0]
+			c.messages = c.messages[1:]
+			remaining := len(c.messages)
 			c.Lock.Unlock()
-			time.Sleep(time.Duration(100) * time.Millisecond)
-			elapsedTime += time.Duration(100)
-			continue
+
+			// Pass the signal to other waiting receivers
+			if remaining > 0 {
+				c.signalMessage()
+			}
+			c.signalSpace()
+			return message, nil
+		}
+		c.Lock.Unlock()
+
+		// Wait for a new message
+		select {
+		case <-c.messageSignal:
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, nil
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+//	Send method are sends a message into the queue.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string    (optional) transaction id to trace execution through call chain.
+//		- envelope *cqueues.MessageEnvelope  a message envelop to be sent.
+//	Returns: error or nil for success.
+func (c *KafkaMessageQueue) Send(ctx context.Context, correlationId string, envelop *cqueues.MessageEnvelope) error {
+	err := c.CheckOpen(correlationId)
+	if err != nil {
+		return err
+	}
+
+	c.Counters.IncrementOne(ctx, "queue."+c.Name()+".sent_messages")
+	c.Metrics.Increment(connect.MetricQueueSentMessages, c.metricLabels(nil), 1)
+	c.Logger.Debug(ctx, envelop.CorrelationId, "Sent message %s via %s", envelop.String(), c.Name())
+
+	tenantId := ""
+	if c.tenancy == TenancyTopic || c.tenancy == TenancyHeader {
+		tenantId = c.getSentTenant(ctx, envelop)
+	}
+
+	err = c.validateMessage(ctx, correlationId, envelop)
+	if err != nil {
+		return err
+	}
+
+	// Transform a copy to keep the original message intact
+	if !c.Pipeline.IsEmpty() {
+		transformed := *envelop
+		err = c.Pipeline.Encode(ctx, &transformed)
+		if err != nil {
+			return err
+		}
+		envelop = &transformed
+	}
+
+	msg, err := c.fromMessage(envelop)
+	if err != nil {
+		return err
+	}
+
+	topic := c.getBaseTopic()
+
+	// Route the message to its tenant
+	if tenantId != "" {
+		topic = c.getTenantTopic(tenantId)
+		if topic != c.getTopic() {
+			err = c.checkTenantTopic(correlationId, topic)
+			if err != nil {
+				return err
+			}
 		}
+		msg.Headers = append(msg.Headers, kafka.RecordHeader{
+			Key:   []byte(TenantHeader),
+			Value: []byte(tenantId),
+		})
+	}
+
+	// Mark the payload with its schema version
+	if version := c.Upcasters.GetVersion(envelop.MessageType); version > 0 {
+		msg.Headers = append(msg.Headers, kafka.RecordHeader{
+			Key:   []byte(SchemaVersionHeader),
+			Value: []byte(strconv.Itoa(version)),
+		})
+	}
+
+	if c.writePartition != -1 {
+		msg.Partition = int32(c.writePartition)
+	}
+
+	c.Lock.Lock()
+	interceptors := c.sendInterceptors
+	// Audit final messages after all other interceptors
+	if c.auditInterceptor != nil {
+		c.auditInterceptor.Connection = c.Connection
+		interceptors = append(append(make([]ISendInterceptor, 0, len(interceptors)+1), interceptors...), c.auditInterceptor)
+	}
+	// Tap final messages after all other interceptors
+	if c.tapInterceptor != nil {
+		c.tapInterceptor.Connection = c.Connection
+		interceptors = append(append(make([]ISendInterceptor, 0, len(interceptors)+1), interceptors...), c.tapInterceptor)
+	}
+	c.Lock.Unlock()
 
-		// Get message from the queue
-		message = c.messages[0]
-		c.messages = c.messages[1:]
+	// Chain interceptors from the last to the first
+	handler := func(ctx context.Context, correlationId string, envelope *cqueues.MessageEnvelope, msg *kafka.ProducerMessage) error {
+		err := c.Connection.Publish(ctx, topic, []*kafka.ProducerMessage{msg})
+		if err == nil {
+			c.sendShadow(ctx, envelope, msg)
+		}
+		return err
+	}
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, correlationId string, envelope *cqueues.MessageEnvelope, msg *kafka.ProducerMessage) error {
+			return interceptor.InterceptSend(ctx, correlationId, envelope, msg, next)
+		}
+	}
 
-		// Add messages to locked messages list
-		messageReceived = true
-		c.Lock.Unlock()
+	err = handler(ctx, correlationId, envelop, msg)
+	if err != nil {
+		c.Logger.Error(ctx, envelop.CorrelationId, err, "Failed to send message via %s", c.Name())
+		return err
 	}
 
-	return message, nil
+	return nil
 }
 
-//	Send method are sends a message into the queue.
+//	Sends a message into the queue and fails when it is not acknowledged within the timeout.
+//	It suits request paths with strict latency budgets that prefer a fast failure
+//	to waiting for the produce timeouts of the connection. A deadline of the context works the same way.
+//	Messages of timed out sends can still be delivered later.
 //	Parameters:
 //		- ctx context.Context	operation context
 //		- correlationId string    (optional) transaction id to trace execution through call chain.
 //		- envelope *cqueues.MessageEnvelope  a message envelop to be sent.
+//		- timeout time.Duration	a timeout of sending
 //	Returns: error or nil for success.
-func (c *KafkaMessageQueue) Send(ctx context.Context, correlationId string, envelop *cqueues.MessageEnvelope) error {
-	err := c.CheckOpen(correlationId)
+func (c *KafkaMessageQueue) SendWithTimeout(ctx context.Context, correlationId string, envelope *cqueues.MessageEnvelope,
+	timeout time.Duration) error {
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return c.Send(ctx, correlationId, envelope)
+}
+
+// Upcasts a received message from the schema version in its header to the latest version
+func (c *KafkaMessageQueue) upcastMessage(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	version, err := parseSchemaVersion(message)
 	if err != nil {
 		return err
 	}
 
-	c.Counters.IncrementOne(ctx, "queue."+c.Name()+".sent_messages")
-	c.Logger.Debug(ctx, envelop.CorrelationId, "Sent message %s via %s", envelop.String(), c.Name())
-
-	msg, err := c.fromMessage(envelop)
+	upcasted, err := c.Upcasters.Upcast(ctx, message, version)
 	if err != nil {
 		return err
 	}
+	if upcasted != version {
+		c.Counters.IncrementOne(ctx, "queue."+c.Name()+".upcast_messages")
+		c.Metrics.Increment(connect.MetricQueueUpcastMessages, c.metricLabels(message), 1)
+	}
+	return nil
+}
+
+//	Sets a schema to validate payloads of sent messages.
+//	Messages that do not match the schema are rejected by Send.
+//	Parameters:
+//		- messageType string	a message type or "" for messages of all other types
+//		- schema cvalid.ISchema	a validation schema of decoded JSON payloads, like KafkaJsonSchema, or nil to remove it
+func (c *KafkaMessageQueue) SetSchema(messageType string, schema cvalid.ISchema) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	if schema == nil {
+		delete(c.schemas, messageType)
+	} else {
+		c.schemas[messageType] = schema
+	}
+}
 
-	topic := c.Name()
-	if topic == "" {
-		topic = c.topic
+// Validates the JSON payload of a sent message by the schema of its type
+func (c *KafkaMessageQueue) validateMessage(ctx context.Context, correlationId string, message *cqueues.MessageEnvelope) error {
+	c.Lock.Lock()
+	schema, ok := c.schemas[message.MessageType]
+	if !ok {
+		schema = c.schemas[""]
 	}
+	c.Lock.Unlock()
 
-	if c.writePartition != -1 {
-		msg.Partition = int32(c.writePartition)
+	if schema == nil {
+		return nil
+	}
+
+	var value any
+	var validationErr *cerr.ApplicationError
+	if err := json.Unmarshal(message.Message, &value); err != nil {
+		validationErr = cerr.NewBadRequestError(correlationId, "INVALID_JSON", "Message payload is not a valid JSON").
+			WithCause(err)
+	} else {
+		validationErr = schema.ValidateAndReturnError(correlationId, value, false)
+	}
+	if validationErr == nil {
+		return nil
+	}
+
+	c.Counters.IncrementOne(ctx, "queue."+c.Name()+".rejected_messages")
+	c.Metrics.Increment(connect.MetricQueueRejectedMessages, c.metricLabels(nil), 1)
+	c.Logger.Warn(ctx, correlationId, "Rejected message %s of type %s sent via %s: %s",
+		message.MessageId, message.MessageType, c.Name(), validationErr.Message)
+	return validationErr.WithDetails("message_type", message.MessageType)
+}
+
+//	Sets the shadow topic that receives copies of sent messages.
+//	Shadow traffic lets a new consumer version run against real messages before cutover.
+//	Parameters:
+//		- topic string	a shadow topic or empty string to stop mirroring
+func (c *KafkaMessageQueue) SetShadowTopic(topic string) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.shadowTopic = topic
+}
+
+//	Gets the shadow topic that receives copies of sent messages.
+//	Returns: the shadow topic or empty string when mirroring is off.
+func (c *KafkaMessageQueue) GetShadowTopic() string {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.shadowTopic
+}
+
+// Mirrors a sent message to the shadow topic. Failures are logged and do not fail sending
+func (c *KafkaMessageQueue) sendShadow(ctx context.Context, envelope *cqueues.MessageEnvelope, msg *kafka.ProducerMessage) {
+	shadowTopic := c.GetShadowTopic()
+	if shadowTopic == "" {
+		return
+	}
+
+	headers := make([]kafka.RecordHeader, 0, len(msg.Headers)+1)
+	headers = append(headers, msg.Headers...)
+	headers = append(headers, kafka.RecordHeader{Key: []byte(ShadowHeader), Value: []byte("true")})
+	shadow := &kafka.ProducerMessage{
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   headers,
+		Timestamp: msg.Timestamp,
 	}
 
-	err = c.Connection.Publish(ctx, topic, []*kafka.ProducerMessage{msg})
+	err := c.Connection.Publish(ctx, shadowTopic, []*kafka.ProducerMessage{shadow})
 	if err != nil {
-		c.Logger.Error(ctx, envelop.CorrelationId, err, "Failed to send message via %s", c.Name())
-		return err
+		c.Counters.IncrementOne(ctx, "queue."+c.Name()+".shadow_errors")
+		c.Logger.Error(ctx, envelope.CorrelationId, err, "Failed to mirror message %s to shadow topic %s",
+			envelope.MessageId, shadowTopic)
+		return
 	}
+	c.Counters.IncrementOne(ctx, "queue."+c.Name()+".shadow_messages")
+}
 
-	return nil
+//	Adds an interceptor to the chain of sent messages.
+//	Parameters:
+//		- interceptor ISendInterceptor	an interceptor to add
+func (c *KafkaMessageQueue) AddSendInterceptor(interceptor ISendInterceptor) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.sendInterceptors = append(c.sendInterceptors, interceptor)
+}
+
+//	Adds an interceptor to the chain of received messages.
+//	Parameters:
+//		- interceptor IReceiveInterceptor	an interceptor to add
+func (c *KafkaMessageQueue) AddReceiveInterceptor(interceptor IReceiveInterceptor) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.receiveInterceptors = append(c.receiveInterceptors, interceptor)
 }
 
 //	RenewLock method are renews a lock on a message that makes it invisible from other receivers in the queue.
 //	This method is usually used to extend the message processing time.
-//	Important: This method is not supported by Kafka.
+//	Kafka has no message locks, so the lock is emulated: the message partition is paused,
+//	commits of later messages from the partition are deferred and the handler is not reported stuck.
+//	Connections that don't implement connect.IKafkaPartitionPauser fail with UnsupportedError.
+//	See "Message locks" in the queue description.
 //	Parameters:
 //		- ctx context.Context	operation context
 //		- message   *cqueues.MessageEnvelope    a message to extend its lock.
-//		- lockTimeout  time.Duration  a locking timeout in milliseconds.
+//		- lockTimeout  time.Duration  a locking timeout, 0 to use the max poll interval.
 //	Returns: error
 //	receives an error or nil for success.
 func (c *KafkaMessageQueue) RenewLock(ctx context.Context, message *cqueues.MessageEnvelope, lockTimeout time.Duration) (err error) {
-	// Not supported
+	err = c.CheckOpen("")
+	if err != nil {
+		return err
+	}
+
+	msg, ok := message.GetReference().(*connect.KafkaMessage)
+	if c.isCanary() || !ok || msg == nil || msg.Message == nil {
+		return nil
+	}
+	if lockTimeout <= 0 {
+		lockTimeout = c.maxPollInterval
+	}
+
+	key := kafkaPartition{topic: msg.Message.Topic, partition: msg.Message.Partition}
+
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	lock, locked := c.locks[key]
+	if locked && lock.offset != msg.Message.Offset {
+		return cerr.NewConflictError(message.CorrelationId, "PARTITION_LOCKED",
+			"Partition "+strconv.Itoa(int(key.partition))+" of "+key.topic+" is locked by another message").
+			WithDetails("offset", lock.offset)
+	}
+
+	if !locked {
+		pauser, ok := c.Connection.(connect.IKafkaPartitionPauser)
+		if !ok {
+			return connect.NewUnsupportedOperationError(message.CorrelationId, "PausePartitions")
+		}
+		lock = &kafkaMessageLock{session: msg.Session, offset: msg.Message.Offset}
+		c.locks[key] = lock
+		if c.subscribed {
+			err = pauser.PausePartitions(c.getTopic(), c.subscribedGroup, c,
+				map[string][]int32{key.topic: {key.partition}})
+			if err != nil {
+				delete(c.locks, key)
+				return err
+			}
+		}
+	} else {
+		lock.timer.Stop()
+	}
+	lock.expires = time.Now().Add(lockTimeout)
+	lock.timer = time.AfterFunc(lockTimeout, func() {
+		c.expireLock(key, lock)
+	})
+
+	// Renewals are progress of the handler
+	if _, ok := c.handlingSince[key.partition]; ok {
+		c.handlingSince[key.partition] = time.Now()
+	}
+
+	c.Logger.Trace(ctx, message.CorrelationId, "Renewed lock of message %s on %s for %s", message, c.Name(), lockTimeout)
 	return nil
 }
 
+// Defers a commit of a message received after the locked message of its partition.
+// Returns true if the commit is deferred.
+func (c *KafkaMessageQueue) deferCommit(msg *connect.KafkaMessage, commit func()) bool {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	lock := c.locks[kafkaPartition{topic: msg.Message.Topic, partition: msg.Message.Partition}]
+	if lock == nil || msg.Message.Offset <= lock.offset {
+		return false
+	}
+	// Later commits include earlier ones
+	if msg.Message.Offset > lock.deferredOffset {
+		lock.deferred = commit
+		lock.deferredOffset = msg.Message.Offset
+	}
+	return true
+}
+
+// Releases the lock held by the message and resumes its partition.
+// Deferred commits are made when the message was committed.
+func (c *KafkaMessageQueue) releaseLock(ctx context.Context, msg *connect.KafkaMessage, committed bool) {
+	key := kafkaPartition{topic: msg.Message.Topic, partition: msg.Message.Partition}
+
+	c.Lock.Lock()
+	lock := c.locks[key]
+	if lock == nil || lock.offset != msg.Message.Offset {
+		c.Lock.Unlock()
+		return
+	}
+	c.unlockPartition(key, lock)
+	c.Lock.Unlock()
+
+	if committed && lock.deferred != nil {
+		lock.deferred()
+	}
+}
+
+// Releases a lock that was not renewed in time. Deferred commits are dropped,
+// since the locked message may not be processed.
+func (c *KafkaMessageQueue) expireLock(key kafkaPartition, lock *kafkaMessageLock) {
+	c.Lock.Lock()
+	// Locks renewed while the timer fired are kept
+	if c.locks[key] != lock || time.Now().Before(lock.expires) {
+		c.Lock.Unlock()
+		return
+	}
+	c.unlockPartition(key, lock)
+	c.Lock.Unlock()
+
+	ctx := context.Background()
+	c.Counters.IncrementOne(ctx, "queue."+c.Name()+".expired_locks")
+	c.Logger.Warn(ctx, "", "Lock of message %d in partition %d of %s expired on %s",
+		lock.offset, key.partition, key.topic, c.Name())
+}
+
+// Removes the lock and resumes its partition. Must be called under the lock.
+func (c *KafkaMessageQueue) unlockPartition(key kafkaPartition, lock *kafkaMessageLock) {
+	lock.timer.Stop()
+	delete(c.locks, key)
+	if pauser, ok := c.Connection.(connect.IKafkaPartitionPauser); ok && c.subscribed {
+		err := pauser.ResumePartitions(c.getTopic(), c.subscribedGroup, c,
+			map[string][]int32{key.topic: {key.partition}})
+		if err != nil {
+			c.Logger.Error(context.Background(), "", err, "Failed to resume partition %d of %s on %s",
+				key.partition, key.topic, c.Name())
+		}
+	}
+}
+
 //	Complete method are permanently removes a message from the queue.
 //	This method is usually used to remove the message after successful processing.
 //	Parameters:
@@ -699,18 +3122,52 @@ func (c *KafkaMessageQueue) Complete(ctx context.Context, message *cqueues.Messa
 		return err
 	}
 
-	msg := message.GetReference().(*connect.KafkaMessage)
+	msg, ok := message.GetReference().(*connect.KafkaMessage)
+
+	// Listening receivers are timed on return
+	c.Lock.Lock()
+	listening := c.listenStop != nil
+	c.Lock.Unlock()
+	if !listening {
+		c.recordLatency(ctx, message, "latency")
+	}
+
+	if !ok || msg == nil {
+		return nil
+	}
+
+	// Skip on autocommit and in canaries
+	if c.autoCommit || c.isCanary() {
+		c.releaseLock(ctx, msg, false)
+		return nil
+	}
 
-	// Skip on autocommit
-	if c.autoCommit || msg == nil {
+	// Later messages of a locked partition are committed with the locked message
+	if c.deferCommit(msg, func() { _ = c.completeOffset(ctx, msg) }) {
+		message.SetReference(nil)
 		return nil
 	}
 
-	// Commit the message offset so it won't come back
-	msg.Session.MarkOffset(msg.Message.Topic, msg.Message.Partition, msg.Message.Offset, "")
-	msg.Session.Commit()
+	err = c.completeOffset(ctx, msg)
+	if err != nil {
+		return err
+	}
 	message.SetReference(nil)
+	c.releaseLock(ctx, msg, true)
+
+	return nil
+}
+
+// Commits the offset of a completed message
+func (c *KafkaMessageQueue) completeOffset(ctx context.Context, msg *connect.KafkaMessage) error {
+	// Save the offset within the context of the caller, so it can join a business transaction
+	if c.OffsetStore != nil {
+		return c.saveOffset(ctx, msg.Message, msg.Message.Offset+1)
+	}
 
+	// Commit the next offset to consume so the message won't come back
+	msg.Session.MarkMessage(msg.Message, c.GetCommitMetadata())
+	c.commitOffset(ctx, msg.Session, msg.Message.Topic, msg.Message.Partition, msg.Message.Offset+1)
 	return nil
 }
 
@@ -729,10 +3186,24 @@ func (c *KafkaMessageQueue) Abandon(ctx context.Context, message *cqueues.Messag
 		return err
 	}
 
-	msg := message.GetReference().(*connect.KafkaMessage)
+	msg, ok := message.GetReference().(*connect.KafkaMessage)
+	if !ok || msg == nil {
+		return nil
+	}
+
+	// Skip on autocommit and in canaries
+	if c.autoCommit || c.isCanary() {
+		c.releaseLock(ctx, msg, false)
+		return nil
+	}
 
-	// Skip on autocommit
-	if c.autoCommit || msg == nil {
+	if c.OffsetStore != nil {
+		err = c.saveOffset(ctx, msg.Message, msg.Message.Offset)
+		if err != nil {
+			return err
+		}
+		message.SetReference(nil)
+		c.releaseLock(ctx, msg, false)
 		return nil
 	}
 
@@ -740,39 +3211,193 @@ func (c *KafkaMessageQueue) Abandon(ctx context.Context, message *cqueues.Messag
 	msg.Session.ResetOffset(msg.Message.Topic, msg.Message.Partition, msg.Message.Offset, "")
 	msg.Session.Commit()
 	message.SetReference(nil)
+	c.releaseLock(ctx, msg, false)
 
 	return nil
 }
 
 //	Permanently removes a message from the queue and sends it to dead letter queue.
-//	Important: This method is not supported by Kafka.
+//	The original record is copied to the dead letter topic with headers of its topic, partition,
+//	offset and consumer group, and then its offset is committed. When the copy fails,
+//	the offset is not committed, so the message can be moved again or abandoned.
+//	Canaries don't move messages, since the main consumers handle them as well.
 //	Parameters:
 //		- ctx context.Context	operation context
 //		- message  *cqueues.MessageEnvelope a message to be removed.
 //	Returns: error
 //	error or nil for success.
 func (c *KafkaMessageQueue) MoveToDeadLetter(ctx context.Context, message *cqueues.MessageEnvelope) error {
-	// Not supported
+	err := c.CheckOpen("")
+	if err != nil {
+		return err
+	}
+
+	msg, ok := message.GetReference().(*connect.KafkaMessage)
+	if c.isCanary() || !ok || msg == nil || msg.Message == nil {
+		return nil
+	}
+
+	err = c.publishDeadLetter(ctx, msg.Message, nil)
+	if err != nil {
+		c.Logger.Error(ctx, message.CorrelationId, err, "Failed to move message %s to the dead letter topic via %s",
+			message, c.Name())
+		return err
+	}
+
+	c.commitMessage(ctx, msg)
+	c.releaseLock(ctx, msg, true)
+	message.SetReference(nil)
+
+	c.Logger.Trace(ctx, message.CorrelationId, "Moved message %s to the dead letter topic via %s", message, c.Name())
 	return nil
 }
 
-func (c *KafkaMessageQueue) sendMessageToReceiver(ctx context.Context, receiver cqueues.IMessageReceiver, message *cqueues.MessageEnvelope) {
+func (c *KafkaMessageQueue) sendMessageToReceiver(ctx context.Context, receiver cqueues.IMessageReceiver, message *cqueues.MessageEnvelope) (err error) {
 	correlationId := message.CorrelationId
 
 	defer func() {
 		if r := recover(); r != nil {
-			err := fmt.Sprintf("%v", r)
-			c.Logger.Error(ctx, correlationId, nil, "Failed to process the message - "+err)
+			msg := fmt.Sprintf("%v", r)
+			c.Logger.Error(ctx, correlationId, nil, "Failed to process the message - "+msg)
+			err = cerr.NewUnknownError(correlationId, "PROCESSING_FAILED", msg)
+			c.reportError(ctx, correlationId, err)
 		}
 	}()
 
-	err := receiver.ReceiveMessage(ctx, message, c)
-	if err != nil {
+	err = receiver.ReceiveMessage(ctx, message, c)
+	if err == nil {
+		c.recordLatency(ctx, message, "latency")
+	}
+	if IsDownstreamUnavailableError(err) {
+		c.Logger.Warn(ctx, correlationId, "Downstream is unavailable for %s: %s", c.Name(), err.Error())
+	} else if err != nil {
 		c.Logger.Error(ctx, correlationId, err, "Failed to process the message")
+		c.reportError(ctx, correlationId, err)
+	}
+	return err
+}
+
+//	Pauses consumption of messages while keeping the consumer group membership.
+//	Receivers pause the queue automatically by returning DownstreamUnavailable errors.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId  string   (optional) transaction id to trace execution through call chain.
+//		- timeout time.Duration	time to resume consumption after, or 0 to wait for explicit Resume
+//	Returns: error or nil for success.
+func (c *KafkaMessageQueue) Pause(ctx context.Context, correlationId string, timeout time.Duration) error {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	if !c.paused {
+		c.paused = true
+		c.resumeSignal = make(chan struct{})
+		if c.subscribed {
+			err := c.Connection.Pause(c.getTopic(), c.subscribedGroup, c)
+			if err != nil {
+				// Nobody waits for the signal yet, since it is created under the lock
+				c.paused = false
+				c.resumeSignal = nil
+				return err
+			}
+		}
+		c.Logger.Info(ctx, correlationId, "Paused consumption at %s", c.Name())
+	}
+
+	if c.pauseTimer != nil {
+		c.pauseTimer.Stop()
+		c.pauseTimer = nil
+	}
+	if timeout > 0 {
+		c.pauseTimer = time.AfterFunc(timeout, func() {
+			c.Resume(context.Background(), correlationId)
+		})
+	}
+
+	return nil
+}
+
+//	Resumes previously paused consumption of messages.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId  string   (optional) transaction id to trace execution through call chain.
+//	Returns: error or nil for success.
+func (c *KafkaMessageQueue) Resume(ctx context.Context, correlationId string) error {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	if !c.paused {
+		return nil
+	}
+
+	c.clearPause()
+	c.Logger.Info(ctx, correlationId, "Resumed consumption at %s", c.Name())
+
+	if c.subscribed {
+		return c.Connection.Resume(c.getTopic(), c.subscribedGroup, c)
+	}
+	return nil
+}
+
+//	Checks if consumption of messages is paused.
+//	Returns: true if the queue is paused and false otherwise.
+func (c *KafkaMessageQueue) IsPaused() bool {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.paused
+}
+
+// Resets the pause state and wakes up waiting consumers. Must be called under the lock.
+func (c *KafkaMessageQueue) clearPause() {
+	if c.pauseTimer != nil {
+		c.pauseTimer.Stop()
+		c.pauseTimer = nil
+	}
+	if c.paused {
+		c.paused = false
+		close(c.resumeSignal)
+		c.resumeSignal = nil
+	}
+}
+
+// Waits before the message is retried. Returns false if the context is done before that.
+func (c *KafkaMessageQueue) waitRetry(ctx context.Context, delay time.Duration) bool {
+	if delay <= 0 {
+		delay = c.commitRetryBackoff
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Waits until the queue is resumed. Returns false if the context is done before that.
+func (c *KafkaMessageQueue) waitResume(ctx context.Context) bool {
+	c.Lock.Lock()
+	if !c.paused {
+		c.Lock.Unlock()
+		return true
+	}
+	resumed := c.resumeSignal
+	c.Lock.Unlock()
+
+	select {
+	case <-resumed:
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
 
-//	Listens for incoming messages and blocks the current thread until queue is closed.
+//	Listens for incoming messages and blocks the current thread until queue is closed,
+//	listening is ended or the context is canceled.
+//	Messages with registered type handlers are dispatched to them instead of the receiver.
+//	On context cancellation the queue leaves the consumer group and commits processed offsets.
 //	Parameters:
 //		- ctx context.Context	operation context
 //		- correlationId   string  (optional) transaction id to trace execution through call chain.
@@ -786,40 +3411,98 @@ func (c *KafkaMessageQueue) Listen(ctx context.Context, correlationId string, re
 		return err
 	}
 
+	// Canary listeners need their own subscription
+	canary := IsCanary(ctx)
+	c.Lock.Lock()
+	if c.subscribed && c.canary != canary {
+		c.Lock.Unlock()
+		return cerr.NewInvalidStateError(correlationId, "ALREADY_SUBSCRIBED",
+			"Queue "+c.Name()+" is already subscribed in another mode, canary listeners need a separate queue").
+			WithDetails("canary", canary)
+	}
+	c.canary = canary
+	c.Lock.Unlock()
+
 	// Subscribe if needed
 	err = c.subscribe(ctx, correlationId)
 	if err != nil {
 		return err
 	}
 
+	if canary {
+		c.Logger.Info(ctx, correlationId, "Started canary listening at %s in group %s", c.Name(), c.canaryGroupId)
+	}
 	c.Logger.Trace(ctx, "", "Started listening messages at %s", c.Name())
 
-	// Get all collected messages
+	// Set the receiver
 	c.Lock.Lock()
+	c.stopListening()
+	c.receiver = receiver
+	stop := make(chan struct{})
+	c.listenStop = stop
+
+	// Get all collected messages
 	batchMessages := c.messages
 	c.messages = []*cqueues.MessageEnvelope{}
 	c.Lock.Unlock()
+	c.signalSpace()
 
-	// Resend collected messages to receiver
+	// Resend collected messages to receivers
 	for _, message := range batchMessages {
-		receiver.ReceiveMessage(ctx, message, c)
+		c.dispatchMessage(ctx, message)
 	}
 
-	// Set the receiver
+	// Block until listening is ended or canceled
+	select {
+	case <-stop:
+		return nil
+	case <-ctx.Done():
+	}
+
+	c.Logger.Trace(ctx, correlationId, "Stopped listening messages at %s", c.Name())
+
 	c.Lock.Lock()
-	c.receiver = receiver
+	if c.listenStop == stop {
+		c.stopListening()
+	}
 	c.Lock.Unlock()
 
-	return nil
+	return c.drain(ctx, correlationId)
 }
 
 //	EndListen method are ends listening for incoming messages.
 //	When this method is call listen unblocks the thread and execution continues.
+//	In-flight messages are given up to the drain timeout to finish before the queue leaves the consumer group.
 //	Parameters:
 //		- ctx context.Context	operation context
 //		- correlationId  string   (optional) transaction id to trace execution through call chain.
 func (c *KafkaMessageQueue) EndListen(ctx context.Context, correlationId string) {
 	c.Lock.Lock()
-	c.receiver = nil
+	c.stopListening()
 	c.Lock.Unlock()
+
+	err := c.drain(ctx, correlationId)
+	if err != nil {
+		c.Logger.Error(ctx, correlationId, err, "Failed to end listening at %s", c.Name())
+	}
+}
+
+// Clears the receiver and unblocks Listen. Must be called under the lock.
+func (c *KafkaMessageQueue) stopListening() {
+	c.receiver = nil
+	if c.listenStop != nil {
+		close(c.listenStop)
+		c.listenStop = nil
+	}
+}
+
+// Splits a list option separated by semicolons
+func splitOptionList(value string) []string {
+	sinks := make([]string, 0)
+	for _, sink := range strings.Split(value, ";") {
+		if sink = strings.TrimSpace(sink); sink != "" {
+			sinks = append(sinks, sink)
+		}
+	}
+	return sinks
 }
@@ -0,0 +1,308 @@
+package queues
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+// SkipRoute is the handler name of routes that skip matched messages
+const SkipRoute = "skip"
+
+//	KafkaMessageRoute routes received messages to named handlers by their content.
+//
+//	Route expressions consist of conditions joined by && and ||, where && takes precedence.
+//	A condition compares two operands with == or != or checks a single operand is not empty.
+//	Operands are:
+//		- type:				the message type
+//		- id:				the message id
+//		- correlation_id:	the message correlation id
+//		- header.<key>:		a Kafka header value
+//		- $.<path>:			a value from JSON payload, like $.order.items.0.sku
+//		- "text" or 'text':	a quoted string literal
+//		- text:				an unquoted literal, like 100 or true
+//
+//	Configuration parameters:
+//
+//		- routes:
+//			- <index>:
+//				- handler:	a name of the handler or "skip" to skip matched messages;
+//							listening queues stop consuming partitions at messages routed
+//							to handlers that are not registered and don't commit them
+//				- when:		a route expression, like: header.tenant == "abc" && $.total != 0
+//
+//	Example:
+//		route, err := NewKafkaMessageRoute("vip", `type == "order" && $.customer.vip == true`)
+type KafkaMessageRoute struct {
+	// Name of the handler that receives matched messages
+	Handler string
+	// Route expression
+	Expression string
+
+	// Alternatives of conjunctive conditions
+	conditions [][]*routeCondition
+}
+
+type routeCondition struct {
+	left     string
+	operator string
+	right    string
+}
+
+//	Creates a new message route.
+//	Parameters:
+//		- handler string	a name of the handler or "skip" to skip matched messages
+//		- expression string	a route expression
+//	Returns: the created route or error if the expression is invalid.
+func NewKafkaMessageRoute(handler string, expression string) (*KafkaMessageRoute, error) {
+	conditions, err := parseRouteExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KafkaMessageRoute{
+		Handler:    handler,
+		Expression: expression,
+		conditions: conditions,
+	}, nil
+}
+
+//	Creates message routes from configuration parameters in the order of their indexes.
+//	Parameters:
+//		- config	configuration parameters with the routes section
+//	Returns: the created routes or error if an expression is invalid.
+func NewKafkaMessageRoutesFromConfig(config *cconf.ConfigParams) ([]*KafkaMessageRoute, error) {
+	section := config.GetSection("routes")
+	names := section.GetSectionNames()
+	sort.SliceStable(names, func(i, j int) bool {
+		a, errA := strconv.Atoi(names[i])
+		b, errB := strconv.Atoi(names[j])
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return names[i] < names[j]
+	})
+
+	routes := make([]*KafkaMessageRoute, 0, len(names))
+	for _, name := range names {
+		routeConfig := section.GetSection(name)
+		handler := routeConfig.GetAsStringWithDefault("handler", SkipRoute)
+		route, err := NewKafkaMessageRoute(handler, routeConfig.GetAsString("when"))
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+
+	return routes, nil
+}
+
+//	Checks if the route skips matched messages.
+//	Returns: true if the route skips messages and false otherwise.
+func (c *KafkaMessageRoute) IsSkip() bool {
+	return c.Handler == SkipRoute
+}
+
+//	Checks if a message matches the route expression.
+//	Parameters:
+//		- message	a message to check
+//	Returns: true if the message matches and false otherwise.
+func (c *KafkaMessageRoute) Match(message *cqueues.MessageEnvelope) bool {
+	return c.matchPayload(message, newPayloadGetter(message))
+}
+
+// Creates a function that parses the JSON payload of a message on the first call,
+// so several routes and conditions share a single parsing
+func newPayloadGetter(message *cqueues.MessageEnvelope) func() any {
+	var payload any
+	payloadParsed := false
+	return func() any {
+		if !payloadParsed {
+			payloadParsed = true
+			if json.Unmarshal(message.Message, &payload) != nil {
+				payload = nil
+			}
+		}
+		return payload
+	}
+}
+
+// Checks if a message matches the route expression using the shared parsed payload
+func (c *KafkaMessageRoute) matchPayload(message *cqueues.MessageEnvelope, getPayload func() any) bool {
+	for _, alternative := range c.conditions {
+		matched := true
+		for _, condition := range alternative {
+			if !condition.match(message, getPayload) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *routeCondition) match(message *cqueues.MessageEnvelope, payload func() any) bool {
+	left := resolveRouteOperand(c.left, message, payload)
+	switch c.operator {
+	case "==":
+		return left == resolveRouteOperand(c.right, message, payload)
+	case "!=":
+		return left != resolveRouteOperand(c.right, message, payload)
+	default:
+		return left != ""
+	}
+}
+
+func resolveRouteOperand(operand string, message *cqueues.MessageEnvelope, payload func() any) string {
+	switch {
+	case len(operand) >= 2 && (operand[0] == '"' || operand[0] == '\''):
+		return operand[1 : len(operand)-1]
+	case operand == "type":
+		return message.MessageType
+	case operand == "id":
+		return message.MessageId
+	case operand == "correlation_id":
+		return message.CorrelationId
+	case strings.HasPrefix(operand, "header."):
+		return GetMessageHeader(message, operand[len("header."):])
+	case operand == "$" || strings.HasPrefix(operand, "$."):
+		return resolveJsonPath(payload(), strings.TrimPrefix(strings.TrimPrefix(operand, "$"), "."))
+	default:
+		return operand
+	}
+}
+
+// Gets a value from JSON payload of a message by a path like $.order.id or order.id
+func getPayloadValue(message *cqueues.MessageEnvelope, path string) string {
+	return resolveJsonPath(newPayloadGetter(message)(), strings.TrimPrefix(strings.TrimPrefix(path, "$"), "."))
+}
+
+func resolveJsonPath(value any, path string) string {
+	if path != "" {
+		for _, key := range strings.Split(path, ".") {
+			switch v := value.(type) {
+			case map[string]any:
+				value = v[key]
+			case []any:
+				index, err := strconv.Atoi(key)
+				if err != nil || index < 0 || index >= len(v) {
+					return ""
+				}
+				value = v[index]
+			default:
+				return ""
+			}
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]any, []any:
+		buffer, _ := json.Marshal(v)
+		return string(buffer)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func parseRouteExpression(expression string) ([][]*routeCondition, error) {
+	tokens, err := tokenizeRouteExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, cerr.NewConfigError("", "INVALID_ROUTE", "Route expression is empty")
+	}
+
+	result := [][]*routeCondition{}
+	alternative := []*routeCondition{}
+	for i := 0; i < len(tokens); {
+		if isRouteOperator(tokens[i]) {
+			return nil, newRouteError(expression)
+		}
+		condition := &routeCondition{left: tokens[i]}
+		i++
+
+		if i < len(tokens) && (tokens[i] == "==" || tokens[i] == "!=") {
+			if i+1 >= len(tokens) || isRouteOperator(tokens[i+1]) {
+				return nil, newRouteError(expression)
+			}
+			condition.operator = tokens[i]
+			condition.right = tokens[i+1]
+			i += 2
+		}
+		alternative = append(alternative, condition)
+
+		if i == len(tokens) {
+			break
+		}
+		switch tokens[i] {
+		case "&&":
+		case "||":
+			result = append(result, alternative)
+			alternative = []*routeCondition{}
+		default:
+			return nil, newRouteError(expression)
+		}
+		i++
+		if i == len(tokens) {
+			return nil, newRouteError(expression)
+		}
+	}
+	result = append(result, alternative)
+
+	return result, nil
+}
+
+func tokenizeRouteExpression(expression string) ([]string, error) {
+	tokens := []string{}
+	for i := 0; i < len(expression); {
+		ch := expression[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch == '"' || ch == '\'':
+			end := strings.IndexByte(expression[i+1:], ch)
+			if end < 0 {
+				return nil, newRouteError(expression)
+			}
+			tokens = append(tokens, expression[i:i+end+2])
+			i += end + 2
+		case strings.HasPrefix(expression[i:], "=="), strings.HasPrefix(expression[i:], "!="),
+			strings.HasPrefix(expression[i:], "&&"), strings.HasPrefix(expression[i:], "||"):
+			tokens = append(tokens, expression[i:i+2])
+			i += 2
+		default:
+			start := i
+			for i < len(expression) && !strings.ContainsRune(" \t\r\n=!&|\"'", rune(expression[i])) {
+				i++
+			}
+			if start == i {
+				return nil, newRouteError(expression)
+			}
+			tokens = append(tokens, expression[start:i])
+		}
+	}
+	return tokens, nil
+}
+
+func isRouteOperator(token string) bool {
+	return token == "==" || token == "!=" || token == "&&" || token == "||"
+}
+
+func newRouteError(expression string) error {
+	return cerr.NewConfigError("", "INVALID_ROUTE", "Invalid route expression: "+expression).
+		WithDetails("expression", expression)
+}
@@ -0,0 +1,184 @@
+package queues
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+// Directions of tapped messages
+const (
+	// Messages sent by the queue
+	TapSent = "sent"
+	// Messages received by the queue
+	TapReceived = "received"
+)
+
+// Headers added to copies of messages published to the tap topic
+const (
+	// Direction of the tapped message: "sent" or "received"
+	TapDirectionHeader = "tap_direction"
+	// Topic of the tapped message
+	TapSourceTopicHeader = "tap_source_topic"
+)
+
+//	KafkaMessageTap copies a sample of messages sent or received by KafkaMessageQueue
+//	to a debug topic or a callback, so live traffic can be inspected without
+//	attaching new consumers to production groups.
+//	The sample is selected by a rate and an optional predicate.
+//	Copies are published with original keys, values and headers plus the tap headers.
+//	Failures to publish copies are logged and do not affect the tapped messages.
+//
+//	The queue adds the tap when options.tap is set: as the last send interceptor to copy
+//	final sent messages and as the first receive interceptor to copy messages as they were received.
+//	The tap can also be created in code and added by AddSendInterceptor and AddReceiveInterceptor.
+//
+//	Example:
+//		tap := NewKafkaMessageTap(TapReceived)
+//		tap.Rate = 0.01
+//		tap.Predicate = func(direction string, message *cqueues.MessageEnvelope) bool {
+//			return message.MessageType == "order_created"
+//		}
+//		tap.Callback = func(ctx context.Context, direction string, message *cqueues.MessageEnvelope) {
+//			fmt.Println(message.String())
+//		}
+//		queue.AddReceiveInterceptor(tap)
+type KafkaMessageTap struct {
+	// Tapped directions: "sent" and/or "received".
+	Directions []string
+	// Fraction of tapped messages from 0 to 1.
+	Rate float64
+	// Optional predicate that selects tapped messages.
+	Predicate func(direction string, message *cqueues.MessageEnvelope) bool
+	// Optional callback that receives tapped messages.
+	Callback func(ctx context.Context, direction string, message *cqueues.MessageEnvelope)
+	// Debug topic to publish copies of tapped messages.
+	Topic string
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The Kafka connection to publish copies of tapped messages.
+	Connection connect.IKafkaConnection
+}
+
+//	Creates a new instance of the message tap that copies all messages of given directions.
+//	Parameters:
+//		- directions ...string	tapped directions: "sent" and/or "received"
+//	Returns: *KafkaMessageTap
+func NewKafkaMessageTap(directions ...string) *KafkaMessageTap {
+	return &KafkaMessageTap{
+		Directions: directions,
+		Rate:       1,
+		Logger:     clog.NewCompositeLogger(),
+	}
+}
+
+//	Sends the message and copies it when it is sampled.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- envelope	a message envelope
+//		- msg	the Kafka message created from the envelope
+//		- next	the next handler in the chain
+//	Returns: the error of sending or nil for success.
+func (c *KafkaMessageTap) InterceptSend(ctx context.Context, correlationId string, envelope *cqueues.MessageEnvelope,
+	msg *kafka.ProducerMessage, next SendHandler) error {
+
+	err := next(ctx, correlationId, envelope, msg)
+	if err != nil || !c.sample(TapSent, envelope) {
+		return err
+	}
+
+	if c.Callback != nil {
+		c.Callback(ctx, TapSent, envelope)
+	}
+
+	if c.Topic != "" && c.Connection != nil {
+		tapped := &kafka.ProducerMessage{
+			Key:       msg.Key,
+			Value:     msg.Value,
+			Headers:   c.tapHeaders(msg.Headers, TapSent, msg.Topic),
+			Timestamp: time.Now(),
+		}
+		c.publishCopy(ctx, envelope, tapped)
+	}
+	return nil
+}
+
+//	Copies the received message when it is sampled and passes it to the next handler.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message	a received message envelope
+//		- next	the next handler in the chain
+//	Returns: the error of the next handler or nil for success.
+func (c *KafkaMessageTap) InterceptReceive(ctx context.Context, message *cqueues.MessageEnvelope, next ReceiveHandler) error {
+	if !c.sample(TapReceived, message) {
+		return next(ctx, message)
+	}
+
+	if c.Callback != nil {
+		c.Callback(ctx, TapReceived, message)
+	}
+
+	if c.Topic != "" && c.Connection != nil {
+		tapped := &kafka.ProducerMessage{
+			Value:     kafka.ByteEncoder(message.Message),
+			Timestamp: time.Now(),
+		}
+		if msg, ok := message.GetReference().(*connect.KafkaMessage); ok && msg != nil && msg.Message != nil {
+			if msg.Message.Key != nil {
+				tapped.Key = kafka.ByteEncoder(msg.Message.Key)
+			}
+			tapped.Value = kafka.ByteEncoder(msg.Message.Value)
+			headers := make([]kafka.RecordHeader, 0, len(msg.Message.Headers))
+			for _, header := range msg.Message.Headers {
+				headers = append(headers, *header)
+			}
+			tapped.Headers = c.tapHeaders(headers, TapReceived, msg.Message.Topic)
+		} else {
+			tapped.Headers = c.tapHeaders(nil, TapReceived, "")
+		}
+		c.publishCopy(ctx, message, tapped)
+	}
+
+	return next(ctx, message)
+}
+
+// Checks if the message of the direction is selected into the sample
+func (c *KafkaMessageTap) sample(direction string, message *cqueues.MessageEnvelope) bool {
+	tapped := false
+	for _, item := range c.Directions {
+		tapped = tapped || item == direction
+	}
+	if !tapped || c.Rate <= 0 {
+		return false
+	}
+	if c.Rate < 1 && rand.Float64() >= c.Rate {
+		return false
+	}
+	return c.Predicate == nil || c.Predicate(direction, message)
+}
+
+// Adds tap headers to headers of the original message
+func (c *KafkaMessageTap) tapHeaders(headers []kafka.RecordHeader, direction string, topic string) []kafka.RecordHeader {
+	result := make([]kafka.RecordHeader, 0, len(headers)+2)
+	result = append(result, headers...)
+	result = append(result,
+		kafka.RecordHeader{Key: []byte(TapDirectionHeader), Value: []byte(direction)},
+		kafka.RecordHeader{Key: []byte(TapSourceTopicHeader), Value: []byte(topic)},
+	)
+	return result
+}
+
+// Publishes a copy of a tapped message to the tap topic
+func (c *KafkaMessageTap) publishCopy(ctx context.Context, message *cqueues.MessageEnvelope, tapped *kafka.ProducerMessage) {
+	err := c.Connection.Publish(ctx, c.Topic, []*kafka.ProducerMessage{tapped})
+	if err != nil {
+		c.Logger.Error(ctx, message.CorrelationId, err, "Failed to copy message %s to tap topic %s",
+			message.MessageId, c.Topic)
+	}
+}
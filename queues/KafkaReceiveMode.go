@@ -0,0 +1,9 @@
+package queues
+
+// Modes of passing messages to several receivers of KafkaMessageQueue
+const (
+	// Each message is passed to the next receiver in turn
+	ReceiveRoundRobin = "round_robin"
+	// Each message is passed to all receivers
+	ReceiveBroadcast = "broadcast"
+)
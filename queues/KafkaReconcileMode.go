@@ -0,0 +1,13 @@
+package queues
+
+// Reconcile modes of KafkaMessageQueue topics on open
+const (
+	// Differences between the live topic and its declared configuration are ignored
+	ReconcileNone = "none"
+	// Differences are logged as warnings
+	ReconcileWarn = "warn"
+	// Open fails when the topic differs from its declared configuration
+	ReconcileFail = "fail"
+	// The topic is altered to match its declared configuration where Kafka allows it
+	ReconcileAlter = "alter"
+)
@@ -0,0 +1,18 @@
+package queues
+
+import (
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+// ShadowHeader is the Kafka header that marks copies of messages sent to the shadow topic
+const ShadowHeader = "shadow"
+
+//	IsShadowMessage checks if a received message is a shadow copy.
+//	Consumers tested against shadow traffic can use it to suppress side effects,
+//	like sending emails or charging payments.
+//	Parameters:
+//		- message *cqueues.MessageEnvelope	a received message
+//	Returns: true if the message was mirrored to the shadow topic and false otherwise.
+func IsShadowMessage(message *cqueues.MessageEnvelope) bool {
+	return GetMessageHeader(message, ShadowHeader) == "true"
+}
@@ -0,0 +1,43 @@
+package queues
+
+import (
+	"context"
+)
+
+// Tenancy modes of KafkaMessageQueue
+const (
+	// Tenants are not separated
+	TenancyNone = "none"
+	// Every tenant has a separate topic named <topic>.<tenant>
+	TenancyTopic = "topic"
+	// Tenants share the topic and are distinguished by the tenant header
+	TenancyHeader = "header"
+)
+
+// TenantHeader is the Kafka header that carries the tenant id
+const TenantHeader = "tenant_id"
+
+type tenantContextKey struct{}
+
+//	WithTenant returns a copy of the context that carries a tenant id.
+//	Messages sent with the context are routed to the tenant
+//	and received messages are delivered with the context of their tenant.
+//	Parameters:
+//		- ctx context.Context	a parent context
+//		- tenantId string	a tenant id
+//	Returns: a context with the tenant id.
+func WithTenant(ctx context.Context, tenantId string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenantId)
+}
+
+//	GetTenant gets a tenant id from the context.
+//	Parameters:
+//		- ctx context.Context	a context
+//	Returns: the tenant id or empty string if it is not set.
+func GetTenant(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	tenantId, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenantId
+}
@@ -0,0 +1,143 @@
+package queues
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+// SchemaVersionHeader is the Kafka header that carries the schema version of a message payload
+const SchemaVersionHeader = "schema_version"
+
+// KafkaUpcaster converts a message payload from one schema version to the next one.
+type KafkaUpcaster func(ctx context.Context, message *cqueues.MessageEnvelope) error
+
+//	KafkaUpcasterRegistry keeps chains of upcasters by message types.
+//	Received messages are upcast step by step from the version in their schema_version header
+//	to the latest registered version, so receivers only handle the current payload format.
+//	Messages without the header are treated as version 1.
+//
+//	Example:
+//		queue.Upcasters.Register("order_created", 1, func(ctx context.Context, message *cqueues.MessageEnvelope) error {
+//			// Convert the v1 payload to v2
+//			return nil
+//		})
+//		queue.Upcasters.Register("order_created", 2, upcastOrderCreatedV2)
+//		// Sent order_created messages get version 3, received v1 and v2 messages are upcast to v3
+type KafkaUpcasterRegistry struct {
+	lock      sync.RWMutex
+	upcasters map[string]map[int]KafkaUpcaster
+	versions  map[string]int
+}
+
+//	Creates a new empty registry of upcasters.
+//	Returns: *KafkaUpcasterRegistry
+func NewKafkaUpcasterRegistry() *KafkaUpcasterRegistry {
+	return &KafkaUpcasterRegistry{
+		upcasters: make(map[string]map[int]KafkaUpcaster),
+		versions:  make(map[string]int),
+	}
+}
+
+//	Registers an upcaster from a schema version to the next one.
+//	Parameters:
+//		- messageType string	a message type
+//		- fromVersion int	a version converted by the upcaster to fromVersion + 1
+//		- upcaster KafkaUpcaster	a conversion function
+func (c *KafkaUpcasterRegistry) Register(messageType string, fromVersion int, upcaster KafkaUpcaster) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	upcasters, ok := c.upcasters[messageType]
+	if !ok {
+		upcasters = make(map[int]KafkaUpcaster)
+		c.upcasters[messageType] = upcasters
+	}
+	upcasters[fromVersion] = upcaster
+}
+
+//	Sets the schema version of sent messages explicitly.
+//	Parameters:
+//		- messageType string	a message type or "" for messages of all types without upcasters
+//		- version int	a schema version or 0 to send messages without the version header
+func (c *KafkaUpcasterRegistry) SetVersion(messageType string, version int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.versions[messageType] = version
+}
+
+//	Gets the schema version of sent messages: the version set for the message type,
+//	the latest version of registered upcasters or the version set for all types.
+//	Parameters:
+//		- messageType string	a message type
+//	Returns: the schema version or 0 when it is unknown.
+func (c *KafkaUpcasterRegistry) GetVersion(messageType string) int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if version, ok := c.versions[messageType]; ok {
+		return version
+	}
+
+	latest := 0
+	for fromVersion := range c.upcasters[messageType] {
+		if fromVersion+1 > latest {
+			latest = fromVersion + 1
+		}
+	}
+	if latest > 0 {
+		return latest
+	}
+
+	return c.versions[""]
+}
+
+//	Upcasts a message payload from a schema version through the chain of registered upcasters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message *cqueues.MessageEnvelope	a received message
+//		- version int	the schema version of the payload
+//	Returns: the schema version after upcasting or error when an upcaster failed.
+func (c *KafkaUpcasterRegistry) Upcast(ctx context.Context, message *cqueues.MessageEnvelope, version int) (int, error) {
+	c.lock.RLock()
+	upcasters := c.upcasters[message.MessageType]
+	c.lock.RUnlock()
+
+	for {
+		c.lock.RLock()
+		upcaster, ok := upcasters[version]
+		c.lock.RUnlock()
+		if !ok {
+			return version, nil
+		}
+
+		err := upcaster(ctx, message)
+		if err != nil {
+			return version, cerr.NewBadRequestError(message.CorrelationId, "UPCAST_FAILED",
+				"Failed to upcast message "+message.MessageId+" from version "+strconv.Itoa(version)).
+				WithDetails("message_type", message.MessageType).
+				WithCause(err)
+		}
+		version++
+	}
+}
+
+// Parses the schema version header of a received message
+func parseSchemaVersion(message *cqueues.MessageEnvelope) (int, error) {
+	value := GetMessageHeader(message, SchemaVersionHeader)
+	if value == "" {
+		return 1, nil
+	}
+
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return 0, cerr.NewBadRequestError(message.CorrelationId, "INVALID_SCHEMA_VERSION",
+			"Message "+message.MessageId+" has invalid schema version").
+			WithDetails("schema_version", value)
+	}
+	return version, nil
+}
@@ -0,0 +1,71 @@
+package queues
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+)
+
+// MemoryKafkaKeyStore keeps data encryption keys in memory.
+// Keys are lost on restart, so it is used in tests and by single short-lived processes.
+//
+//	See IKafkaKeyStore
+type MemoryKafkaKeyStore struct {
+	lock sync.Mutex
+	keys map[string][]byte
+}
+
+// Creates a new instance of the memory key store.
+func NewMemoryKafkaKeyStore() *MemoryKafkaKeyStore {
+	return &MemoryKafkaKeyStore{
+		keys: make(map[string][]byte),
+	}
+}
+
+//	Gets the key of a subject.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- subject string	a subject id
+//	Returns: the key or nil when the subject has no key.
+func (c *MemoryKafkaKeyStore) GetKey(ctx context.Context, correlationId string, subject string) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.keys[subject], nil
+}
+
+//	Gets the key of a subject and creates a new random 256-bit key when the subject has no key.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- subject string	a subject id
+//	Returns: the key or error.
+func (c *MemoryKafkaKeyStore) CreateKey(ctx context.Context, correlationId string, subject string) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if key, ok := c.keys[subject]; ok {
+		return key, nil
+	}
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	c.keys[subject] = key
+	return key, nil
+}
+
+//	Deletes the key of a subject.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- subject string	a subject id
+//	Returns: error or nil for success.
+func (c *MemoryKafkaKeyStore) DeleteKey(ctx context.Context, correlationId string, subject string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.keys, subject)
+	return nil
+}
@@ -0,0 +1,663 @@
+package queues
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+//	MemoryKafkaMessageQueue is a message queue that emulates Kafka semantics within the same process.
+//	It is used to run integration-style tests without a Kafka broker.
+//
+//	Queues with the same topic share partitioned logs. Messages are assigned to partitions
+//	by the hash of their ids (keys) like KafkaMessageQueue producers do and keep their order
+//	within a partition. Every consumer group receives all messages of the topic. Partitions
+//	are distributed between the opened queues of the same group and redistributed when
+//	queues join or leave the group, returning uncommitted messages to the group.
+//	Abandoned messages are redelivered with all following messages of their partition.
+//
+//	Configuration parameters:
+//
+//		- topic:                         name of the emulated topic (default: queue name)
+//		- group_id:                      (optional) consumer group id (default: default)
+//		- from_beginning:                (optional) restarts receiving messages from the beginning (default: false)
+//		- autocommit:                    (optional) turns on/off autocommit (default: true)
+//		- options:
+//			- num_partitions:       	(optional) number of partitions of the created topic (default: 1)
+//			- read_partitions:      	(optional) list of partition indexes to be read (default: all, set for example: "1;5;7")
+//			- write_partition:		(optional) partition index to write messages to (default: auto (-1))
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:counters:*:*:1.0           (optional)  ICounters components to pass collected measurements
+//
+//	See KafkaMessageQueue
+//
+//	Example:
+//		queue := NewMemoryKafkaMessageQueue("myqueue")
+//		queue.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"topic", "mytopic",
+//			"options.num_partitions", 3,
+//		))
+//
+//		_ = queue.Open(ctx, "123")
+//		_ = queue.Send(ctx, "123", NewMessageEnvelope("", "mymessage", "ABC"))
+//
+//		message, err := queue.Receive(ctx, "123", 10000*time.Milliseconds)
+//		if (message != nil) {
+//			...
+//			queue.Complete(ctx, message);
+//		}
+type MemoryKafkaMessageQueue struct {
+	*cqueues.MessageQueue
+
+	topic              string
+	groupId            string
+	fromBeginning      bool
+	autoCommit         bool
+	numPartitions      int
+	readablePartitions []int32
+	writePartition     int
+
+	opened     bool
+	log        *memoryKafkaTopic
+	group      *memoryKafkaGroup
+	cursor     int
+	receiver   cqueues.IMessageReceiver
+	listenStop chan struct{}
+}
+
+// Reference of received messages that points to their partition and offset
+type memoryKafkaRecord struct {
+	partition int32
+	offset    int64
+}
+
+//	NewMemoryKafkaMessageQueue creates a new instance of the in-memory Kafka message queue.
+//	Parameters:
+//		- name string	(optional) a queue name.
+//	Returns: *MemoryKafkaMessageQueue
+func NewMemoryKafkaMessageQueue(name string) *MemoryKafkaMessageQueue {
+	c := MemoryKafkaMessageQueue{
+		groupId:            "default",
+		autoCommit:         true,
+		numPartitions:      1,
+		readablePartitions: make([]int32, 0),
+		writePartition:     -1,
+	}
+	c.MessageQueue = cqueues.InheritMessageQueue(&c, name,
+		cqueues.NewMessagingCapabilities(true, true, true, true, true, false, false, false, true))
+	return &c
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *MemoryKafkaMessageQueue) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	c.MessageQueue.Configure(ctx, config)
+
+	c.topic = config.GetAsStringWithDefault("topic", c.topic)
+	c.groupId = config.GetAsStringWithDefault("group_id", c.groupId)
+	c.fromBeginning = config.GetAsBooleanWithDefault("from_beginning", c.fromBeginning)
+	c.autoCommit = config.GetAsBooleanWithDefault("autocommit", c.autoCommit)
+	c.numPartitions = config.GetAsIntegerWithDefault("options.num_partitions", c.numPartitions)
+	c.writePartition = config.GetAsIntegerWithDefault("options.write_partition", c.writePartition)
+
+	if partitions, ok := config.GetAsNullableString("options.read_partitions"); ok {
+		for _, strVal := range strings.Split(partitions, ";") {
+			val, err := strconv.Atoi(strVal)
+			if err != nil {
+				continue
+			}
+			c.readablePartitions = append(c.readablePartitions, int32(val))
+		}
+	}
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *MemoryKafkaMessageQueue) IsOpen() bool {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.opened
+}
+
+//	Opens the component, creates the emulated topic when it doesn't exist
+//	and joins the consumer group.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *MemoryKafkaMessageQueue) Open(ctx context.Context, correlationId string) error {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	if c.opened {
+		return nil
+	}
+
+	c.log = memoryKafkaTopics.getOrCreate(c.getTopic(), c.numPartitions)
+	c.group = c.log.join(c.groupId, c, c.fromBeginning)
+	c.opened = true
+	return nil
+}
+
+//	Closes component and leaves the consumer group. Uncommitted messages
+//	are returned to the remaining members of the group.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *MemoryKafkaMessageQueue) Close(ctx context.Context, correlationId string) error {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	if !c.opened {
+		return nil
+	}
+
+	c.log.leave(c.group, c)
+	c.opened = false
+	c.stopListening()
+	return nil
+}
+
+func (c *MemoryKafkaMessageQueue) getTopic() string {
+	if c.topic != "" {
+		return c.topic
+	}
+	return c.Name()
+}
+
+// Checks if the queue reads a partition
+func (c *MemoryKafkaMessageQueue) readsPartition(partition int32) bool {
+	if len(c.readablePartitions) == 0 {
+		return true
+	}
+	for _, p := range c.readablePartitions {
+		if p == partition {
+			return true
+		}
+	}
+	return false
+}
+
+//	Capabilities method are gets capabilities of the queue with its current configuration.
+//	Messages can be abandoned only when offsets are committed manually.
+//	Returns: the queue's capabilities object.
+func (c *MemoryKafkaMessageQueue) Capabilities() *cqueues.MessagingCapabilities {
+	return cqueues.NewMessagingCapabilities(true, true, true, true, true, false, !c.autoCommit, false, true)
+}
+
+//	Clear method are skips all messages that are not yet consumed by the consumer group.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	string (optional) transaction id to trace execution through call chain.
+//	Returns error or nil no errors occured.
+func (c *MemoryKafkaMessageQueue) Clear(ctx context.Context, correlationId string) error {
+	err := c.CheckOpen(correlationId)
+	if err != nil {
+		return err
+	}
+
+	c.log.lock.Lock()
+	defer c.log.lock.Unlock()
+
+	for partition, messages := range c.log.partitions {
+		if c.readsPartition(int32(partition)) {
+			c.group.position[partition] = int64(len(messages))
+			c.group.committed[partition] = int64(len(messages))
+		}
+	}
+	return nil
+}
+
+//	ReadMessageCount method are reads the lag of the consumer group
+//	summed across the read partitions.
+//	Returns number of messages or error.
+func (c *MemoryKafkaMessageQueue) ReadMessageCount() (int64, error) {
+	err := c.CheckOpen("")
+	if err != nil {
+		return 0, err
+	}
+
+	c.log.lock.Lock()
+	defer c.log.lock.Unlock()
+
+	count := int64(0)
+	for partition, messages := range c.log.partitions {
+		if c.readsPartition(int32(partition)) {
+			count += int64(len(messages)) - c.group.committed[partition]
+		}
+	}
+	return count, nil
+}
+
+//	Send method are appends a message to a partition of the topic.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- envelope *cqueues.MessageEnvelope  a message envelop to be sent.
+//	Returns: error or nil for success.
+func (c *MemoryKafkaMessageQueue) Send(ctx context.Context, correlationId string, envelope *cqueues.MessageEnvelope) error {
+	err := c.CheckOpen(correlationId)
+	if err != nil {
+		return err
+	}
+
+	c.Counters.IncrementOne(ctx, "queue."+c.Name()+".sent_messages")
+	c.Logger.Debug(ctx, envelope.CorrelationId, "Sent message %s via %s", envelope.String(), c.Name())
+
+	message := *envelope
+	if message.SentTime.IsZero() {
+		message.SentTime = time.Now()
+	}
+	message.SetReference(nil)
+	c.log.append(&message, c.writePartition)
+
+	return nil
+}
+
+//	Peek method are peeks the next message of the consumer group without removing it.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//	Returns: a message or nil when there are no messages.
+func (c *MemoryKafkaMessageQueue) Peek(ctx context.Context, correlationId string) (*cqueues.MessageEnvelope, error) {
+	messages, err := c.PeekBatch(ctx, correlationId, 1)
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+	return messages[0], nil
+}
+
+//	PeekBatch method are peeks multiple next messages of the consumer group without removing them.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- messageCount int64	a maximum number of messages to peek.
+//	Returns: a list with messages.
+func (c *MemoryKafkaMessageQueue) PeekBatch(ctx context.Context, correlationId string, messageCount int64) ([]*cqueues.MessageEnvelope, error) {
+	err := c.CheckOpen(correlationId)
+	if err != nil {
+		return nil, err
+	}
+
+	c.log.lock.Lock()
+	defer c.log.lock.Unlock()
+
+	result := make([]*cqueues.MessageEnvelope, 0)
+	for partition, messages := range c.log.partitions {
+		if !c.log.isAssigned(c.group, c, int32(partition)) {
+			continue
+		}
+		for offset := c.group.position[partition]; offset < int64(len(messages)); offset++ {
+			if int64(len(result)) >= messageCount {
+				return result, nil
+			}
+			message := *messages[offset]
+			result = append(result, &message)
+		}
+	}
+	return result, nil
+}
+
+//	Receive method are receives the next message assigned to the queue within its consumer group.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- waitTimeout time.Duration	a timeout in milliseconds to wait for a message to come.
+//	Returns: a message or nil when no message arrived within the timeout.
+func (c *MemoryKafkaMessageQueue) Receive(ctx context.Context, correlationId string, waitTimeout time.Duration) (*cqueues.MessageEnvelope, error) {
+	err := c.CheckOpen(correlationId)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(waitTimeout)
+	defer timer.Stop()
+
+	message, err := c.receive(ctx, timer.C, nil)
+	if message != nil {
+		c.Counters.IncrementOne(ctx, "queue."+c.Name()+".received_messages")
+		c.Logger.Debug(ctx, message.CorrelationId, "Received message %s via %s", message.String(), c.Name())
+	}
+	return message, err
+}
+
+// Waits for the next assigned message until timeout, stop or context cancellation
+func (c *MemoryKafkaMessageQueue) receive(ctx context.Context, timeout <-chan time.Time,
+	stop chan struct{}) (*cqueues.MessageEnvelope, error) {
+
+	for {
+		message, signal := c.log.next(c.group, c)
+		if message != nil {
+			return message, nil
+		}
+
+		select {
+		case <-signal:
+		case <-timeout:
+			return nil, nil
+		case <-stop:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+//	Renews a lock on a message. Kafka doesn't lock messages, so this method does nothing.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message *cqueues.MessageEnvelope	a message to extend its lock.
+//		- lockTimeout time.Duration	a locking timeout in milliseconds.
+//	Returns: error or nil for success.
+func (c *MemoryKafkaMessageQueue) RenewLock(ctx context.Context, message *cqueues.MessageEnvelope, lockTimeout time.Duration) error {
+	return nil
+}
+
+//	Complete method are commits the message offset when autocommit is off.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message *cqueues.MessageEnvelope	a message to remove.
+//	Returns: error or nil for success.
+func (c *MemoryKafkaMessageQueue) Complete(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	err := c.CheckOpen("")
+	if err != nil {
+		return err
+	}
+
+	record, ok := message.GetReference().(*memoryKafkaRecord)
+	if c.autoCommit || !ok || record == nil {
+		return nil
+	}
+
+	c.log.commit(c.group, record.partition, record.offset+1)
+	message.SetReference(nil)
+	return nil
+}
+
+//	Abandon method are seeks the partition back to the message so it is received again
+//	together with all following messages of the partition. Ignored when autocommit is on.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message *cqueues.MessageEnvelope	a message to return.
+//	Returns: error or nil for success.
+func (c *MemoryKafkaMessageQueue) Abandon(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	err := c.CheckOpen("")
+	if err != nil {
+		return err
+	}
+
+	record, ok := message.GetReference().(*memoryKafkaRecord)
+	if c.autoCommit || !ok || record == nil {
+		return nil
+	}
+
+	c.log.seek(c.group, record.partition, record.offset)
+	message.SetReference(nil)
+	return nil
+}
+
+//	Permanently removes a message from the queue. Dead letter queues are not supported by Kafka.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message *cqueues.MessageEnvelope	a message to be removed.
+//	Returns: error or nil for success.
+func (c *MemoryKafkaMessageQueue) MoveToDeadLetter(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	return nil
+}
+
+//	Listens for incoming messages and blocks the current thread until queue is closed,
+//	listening is ended or the context is canceled.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId   string  (optional) transaction id to trace execution through call chain.
+//		- receiver    cqueues.IMessageReceiver      a receiver to receive incoming messages.
+func (c *MemoryKafkaMessageQueue) Listen(ctx context.Context, correlationId string, receiver cqueues.IMessageReceiver) error {
+	err := c.CheckOpen(correlationId)
+	if err != nil {
+		return err
+	}
+
+	c.Lock.Lock()
+	c.stopListening()
+	c.receiver = receiver
+	stop := make(chan struct{})
+	c.listenStop = stop
+	c.Lock.Unlock()
+
+	for {
+		message, err := c.receive(ctx, nil, stop)
+		if message == nil || err != nil {
+			break
+		}
+
+		c.Counters.IncrementOne(ctx, "queue."+c.Name()+".received_messages")
+		err = receiver.ReceiveMessage(ctx, message, c)
+		if err != nil {
+			c.Logger.Error(ctx, message.CorrelationId, err, "Failed to process the message")
+		}
+	}
+
+	c.Lock.Lock()
+	if c.listenStop == stop {
+		c.stopListening()
+	}
+	c.Lock.Unlock()
+	return nil
+}
+
+//	EndListen method are ends listening for incoming messages.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId  string   (optional) transaction id to trace execution through call chain.
+func (c *MemoryKafkaMessageQueue) EndListen(ctx context.Context, correlationId string) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.stopListening()
+}
+
+// Clears the receiver and unblocks Listen. Must be called under the lock.
+func (c *MemoryKafkaMessageQueue) stopListening() {
+	c.receiver = nil
+	if c.listenStop != nil {
+		close(c.listenStop)
+		c.listenStop = nil
+	}
+}
+
+// Registry of emulated topics shared by all queues of the process
+type memoryKafkaRegistry struct {
+	lock   sync.Mutex
+	topics map[string]*memoryKafkaTopic
+}
+
+var memoryKafkaTopics = &memoryKafkaRegistry{
+	topics: make(map[string]*memoryKafkaTopic),
+}
+
+func (r *memoryKafkaRegistry) getOrCreate(name string, numPartitions int) *memoryKafkaTopic {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	topic, ok := r.topics[name]
+	if !ok {
+		if numPartitions < 1 {
+			numPartitions = 1
+		}
+		topic = &memoryKafkaTopic{
+			partitions: make([][]*cqueues.MessageEnvelope, numPartitions),
+			groups:     make(map[string]*memoryKafkaGroup),
+			signal:     make(chan struct{}),
+		}
+		r.topics[name] = topic
+	}
+	return topic
+}
+
+// Emulated topic with partitioned logs and consumer group offsets
+type memoryKafkaTopic struct {
+	lock       sync.Mutex
+	partitions [][]*cqueues.MessageEnvelope
+	groups     map[string]*memoryKafkaGroup
+	counter    int
+	signal     chan struct{}
+}
+
+// Emulated consumer group with its members and offsets
+type memoryKafkaGroup struct {
+	members   []*MemoryKafkaMessageQueue
+	committed []int64
+	position  []int64
+}
+
+func (t *memoryKafkaTopic) join(groupId string, member *MemoryKafkaMessageQueue, fromBeginning bool) *memoryKafkaGroup {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	group, ok := t.groups[groupId]
+	if !ok {
+		group = &memoryKafkaGroup{
+			committed: make([]int64, len(t.partitions)),
+			position:  make([]int64, len(t.partitions)),
+		}
+		// New groups start from the latest offsets unless they read from the beginning
+		if !fromBeginning {
+			for partition, messages := range t.partitions {
+				group.committed[partition] = int64(len(messages))
+				group.position[partition] = int64(len(messages))
+			}
+		}
+		t.groups[groupId] = group
+	}
+
+	group.members = append(group.members, member)
+	t.rebalance(group)
+	return group
+}
+
+func (t *memoryKafkaTopic) leave(group *memoryKafkaGroup, member *MemoryKafkaMessageQueue) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for i, m := range group.members {
+		if m == member {
+			group.members = append(group.members[:i], group.members[i+1:]...)
+			break
+		}
+	}
+	t.rebalance(group)
+}
+
+// Returns uncommitted messages to the group after its membership changed. Must be called under the lock.
+func (t *memoryKafkaTopic) rebalance(group *memoryKafkaGroup) {
+	copy(group.position, group.committed)
+	t.notify()
+}
+
+// Checks if a partition is assigned to a group member. Must be called under the lock.
+func (t *memoryKafkaTopic) isAssigned(group *memoryKafkaGroup, member *MemoryKafkaMessageQueue, partition int32) bool {
+	candidates := make([]*MemoryKafkaMessageQueue, 0, len(group.members))
+	for _, m := range group.members {
+		if m.readsPartition(partition) {
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) == 0 {
+		return false
+	}
+	return candidates[int(partition)%len(candidates)] == member
+}
+
+// Wakes up all waiting consumers. Must be called under the lock.
+func (t *memoryKafkaTopic) notify() {
+	close(t.signal)
+	t.signal = make(chan struct{})
+}
+
+func (t *memoryKafkaTopic) append(message *cqueues.MessageEnvelope, writePartition int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	partition := writePartition
+	if partition < 0 || partition >= len(t.partitions) {
+		partition = t.partition(message.MessageId)
+	}
+	t.partitions[partition] = append(t.partitions[partition], message)
+	t.notify()
+}
+
+// Chooses a partition by the message key like producers of KafkaConnection,
+// or round-robin for messages without keys. Must be called under the lock.
+func (t *memoryKafkaTopic) partition(key string) int {
+	if key == "" {
+		t.counter++
+		return t.counter % len(t.partitions)
+	}
+	return int(connect.KeyPartition(key, len(t.partitions)))
+}
+
+// Takes the next message assigned to a group member. When there is no message
+// it returns a channel that is closed on the next change of the topic.
+func (t *memoryKafkaTopic) next(group *memoryKafkaGroup, member *MemoryKafkaMessageQueue) (*cqueues.MessageEnvelope, chan struct{}) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	count := len(t.partitions)
+	for i := 0; i < count; i++ {
+		// Rotate partitions so that a busy partition doesn't starve the others
+		partition := (member.cursor + i) % count
+		if !t.isAssigned(group, member, int32(partition)) {
+			continue
+		}
+
+		offset := group.position[partition]
+		if offset >= int64(len(t.partitions[partition])) {
+			continue
+		}
+
+		group.position[partition] = offset + 1
+		if member.autoCommit {
+			group.committed[partition] = offset + 1
+		}
+		member.cursor = partition + 1
+
+		message := *t.partitions[partition][offset]
+		message.SetReference(&memoryKafkaRecord{
+			partition: int32(partition),
+			offset:    offset,
+		})
+		return &message, nil
+	}
+
+	return nil, t.signal
+}
+
+func (t *memoryKafkaTopic) commit(group *memoryKafkaGroup, partition int32, offset int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if int(partition) < len(group.committed) && group.committed[partition] < offset {
+		group.committed[partition] = offset
+	}
+}
+
+func (t *memoryKafkaTopic) seek(group *memoryKafkaGroup, partition int32, offset int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if int(partition) < len(group.position) && group.position[partition] > offset {
+		group.position[partition] = offset
+		t.notify()
+	}
+}
@@ -0,0 +1,519 @@
+package queues
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+//	MockKafkaMessageQueue is a deterministic in-process implementation of IKafkaMessageQueue
+//	for unit tests of services that use Kafka queues.
+//
+//	Sent messages are delivered in order. While the queue is listening and not paused
+//	they are dispatched synchronously within Send, so tests don't need to wait for delivery.
+//	Otherwise they are kept until they are received or listening starts.
+//	Abandoned messages are returned to the head of the queue and redelivered
+//	on the next Send, Resume or Listen. All sent, completed and abandoned messages
+//	are recorded to be checked by tests.
+//
+//	Example:
+//		queue := NewMockKafkaMessageQueue("myqueue")
+//		_ = queue.Open(ctx, "123")
+//
+//		service.SetQueue(queue)
+//		...
+//		assert.Len(t, queue.SentMessages(), 1)
+type MockKafkaMessageQueue struct {
+	*cqueues.MessageQueue
+
+	opened          bool
+	messages        []*cqueues.MessageEnvelope
+	sent            []*cqueues.MessageEnvelope
+	completed       []*cqueues.MessageEnvelope
+	abandoned       []*cqueues.MessageEnvelope
+	receiver        cqueues.IMessageReceiver
+	listenStop      chan struct{}
+	messageSignal   chan struct{}
+	handlers        map[string]cqueues.IMessageReceiver
+	defaultHandler  cqueues.IMessageReceiver
+	filterPredicate func(message *cqueues.MessageEnvelope) bool
+	paused          bool
+	pauseTimer      *time.Timer
+}
+
+//	NewMockKafkaMessageQueue creates a new instance of the mock queue.
+//	Parameters:
+//		- name string	(optional) a queue name.
+//	Returns: *MockKafkaMessageQueue
+func NewMockKafkaMessageQueue(name string) *MockKafkaMessageQueue {
+	c := MockKafkaMessageQueue{
+		messages:      make([]*cqueues.MessageEnvelope, 0),
+		sent:          make([]*cqueues.MessageEnvelope, 0),
+		completed:     make([]*cqueues.MessageEnvelope, 0),
+		abandoned:     make([]*cqueues.MessageEnvelope, 0),
+		messageSignal: make(chan struct{}, 1),
+		handlers:      make(map[string]cqueues.IMessageReceiver),
+	}
+	c.MessageQueue = cqueues.InheritMessageQueue(&c, name,
+		cqueues.NewMessagingCapabilities(true, true, true, true, true, false, true, false, true))
+	return &c
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *MockKafkaMessageQueue) IsOpen() bool {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.opened
+}
+
+//	Opens the component.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *MockKafkaMessageQueue) Open(ctx context.Context, correlationId string) error {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.opened = true
+	return nil
+}
+
+//	Closes component and frees used resources.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *MockKafkaMessageQueue) Close(ctx context.Context, correlationId string) error {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.opened = false
+	c.stopListening()
+	c.clearPause()
+	return nil
+}
+
+//	Clear method are clears collected messages and recorded history.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	string (optional) transaction id to trace execution through call chain.
+//	Returns error or nil no errors occured.
+func (c *MockKafkaMessageQueue) Clear(ctx context.Context, correlationId string) error {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	c.messages = make([]*cqueues.MessageEnvelope, 0)
+	c.sent = make([]*cqueues.MessageEnvelope, 0)
+	c.completed = make([]*cqueues.MessageEnvelope, 0)
+	c.abandoned = make([]*cqueues.MessageEnvelope, 0)
+	return nil
+}
+
+//	Gets all messages sent to the queue in the order they were sent.
+//	Returns: sent messages.
+func (c *MockKafkaMessageQueue) SentMessages() []*cqueues.MessageEnvelope {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return append([]*cqueues.MessageEnvelope{}, c.sent...)
+}
+
+//	Gets all completed messages in the order they were completed.
+//	Returns: completed messages.
+func (c *MockKafkaMessageQueue) CompletedMessages() []*cqueues.MessageEnvelope {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return append([]*cqueues.MessageEnvelope{}, c.completed...)
+}
+
+//	Gets all abandoned messages in the order they were abandoned.
+//	Returns: abandoned messages.
+func (c *MockKafkaMessageQueue) AbandonedMessages() []*cqueues.MessageEnvelope {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return append([]*cqueues.MessageEnvelope{}, c.abandoned...)
+}
+
+//	ReadMessageCount method are reads the current number of messages in the queue to be delivered.
+//	Returns number of messages or error.
+func (c *MockKafkaMessageQueue) ReadMessageCount() (int64, error) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return int64(len(c.messages)), nil
+}
+
+//	Send method are sends a message into the queue.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- envelope *cqueues.MessageEnvelope  a message envelop to be sent.
+//	Returns: error or nil for success.
+func (c *MockKafkaMessageQueue) Send(ctx context.Context, correlationId string, envelope *cqueues.MessageEnvelope) error {
+	err := c.CheckOpen(correlationId)
+	if err != nil {
+		return err
+	}
+
+	envelope.SentTime = time.Now()
+
+	c.Lock.Lock()
+	c.sent = append(c.sent, envelope)
+	filter := c.filterPredicate
+	c.Lock.Unlock()
+
+	c.Counters.IncrementOne(ctx, "queue."+c.Name()+".sent_messages")
+
+	if filter != nil && !filter(envelope) {
+		return nil
+	}
+
+	c.Lock.Lock()
+	c.messages = append(c.messages, envelope)
+	c.Lock.Unlock()
+	c.signalMessage()
+
+	c.deliverMessages(ctx)
+	return nil
+}
+
+//	Peek method are peeks a single incoming message from the queue without removing it.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//	Returns: a message or nil when the queue is empty.
+func (c *MockKafkaMessageQueue) Peek(ctx context.Context, correlationId string) (*cqueues.MessageEnvelope, error) {
+	err := c.CheckOpen(correlationId)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	if len(c.messages) == 0 {
+		return nil, nil
+	}
+	return c.messages[0], nil
+}
+
+//	PeekBatch method are peeks multiple incoming messages from the queue without removing them.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- messageCount int64	a maximum number of messages to peek.
+//	Returns: a list with messages.
+func (c *MockKafkaMessageQueue) PeekBatch(ctx context.Context, correlationId string, messageCount int64) ([]*cqueues.MessageEnvelope, error) {
+	err := c.CheckOpen(correlationId)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	count := int(messageCount)
+	if count > len(c.messages) {
+		count = len(c.messages)
+	}
+	return append([]*cqueues.MessageEnvelope{}, c.messages[:count]...), nil
+}
+
+//	Receive method are receives an incoming message and removes it from the queue.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- waitTimeout time.Duration	a timeout in milliseconds to wait for a message to come.
+//	Returns: a message or nil when no message arrived within the timeout.
+func (c *MockKafkaMessageQueue) Receive(ctx context.Context, correlationId string, waitTimeout time.Duration) (*cqueues.MessageEnvelope, error) {
+	err := c.CheckOpen(correlationId)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(waitTimeout)
+	defer timer.Stop()
+
+	for {
+		c.Lock.Lock()
+		if !c.paused && len(c.messages) > 0 {
+			message := c.messages[0]
+			c.messages = c.messages[1:]
+			c.Lock.Unlock()
+			c.Counters.IncrementOne(ctx, "queue."+c.Name()+".received_messages")
+			return message, nil
+		}
+		c.Lock.Unlock()
+
+		select {
+		case <-c.messageSignal:
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+//	Renews a lock on a message. Locks are not used by the mock.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message *cqueues.MessageEnvelope	a message to extend its lock.
+//		- lockTimeout time.Duration	a locking timeout in milliseconds.
+//	Returns: error or nil for success.
+func (c *MockKafkaMessageQueue) RenewLock(ctx context.Context, message *cqueues.MessageEnvelope, lockTimeout time.Duration) error {
+	return c.CheckOpen("")
+}
+
+//	Completes a message and records it as completed.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message *cqueues.MessageEnvelope	a message to remove.
+//	Returns: error or nil for success.
+func (c *MockKafkaMessageQueue) Complete(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	err := c.CheckOpen("")
+	if err != nil {
+		return err
+	}
+
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.completed = append(c.completed, message)
+	return nil
+}
+
+//	Returns a message to the head of the queue to be received again and records it as abandoned.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message *cqueues.MessageEnvelope	a message to return.
+//	Returns: error or nil for success.
+func (c *MockKafkaMessageQueue) Abandon(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	err := c.CheckOpen("")
+	if err != nil {
+		return err
+	}
+
+	c.Lock.Lock()
+	c.abandoned = append(c.abandoned, message)
+	c.messages = append([]*cqueues.MessageEnvelope{message}, c.messages...)
+	c.Lock.Unlock()
+	c.signalMessage()
+
+	return nil
+}
+
+//	Permanently removes a message from the queue. Dead letter queues are not supported by Kafka.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message *cqueues.MessageEnvelope	a message to be removed.
+//	Returns: error or nil for success.
+func (c *MockKafkaMessageQueue) MoveToDeadLetter(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	return c.CheckOpen("")
+}
+
+//	Registers a receiver for messages of a specific type.
+//	Parameters:
+//		- messageType string	a message type
+//		- receiver cqueues.IMessageReceiver	a receiver for the messages
+func (c *MockKafkaMessageQueue) RegisterHandler(messageType string, receiver cqueues.IMessageReceiver) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.handlers[messageType] = receiver
+}
+
+//	Unregisters a receiver of a specific message type.
+//	Parameters:
+//		- messageType string	a message type
+func (c *MockKafkaMessageQueue) UnregisterHandler(messageType string) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	delete(c.handlers, messageType)
+}
+
+//	Registers a fallback receiver for messages without type specific handlers.
+//	Parameters:
+//		- receiver cqueues.IMessageReceiver	a receiver for the messages, or nil to use the Listen receiver
+func (c *MockKafkaMessageQueue) RegisterDefaultHandler(receiver cqueues.IMessageReceiver) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.defaultHandler = receiver
+}
+
+//	Sets a predicate that accepts sent messages. Rejected messages are recorded as sent
+//	but never delivered.
+//	Parameters:
+//		- predicate func(message *cqueues.MessageEnvelope) bool	a predicate, or nil to accept all messages
+func (c *MockKafkaMessageQueue) SetFilter(predicate func(message *cqueues.MessageEnvelope) bool) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.filterPredicate = predicate
+}
+
+//	Pauses delivery of messages.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId  string   (optional) transaction id to trace execution through call chain.
+//		- timeout time.Duration	time to resume delivery after, or 0 to wait for explicit Resume
+//	Returns: error or nil for success.
+func (c *MockKafkaMessageQueue) Pause(ctx context.Context, correlationId string, timeout time.Duration) error {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	c.paused = true
+	if c.pauseTimer != nil {
+		c.pauseTimer.Stop()
+		c.pauseTimer = nil
+	}
+	if timeout > 0 {
+		c.pauseTimer = time.AfterFunc(timeout, func() {
+			c.Resume(context.Background(), correlationId)
+		})
+	}
+	return nil
+}
+
+//	Resumes delivery of messages and delivers the collected ones to the listening receiver.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId  string   (optional) transaction id to trace execution through call chain.
+//	Returns: error or nil for success.
+func (c *MockKafkaMessageQueue) Resume(ctx context.Context, correlationId string) error {
+	c.Lock.Lock()
+	c.clearPause()
+	c.Lock.Unlock()
+	c.signalMessage()
+
+	c.deliverMessages(ctx)
+	return nil
+}
+
+//	Checks if delivery of messages is paused.
+//	Returns: true if the queue is paused and false otherwise.
+func (c *MockKafkaMessageQueue) IsPaused() bool {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.paused
+}
+
+//	Listens for incoming messages and blocks the current thread until queue is closed,
+//	listening is ended or the context is canceled.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId   string  (optional) transaction id to trace execution through call chain.
+//		- receiver    cqueues.IMessageReceiver      a receiver to receive incoming messages.
+func (c *MockKafkaMessageQueue) Listen(ctx context.Context, correlationId string, receiver cqueues.IMessageReceiver) error {
+	err := c.CheckOpen(correlationId)
+	if err != nil {
+		return err
+	}
+
+	c.Lock.Lock()
+	c.stopListening()
+	c.receiver = receiver
+	stop := make(chan struct{})
+	c.listenStop = stop
+	c.Lock.Unlock()
+
+	c.deliverMessages(ctx)
+
+	select {
+	case <-stop:
+	case <-ctx.Done():
+		c.Lock.Lock()
+		if c.listenStop == stop {
+			c.stopListening()
+		}
+		c.Lock.Unlock()
+	}
+	return nil
+}
+
+//	EndListen method are ends listening for incoming messages.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId  string   (optional) transaction id to trace execution through call chain.
+func (c *MockKafkaMessageQueue) EndListen(ctx context.Context, correlationId string) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.stopListening()
+}
+
+// Dispatches collected messages while the queue is listening and not paused
+func (c *MockKafkaMessageQueue) deliverMessages(ctx context.Context) {
+	for {
+		c.Lock.Lock()
+		if c.listenStop == nil || c.paused || len(c.messages) == 0 {
+			c.Lock.Unlock()
+			return
+		}
+		message := c.messages[0]
+		c.messages = c.messages[1:]
+
+		receiver, ok := c.handlers[message.MessageType]
+		if !ok {
+			receiver = c.defaultHandler
+		}
+		if receiver == nil {
+			receiver = c.receiver
+		}
+		c.Lock.Unlock()
+
+		if receiver == nil {
+			c.Counters.IncrementOne(ctx, "queue."+c.Name()+".unhandled_messages")
+			continue
+		}
+
+		c.Counters.IncrementOne(ctx, "queue."+c.Name()+".received_messages")
+		err := c.sendMessageToReceiver(ctx, receiver, message)
+		if IsDownstreamUnavailableError(err) {
+			// Keep the message and pause like the Kafka queue does
+			c.Lock.Lock()
+			c.messages = append([]*cqueues.MessageEnvelope{message}, c.messages...)
+			c.paused = true
+			c.Lock.Unlock()
+			return
+		}
+		if err != nil {
+			c.Logger.Error(ctx, message.CorrelationId, err, "Failed to process the message")
+		}
+	}
+}
+
+func (c *MockKafkaMessageQueue) sendMessageToReceiver(ctx context.Context, receiver cqueues.IMessageReceiver,
+	message *cqueues.MessageEnvelope) (err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = cerr.NewUnknownError(message.CorrelationId, "PROCESSING_FAILED", fmt.Sprintf("%v", r))
+		}
+	}()
+
+	return receiver.ReceiveMessage(ctx, message, c)
+}
+
+// Wakes up a waiting receiver without blocking when nobody waits
+func (c *MockKafkaMessageQueue) signalMessage() {
+	select {
+	case c.messageSignal <- struct{}{}:
+	default:
+	}
+}
+
+// Clears the receiver and unblocks Listen. Must be called under the lock.
+func (c *MockKafkaMessageQueue) stopListening() {
+	c.receiver = nil
+	if c.listenStop != nil {
+		close(c.listenStop)
+		c.listenStop = nil
+	}
+}
+
+// Resets the pause state. Must be called under the lock.
+func (c *MockKafkaMessageQueue) clearPause() {
+	if c.pauseTimer != nil {
+		c.pauseTimer.Stop()
+		c.pauseTimer = nil
+	}
+	c.paused = false
+}
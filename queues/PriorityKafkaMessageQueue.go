@@ -0,0 +1,624 @@
+package queues
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+// Default priorities of PriorityKafkaMessageQueue lanes from the highest to the lowest
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+type priorityContextKey struct{}
+
+//	WithPriority returns a copy of the context that carries a message priority.
+//	Messages sent with the context to PriorityKafkaMessageQueue are routed to the lane of the priority.
+//	Parameters:
+//		- ctx context.Context	a parent context
+//		- priority string	a priority name, like "high"
+//	Returns: a context with the priority.
+func WithPriority(ctx context.Context, priority string) context.Context {
+	return context.WithValue(ctx, priorityContextKey{}, priority)
+}
+
+//	GetPriority gets a message priority from the context.
+//	Parameters:
+//		- ctx context.Context	a context
+//	Returns: the priority or empty string if it is not set.
+func GetPriority(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	priority, _ := ctx.Value(priorityContextKey{}).(string)
+	return priority
+}
+
+//	PriorityKafkaMessageQueue is a logical queue that maps to one physical topic per priority,
+//	named <topic>.<priority>. Send routes messages to lanes by the priority in the context
+//	or by their message types. Receive and Listen drain higher-priority lanes first,
+//	so low-priority messages are processed only when higher lanes are empty.
+//
+//	Lanes are KafkaMessageQueue instances configured with the same parameters as the logical queue.
+//
+//	Configuration parameters:
+//
+//		- topic:                         base name of lane topics (default: queue name)
+//		- group_id:                      (optional) consumer group id of all lanes (default: default)
+//		- options:
+//			- priorities:           	(optional) list of priorities from the highest to the lowest (default: "high;normal;low")
+//			- default_priority:     	(optional) priority of messages sent without a priority (default: normal or the middle lane)
+//			- priority_types:       	(optional) priorities of message types (default: none, set for example: "alert=high;report=low")
+//			- poll_interval:        	(optional) number of milliseconds between checks of empty lanes while receiving (default: 100)
+//		- other parameters of KafkaMessageQueue
+//
+//	References:
+//
+//		- references of KafkaMessageQueue are passed to all lanes
+//
+//	Example:
+//		queue := NewPriorityKafkaMessageQueue("orders")
+//		queue.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"topic", "orders",
+//			"connection.host", "localhost",
+//			"connection.port", 9092,
+//		))
+//
+//		_ = queue.Open(ctx, "123")
+//		_ = queue.Send(WithPriority(ctx, PriorityHigh), "123", NewMessageEnvelope("", "order", []byte("ABC")))
+//
+//		message, err := queue.Receive(ctx, "123", 10*time.Second)
+type PriorityKafkaMessageQueue struct {
+	*cqueues.MessageQueue
+
+	topic           string
+	priorities      []string
+	defaultPriority string
+	priorityTypes   map[string]string
+	pollInterval    time.Duration
+	configErr       error
+
+	lanes      map[string]cqueues.IMessageQueue
+	owners     map[*cqueues.MessageEnvelope]cqueues.IMessageQueue
+	ownersLock sync.Mutex
+	listenStop chan struct{}
+}
+
+//	NewPriorityKafkaMessageQueue creates a new instance of the priority queue.
+//	Parameters:
+//		- name string	(optional) a queue name.
+//	Returns: *PriorityKafkaMessageQueue
+func NewPriorityKafkaMessageQueue(name string) *PriorityKafkaMessageQueue {
+	c := PriorityKafkaMessageQueue{
+		priorities:    []string{PriorityHigh, PriorityNormal, PriorityLow},
+		priorityTypes: make(map[string]string),
+		pollInterval:  100 * time.Millisecond,
+		lanes:         make(map[string]cqueues.IMessageQueue),
+		owners:        make(map[*cqueues.MessageEnvelope]cqueues.IMessageQueue),
+	}
+	c.MessageQueue = cqueues.InheritMessageQueue(&c, name,
+		cqueues.NewMessagingCapabilities(true, true, true, true, true, false, false, false, true))
+	return &c
+}
+
+//	Sets a queue of a priority lane. Lanes that are not set are created as KafkaMessageQueue on configuration.
+//	Parameters:
+//		- priority string	a priority name
+//		- queue cqueues.IMessageQueue	a queue of the lane, like KafkaMessageQueue or MemoryKafkaMessageQueue
+func (c *PriorityKafkaMessageQueue) SetLane(priority string, queue cqueues.IMessageQueue) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.lanes[priority] = queue
+}
+
+//	Gets the queue of a priority lane.
+//	Parameters:
+//		- priority string	a priority name
+//	Returns: the lane queue or nil if the priority is not configured.
+func (c *PriorityKafkaMessageQueue) GetLane(priority string) cqueues.IMessageQueue {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.lanes[priority]
+}
+
+//	Configures the queue and its lanes.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *PriorityKafkaMessageQueue) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	c.MessageQueue.Configure(ctx, config)
+
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	c.topic = config.GetAsStringWithDefault("topic", c.topic)
+	c.pollInterval = time.Duration(config.GetAsIntegerWithDefault("options.poll_interval",
+		int(c.pollInterval.Milliseconds()))) * time.Millisecond
+	if priorities := splitOptionList(config.GetAsStringWithDefault("options.priorities", "")); len(priorities) > 0 {
+		c.priorities = priorities
+	}
+
+	c.defaultPriority = config.GetAsStringWithDefault("options.default_priority", c.defaultPriority)
+	if c.defaultPriority == "" {
+		c.defaultPriority = c.priorities[len(c.priorities)/2]
+		for _, priority := range c.priorities {
+			if priority == PriorityNormal {
+				c.defaultPriority = priority
+			}
+		}
+	}
+
+	c.configErr = nil
+	for _, pair := range splitOptionList(config.GetAsStringWithDefault("options.priority_types", "")) {
+		messageType, priority, _ := strings.Cut(pair, "=")
+		c.priorityTypes[strings.TrimSpace(messageType)] = strings.TrimSpace(priority)
+	}
+	for _, priority := range append([]string{c.defaultPriority}, mapValues(c.priorityTypes)...) {
+		if !c.hasPriority(priority) {
+			c.configErr = cerr.NewConfigError("", "UNKNOWN_PRIORITY",
+				"Priority "+priority+" is not in options.priorities").WithDetails("priority", priority)
+		}
+	}
+
+	// Lane options are removed, so lanes do not reject them
+	laneConfig := cconf.NewConfigParamsFromMaps(config.Value())
+	for _, option := range []string{"priorities", "default_priority", "priority_types", "poll_interval"} {
+		laneConfig.Remove("options." + option)
+	}
+
+	for _, priority := range c.priorities {
+		lane, ok := c.lanes[priority]
+		if !ok {
+			lane = NewKafkaMessageQueue(c.Name() + "." + priority)
+			c.lanes[priority] = lane
+		}
+		if configurable, ok := lane.(cconf.IConfigurable); ok {
+			laneConfig.SetAsObject("topic", c.getTopic()+"."+priority)
+			configurable.Configure(ctx, laneConfig)
+		}
+	}
+}
+
+//	Sets references to all lanes.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references	references to locate the component dependencies.
+func (c *PriorityKafkaMessageQueue) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.MessageQueue.SetReferences(ctx, references)
+	for _, lane := range c.getLanes() {
+		if referenceable, ok := lane.(cref.IReferenceable); ok {
+			referenceable.SetReferences(ctx, references)
+		}
+	}
+}
+
+func (c *PriorityKafkaMessageQueue) getTopic() string {
+	if c.topic != "" {
+		return c.topic
+	}
+	return c.Name()
+}
+
+// Checks if a priority is configured. Must be called under the lock.
+func (c *PriorityKafkaMessageQueue) hasPriority(priority string) bool {
+	for _, item := range c.priorities {
+		if item == priority {
+			return true
+		}
+	}
+	return false
+}
+
+// Gets lanes from the highest to the lowest priority
+func (c *PriorityKafkaMessageQueue) getLanes() []cqueues.IMessageQueue {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	lanes := make([]cqueues.IMessageQueue, 0, len(c.priorities))
+	for _, priority := range c.priorities {
+		if lane, ok := c.lanes[priority]; ok {
+			lanes = append(lanes, lane)
+		}
+	}
+	return lanes
+}
+
+//	Checks if all lanes are opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *PriorityKafkaMessageQueue) IsOpen() bool {
+	lanes := c.getLanes()
+	for _, lane := range lanes {
+		if !lane.IsOpen() {
+			return false
+		}
+	}
+	return len(lanes) > 0
+}
+
+//	Opens all lanes. Lanes opened before a failure are closed.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *PriorityKafkaMessageQueue) Open(ctx context.Context, correlationId string) error {
+	c.Lock.Lock()
+	configErr := c.configErr
+	c.Lock.Unlock()
+	if configErr != nil {
+		return configErr
+	}
+
+	lanes := c.getLanes()
+	if len(lanes) == 0 {
+		return cerr.NewConfigError(correlationId, "NO_LANES", "Priority queue is not configured")
+	}
+
+	for i, lane := range lanes {
+		err := lane.Open(ctx, correlationId)
+		if err != nil {
+			for _, opened := range lanes[:i] {
+				_ = opened.Close(ctx, correlationId)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+//	Closes all lanes.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: the first error of closing lanes or nil no errors occured.
+func (c *PriorityKafkaMessageQueue) Close(ctx context.Context, correlationId string) error {
+	c.Lock.Lock()
+	c.stopListening()
+	c.Lock.Unlock()
+
+	c.ownersLock.Lock()
+	c.owners = make(map[*cqueues.MessageEnvelope]cqueues.IMessageQueue)
+	c.ownersLock.Unlock()
+
+	var result error
+	for _, lane := range c.getLanes() {
+		err := lane.Close(ctx, correlationId)
+		if err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+//	Gets capabilities supported by all lanes.
+//	Returns: the queue's capabilities object.
+func (c *PriorityKafkaMessageQueue) Capabilities() *cqueues.MessagingCapabilities {
+	lanes := c.getLanes()
+	if len(lanes) == 0 {
+		return c.MessageQueue.Capabilities()
+	}
+
+	all := lanes[0].Capabilities()
+	for _, lane := range lanes[1:] {
+		other := lane.Capabilities()
+		all = cqueues.NewMessagingCapabilities(
+			all.CanMessageCount() && other.CanMessageCount(),
+			all.CanSend() && other.CanSend(),
+			all.CanReceive() && other.CanReceive(),
+			all.CanPeek() && other.CanPeek(),
+			all.CanPeekBatch() && other.CanPeekBatch(),
+			all.CanRenewLock() && other.CanRenewLock(),
+			all.CanAbandon() && other.CanAbandon(),
+			all.CanDeadLetter() && other.CanDeadLetter(),
+			all.CanClear() && other.CanClear(),
+		)
+	}
+	return all
+}
+
+//	Clears all lanes.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	string (optional) transaction id to trace execution through call chain.
+//	Returns error or nil no errors occured.
+func (c *PriorityKafkaMessageQueue) Clear(ctx context.Context, correlationId string) error {
+	for _, lane := range c.getLanes() {
+		if clearable, ok := lane.(interface {
+			Clear(ctx context.Context, correlationId string) error
+		}); ok {
+			err := clearable.Clear(ctx, correlationId)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//	Reads the total number of messages in all lanes.
+//	Returns: number of messages or error.
+func (c *PriorityKafkaMessageQueue) ReadMessageCount() (int64, error) {
+	total := int64(0)
+	for _, lane := range c.getLanes() {
+		count, err := lane.ReadMessageCount()
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+//	Gets the priority of a sent message: the priority in the context,
+//	the priority of its message type or the default priority.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message *cqueues.MessageEnvelope	a message to be sent
+//	Returns: the priority name.
+func (c *PriorityKafkaMessageQueue) GetMessagePriority(ctx context.Context, message *cqueues.MessageEnvelope) string {
+	if priority := GetPriority(ctx); priority != "" {
+		return priority
+	}
+
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	if priority, ok := c.priorityTypes[message.MessageType]; ok {
+		return priority
+	}
+	return c.defaultPriority
+}
+
+//	Sends a message to the lane of its priority.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string    (optional) transaction id to trace execution through call chain.
+//		- envelope *cqueues.MessageEnvelope  a message envelop to be sent.
+//	Returns: error or nil for success.
+func (c *PriorityKafkaMessageQueue) Send(ctx context.Context, correlationId string, envelope *cqueues.MessageEnvelope) error {
+	priority := c.GetMessagePriority(ctx, envelope)
+	lane := c.GetLane(priority)
+	if lane == nil {
+		return cerr.NewBadRequestError(correlationId, "UNKNOWN_PRIORITY", "Priority "+priority+" is not configured").
+			WithDetails("priority", priority)
+	}
+
+	c.Counters.IncrementOne(ctx, "queue."+c.Name()+"."+priority+".sent_messages")
+	return lane.Send(ctx, correlationId, envelope)
+}
+
+//	Peeks a single incoming message from the highest-priority lane that has messages.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId  string  (optional) transaction id to trace execution through call chain.
+//	Returns: a peeked message or nil and error.
+func (c *PriorityKafkaMessageQueue) Peek(ctx context.Context, correlationId string) (*cqueues.MessageEnvelope, error) {
+	for _, lane := range c.getLanes() {
+		message, err := lane.Peek(ctx, correlationId)
+		if message != nil || err != nil {
+			return message, err
+		}
+	}
+	return nil, nil
+}
+
+//	Peeks multiple incoming messages from lanes in the priority order.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId  string  (optional) transaction id to trace execution through call chain.
+//		- messageCount int64	a maximum number of messages to peek.
+//	Returns: a list with peeked messages or error.
+func (c *PriorityKafkaMessageQueue) PeekBatch(ctx context.Context, correlationId string, messageCount int64) ([]*cqueues.MessageEnvelope, error) {
+	messages := make([]*cqueues.MessageEnvelope, 0)
+	for _, lane := range c.getLanes() {
+		if int64(len(messages)) >= messageCount {
+			break
+		}
+		batch, err := lane.PeekBatch(ctx, correlationId, messageCount-int64(len(messages)))
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, batch...)
+	}
+	return messages, nil
+}
+
+//	Receives a message from the highest-priority lane that has messages.
+//	The call blocks until a message arrives in any lane, the wait timeout expires or the context is done.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId   string   (optional) transaction id to trace execution through call chain.
+//		- waitTimeout  time.Duration     a timeout to wait for a message to come.
+//	Returns: a received message or nil and error.
+func (c *PriorityKafkaMessageQueue) Receive(ctx context.Context, correlationId string, waitTimeout time.Duration) (*cqueues.MessageEnvelope, error) {
+	message, lane, err := c.receive(ctx, correlationId, time.After(waitTimeout), nil)
+	if message != nil {
+		c.ownersLock.Lock()
+		c.owners[message] = lane
+		c.ownersLock.Unlock()
+	}
+	return message, err
+}
+
+// Polls lanes in the priority order until a message arrives, the timeout expires or receiving is stopped
+func (c *PriorityKafkaMessageQueue) receive(ctx context.Context, correlationId string, timeout <-chan time.Time,
+	stop <-chan struct{}) (*cqueues.MessageEnvelope, cqueues.IMessageQueue, error) {
+
+	err := c.CheckOpen(correlationId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.Lock.Lock()
+	pollInterval := c.pollInterval
+	c.Lock.Unlock()
+
+	for {
+		for _, lane := range c.getLanes() {
+			message, err := lane.Receive(ctx, correlationId, 0)
+			if err != nil {
+				return nil, nil, err
+			}
+			if message != nil {
+				return message, lane, nil
+			}
+		}
+
+		timer := time.NewTimer(pollInterval)
+		select {
+		case <-timer.C:
+		case <-timeout:
+			timer.Stop()
+			return nil, nil, nil
+		case <-stop:
+			timer.Stop()
+			return nil, nil, nil
+		case <-ctx.Done():
+			timer.Stop()
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, nil, nil
+			}
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// Gets and forgets the lane of a message returned by Receive
+func (c *PriorityKafkaMessageQueue) takeOwner(message *cqueues.MessageEnvelope) cqueues.IMessageQueue {
+	c.ownersLock.Lock()
+	defer c.ownersLock.Unlock()
+
+	lane := c.owners[message]
+	delete(c.owners, message)
+	return lane
+}
+
+//	Renews a lock on a message in its lane.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message *cqueues.MessageEnvelope	a message received by Receive.
+//		- lockTimeout time.Duration	a locking timeout.
+//	Returns: error or nil for success.
+func (c *PriorityKafkaMessageQueue) RenewLock(ctx context.Context, message *cqueues.MessageEnvelope, lockTimeout time.Duration) error {
+	c.ownersLock.Lock()
+	lane := c.owners[message]
+	c.ownersLock.Unlock()
+
+	if lane == nil {
+		return nil
+	}
+	return lane.RenewLock(ctx, message, lockTimeout)
+}
+
+//	Completes a message in its lane.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message *cqueues.MessageEnvelope	a message received by Receive.
+//	Returns: error or nil for success.
+func (c *PriorityKafkaMessageQueue) Complete(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	if lane := c.takeOwner(message); lane != nil {
+		return lane.Complete(ctx, message)
+	}
+	return nil
+}
+
+//	Returns a message into its lane.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message *cqueues.MessageEnvelope	a message received by Receive.
+//	Returns: error or nil for success.
+func (c *PriorityKafkaMessageQueue) Abandon(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	if lane := c.takeOwner(message); lane != nil {
+		return lane.Abandon(ctx, message)
+	}
+	return nil
+}
+
+//	Moves a message to the dead letter queue of its lane.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- message *cqueues.MessageEnvelope	a message received by Receive.
+//	Returns: error or nil for success.
+func (c *PriorityKafkaMessageQueue) MoveToDeadLetter(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	if lane := c.takeOwner(message); lane != nil {
+		return lane.MoveToDeadLetter(ctx, message)
+	}
+	return nil
+}
+
+//	Listens for incoming messages of all lanes and blocks the current thread until the queue is closed,
+//	listening is ended or the context is canceled. Messages of higher-priority lanes are passed first.
+//	Receivers get the lane queue of every message to complete or abandon it.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId   string  (optional) transaction id to trace execution through call chain.
+//		- receiver    cqueues.IMessageReceiver      a receiver to receive incoming messages.
+func (c *PriorityKafkaMessageQueue) Listen(ctx context.Context, correlationId string, receiver cqueues.IMessageReceiver) error {
+	err := c.CheckOpen(correlationId)
+	if err != nil {
+		return err
+	}
+
+	c.Lock.Lock()
+	c.stopListening()
+	stop := make(chan struct{})
+	c.listenStop = stop
+	c.Lock.Unlock()
+
+	for {
+		message, lane, err := c.receive(ctx, correlationId, nil, stop)
+		if err != nil {
+			return err
+		}
+		if message == nil {
+			break
+		}
+
+		c.Counters.IncrementOne(ctx, "queue."+c.Name()+".received_messages")
+		err = receiver.ReceiveMessage(ctx, message, lane)
+		if err != nil {
+			c.Logger.Error(ctx, message.CorrelationId, err, "Failed to process the message")
+		}
+	}
+
+	c.Lock.Lock()
+	if c.listenStop == stop {
+		c.stopListening()
+	}
+	c.Lock.Unlock()
+	return nil
+}
+
+//	Ends listening for incoming messages.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId  string   (optional) transaction id to trace execution through call chain.
+func (c *PriorityKafkaMessageQueue) EndListen(ctx context.Context, correlationId string) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	c.stopListening()
+}
+
+// Unblocks Listen. Must be called under the lock.
+func (c *PriorityKafkaMessageQueue) stopListening() {
+	if c.listenStop != nil {
+		close(c.listenStop)
+		c.listenStop = nil
+	}
+}
+
+// Gets values of a string map
+func mapValues(values map[string]string) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		result = append(result, value)
+	}
+	return result
+}
@@ -0,0 +1,182 @@
+package queues
+
+import (
+	"context"
+	"sync"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	ccount "github.com/pip-services3-gox/pip-services3-components-gox/count"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+)
+
+//	QueueBridge pumps messages from a source queue to a target queue.
+//	Either queue can be a KafkaMessageQueue or any other IMessageQueue (MQTT, NATS, memory),
+//	so services can be migrated between brokers gradually.
+//	Envelopes are forwarded with their message ids, types, correlation ids and sent times.
+//	Source messages are completed after they are sent to the target.
+//	When the target fails, Kafka sources pause and redeliver the message,
+//	other sources get the message abandoned. Use two bridges to pump messages both ways.
+//
+//	Configuration parameters:
+//
+//		- dependencies:
+//			- source:                      descriptor of the source queue
+//			- target:                      descriptor of the target queue
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:counters:*:*:1.0           (optional)  ICounters components to pass collected measurements
+//		- *:message-queue:*:*:1.0      source and target queues set by the dependencies
+//
+//	Example:
+//		bridge := NewQueueBridge("orders")
+//		bridge.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"dependencies.source", "pip-services:message-queue:mqtt:orders:1.0",
+//			"dependencies.target", "pip-services:message-queue:kafka:orders:1.0",
+//		))
+//		bridge.SetReferences(ctx, references)
+//		_ = bridge.Open(ctx, "123")
+type QueueBridge struct {
+	name   string
+	lock   sync.Mutex
+	opened bool
+
+	// The dependency resolver.
+	DependencyResolver *cref.DependencyResolver
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The performance counters.
+	Counters *ccount.CompositeCounters
+	// The queue to receive messages from.
+	Source cqueues.IMessageQueue
+	// The queue to send messages to.
+	Target cqueues.IMessageQueue
+}
+
+//	NewQueueBridge creates a new instance of the bridge.
+//	Parameters:
+//		- name string	(optional) a bridge name used in logs and counters.
+//	Returns: *QueueBridge
+func NewQueueBridge(name string) *QueueBridge {
+	return &QueueBridge{
+		name:               name,
+		DependencyResolver: cref.NewDependencyResolver(),
+		Logger:             clog.NewCompositeLogger(),
+		Counters:           ccount.NewCompositeCounters(),
+	}
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *QueueBridge) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	c.name = config.GetAsStringWithDefault("name", c.name)
+	c.DependencyResolver.Configure(ctx, config)
+	c.Logger.Configure(ctx, config)
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *QueueBridge) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.Logger.SetReferences(ctx, references)
+	c.Counters.SetReferences(ctx, references)
+	c.DependencyResolver.SetReferences(ctx, references)
+
+	if source, ok := c.DependencyResolver.GetOneOptional("source").(cqueues.IMessageQueue); ok {
+		c.Source = source
+	}
+	if target, ok := c.DependencyResolver.GetOneOptional("target").(cqueues.IMessageQueue); ok {
+		c.Target = target
+	}
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *QueueBridge) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.opened
+}
+
+//	Opens the component and starts pumping messages.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *QueueBridge) Open(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.opened {
+		return nil
+	}
+
+	if c.Source == nil {
+		return cref.NewReferenceError(correlationId, "source")
+	}
+	if c.Target == nil {
+		return cref.NewReferenceError(correlationId, "target")
+	}
+
+	c.Source.BeginListen(context.Background(), correlationId, c)
+	c.opened = true
+
+	c.Logger.Info(ctx, correlationId, "Started bridge %s from %s to %s", c.name, c.Source.Name(), c.Target.Name())
+	return nil
+}
+
+//	Closes component and stops pumping messages.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *QueueBridge) Close(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.opened {
+		return nil
+	}
+
+	c.Source.EndListen(ctx, correlationId)
+	c.opened = false
+	return nil
+}
+
+//	Forwards a message received from the source queue to the target queue.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- envelope *cqueues.MessageEnvelope	a received message
+//		- queue cqueues.IMessageQueue	the source queue
+//	Returns: error or nil for success.
+func (c *QueueBridge) ReceiveMessage(ctx context.Context, envelope *cqueues.MessageEnvelope, queue cqueues.IMessageQueue) error {
+	// Send a copy so the target doesn't take over the source reference
+	message := cqueues.NewEmptyMessageEnvelope()
+	message.CorrelationId = envelope.CorrelationId
+	message.MessageId = envelope.MessageId
+	message.MessageType = envelope.MessageType
+	message.SentTime = envelope.SentTime
+	message.Message = envelope.Message
+
+	err := c.Target.Send(ctx, envelope.CorrelationId, message)
+	if err != nil {
+		c.Logger.Error(ctx, envelope.CorrelationId, err, "Failed to forward message %s to %s", envelope.MessageId, c.Target.Name())
+		c.Counters.IncrementOne(ctx, "bridge."+c.name+".failed_messages")
+
+		if _, ok := queue.(IKafkaMessageQueue); ok {
+			return NewDownstreamUnavailableError(envelope.CorrelationId, "Target queue "+c.Target.Name()+" is unavailable").
+				WithCause(err)
+		}
+		_ = queue.Abandon(ctx, envelope)
+		return err
+	}
+
+	c.Counters.IncrementOne(ctx, "bridge."+c.name+".forwarded_messages")
+	return queue.Complete(ctx, envelope)
+}
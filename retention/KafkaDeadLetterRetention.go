@@ -0,0 +1,391 @@
+package retention
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	ccount "github.com/pip-services3-gox/pip-services3-components-gox/count"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+)
+
+//	KafkaDeadLetterPurge reports records purged from a dead letter topic.
+type KafkaDeadLetterPurge struct {
+	// The purged topic.
+	Topic string `json:"topic"`
+	// The number of purged records.
+	PurgedRecords int64 `json:"purged_records"`
+	// The offsets by partitions before which records were purged.
+	Offsets map[int32]int64 `json:"offsets"`
+}
+
+//	KafkaDeadLetterRetention periodically trims dead letter topics, which otherwise grow forever.
+//	Records older than the maximum age and records beyond the maximum number of messages
+//	in a partition are deleted via the admin API. Purged records are reported
+//	by logs and counters.
+//
+//	Dead letter topics are set explicitly or discovered by their suffix.
+//
+//	Configuration parameters:
+//
+//		- topics:                        (optional) comma-separated list of dead letter topics (default: topics with the suffix)
+//		- suffix:                        (optional) suffix of discovered dead letter topics (default: .dlq)
+//		- connection(s):
+//			- discovery_key:               (optional) a key to retrieve the connection from  IDiscovery
+//			- host:                        host name or IP address
+//			- port:                        port number
+//			- uri:                         resource URI or connection string with all parameters in it
+//		- credential(s):
+//			- store_key:                   (optional) a key to retrieve the credentials from  ICredentialStore
+//			- username:                    user name
+//			- password:                    user password
+//		- options:
+//			- interval:                    (optional) number of milliseconds between purges, 0 to purge only on demand (default: 3600000)
+//			- max_age_days:                (optional) number of days records are kept, 0 to keep them by age (default: 7)
+//			- max_messages:                (optional) number of latest records kept in each partition, 0 for no limit (default: 0)
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:counters:*:*:1.0           (optional)  ICounters components to pass collected measurements
+//		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
+//		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
+//		- *:connection:kafka:*:1.0      (optional) Shared connection to Kafka service
+//
+//	Counters:
+//
+//		- dead_letter.<topic>.purged_records:   number of purged records
+//
+//	Example:
+//		dlqRetention := retention.NewKafkaDeadLetterRetention()
+//		dlqRetention.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"topics", "orders.dlq",
+//			"options.max_age_days", 14,
+//			"connection.host", "localhost",
+//			"connection.port", 9092,
+//		))
+//		_ = dlqRetention.Open(ctx, "123")
+type KafkaDeadLetterRetention struct {
+	defaultConfig   *cconf.ConfigParams
+	config          *cconf.ConfigParams
+	references      cref.IReferences
+	lock            sync.Mutex
+	opened          bool
+	localConnection bool
+
+	// The dependency resolver.
+	DependencyResolver *cref.DependencyResolver
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The performance counters.
+	Counters *ccount.CompositeCounters
+	// The Kafka connection component.
+	Connection connect.IKafkaConnection
+
+	topics      []string
+	suffix      string
+	interval    time.Duration
+	maxAgeDays  int
+	maxMessages int64
+	stop        chan struct{}
+	workers     sync.WaitGroup
+}
+
+//	NewKafkaDeadLetterRetention creates a new instance of the retention component.
+//	Returns: *KafkaDeadLetterRetention
+func NewKafkaDeadLetterRetention() *KafkaDeadLetterRetention {
+	c := &KafkaDeadLetterRetention{
+		defaultConfig: cconf.NewConfigParamsFromTuples(
+			"dependencies.connection", "*:connection:kafka:*:1.0",
+		),
+		Logger:     clog.NewCompositeLogger(),
+		Counters:   ccount.NewCompositeCounters(),
+		topics:     []string{},
+		suffix:     ".dlq",
+		interval:   3600000 * time.Millisecond,
+		maxAgeDays: 7,
+	}
+	c.DependencyResolver = cref.NewDependencyResolver()
+	c.DependencyResolver.Configure(context.Background(), c.defaultConfig)
+	return c
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaDeadLetterRetention) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	config = config.SetDefaults(c.defaultConfig)
+	c.config = config
+
+	c.DependencyResolver.Configure(ctx, config)
+	c.Logger.Configure(ctx, config)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if topics, ok := config.GetAsNullableString("topics"); ok {
+		c.topics = []string{}
+		for _, topic := range strings.Split(topics, ",") {
+			if topic = strings.TrimSpace(topic); topic != "" {
+				c.topics = append(c.topics, topic)
+			}
+		}
+	}
+	c.suffix = config.GetAsStringWithDefault("suffix", c.suffix)
+	c.interval = time.Duration(config.GetAsIntegerWithDefault("options.interval",
+		int(c.interval.Milliseconds()))) * time.Millisecond
+	c.maxAgeDays = config.GetAsIntegerWithDefault("options.max_age_days", c.maxAgeDays)
+	c.maxMessages = config.GetAsLongWithDefault("options.max_messages", c.maxMessages)
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaDeadLetterRetention) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.references = references
+	c.Logger.SetReferences(ctx, references)
+	c.Counters.SetReferences(ctx, references)
+
+	// Get connection
+	c.DependencyResolver.SetReferences(ctx, references)
+	result := c.DependencyResolver.GetOneOptional("connection")
+	if dep, ok := result.(connect.IKafkaConnection); ok {
+		c.Connection = dep
+	}
+	// Or create a local one
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	} else {
+		c.localConnection = false
+	}
+}
+
+//	Unsets (clears) previously set references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+func (c *KafkaDeadLetterRetention) UnsetReferences(ctx context.Context) {
+	c.Connection = nil
+}
+
+func (c *KafkaDeadLetterRetention) createConnection() *connect.KafkaConnection {
+	connection := connect.NewKafkaConnection()
+	connection.AllowOptions("interval", "max_age_days", "max_messages")
+
+	if c.config != nil {
+		connection.Configure(context.Background(), c.config)
+	}
+
+	if c.references != nil {
+		connection.SetReferences(context.Background(), c.references)
+	}
+	return connection
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaDeadLetterRetention) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.opened
+}
+
+//	Opens the component and starts periodic purges.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaDeadLetterRetention) Open(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.opened {
+		return nil
+	}
+
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	}
+
+	if c.localConnection {
+		err := c.Connection.Open(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !c.Connection.IsOpen() {
+		return cerr.NewConnectionError(correlationId, "CONNECT_FAILED", "Kafka connection is not opened")
+	}
+
+	c.opened = true
+	c.startPurging()
+	return nil
+}
+
+//	Closes component and stops periodic purges.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaDeadLetterRetention) Close(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	if !c.opened {
+		c.lock.Unlock()
+		return nil
+	}
+	c.opened = false
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	c.lock.Unlock()
+
+	c.workers.Wait()
+
+	if c.localConnection {
+		return c.Connection.Close(ctx, correlationId)
+	}
+	return nil
+}
+
+func (c *KafkaDeadLetterRetention) startPurging() {
+	if c.interval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	c.stop = stop
+
+	c.workers.Add(1)
+	go func() {
+		defer c.workers.Done()
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				_, err := c.Purge(context.Background(), "")
+				if err != nil {
+					c.Logger.Error(context.Background(), "", err, "Failed to purge dead letter topics")
+				}
+			}
+		}
+	}()
+}
+
+//	Purges expired records from dead letter topics. It is called periodically
+//	by the interval option, and it can be called on demand.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//	Returns: reports of topics with purged records or error.
+func (c *KafkaDeadLetterRetention) Purge(ctx context.Context, correlationId string) ([]*KafkaDeadLetterPurge, error) {
+	c.lock.Lock()
+	if !c.opened {
+		c.lock.Unlock()
+		return nil, cerr.NewInvalidStateError(correlationId, "NOT_OPENED", "The retention is not opened")
+	}
+	topics, suffix := c.topics, c.suffix
+	maxAgeDays, maxMessages := c.maxAgeDays, c.maxMessages
+	c.lock.Unlock()
+
+	if len(topics) == 0 {
+		names, err := c.Connection.ReadQueueNames()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if suffix != "" && strings.HasSuffix(name, suffix) {
+				topics = append(topics, name)
+			}
+		}
+	}
+
+	result := []*KafkaDeadLetterPurge{}
+	for _, topic := range topics {
+		purge, err := c.purgeTopic(ctx, correlationId, topic, maxAgeDays, maxMessages)
+		if err != nil {
+			return result, cerr.NewConnectionError(correlationId, "PURGE_FAILED",
+				"Failed to purge dead letter topic "+topic).
+				WithDetails("topic", topic).
+				WithCause(err)
+		}
+		if purge == nil {
+			continue
+		}
+
+		result = append(result, purge)
+		c.Counters.Increment(ctx, "dead_letter."+topic+".purged_records", purge.PurgedRecords)
+		c.Logger.Info(ctx, correlationId, "Purged %d records from dead letter topic %s", purge.PurgedRecords, topic)
+	}
+	return result, nil
+}
+
+// Deletes records of a topic that are older than the maximum age or beyond the maximum number
+func (c *KafkaDeadLetterRetention) purgeTopic(ctx context.Context, correlationId string, topic string,
+	maxAgeDays int, maxMessages int64) (*KafkaDeadLetterPurge, error) {
+
+	deleter, ok := c.Connection.(connect.IKafkaRecordDeleter)
+	if !ok {
+		return nil, connect.NewUnsupportedOperationError(correlationId, "DeleteRecords")
+	}
+
+	offsets, err := c.Connection.ListOffsets(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	cuts := make(map[int32]int64, len(offsets))
+	if maxAgeDays > 0 {
+		expireTime := time.Now().Add(-time.Duration(maxAgeDays) * 24 * time.Hour).UnixMilli()
+		reader, ok := c.Connection.(connect.IKafkaOffsetReader)
+		if !ok {
+			return nil, connect.NewUnsupportedOperationError(correlationId, "ReadOffsets")
+		}
+		cuts, err = reader.ReadOffsets(topic, nil, expireTime)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	purge := &KafkaDeadLetterPurge{
+		Topic:   topic,
+		Offsets: make(map[int32]int64),
+	}
+	for partition, offset := range offsets {
+		cut := cuts[partition]
+		if maxMessages > 0 && offset.Latest-maxMessages > cut {
+			cut = offset.Latest - maxMessages
+		}
+		if cut > offset.Latest {
+			cut = offset.Latest
+		}
+		if cut > offset.Earliest {
+			purge.Offsets[partition] = cut
+			purge.PurgedRecords += cut - offset.Earliest
+		}
+	}
+
+	if len(purge.Offsets) == 0 {
+		return nil, nil
+	}
+
+	err = deleter.DeleteRecords(topic, purge.Offsets)
+	if err != nil {
+		return nil, err
+	}
+	return purge, nil
+}
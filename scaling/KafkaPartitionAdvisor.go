@@ -0,0 +1,484 @@
+package scaling
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	ccount "github.com/pip-services3-gox/pip-services3-components-gox/count"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+)
+
+//	KafkaPartitionRecommendation is a recommended increase of topic partitions.
+type KafkaPartitionRecommendation struct {
+	// The observed topic.
+	Topic string `json:"topic"`
+	// The current number of partitions.
+	Partitions int `json:"partitions"`
+	// The recommended number of partitions.
+	RecommendedPartitions int `json:"recommended_partitions"`
+	// The total throughput of the topic in messages per second.
+	Throughput float64 `json:"throughput"`
+	// The total lag of the observed consumer group.
+	Lag int64 `json:"lag"`
+	// True when the partitions were increased.
+	Applied bool `json:"applied"`
+}
+
+// Observed state of a topic
+type kafkaTopicObservation struct {
+	time     time.Time
+	latest   map[int32]int64
+	required []int
+}
+
+//	KafkaPartitionAdvisor observes per-partition throughput of topics and lag of a consumer group
+//	and recommends partition increases when partitions stay saturated for several observations.
+//	Recommendations are logged as warnings and published as counters, and with auto_scale
+//	they are applied via the admin API.
+//
+//	The recommended number of partitions keeps throughput and lag of each partition under
+//	the configured limits in all sustained observations. Adding partitions remaps keys
+//	of existing records, so auto scaling should be enabled only for topics without key ordering.
+//
+//	Configuration parameters:
+//
+//		- topics:                        comma-separated list of observed topics
+//		- group_id:                      (optional) consumer group whose lag is observed
+//		- connection(s):
+//			- discovery_key:               (optional) a key to retrieve the connection from  IDiscovery
+//			- host:                        host name or IP address
+//			- port:                        port number
+//			- uri:                         resource URI or connection string with all parameters in it
+//		- credential(s):
+//			- store_key:                   (optional) a key to retrieve the credentials from  ICredentialStore
+//			- username:                    user name
+//			- password:                    user password
+//		- options:
+//			- interval:                    (optional) number of milliseconds between observations, 0 to observe only on demand (default: 60000)
+//			- max_partition_throughput:    (optional) messages per second a partition handles (default: 1000)
+//			- max_partition_lag:           (optional) lag a partition is allowed to keep (default: 10000)
+//			- sustain_count:               (optional) number of consecutive saturated observations before a recommendation (default: 5)
+//			- max_partitions:              (optional) maximum recommended number of partitions (default: 100)
+//			- auto_scale:                  (optional) increases partitions by recommendations (default: false)
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:counters:*:*:1.0           (optional)  ICounters components to pass collected measurements
+//		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
+//		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
+//		- *:connection:kafka:*:1.0      (optional) Shared connection to Kafka service
+//
+//	Counters:
+//
+//		- partition_advisor.<topic>.throughput:              total throughput of the topic in messages per second
+//		- partition_advisor.<topic>.lag:                     total lag of the consumer group
+//		- partition_advisor.<topic>.recommended_partitions:  recommended number of partitions
+//		- partition_advisor.<topic>.scaled:                  number of applied increases
+//
+//	Example:
+//		advisor := scaling.NewKafkaPartitionAdvisor()
+//		advisor.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"topics", "orders,payments",
+//			"group_id", "billing",
+//			"connection.host", "localhost",
+//			"connection.port", 9092,
+//		))
+//		_ = advisor.Open(ctx, "123")
+type KafkaPartitionAdvisor struct {
+	defaultConfig   *cconf.ConfigParams
+	config          *cconf.ConfigParams
+	references      cref.IReferences
+	lock            sync.Mutex
+	opened          bool
+	localConnection bool
+
+	// The dependency resolver.
+	DependencyResolver *cref.DependencyResolver
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The performance counters.
+	Counters *ccount.CompositeCounters
+	// The Kafka connection component.
+	Connection connect.IKafkaConnection
+
+	topics                 []string
+	groupId                string
+	interval               time.Duration
+	maxPartitionThroughput float64
+	maxPartitionLag        int64
+	sustainCount           int
+	maxPartitions          int
+	autoScale              bool
+	observations           map[string]*kafkaTopicObservation
+	recommendations        map[string]*KafkaPartitionRecommendation
+	stop                   chan struct{}
+	workers                sync.WaitGroup
+}
+
+//	NewKafkaPartitionAdvisor creates a new instance of the advisor component.
+//	Returns: *KafkaPartitionAdvisor
+func NewKafkaPartitionAdvisor() *KafkaPartitionAdvisor {
+	c := &KafkaPartitionAdvisor{
+		defaultConfig: cconf.NewConfigParamsFromTuples(
+			"dependencies.connection", "*:connection:kafka:*:1.0",
+		),
+		Logger:                 clog.NewCompositeLogger(),
+		Counters:               ccount.NewCompositeCounters(),
+		topics:                 []string{},
+		interval:               60000 * time.Millisecond,
+		maxPartitionThroughput: 1000,
+		maxPartitionLag:        10000,
+		sustainCount:           5,
+		maxPartitions:          100,
+		observations:           make(map[string]*kafkaTopicObservation),
+		recommendations:        make(map[string]*KafkaPartitionRecommendation),
+	}
+	c.DependencyResolver = cref.NewDependencyResolver()
+	c.DependencyResolver.Configure(context.Background(), c.defaultConfig)
+	return c
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaPartitionAdvisor) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	config = config.SetDefaults(c.defaultConfig)
+	c.config = config
+
+	c.DependencyResolver.Configure(ctx, config)
+	c.Logger.Configure(ctx, config)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if topics, ok := config.GetAsNullableString("topics"); ok {
+		c.topics = []string{}
+		for _, topic := range strings.Split(topics, ",") {
+			if topic = strings.TrimSpace(topic); topic != "" {
+				c.topics = append(c.topics, topic)
+			}
+		}
+	}
+	c.groupId = config.GetAsStringWithDefault("group_id", c.groupId)
+	c.interval = time.Duration(config.GetAsIntegerWithDefault("options.interval",
+		int(c.interval.Milliseconds()))) * time.Millisecond
+	c.maxPartitionThroughput = config.GetAsDoubleWithDefault("options.max_partition_throughput", c.maxPartitionThroughput)
+	c.maxPartitionLag = config.GetAsLongWithDefault("options.max_partition_lag", c.maxPartitionLag)
+	c.sustainCount = config.GetAsIntegerWithDefault("options.sustain_count", c.sustainCount)
+	c.maxPartitions = config.GetAsIntegerWithDefault("options.max_partitions", c.maxPartitions)
+	c.autoScale = config.GetAsBooleanWithDefault("options.auto_scale", c.autoScale)
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaPartitionAdvisor) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.references = references
+	c.Logger.SetReferences(ctx, references)
+	c.Counters.SetReferences(ctx, references)
+
+	// Get connection
+	c.DependencyResolver.SetReferences(ctx, references)
+	result := c.DependencyResolver.GetOneOptional("connection")
+	if dep, ok := result.(connect.IKafkaConnection); ok {
+		c.Connection = dep
+	}
+	// Or create a local one
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	} else {
+		c.localConnection = false
+	}
+}
+
+//	Unsets (clears) previously set references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+func (c *KafkaPartitionAdvisor) UnsetReferences(ctx context.Context) {
+	c.Connection = nil
+}
+
+func (c *KafkaPartitionAdvisor) createConnection() *connect.KafkaConnection {
+	connection := connect.NewKafkaConnection()
+	connection.AllowOptions("interval", "max_partition_throughput", "max_partition_lag",
+		"sustain_count", "max_partitions", "auto_scale")
+
+	if c.config != nil {
+		connection.Configure(context.Background(), c.config)
+	}
+
+	if c.references != nil {
+		connection.SetReferences(context.Background(), c.references)
+	}
+	return connection
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaPartitionAdvisor) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.opened
+}
+
+//	Opens the component and starts periodic observations.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaPartitionAdvisor) Open(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.opened {
+		return nil
+	}
+
+	if len(c.topics) == 0 {
+		return cerr.NewConfigError(correlationId, "NO_TOPICS", "Observed topics are not set")
+	}
+
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	}
+
+	if c.localConnection {
+		err := c.Connection.Open(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !c.Connection.IsOpen() {
+		return cerr.NewConnectionError(correlationId, "CONNECT_FAILED", "Kafka connection is not opened")
+	}
+
+	c.observations = make(map[string]*kafkaTopicObservation)
+	c.recommendations = make(map[string]*KafkaPartitionRecommendation)
+	c.opened = true
+	c.startObserving()
+	return nil
+}
+
+//	Closes component and stops observations.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaPartitionAdvisor) Close(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	if !c.opened {
+		c.lock.Unlock()
+		return nil
+	}
+	c.opened = false
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	c.lock.Unlock()
+
+	c.workers.Wait()
+
+	if c.localConnection {
+		return c.Connection.Close(ctx, correlationId)
+	}
+	return nil
+}
+
+func (c *KafkaPartitionAdvisor) startObserving() {
+	if c.interval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	c.stop = stop
+
+	c.workers.Add(1)
+	go func() {
+		defer c.workers.Done()
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				_, err := c.Observe(context.Background(), "")
+				if err != nil {
+					c.Logger.Debug(context.Background(), "", "Failed to observe partitions: %s", err)
+				}
+			}
+		}
+	}()
+}
+
+//	Gets the latest recommendations by topics.
+//	Returns: recommendations of topics that needed more partitions.
+func (c *KafkaPartitionAdvisor) GetRecommendations() []*KafkaPartitionRecommendation {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	result := make([]*KafkaPartitionRecommendation, 0, len(c.recommendations))
+	for _, topic := range c.topics {
+		if recommendation, ok := c.recommendations[topic]; ok {
+			result = append(result, recommendation)
+		}
+	}
+	return result
+}
+
+//	Observes throughput and lag of all topics. It is called periodically
+//	by the interval option, and it can be called on demand.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//	Returns: recommendations made by this observation or error.
+func (c *KafkaPartitionAdvisor) Observe(ctx context.Context, correlationId string) ([]*KafkaPartitionRecommendation, error) {
+	c.lock.Lock()
+	if !c.opened {
+		c.lock.Unlock()
+		return nil, cerr.NewInvalidStateError(correlationId, "NOT_OPENED", "The advisor is not opened")
+	}
+	topics := c.topics
+	c.lock.Unlock()
+
+	result := []*KafkaPartitionRecommendation{}
+	for _, topic := range topics {
+		recommendation, err := c.observeTopic(ctx, correlationId, topic)
+		if err != nil {
+			return result, err
+		}
+		if recommendation != nil {
+			result = append(result, recommendation)
+		}
+	}
+	return result, nil
+}
+
+// Observes a topic and recommends partitions when they stay saturated
+func (c *KafkaPartitionAdvisor) observeTopic(ctx context.Context, correlationId string,
+	topic string) (*KafkaPartitionRecommendation, error) {
+
+	now := time.Now()
+	offsets, err := c.Connection.ListOffsets(topic)
+	if err != nil {
+		return nil, err
+	}
+	partitions := len(offsets)
+	if partitions == 0 {
+		return nil, nil
+	}
+
+	lag := int64(0)
+	if c.groupId != "" {
+		lags, err := c.Connection.ReadLags(topic, c.groupId, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, partitionLag := range lags {
+			lag += partitionLag
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	latest := make(map[int32]int64, len(offsets))
+	for partition, offset := range offsets {
+		latest[partition] = offset.Latest
+	}
+
+	observation, ok := c.observations[topic]
+	if !ok {
+		// The first observation only remembers offsets to measure throughput
+		c.observations[topic] = &kafkaTopicObservation{time: now, latest: latest}
+		return nil, nil
+	}
+
+	produced := int64(0)
+	for partition, offset := range latest {
+		if previous, ok := observation.latest[partition]; ok && offset > previous {
+			produced += offset - previous
+		}
+	}
+	throughput := 0.0
+	if elapsed := now.Sub(observation.time).Seconds(); elapsed > 0 {
+		throughput = float64(produced) / elapsed
+	}
+	observation.time = now
+	observation.latest = latest
+
+	c.Counters.Last(ctx, "partition_advisor."+topic+".throughput", throughput)
+	c.Counters.Last(ctx, "partition_advisor."+topic+".lag", float64(lag))
+
+	required := int(math.Ceil(throughput / c.maxPartitionThroughput))
+	if c.maxPartitionLag > 0 {
+		lagRequired := int(math.Ceil(float64(lag) / float64(c.maxPartitionLag)))
+		if lagRequired > required {
+			required = lagRequired
+		}
+	}
+	if required > c.maxPartitions {
+		required = c.maxPartitions
+	}
+
+	if required <= partitions {
+		observation.required = nil
+		return nil, nil
+	}
+	observation.required = append(observation.required, required)
+	if len(observation.required) < c.sustainCount {
+		return nil, nil
+	}
+
+	// The smallest requirement of sustained observations avoids scaling by short bursts
+	recommended := required
+	for _, value := range observation.required {
+		if value < recommended {
+			recommended = value
+		}
+	}
+	observation.required = nil
+
+	recommendation := &KafkaPartitionRecommendation{
+		Topic:                 topic,
+		Partitions:            partitions,
+		RecommendedPartitions: recommended,
+		Throughput:            throughput,
+		Lag:                   lag,
+	}
+	c.recommendations[topic] = recommendation
+	c.Counters.Last(ctx, "partition_advisor."+topic+".recommended_partitions", float64(recommended))
+	c.Logger.Warn(ctx, correlationId, "Topic %s needs %d partitions instead of %d, throughput: %.1f/s, lag: %d",
+		topic, recommended, partitions, throughput, lag)
+
+	if c.autoScale {
+		err = c.Connection.CreatePartitions(topic, recommended)
+		if err != nil {
+			return recommendation, cerr.NewConnectionError(correlationId, "SCALE_FAILED",
+				"Failed to increase partitions of topic "+topic).
+				WithDetails("partitions", recommended).
+				WithCause(err)
+		}
+		recommendation.Applied = true
+		c.Counters.IncrementOne(ctx, "partition_advisor."+topic+".scaled")
+		c.Logger.Info(ctx, correlationId, "Increased partitions of topic %s to %d", topic, recommended)
+	}
+
+	return recommendation, nil
+}
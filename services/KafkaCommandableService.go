@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	ccomands "github.com/pip-services3-gox/pip-services3-commons-gox/commands"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	crun "github.com/pip-services3-gox/pip-services3-commons-gox/run"
+	ccount "github.com/pip-services3-gox/pip-services3-components-gox/count"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
+)
+
+//	KafkaCommandableService exposes commands of a controller that implements ICommandable
+//	over a Kafka request topic. It is the Kafka analog of commandable HTTP and gRPC services.
+//
+//	Requests carry the command name in the "command" header and JSON arguments in their values.
+//	Results are sent as JSON to reply topics of requests, and errors are returned
+//	as error descriptions, so KafkaClient restores them as application errors.
+//
+//	Configuration parameters:
+//
+//		- topic:                         (optional) topic of command requests (default: topic set in the constructor)
+//		- group_id:                      (optional) consumer group id of service instances (default: default)
+//		- dependencies:
+//			- controller:                override for controller dependency
+//		- connection(s), credential(s), options: see KafkaConsumer
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:counters:*:*:1.0           (optional)  ICounters components to pass collected measurements
+//		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
+//		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
+//		- *:connection:kafka:*:1.0      (optional) Shared connection to Kafka service
+//
+//	Example:
+//		type MyKafkaService struct {
+//			*services.KafkaCommandableService
+//		}
+//
+//		func NewMyKafkaService() *MyKafkaService {
+//			c := &MyKafkaService{
+//				KafkaCommandableService: services.NewKafkaCommandableService("v1.mycontroller"),
+//			}
+//			c.DependencyResolver.Put(context.Background(), "controller",
+//				cref.NewDescriptor("mygroup", "controller", "*", "*", "1.0"))
+//			return c
+//		}
+type KafkaCommandableService struct {
+	lock       sync.Mutex
+	topic      string
+	commandSet *ccomands.CommandSet
+	refErr     error
+
+	// The dependency resolver of the controller.
+	DependencyResolver *cref.DependencyResolver
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The performance counters.
+	Counters *ccount.CompositeCounters
+	// The server of command requests.
+	Server *clients.KafkaRequestReplyServer
+}
+
+//	NewKafkaCommandableService creates a new instance of the service.
+//	Parameters:
+//		- topic string	a topic of command requests
+//	Returns: *KafkaCommandableService
+func NewKafkaCommandableService(topic string) *KafkaCommandableService {
+	c := &KafkaCommandableService{
+		topic:              topic,
+		DependencyResolver: cref.NewDependencyResolver(),
+		Logger:             clog.NewCompositeLogger(),
+		Counters:           ccount.NewCompositeCounters(),
+		Server:             clients.NewKafkaRequestReplyServer(),
+	}
+	c.Server.SetHandler(c.handleCommand)
+	return c
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaCommandableService) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	c.lock.Lock()
+	c.topic = config.GetAsStringWithDefault("topic", c.topic)
+	topic := c.topic
+	c.lock.Unlock()
+
+	c.DependencyResolver.Configure(ctx, config)
+	c.Logger.Configure(ctx, config)
+	c.Server.Configure(ctx, config.Override(cconf.NewConfigParamsFromTuples("topic", topic)))
+}
+
+//	Sets references to the controller and dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaCommandableService) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.Logger.SetReferences(ctx, references)
+	c.Counters.SetReferences(ctx, references)
+	c.Server.SetReferences(ctx, references)
+
+	c.DependencyResolver.SetReferences(ctx, references)
+	controller, err := c.DependencyResolver.GetOneRequired("controller")
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.refErr = err
+	if err != nil {
+		return
+	}
+	commandable, ok := controller.(ccomands.ICommandable)
+	if !ok {
+		c.refErr = cerr.NewConfigError("", "NOT_COMMANDABLE", "Controller does not implement ICommandable")
+		return
+	}
+	c.commandSet = commandable.GetCommandSet()
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaCommandableService) IsOpen() bool {
+	return c.Server.IsOpen()
+}
+
+//	Opens the component and starts serving commands.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaCommandableService) Open(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	refErr, commandSet := c.refErr, c.commandSet
+	c.lock.Unlock()
+
+	if refErr != nil {
+		return refErr
+	}
+	if commandSet == nil {
+		return cerr.NewConfigError(correlationId, "NO_CONTROLLER", "Controller is not set")
+	}
+
+	return c.Server.Open(ctx, correlationId)
+}
+
+//	Closes component and stops serving commands.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaCommandableService) Close(ctx context.Context, correlationId string) error {
+	return c.Server.Close(ctx, correlationId)
+}
+
+// Executes the command of a request and encodes its result
+func (c *KafkaCommandableService) handleCommand(ctx context.Context, request *clients.KafkaRecord) ([]byte, error) {
+	correlationId := request.Headers[clients.RequestCorrelationIdHeader]
+	name := request.Headers[clients.CommandHeader]
+	if name == "" {
+		return nil, cerr.NewBadRequestError(correlationId, "NO_COMMAND", "Request has no command name")
+	}
+
+	c.lock.Lock()
+	commandSet, topic := c.commandSet, c.topic
+	c.lock.Unlock()
+
+	args := crun.NewEmptyParameters()
+	if len(request.Value) > 0 {
+		values := make(map[string]any)
+		err := json.Unmarshal(request.Value, &values)
+		if err != nil {
+			return nil, cerr.NewBadRequestError(correlationId, "INVALID_ARGS", "Command arguments are not a JSON object").
+				WithDetails("command", name).
+				WithCause(err)
+		}
+		args = crun.NewParametersFromValue(values)
+	}
+
+	c.Logger.Trace(ctx, correlationId, "Executing %s.%s command", topic, name)
+	timing := c.Counters.BeginTiming(ctx, topic+"."+name+".exec_time")
+	defer timing.EndTiming(ctx)
+
+	result, err := commandSet.Execute(ctx, correlationId, name, args)
+	if err != nil {
+		c.Counters.IncrementOne(ctx, topic+"."+name+".exec_errors")
+		return nil, err
+	}
+
+	return json.Marshal(result)
+}
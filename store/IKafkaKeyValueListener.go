@@ -0,0 +1,9 @@
+package store
+
+import "context"
+
+// IKafkaKeyValueListener receives changes of KafkaKeyValueStore
+type IKafkaKeyValueListener interface {
+	// Called when a key is set or removed. Removed keys have nil values.
+	OnKeyChanged(ctx context.Context, key string, value []byte)
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"sort"
+
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+)
+
+// KafkaLoadProgress is called while a compacted topic is loaded.
+// Loaded and total are numbers of offsets, so skipped compacted records count as loaded.
+type KafkaLoadProgress func(loaded int64, total int64)
+
+//	LoadCompactedTopic reads a compacted topic from the beginning to the current end offsets
+//	into a map of last values by keys. Records with empty values (tombstones) remove their keys.
+//	Records produced after the call started are not read, so the load always completes.
+//	It is a bootstrap step of services that keep configuration or reference data in compacted topics.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- connection connect.IKafkaConnection	an opened Kafka connection
+//		- topic string	a topic name
+//		- progress KafkaLoadProgress	(optional) a callback called after every read batch
+//	Returns: values by keys or error.
+func LoadCompactedTopic(ctx context.Context, correlationId string, connection connect.IKafkaConnection,
+	topic string, progress KafkaLoadProgress) (map[string][]byte, error) {
+
+	if connection == nil || !connection.IsOpen() {
+		return nil, cerr.NewInvalidStateError(correlationId, "NOT_OPENED", "Kafka connection is not opened")
+	}
+
+	offsets, err := connection.ListOffsets(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := make([]int32, 0, len(offsets))
+	total := int64(0)
+	for partition, offset := range offsets {
+		partitions = append(partitions, partition)
+		total += offset.Latest - offset.Earliest
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+	values := make(map[string][]byte)
+	loaded := int64(0)
+	if progress != nil {
+		progress(loaded, total)
+	}
+
+	for _, partition := range partitions {
+		position := offsets[partition].Earliest
+		end := offsets[partition].Latest
+
+		for position < end {
+			err = ctx.Err()
+			if err != nil {
+				return nil, err
+			}
+
+			messages, err := connection.ReadMessages(topic, partition, position, 1000)
+			if err != nil {
+				return nil, err
+			}
+
+			next := end
+			if len(messages) > 0 && messages[len(messages)-1].Offset+1 < end {
+				next = messages[len(messages)-1].Offset + 1
+			}
+			for _, msg := range messages {
+				if msg.Offset >= end {
+					break
+				}
+				if msg.Value == nil {
+					delete(values, string(msg.Key))
+				} else {
+					values[string(msg.Key)] = msg.Value
+				}
+			}
+
+			// Gaps left by compaction are counted as loaded
+			loaded += next - position
+			position = next
+			if progress != nil {
+				progress(loaded, total)
+			}
+		}
+	}
+
+	return values, nil
+}
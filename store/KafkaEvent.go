@@ -0,0 +1,52 @@
+package store
+
+import (
+	"time"
+)
+
+// AnyVersion disables the check of the expected stream version in KafkaEventLog.Append
+const AnyVersion int64 = -1
+
+// KafkaEvent is an event of a stream stored in KafkaEventLog
+type KafkaEvent struct {
+	// Sequence number of the event in its stream starting from 1. It is assigned by the log.
+	Version int64 `json:"version,omitempty"`
+	// Event type
+	Type string `json:"type"`
+	// Event payload
+	Data []byte `json:"data"`
+	// Time the event was appended
+	Time time.Time `json:"time"`
+}
+
+//	NewKafkaEvent creates a new event.
+//	Parameters:
+//		- eventType string	an event type
+//		- data []byte	an event payload
+//	Returns: *KafkaEvent
+func NewKafkaEvent(eventType string, data []byte) *KafkaEvent {
+	return &KafkaEvent{
+		Type: eventType,
+		Data: data,
+	}
+}
+
+// Events appended to a stream at once
+type kafkaEventBatch struct {
+	StreamId        string        `json:"stream_id"`
+	ExpectedVersion int64         `json:"expected_version"`
+	Events          []*KafkaEvent `json:"events"`
+}
+
+// Location of an accepted batch in the log
+type kafkaEventBatchRef struct {
+	offset       int64
+	firstVersion int64
+	lastVersion  int64
+}
+
+// Index of a stream in the log
+type kafkaEventStream struct {
+	version int64
+	batches []kafkaEventBatchRef
+}
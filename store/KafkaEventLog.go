@@ -0,0 +1,476 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+)
+
+//	KafkaEventLog is an append-only event store for event-sourced services on top of a keyed Kafka topic.
+//
+//	Events of a stream are kept in the partition of the stream id, so the partition leader
+//	totally orders them. Every append is a single record with a batch of events and
+//	the expected stream version. All log instances replay records in the same order,
+//	assign per-stream sequence numbers and reject batches with outdated expected versions,
+//	so concurrent writers get optimistic concurrency without a separate event store.
+//	Instances keep only an index of record offsets in memory and read events from the topic.
+//
+//	The topic must exist and must not be compacted. Set retention.ms to -1 to keep events forever.
+//
+//	Configuration parameters:
+//
+//		- topic:                         event log topic name
+//		- connection(s):
+//			- discovery_key:               (optional) a key to retrieve the connection from  IDiscovery
+//			- host:                        host name or IP address
+//			- port:                        port number
+//			- uri:                         resource URI or connection string with all parameters in it
+//		- credential(s):
+//			- store_key:                   (optional) a key to retrieve the credentials from  ICredentialStore
+//			- username:                    user name
+//			- password:                    user password
+//		- options:
+//			- read_attempts:               (optional) number of attempts to read own appends from the topic (default: 3)
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
+//		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
+//		- *:connection:kafka:*:1.0      (optional) Shared connection to Kafka service
+//
+//	Example:
+//		log := NewKafkaEventLog()
+//		log.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"topic", "orders_events",
+//			"connection.host", "localhost",
+//			"connection.port", 9092,
+//		))
+//		_ = log.Open(ctx, "123")
+//
+//		version, err := log.Append(ctx, "123", "order1", 0, []*KafkaEvent{
+//			NewKafkaEvent("created", []byte("{...}")),
+//		})
+//		events, err := log.ReadStream(ctx, "123", "order1", 1)
+type KafkaEventLog struct {
+	defaultConfig   *cconf.ConfigParams
+	config          *cconf.ConfigParams
+	references      cref.IReferences
+	lock            sync.Mutex
+	readLock        sync.Mutex
+	opened          bool
+	localConnection bool
+
+	// The dependency resolver.
+	DependencyResolver *cref.DependencyResolver
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The Kafka connection component.
+	Connection connect.IKafkaConnection
+
+	topic        string
+	readAttempts int
+	partitions   []int32
+	positions    map[int32]int64
+	streams      map[string]*kafkaEventStream
+}
+
+//	NewKafkaEventLog creates a new instance of the event log component.
+//	Returns: *KafkaEventLog
+func NewKafkaEventLog() *KafkaEventLog {
+	c := &KafkaEventLog{
+		defaultConfig: cconf.NewConfigParamsFromTuples(
+			"options.read_attempts", 3,
+			"dependencies.connection", "*:connection:kafka:*:1.0",
+		),
+		Logger:       clog.NewCompositeLogger(),
+		readAttempts: 3,
+		positions:    make(map[int32]int64),
+		streams:      make(map[string]*kafkaEventStream),
+	}
+	c.DependencyResolver = cref.NewDependencyResolver()
+	c.DependencyResolver.Configure(context.Background(), c.defaultConfig)
+	return c
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaEventLog) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	config = config.SetDefaults(c.defaultConfig)
+	c.config = config
+
+	c.DependencyResolver.Configure(ctx, config)
+	c.Logger.Configure(ctx, config)
+
+	c.topic = config.GetAsStringWithDefault("topic", c.topic)
+	c.readAttempts = config.GetAsIntegerWithDefault("options.read_attempts", c.readAttempts)
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaEventLog) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.references = references
+	c.Logger.SetReferences(ctx, references)
+
+	// Get connection
+	c.DependencyResolver.SetReferences(ctx, references)
+	result := c.DependencyResolver.GetOneOptional("connection")
+	if dep, ok := result.(connect.IKafkaConnection); ok {
+		c.Connection = dep
+	}
+	// Or create a local one
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	} else {
+		c.localConnection = false
+	}
+}
+
+//	Unsets (clears) previously set references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+func (c *KafkaEventLog) UnsetReferences(ctx context.Context) {
+	c.Connection = nil
+}
+
+func (c *KafkaEventLog) createConnection() *connect.KafkaConnection {
+	connection := connect.NewKafkaConnection()
+	connection.AllowOptions("read_attempts")
+
+	if c.config != nil {
+		connection.Configure(context.Background(), c.config)
+	}
+
+	if c.references != nil {
+		connection.SetReferences(context.Background(), c.references)
+	}
+	return connection
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaEventLog) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.opened
+}
+
+//	Opens the component and indexes streams of the topic.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaEventLog) Open(ctx context.Context, correlationId string) error {
+	if c.IsOpen() {
+		return nil
+	}
+
+	if c.topic == "" {
+		return cerr.NewConfigError(correlationId, "NO_TOPIC", "Topic is not set")
+	}
+
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	}
+
+	if c.localConnection {
+		err := c.Connection.Open(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !c.Connection.IsOpen() {
+		return cerr.NewConnectionError(correlationId, "CONNECT_FAILED", "Kafka connection is not opened")
+	}
+
+	partitions, err := c.Connection.ReadPartitions(c.topic)
+	if err != nil {
+		return err
+	}
+	if len(partitions) == 0 {
+		return cerr.NewNotFoundError(correlationId, "TOPIC_NOT_FOUND", "Event log topic "+c.topic+" does not exist").
+			WithDetails("topic", c.topic)
+	}
+
+	c.lock.Lock()
+	c.partitions = partitions
+	c.positions = make(map[int32]int64)
+	c.streams = make(map[string]*kafkaEventStream)
+	c.lock.Unlock()
+
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	for _, partition := range partitions {
+		_, err = c.readPartition(correlationId, partition, -1)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.lock.Lock()
+	c.opened = true
+	c.lock.Unlock()
+	return nil
+}
+
+//	Closes component and frees used resources.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaEventLog) Close(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.opened {
+		return nil
+	}
+
+	if c.localConnection {
+		err := c.Connection.Close(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.opened = false
+	return nil
+}
+
+//	Appends events to a stream.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- streamId string	a stream id
+//		- expectedVersion int64	the last version of the stream known to the caller, 0 for a new stream or AnyVersion to skip the check
+//		- events []*KafkaEvent	events to be appended
+//	Returns: the new version of the stream or error. Outdated expected versions fail with VERSION_CONFLICT.
+func (c *KafkaEventLog) Append(ctx context.Context, correlationId string, streamId string,
+	expectedVersion int64, events []*KafkaEvent) (int64, error) {
+
+	if !c.IsOpen() {
+		return 0, cerr.NewInvalidStateError(correlationId, "NOT_OPENED", "The event log is not opened")
+	}
+	if len(events) == 0 {
+		return c.GetVersion(streamId), nil
+	}
+
+	now := time.Now()
+	batch := &kafkaEventBatch{
+		StreamId:        streamId,
+		ExpectedVersion: expectedVersion,
+		Events:          make([]*KafkaEvent, len(events)),
+	}
+	for i, event := range events {
+		batch.Events[i] = &KafkaEvent{Type: event.Type, Data: event.Data, Time: now}
+	}
+	value, err := json.Marshal(batch)
+	if err != nil {
+		return 0, err
+	}
+
+	partition := c.streamPartition(streamId)
+	msg := &kafka.ProducerMessage{
+		Key:       kafka.StringEncoder(streamId),
+		Value:     kafka.ByteEncoder(value),
+		Partition: partition,
+		Timestamp: now,
+	}
+	err = c.Connection.Publish(ctx, c.topic, []*kafka.ProducerMessage{msg})
+	if err != nil {
+		return 0, err
+	}
+
+	// Read the batch back to learn if it was accepted
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	read, err := c.readPartition(correlationId, partition, msg.Offset)
+	if err != nil {
+		return 0, err
+	}
+	if !read {
+		return 0, cerr.NewInternalError(correlationId, "EVENT_LOG_READ_FAILED", "Failed to read events from topic "+c.topic).
+			WithDetails("partition", partition).WithDetails("offset", msg.Offset)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	stream := c.streams[streamId]
+	if stream != nil {
+		for i := len(stream.batches) - 1; i >= 0; i-- {
+			if stream.batches[i].offset == msg.Offset {
+				return stream.batches[i].lastVersion, nil
+			}
+		}
+	}
+
+	version := int64(0)
+	if stream != nil {
+		version = stream.version
+	}
+	return 0, cerr.NewConflictError(correlationId, "VERSION_CONFLICT", "Stream "+streamId+" was changed concurrently").
+		WithDetails("stream_id", streamId).WithDetails("expected_version", expectedVersion).
+		WithDetails("version", version)
+}
+
+//	Reads events of a stream.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- streamId string	a stream id
+//		- fromVersion int64	a version of the first event to read
+//	Returns: events ordered by versions or error.
+func (c *KafkaEventLog) ReadStream(ctx context.Context, correlationId string, streamId string,
+	fromVersion int64) ([]*KafkaEvent, error) {
+
+	if !c.IsOpen() {
+		return nil, cerr.NewInvalidStateError(correlationId, "NOT_OPENED", "The event log is not opened")
+	}
+
+	// Catch up with appends of other instances
+	partition := c.streamPartition(streamId)
+	c.readLock.Lock()
+	_, err := c.readPartition(correlationId, partition, -1)
+	c.readLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	batches := []kafkaEventBatchRef{}
+	if stream := c.streams[streamId]; stream != nil {
+		for _, batch := range stream.batches {
+			if batch.lastVersion >= fromVersion {
+				batches = append(batches, batch)
+			}
+		}
+	}
+	c.lock.Unlock()
+
+	events := []*KafkaEvent{}
+	for _, ref := range batches {
+		messages, err := c.Connection.ReadMessages(c.topic, partition, ref.offset, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(messages) == 0 || messages[0].Offset != ref.offset {
+			return nil, cerr.NewInternalError(correlationId, "EVENTS_NOT_FOUND", "Events of stream "+streamId+" were removed from topic "+c.topic).
+				WithDetails("stream_id", streamId).WithDetails("offset", ref.offset)
+		}
+
+		batch := &kafkaEventBatch{}
+		err = json.Unmarshal(messages[0].Value, batch)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, event := range batch.Events {
+			event.Version = ref.firstVersion + int64(i)
+			if event.Version >= fromVersion {
+				events = append(events, event)
+			}
+		}
+	}
+
+	return events, nil
+}
+
+//	Gets the last known version of a stream.
+//	Parameters:
+//		- streamId string	a stream id
+//	Returns: the stream version or 0 if the stream doesn't exist.
+func (c *KafkaEventLog) GetVersion(streamId string) int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if stream := c.streams[streamId]; stream != nil {
+		return stream.version
+	}
+	return 0
+}
+
+func (c *KafkaEventLog) streamPartition(streamId string) int32 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return connect.KeyPartition(streamId, len(c.partitions))
+}
+
+// Indexes records of the partition up to the end or to the offset if it is not negative.
+// Returns true if the offset was reached.
+func (c *KafkaEventLog) readPartition(correlationId string, partition int32, offset int64) (bool, error) {
+	c.lock.Lock()
+	position, ok := c.positions[partition]
+	c.lock.Unlock()
+	if !ok {
+		position = kafka.OffsetOldest
+	}
+
+	attempts := 0
+	for offset < 0 || position <= offset {
+		messages, err := c.Connection.ReadMessages(c.topic, partition, position, 1000)
+		if err != nil {
+			return false, err
+		}
+
+		if len(messages) == 0 {
+			attempts++
+			if offset < 0 || attempts >= c.readAttempts {
+				return offset < 0, nil
+			}
+			continue
+		}
+
+		c.lock.Lock()
+		for _, msg := range messages {
+			c.apply(msg)
+			position = msg.Offset + 1
+		}
+		c.positions[partition] = position
+		c.lock.Unlock()
+	}
+
+	return true, nil
+}
+
+// Accepts or rejects a batch of events like every other log instance does
+func (c *KafkaEventLog) apply(msg *kafka.ConsumerMessage) {
+	batch := &kafkaEventBatch{}
+	if err := json.Unmarshal(msg.Value, batch); err != nil || len(batch.Events) == 0 {
+		return
+	}
+
+	stream := c.streams[batch.StreamId]
+	if stream == nil {
+		stream = &kafkaEventStream{}
+		c.streams[batch.StreamId] = stream
+	}
+
+	if batch.ExpectedVersion != AnyVersion && batch.ExpectedVersion != stream.version {
+		return
+	}
+
+	stream.batches = append(stream.batches, kafkaEventBatchRef{
+		offset:       msg.Offset,
+		firstVersion: stream.version + 1,
+		lastVersion:  stream.version + int64(len(batch.Events)),
+	})
+	stream.version += int64(len(batch.Events))
+}
@@ -0,0 +1,474 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+)
+
+//	KafkaKeyValueStore materializes a compacted Kafka topic into an in-memory map.
+//	It is a minimal table for configuration and reference data shared between services.
+//	Writes are produced to the topic and applied after they are read back,
+//	so all store instances see changes in the same order.
+//	Changes made by other instances are picked up by periodic refreshes.
+//
+//	The topic must exist and should be compacted, so it keeps only the last value of every key.
+//
+//	Configuration parameters:
+//
+//		- topic:                         store topic name
+//		- connection(s):
+//			- discovery_key:               (optional) a key to retrieve the connection from  IDiscovery
+//			- host:                        host name or IP address
+//			- port:                        port number
+//			- uri:                         resource URI or connection string with all parameters in it
+//		- credential(s):
+//			- store_key:                   (optional) a key to retrieve the credentials from  ICredentialStore
+//			- username:                    user name
+//			- password:                    user password
+//		- options:
+//			- refresh_interval:            (optional) interval in milliseconds to read changes from the topic, 0 to disable (default: 1000)
+//			- read_attempts:               (optional) number of attempts to read own writes from the topic (default: 3)
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
+//		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
+//		- *:connection:kafka:*:1.0      (optional) Shared connection to Kafka service
+//
+//	Example:
+//		store := NewKafkaKeyValueStore()
+//		store.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"topic", "settings",
+//			"connection.host", "localhost",
+//			"connection.port", 9092,
+//		))
+//		_ = store.Open(ctx, "123")
+//
+//		err := store.Put(ctx, "123", "feature.enabled", []byte("true"))
+//		value, ok := store.Get("feature.enabled")
+type KafkaKeyValueStore struct {
+	defaultConfig   *cconf.ConfigParams
+	config          *cconf.ConfigParams
+	references      cref.IReferences
+	lock            sync.Mutex
+	readLock        sync.Mutex
+	opened          bool
+	localConnection bool
+	cancel          context.CancelFunc
+
+	// The dependency resolver.
+	DependencyResolver *cref.DependencyResolver
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The Kafka connection component.
+	Connection connect.IKafkaConnection
+
+	topic           string
+	refreshInterval time.Duration
+	readAttempts    int
+	partitions      []int32
+	positions       map[int32]int64
+	values          map[string][]byte
+	listeners       []IKafkaKeyValueListener
+}
+
+//	NewKafkaKeyValueStore creates a new instance of the store component.
+//	Returns: *KafkaKeyValueStore
+func NewKafkaKeyValueStore() *KafkaKeyValueStore {
+	c := &KafkaKeyValueStore{
+		defaultConfig: cconf.NewConfigParamsFromTuples(
+			"options.refresh_interval", 1000,
+			"options.read_attempts", 3,
+			"dependencies.connection", "*:connection:kafka:*:1.0",
+		),
+		Logger:          clog.NewCompositeLogger(),
+		refreshInterval: 1000 * time.Millisecond,
+		readAttempts:    3,
+		positions:       make(map[int32]int64),
+		values:          make(map[string][]byte),
+		listeners:       make([]IKafkaKeyValueListener, 0),
+	}
+	c.DependencyResolver = cref.NewDependencyResolver()
+	c.DependencyResolver.Configure(context.Background(), c.defaultConfig)
+	return c
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaKeyValueStore) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	config = config.SetDefaults(c.defaultConfig)
+	c.config = config
+
+	c.DependencyResolver.Configure(ctx, config)
+	c.Logger.Configure(ctx, config)
+
+	c.topic = config.GetAsStringWithDefault("topic", c.topic)
+	c.refreshInterval = time.Duration(config.GetAsIntegerWithDefault("options.refresh_interval",
+		int(c.refreshInterval.Milliseconds()))) * time.Millisecond
+	c.readAttempts = config.GetAsIntegerWithDefault("options.read_attempts", c.readAttempts)
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaKeyValueStore) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.references = references
+	c.Logger.SetReferences(ctx, references)
+
+	// Get connection
+	c.DependencyResolver.SetReferences(ctx, references)
+	result := c.DependencyResolver.GetOneOptional("connection")
+	if dep, ok := result.(connect.IKafkaConnection); ok {
+		c.Connection = dep
+	}
+	// Or create a local one
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	} else {
+		c.localConnection = false
+	}
+}
+
+//	Unsets (clears) previously set references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+func (c *KafkaKeyValueStore) UnsetReferences(ctx context.Context) {
+	c.Connection = nil
+}
+
+func (c *KafkaKeyValueStore) createConnection() *connect.KafkaConnection {
+	connection := connect.NewKafkaConnection()
+	connection.AllowOptions("refresh_interval", "read_attempts")
+
+	if c.config != nil {
+		connection.Configure(context.Background(), c.config)
+	}
+
+	if c.references != nil {
+		connection.SetReferences(context.Background(), c.references)
+	}
+	return connection
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaKeyValueStore) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.opened
+}
+
+//	Opens the component and loads the topic into memory.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaKeyValueStore) Open(ctx context.Context, correlationId string) error {
+	if c.IsOpen() {
+		return nil
+	}
+
+	if c.topic == "" {
+		return cerr.NewConfigError(correlationId, "NO_TOPIC", "Topic is not set")
+	}
+
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	}
+
+	if c.localConnection {
+		err := c.Connection.Open(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !c.Connection.IsOpen() {
+		return cerr.NewConnectionError(correlationId, "CONNECT_FAILED", "Kafka connection is not opened")
+	}
+
+	partitions, err := c.Connection.ReadPartitions(c.topic)
+	if err != nil {
+		return err
+	}
+	if len(partitions) == 0 {
+		return cerr.NewNotFoundError(correlationId, "TOPIC_NOT_FOUND", "Store topic "+c.topic+" does not exist").
+			WithDetails("topic", c.topic)
+	}
+
+	c.lock.Lock()
+	c.partitions = partitions
+	c.positions = make(map[int32]int64)
+	c.values = make(map[string][]byte)
+	c.lock.Unlock()
+
+	err = c.Refresh(ctx, correlationId)
+	if err != nil {
+		return err
+	}
+
+	refreshCtx, cancel := context.WithCancel(context.Background())
+	c.lock.Lock()
+	c.cancel = cancel
+	c.opened = true
+	c.lock.Unlock()
+
+	if c.refreshInterval > 0 {
+		go c.refresh(refreshCtx, correlationId)
+	}
+
+	return nil
+}
+
+//	Closes component and frees used resources.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaKeyValueStore) Close(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.opened {
+		return nil
+	}
+
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
+
+	if c.localConnection {
+		err := c.Connection.Close(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.opened = false
+	return nil
+}
+
+// Periodically reads changes made by other store instances
+func (c *KafkaKeyValueStore) refresh(ctx context.Context, correlationId string) {
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := c.Refresh(ctx, correlationId)
+			if err != nil {
+				c.Logger.Error(ctx, correlationId, err, "Failed to refresh store from topic %s", c.topic)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+//	Reads all changes from the topic.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaKeyValueStore) Refresh(ctx context.Context, correlationId string) error {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	c.lock.Lock()
+	partitions := c.partitions
+	c.lock.Unlock()
+
+	for _, partition := range partitions {
+		_, err := c.readPartition(ctx, correlationId, partition, -1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//	Gets a value by its key.
+//	Parameters:
+//		- key string	a key
+//	Returns: the value and true if the key exists.
+func (c *KafkaKeyValueStore) Get(key string) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	value, ok := c.values[key]
+	return value, ok
+}
+
+//	Gets all stored keys.
+//	Returns: stored keys.
+func (c *KafkaKeyValueStore) Keys() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+//	Sets a value by its key. The value is produced to the topic
+//	and returned by Get after it is read back.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- key string	a key
+//		- value []byte	a value
+//	Returns: error or nil for success.
+func (c *KafkaKeyValueStore) Put(ctx context.Context, correlationId string, key string, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	return c.write(ctx, correlationId, key, value)
+}
+
+//	Removes a key by producing a tombstone to the topic.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string	(optional) transaction id to trace execution through call chain.
+//		- key string	a key
+//	Returns: error or nil for success.
+func (c *KafkaKeyValueStore) Remove(ctx context.Context, correlationId string, key string) error {
+	return c.write(ctx, correlationId, key, nil)
+}
+
+//	Subscribes a listener to changes of the store.
+//	Parameters:
+//		- listener IKafkaKeyValueListener	a listener to be subscribed
+func (c *KafkaKeyValueStore) Subscribe(listener IKafkaKeyValueListener) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.listeners = append(c.listeners, listener)
+}
+
+//	Unsubscribes a listener from changes of the store.
+//	Parameters:
+//		- listener IKafkaKeyValueListener	a listener to be unsubscribed
+func (c *KafkaKeyValueStore) Unsubscribe(listener IKafkaKeyValueListener) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for i, l := range c.listeners {
+		if l == listener {
+			c.listeners = append(c.listeners[:i], c.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+func (c *KafkaKeyValueStore) write(ctx context.Context, correlationId string, key string, value []byte) error {
+	if !c.IsOpen() {
+		return cerr.NewInvalidStateError(correlationId, "NOT_OPENED", "The store is not opened")
+	}
+
+	c.lock.Lock()
+	partition := connect.KeyPartition(key, len(c.partitions))
+	c.lock.Unlock()
+
+	msg := &kafka.ProducerMessage{
+		Key:       kafka.StringEncoder(key),
+		Partition: partition,
+		Timestamp: time.Now(),
+	}
+	if value != nil {
+		msg.Value = kafka.ByteEncoder(value)
+	}
+
+	err := c.Connection.Publish(ctx, c.topic, []*kafka.ProducerMessage{msg})
+	if err != nil {
+		return err
+	}
+
+	// Read own write back to keep the order of changes
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	read, err := c.readPartition(ctx, correlationId, partition, msg.Offset)
+	if err == nil && !read {
+		err = cerr.NewInternalError(correlationId, "STORE_READ_FAILED", "Failed to read changes from topic "+c.topic).
+			WithDetails("partition", partition).WithDetails("offset", msg.Offset)
+	}
+	return err
+}
+
+// Applies records of the partition up to the end or to the offset if it is not negative.
+// Returns true if the offset was reached.
+func (c *KafkaKeyValueStore) readPartition(ctx context.Context, correlationId string, partition int32, offset int64) (bool, error) {
+	c.lock.Lock()
+	position, ok := c.positions[partition]
+	c.lock.Unlock()
+	if !ok {
+		position = kafka.OffsetOldest
+	}
+
+	attempts := 0
+	for offset < 0 || position <= offset {
+		messages, err := c.Connection.ReadMessages(c.topic, partition, position, 1000)
+		if err != nil {
+			return false, err
+		}
+
+		if len(messages) == 0 {
+			attempts++
+			if offset < 0 || attempts >= c.readAttempts {
+				return offset < 0, nil
+			}
+			continue
+		}
+
+		for _, msg := range messages {
+			c.apply(ctx, msg)
+			position = msg.Offset + 1
+		}
+
+		c.lock.Lock()
+		c.positions[partition] = position
+		c.lock.Unlock()
+	}
+
+	return true, nil
+}
+
+// Applies a record to the map and notifies listeners
+func (c *KafkaKeyValueStore) apply(ctx context.Context, msg *kafka.ConsumerMessage) {
+	key := string(msg.Key)
+
+	c.lock.Lock()
+	if msg.Value == nil {
+		if _, ok := c.values[key]; !ok {
+			c.lock.Unlock()
+			return
+		}
+		delete(c.values, key)
+	} else {
+		c.values[key] = msg.Value
+	}
+	listeners := make([]IKafkaKeyValueListener, len(c.listeners))
+	copy(listeners, c.listeners)
+	c.lock.Unlock()
+
+	for _, listener := range listeners {
+		listener.OnKeyChanged(ctx, key, msg.Value)
+	}
+}
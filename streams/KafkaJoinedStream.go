@@ -0,0 +1,184 @@
+package streams
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
+	store "github.com/pip-services3-gox/pip-services3-kafka-gox/store"
+)
+
+// KafkaStreamJoiner combines records joined by their keys. It returns nil to drop the result.
+// Right records of left joins are nil when there is no match.
+type KafkaStreamJoiner func(ctx context.Context, left *clients.KafkaRecord, right *clients.KafkaRecord) (*clients.KafkaRecord, error)
+
+//	Joins records of two streams with equal keys and timestamps that differ no more than the window.
+//	Every record is joined with all matching records received before it from the other stream.
+//	Records are buffered in memory until the latest record time passes them by more than the window.
+//	Parameters:
+//		- other *KafkaStream	a stream to join with
+//		- window time.Duration	a maximum difference of record timestamps
+//		- joiner KafkaStreamJoiner	combines records of this (left) and the other (right) stream
+//	Returns: a stream of joined records.
+func (c *KafkaStream) Join(other *KafkaStream, window time.Duration, joiner KafkaStreamJoiner) *KafkaStream {
+	joined := newKafkaStream(c.builder)
+	join := &kafkaStreamJoin{
+		window: window,
+		left:   make(map[string][]*clients.KafkaRecord),
+		right:  make(map[string][]*clients.KafkaRecord),
+	}
+
+	left := c.addChild()
+	left.process = func(ctx context.Context, record *clients.KafkaRecord, sink kafkaStreamSink) error {
+		for _, match := range join.add(record, true) {
+			err := joined.emit(ctx, joiner, record, match, sink)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	right := other.addChild()
+	right.process = func(ctx context.Context, record *clients.KafkaRecord, sink kafkaStreamSink) error {
+		for _, match := range join.add(record, false) {
+			err := joined.emit(ctx, joiner, match, record, sink)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return joined
+}
+
+//	Enriches records of the stream with values of a table with equal keys.
+//	Records without matching table values are dropped.
+//	Parameters:
+//		- table *store.KafkaKeyValueStore	a table to look up, see KafkaTopologyBuilder.Table
+//		- joiner KafkaStreamJoiner	combines stream records with table records
+//	Returns: a stream of joined records.
+func (c *KafkaStream) JoinTable(table *store.KafkaKeyValueStore, joiner KafkaStreamJoiner) *KafkaStream {
+	return c.joinTable(table, joiner, false)
+}
+
+//	Enriches records of the stream with values of a table with equal keys.
+//	Records without matching table values are joined with nil.
+//	Parameters:
+//		- table *store.KafkaKeyValueStore	a table to look up, see KafkaTopologyBuilder.Table
+//		- joiner KafkaStreamJoiner	combines stream records with table records
+//	Returns: a stream of joined records.
+func (c *KafkaStream) LeftJoinTable(table *store.KafkaKeyValueStore, joiner KafkaStreamJoiner) *KafkaStream {
+	return c.joinTable(table, joiner, true)
+}
+
+func (c *KafkaStream) joinTable(table *store.KafkaKeyValueStore, joiner KafkaStreamJoiner, keepUnmatched bool) *KafkaStream {
+	child := c.addChild()
+	child.process = func(ctx context.Context, record *clients.KafkaRecord, sink kafkaStreamSink) error {
+		var right *clients.KafkaRecord
+		if value, ok := table.Get(string(record.Key)); ok {
+			right = &clients.KafkaRecord{
+				Key:     record.Key,
+				Value:   value,
+				Headers: map[string]string{},
+			}
+		} else if !keepUnmatched {
+			return nil
+		}
+		return child.emit(ctx, joiner, record, right, sink)
+	}
+	return child
+}
+
+// Passes a joined record to children
+func (c *KafkaStream) emit(ctx context.Context, joiner KafkaStreamJoiner,
+	left *clients.KafkaRecord, right *clients.KafkaRecord, sink kafkaStreamSink) error {
+
+	joined, err := joiner(ctx, left, right)
+	if err != nil || joined == nil {
+		return err
+	}
+	return c.forward(ctx, joined, sink)
+}
+
+//	Gets a table materialized from a compacted topic to join streams with.
+//	The table is opened by the processor and refreshed periodically.
+//	Parameters:
+//		- topic string	a compacted topic name
+//	Returns: the table store.
+func (c *KafkaTopologyBuilder) Table(topic string) *store.KafkaKeyValueStore {
+	table := store.NewKafkaKeyValueStore()
+	table.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"topic", topic,
+	))
+	c.addStore(table)
+	return table
+}
+
+// Buffers of a stream-stream join
+type kafkaStreamJoin struct {
+	lock       sync.Mutex
+	window     time.Duration
+	left       map[string][]*clients.KafkaRecord
+	right      map[string][]*clients.KafkaRecord
+	streamTime time.Time
+}
+
+// Buffers a record and returns matching records of the other stream
+func (c *kafkaStreamJoin) add(record *clients.KafkaRecord, isLeft bool) []*clients.KafkaRecord {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	timestamp := recordTime(record)
+	if timestamp.After(c.streamTime) {
+		c.streamTime = timestamp
+		c.evict(c.left)
+		c.evict(c.right)
+	}
+
+	own, other := c.left, c.right
+	if !isLeft {
+		own, other = c.right, c.left
+	}
+
+	key := string(record.Key)
+	matches := []*clients.KafkaRecord{}
+	for _, candidate := range other[key] {
+		diff := recordTime(candidate).Sub(timestamp)
+		if diff <= c.window && diff >= -c.window {
+			matches = append(matches, candidate)
+		}
+	}
+
+	own[key] = append(own[key], record)
+	return matches
+}
+
+// Removes records that can't be joined anymore
+func (c *kafkaStreamJoin) evict(buffer map[string][]*clients.KafkaRecord) {
+	expired := c.streamTime.Add(-c.window)
+	for key, records := range buffer {
+		kept := make([]*clients.KafkaRecord, 0, len(records))
+		for _, record := range records {
+			if !recordTime(record).Before(expired) {
+				kept = append(kept, record)
+			}
+		}
+		if len(kept) == 0 {
+			delete(buffer, key)
+		} else {
+			buffer[key] = kept
+		}
+	}
+}
+
+// Gets a record timestamp or the current time if it is not set
+func recordTime(record *clients.KafkaRecord) time.Time {
+	if record.Timestamp.IsZero() {
+		return time.Now()
+	}
+	return record.Timestamp
+}
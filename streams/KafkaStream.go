@@ -0,0 +1,134 @@
+package streams
+
+import (
+	"context"
+
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
+)
+
+// KafkaStreamPredicate selects records of a stream
+type KafkaStreamPredicate func(ctx context.Context, record *clients.KafkaRecord) bool
+
+// KafkaStreamMapper transforms records of a stream. It returns nil to drop the record.
+type KafkaStreamMapper func(ctx context.Context, record *clients.KafkaRecord) (*clients.KafkaRecord, error)
+
+// KafkaStreamAction handles records of a stream without changing them
+type KafkaStreamAction func(ctx context.Context, record *clients.KafkaRecord) error
+
+// Produces records to sink topics
+type kafkaStreamSink func(ctx context.Context, topic string, record *clients.KafkaRecord) error
+
+//	KafkaStream is a node of a stream processing topology.
+//	Every operation adds a child node and returns it, so operations are chained.
+//	Records are passed to all children of a node, so mappers shall return new records
+//	instead of changing received ones when a stream has several children.
+type KafkaStream struct {
+	builder  *KafkaTopologyBuilder
+	process  func(ctx context.Context, record *clients.KafkaRecord, sink kafkaStreamSink) error
+	children []*KafkaStream
+}
+
+func newKafkaStream(builder *KafkaTopologyBuilder) *KafkaStream {
+	c := &KafkaStream{
+		builder:  builder,
+		children: make([]*KafkaStream, 0),
+	}
+	c.process = c.forward
+	return c
+}
+
+func (c *KafkaStream) forward(ctx context.Context, record *clients.KafkaRecord, sink kafkaStreamSink) error {
+	for _, child := range c.children {
+		err := child.process(ctx, record, sink)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *KafkaStream) addChild() *KafkaStream {
+	child := newKafkaStream(c.builder)
+	c.children = append(c.children, child)
+	return child
+}
+
+//	Keeps records that match the predicate.
+//	Parameters:
+//		- predicate KafkaStreamPredicate	a predicate to select records
+//	Returns: the filtered stream.
+func (c *KafkaStream) Filter(predicate KafkaStreamPredicate) *KafkaStream {
+	child := c.addChild()
+	child.process = func(ctx context.Context, record *clients.KafkaRecord, sink kafkaStreamSink) error {
+		if predicate(ctx, record) {
+			return child.forward(ctx, record, sink)
+		}
+		return nil
+	}
+	return child
+}
+
+//	Transforms records of the stream.
+//	Parameters:
+//		- mapper KafkaStreamMapper	a record transformation
+//	Returns: the transformed stream.
+func (c *KafkaStream) Map(mapper KafkaStreamMapper) *KafkaStream {
+	child := c.addChild()
+	child.process = func(ctx context.Context, record *clients.KafkaRecord, sink kafkaStreamSink) error {
+		mapped, err := mapper(ctx, record)
+		if err != nil || mapped == nil {
+			return err
+		}
+		return child.forward(ctx, mapped, sink)
+	}
+	return child
+}
+
+//	Splits the stream by predicates. Every record goes to the branch
+//	of the first matching predicate, records that match none are dropped.
+//	Parameters:
+//		- predicates ...KafkaStreamPredicate	predicates of the branches
+//	Returns: branch streams in the order of the predicates.
+func (c *KafkaStream) Branch(predicates ...KafkaStreamPredicate) []*KafkaStream {
+	branches := make([]*KafkaStream, len(predicates))
+	for i := range branches {
+		branches[i] = newKafkaStream(c.builder)
+	}
+
+	router := c.addChild()
+	router.process = func(ctx context.Context, record *clients.KafkaRecord, sink kafkaStreamSink) error {
+		for i, predicate := range predicates {
+			if predicate(ctx, record) {
+				return branches[i].process(ctx, record, sink)
+			}
+		}
+		return nil
+	}
+	return branches
+}
+
+//	Performs an action for every record and passes records further unchanged.
+//	Parameters:
+//		- action KafkaStreamAction	an action to perform
+//	Returns: the same records as a new stream.
+func (c *KafkaStream) Peek(action KafkaStreamAction) *KafkaStream {
+	child := c.addChild()
+	child.process = func(ctx context.Context, record *clients.KafkaRecord, sink kafkaStreamSink) error {
+		err := action(ctx, record)
+		if err != nil {
+			return err
+		}
+		return child.forward(ctx, record, sink)
+	}
+	return child
+}
+
+//	Produces records of the stream to a sink topic.
+//	Parameters:
+//		- topic string	a sink topic name
+func (c *KafkaStream) To(topic string) {
+	child := c.addChild()
+	child.process = func(ctx context.Context, record *clients.KafkaRecord, sink kafkaStreamSink) error {
+		return sink(ctx, topic, record)
+	}
+}
@@ -0,0 +1,357 @@
+package streams
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	ccount "github.com/pip-services3-gox/pip-services3-components-gox/count"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+)
+
+//	KafkaStreamProcessor runs a stream processing topology on a Kafka connection.
+//	Records of source topics are passed through the topology and produced to sink topics.
+//	Source offsets are committed only after records are processed by the whole topology,
+//	so processing resumes from the last committed record after restart.
+//	Failed records are retried until they are processed or the processor is closed.
+//	Close stops consumption and waits for records being processed.
+//	State stores of aggregations are recovered from their changelog topics on open.
+//
+//	Configuration parameters:
+//
+//		- application_id:                (optional) consumer group id of the processor (default: default)
+//		- from_beginning:                (optional) processes topics from the beginning when there are no committed offsets (default: false)
+//		- connection(s):
+//			- discovery_key:               (optional) a key to retrieve the connection from  IDiscovery
+//			- host:                        host name or IP address
+//			- port:                        port number
+//			- uri:                         resource URI or connection string with all parameters in it
+//		- credential(s):
+//			- store_key:                   (optional) a key to retrieve the credentials from  ICredentialStore
+//			- username:                    user name
+//			- password:                    user password
+//		- options:
+//			- retry_timeout:               (optional) timeout in milliseconds between retries of failed records (default: 1000)
+//
+//	References:
+//
+//		- *:logger:*:*:1.0             (optional)  ILogger components to pass log messages
+//		- *:counters:*:*:1.0           (optional)  ICounters components to pass collected measurements
+//		- *:discovery:*:*:1.0          (optional)  IDiscovery services to resolve connections
+//		- *:credential-store:*:*:1.0   (optional) Credential stores to resolve credentials
+//		- *:connection:kafka:*:1.0      (optional) Shared connection to Kafka service
+//
+//	Example:
+//		builder := NewKafkaTopologyBuilder()
+//		builder.Stream("orders").Filter(isValid).To("valid_orders")
+//
+//		processor := NewKafkaStreamProcessor(builder)
+//		processor.Configure(ctx, cconf.NewConfigParamsFromTuples(
+//			"application_id", "order_validator",
+//			"connection.host", "localhost",
+//			"connection.port", 9092,
+//		))
+//		_ = processor.Open(ctx, "123")
+//		...
+//		_ = processor.Close(ctx, "123")
+type KafkaStreamProcessor struct {
+	defaultConfig   *cconf.ConfigParams
+	config          *cconf.ConfigParams
+	references      cref.IReferences
+	lock            sync.Mutex
+	opened          bool
+	localConnection bool
+	cancel          context.CancelFunc
+	processing      sync.WaitGroup
+
+	// The dependency resolver.
+	DependencyResolver *cref.DependencyResolver
+	// The logger.
+	Logger *clog.CompositeLogger
+	// The performance counters.
+	Counters *ccount.CompositeCounters
+	// The Kafka connection component.
+	Connection connect.IKafkaConnection
+	// The consumer of source topics.
+	Consumer *clients.KafkaConsumer
+	// The producer to sink topics.
+	Producer *clients.KafkaProducer
+
+	topology      *KafkaTopologyBuilder
+	applicationId string
+	retryTimeout  time.Duration
+}
+
+//	NewKafkaStreamProcessor creates a new processor of a topology.
+//	Parameters:
+//		- topology *KafkaTopologyBuilder	a topology to run
+//	Returns: *KafkaStreamProcessor
+func NewKafkaStreamProcessor(topology *KafkaTopologyBuilder) *KafkaStreamProcessor {
+	c := &KafkaStreamProcessor{
+		defaultConfig: cconf.NewConfigParamsFromTuples(
+			"application_id", "default",
+			"options.retry_timeout", 1000,
+			"dependencies.connection", "*:connection:kafka:*:1.0",
+		),
+		Logger:        clog.NewCompositeLogger(),
+		Counters:      ccount.NewCompositeCounters(),
+		Consumer:      clients.NewKafkaConsumer(),
+		Producer:      clients.NewKafkaProducer(),
+		topology:      topology,
+		applicationId: "default",
+		retryTimeout:  1000 * time.Millisecond,
+	}
+	c.DependencyResolver = cref.NewDependencyResolver()
+	c.DependencyResolver.Configure(context.Background(), c.defaultConfig)
+	c.configureConsumer(context.Background(), false)
+	return c
+}
+
+// Offsets are committed after records are processed by the topology
+func (c *KafkaStreamProcessor) configureConsumer(ctx context.Context, fromBeginning bool) {
+	c.Consumer.Configure(ctx, cconf.NewConfigParamsFromTuples(
+		"group_id", c.applicationId,
+		"from_beginning", fromBeginning,
+		"autocommit", false,
+	))
+}
+
+//	Configures component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config    configuration parameters to be set.
+func (c *KafkaStreamProcessor) Configure(ctx context.Context, config *cconf.ConfigParams) {
+	config = config.SetDefaults(c.defaultConfig)
+	c.config = config
+
+	c.DependencyResolver.Configure(ctx, config)
+	c.Logger.Configure(ctx, config)
+
+	c.applicationId = config.GetAsStringWithDefault("application_id", c.applicationId)
+	c.retryTimeout = time.Duration(config.GetAsIntegerWithDefault("options.retry_timeout",
+		int(c.retryTimeout.Milliseconds()))) * time.Millisecond
+
+	c.configureConsumer(ctx, config.GetAsBoolean("from_beginning"))
+}
+
+//	Sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references 	references to locate the component dependencies.
+func (c *KafkaStreamProcessor) SetReferences(ctx context.Context, references cref.IReferences) {
+	c.references = references
+	c.Logger.SetReferences(ctx, references)
+	c.Counters.SetReferences(ctx, references)
+	c.Consumer.Logger.SetReferences(ctx, references)
+	c.Consumer.Counters.SetReferences(ctx, references)
+	c.Producer.Logger.SetReferences(ctx, references)
+	c.Producer.Counters.SetReferences(ctx, references)
+
+	// Get connection
+	c.DependencyResolver.SetReferences(ctx, references)
+	result := c.DependencyResolver.GetOneOptional("connection")
+	if dep, ok := result.(connect.IKafkaConnection); ok {
+		c.Connection = dep
+	}
+	// Or create a local one
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	} else {
+		c.localConnection = false
+	}
+}
+
+//	Unsets (clears) previously set references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+func (c *KafkaStreamProcessor) UnsetReferences(ctx context.Context) {
+	c.Connection = nil
+}
+
+func (c *KafkaStreamProcessor) createConnection() *connect.KafkaConnection {
+	connection := connect.NewKafkaConnection()
+
+	if c.config != nil {
+		connection.Configure(context.Background(), c.config)
+	}
+
+	if c.references != nil {
+		connection.SetReferences(context.Background(), c.references)
+	}
+	return connection
+}
+
+//	Checks if the component is opened.
+//	Returns true if the component has been opened and false otherwise.
+func (c *KafkaStreamProcessor) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.opened
+}
+
+//	Opens the component and starts processing.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaStreamProcessor) Open(ctx context.Context, correlationId string) error {
+	if c.IsOpen() {
+		return nil
+	}
+
+	topics := c.topology.Topics()
+	if len(topics) == 0 {
+		return cerr.NewConfigError(correlationId, "NO_SOURCES", "Topology has no source topics")
+	}
+
+	if c.Connection == nil {
+		c.Connection = c.createConnection()
+		c.localConnection = true
+	}
+
+	if c.localConnection {
+		err := c.Connection.Open(ctx, correlationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.Consumer.Connection = c.Connection
+	c.Producer.Connection = c.Connection
+
+	// Recover state of the topology
+	for _, state := range c.topology.Stores() {
+		if state.Connection == nil {
+			state.Connection = c.Connection
+		}
+		err := state.Open(ctx, correlationId)
+		if err != nil {
+			c.closeStores(ctx, correlationId)
+			c.closeConnection(ctx, correlationId)
+			return err
+		}
+	}
+
+	err := c.Producer.Open(ctx, correlationId)
+	if err != nil {
+		c.closeStores(ctx, correlationId)
+		c.closeConnection(ctx, correlationId)
+		return err
+	}
+
+	err = c.Consumer.Open(ctx, correlationId)
+	if err != nil {
+		_ = c.Producer.Close(ctx, correlationId)
+		c.closeStores(ctx, correlationId)
+		c.closeConnection(ctx, correlationId)
+		return err
+	}
+
+	processCtx, cancel := context.WithCancel(context.Background())
+	c.lock.Lock()
+	c.cancel = cancel
+	c.opened = true
+	c.lock.Unlock()
+
+	err = c.Consumer.Subscribe(ctx, correlationId, topics,
+		func(ctx context.Context, record *clients.KafkaRecord) error {
+			return c.processRecord(processCtx, ctx, record)
+		})
+	if err != nil {
+		_ = c.Close(ctx, correlationId)
+		return err
+	}
+
+	c.Logger.Info(ctx, correlationId, "Started stream processor %s", c.applicationId)
+	return nil
+}
+
+//	Closes component. It stops consumption and waits for records being processed.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId 	(optional) transaction id to trace execution through call chain.
+//	Returns: error or nil no errors occured.
+func (c *KafkaStreamProcessor) Close(ctx context.Context, correlationId string) error {
+	c.lock.Lock()
+	if !c.opened {
+		c.lock.Unlock()
+		return nil
+	}
+	c.opened = false
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
+	c.lock.Unlock()
+
+	err := c.Consumer.Close(ctx, correlationId)
+
+	// Wait for records being processed
+	c.processing.Wait()
+
+	if closeErr := c.Producer.Close(ctx, correlationId); err == nil {
+		err = closeErr
+	}
+	c.closeStores(ctx, correlationId)
+	c.closeConnection(ctx, correlationId)
+
+	c.Logger.Info(ctx, correlationId, "Stopped stream processor %s", c.applicationId)
+	return err
+}
+
+func (c *KafkaStreamProcessor) closeStores(ctx context.Context, correlationId string) {
+	for _, state := range c.topology.Stores() {
+		err := state.Close(ctx, correlationId)
+		if err != nil {
+			c.Logger.Error(ctx, correlationId, err, "Failed to close state store")
+		}
+	}
+}
+
+func (c *KafkaStreamProcessor) closeConnection(ctx context.Context, correlationId string) {
+	if c.localConnection {
+		err := c.Connection.Close(ctx, correlationId)
+		if err != nil {
+			c.Logger.Error(ctx, correlationId, err, "Failed to close Kafka connection")
+		}
+	}
+}
+
+// Passes a record through the topology and commits it
+func (c *KafkaStreamProcessor) processRecord(processCtx context.Context, ctx context.Context, record *clients.KafkaRecord) error {
+	c.processing.Add(1)
+	defer c.processing.Done()
+
+	source := c.topology.Stream(record.Topic)
+	for {
+		err := source.process(ctx, record, c.sink)
+		if err == nil {
+			break
+		}
+
+		c.Logger.Error(ctx, "", err, "Failed to process record %d:%d from %s", record.Partition, record.Offset, record.Topic)
+		c.Counters.IncrementOne(ctx, "streams."+c.applicationId+".failed_records")
+
+		// Retry until the record is processed to keep the order of records
+		select {
+		case <-time.After(c.retryTimeout):
+		case <-ctx.Done():
+			return err
+		case <-processCtx.Done():
+			return err
+		}
+	}
+
+	c.Counters.IncrementOne(ctx, "streams."+c.applicationId+".processed_records")
+	return c.Consumer.Commit(ctx, record)
+}
+
+func (c *KafkaStreamProcessor) sink(ctx context.Context, topic string, record *clients.KafkaRecord) error {
+	return c.Producer.Send(ctx, "", topic, string(record.Key), record.Headers, record.Value)
+}
@@ -0,0 +1,65 @@
+package streams
+
+import (
+	"sort"
+
+	store "github.com/pip-services3-gox/pip-services3-kafka-gox/store"
+)
+
+//	KafkaTopologyBuilder builds a stream processing topology
+//	from source topics through map, filter and branch operations to sink topics.
+//	The topology is run by KafkaStreamProcessor.
+//
+//	Example:
+//		builder := NewKafkaTopologyBuilder()
+//		orders := builder.Stream("orders").Filter(isValid)
+//		branches := orders.Branch(isDomestic, isInternational)
+//		branches[0].Map(toShipment).To("domestic_shipments")
+//		branches[1].Map(toShipment).To("international_shipments")
+type KafkaTopologyBuilder struct {
+	sources map[string]*KafkaStream
+	stores  []*store.KafkaKeyValueStore
+}
+
+//	NewKafkaTopologyBuilder creates a new topology builder.
+//	Returns: *KafkaTopologyBuilder
+func NewKafkaTopologyBuilder() *KafkaTopologyBuilder {
+	return &KafkaTopologyBuilder{
+		sources: make(map[string]*KafkaStream),
+		stores:  make([]*store.KafkaKeyValueStore, 0),
+	}
+}
+
+//	Gets a stream of records from a source topic.
+//	Parameters:
+//		- topic string	a source topic name
+//	Returns: the source stream.
+func (c *KafkaTopologyBuilder) Stream(topic string) *KafkaStream {
+	source, ok := c.sources[topic]
+	if !ok {
+		source = newKafkaStream(c)
+		c.sources[topic] = source
+	}
+	return source
+}
+
+//	Gets source topics of the topology.
+//	Returns: sorted topic names.
+func (c *KafkaTopologyBuilder) Topics() []string {
+	topics := make([]string, 0, len(c.sources))
+	for topic := range c.sources {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+//	Gets state stores of the topology. They are opened by the processor.
+//	Returns: state stores.
+func (c *KafkaTopologyBuilder) Stores() []*store.KafkaKeyValueStore {
+	return c.stores
+}
+
+func (c *KafkaTopologyBuilder) addStore(state *store.KafkaKeyValueStore) {
+	c.stores = append(c.stores, state)
+}
@@ -0,0 +1,64 @@
+package streams
+
+import (
+	"time"
+)
+
+//	KafkaWindow defines time windows of stream aggregations.
+//	Tumbling windows don't overlap, hopping windows of the same size
+//	start every advance interval and overlap.
+type KafkaWindow struct {
+	// Window size
+	Size time.Duration
+	// Interval between starts of windows, equal to the size for tumbling windows
+	Advance time.Duration
+	// Time to accept late records after the window end. Closed windows are removed from the state.
+	Grace time.Duration
+}
+
+//	NewTumblingWindow creates non-overlapping windows.
+//	Parameters:
+//		- size time.Duration	a window size
+//	Returns: *KafkaWindow
+func NewTumblingWindow(size time.Duration) *KafkaWindow {
+	return &KafkaWindow{
+		Size:    size,
+		Advance: size,
+	}
+}
+
+//	NewHoppingWindow creates overlapping windows.
+//	Parameters:
+//		- size time.Duration	a window size
+//		- advance time.Duration	an interval between starts of windows
+//	Returns: *KafkaWindow
+func NewHoppingWindow(size time.Duration, advance time.Duration) *KafkaWindow {
+	return &KafkaWindow{
+		Size:    size,
+		Advance: advance,
+	}
+}
+
+//	Sets the time to accept late records.
+//	Parameters:
+//		- grace time.Duration	a time after the window end
+//	Returns: the same window.
+func (c *KafkaWindow) WithGrace(grace time.Duration) *KafkaWindow {
+	c.Grace = grace
+	return c
+}
+
+// Gets starts of windows that contain the time
+func (c *KafkaWindow) windowStarts(t time.Time) []time.Time {
+	advance := c.Advance
+	if advance <= 0 || advance > c.Size {
+		advance = c.Size
+	}
+
+	last := t.Truncate(advance)
+	starts := []time.Time{}
+	for start := last; start.Add(c.Size).After(t); start = start.Add(-advance) {
+		starts = append(starts, start)
+	}
+	return starts
+}
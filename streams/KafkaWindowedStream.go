@@ -0,0 +1,214 @@
+package streams
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
+	store "github.com/pip-services3-gox/pip-services3-kafka-gox/store"
+)
+
+// Headers with bounds of aggregation windows in Unix milliseconds
+const (
+	WindowStartHeader = "window_start"
+	WindowEndHeader   = "window_end"
+)
+
+// KafkaStreamAggregator adds a record to the aggregate of a window. The first aggregate is nil.
+type KafkaStreamAggregator func(ctx context.Context, aggregate []byte, record *clients.KafkaRecord) ([]byte, error)
+
+// KafkaWindowedStream is a stream grouped by record keys into time windows
+type KafkaWindowedStream struct {
+	builder *KafkaTopologyBuilder
+	stream  *KafkaStream
+	window  *KafkaWindow
+}
+
+//	Groups records of the stream by their keys into time windows.
+//	Records are assigned to windows by their timestamps.
+//	Parameters:
+//		- window *KafkaWindow	windows of the aggregation
+//	Returns: the windowed stream.
+func (c *KafkaStream) WindowedBy(window *KafkaWindow) *KafkaWindowedStream {
+	return &KafkaWindowedStream{
+		builder: c.builder,
+		stream:  c,
+		window:  window,
+	}
+}
+
+//	Aggregates records of every key and window. Aggregates are persisted to a compacted
+//	changelog topic, so they are recovered after restarts. Every record emits updated
+//	aggregates of its windows with the record key and window bounds in headers.
+//	Windows are closed when the latest record time passes their end and grace period,
+//	late records of closed windows are dropped.
+//	Parameters:
+//		- changelog string	a compacted topic to persist aggregates
+//		- aggregator KafkaStreamAggregator	adds records to aggregates
+//	Returns: a stream of updated aggregates.
+func (c *KafkaWindowedStream) Aggregate(changelog string, aggregator KafkaStreamAggregator) *KafkaStream {
+	state := store.NewKafkaKeyValueStore()
+	state.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"topic", changelog,
+		"options.refresh_interval", 0,
+	))
+	c.builder.addStore(state)
+
+	aggregation := &kafkaWindowAggregation{
+		window:     c.window,
+		state:      state,
+		aggregator: aggregator,
+	}
+
+	child := c.stream.addChild()
+	child.process = func(ctx context.Context, record *clients.KafkaRecord, sink kafkaStreamSink) error {
+		results, err := aggregation.add(ctx, record)
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			err = child.forward(ctx, result, sink)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return child
+}
+
+// State of a windowed aggregation
+type kafkaWindowAggregation struct {
+	lock       sync.Mutex
+	window     *KafkaWindow
+	state      *store.KafkaKeyValueStore
+	aggregator KafkaStreamAggregator
+	// Starts of stored windows by record keys
+	windows map[string][]int64
+	// The latest record time seen by the aggregation
+	streamTime time.Time
+}
+
+func windowStateKey(key string, start int64) string {
+	return key + "@" + strconv.FormatInt(start, 10)
+}
+
+// Indexes windows recovered from the changelog
+func (c *kafkaWindowAggregation) loadWindows() {
+	c.windows = make(map[string][]int64)
+	for _, stateKey := range c.state.Keys() {
+		index := strings.LastIndex(stateKey, "@")
+		if index < 0 {
+			continue
+		}
+		start, err := strconv.ParseInt(stateKey[index+1:], 10, 64)
+		if err != nil {
+			continue
+		}
+		key := stateKey[:index]
+		c.windows[key] = append(c.windows[key], start)
+
+		// Recovered windows are still open, so the stream time is not earlier than their starts
+		if time.UnixMilli(start).After(c.streamTime) {
+			c.streamTime = time.UnixMilli(start)
+		}
+	}
+	for key := range c.windows {
+		sort.Slice(c.windows[key], func(i, j int) bool { return c.windows[key][i] < c.windows[key][j] })
+	}
+}
+
+func (c *kafkaWindowAggregation) add(ctx context.Context, record *clients.KafkaRecord) ([]*clients.KafkaRecord, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.windows == nil {
+		c.loadWindows()
+	}
+
+	timestamp := recordTime(record)
+	key := string(record.Key)
+	if timestamp.After(c.streamTime) {
+		c.streamTime = timestamp
+	}
+
+	// Remove windows closed for this key
+	err := c.evict(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*clients.KafkaRecord{}
+	for _, start := range c.window.windowStarts(timestamp) {
+		end := start.Add(c.window.Size)
+		// Skip late records of closed windows
+		if !end.Add(c.window.Grace).After(c.streamTime) {
+			continue
+		}
+
+		stateKey := windowStateKey(key, start.UnixMilli())
+		aggregate, exists := c.state.Get(stateKey)
+		aggregate, err := c.aggregator(ctx, aggregate, record)
+		if err != nil {
+			return nil, err
+		}
+
+		err = c.state.Put(ctx, "", stateKey, aggregate)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			c.windows[key] = append(c.windows[key], start.UnixMilli())
+		}
+
+		headers := make(map[string]string, len(record.Headers)+2)
+		for name, value := range record.Headers {
+			headers[name] = value
+		}
+		headers[WindowStartHeader] = strconv.FormatInt(start.UnixMilli(), 10)
+		headers[WindowEndHeader] = strconv.FormatInt(end.UnixMilli(), 10)
+
+		results = append(results, &clients.KafkaRecord{
+			Topic:     record.Topic,
+			Partition: record.Partition,
+			Offset:    record.Offset,
+			Key:       record.Key,
+			Value:     aggregate,
+			Headers:   headers,
+			Timestamp: timestamp,
+		})
+	}
+
+	return results, nil
+}
+
+func (c *kafkaWindowAggregation) evict(ctx context.Context, key string) error {
+	starts := c.windows[key]
+	kept := make([]int64, 0, len(starts))
+	for i, start := range starts {
+		end := time.UnixMilli(start).Add(c.window.Size).Add(c.window.Grace)
+		if end.After(c.streamTime) {
+			kept = append(kept, start)
+			continue
+		}
+
+		err := c.state.Remove(ctx, "", windowStateKey(key, start))
+		if err != nil {
+			// Keep windows that were not removed
+			c.windows[key] = append(kept, starts[i:]...)
+			return err
+		}
+	}
+
+	if len(kept) == 0 {
+		delete(c.windows, key)
+	} else {
+		c.windows[key] = kept
+	}
+	return nil
+}
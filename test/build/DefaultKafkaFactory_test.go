@@ -0,0 +1,33 @@
+package test_build
+
+import (
+	"testing"
+
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	build "github.com/pip-services3-gox/pip-services3-kafka-gox/build"
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultKafkaFactoryMemoryQueue(t *testing.T) {
+	factory := build.NewDefaultKafkaFactory()
+	descriptor := cref.NewDescriptor("pip-services", "message-queue", "memory-kafka", "test", "1.0")
+
+	comp, err := factory.Create(descriptor)
+	assert.Nil(t, err)
+	assert.NotNil(t, comp)
+
+	queue := comp.(*queues.MemoryKafkaMessageQueue)
+	assert.Equal(t, "test", queue.Name())
+}
+
+func TestDefaultKafkaFactoryPriorityQueue(t *testing.T) {
+	factory := build.NewDefaultKafkaFactory()
+	descriptor := cref.NewDescriptor("pip-services", "message-queue", "priority-kafka", "test", "1.0")
+
+	comp, err := factory.Create(descriptor)
+	assert.Nil(t, err)
+
+	queue := comp.(*queues.PriorityKafkaMessageQueue)
+	assert.Equal(t, "test", queue.Name())
+}
@@ -0,0 +1,72 @@
+package test_clients
+
+import (
+	"context"
+	"testing"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	"github.com/stretchr/testify/assert"
+)
+
+type testPrice struct {
+	ProductId string  `json:"product_id"`
+	Price     float64 `json:"price"`
+}
+
+func TestKafkaClient(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	_ = connection.Open(ctx, "")
+
+	server := clients.NewKafkaRequestReplyServer()
+	server.Configure(ctx, cconf.NewConfigParamsFromTuples("topic", "v1.prices"))
+	server.Consumer.Connection = connection
+	server.SetHandler(func(ctx context.Context, request *clients.KafkaRecord) ([]byte, error) {
+		if request.Headers[clients.CommandHeader] != "get_price" {
+			return nil, cerr.NewBadRequestError("", "CMD_NOT_FOUND", "Requested command does not exist")
+		}
+		return []byte(`{"product_id": "1", "price": 10}`), nil
+	})
+	err := server.Open(ctx, "")
+	assert.Nil(t, err)
+	defer server.Close(ctx, "")
+
+	client := clients.NewKafkaClient("v1.prices")
+	client.Configure(ctx, cconf.NewConfigParamsFromTuples("options.timeout", 200))
+	client.Client.Consumer.Connection = connection
+	err = client.Open(ctx, "")
+	assert.Nil(t, err)
+	defer client.Close(ctx, "")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		price, err := clients.HandleKafkaReply[*testPrice](
+			client.CallCommand(ctx, "123", "get_price", map[string]any{"product_id": "1"}))
+		assert.Nil(t, err)
+		assert.Equal(t, &testPrice{ProductId: "1", Price: 10}, price)
+	}()
+	deliverPublished(t, connection, "v1.prices", 0)
+	deliverPublished(t, connection, "v1.prices.replies", 0)
+	<-done
+
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := client.CallCommand(ctx, "123", "get_cost", nil)
+		assert.NotNil(t, err)
+		assert.Equal(t, "CMD_NOT_FOUND", err.(*cerr.ApplicationError).Code)
+	}()
+	deliverPublished(t, connection, "v1.prices", 1)
+	deliverPublished(t, connection, "v1.prices.replies", 1)
+	<-done
+
+	// Results that are not received in time fail the call
+	_, err = client.CallCommand(ctx, "123", "get_price", nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, "REPLY_TIMEOUT", err.(*cerr.ApplicationError).Code)
+	assert.Len(t, connection.GetPublished("v1.prices"), 3)
+}
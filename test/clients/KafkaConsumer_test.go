@@ -0,0 +1,121 @@
+package test_clients
+
+import (
+	"context"
+	"testing"
+
+	kafka "github.com/Shopify/sarama"
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSession struct {
+	ctx       context.Context
+	marked    []int64
+	reset     []int64
+	committed int
+}
+
+func (c *fakeSession) Claims() map[string][]int32 { return nil }
+func (c *fakeSession) MemberID() string           { return "" }
+func (c *fakeSession) GenerationID() int32        { return 0 }
+func (c *fakeSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+}
+func (c *fakeSession) Commit() { c.committed++ }
+func (c *fakeSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	c.reset = append(c.reset, offset)
+}
+func (c *fakeSession) MarkMessage(msg *kafka.ConsumerMessage, metadata string) {
+	c.marked = append(c.marked, msg.Offset)
+}
+func (c *fakeSession) Context() context.Context { return c.ctx }
+
+type fakeClaim struct {
+	messages chan *kafka.ConsumerMessage
+}
+
+func (c *fakeClaim) Topic() string                           { return "orders" }
+func (c *fakeClaim) Partition() int32                        { return 0 }
+func (c *fakeClaim) InitialOffset() int64                    { return 0 }
+func (c *fakeClaim) HighWaterMarkOffset() int64              { return 0 }
+func (c *fakeClaim) Messages() <-chan *kafka.ConsumerMessage { return c.messages }
+
+func TestKafkaConsumerSubscribe(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	_ = connection.Open(ctx, "")
+
+	consumer := clients.NewKafkaConsumer()
+	consumer.Connection = connection
+
+	handler := func(ctx context.Context, record *clients.KafkaRecord) error { return nil }
+
+	err := consumer.Subscribe(ctx, "", []string{"orders"}, handler)
+	assert.NotNil(t, err)
+
+	err = consumer.Open(ctx, "")
+	assert.Nil(t, err)
+
+	err = consumer.Subscribe(ctx, "", []string{"orders", "payments"}, handler)
+	assert.Nil(t, err)
+	assert.Len(t, connection.Listeners, 2)
+	assert.ElementsMatch(t, []string{"orders", "payments"}, consumer.Topics())
+
+	err = consumer.Subscribe(ctx, "", []string{"orders"}, handler)
+	assert.NotNil(t, err)
+
+	err = consumer.Unsubscribe(ctx, "", []string{"payments"})
+	assert.Nil(t, err)
+	assert.Len(t, connection.Listeners, 1)
+
+	err = consumer.Close(ctx, "")
+	assert.Nil(t, err)
+	assert.Len(t, connection.Listeners, 0)
+}
+
+func TestKafkaConsumerRecords(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	_ = connection.Open(ctx, "")
+
+	consumer := clients.NewKafkaConsumer()
+	consumer.Connection = connection
+	_ = consumer.Open(ctx, "")
+	defer consumer.Close(ctx, "")
+
+	records := make([]*clients.KafkaRecord, 0)
+	err := consumer.Subscribe(ctx, "", []string{"orders"}, func(ctx context.Context, record *clients.KafkaRecord) error {
+		records = append(records, record)
+		if record.Offset == 2 {
+			return assert.AnError
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+
+	session := &fakeSession{ctx: ctx}
+	claim := &fakeClaim{messages: make(chan *kafka.ConsumerMessage, 2)}
+	claim.messages <- &kafka.ConsumerMessage{
+		Topic: "orders", Partition: 3, Offset: 1, Key: []byte("1"), Value: []byte("A"),
+		Headers: []*kafka.RecordHeader{{Key: []byte("type"), Value: []byte("created")}},
+	}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "orders", Partition: 3, Offset: 2, Value: []byte("B")}
+	close(claim.messages)
+
+	err = connection.Listeners["orders"].ConsumeClaim(session, claim)
+	assert.Nil(t, err)
+
+	assert.Len(t, records, 2)
+	assert.Equal(t, int32(3), records[0].Partition)
+	assert.Equal(t, "1", string(records[0].Key))
+	assert.Equal(t, "A", string(records[0].Value))
+	assert.Equal(t, "created", records[0].Headers["type"])
+
+	// Only successfully processed records are committed
+	assert.Equal(t, []int64{1}, session.marked)
+
+	err = consumer.Seek(ctx, records[1])
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{2}, session.reset)
+}
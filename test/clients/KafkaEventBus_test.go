@@ -0,0 +1,122 @@
+package test_clients
+
+import (
+	"context"
+	"testing"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaEventBus(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	_ = connection.Open(ctx, "")
+
+	bus := clients.NewKafkaEventBus()
+	bus.Configure(ctx, cconf.NewConfigParamsFromTuples(
+		"group_id", "invoices",
+		"topics.order_created", "orders",
+	))
+	bus.Consumer.Connection = connection
+
+	assert.Equal(t, "orders", bus.GetTopic("order_created"))
+	assert.Equal(t, "events", bus.GetTopic("user_created"))
+
+	received := make([]string, 0)
+	err := bus.Subscribe(ctx, "", "order_created", func(ctx context.Context, event *clients.KafkaRecord) error {
+		received = append(received, "first:"+string(event.Value))
+		return nil
+	})
+	assert.Nil(t, err)
+	err = bus.Subscribe(ctx, "", "order_created", func(ctx context.Context, event *clients.KafkaRecord) error {
+		received = append(received, "second:"+string(event.Value))
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = bus.Open(ctx, "")
+	assert.Nil(t, err)
+	defer bus.Close(ctx, "")
+	assert.Equal(t, []string{"orders"}, bus.Consumer.Topics())
+
+	err = bus.Publish(ctx, "123", "order_created", []byte("order1"))
+	assert.Nil(t, err)
+	err = bus.Publish(ctx, "123", "user_created", []byte("user1"))
+	assert.Nil(t, err)
+	assert.Len(t, connection.GetPublished("orders"), 1)
+	assert.Len(t, connection.GetPublished("events"), 1)
+
+	deliverPublished(t, connection, "orders", 0)
+	assert.Equal(t, []string{"first:order1", "second:order1"}, received)
+
+	// Subscriptions of opened buses join their topics
+	err = bus.Subscribe(ctx, "", "user_created", func(ctx context.Context, event *clients.KafkaRecord) error {
+		received = append(received, "user:"+string(event.Value))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"orders", "events"}, bus.Consumer.Topics())
+
+	deliverPublished(t, connection, "events", 0)
+	assert.Equal(t, "user:user1", received[2])
+
+	err = bus.Unsubscribe(ctx, "", "order_created")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"events"}, bus.Consumer.Topics())
+}
+
+func TestKafkaEventBusDurableSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	_ = connection.Open(ctx, "")
+
+	bus := clients.NewKafkaEventBus()
+	bus.Configure(ctx, cconf.NewConfigParamsFromTuples("topics.order_created", "orders"))
+	bus.Consumer.Connection = connection
+
+	received := make([]string, 0)
+	err := bus.SubscribeDurable(ctx, "", "invoicing", "order_created", func(ctx context.Context, event *clients.KafkaRecord) error {
+		received = append(received, string(event.Value))
+		return nil
+	})
+	assert.Nil(t, err)
+	err = bus.SubscribeDurable(ctx, "", "invoicing", "order_created", nil)
+	assert.NotNil(t, err)
+
+	err = bus.Open(ctx, "")
+	assert.Nil(t, err)
+	defer bus.Close(ctx, "")
+
+	err = bus.Publish(ctx, "123", "order_created", []byte("order1"))
+	assert.Nil(t, err)
+	err = bus.Publish(ctx, "123", "order_created", []byte("order2"))
+	assert.Nil(t, err)
+	deliverPublished(t, connection, "orders", 0)
+	deliverPublished(t, connection, "orders", 1)
+	assert.Equal(t, []string{"order1", "order2"}, received)
+
+	subscriptions := bus.GetSubscriptions()
+	assert.Len(t, subscriptions, 1)
+	assert.Equal(t, "subscription.invoicing", subscriptions[0].GroupId)
+	assert.Equal(t, "orders", subscriptions[0].Topic)
+	assert.False(t, subscriptions[0].Paused)
+
+	err = bus.PauseSubscription(ctx, "", "invoicing")
+	assert.Nil(t, err)
+	assert.True(t, bus.GetSubscriptions()[0].Paused)
+	err = bus.ResumeSubscription(ctx, "", "invoicing")
+	assert.Nil(t, err)
+	assert.False(t, bus.GetSubscriptions()[0].Paused)
+
+	connection.Committed["subscription.invoicing"] = map[string]map[int32]int64{"orders": {0: 1}}
+	err = bus.DeleteSubscription(ctx, "", "invoicing")
+	assert.Nil(t, err)
+	assert.Len(t, bus.GetSubscriptions(), 0)
+	assert.NotContains(t, connection.Committed, "subscription.invoicing")
+
+	err = bus.PauseSubscription(ctx, "", "invoicing")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,69 @@
+package test_clients
+
+import (
+	"context"
+	"testing"
+
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaProducerSend(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	_ = connection.Open(ctx, "")
+
+	producer := clients.NewKafkaProducer()
+	producer.Connection = connection
+
+	err := producer.Send(ctx, "", "orders", "1", nil, []byte("A"))
+	assert.NotNil(t, err)
+
+	err = producer.Open(ctx, "")
+	assert.Nil(t, err)
+	defer producer.Close(ctx, "")
+
+	err = producer.Send(ctx, "", "orders", "1", map[string]string{"type": "created"}, []byte("A"))
+	assert.Nil(t, err)
+	err = producer.Send(ctx, "", "payments", "2", nil, []byte("B"))
+	assert.Nil(t, err)
+
+	assert.Len(t, connection.GetPublished("orders"), 1)
+	assert.Len(t, connection.GetPublished("payments"), 1)
+
+	msg := connection.GetPublished("orders")[0]
+	assert.Equal(t, "type", string(msg.Headers[0].Key))
+	assert.Equal(t, "created", string(msg.Headers[0].Value))
+}
+
+func TestKafkaProducerPartitionCount(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection("orders")
+	_ = connection.Open(ctx, "")
+
+	producer := clients.NewKafkaProducer()
+	producer.Connection = connection
+	err := producer.Open(ctx, "")
+	assert.Nil(t, err)
+
+	// Partition counts are read once while the producer is open
+	err = producer.Send(ctx, "", "orders", "2", nil, []byte("A"))
+	assert.Nil(t, err)
+	connection.Topics["orders"] = 8
+	assert.NotEqual(t, int32(0), connect.KeyPartition("2", 8))
+
+	err = producer.Send(ctx, "", "orders", "2", nil, []byte("B"))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(0), connection.GetPublished("orders")[1].Partition)
+
+	// Reopened producers read them again
+	assert.Nil(t, producer.Close(ctx, ""))
+	assert.Nil(t, producer.Open(ctx, ""))
+	defer producer.Close(ctx, "")
+
+	err = producer.Send(ctx, "", "orders", "2", nil, []byte("C"))
+	assert.Nil(t, err)
+	assert.Equal(t, connect.KeyPartition("2", 8), connection.GetPublished("orders")[2].Partition)
+}
@@ -0,0 +1,72 @@
+package test_clients
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaReplayer(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	connection.Topics["orders"] = 2
+	_ = connection.Open(ctx, "")
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		_ = connection.Publish(ctx, "orders", []*kafka.ProducerMessage{{
+			Partition: int32(i % 2),
+			Value:     kafka.StringEncoder(strconv.Itoa(i)),
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		}})
+	}
+
+	replayer := clients.NewKafkaReplayer()
+	replayer.Configure(ctx, cconf.NewConfigParamsFromTuples(
+		"topic", "orders",
+		"options.rate", 1000,
+		"options.batch_size", 2,
+	))
+	replayer.Connection = connection
+
+	_, err := replayer.ReplayOffsets(ctx, "", map[int32]int64{0: 0}, nil, nil)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, replayer.Open(ctx, ""))
+	defer replayer.Close(ctx, "")
+
+	values := []string{}
+	handler := func(ctx context.Context, record *clients.KafkaRecord) error {
+		values = append(values, string(record.Value))
+		return nil
+	}
+
+	progress, err := replayer.ReplayTime(ctx, "", start.Add(2*time.Minute), start.Add(7*time.Minute), handler)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), progress.Total)
+	assert.Equal(t, int64(5), progress.Processed)
+	assert.Equal(t, 1.0, progress.Completion())
+	assert.Equal(t, []string{"2", "4", "6", "3", "5"}, values)
+
+	// Replay stops at the first error and keeps the position to resume
+	values = []string{}
+	progress, err = replayer.ReplayOffsets(ctx, "", map[int32]int64{0: 0, 1: 0}, nil,
+		func(ctx context.Context, record *clients.KafkaRecord) error {
+			if string(record.Value) == "4" {
+				return assert.AnError
+			}
+			values = append(values, string(record.Value))
+			return nil
+		})
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"0", "2"}, values)
+	assert.Equal(t, int64(2), progress.Positions[0])
+	assert.Equal(t, int64(2), progress.Processed)
+}
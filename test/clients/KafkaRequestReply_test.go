@@ -0,0 +1,93 @@
+package test_clients
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	"github.com/stretchr/testify/assert"
+)
+
+// Delivers a message published to a topic to its subscribed listener
+func deliverPublished(t *testing.T, connection *fixtures.FakeKafkaConnection, topic string, index int) {
+	assert.Eventually(t, func() bool {
+		return len(connection.GetPublished(topic)) > index
+	}, time.Second, 5*time.Millisecond)
+
+	msg := connection.GetPublished(topic)[index]
+	record := &kafka.ConsumerMessage{Topic: topic, Offset: int64(index)}
+	record.Value, _ = msg.Value.Encode()
+	for _, header := range msg.Headers {
+		record.Headers = append(record.Headers, &kafka.RecordHeader{Key: header.Key, Value: header.Value})
+	}
+
+	claim := &fakeClaim{messages: make(chan *kafka.ConsumerMessage, 1)}
+	claim.messages <- record
+	close(claim.messages)
+	err := connection.GetListener(topic).ConsumeClaim(&fakeSession{ctx: context.Background()}, claim)
+	assert.Nil(t, err)
+}
+
+func TestKafkaRequestReply(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	_ = connection.Open(ctx, "")
+
+	server := clients.NewKafkaRequestReplyServer()
+	server.Configure(ctx, cconf.NewConfigParamsFromTuples("topic", "prices"))
+	server.Consumer.Connection = connection
+	server.SetHandler(func(ctx context.Context, request *clients.KafkaRecord) ([]byte, error) {
+		if string(request.Value) == "fail" {
+			return nil, cerr.NewNotFoundError("", "NOT_FOUND", "Product not found")
+		}
+		return append([]byte("price of "), request.Value...), nil
+	})
+	err := server.Open(ctx, "")
+	assert.Nil(t, err)
+	defer server.Close(ctx, "")
+
+	client := clients.NewKafkaRequestReplyClient()
+	client.Configure(ctx, cconf.NewConfigParamsFromTuples(
+		"topic", "prices",
+		"options.reply_timeout", 200,
+	))
+	client.Consumer.Connection = connection
+	err = client.Open(ctx, "")
+	assert.Nil(t, err)
+	defer client.Close(ctx, "")
+
+	// Successful call
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reply, err := client.Call(ctx, "123", "", []byte("product1"))
+		assert.Nil(t, err)
+		assert.Equal(t, "price of product1", string(reply))
+	}()
+	deliverPublished(t, connection, "prices", 0)
+	deliverPublished(t, connection, "prices.replies", 0)
+	<-done
+
+	// Handler errors are returned to the caller
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := client.Call(ctx, "123", "", []byte("fail"))
+		var appErr *cerr.ApplicationError
+		assert.True(t, errors.As(err, &appErr))
+		assert.Equal(t, "NOT_FOUND", appErr.Code)
+	}()
+	deliverPublished(t, connection, "prices", 1)
+	deliverPublished(t, connection, "prices.replies", 1)
+	<-done
+
+	// Calls without replies time out
+	_, err = client.Call(ctx, "123", "", []byte("product2"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,55 @@
+package test_clients
+
+import (
+	"context"
+	"testing"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaTopicBridgeMirror(t *testing.T) {
+	ctx := context.Background()
+	source := fixtures.NewFakeKafkaConnection()
+	_ = source.Open(ctx, "")
+	target := fixtures.NewFakeKafkaConnection()
+	_ = target.Open(ctx, "")
+
+	bridge := clients.NewKafkaTopicBridge()
+	bridge.Configure(ctx, cconf.NewConfigParamsFromTuples(
+		"source.topic", "orders",
+		"target.topic", "orders_copy",
+	))
+	bridge.Consumer.Connection = source
+	bridge.Producer.Connection = target
+	bridge.SetTransform(func(ctx context.Context, record *clients.KafkaRecord) (*clients.KafkaRecord, error) {
+		if string(record.Value) == "skip" {
+			return nil, nil
+		}
+		record.Headers["mirrored"] = "true"
+		return record, nil
+	})
+
+	err := bridge.Open(ctx, "")
+	assert.Nil(t, err)
+	defer bridge.Close(ctx, "")
+
+	session := &fakeSession{ctx: ctx}
+	claim := &fakeClaim{messages: make(chan *kafka.ConsumerMessage, 2)}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "orders", Offset: 1, Key: []byte("1"), Value: []byte("A")}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "orders", Offset: 2, Value: []byte("skip")}
+	close(claim.messages)
+
+	err = source.Listeners["orders"].ConsumeClaim(session, claim)
+	assert.Nil(t, err)
+
+	assert.Len(t, target.Published["orders_copy"], 1)
+	msg := target.Published["orders_copy"][0]
+	assert.Equal(t, "mirrored", string(msg.Headers[0].Key))
+
+	// Both mirrored and skipped records are checkpointed
+	assert.Equal(t, []int64{1, 2}, session.marked)
+}
@@ -0,0 +1,80 @@
+package test_clients
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaTopicBrowser(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	connection.Topics["orders"] = 2
+	_ = connection.Open(ctx, "")
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		_ = connection.Publish(ctx, "orders", []*kafka.ProducerMessage{{
+			Partition: int32(i % 2),
+			Key:       kafka.StringEncoder("id" + strconv.Itoa(i)),
+			Value:     kafka.StringEncoder(strconv.Itoa(i)),
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+			Headers: []kafka.RecordHeader{
+				{Key: []byte("message_type"), Value: []byte("order")},
+				{Key: []byte("correlation_id"), Value: []byte("cid" + strconv.Itoa(i))},
+			},
+		}})
+	}
+
+	browser := clients.NewKafkaTopicBrowser()
+	browser.Configure(ctx, cconf.NewConfigParamsFromTuples(
+		"topic", "orders",
+		"options.page_size", 2,
+	))
+	browser.Connection = connection
+
+	_, err := browser.BrowseOffsets(ctx, "", map[int32]int64{0: 0}, nil)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, browser.Open(ctx, ""))
+	defer browser.Close(ctx, "")
+
+	page, err := browser.BrowseTime(ctx, "", start.Add(2*time.Minute), start.Add(7*time.Minute))
+	assert.Nil(t, err)
+	assert.Len(t, page.Messages, 2)
+	assert.True(t, page.HasMore())
+
+	message := page.Messages[0]
+	assert.Equal(t, "id2", message.MessageId)
+	assert.Equal(t, "order", message.MessageType)
+	assert.Equal(t, "cid2", message.CorrelationId)
+	assert.Equal(t, "2", message.GetMessageAsString())
+	assert.Equal(t, start.Add(2*time.Minute), message.SentTime)
+
+	values := []string{}
+	for {
+		for _, message := range page.Messages {
+			values = append(values, message.GetMessageAsString())
+		}
+		if !page.HasMore() {
+			break
+		}
+		page, err = browser.BrowseOffsets(ctx, "", page.Next, page.Ends)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, []string{"2", "4", "6", "3", "5"}, values)
+
+	// Browsing does not commit offsets, so the same range can be read again
+	page, err = browser.BrowseOffsets(ctx, "", map[int32]int64{1: 4}, nil)
+	assert.Nil(t, err)
+	assert.Len(t, page.Messages, 1)
+	assert.Equal(t, "9", page.Messages[0].GetMessageAsString())
+	assert.False(t, page.HasMore())
+}
@@ -0,0 +1,66 @@
+package test_connect
+
+import (
+	"context"
+	"testing"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	crun "github.com/pip-services3-gox/pip-services3-commons-gox/run"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeConfigReader struct {
+	config   *cconf.ConfigParams
+	listener crun.INotifiable
+}
+
+func (c *fakeConfigReader) ReadConfig(ctx context.Context, correlationId string, parameters *cconf.ConfigParams) (*cconf.ConfigParams, error) {
+	return c.config, nil
+}
+
+func (c *fakeConfigReader) AddChangeListener(ctx context.Context, listener crun.INotifiable) {
+	c.listener = listener
+}
+
+func (c *fakeConfigReader) RemoveChangeListener(ctx context.Context, listener crun.INotifiable) {
+	c.listener = nil
+}
+
+type fakeReconfigurable struct {
+	config *cconf.ConfigParams
+}
+
+func (c *fakeReconfigurable) Reconfigure(ctx context.Context, config *cconf.ConfigParams) {
+	c.config = config
+}
+
+func TestKafkaConfigReloader(t *testing.T) {
+	ctx := context.Background()
+	reader := &fakeConfigReader{config: cconf.NewEmptyConfigParams()}
+	component := &fakeReconfigurable{}
+
+	reloader := connect.NewKafkaConfigReloader()
+	reloader.Configure(ctx, cconf.NewConfigParamsFromTuples("section", "kafka"))
+
+	err := reloader.Open(ctx, "")
+	assert.NotNil(t, err)
+
+	reloader.SetReferences(ctx, cref.NewReferencesFromTuples(ctx,
+		cref.NewDescriptor("pip-services", "config-reader", "memory", "default", "1.0"), reader,
+		cref.NewDescriptor("test", "component", "fake", "default", "1.0"), component,
+	))
+
+	err = reloader.Open(ctx, "")
+	assert.Nil(t, err)
+	assert.NotNil(t, reader.listener)
+
+	reader.config = cconf.NewConfigParamsFromTuples("kafka.options.request_timeout", 5000)
+	reader.listener.Notify(ctx, "", nil)
+	assert.Equal(t, 5000, component.config.GetAsInteger("options.request_timeout"))
+
+	err = reloader.Close(ctx, "")
+	assert.Nil(t, err)
+	assert.Nil(t, reader.listener)
+}
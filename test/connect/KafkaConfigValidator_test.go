@@ -0,0 +1,63 @@
+package test_connect
+
+import (
+	"context"
+	"testing"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateKafkaOptions(t *testing.T) {
+	err := connect.ValidateKafkaOptions("", cconf.NewConfigParamsFromTuples(
+		"options.request_timeout", 1000,
+		"options.retry_backoff", 200,
+		"options.delivery_timeout", 120000,
+	), connect.KafkaConnectionOptions...)
+	assert.Nil(t, err)
+
+	err = connect.ValidateKafkaOptions("", cconf.NewConfigParamsFromTuples(
+		"options.request_timout", 1000,
+	), connect.KafkaConnectionOptions...)
+	assert.NotNil(t, err)
+	assert.Equal(t, "UNKNOWN_OPTION", err.(*cerr.ApplicationError).Code)
+	assert.Contains(t, err.Error(), "did you mean options.request_timeout")
+}
+
+func TestKafkaConnectionInvalidConfig(t *testing.T) {
+	for _, config := range []*cconf.ConfigParams{
+		cconf.NewConfigParamsFromTuples("options.acks", 2),
+		cconf.NewConfigParamsFromTuples("options.read_timeout", 0),
+		cconf.NewConfigParamsFromTuples("options.write_timeout", 0),
+		cconf.NewConfigParamsFromTuples("options.delivery_timeout", -1),
+		cconf.NewConfigParamsFromTuples("options.cleanup_policy", "compacted"),
+		cconf.NewConfigParamsFromTuples("options.failover_consumers", true),
+		cconf.NewConfigParamsFromTuples("options.required_features", "headers;zstandard"),
+		cconf.NewConfigParamsFromTuples("options.unknown", true),
+	} {
+		connection := connect.NewKafkaConnection()
+		connection.Configure(context.Background(), config.SetDefaults(cconf.NewConfigParamsFromTuples(
+			"connection.host", "localhost",
+			"connection.port", 9092,
+		)))
+
+		err := connection.Open(context.Background(), "")
+		assert.NotNil(t, err)
+		assert.Equal(t, cerr.Misconfiguration, err.(*cerr.ApplicationError).Category)
+	}
+
+	// Options of owners are allowed explicitly
+	connection := connect.NewKafkaConnection()
+	connection.AllowOptions("unknown")
+	connection.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"connection.host", "localhost",
+		"connection.port", 1,
+		"options.unknown", true,
+		"options.open_timeout", 1000,
+	))
+	err := connection.Open(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.Equal(t, "CONNECT_FAILED", err.(*cerr.ApplicationError).Code)
+}
@@ -0,0 +1,122 @@
+package test_connect
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	"github.com/stretchr/testify/assert"
+)
+
+func newOptionsConnection(t *testing.T, broker *kafka.MockBroker, options ...any) *connect.KafkaConnection {
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			append([]any{
+				"connection.uri", broker.Addr(),
+				"options.rtt_interval", 0,
+				"options.max_retries", 0,
+				"options.metadata_cache_ttl", 60000,
+			}, options...)...,
+		),
+	)
+	assert.Nil(t, connection.Open(context.Background(), ""))
+	return connection
+}
+
+func TestKafkaConnectionReadTimeout(t *testing.T) {
+	broker := newProduceMockBroker(t, "orders", kafka.ErrNoError)
+	defer broker.Close()
+
+	fast := newOptionsConnection(t, broker, "options.read_timeout", 200)
+	defer fast.Close(context.Background(), "")
+	_, err := fast.ReadPartitions("orders")
+	assert.Nil(t, err)
+	slow := newOptionsConnection(t, broker)
+	defer slow.Close(context.Background(), "")
+	_, err = slow.ReadPartitions("orders")
+	assert.Nil(t, err)
+
+	// Brokers that respond slower than the read timeout fail requests
+	broker.SetLatency(time.Second)
+	fast.Invalidate("orders")
+	_, err = fast.ReadPartitions("orders")
+	var netErr net.Error
+	assert.True(t, errors.As(err, &netErr) && netErr.Timeout())
+
+	// Slow responses are awaited by default
+	slow.Invalidate("orders")
+	_, err = slow.ReadPartitions("orders")
+	assert.Nil(t, err)
+}
+
+func countMetadataRequests(broker *kafka.MockBroker) int {
+	return len(findRequests[*kafka.MetadataRequest](broker))
+}
+
+func TestKafkaConnectionMetadataRefresh(t *testing.T) {
+	broker := newProduceMockBroker(t, "orders", kafka.ErrNoError)
+	defer broker.Close()
+
+	// Metadata is refreshed in background at the configured interval
+	connection := newOptionsConnection(t, broker, "options.metadata_refresh_interval", 100)
+	requests := countMetadataRequests(broker)
+	assert.Eventually(t, func() bool {
+		return countMetadataRequests(broker) >= requests+3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Nil(t, connection.Close(context.Background(), ""))
+}
+
+func TestKafkaConnectionMetadataRefreshDisabled(t *testing.T) {
+	broker := newProduceMockBroker(t, "orders", kafka.ErrNoError)
+	defer broker.Close()
+
+	// Zero interval disables background refreshes
+	connection := newOptionsConnection(t, broker, "options.metadata_refresh_interval", 0)
+	defer connection.Close(context.Background(), "")
+	requests := countMetadataRequests(broker)
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, requests, countMetadataRequests(broker))
+}
+
+func TestKafkaConnectionMaxIdleTime(t *testing.T) {
+	broker := newProduceMockBroker(t, "orders", kafka.ErrNoError)
+	defer broker.Close()
+	connection := newOptionsConnection(t, broker,
+		"options.max_idle_time", 300,
+		"options.connect_timeout", 100,
+	)
+	defer connection.Close(context.Background(), "")
+
+	// Producers in use keep their connections
+	assert.Nil(t, publishTestMessage(connection))
+	requests := countMetadataRequests(broker)
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, publishTestMessage(connection))
+	assert.Equal(t, requests, countMetadataRequests(broker))
+
+	// Producers idle longer than the max idle time are recreated before sending
+	time.Sleep(400 * time.Millisecond)
+	assert.Nil(t, publishTestMessage(connection))
+	assert.Greater(t, countMetadataRequests(broker), requests)
+	assert.Equal(t, 3, countProduceRequests(broker))
+}
+
+func TestKafkaConnectionMaxIdleTimeDisabled(t *testing.T) {
+	broker := newProduceMockBroker(t, "orders", kafka.ErrNoError)
+	defer broker.Close()
+	connection := newOptionsConnection(t, broker, "options.connect_timeout", 100)
+	defer connection.Close(context.Background(), "")
+
+	// Idle producers are kept by default
+	assert.Nil(t, publishTestMessage(connection))
+	requests := countMetadataRequests(broker)
+	time.Sleep(400 * time.Millisecond)
+	assert.Nil(t, publishTestMessage(connection))
+	assert.Equal(t, requests, countMetadataRequests(broker))
+}
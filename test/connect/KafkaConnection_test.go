@@ -4,9 +4,14 @@ import (
 	"context"
 	"os"
 	"testing"
+	"time"
 
+	kafka "github.com/Shopify/sarama"
 	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
 	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	test_containers "github.com/pip-services3-gox/pip-services3-kafka-gox/test/containers"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -15,41 +20,13 @@ type kafkaConnectionTest struct {
 }
 
 func newKafkaConnectionTest() *kafkaConnectionTest {
-	kafkaUri := os.Getenv("KAFKA_SERVICE_URI")
-	kafkaHost := os.Getenv("KAFKA_SERVICE_HOST")
-	if kafkaHost == "" {
-		kafkaHost = "localhost"
-	}
-
-	kafkaPort := os.Getenv("KAFKA_SERVICE_PORT")
-	if kafkaPort == "" {
-		kafkaPort = "9092"
-	}
-
-	kafkaUser := os.Getenv("KAFKA_USER")
-	// if kafkaUser == "" {
-	// 	kafkaUser = ""
-	// }
-	kafkaPassword := os.Getenv("KAFKA_PASS")
-	// if kafkaPassword == "" {
-	// 	kafkaPassword = ""
-	// }
-
-	if kafkaUri == "" && kafkaHost == "" {
+	config := test_containers.GetKafkaConfig()
+	if config == nil {
 		return nil
 	}
 
 	connection := connect.NewKafkaConnection()
-	connection.Configure(context.Background(),
-		cconf.NewConfigParamsFromTuples(
-			"connection.uri", kafkaUri,
-			"connection.host", kafkaHost,
-			"connection.port", kafkaPort,
-			"credential.mechanism", "plain",
-			"credential.username", kafkaUser,
-			"credential.password", kafkaPassword,
-		),
-	)
+	connection.Configure(context.Background(), config)
 
 	return &kafkaConnectionTest{
 		connection: connection,
@@ -84,12 +61,379 @@ func (c *kafkaConnectionTest) TestReadTopics(t *testing.T) {
 	assert.Nil(t, c.connection.GetConnection())
 }
 
+func (c *kafkaConnectionTest) TestCheckConnection(t *testing.T) {
+	err := c.connection.CheckConnection(context.Background(), "")
+	assert.Nil(t, err)
+	assert.False(t, c.connection.IsOpen())
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	test_containers.StopKafka()
+	os.Exit(code)
+}
+
 func TestKafkaConnection(t *testing.T) {
 	c := newKafkaConnectionTest()
 	if c == nil {
-		return
+		t.Skip("Kafka broker is not available")
 	}
 
 	t.Run("Open and Close", c.TestOpenClose)
 	t.Run("Read Topics", c.TestReadTopics)
+	t.Run("Check Connection", c.TestCheckConnection)
+
+	fixture := fixtures.NewKafkaConnectionFixture(c.connection, "test_connection")
+	t.Run("Create Delete Queue", fixture.TestCreateDeleteQueue)
+	t.Run("Publish", fixture.TestPublish)
+	t.Run("List Offsets", fixture.TestListOffsets)
+	t.Run("Describe Group", fixture.TestDescribeGroup)
+}
+
+func TestKafkaConnectionTopicNames(t *testing.T) {
+	connection := connect.NewKafkaConnection()
+	assert.Equal(t, "orders", connection.ResolveTopic("orders"))
+
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"options.topic_prefix", "staging.",
+			"options.topic_suffix", ".v1",
+		),
+	)
+	assert.Equal(t, "staging.orders.v1", connection.ResolveTopic("orders"))
+}
+
+func TestKafkaConnectionOpenTimeout(t *testing.T) {
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.host", "localhost",
+			"connection.port", 1,
+			"options.open_timeout", 2000,
+		),
+	)
+
+	start := time.Now()
+	err := connection.Open(context.Background(), "123")
+	assert.NotNil(t, err)
+	assert.Less(t, time.Since(start), 3*time.Second)
+	assert.False(t, connection.IsOpen())
+
+	appErr, ok := err.(*cerr.ApplicationError)
+	assert.True(t, ok)
+	assert.Equal(t, "CONNECT_FAILED", appErr.Code)
+	assert.Contains(t, appErr.Message, "localhost:1")
+	assert.Contains(t, appErr.Message, "none authentication")
+}
+
+func TestKafkaConnectionCheckUnreachable(t *testing.T) {
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.host", "localhost",
+			"connection.port", 1,
+			"options.open_timeout", 2000,
+		),
+	)
+
+	err := connection.CheckConnection(context.Background(), "123")
+	assert.NotNil(t, err)
+	assert.Equal(t, "CONNECT_FAILED", err.(*cerr.ApplicationError).Code)
+	assert.False(t, connection.IsOpen())
+}
+
+func TestFakeKafkaConnectionListOffsets(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection()
+	fixture := fixtures.NewKafkaConnectionFixture(connection, "test")
+	t.Run("List Offsets", fixture.TestListOffsets)
+}
+
+func TestFakeKafkaConnectionDescribeGroup(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection()
+	fixture := fixtures.NewKafkaConnectionFixture(connection, "test")
+	t.Run("Describe Group", fixture.TestDescribeGroup)
+
+	connection.Groups["orders"] = &connect.KafkaGroupDescription{
+		GroupId: "orders",
+		State:   "Stable",
+		Members: []*connect.KafkaGroupMember{
+			{MemberId: "m1", Assignments: map[string][]int32{"orders": {0, 1}}},
+			{MemberId: "m2", Assignments: map[string][]int32{"orders": {2}, "returns": {0}}},
+		},
+	}
+	group, err := connection.DescribeGroup("orders")
+	assert.Nil(t, err)
+	assert.Equal(t, "m2", group.FindOwner("orders", 2).MemberId)
+	assert.Equal(t, "m1", group.FindOwner("orders", 1).MemberId)
+	assert.Nil(t, group.FindOwner("orders", 3))
+}
+
+func TestKafkaConnectionThrottle(t *testing.T) {
+	broker := kafka.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	response := &kafka.ProduceResponse{Version: 3, ThrottleTime: 500 * time.Millisecond}
+	response.AddTopicPartition("orders", 0, kafka.ErrNoError)
+	broker.SetHandlerByMap(map[string]kafka.MockResponse{
+		"MetadataRequest": kafka.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()),
+		"ApiVersionsRequest": kafka.NewMockApiVersionsResponse(t),
+		"ProduceRequest":     kafka.NewMockWrapper(response),
+	})
+
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.uri", broker.Addr(),
+			"options.rtt_interval", 0,
+			"options.throttle_slowdown", true,
+		),
+	)
+	assert.Nil(t, connection.Open(context.Background(), ""))
+	defer connection.Close(context.Background(), "")
+	assert.False(t, connection.IsThrottled())
+
+	message := &kafka.ProducerMessage{Partition: 0, Value: kafka.StringEncoder("test")}
+	assert.Nil(t, connection.Publish(context.Background(), "orders", []*kafka.ProducerMessage{message}))
+	assert.True(t, connection.IsThrottled())
+
+	// The next send waits until the throttle time passes
+	start := time.Now()
+	message = &kafka.ProducerMessage{Partition: 0, Value: kafka.StringEncoder("test")}
+	assert.Nil(t, connection.Publish(context.Background(), "orders", []*kafka.ProducerMessage{message}))
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+}
+
+func TestKafkaConnectionFeatures(t *testing.T) {
+	broker := kafka.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	// Brokers of Kafka 1.0 support produce requests up to version 5
+	broker.SetHandlerByMap(map[string]kafka.MockResponse{
+		"MetadataRequest": kafka.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()),
+		"ApiVersionsRequest": kafka.NewMockApiVersionsResponse(t).SetApiKeys([]kafka.ApiVersionsResponseKey{
+			{ApiKey: 0, MinVersion: 0, MaxVersion: 5},
+			{ApiKey: 11, MinVersion: 0, MaxVersion: 2},
+			{ApiKey: 22, MinVersion: 0, MaxVersion: 0},
+			{ApiKey: 24, MinVersion: 0, MaxVersion: 0},
+			{ApiKey: 26, MinVersion: 0, MaxVersion: 0},
+		}),
+	})
+
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.uri", broker.Addr(),
+			"options.rtt_interval", 0,
+		),
+	)
+	assert.Nil(t, connection.GetFeatures())
+	assert.Nil(t, connection.Open(context.Background(), ""))
+
+	features := connection.GetFeatures()
+	assert.NotNil(t, features)
+	assert.Equal(t, "1.0.0", features.Version)
+	assert.True(t, features.Supports(connect.FeatureHeaders))
+	assert.True(t, features.Supports(connect.FeatureTransactions))
+	assert.False(t, features.Supports(connect.FeatureIncrementalRebalance))
+	assert.Nil(t, connection.RequireFeature("", connect.FeatureIdempotence))
+
+	err := connection.RequireFeature("123", connect.FeatureZstd)
+	assert.NotNil(t, err)
+	assert.Equal(t, "UNSUPPORTED_FEATURE", err.(*cerr.ApplicationError).Code)
+	assert.Contains(t, err.(*cerr.ApplicationError).Message, "requires Kafka 2.1.0")
+	assert.Nil(t, connection.Close(context.Background(), ""))
+
+	// Open fails when required features are not supported
+	connection = connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.uri", broker.Addr(),
+			"options.rtt_interval", 0,
+			"options.required_features", "headers;zstd",
+		),
+	)
+	err = connection.Open(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.Equal(t, "UNSUPPORTED_FEATURE", err.(*cerr.ApplicationError).Code)
+	assert.False(t, connection.IsOpen())
+}
+
+func TestKafkaConnectionMetadataCache(t *testing.T) {
+	broker := kafka.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]kafka.MockResponse{
+		"MetadataRequest": kafka.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()).
+			SetLeader("orders", 1, broker.BrokerID()),
+		"ApiVersionsRequest": kafka.NewMockApiVersionsResponse(t),
+	})
+
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.uri", broker.Addr(),
+			"options.rtt_interval", 0,
+			"options.metadata_refresh_interval", 0,
+			"options.metadata_cache_ttl", 60000,
+		),
+	)
+	assert.Nil(t, connection.Open(context.Background(), ""))
+	defer connection.Close(context.Background(), "")
+
+	metadataRequests := func() int {
+		count := 0
+		for _, entry := range broker.History() {
+			if _, ok := entry.Request.(*kafka.MetadataRequest); ok {
+				count++
+			}
+		}
+		return count
+	}
+
+	names, err := connection.ReadQueueNames()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"orders"}, names)
+	partitions, err := connection.ReadPartitions("orders")
+	assert.Nil(t, err)
+	assert.Len(t, partitions, 2)
+	requests := metadataRequests()
+
+	// Repeated reads are served from the cache
+	for i := 0; i < 5; i++ {
+		names, err = connection.ReadQueueNames()
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"orders"}, names)
+		partitions, err = connection.ReadPartitions("orders")
+		assert.Nil(t, err)
+		assert.Len(t, partitions, 2)
+	}
+	assert.Equal(t, requests, metadataRequests())
+
+	// Invalidated entries are read from brokers again
+	connection.Invalidate("orders")
+	_, err = connection.ReadPartitions("orders")
+	assert.Nil(t, err)
+	_, err = connection.ReadQueueNames()
+	assert.Nil(t, err)
+	assert.Equal(t, requests+2, metadataRequests())
+}
+
+func TestKafkaConnectionInternalTopics(t *testing.T) {
+	broker := kafka.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]kafka.MockResponse{
+		"MetadataRequest": kafka.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()).
+			SetLeader("_schemas", 0, broker.BrokerID()).
+			SetLeader("__consumer_offsets", 0, broker.BrokerID()),
+		"ApiVersionsRequest": kafka.NewMockApiVersionsResponse(t),
+	})
+
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.uri", broker.Addr(),
+			"options.rtt_interval", 0,
+			"options.hide_internal_topics", true,
+		),
+	)
+	assert.Nil(t, connection.Open(context.Background(), ""))
+	defer connection.Close(context.Background(), "")
+
+	names, err := connection.ReadQueueNames()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"orders"}, names)
+
+	// Internal topics are refused before any request is sent
+	message := &kafka.ProducerMessage{Partition: 0, Value: kafka.StringEncoder("test")}
+	err = connection.Publish(context.Background(), "_schemas", []*kafka.ProducerMessage{message})
+	assert.NotNil(t, err)
+	assert.Equal(t, "INTERNAL_TOPIC", err.(*cerr.ApplicationError).Code)
+
+	for _, err = range []error{
+		connection.CreateQueue("__consumer_offsets"),
+		connection.DeleteQueue("__consumer_offsets"),
+		connection.CreatePartitions("_schemas", 2),
+		connection.DeleteRecords("_schemas", map[int32]int64{0: 1}),
+	} {
+		assert.NotNil(t, err)
+		assert.Equal(t, "INTERNAL_TOPIC", err.(*cerr.ApplicationError).Code)
+	}
+
+	// Internal topics are visible when they are not hidden
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"options.hide_internal_topics", false,
+		),
+	)
+	names, err = connection.ReadQueueNames()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"orders", "_schemas", "__consumer_offsets"}, names)
+}
+
+func TestKafkaConnectionPeekMessages(t *testing.T) {
+	broker := kafka.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	fetch := kafka.NewMockFetchResponse(t, 1)
+	for offset := int64(0); offset < 3; offset++ {
+		fetch.SetMessage("orders", 0, offset, kafka.StringEncoder("a"))
+	}
+	for offset := int64(7); offset < 10; offset++ {
+		fetch.SetMessage("orders", 1, offset, kafka.StringEncoder("b"))
+	}
+	broker.SetHandlerByMap(map[string]kafka.MockResponse{
+		"MetadataRequest": kafka.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()).
+			SetLeader("orders", 1, broker.BrokerID()),
+		"ApiVersionsRequest": kafka.NewMockApiVersionsResponse(t),
+		"FindCoordinatorRequest": kafka.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(kafka.CoordinatorGroup, "group", broker),
+		"OffsetFetchRequest": kafka.NewMockOffsetFetchResponse(t).
+			SetOffset("group", "orders", 0, 1, "", kafka.ErrNoError).
+			SetOffset("group", "orders", 1, -1, "", kafka.ErrNoError),
+		"OffsetRequest": kafka.NewMockOffsetResponse(t).
+			SetOffset("orders", 0, kafka.OffsetOldest, 0).
+			SetOffset("orders", 0, kafka.OffsetNewest, 3).
+			SetOffset("orders", 1, kafka.OffsetOldest, 0).
+			SetOffset("orders", 1, kafka.OffsetNewest, 10),
+		"FetchRequest": fetch,
+	})
+
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.uri", broker.Addr(),
+			"options.rtt_interval", 0,
+		),
+	)
+	assert.Nil(t, connection.Open(context.Background(), ""))
+	defer connection.Close(context.Background(), "")
+
+	// Partitions are read from committed offsets or from their latest messages
+	messages, err := connection.PeekMessages("orders", "group", nil, 3)
+	assert.Nil(t, err)
+	offsets := []int64{}
+	for _, message := range messages {
+		offsets = append(offsets, message.Offset)
+	}
+	assert.Equal(t, []int64{1, 2, 9}, offsets)
+
+	messages, err = connection.PeekMessages("orders", "group", []int32{1}, 2)
+	assert.Nil(t, err)
+	assert.Len(t, messages, 2)
+	assert.Equal(t, int64(8), messages[0].Offset)
 }
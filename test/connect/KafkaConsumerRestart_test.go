@@ -0,0 +1,140 @@
+package test_connect
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	"github.com/stretchr/testify/assert"
+)
+
+// Sets the error of join requests to the group coordinated by the broker
+func setJoinGroupError(t *testing.T, broker *kafka.MockBroker, joinErr kafka.KError) {
+	broker.SetHandlerByMap(map[string]kafka.MockResponse{
+		"MetadataRequest": kafka.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()),
+		"ApiVersionsRequest": kafka.NewMockApiVersionsResponse(t),
+		"OffsetRequest": kafka.NewMockOffsetResponse(t).
+			SetOffset("orders", 0, kafka.OffsetOldest, 0).
+			SetOffset("orders", 0, kafka.OffsetNewest, 0),
+		"FindCoordinatorRequest": kafka.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(kafka.CoordinatorGroup, "group", broker),
+		"JoinGroupRequest": kafka.NewMockJoinGroupResponse(t).
+			SetGroupProtocol(kafka.RangeBalanceStrategyName).
+			SetError(joinErr),
+		"SyncGroupRequest": kafka.NewMockSyncGroupResponse(t).SetMemberAssignment(
+			&kafka.ConsumerGroupMemberAssignment{Topics: map[string][]int32{"orders": {0}}}),
+		"HeartbeatRequest":    kafka.NewMockHeartbeatResponse(t),
+		"LeaveGroupRequest":   kafka.NewMockLeaveGroupResponse(t),
+		"OffsetFetchRequest":  kafka.NewMockOffsetFetchResponse(t).SetOffset("group", "orders", 0, 0, "", kafka.ErrNoError),
+		"OffsetCommitRequest": kafka.NewMockOffsetCommitResponse(t),
+		"FetchRequest":        kafka.NewMockFetchResponse(t, 1),
+	})
+}
+
+func countJoinRequests(broker *kafka.MockBroker) int {
+	count := 0
+	for _, entry := range broker.History() {
+		if _, ok := entry.Request.(*kafka.JoinGroupRequest); ok {
+			count++
+		}
+	}
+	return count
+}
+
+func newRestartedConnection(t *testing.T, broker *kafka.MockBroker) *connect.KafkaConnection {
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.uri", broker.Addr(),
+			"options.rtt_interval", 0,
+			"options.retry_timeout", 300,
+		),
+	)
+	assert.Nil(t, connection.Open(context.Background(), ""))
+	return connection
+}
+
+func TestKafkaConnectionConsumerRestart(t *testing.T) {
+	broker := kafka.NewMockBroker(t, 1)
+	defer broker.Close()
+	setJoinGroupError(t, broker, kafka.ErrNoError)
+	connection := newRestartedConnection(t, broker)
+	defer connection.Close(context.Background(), "")
+
+	listener := newTestGroupListener()
+	listener.leave = make(chan struct{})
+	err := connection.Subscribe(context.Background(), "orders", "group", kafka.NewConfig(), listener)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, listener.getSetups())
+
+	// The session ends and the group cannot be joined again
+	setJoinGroupError(t, broker, kafka.ErrInvalidGroupId)
+	joins := countJoinRequests(broker)
+	close(listener.leave)
+
+	// Dead consumers are recreated after backoffs limited by the retry timeout
+	time.Sleep(time.Second)
+	failedJoins := countJoinRequests(broker) - joins
+	assert.GreaterOrEqual(t, failedJoins, 2)
+	assert.LessOrEqual(t, failedJoins, 6)
+
+	// The recreated consumer starts a new session once the group can be joined
+	setJoinGroupError(t, broker, kafka.ErrNoError)
+	assert.Eventually(t, func() bool {
+		return listener.getSetups() > 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestKafkaConnectionConsumerStartFailure(t *testing.T) {
+	broker := kafka.NewMockBroker(t, 1)
+	defer broker.Close()
+	setJoinGroupError(t, broker, kafka.ErrInvalidGroupId)
+	connection := newRestartedConnection(t, broker)
+	defer connection.Close(context.Background(), "")
+
+	// Consumers that have never started are not recreated
+	err := connection.Subscribe(context.Background(), "orders", "group", kafka.NewConfig(), newTestGroupListener())
+	assert.NotNil(t, err)
+	joins := countJoinRequests(broker)
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, joins, countJoinRequests(broker))
+}
+
+func TestKafkaConnectionCloseDuringRestart(t *testing.T) {
+	broker := kafka.NewMockBroker(t, 1)
+	defer broker.Close()
+	setJoinGroupError(t, broker, kafka.ErrNoError)
+	connection := newRestartedConnection(t, broker)
+
+	listener := newTestGroupListener()
+	listener.leave = make(chan struct{})
+	err := connection.Subscribe(context.Background(), "orders", "group", kafka.NewConfig(), listener)
+	assert.Nil(t, err)
+
+	setJoinGroupError(t, broker, kafka.ErrInvalidGroupId)
+	close(listener.leave)
+	time.Sleep(400 * time.Millisecond)
+
+	// Closing stops recreating consumers and waits for their goroutines
+	start := time.Now()
+	err = connection.Close(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+
+	joins := countJoinRequests(broker)
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, joins, countJoinRequests(broker))
+	assert.Eventually(t, func() bool {
+		buffer := make([]byte, 1<<20)
+		stacks := string(buffer[:runtime.Stack(buffer, true)])
+		return !strings.Contains(stacks, "kafka-gox/connect.")
+	}, 5*time.Second, 50*time.Millisecond)
+}
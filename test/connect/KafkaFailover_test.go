@@ -0,0 +1,320 @@
+package test_connect
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	"github.com/stretchr/testify/assert"
+)
+
+// Starts a broker that leads partition 0 of the topic and answers produce requests with the error
+func newProduceMockBroker(t *testing.T, topic string, produceErr kafka.KError) *kafka.MockBroker {
+	broker := kafka.NewMockBroker(t, 1)
+	setProduceResponse(t, broker, topic, produceErr)
+	return broker
+}
+
+func setProduceResponse(t *testing.T, broker *kafka.MockBroker, topic string, produceErr kafka.KError) {
+	response := &kafka.ProduceResponse{Version: 3}
+	response.AddTopicPartition(topic, 0, produceErr)
+	broker.SetHandlerByMap(map[string]kafka.MockResponse{
+		"MetadataRequest": kafka.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader(topic, 0, broker.BrokerID()),
+		"ApiVersionsRequest": kafka.NewMockApiVersionsResponse(t),
+		"ProduceRequest":     kafka.NewMockWrapper(response),
+	})
+}
+
+func countProduceRequests(broker *kafka.MockBroker) int {
+	count := 0
+	for _, entry := range broker.History() {
+		if _, ok := entry.Request.(*kafka.ProduceRequest); ok {
+			count++
+		}
+	}
+	return count
+}
+
+func publishTestMessage(connection *connect.KafkaConnection) error {
+	message := &kafka.ProducerMessage{Partition: 0, Value: kafka.StringEncoder("test")}
+	return connection.Publish(context.Background(), "orders", []*kafka.ProducerMessage{message})
+}
+
+func TestKafkaConnectionFailover(t *testing.T) {
+	primary := newProduceMockBroker(t, "orders", kafka.ErrInvalidMessage)
+	defer primary.Close()
+	secondary := newProduceMockBroker(t, "orders", kafka.ErrNoError)
+	defer secondary.Close()
+
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.uri", primary.Addr(),
+			"secondary.connection.uri", secondary.Addr(),
+			"options.rtt_interval", 0,
+			"options.max_retries", 0,
+			"options.failover_timeout", 0,
+			"options.failback_interval", 200,
+		),
+	)
+	assert.Nil(t, connection.Open(context.Background(), ""))
+	defer connection.Close(context.Background(), "")
+	assert.False(t, connection.IsFailedOver())
+
+	// Failures of the primary cluster switch producers to the secondary one
+	assert.NotNil(t, publishTestMessage(connection))
+	assert.True(t, connection.IsFailedOver())
+
+	assert.Nil(t, publishTestMessage(connection))
+	assert.Equal(t, 1, countProduceRequests(secondary))
+
+	// Producers switch back once the primary cluster is healthy
+	primaryRequests := countProduceRequests(primary)
+	setProduceResponse(t, primary, "orders", kafka.ErrNoError)
+	assert.Eventually(t, func() bool {
+		return !connection.IsFailedOver()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Nil(t, publishTestMessage(connection))
+	assert.Equal(t, primaryRequests+1, countProduceRequests(primary))
+	assert.Equal(t, 1, countProduceRequests(secondary))
+}
+
+func TestKafkaConnectionFailoverTimeout(t *testing.T) {
+	primary := newProduceMockBroker(t, "orders", kafka.ErrInvalidMessage)
+	defer primary.Close()
+	secondary := newProduceMockBroker(t, "orders", kafka.ErrNoError)
+	defer secondary.Close()
+
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.uri", primary.Addr(),
+			"secondary.connection.uri", secondary.Addr(),
+			"options.rtt_interval", 0,
+			"options.max_retries", 0,
+			"options.failover_timeout", 300,
+			"options.failback_interval", 0,
+		),
+	)
+	assert.Nil(t, connection.Open(context.Background(), ""))
+	defer connection.Close(context.Background(), "")
+
+	// Failures shorter than the timeout keep the primary cluster
+	assert.NotNil(t, publishTestMessage(connection))
+	assert.NotNil(t, publishTestMessage(connection))
+	assert.False(t, connection.IsFailedOver())
+
+	time.Sleep(300 * time.Millisecond)
+	assert.NotNil(t, publishTestMessage(connection))
+	assert.True(t, connection.IsFailedOver())
+	assert.Nil(t, publishTestMessage(connection))
+}
+
+func TestKafkaConnectionOpenSecondary(t *testing.T) {
+	secondary := newProduceMockBroker(t, "orders", kafka.ErrNoError)
+	defer secondary.Close()
+
+	// Connections start on the secondary cluster when the primary one is down
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.host", "localhost",
+			"connection.port", 1,
+			"secondary.connection.uri", secondary.Addr(),
+			"options.rtt_interval", 0,
+			"options.open_timeout", 1000,
+			"options.failback_interval", 0,
+		),
+	)
+	assert.Nil(t, connection.Open(context.Background(), ""))
+	defer connection.Close(context.Background(), "")
+	assert.True(t, connection.IsFailedOver())
+
+	assert.Nil(t, publishTestMessage(connection))
+	assert.Equal(t, 1, countProduceRequests(secondary))
+}
+
+func TestKafkaConnectionSwitchDuringAdminCalls(t *testing.T) {
+	primary := newProduceMockBroker(t, "orders", kafka.ErrInvalidMessage)
+	defer primary.Close()
+	secondary := newProduceMockBroker(t, "orders", kafka.ErrNoError)
+	defer secondary.Close()
+
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.uri", primary.Addr(),
+			"secondary.connection.uri", secondary.Addr(),
+			"options.rtt_interval", 0,
+			"options.max_retries", 0,
+			"options.failover_timeout", 0,
+			"options.failback_interval", 20,
+		),
+	)
+	assert.Nil(t, connection.Open(context.Background(), ""))
+	defer connection.Close(context.Background(), "")
+
+	// Producers switch between clusters while admin calls and produce timings use the shared clients
+	var workers sync.WaitGroup
+	stop := make(chan struct{})
+	workers.Add(2)
+	go func() {
+		defer workers.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = publishTestMessage(connection)
+			}
+		}
+	}()
+	go func() {
+		defer workers.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_, _ = connection.ReadPartitions("orders")
+				_, _ = connection.ReadQueueNames()
+			}
+		}
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+	close(stop)
+	workers.Wait()
+	assert.Greater(t, countProduceRequests(secondary), 0)
+}
+
+func TestKafkaConnectionConcurrentAdminClients(t *testing.T) {
+	primary := newProduceMockBroker(t, "orders", kafka.ErrInvalidMessage)
+	defer primary.Close()
+	secondary := newProduceMockBroker(t, "orders", kafka.ErrNoError)
+	defer secondary.Close()
+
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.uri", primary.Addr(),
+			"secondary.connection.uri", secondary.Addr(),
+			"options.rtt_interval", 0,
+			"options.max_retries", 0,
+			"options.failover_timeout", 0,
+			"options.failback_interval", 0,
+		),
+	)
+	assert.Nil(t, connection.Open(context.Background(), ""))
+
+	// Failover resets the admin client, so concurrent calls create new ones
+	assert.NotNil(t, publishTestMessage(connection))
+	assert.True(t, connection.IsFailedOver())
+
+	var workers sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			_, err := connection.ReadPartitions("orders")
+			assert.Nil(t, err)
+		}()
+	}
+	workers.Wait()
+
+	// Admin clients that lost the race are closed with their background goroutines
+	assert.Nil(t, connection.Close(context.Background(), ""))
+	assert.Eventually(t, func() bool {
+		buffer := make([]byte, 1<<20)
+		stacks := string(buffer[:runtime.Stack(buffer, true)])
+		return !strings.Contains(stacks, "backgroundMetadataUpdater")
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestKafkaConnectionCloseFailedOver(t *testing.T) {
+	primary := newProduceMockBroker(t, "orders", kafka.ErrInvalidMessage)
+	secondary := newProduceMockBroker(t, "orders", kafka.ErrNoError)
+	defer secondary.Close()
+
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.uri", primary.Addr(),
+			"secondary.connection.uri", secondary.Addr(),
+			"options.rtt_interval", 0,
+			"options.max_retries", 0,
+			"options.failover_timeout", 0,
+			"options.failback_interval", 50,
+		),
+	)
+	assert.Nil(t, connection.Open(context.Background(), ""))
+
+	assert.NotNil(t, publishTestMessage(connection))
+	assert.True(t, connection.IsFailedOver())
+
+	// Closing doesn't wait until the primary cluster comes back
+	primary.Close()
+	closed := make(chan error)
+	go func() {
+		closed <- connection.Close(context.Background(), "")
+	}()
+	select {
+	case err := <-closed:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "Connection was not closed while the primary cluster is down")
+	}
+}
+
+func TestKafkaConnectionReconfigureFailedOver(t *testing.T) {
+	primary := newProduceMockBroker(t, "orders", kafka.ErrInvalidMessage)
+	secondary := newProduceMockBroker(t, "orders", kafka.ErrNoError)
+	defer secondary.Close()
+
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.uri", primary.Addr(),
+			"secondary.connection.uri", secondary.Addr(),
+			"options.rtt_interval", 0,
+			"options.max_retries", 0,
+			"options.failover_timeout", 0,
+			"options.failback_interval", 50,
+		),
+	)
+	assert.Nil(t, connection.Open(context.Background(), ""))
+	defer connection.Close(context.Background(), "")
+
+	assert.NotNil(t, publishTestMessage(connection))
+	assert.True(t, connection.IsFailedOver())
+	primary.Close()
+
+	// Probes restart without waiting until the primary cluster comes back
+	reconfigured := make(chan struct{})
+	go func() {
+		defer close(reconfigured)
+		connection.Reconfigure(context.Background(),
+			cconf.NewConfigParamsFromTuples("options.rtt_interval", 20))
+	}()
+	select {
+	case <-reconfigured:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "Connection was not reconfigured while the primary cluster is down")
+	}
+
+	// Restarted probes measure brokers of the secondary cluster
+	requests := countMetadataRequests(secondary)
+	assert.Eventually(t, func() bool {
+		return countMetadataRequests(secondary) > requests
+	}, 5*time.Second, 10*time.Millisecond)
+}
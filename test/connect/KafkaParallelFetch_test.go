@@ -0,0 +1,82 @@
+package test_connect
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaConnectionParallelFetch(t *testing.T) {
+	// Partition 0 is led by the coordinator and partition 1 by a slow broker
+	broker := kafka.NewMockBroker(t, 1)
+	defer broker.Close()
+	slowBroker := kafka.NewMockBroker(t, 2)
+	defer slowBroker.Close()
+
+	broker.SetHandlerByMap(map[string]kafka.MockResponse{
+		"MetadataRequest": kafka.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetBroker(slowBroker.Addr(), slowBroker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()).
+			SetLeader("orders", 1, slowBroker.BrokerID()),
+		"ApiVersionsRequest": kafka.NewMockApiVersionsResponse(t),
+		"OffsetRequest": kafka.NewMockOffsetResponse(t).
+			SetOffset("orders", 0, kafka.OffsetOldest, 0).
+			SetOffset("orders", 0, kafka.OffsetNewest, 1),
+		"FindCoordinatorRequest": kafka.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(kafka.CoordinatorGroup, "group", broker),
+		"JoinGroupRequest": kafka.NewMockJoinGroupResponse(t).SetGroupProtocol(kafka.RangeBalanceStrategyName),
+		"SyncGroupRequest": kafka.NewMockSyncGroupResponse(t).SetMemberAssignment(
+			&kafka.ConsumerGroupMemberAssignment{Topics: map[string][]int32{"orders": {0, 1}}}),
+		"HeartbeatRequest":  kafka.NewMockHeartbeatResponse(t),
+		"LeaveGroupRequest": kafka.NewMockLeaveGroupResponse(t),
+		"OffsetFetchRequest": kafka.NewMockOffsetFetchResponse(t).
+			SetOffset("group", "orders", 0, 0, "", kafka.ErrNoError).
+			SetOffset("group", "orders", 1, 0, "", kafka.ErrNoError),
+		"OffsetCommitRequest": kafka.NewMockOffsetCommitResponse(t),
+		"FetchRequest": kafka.NewMockFetchResponse(t, 1).
+			SetMessage("orders", 0, 0, kafka.StringEncoder("fast")),
+	})
+	slowBroker.SetHandlerByMap(map[string]kafka.MockResponse{
+		"ApiVersionsRequest": kafka.NewMockApiVersionsResponse(t),
+		"OffsetRequest": kafka.NewMockOffsetResponse(t).
+			SetOffset("orders", 1, kafka.OffsetOldest, 0).
+			SetOffset("orders", 1, kafka.OffsetNewest, 1),
+		"FetchRequest": kafka.NewMockFetchResponse(t, 1).
+			SetMessage("orders", 1, 0, kafka.StringEncoder("slow")),
+	})
+	slowBroker.SetLatency(time.Second)
+
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.uri", broker.Addr(),
+			"options.rtt_interval", 0,
+		),
+	)
+	assert.Nil(t, connection.Open(context.Background(), ""))
+	defer connection.Close(context.Background(), "")
+
+	listener := newTestGroupListener()
+	started := time.Now()
+	err := connection.Subscribe(context.Background(), "orders", "group", kafka.NewConfig(), listener)
+	assert.Nil(t, err)
+
+	// Records of the fast broker are not held by fetches from the slow one
+	assert.Eventually(t, func() bool {
+		messages := listener.getMessages()
+		return len(messages) > 0 && messages[0] == "fast"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Less(t, time.Since(started), time.Second)
+
+	assert.Eventually(t, func() bool {
+		return len(listener.getMessages()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"fast", "slow"}, listener.getMessages())
+}
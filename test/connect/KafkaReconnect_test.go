@@ -0,0 +1,79 @@
+package test_connect
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	ccon "github.com/pip-services3-gox/pip-services3-components-gox/connect"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	"github.com/stretchr/testify/assert"
+)
+
+// Discovery service that resolves brokers at a changing address
+type movingDiscovery struct {
+	lock sync.Mutex
+	uri  string
+}
+
+func (c *movingDiscovery) setUri(uri string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.uri = uri
+}
+
+func (c *movingDiscovery) Register(correlationId string, key string, connection *ccon.ConnectionParams) (*ccon.ConnectionParams, error) {
+	return connection, nil
+}
+
+func (c *movingDiscovery) ResolveOne(correlationId string, key string) (*ccon.ConnectionParams, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return ccon.NewConnectionParamsFromTuples("uri", c.uri), nil
+}
+
+func (c *movingDiscovery) ResolveAll(correlationId string, key string) ([]*ccon.ConnectionParams, error) {
+	connection, err := c.ResolveOne(correlationId, key)
+	return []*ccon.ConnectionParams{connection}, err
+}
+
+func TestKafkaConnectionReconnect(t *testing.T) {
+	previous := newProduceMockBroker(t, "orders", kafka.ErrNoError)
+	discovery := &movingDiscovery{uri: previous.Addr()}
+
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.discovery_key", "kafka",
+			"options.rtt_interval", 0,
+			"options.max_retries", 0,
+		),
+	)
+	connection.SetReferences(context.Background(), cref.NewReferencesFromTuples(context.Background(),
+		cref.NewDescriptor("pip-services", "discovery", "memory", "default", "1.0"), discovery,
+	))
+	assert.Nil(t, connection.Open(context.Background(), ""))
+	defer connection.Close(context.Background(), "")
+	assert.Nil(t, publishTestMessage(connection))
+
+	// The broker moves to another address
+	previous.Close()
+	broker := newProduceMockBroker(t, "orders", kafka.ErrNoError)
+	discovery.setUri(broker.Addr())
+
+	// Publishing to unreachable brokers recreates the producer from resolved addresses
+	assert.Nil(t, publishTestMessage(connection))
+	assert.Equal(t, 1, countProduceRequests(broker))
+
+	// Producers are not recreated again within the connect timeout
+	broker.Close()
+	next := newProduceMockBroker(t, "orders", kafka.ErrNoError)
+	defer next.Close()
+	discovery.setUri(next.Addr())
+
+	assert.NotNil(t, publishTestMessage(connection))
+	assert.Equal(t, 0, countProduceRequests(next))
+}
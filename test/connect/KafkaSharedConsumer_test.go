@@ -0,0 +1,216 @@
+package test_connect
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	"github.com/stretchr/testify/assert"
+)
+
+// Listener that records sessions and messages of a topic
+type testGroupListener struct {
+	lock     sync.Mutex
+	ready    chan bool
+	setups   int
+	messages []string
+	// Blocks Setup until the channel is closed
+	block chan struct{}
+	// Ends claims when the channel is closed
+	leave chan struct{}
+}
+
+func newTestGroupListener() *testGroupListener {
+	return &testGroupListener{ready: make(chan bool)}
+}
+
+func (c *testGroupListener) Setup(session kafka.ConsumerGroupSession) error {
+	if c.block != nil {
+		<-c.block
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.setups++
+	close(c.ready)
+	return nil
+}
+
+func (c *testGroupListener) Cleanup(session kafka.ConsumerGroupSession) error {
+	return nil
+}
+
+func (c *testGroupListener) ConsumeClaim(session kafka.ConsumerGroupSession, claim kafka.ConsumerGroupClaim) error {
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			c.lock.Lock()
+			c.messages = append(c.messages, string(msg.Value))
+			c.lock.Unlock()
+		case <-c.leave:
+			return nil
+		}
+	}
+}
+
+func (c *testGroupListener) Ready() chan bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ready
+}
+
+func (c *testGroupListener) SetReady(chFlag chan bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ready = chFlag
+}
+
+func (c *testGroupListener) getSetups() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.setups
+}
+
+func (c *testGroupListener) getMessages() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string{}, c.messages...)
+}
+
+// Starts a broker that coordinates the group and assigns partition 0 of the topics to every member
+func newGroupMockBroker(t *testing.T, groupId string, topics ...string) *kafka.MockBroker {
+	broker := kafka.NewMockBroker(t, 1)
+
+	metadata := kafka.NewMockMetadataResponse(t).
+		SetBroker(broker.Addr(), broker.BrokerID()).
+		SetController(broker.BrokerID())
+	offsets := kafka.NewMockOffsetResponse(t)
+	fetchedOffsets := kafka.NewMockOffsetFetchResponse(t)
+	fetch := kafka.NewMockFetchResponse(t, 1)
+	assignment := &kafka.ConsumerGroupMemberAssignment{Topics: map[string][]int32{}}
+	for _, topic := range topics {
+		metadata.SetLeader(topic, 0, broker.BrokerID())
+		offsets.SetOffset(topic, 0, kafka.OffsetOldest, 0).SetOffset(topic, 0, kafka.OffsetNewest, 1)
+		fetchedOffsets.SetOffset(groupId, topic, 0, 0, "", kafka.ErrNoError)
+		fetch.SetMessage(topic, 0, 0, kafka.StringEncoder(topic))
+		assignment.Topics[topic] = []int32{0}
+	}
+
+	broker.SetHandlerByMap(map[string]kafka.MockResponse{
+		"MetadataRequest":    metadata,
+		"ApiVersionsRequest": kafka.NewMockApiVersionsResponse(t),
+		"OffsetRequest":      offsets,
+		"FindCoordinatorRequest": kafka.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(kafka.CoordinatorGroup, groupId, broker),
+		"JoinGroupRequest":    kafka.NewMockJoinGroupResponse(t).SetGroupProtocol(kafka.RangeBalanceStrategyName),
+		"SyncGroupRequest":    kafka.NewMockSyncGroupResponse(t).SetMemberAssignment(assignment),
+		"HeartbeatRequest":    kafka.NewMockHeartbeatResponse(t),
+		"LeaveGroupRequest":   kafka.NewMockLeaveGroupResponse(t),
+		"OffsetFetchRequest":  fetchedOffsets,
+		"OffsetCommitRequest": kafka.NewMockOffsetCommitResponse(t),
+		"FetchRequest":        fetch,
+	})
+	return broker
+}
+
+func newSharedConsumerConnection(t *testing.T, broker *kafka.MockBroker) *connect.KafkaConnection {
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			"connection.uri", broker.Addr(),
+			"options.rtt_interval", 0,
+			"options.request_timeout", 1000,
+			"options.shared_consumer", true,
+		),
+	)
+	assert.Nil(t, connection.Open(context.Background(), ""))
+	return connection
+}
+
+func TestKafkaConnectionSharedConsumer(t *testing.T) {
+	broker := newGroupMockBroker(t, "group", "orders", "payments")
+	defer broker.Close()
+	connection := newSharedConsumerConnection(t, broker)
+	defer connection.Close(context.Background(), "")
+
+	config := kafka.NewConfig()
+	orders := newTestGroupListener()
+	err := connection.Subscribe(context.Background(), "orders", "group", config, orders)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return len(orders.getMessages()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "orders", orders.getMessages()[0])
+
+	// The group consumes a topic by one listener only
+	err = connection.Subscribe(context.Background(), "orders", "group", config, orders)
+	assert.Nil(t, err)
+	err = connection.Subscribe(context.Background(), "orders", "group", config, newTestGroupListener())
+	assert.NotNil(t, err)
+	assert.Equal(t, "ALREADY_SUBSCRIBED", err.(*cerr.ApplicationError).Code)
+
+	// Added topics restart the session, started listeners get new ready channels
+	// and the added listener keeps the channel its subscriber waits for
+	payments := newTestGroupListener()
+	err = connection.Subscribe(context.Background(), "payments", "group", config, payments)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, payments.getSetups())
+	assert.Eventually(t, func() bool {
+		return orders.getSetups() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return len(payments.getMessages()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "payments", payments.getMessages()[0])
+
+	// Removed topics restart the session with the remaining ones
+	err = connection.Unsubscribe(context.Background(), "payments", "group", payments)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return orders.getSetups() == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, payments.getSetups())
+
+	err = connection.Unsubscribe(context.Background(), "orders", "group", orders)
+	assert.Nil(t, err)
+}
+
+func TestKafkaConnectionSharedConsumerTimeout(t *testing.T) {
+	broker := newGroupMockBroker(t, "group", "orders", "payments")
+	defer broker.Close()
+	connection := newSharedConsumerConnection(t, broker)
+	defer connection.Close(context.Background(), "")
+
+	config := kafka.NewConfig()
+	orders := newTestGroupListener()
+	err := connection.Subscribe(context.Background(), "orders", "group", config, orders)
+	assert.Nil(t, err)
+
+	// Topics that don't start in time are removed from the shared consumer
+	slow := newTestGroupListener()
+	slow.block = make(chan struct{})
+	err = connection.Subscribe(context.Background(), "payments", "group", config, slow)
+	assert.NotNil(t, err)
+	assert.Equal(t, "CONSUME_FAILED", err.(*cerr.ApplicationError).Code)
+	setups := orders.getSetups()
+	close(slow.block)
+
+	// The session is restarted with the remaining topics
+	assert.Eventually(t, func() bool {
+		return orders.getSetups() > setups
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The topic can be subscribed again
+	payments := newTestGroupListener()
+	err = connection.Subscribe(context.Background(), "payments", "group", config, payments)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, payments.getSetups())
+}
@@ -0,0 +1,172 @@
+package test_connect
+
+import (
+	"context"
+	"testing"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	"github.com/stretchr/testify/assert"
+)
+
+// Starts a broker that controls the cluster and leads partitions of the topic
+func newAdminMockBroker(t *testing.T, topic string, partitions int) *kafka.MockBroker {
+	broker := kafka.NewMockBroker(t, 1)
+	metadata := kafka.NewMockMetadataResponse(t).
+		SetBroker(broker.Addr(), broker.BrokerID()).
+		SetController(broker.BrokerID())
+	for partition := 0; partition < partitions; partition++ {
+		metadata.SetLeader(topic, int32(partition), broker.BrokerID())
+	}
+
+	broker.SetHandlerByMap(map[string]kafka.MockResponse{
+		"MetadataRequest":                metadata,
+		"ApiVersionsRequest":             kafka.NewMockApiVersionsResponse(t),
+		"CreateTopicsRequest":            kafka.NewMockCreateTopicsResponse(t),
+		"CreatePartitionsRequest":        kafka.NewMockCreatePartitionsResponse(t),
+		"DescribeConfigsRequest":         kafka.NewMockDescribeConfigsResponse(t),
+		"AlterConfigsRequest":            kafka.NewMockAlterConfigsResponse(t),
+		"IncrementalAlterConfigsRequest": kafka.NewMockIncrementalAlterConfigsResponse(t),
+	})
+	return broker
+}
+
+func newAdminConnection(t *testing.T, broker *kafka.MockBroker, options ...any) *connect.KafkaConnection {
+	connection := connect.NewKafkaConnection()
+	connection.Configure(context.Background(),
+		cconf.NewConfigParamsFromTuples(
+			append([]any{
+				"connection.uri", broker.Addr(),
+				"options.rtt_interval", 0,
+			}, options...)...,
+		),
+	)
+	assert.Nil(t, connection.Open(context.Background(), ""))
+	return connection
+}
+
+// Returns requests of the type received by the broker
+func findRequests[T any](broker *kafka.MockBroker) []T {
+	requests := []T{}
+	for _, entry := range broker.History() {
+		if request, ok := entry.Request.(T); ok {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+func TestKafkaConnectionCreateQueueConfig(t *testing.T) {
+	broker := newAdminMockBroker(t, "orders", 1)
+	defer broker.Close()
+	connection := newAdminConnection(t, broker,
+		"options.num_partitions", 3,
+		"options.retention_ms", 60000,
+		"options.cleanup_policy", "compact",
+		"options.min_insync_replicas", 2,
+	)
+	defer connection.Close(context.Background(), "")
+
+	// Created topics get the declared partitions and config entries
+	err := connection.CreateQueue("invoices")
+	assert.Nil(t, err)
+
+	requests := findRequests[*kafka.CreateTopicsRequest](broker)
+	assert.Len(t, requests, 1)
+	detail := requests[0].TopicDetails["invoices"]
+	assert.NotNil(t, detail)
+	assert.Equal(t, int32(3), detail.NumPartitions)
+	assert.Equal(t, int16(1), detail.ReplicationFactor)
+	assert.Len(t, detail.ConfigEntries, 3)
+	assert.Equal(t, "60000", *detail.ConfigEntries["retention.ms"])
+	assert.Equal(t, "compact", *detail.ConfigEntries["cleanup.policy"])
+	assert.Equal(t, "2", *detail.ConfigEntries["min.insync.replicas"])
+}
+
+func TestKafkaConnectionCreateQueueDefaultConfig(t *testing.T) {
+	broker := newAdminMockBroker(t, "orders", 1)
+	defer broker.Close()
+	connection := newAdminConnection(t, broker)
+	defer connection.Close(context.Background(), "")
+
+	// Topics without declared entries take broker defaults
+	err := connection.CreateQueue("invoices")
+	assert.Nil(t, err)
+
+	requests := findRequests[*kafka.CreateTopicsRequest](broker)
+	assert.Len(t, requests, 1)
+	detail := requests[0].TopicDetails["invoices"]
+	assert.Equal(t, int32(1), detail.NumPartitions)
+	assert.Len(t, detail.ConfigEntries, 0)
+}
+
+func TestKafkaConnectionReadQueueDrift(t *testing.T) {
+	broker := newAdminMockBroker(t, "orders", 1)
+	defer broker.Close()
+
+	// Matching topics have no drift and their config is not read without declared entries
+	connection := newAdminConnection(t, broker)
+	drift, err := connection.ReadQueueDrift("orders")
+	assert.Nil(t, err)
+	assert.Len(t, drift, 0)
+	assert.Len(t, findRequests[*kafka.DescribeConfigsRequest](broker), 0)
+	assert.Nil(t, connection.Close(context.Background(), ""))
+
+	// Differences of partitions and config entries are reported
+	connection = newAdminConnection(t, broker,
+		"options.num_partitions", 3,
+		"options.retention_ms", 60000,
+	)
+	defer connection.Close(context.Background(), "")
+	drift, err = connection.ReadQueueDrift("orders")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"partitions: 1, declared: 3",
+		"retention.ms: 5000, declared: 60000",
+	}, drift)
+}
+
+func TestKafkaConnectionAlignQueue(t *testing.T) {
+	broker := newAdminMockBroker(t, "orders", 1)
+	defer broker.Close()
+	connection := newAdminConnection(t, broker,
+		"options.num_partitions", 3,
+		"options.retention_ms", 60000,
+		"options.cleanup_policy", "compact",
+	)
+	defer connection.Close(context.Background(), "")
+
+	// Missing partitions are added and config entries are set
+	err := connection.AlignQueue("orders")
+	assert.Nil(t, err)
+
+	partitionRequests := findRequests[*kafka.CreatePartitionsRequest](broker)
+	assert.Len(t, partitionRequests, 1)
+	assert.Equal(t, int32(3), partitionRequests[0].TopicPartitions["orders"].Count)
+
+	// Clients older than Kafka 2.3 replace all overrides of the topic,
+	// so overrides with returned values are kept and defaults are not turned into overrides
+	assert.Len(t, findRequests[*kafka.IncrementalAlterConfigsRequest](broker), 0)
+	configRequests := findRequests[*kafka.AlterConfigsRequest](broker)
+	assert.Len(t, configRequests, 1)
+	resource := configRequests[0].Resources[0]
+	assert.Equal(t, kafka.TopicResource, resource.Type)
+	assert.Equal(t, "orders", resource.Name)
+	assert.Len(t, resource.ConfigEntries, 2)
+	assert.Equal(t, "60000", *resource.ConfigEntries["retention.ms"])
+	assert.Equal(t, "compact", *resource.ConfigEntries["cleanup.policy"])
+}
+
+func TestKafkaConnectionAlignQueuePartitions(t *testing.T) {
+	broker := newAdminMockBroker(t, "orders", 3)
+	defer broker.Close()
+	connection := newAdminConnection(t, broker)
+	defer connection.Close(context.Background(), "")
+
+	// Topics with enough partitions and no declared entries are not altered
+	err := connection.AlignQueue("orders")
+	assert.Nil(t, err)
+	assert.Len(t, findRequests[*kafka.CreatePartitionsRequest](broker), 0)
+	assert.Len(t, findRequests[*kafka.IncrementalAlterConfigsRequest](broker), 0)
+}
@@ -0,0 +1,42 @@
+package test_connect
+
+import (
+	"testing"
+
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaTopicFilter(t *testing.T) {
+	names := []string{"orders.created", "__consumer_offsets", "orders.deleted", "_schemas", "users", "orders.archived"}
+
+	// Nil filters select all names
+	var all *connect.KafkaTopicFilter
+	selected, err := all.Select(names, 0, 0)
+	assert.Nil(t, err)
+	assert.Len(t, selected, 6)
+
+	filter := connect.NewKafkaTopicFilter()
+	filter.ExcludeInternal = true
+	selected, err = filter.Select(names, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"orders.archived", "orders.created", "orders.deleted", "users"}, selected)
+
+	filter.Prefix = "orders."
+	filter.Pattern = "d$"
+	selected, err = filter.Select(names, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"orders.archived", "orders.created", "orders.deleted"}, selected)
+
+	// Pages of sorted names
+	selected, err = filter.Select(names, 1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"orders.created"}, selected)
+	selected, err = filter.Select(names, 5, 1)
+	assert.Nil(t, err)
+	assert.Empty(t, selected)
+
+	filter.Pattern = "("
+	_, err = filter.Select(names, 0, 0)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,184 @@
+package test_containers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+)
+
+// Image of the single-node broker. Redpanda speaks the Kafka protocol and starts in seconds.
+const KafkaImage = "docker.redpanda.com/redpandadata/redpanda:v23.1.13"
+
+// KafkaContainer is a single-node Kafka compatible broker running in Docker.
+type KafkaContainer struct {
+	Id   string
+	Host string
+	Port int
+}
+
+//	StartKafkaContainer starts a broker container and waits until it accepts Kafka clients.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- timeout time.Duration	time to wait for the broker readiness
+//	Returns: the started container or error if Docker is not available or the broker is not ready.
+func StartKafkaContainer(ctx context.Context, timeout time.Duration) (*KafkaContainer, error) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return nil, err
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := exec.CommandContext(ctx, "docker", "run", "-d", "--rm",
+		"-p", fmt.Sprintf("%d:9092", port),
+		KafkaImage,
+		"redpanda", "start",
+		"--overprovisioned", "--smp", "1", "--memory", "512M", "--reserve-memory", "0M",
+		"--node-id", "0", "--check=false",
+		"--kafka-addr", "PLAINTEXT://0.0.0.0:9092",
+		"--advertise-kafka-addr", fmt.Sprintf("PLAINTEXT://127.0.0.1:%d", port),
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start kafka container: %w", err)
+	}
+
+	container := &KafkaContainer{
+		Id:   strings.TrimSpace(string(out)),
+		Host: "127.0.0.1",
+		Port: port,
+	}
+
+	err = container.waitReady(ctx, timeout)
+	if err != nil {
+		_ = container.Stop(context.Background())
+		return nil, err
+	}
+	return container, nil
+}
+
+// Waits until the broker returns metadata
+func (c *KafkaContainer) waitReady(ctx context.Context, timeout time.Duration) error {
+	config := kafka.NewConfig()
+	config.Net.DialTimeout = time.Second
+	deadline := time.Now().Add(timeout)
+	address := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+
+	for time.Now().Before(deadline) {
+		client, err := kafka.NewClient([]string{address}, config)
+		if err == nil {
+			brokers := client.Brokers()
+			client.Close()
+			if len(brokers) > 0 {
+				return nil
+			}
+		}
+
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return errors.New("kafka container is not ready after " + timeout.String())
+}
+
+//	Config returns connection parameters of the broker for queues and connections.
+//	Returns: configuration parameters.
+func (c *KafkaContainer) Config() *cconf.ConfigParams {
+	return cconf.NewConfigParamsFromTuples(
+		"connection.host", c.Host,
+		"connection.port", c.Port,
+	)
+}
+
+//	Stop stops and removes the container.
+//	Parameters:
+//		- ctx context.Context	operation context
+//	Returns: error or nil for success.
+func (c *KafkaContainer) Stop(ctx context.Context) error {
+	return exec.CommandContext(ctx, "docker", "stop", c.Id).Run()
+}
+
+var (
+	sharedLock      sync.Mutex
+	sharedContainer *KafkaContainer
+	sharedErr       error
+	sharedStarted   bool
+)
+
+//	GetKafkaConfig returns connection parameters of the broker used by integration tests.
+//	When KAFKA_SERVICE_URI or KAFKA_SERVICE_HOST are set they are used as they are.
+//	Otherwise a shared broker container is started on the first call,
+//	unless KAFKA_CONTAINER is set to "false".
+//	Returns: configuration parameters or nil when no broker is available.
+func GetKafkaConfig() *cconf.ConfigParams {
+	uri := os.Getenv("KAFKA_SERVICE_URI")
+	host := os.Getenv("KAFKA_SERVICE_HOST")
+	if uri != "" || host != "" {
+		port := os.Getenv("KAFKA_SERVICE_PORT")
+		if port == "" {
+			port = "9092"
+		}
+		return cconf.NewConfigParamsFromTuples(
+			"connection.uri", uri,
+			"connection.host", host,
+			"connection.port", port,
+			"credential.mechanism", "plain",
+			"credential.username", os.Getenv("KAFKA_USER"),
+			"credential.password", os.Getenv("KAFKA_PASS"),
+		)
+	}
+
+	if os.Getenv("KAFKA_CONTAINER") == "false" {
+		return nil
+	}
+
+	sharedLock.Lock()
+	defer sharedLock.Unlock()
+
+	if !sharedStarted {
+		sharedStarted = true
+		sharedContainer, sharedErr = StartKafkaContainer(context.Background(), 60*time.Second)
+		if sharedErr != nil {
+			fmt.Fprintln(os.Stderr, "Kafka container is not available:", sharedErr)
+		}
+	}
+	if sharedContainer == nil {
+		return nil
+	}
+	return sharedContainer.Config()
+}
+
+//	StopKafka stops the shared broker container if it was started.
+//	Test packages call it from TestMain after all tests are run.
+func StopKafka() {
+	sharedLock.Lock()
+	defer sharedLock.Unlock()
+
+	if sharedContainer != nil {
+		_ = sharedContainer.Stop(context.Background())
+		sharedContainer = nil
+	}
+}
+
+// Finds a free local port to publish the broker on
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,44 @@
+package test_load
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	load "github.com/pip-services3-gox/pip-services3-kafka-gox/load"
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaLoadGeneratorAndVerifier(t *testing.T) {
+	ctx := context.Background()
+
+	queue := queues.NewChaosKafkaMessageQueue(queues.NewMockKafkaMessageQueue("load"))
+	queue.Configure(ctx, cconf.NewConfigParamsFromTuples(
+		"options.duplicate_rate", 1,
+	))
+	_ = queue.Open(ctx, "")
+	defer queue.Close(ctx, "")
+
+	generator := load.NewKafkaLoadGenerator(queue)
+	generator.Configure(ctx, cconf.NewConfigParamsFromTuples(
+		"options.rate", 0,
+		"options.message_count", 100,
+		"options.message_size", 64,
+	))
+
+	verifier := load.NewKafkaLoadVerifier(queue)
+	verifier.Start(ctx, "")
+	time.Sleep(50 * time.Millisecond)
+
+	sent, err := generator.Run(ctx, "")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), sent)
+
+	report := verifier.Stop(ctx, "", sent)
+	assert.Equal(t, int64(0), report.Lost)
+	assert.True(t, report.Duplicated > 0)
+	assert.Equal(t, report.Received-100, report.Duplicated)
+	assert.True(t, report.LatencyMax >= report.LatencyP50)
+}
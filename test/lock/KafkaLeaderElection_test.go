@@ -0,0 +1,85 @@
+package test_lock
+
+import (
+	"context"
+	"testing"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	lock "github.com/pip-services3-gox/pip-services3-kafka-gox/lock"
+	"github.com/stretchr/testify/assert"
+)
+
+type electionSession struct {
+	ctx    context.Context
+	claims map[string][]int32
+}
+
+func (c *electionSession) Claims() map[string][]int32                                               { return c.claims }
+func (c *electionSession) MemberID() string                                                         { return "" }
+func (c *electionSession) GenerationID() int32                                                      { return 0 }
+func (c *electionSession) MarkOffset(topic string, partition int32, offset int64, metadata string)  {}
+func (c *electionSession) Commit()                                                                  {}
+func (c *electionSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {}
+func (c *electionSession) MarkMessage(msg *kafka.ConsumerMessage, metadata string)                  {}
+func (c *electionSession) Context() context.Context                                                 { return c.ctx }
+
+func TestKafkaLeaderElection(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	_ = connection.Open(ctx, "")
+
+	election := lock.NewKafkaLeaderElection()
+	election.Configure(ctx, cconf.NewConfigParamsFromTuples("group_id", "scheduler"))
+	election.Connection = connection
+
+	var leadership context.Context
+	resigned := 0
+	election.SetElectedCallback(func(ctx context.Context) {
+		leadership = ctx
+	})
+	election.SetResignedCallback(func(ctx context.Context) {
+		resigned++
+	})
+
+	err := election.Open(ctx, "")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), connection.Topics["elections"])
+	assert.False(t, election.IsLeader())
+
+	listener := connection.Listeners["elections"]
+
+	// Instances without the election partition are followers
+	sessionCtx, endSession := context.WithCancel(ctx)
+	_ = listener.Setup(&electionSession{ctx: sessionCtx, claims: map[string][]int32{}})
+	assert.False(t, election.IsLeader())
+	assert.Nil(t, leadership)
+	endSession()
+	_ = listener.Cleanup(&electionSession{ctx: sessionCtx})
+	assert.Equal(t, 0, resigned)
+
+	listener.SetReady(make(chan bool))
+	sessionCtx, endSession = context.WithCancel(ctx)
+	_ = listener.Setup(&electionSession{ctx: sessionCtx, claims: map[string][]int32{"elections": {0}}})
+	assert.True(t, election.IsLeader())
+	assert.NotNil(t, leadership)
+	assert.Nil(t, leadership.Err())
+
+	// Leadership is lost at the end of the session
+	endSession()
+	_ = listener.Cleanup(&electionSession{ctx: sessionCtx})
+	assert.False(t, election.IsLeader())
+	assert.NotNil(t, leadership.Err())
+	assert.Equal(t, 1, resigned)
+
+	// Closing resigns the leadership
+	listener.SetReady(make(chan bool))
+	_ = listener.Setup(&electionSession{ctx: ctx, claims: map[string][]int32{"elections": {0}}})
+	assert.True(t, election.IsLeader())
+
+	err = election.Close(ctx, "")
+	assert.Nil(t, err)
+	assert.False(t, election.IsLeader())
+	assert.Equal(t, 2, resigned)
+}
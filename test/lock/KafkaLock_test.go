@@ -0,0 +1,97 @@
+package test_lock
+
+import (
+	"context"
+	"testing"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	lock "github.com/pip-services3-gox/pip-services3-kafka-gox/lock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLock(connection *fixtures.FakeKafkaConnection) *lock.KafkaLock {
+	l := lock.NewKafkaLock()
+	l.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.retry_timeout", 10,
+	))
+	l.Connection = connection
+	return l
+}
+
+func TestKafkaLockTopicNotFound(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	_ = connection.Open(ctx, "")
+
+	l := newTestLock(connection)
+	err := l.Open(ctx, "")
+	assert.NotNil(t, err)
+}
+
+func TestKafkaLockAcquireRelease(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	connection.Topics["locks"] = 3
+	_ = connection.Open(ctx, "")
+
+	lock1 := newTestLock(connection)
+	lock2 := newTestLock(connection)
+	assert.Nil(t, lock1.Open(ctx, ""))
+	assert.Nil(t, lock2.Open(ctx, ""))
+
+	ok, err := lock1.TryAcquireLock(ctx, "", "key1", 10000)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	token1, ok := lock1.GetFencingToken("key1")
+	assert.True(t, ok)
+
+	ok, err = lock2.TryAcquireLock(ctx, "", "key1", 10000)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	err = lock2.AcquireLock(ctx, "", "key1", 10000, 50)
+	assert.NotNil(t, err)
+
+	// Other keys are not affected
+	ok, err = lock2.TryAcquireLock(ctx, "", "key2", 10000)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// Releasing a lock held by another instance has no effect
+	err = lock2.ReleaseLock(ctx, "", "key1")
+	assert.Nil(t, err)
+	ok, _ = lock2.TryAcquireLock(ctx, "", "key1", 10000)
+	assert.False(t, ok)
+
+	err = lock1.ReleaseLock(ctx, "", "key1")
+	assert.Nil(t, err)
+
+	ok, err = lock2.TryAcquireLock(ctx, "", "key1", 10000)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	token2, ok := lock2.GetFencingToken("key1")
+	assert.True(t, ok)
+	assert.Greater(t, token2, token1)
+}
+
+func TestKafkaLockExpiration(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	connection.Topics["locks"] = 1
+	_ = connection.Open(ctx, "")
+
+	lock1 := newTestLock(connection)
+	lock2 := newTestLock(connection)
+	_ = lock1.Open(ctx, "")
+	_ = lock2.Open(ctx, "")
+
+	ok, _ := lock1.TryAcquireLock(ctx, "", "key1", 50)
+	assert.True(t, ok)
+
+	err := lock2.AcquireLock(ctx, "", "key1", 10000, 1000)
+	assert.Nil(t, err)
+
+	_, ok = lock1.GetFencingToken("key1")
+	assert.False(t, ok)
+}
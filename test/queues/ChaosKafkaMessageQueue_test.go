@@ -0,0 +1,64 @@
+package test_queues
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+func newChaosKafkaMessageQueue(options ...any) (*queues.ChaosKafkaMessageQueue, *queues.MockKafkaMessageQueue) {
+	mock := queues.NewMockKafkaMessageQueue("TestQueue")
+	queue := queues.NewChaosKafkaMessageQueue(mock)
+	queue.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		append([]any{"options.seed", 1}, options...)...,
+	))
+	_ = queue.Open(context.Background(), "")
+	return queue, mock
+}
+
+func TestChaosKafkaMessageQueueErrors(t *testing.T) {
+	ctx := context.Background()
+	queue, mock := newChaosKafkaMessageQueue("options.send_error_rate", 1)
+	defer queue.Close(ctx, "")
+
+	err := queue.Send(ctx, "", cqueues.NewMessageEnvelope("123", "Test", []byte("A")))
+	assert.NotNil(t, err)
+	assert.Len(t, mock.SentMessages(), 0)
+}
+
+func TestChaosKafkaMessageQueueDuplicates(t *testing.T) {
+	ctx := context.Background()
+	queue, mock := newChaosKafkaMessageQueue("options.duplicate_rate", 1)
+	defer queue.Close(ctx, "")
+
+	err := queue.Send(ctx, "", cqueues.NewMessageEnvelope("123", "Test", []byte("A")))
+	assert.Nil(t, err)
+	assert.Len(t, mock.SentMessages(), 2)
+}
+
+func TestChaosKafkaMessageQueueReordering(t *testing.T) {
+	ctx := context.Background()
+	queue, mock := newChaosKafkaMessageQueue("options.reorder_rate", 1)
+	defer queue.Close(ctx, "")
+
+	_ = queue.Send(ctx, "", cqueues.NewMessageEnvelope("1", "Test", []byte("A")))
+	_ = queue.Send(ctx, "", cqueues.NewMessageEnvelope("2", "Test", []byte("B")))
+
+	sent := mock.SentMessages()
+	assert.Len(t, sent, 2)
+	assert.Equal(t, "2", sent[0].CorrelationId)
+	assert.Equal(t, "1", sent[1].CorrelationId)
+
+	// Received messages are reordered again
+	message, err := queue.Receive(ctx, "", 100*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", message.CorrelationId)
+	message, err = queue.Receive(ctx, "", 100*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", message.CorrelationId)
+}
@@ -0,0 +1,64 @@
+package test_queues
+
+import (
+	"context"
+	"testing"
+
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebeziumDecode(t *testing.T) {
+	ctx := context.Background()
+	transformer := queues.NewDebeziumMessageTransformer()
+
+	message := cqueues.NewMessageEnvelope("", "", []byte(`{
+		"schema": {"type": "struct"},
+		"payload": {
+			"before": {"id": 1, "name": "A"},
+			"after": {"id": 1, "name": "B"},
+			"op": "u",
+			"source": {"connector": "postgresql", "db": "shop", "table": "customers"},
+			"ts_ms": 1000
+		}
+	}`))
+
+	err := transformer.Decode(ctx, message)
+	assert.Nil(t, err)
+	assert.Equal(t, queues.DebeziumUpdate, message.MessageType)
+
+	change, err := queues.GetDebeziumChangeEvent(message)
+	assert.Nil(t, err)
+	assert.Equal(t, "A", change.Before["name"])
+	assert.Equal(t, "B", change.After["name"])
+	assert.Equal(t, "customers", change.Source.Table)
+	assert.Equal(t, int64(1000), change.TsMs)
+
+	// Encoded changes are decoded back
+	err = transformer.Encode(ctx, message)
+	assert.Nil(t, err)
+	err = transformer.Decode(ctx, message)
+	assert.Nil(t, err)
+	change, _ = queues.GetDebeziumChangeEvent(message)
+	assert.Equal(t, queues.DebeziumUpdate, change.Operation)
+	assert.Equal(t, "B", change.After["name"])
+}
+
+func TestDebeziumDecodeTombstone(t *testing.T) {
+	ctx := context.Background()
+	transformer := queues.NewDebeziumMessageTransformer()
+
+	message := cqueues.NewMessageEnvelope("", "", nil)
+	err := transformer.Decode(ctx, message)
+	assert.Nil(t, err)
+	assert.Equal(t, queues.DebeziumTombstone, message.MessageType)
+
+	change, err := queues.GetDebeziumChangeEvent(message)
+	assert.Nil(t, err)
+	assert.Nil(t, change)
+
+	message = cqueues.NewMessageEnvelope("", "", []byte(`{"op": "x"}`))
+	err = transformer.Decode(ctx, message)
+	assert.NotNil(t, err)
+}
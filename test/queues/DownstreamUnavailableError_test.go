@@ -0,0 +1,18 @@
+package test_queues
+
+import (
+	"testing"
+
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownstreamUnavailableError(t *testing.T) {
+	err := queues.NewDownstreamUnavailableError("123", "Database is down")
+	assert.True(t, queues.IsDownstreamUnavailableError(err))
+	assert.Equal(t, "123", err.CorrelationId)
+
+	assert.False(t, queues.IsDownstreamUnavailableError(nil))
+	assert.False(t, queues.IsDownstreamUnavailableError(cerr.NewConnectionError("123", "CONNECT_FAILED", "Failed")))
+}
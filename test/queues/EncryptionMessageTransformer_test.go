@@ -0,0 +1,53 @@
+package test_queues
+
+import (
+	"context"
+	"testing"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptionMessageTransformer(t *testing.T) {
+	ctx := context.Background()
+	keyStore := queues.NewMemoryKafkaKeyStore()
+
+	pipeline := queues.NewKafkaMessagePipeline()
+	pipeline.Configure(ctx, cconf.NewConfigParamsFromTuples(
+		"options.pipeline", "encrypt",
+	))
+
+	// Encryption fails without the key store
+	message := cqueues.NewMessageEnvelope("123", "Test", []byte("personal data"))
+	message.MessageId = "user1"
+	assert.NotNil(t, pipeline.Encode(ctx, message))
+
+	pipeline.SetReferences(ctx, cref.NewReferencesFromTuples(ctx,
+		cref.NewDescriptor("pip-services", "key-store", "memory", "default", "1.0"), keyStore,
+	))
+
+	first := cqueues.NewMessageEnvelope("123", "Test", []byte("personal data"))
+	first.MessageId = "user1"
+	assert.Nil(t, pipeline.Encode(ctx, first))
+	assert.NotContains(t, string(first.Message), "personal data")
+
+	second := cqueues.NewMessageEnvelope("123", "Test", []byte("other data"))
+	second.MessageId = "user2"
+	assert.Nil(t, pipeline.Encode(ctx, second))
+
+	// Payloads cannot be decrypted with keys of other subjects
+	moved := cqueues.NewMessageEnvelope("123", "Test", first.Message)
+	moved.MessageId = "user2"
+	assert.NotNil(t, pipeline.Decode(ctx, moved))
+
+	// Deleting the key erases messages of the subject only
+	assert.Nil(t, keyStore.DeleteKey(ctx, "123", "user1"))
+	assert.Nil(t, pipeline.Decode(ctx, first))
+	assert.Nil(t, first.Message)
+
+	assert.Nil(t, pipeline.Decode(ctx, second))
+	assert.Equal(t, "other data", string(second.Message))
+}
@@ -0,0 +1,42 @@
+package test_queues
+
+import (
+	"testing"
+
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaJsonSchemaValidate(t *testing.T) {
+	schema, err := queues.NewKafkaJsonSchema(`{
+		"type": "object",
+		"required": ["id", "amount"],
+		"additionalProperties": false,
+		"properties": {
+			"id": {"type": "string", "pattern": "^[0-9]+$"},
+			"amount": {"type": "number", "minimum": 0},
+			"status": {"enum": ["new", "paid"]},
+			"tags": {"type": "array", "maxItems": 2, "items": {"type": "string", "minLength": 1}}
+		}
+	}`)
+	assert.Nil(t, err)
+
+	results := schema.Validate([]byte(`{"id": "1", "amount": 10, "status": "paid", "tags": ["a"]}`))
+	assert.Len(t, results, 0)
+
+	results = schema.Validate([]byte(`{"id": "a", "amount": -1, "status": "done", "tags": ["", "b", "c"], "extra": true}`))
+	assert.Len(t, results, 6)
+
+	results = schema.Validate([]byte(`{"amount": 1}`))
+	assert.Len(t, results, 1)
+	assert.Equal(t, "id", results[0].Path())
+
+	results = schema.Validate([]byte("abc"))
+	assert.Len(t, results, 1)
+
+	assert.Nil(t, schema.ValidateAndReturnError("", map[string]any{"id": "1", "amount": 1.0}, false))
+	assert.NotNil(t, schema.ValidateAndReturnError("", []any{}, false))
+
+	_, err = queues.NewKafkaJsonSchema("{abc")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,40 @@
+package test_queues
+
+import (
+	"testing"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+func newReceivedEnvelope(messageType string, headers map[string]string) *cqueues.MessageEnvelope {
+	envelope := cqueues.NewMessageEnvelope("123", messageType, []byte("Test message"))
+	msg := &kafka.ConsumerMessage{}
+	for key, value := range headers {
+		msg.Headers = append(msg.Headers, &kafka.RecordHeader{Key: []byte(key), Value: []byte(value)})
+	}
+	envelope.SetReference(&connect.KafkaMessage{Message: msg})
+	return envelope
+}
+
+func TestKafkaMessageFilter(t *testing.T) {
+	filter := queues.NewKafkaMessageFilterFromConfig(cconf.NewConfigParamsFromTuples(
+		"options.filter_message_types", "order.created; order.updated",
+		"options.filter_headers", "tenant=abc",
+	))
+	assert.False(t, filter.IsEmpty())
+
+	assert.True(t, filter.Match(newReceivedEnvelope("order.created", map[string]string{"tenant": "abc"})))
+	assert.True(t, filter.Match(newReceivedEnvelope("order.updated", map[string]string{"tenant": "abc", "source": "web"})))
+	assert.False(t, filter.Match(newReceivedEnvelope("order.deleted", map[string]string{"tenant": "abc"})))
+	assert.False(t, filter.Match(newReceivedEnvelope("order.created", map[string]string{"tenant": "xyz"})))
+	assert.False(t, filter.Match(newReceivedEnvelope("order.created", nil)))
+
+	empty := queues.NewKafkaMessageFilterFromConfig(cconf.NewEmptyConfigParams())
+	assert.True(t, empty.IsEmpty())
+	assert.True(t, empty.Match(newReceivedEnvelope("any", nil)))
+}
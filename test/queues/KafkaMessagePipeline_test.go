@@ -0,0 +1,66 @@
+package test_queues
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+type upperCaseTransformer struct{}
+
+func (c *upperCaseTransformer) Encode(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	message.Message = []byte(strings.ToUpper(string(message.Message)))
+	return nil
+}
+
+func (c *upperCaseTransformer) Decode(ctx context.Context, message *cqueues.MessageEnvelope) error {
+	message.Message = []byte(strings.ToLower(string(message.Message)))
+	return nil
+}
+
+func TestKafkaMessagePipeline(t *testing.T) {
+	pipeline := queues.NewKafkaMessagePipeline()
+	pipeline.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.pipeline", "gzip;upper",
+	))
+	assert.NotNil(t, pipeline.Validate())
+
+	pipeline.Register("upper", &upperCaseTransformer{})
+	assert.Nil(t, pipeline.Validate())
+
+	envelope := cqueues.NewMessageEnvelope("123", "Test", []byte("test message"))
+	err := pipeline.Encode(context.Background(), envelope)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "test message", string(envelope.Message))
+
+	err = pipeline.Decode(context.Background(), envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, "test message", string(envelope.Message))
+
+	// Gzip is the outermost step, so raw payloads fail to decode
+	raw := cqueues.NewMessageEnvelope("123", "Test", []byte("TEST MESSAGE"))
+	err = pipeline.Decode(context.Background(), raw)
+	assert.NotNil(t, err)
+}
+
+func BenchmarkGzipMessageTransformer(b *testing.B) {
+	transformer := queues.NewGzipMessageTransformer()
+	payload := []byte(strings.Repeat(`{"id":"123","name":"test message"}`, 30))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		envelope := cqueues.NewMessageEnvelope("123", "Test", payload)
+		if err := transformer.Encode(context.Background(), envelope); err != nil {
+			b.Fatal(err)
+		}
+		if err := transformer.Decode(context.Background(), envelope); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
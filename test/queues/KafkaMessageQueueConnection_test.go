@@ -0,0 +1,1239 @@
+package test_queues
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	ccount "github.com/pip-services3-gox/pip-services3-components-gox/count"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFakeConnectedQueue(connection *fixtures.FakeKafkaConnection, options ...any) *queues.KafkaMessageQueue {
+	queue := queues.NewKafkaMessageQueue("TestQueue")
+	queue.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		append([]any{"topic", "test"}, options...)...,
+	))
+	queue.Connection = connection
+	_ = connection.Open(context.Background(), "")
+	return queue
+}
+
+// Fake connection that starts consumer sessions like KafkaConnection:
+// Subscribe waits until the listener is set up from another goroutine.
+type sessionKafkaConnection struct {
+	*fixtures.FakeKafkaConnection
+	lock    sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+func newSessionKafkaConnection(topics ...string) *sessionKafkaConnection {
+	return &sessionKafkaConnection{
+		FakeKafkaConnection: fixtures.NewFakeKafkaConnection(topics...),
+		cancels:             make(map[string]context.CancelFunc),
+	}
+}
+
+func (c *sessionKafkaConnection) Subscribe(ctx context.Context, topic string, groupId string, config *kafka.Config, listener connect.IKafkaMessageListener) error {
+	err := c.FakeKafkaConnection.Subscribe(ctx, topic, groupId, config, listener)
+	if err != nil {
+		return err
+	}
+
+	sessionCtx, cancel := context.WithCancel(context.Background())
+	c.lock.Lock()
+	c.cancels[topic] = cancel
+	c.lock.Unlock()
+
+	ready := listener.Ready()
+	go func() {
+		_ = listener.Setup(&offsetSession{ctx: sessionCtx})
+	}()
+
+	select {
+	case <-ready:
+		return nil
+	case <-time.After(time.Second):
+		return cerr.NewConnectionError("", "CONSUME_FAILED", "Consumer did not start")
+	}
+}
+
+func (c *sessionKafkaConnection) Unsubscribe(ctx context.Context, topic string, groupId string, listener connect.IKafkaMessageListener) error {
+	c.lock.Lock()
+	cancel := c.cancels[topic]
+	delete(c.cancels, topic)
+	c.lock.Unlock()
+
+	if cancel != nil {
+		cancel()
+		_ = listener.Cleanup(&offsetSession{ctx: context.Background()})
+	}
+	return c.FakeKafkaConnection.Unsubscribe(ctx, topic, groupId, listener)
+}
+
+func TestKafkaMessageQueueFakeConnectionSend(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection()
+	queue := newFakeConnectedQueue(connection)
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	assert.Contains(t, connection.Topics, "test")
+
+	err = queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "Test", []byte("abc")))
+	assert.Nil(t, err)
+	assert.Len(t, connection.GetPublished("test"), 1)
+}
+
+func TestKafkaMessageQueueMissingTopic(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection()
+	queue := newFakeConnectedQueue(connection, "options.autocreate", false)
+
+	err := queue.Open(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.Equal(t, "TOPIC_NOT_FOUND", err.(*cerr.ApplicationError).Code)
+	assert.Equal(t, cerr.Misconfiguration, err.(*cerr.ApplicationError).Category)
+
+	// Existing topics must have the declared partitions
+	connection.Topics["test"] = 2
+	queue = newFakeConnectedQueue(connection, "options.autocreate", false, "options.num_partitions", 3)
+	err = queue.Open(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.Equal(t, "NOT_ENOUGH_PARTITIONS", err.(*cerr.ApplicationError).Code)
+	assert.Equal(t, cerr.Misconfiguration, err.(*cerr.ApplicationError).Category)
+
+	queue = newFakeConnectedQueue(connection, "options.autocreate", false, "options.read_partitions", "2")
+	err = queue.Open(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.Equal(t, "PARTITION_NOT_FOUND", err.(*cerr.ApplicationError).Code)
+
+	queue = newFakeConnectedQueue(connection, "options.autocreate", false, "options.num_partitions", 2,
+		"options.read_partitions", "1")
+	err = queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Nil(t, queue.Close(context.Background(), ""))
+}
+
+func TestKafkaMessageQueueReconcile(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	connection.Drift = []string{"partitions: 1, declared: 3"}
+
+	// Drift is ignored or only reported without altering the topic
+	for _, mode := range []string{queues.ReconcileNone, queues.ReconcileWarn} {
+		queue := newFakeConnectedQueue(connection, "options.reconcile", mode)
+		err := queue.Open(context.Background(), "")
+		assert.Nil(t, err, mode)
+		assert.Len(t, connection.GetAligned(), 0, mode)
+		_ = queue.Close(context.Background(), "")
+	}
+
+	queue := newFakeConnectedQueue(connection, "options.reconcile", queues.ReconcileFail)
+	err := queue.Open(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.Equal(t, "TOPIC_CONFIG_DRIFT", err.(*cerr.ApplicationError).Code)
+
+	queue = newFakeConnectedQueue(connection, "options.reconcile", queues.ReconcileAlter)
+	err = queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"test"}, connection.GetAligned())
+	_ = queue.Close(context.Background(), "")
+
+	// Aligned topics are not altered again
+	queue = newFakeConnectedQueue(connection, "options.reconcile", queues.ReconcileAlter)
+	err = queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"test"}, connection.GetAligned())
+	_ = queue.Close(context.Background(), "")
+}
+
+func TestKafkaMessageQueueExportImportOffsets(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	connection.Committed["blue"] = map[string]map[int32]int64{"test": {0: 42}}
+	connection.CommittedMetadata["blue"] = map[string]map[int32]string{"test": {0: "host1"}}
+
+	blue := newFakeConnectedQueue(connection, "group_id", "blue")
+	err := blue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer blue.Close(context.Background(), "")
+
+	snapshot, err := blue.ExportOffsets(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Equal(t, "blue", snapshot.GroupId)
+	assert.Equal(t, int64(42), snapshot.Offsets[0])
+	assert.Equal(t, "host1", snapshot.Metadata[0])
+
+	// The snapshot survives serialization
+	data, err := json.Marshal(snapshot)
+	assert.Nil(t, err)
+	restored := &connect.KafkaOffsetSnapshot{}
+	err = json.Unmarshal(data, restored)
+	assert.Nil(t, err)
+
+	green := newFakeConnectedQueue(connection, "group_id", "green")
+	err = green.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer green.Close(context.Background(), "")
+
+	err = green.ImportOffsets(context.Background(), "", restored)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), connection.GetCommitted("green", "test")[0])
+	assert.Equal(t, "host1", connection.CommittedMetadata["green"]["test"][0])
+}
+
+// Connection that implements only the required IKafkaConnection operations
+type basicKafkaConnection struct {
+	connect.IKafkaConnection
+}
+
+func TestKafkaMessageQueueUnsupportedOperations(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection)
+	assert.Nil(t, queue.GetKafkaConnection())
+	queue.Connection = &basicKafkaConnection{IKafkaConnection: connection}
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	_, err = queue.ExportOffsets(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.Equal(t, "UNSUPPORTED_OPERATION", err.(*cerr.ApplicationError).Code)
+
+	err = queue.ImportOffsets(context.Background(), "", connect.NewKafkaOffsetSnapshot("test", "default"))
+	assert.NotNil(t, err)
+	assert.Equal(t, "UNSUPPORTED_OPERATION", err.(*cerr.ApplicationError).Code)
+
+	// The concrete connection is returned when the queue uses KafkaConnection
+	queue.Connection = connect.NewKafkaConnection()
+	assert.Same(t, queue.Connection, queue.GetKafkaConnection())
+}
+
+func TestKafkaMessageQueueScalingMetrics(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	connection.Lags = map[int32]int64{0: 3, 1: 4}
+	queue := newFakeConnectedQueue(connection,
+		"options.autosubscribe", true,
+		"options.metrics_interval", 50,
+	)
+	counters := ccount.NewLogCounters()
+	queue.SetReferences(context.Background(), cref.NewReferencesFromTuples(context.Background(),
+		cref.NewDescriptor("pip-services", "counters", "log", "default", "1.0"), counters,
+	))
+	queue.Connection = connection
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	assert.Eventually(t, func() bool {
+		counter, ok := counters.Get(context.Background(), "queue.TestQueue.consumer_lag", ccount.LastValue)
+		return ok && counter.Last() == 7
+	}, time.Second, 10*time.Millisecond)
+
+	_, ok := counters.Get(context.Background(), "queue.TestQueue.processing_rate", ccount.LastValue)
+	assert.True(t, ok)
+}
+
+func TestKafkaMessageQueuePeekFetched(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	connection.Committed["workers"] = map[string]map[int32]int64{"test": {0: 0}}
+	queue := newFakeConnectedQueue(connection, "autocommit", false, "group_id", "workers")
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	for _, value := range []string{"m0", "m1", "m2"} {
+		err = queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "Test", []byte(value)))
+		assert.Nil(t, err)
+	}
+
+	// Two messages are fetched by the consumer group, but not committed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 2)}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "test", Partition: 0, Offset: 0, Value: []byte("m0")}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "test", Partition: 0, Offset: 1, Value: []byte("m1")}
+	close(claim.messages)
+	go queue.ConsumeClaim(&offsetSession{ctx: ctx}, claim)
+
+	assert.Eventually(t, func() bool {
+		messages, err := queue.PeekBatch(context.Background(), "", 2)
+		return err == nil && len(messages) == 2 && messages[1].GetReference() != nil
+	}, time.Second, 10*time.Millisecond)
+
+	// Fetched messages are peeked from the buffer and skipped in the topic
+	messages, err := queue.PeekBatch(context.Background(), "", 3)
+	assert.Nil(t, err)
+	assert.Len(t, messages, 3)
+	values := []string{}
+	for _, message := range messages {
+		values = append(values, message.GetMessageAsString())
+	}
+	assert.Equal(t, []string{"m0", "m1", "m2"}, values)
+}
+
+func TestKafkaMessageQueueGetLag(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	connection.Lags = map[int32]int64{0: 3, 1: 4}
+	queue := newFakeConnectedQueue(connection)
+
+	_, _, err := queue.GetLag(context.Background(), "")
+	assert.NotNil(t, err)
+
+	err = queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	total, lags, err := queue.GetLag(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), total)
+	assert.Equal(t, int64(4), lags[1])
+}
+
+func TestKafkaMessageQueueLatencyMetrics(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection)
+	counters := ccount.NewLogCounters()
+	queue.SetReferences(context.Background(), cref.NewReferencesFromTuples(context.Background(),
+		cref.NewDescriptor("pip-services", "counters", "log", "default", "1.0"), counters,
+	))
+	queue.Connection = connection
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 1)}
+	claim.messages <- &kafka.ConsumerMessage{
+		Topic:     "test",
+		Timestamp: time.Now().Add(-100 * time.Millisecond),
+		Headers:   []*kafka.RecordHeader{{Key: []byte("message_type"), Value: []byte("Test")}},
+	}
+	go queue.ConsumeClaim(&offsetSession{ctx: ctx}, claim)
+
+	message, err := queue.Receive(context.Background(), "", time.Second)
+	assert.Nil(t, err)
+	assert.NotNil(t, message)
+	err = queue.Complete(context.Background(), message)
+	assert.Nil(t, err)
+
+	age, ok := counters.Get(context.Background(), "topic.test.Test.age", ccount.Interval)
+	assert.True(t, ok)
+	assert.GreaterOrEqual(t, age.Last(), float64(100))
+
+	latency, ok := counters.Get(context.Background(), "topic.test.Test.latency", ccount.Interval)
+	assert.True(t, ok)
+	assert.GreaterOrEqual(t, latency.Last(), age.Last())
+}
+
+func TestKafkaMessageQueueSentTime(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection()
+	queue := newFakeConnectedQueue(connection)
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	sentTime := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	envelope := cqueues.NewMessageEnvelope("123", "Test", []byte("abc"))
+	envelope.SentTime = sentTime
+	err = queue.Send(context.Background(), "", envelope)
+	assert.Nil(t, err)
+	assert.True(t, sentTime.Equal(connection.GetPublished("test")[0].Timestamp))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 1)}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "test", Timestamp: sentTime}
+	go queue.ConsumeClaim(&offsetSession{ctx: ctx}, claim)
+
+	message, err := queue.Receive(context.Background(), "", time.Second)
+	assert.Nil(t, err)
+	assert.True(t, sentTime.Equal(message.SentTime))
+}
+
+func TestKafkaMessageQueueIdleDetection(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	connection.Lags = map[int32]int64{0: 5}
+	queue := newFakeConnectedQueue(connection,
+		"options.autosubscribe", true,
+		"options.idle_timeout", 40,
+	)
+
+	idleLag := make(chan int64, 1)
+	queue.SetIdleCallback(func(ctx context.Context, idleFor time.Duration, lag int64) {
+		idleLag <- lag
+	})
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	select {
+	case lag := <-idleLag:
+		assert.Equal(t, int64(5), lag)
+	case <-time.After(time.Second):
+		assert.Fail(t, "Idle consumer was not reported")
+	}
+	assert.True(t, queue.IsIdle())
+}
+
+func TestKafkaMessageQueueReconfigure(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection)
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	queue.Reconfigure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.filter_message_types", "Accepted",
+	))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 2)}
+	claim.messages <- &kafka.ConsumerMessage{
+		Topic:   "test",
+		Headers: []*kafka.RecordHeader{{Key: []byte("message_type"), Value: []byte("Rejected")}},
+	}
+	claim.messages <- &kafka.ConsumerMessage{
+		Topic:   "test",
+		Headers: []*kafka.RecordHeader{{Key: []byte("message_type"), Value: []byte("Accepted")}},
+	}
+	go queue.ConsumeClaim(&offsetSession{ctx: ctx}, claim)
+
+	message, err := queue.Receive(context.Background(), "", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "Accepted", message.MessageType)
+}
+
+func TestKafkaMessageQueueSetTopic(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection, "options.autosubscribe", true)
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+	assert.NotNil(t, connection.GetListener("test"))
+
+	err = queue.SetTopic(context.Background(), "", "test_v2")
+	assert.Nil(t, err)
+	assert.Contains(t, connection.Topics, "test_v2")
+	assert.NotNil(t, connection.GetListener("test_v2"))
+	assert.Nil(t, connection.GetListener("test"))
+
+	err = queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "Test", []byte("abc")))
+	assert.Nil(t, err)
+	assert.Len(t, connection.GetPublished("test_v2"), 1)
+}
+
+type countingReceiver struct {
+	received chan *cqueues.MessageEnvelope
+}
+
+func (c *countingReceiver) ReceiveMessage(ctx context.Context, envelope *cqueues.MessageEnvelope, queue cqueues.IMessageQueue) error {
+	c.received <- envelope
+	return nil
+}
+
+func TestKafkaMessageQueueReceivers(t *testing.T) {
+	for _, mode := range []string{queues.ReceiveRoundRobin, queues.ReceiveBroadcast} {
+		connection := fixtures.NewFakeKafkaConnection("test")
+		queue := newFakeConnectedQueue(connection, "options.receive_mode", mode)
+
+		err := queue.Open(context.Background(), "")
+		assert.Nil(t, err)
+
+		receiver1 := &countingReceiver{received: make(chan *cqueues.MessageEnvelope, 2)}
+		receiver2 := &countingReceiver{received: make(chan *cqueues.MessageEnvelope, 2)}
+		queue.AddReceiver(receiver2)
+		queue.BeginListen(context.Background(), "", receiver1)
+		time.Sleep(50 * time.Millisecond)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 2)}
+		claim.messages <- &kafka.ConsumerMessage{Topic: "test", Offset: 0}
+		claim.messages <- &kafka.ConsumerMessage{Topic: "test", Offset: 1}
+		go queue.ConsumeClaim(&offsetSession{ctx: ctx}, claim)
+
+		expected := 1
+		if mode == queues.ReceiveBroadcast {
+			expected = 2
+		}
+		assert.Eventually(t, func() bool {
+			return len(receiver1.received) == expected && len(receiver2.received) == expected
+		}, time.Second, 10*time.Millisecond, mode)
+
+		cancel()
+		queue.EndListen(context.Background(), "")
+		_ = queue.Close(context.Background(), "")
+	}
+}
+
+// Connection that fails to pause subscriptions
+type unpausableConnection struct {
+	*fixtures.FakeKafkaConnection
+}
+
+func (c *unpausableConnection) Pause(topic string, groupId string, listener connect.IKafkaMessageListener) error {
+	return cerr.NewConnectionError("", "PAUSE_FAILED", "Failed to pause consumer")
+}
+
+// Receiver that reports unavailable downstream for the first message
+type unavailableReceiver struct {
+	lock     sync.Mutex
+	attempts int
+}
+
+func (c *unavailableReceiver) ReceiveMessage(ctx context.Context, envelope *cqueues.MessageEnvelope, queue cqueues.IMessageQueue) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.attempts++
+	if c.attempts == 1 {
+		return queues.NewDownstreamUnavailableError("", "Database is down")
+	}
+	return nil
+}
+
+func (c *unavailableReceiver) getAttempts() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.attempts
+}
+
+func TestKafkaMessageQueueFailedPause(t *testing.T) {
+	connection := &unpausableConnection{FakeKafkaConnection: fixtures.NewFakeKafkaConnection("test")}
+	queue := newFakeConnectedQueue(connection.FakeKafkaConnection, "options.pause_timeout", 50)
+	queue.Connection = connection
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	// Failed pauses leave the queue running
+	receiver := &unavailableReceiver{}
+	queue.BeginListen(context.Background(), "", receiver)
+	defer queue.EndListen(context.Background(), "")
+	time.Sleep(50 * time.Millisecond)
+	err = queue.Pause(context.Background(), "", time.Second)
+	assert.NotNil(t, err)
+	assert.False(t, queue.IsPaused())
+
+	// Messages are retried after the pause timeout instead of waiting for a resume
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := &offsetSession{ctx: ctx}
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 1)}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "test", Offset: 0}
+	go queue.ConsumeClaim(session, claim)
+
+	assert.Eventually(t, func() bool {
+		return receiver.getAttempts() == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.False(t, queue.IsPaused())
+}
+
+func TestKafkaMessageQueueUnsubscribeReceiver(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection)
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	receiver1 := &countingReceiver{received: make(chan *cqueues.MessageEnvelope, 2)}
+	receiver2 := &countingReceiver{received: make(chan *cqueues.MessageEnvelope, 2)}
+	queue.AddReceiver(receiver2)
+
+	listening := make(chan error, 1)
+	go func() {
+		listening <- queue.Listen(context.Background(), "", receiver1)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// Remaining receivers get all messages
+	queue.Unsubscribe(context.Background(), "", receiver1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 2)}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "test", Offset: 0}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "test", Offset: 1}
+	go queue.ConsumeClaim(&offsetSession{ctx: ctx}, claim)
+
+	assert.Eventually(t, func() bool {
+		return len(receiver2.received) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Len(t, receiver1.received, 0)
+
+	// Listening ends with the last receiver
+	queue.Unsubscribe(context.Background(), "", receiver2)
+	select {
+	case err = <-listening:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "Listening did not end")
+	}
+}
+
+func TestKafkaMessageQueueAudit(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection()
+	queue := newFakeConnectedQueue(connection,
+		"options.audit", "topic;counters",
+		"options.audit_topic", "audit",
+	)
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	envelope := cqueues.NewMessageEnvelope("123", "Test", []byte("abc"))
+	err = queue.Send(context.Background(), "", envelope)
+	assert.Nil(t, err)
+	assert.Len(t, connection.GetPublished("test"), 1)
+	assert.Len(t, connection.GetPublished("audit"), 1)
+
+	data, _ := connection.GetPublished("audit")[0].Value.Encode()
+	record := &queues.KafkaAuditRecord{}
+	assert.Nil(t, json.Unmarshal(data, record))
+	assert.Equal(t, "test", record.Topic)
+	assert.Equal(t, envelope.MessageId, record.MessageId)
+	assert.Equal(t, envelope.MessageId, record.Key)
+	assert.Equal(t, "123", record.CorrelationId)
+	assert.Equal(t, 3, record.Size)
+	assert.Equal(t, queues.AuditSent, record.Result)
+
+	// The topic sink requires the audit topic
+	queue = newFakeConnectedQueue(connection, "options.audit", "topic")
+	err = queue.Open(context.Background(), "")
+	assert.NotNil(t, err)
+}
+
+func TestKafkaMessageQueueSchema(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection()
+	queue := newFakeConnectedQueue(connection,
+		"options.schema", `{"type": "object", "required": ["id"], "properties": {"id": {"type": "string"}}}`,
+	)
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	err = queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "Test", []byte(`{"id": "1"}`)))
+	assert.Nil(t, err)
+
+	err = queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "Test", []byte(`{"id": 1}`)))
+	assert.NotNil(t, err)
+	err = queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "Test", []byte("abc")))
+	assert.NotNil(t, err)
+	assert.Len(t, connection.GetPublished("test"), 1)
+
+	// Schemas of message types override the default schema
+	schema, _ := queues.NewKafkaJsonSchema(`{"type": "array"}`)
+	queue.SetSchema("List", schema)
+	err = queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "List", []byte("[1, 2]")))
+	assert.Nil(t, err)
+	assert.Len(t, connection.GetPublished("test"), 2)
+
+	// Invalid schemas fail to open the queue
+	queue = newFakeConnectedQueue(connection, "options.schema", "{abc")
+	err = queue.Open(context.Background(), "")
+	assert.NotNil(t, err)
+}
+
+func TestKafkaMessageQueueUpcasting(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection, "options.schema_version", 1)
+	queue.Upcasters.Register("Order", 1, func(ctx context.Context, message *cqueues.MessageEnvelope) error {
+		message.Message = append([]byte("v2:"), message.Message...)
+		return nil
+	})
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	// Sent messages are marked with the latest version of their type
+	err = queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "Order", []byte("abc")))
+	assert.Nil(t, err)
+	err = queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "Test", []byte("abc")))
+	assert.Nil(t, err)
+	assert.Len(t, connection.GetPublished("test"), 2)
+	assert.Equal(t, "2", getProducerHeader(connection.GetPublished("test")[0], queues.SchemaVersionHeader))
+	assert.Equal(t, "1", getProducerHeader(connection.GetPublished("test")[1], queues.SchemaVersionHeader))
+
+	// Messages without the version header are upcast from version 1
+	queue.OnMessage(context.Background(), &connect.KafkaMessage{Message: &kafka.ConsumerMessage{
+		Topic:   "test",
+		Value:   []byte("abc"),
+		Headers: []*kafka.RecordHeader{{Key: []byte("message_type"), Value: []byte("Order")}},
+	}})
+	queue.OnMessage(context.Background(), &connect.KafkaMessage{Message: &kafka.ConsumerMessage{
+		Topic: "test",
+		Value: []byte("v2:def"),
+		Headers: []*kafka.RecordHeader{
+			{Key: []byte("message_type"), Value: []byte("Order")},
+			{Key: []byte(queues.SchemaVersionHeader), Value: []byte("2")},
+		},
+	}})
+
+	message, err := queue.Receive(context.Background(), "", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "v2:abc", string(message.Message))
+	message, err = queue.Receive(context.Background(), "", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "v2:def", string(message.Message))
+}
+
+func getProducerHeader(msg *kafka.ProducerMessage, key string) string {
+	for _, header := range msg.Headers {
+		if string(header.Key) == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+type recordedMetric struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+type recordingMetrics struct {
+	lock    sync.Mutex
+	metrics []recordedMetric
+}
+
+func (c *recordingMetrics) Increment(name string, labels map[string]string, value int64) {
+	c.record(name, labels, float64(value))
+}
+
+func (c *recordingMetrics) Set(name string, labels map[string]string, value float64) {
+	c.record(name, labels, value)
+}
+
+func (c *recordingMetrics) Observe(name string, labels map[string]string, value float64) {
+	c.record(name, labels, value)
+}
+
+func (c *recordingMetrics) record(name string, labels map[string]string, value float64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.metrics = append(c.metrics, recordedMetric{name: name, labels: labels, value: value})
+}
+
+func (c *recordingMetrics) find(name string) *recordedMetric {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, metric := range c.metrics {
+		if metric.name == name {
+			return &metric
+		}
+	}
+	return nil
+}
+
+func TestKafkaMessageQueueLabeledMetrics(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection, "group_id", "workers")
+	metrics := &recordingMetrics{}
+	queue.SetReferences(context.Background(), cref.NewReferencesFromTuples(context.Background(),
+		cref.NewDescriptor("pip-services", "metrics", "prometheus", "default", "1.0"), metrics,
+	))
+	queue.Connection = connection
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	err = queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "Test", []byte("abc")))
+	assert.Nil(t, err)
+
+	sent := metrics.find(connect.MetricQueueSentMessages)
+	assert.NotNil(t, sent)
+	assert.Equal(t, map[string]string{"queue": "TestQueue", "topic": "test", "group": "workers", "partition": ""}, sent.labels)
+	assert.Equal(t, float64(1), sent.value)
+
+	queue.OnMessage(context.Background(), &connect.KafkaMessage{Message: &kafka.ConsumerMessage{
+		Topic:     "test",
+		Partition: 2,
+		Value:     []byte("abc"),
+		Timestamp: time.Now(),
+		Headers:   []*kafka.RecordHeader{{Key: []byte("message_type"), Value: []byte("Test")}},
+	}})
+
+	received := metrics.find(connect.MetricQueueReceivedMessages)
+	assert.NotNil(t, received)
+	assert.Equal(t, map[string]string{"queue": "TestQueue", "topic": "test", "group": "workers", "partition": "2"}, received.labels)
+
+	age := metrics.find(connect.MetricQueueMessageAge)
+	assert.NotNil(t, age)
+	assert.Equal(t, "Test", age.labels["message_type"])
+	assert.Equal(t, "2", age.labels["partition"])
+}
+
+func TestKafkaMessageQueueShadowTraffic(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection, "options.shadow_topic", "test.shadow")
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	err = queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "Test", []byte("abc")))
+	assert.Nil(t, err)
+	assert.Len(t, connection.GetPublished("test"), 1)
+	assert.Len(t, connection.GetPublished("test.shadow"), 1)
+	assert.Equal(t, "", getProducerHeader(connection.GetPublished("test")[0], queues.ShadowHeader))
+	assert.Equal(t, "true", getProducerHeader(connection.GetPublished("test.shadow")[0], queues.ShadowHeader))
+	assert.Equal(t, "Test", getProducerHeader(connection.GetPublished("test.shadow")[0], "message_type"))
+
+	// Shadow copies are recognized by consumers
+	queue.OnMessage(context.Background(), &connect.KafkaMessage{Message: &kafka.ConsumerMessage{
+		Topic:   "test.shadow",
+		Value:   []byte("abc"),
+		Headers: []*kafka.RecordHeader{{Key: []byte(queues.ShadowHeader), Value: []byte("true")}},
+	}})
+	message, err := queue.Receive(context.Background(), "", time.Second)
+	assert.Nil(t, err)
+	assert.True(t, queues.IsShadowMessage(message))
+
+	// Mirroring stops at cutover
+	queue.SetShadowTopic("")
+	err = queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "Test", []byte("abc")))
+	assert.Nil(t, err)
+	assert.Len(t, connection.GetPublished("test"), 2)
+	assert.Len(t, connection.GetPublished("test.shadow"), 1)
+}
+
+func TestKafkaMessageQueueCanary(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	connection.Topics["test"] = 4
+	queue := newFakeConnectedQueue(connection,
+		"group_id", "workers",
+		"options.canary_fraction", 0.5,
+	)
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	receiver := &countingReceiver{received: make(chan *cqueues.MessageEnvelope, 4)}
+	queue.BeginListen(queues.WithCanary(context.Background()), "", receiver)
+	assert.Eventually(t, func() bool {
+		return connection.GetSubscribedGroup("test") == "workers.canary"
+	}, time.Second, 10*time.Millisecond)
+
+	// Only the first half of partitions is handled and nothing is committed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := &offsetSession{ctx: ctx}
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 4)}
+	for partition := int32(0); partition < 4; partition++ {
+		claim.messages <- &kafka.ConsumerMessage{Topic: "test", Partition: partition}
+	}
+	go queue.ConsumeClaim(session, claim)
+
+	assert.Eventually(t, func() bool {
+		return len(claim.messages) == 0
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, receiver.received, 2)
+	assert.Empty(t, session.getMarked())
+
+	queue.EndListen(context.Background(), "")
+}
+
+type deadlineConnection struct {
+	*fixtures.FakeKafkaConnection
+	deadline time.Time
+}
+
+func (c *deadlineConnection) Publish(ctx context.Context, topic string, messages []*kafka.ProducerMessage) error {
+	c.deadline, _ = ctx.Deadline()
+	return c.FakeKafkaConnection.Publish(ctx, topic, messages)
+}
+
+func TestKafkaMessageQueueSendWithTimeout(t *testing.T) {
+	connection := &deadlineConnection{FakeKafkaConnection: fixtures.NewFakeKafkaConnection("test")}
+	queue := queues.NewKafkaMessageQueue("TestQueue")
+	queue.Configure(context.Background(), cconf.NewConfigParamsFromTuples("topic", "test"))
+	queue.Connection = connection
+	_ = connection.Open(context.Background(), "")
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	start := time.Now()
+	err = queue.SendWithTimeout(context.Background(), "", cqueues.NewMessageEnvelope("123", "Test", []byte("abc")), 200*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Len(t, connection.GetPublished("test"), 1)
+	assert.False(t, connection.deadline.IsZero())
+	assert.True(t, connection.deadline.Before(start.Add(time.Second)))
+}
+
+func TestKafkaMessageQueueDeserializeErrorPolicy(t *testing.T) {
+	malformed := &kafka.ConsumerMessage{
+		Topic:   "test",
+		Offset:  0,
+		Value:   []byte("abc"),
+		Headers: []*kafka.RecordHeader{{Key: []byte(queues.SchemaVersionHeader), Value: []byte("v1")}},
+	}
+	valid := &kafka.ConsumerMessage{Topic: "test", Offset: 1, Value: []byte("def")}
+
+	// The empty policy checks the default one
+	for _, policy := range []string{"", queues.DeserializeErrorSkip, queues.DeserializeErrorFail, queues.DeserializeErrorDeadLetter} {
+		options := []any{"autocommit", false}
+		if policy != "" {
+			options = append(options, "options.on_deserialize_error", policy)
+		}
+		connection := fixtures.NewFakeKafkaConnection("test")
+		queue := newFakeConnectedQueue(connection, options...)
+		err := queue.Open(context.Background(), "")
+		assert.Nil(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		session := &offsetSession{ctx: ctx}
+		claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 2)}
+		claim.messages <- malformed
+		claim.messages <- valid
+		stopped := make(chan bool, 1)
+		go func() {
+			queue.ConsumeClaim(session, claim)
+			stopped <- true
+		}()
+
+		if policy == queues.DeserializeErrorFail || policy == "" {
+			// The partition stops at the malformed record without committing it
+			select {
+			case <-stopped:
+			case <-time.After(time.Second):
+				assert.Fail(t, "Partition was not stopped")
+			}
+			assert.Empty(t, session.getMarked())
+			assert.Len(t, claim.messages, 1)
+		} else {
+			// The malformed record is committed and the next one is received
+			message, err := queue.Receive(context.Background(), "", time.Second)
+			assert.Nil(t, err)
+			assert.Equal(t, "def", string(message.Message))
+			assert.Equal(t, []int64{1}, session.getMarked())
+		}
+
+		if policy == queues.DeserializeErrorDeadLetter {
+			assert.Len(t, connection.GetPublished("test.dlq"), 1)
+			record := connection.GetPublished("test.dlq")[0]
+			assert.Equal(t, "test", getProducerHeader(record, queues.DeadLetterTopicHeader))
+			assert.Equal(t, "0", getProducerHeader(record, queues.DeadLetterOffsetHeader))
+			assert.NotEmpty(t, getProducerHeader(record, queues.DeadLetterErrorHeader))
+		} else {
+			assert.Empty(t, connection.GetPublished("test.dlq"))
+		}
+
+		cancel()
+		_ = queue.Close(context.Background(), "")
+	}
+}
+
+func TestKafkaMessageQueueRawDeserializeErrorPolicy(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection,
+		"autocommit", false,
+		"options.on_deserialize_error", queues.DeserializeErrorRaw,
+	)
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := &offsetSession{ctx: ctx}
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 2)}
+	claim.messages <- &kafka.ConsumerMessage{
+		Topic:  "test",
+		Offset: 0,
+		Value:  []byte{'{', '"', 0xff, 0xfe},
+		Headers: []*kafka.RecordHeader{
+			{Key: []byte(queues.SchemaVersionHeader), Value: []byte("v1")},
+			{Key: []byte("message_type"), Value: []byte{'t', 0xff}},
+		},
+	}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "test", Offset: 1, Value: []byte("def")}
+	go queue.ConsumeClaim(session, claim)
+
+	// The unreadable record is received with its original bytes
+	message, err := queue.Receive(context.Background(), "", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{'{', '"', 0xff, 0xfe}, message.Message)
+	assert.True(t, queues.IsRawMessage(message))
+	assert.True(t, utf8.ValidString(message.MessageType))
+	err = queue.Complete(context.Background(), message)
+	assert.Nil(t, err)
+
+	message, err = queue.Receive(context.Background(), "", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "def", string(message.Message))
+	assert.False(t, queues.IsRawMessage(message))
+}
+
+func TestKafkaMessageQueueMaxPollRecords(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection, "options.max_poll_records", 2)
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := &offsetSession{ctx: ctx}
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 4)}
+	for offset := int64(0); offset < 4; offset++ {
+		claim.messages <- &kafka.ConsumerMessage{Topic: "test", Offset: offset, Value: []byte("abc")}
+	}
+	go queue.ConsumeClaim(session, claim)
+
+	// The consumer waits with the third record until the queue has space
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, claim.messages, 1)
+
+	for i := 0; i < 4; i++ {
+		message, err := queue.Receive(context.Background(), "", time.Second)
+		assert.Nil(t, err)
+		assert.NotNil(t, message)
+	}
+	assert.Len(t, claim.messages, 0)
+}
+
+type discardConnection struct {
+	*fixtures.FakeKafkaConnection
+}
+
+func (c *discardConnection) Publish(ctx context.Context, topic string, messages []*kafka.ProducerMessage) error {
+	return nil
+}
+
+// Reports throughput of the benchmark, the hot path shall handle well above 50k msg/s
+func reportThroughput(b *testing.B, start time.Time) {
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+}
+
+func BenchmarkKafkaMessageQueueSend(b *testing.B) {
+	connection := &discardConnection{FakeKafkaConnection: fixtures.NewFakeKafkaConnection("test")}
+	queue := queues.NewKafkaMessageQueue("TestQueue")
+	queue.Configure(context.Background(), cconf.NewConfigParamsFromTuples("topic", "test"))
+	queue.Connection = connection
+	_ = connection.Open(context.Background(), "")
+	if err := queue.Open(context.Background(), ""); err != nil {
+		b.Fatal(err)
+	}
+	defer queue.Close(context.Background(), "")
+	payload := []byte(`{"id":"123","name":"test message"}`)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		err := queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "Test", payload))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportThroughput(b, start)
+}
+
+func BenchmarkKafkaMessageQueueReceive(b *testing.B) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection)
+	if err := queue.Open(context.Background(), ""); err != nil {
+		b.Fatal(err)
+	}
+	defer queue.Close(context.Background(), "")
+	record := &kafka.ConsumerMessage{
+		Topic: "test",
+		Key:   []byte("123"),
+		Value: []byte(`{"id":"123","name":"test message"}`),
+		Headers: []*kafka.RecordHeader{
+			{Key: []byte("correlation_id"), Value: []byte("123")},
+			{Key: []byte("message_type"), Value: []byte("Test")},
+		},
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		queue.OnMessage(context.Background(), &connect.KafkaMessage{Message: record})
+		message, err := queue.Receive(context.Background(), "", time.Second)
+		if err != nil || message == nil {
+			b.Fatal("Message was not received", err)
+		}
+	}
+	reportThroughput(b, start)
+}
+
+func TestKafkaMessageQueueCapabilities(t *testing.T) {
+	queue := queues.NewKafkaMessageQueue("TestQueue")
+	queue.Configure(context.Background(), cconf.NewConfigParamsFromTuples("topic", "capabilities"))
+
+	capabilities := queue.Capabilities()
+	assert.True(t, capabilities.CanMessageCount())
+	assert.True(t, capabilities.CanPeek())
+	assert.True(t, capabilities.CanPeekBatch())
+	assert.False(t, capabilities.CanAbandon())
+	assert.True(t, capabilities.CanRenewLock())
+	assert.True(t, capabilities.CanDeadLetter())
+	assert.False(t, capabilities.CanClear())
+
+	// Messages can be abandoned when offsets are committed manually
+	queue.Configure(context.Background(), cconf.NewConfigParamsFromTuples("autocommit", false))
+	assert.True(t, queue.Capabilities().CanAbandon())
+}
+
+func TestKafkaMessageQueueMoveToDeadLetter(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection,
+		"group_id", "orders",
+		"autocommit", false,
+	)
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := &offsetSession{ctx: ctx}
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 1)}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "test", Partition: 0, Offset: 5, Key: []byte("123"), Value: []byte("abc")}
+	close(claim.messages)
+	go queue.ConsumeClaim(session, claim)
+
+	message, err := queue.Receive(context.Background(), "", time.Second)
+	assert.Nil(t, err)
+	assert.NotNil(t, message)
+
+	err = queue.MoveToDeadLetter(context.Background(), message)
+	assert.Nil(t, err)
+	assert.Nil(t, message.GetReference())
+
+	// The record is copied with its provenance and its offset is committed
+	assert.Len(t, connection.GetPublished("test.dlq"), 1)
+	record := connection.GetPublished("test.dlq")[0]
+	value, _ := record.Value.Encode()
+	assert.Equal(t, "abc", string(value))
+	assert.Equal(t, "test", getProducerHeader(record, queues.DeadLetterTopicHeader))
+	assert.Equal(t, "0", getProducerHeader(record, queues.DeadLetterPartitionHeader))
+	assert.Equal(t, "5", getProducerHeader(record, queues.DeadLetterOffsetHeader))
+	assert.Equal(t, "orders", getProducerHeader(record, queues.DeadLetterGroupHeader))
+	assert.Equal(t, []int64{6}, session.getMarked())
+
+	// Moved messages are not moved again
+	err = queue.MoveToDeadLetter(context.Background(), message)
+	assert.Nil(t, err)
+	assert.Len(t, connection.GetPublished("test.dlq"), 1)
+}
+
+func TestKafkaMessageQueueRenewLock(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection,
+		"autocommit", false,
+		"options.autosubscribe", true,
+	)
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := &offsetSession{ctx: ctx}
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 3)}
+	for offset := int64(5); offset < 8; offset++ {
+		claim.messages <- &kafka.ConsumerMessage{Topic: "test", Partition: 0, Offset: offset, Value: []byte("abc")}
+	}
+	close(claim.messages)
+	go queue.ConsumeClaim(session, claim)
+
+	receive := func() *cqueues.MessageEnvelope {
+		message, err := queue.Receive(context.Background(), "", time.Second)
+		assert.Nil(t, err)
+		assert.NotNil(t, message)
+		return message
+	}
+
+	// The locked message pauses its partition
+	locked := receive()
+	assert.Nil(t, queue.RenewLock(context.Background(), locked, time.Second))
+	assert.Nil(t, queue.RenewLock(context.Background(), locked, time.Second))
+	assert.Equal(t, []int32{0}, connection.GetPausedPartitions("test"))
+
+	next := receive()
+	err = queue.RenewLock(context.Background(), next, time.Second)
+	assert.NotNil(t, err)
+	assert.Equal(t, "PARTITION_LOCKED", err.(*cerr.ApplicationError).Code)
+
+	// Later messages are committed after the locked one, marking next offsets to consume
+	assert.Nil(t, queue.Complete(context.Background(), next))
+	assert.Empty(t, session.getMarked())
+	assert.Nil(t, queue.Complete(context.Background(), locked))
+	assert.Equal(t, []int64{6, 7}, session.getMarked())
+	assert.Empty(t, connection.GetPausedPartitions("test"))
+
+	// Locks expire without renewal
+	expiring := receive()
+	assert.Nil(t, queue.RenewLock(context.Background(), expiring, 50*time.Millisecond))
+	assert.NotEmpty(t, connection.GetPausedPartitions("test"))
+	assert.Eventually(t, func() bool {
+		return len(connection.GetPausedPartitions("test")) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Nil(t, queue.Complete(context.Background(), expiring))
+	assert.Equal(t, []int64{6, 7, 8}, session.getMarked())
+}
+
+func TestKafkaMessageQueueOpenCloseCycles(t *testing.T) {
+	connection := newSessionKafkaConnection("test")
+	_ = connection.Open(context.Background(), "")
+	queue := queues.NewKafkaMessageQueue("TestQueue")
+	// Async commits set up the committer under the queue lock while Subscribe waits for it
+	queue.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"topic", "test",
+		"options.autosubscribe", true,
+		"options.commit_mode", "async",
+	))
+	queue.Connection = connection
+
+	cycle := func() {
+		err := queue.Open(context.Background(), "")
+		assert.Nil(t, err)
+		assert.NotNil(t, connection.GetListener("test"))
+		err = queue.Close(context.Background(), "")
+		assert.Nil(t, err)
+		assert.Nil(t, connection.GetListener("test"))
+	}
+
+	cycle()
+	time.Sleep(100 * time.Millisecond)
+	goroutines := runtime.NumGoroutine()
+
+	for i := 0; i < 3; i++ {
+		cycle()
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines)
+}
@@ -0,0 +1,103 @@
+package test_queues
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+// Receiver that blocks on every message until it is released
+type blockingReceiver struct {
+	started chan *cqueues.MessageEnvelope
+	release chan struct{}
+}
+
+func newBlockingReceiver() *blockingReceiver {
+	return &blockingReceiver{
+		started: make(chan *cqueues.MessageEnvelope, 2),
+		release: make(chan struct{}),
+	}
+}
+
+func (c *blockingReceiver) ReceiveMessage(ctx context.Context, envelope *cqueues.MessageEnvelope, queue cqueues.IMessageQueue) error {
+	c.started <- envelope
+	<-c.release
+	return nil
+}
+
+// Starts listening and consuming two messages, the first one is held by the receiver
+func startDrainedQueue(t *testing.T, options ...any) (*fixtures.FakeKafkaConnection, *blockingReceiver, *offsetSession, chan error, func()) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection, append([]any{"options.autosubscribe", true}, options...)...)
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+
+	receiver := newBlockingReceiver()
+	queue.BeginListen(context.Background(), "", receiver)
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 2)}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "test", Offset: 0}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "test", Offset: 1}
+	session := &offsetSession{ctx: ctx}
+	go queue.ConsumeClaim(session, claim)
+
+	select {
+	case <-receiver.started:
+	case <-time.After(time.Second):
+		assert.Fail(t, "Message is not received")
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- queue.Close(context.Background(), "")
+	}()
+	return connection, receiver, session, closed, cancel
+}
+
+func TestKafkaMessageQueueDrain(t *testing.T) {
+	connection, receiver, session, closed, cancel := startDrainedQueue(t)
+	defer cancel()
+
+	// Close waits for the in-flight message and keeps the subscription
+	select {
+	case <-closed:
+		assert.Fail(t, "Close doesn't wait for in-flight messages")
+	case <-time.After(200 * time.Millisecond):
+	}
+	assert.NotNil(t, connection.GetListener("test"))
+
+	// The finished message is committed before leaving the group
+	// and messages fetched while draining are not passed to receivers
+	close(receiver.release)
+	select {
+	case err := <-closed:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "Close is not finished after in-flight messages")
+	}
+	assert.Equal(t, []int64{1}, session.getMarked())
+	assert.Len(t, receiver.started, 0)
+	assert.Nil(t, connection.GetListener("test"))
+}
+
+func TestKafkaMessageQueueDrainTimeout(t *testing.T) {
+	connection, receiver, _, closed, cancel := startDrainedQueue(t, "options.drain_timeout", 100)
+	defer cancel()
+	defer close(receiver.release)
+
+	// Close leaves the group when in-flight messages are not finished in time
+	select {
+	case err := <-closed:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "Close doesn't stop waiting after the drain timeout")
+	}
+	assert.Nil(t, connection.GetListener("test"))
+}
@@ -0,0 +1,107 @@
+package test_queues
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+// Sets responses of a broker that coordinates the group, assigns partition 0 of the topic
+// and starts consumers from the committed offset
+func setGroupResponses(t *testing.T, broker *kafka.MockBroker, topic string, groupId string, committed int64) {
+	fetch := kafka.NewMockFetchResponse(t, 1)
+	for offset := int64(0); offset < 2; offset++ {
+		fetch.SetMessage(topic, 0, offset, kafka.StringEncoder("abc"))
+	}
+
+	broker.SetHandlerByMap(map[string]kafka.MockResponse{
+		"MetadataRequest": kafka.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader(topic, 0, broker.BrokerID()),
+		"ApiVersionsRequest": kafka.NewMockApiVersionsResponse(t),
+		"OffsetRequest": kafka.NewMockOffsetResponse(t).
+			SetOffset(topic, 0, kafka.OffsetOldest, 0).
+			SetOffset(topic, 0, kafka.OffsetNewest, 2),
+		"FindCoordinatorRequest": kafka.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(kafka.CoordinatorGroup, groupId, broker),
+		"JoinGroupRequest": kafka.NewMockJoinGroupResponse(t).SetGroupProtocol(kafka.RangeBalanceStrategyName),
+		"SyncGroupRequest": kafka.NewMockSyncGroupResponse(t).SetMemberAssignment(
+			&kafka.ConsumerGroupMemberAssignment{Topics: map[string][]int32{topic: {0}}}),
+		"HeartbeatRequest":  kafka.NewMockHeartbeatResponse(t),
+		"LeaveGroupRequest": kafka.NewMockLeaveGroupResponse(t),
+		"OffsetFetchRequest": kafka.NewMockOffsetFetchResponse(t).
+			SetOffset(groupId, topic, 0, committed, "", kafka.ErrNoError),
+		"OffsetCommitRequest": kafka.NewMockOffsetCommitResponse(t),
+		"FetchRequest":        fetch,
+	})
+}
+
+// Reads the latest offset of partition 0 committed to the broker
+func getCommittedOffset(broker *kafka.MockBroker, topic string) int64 {
+	committed := int64(-1)
+	for _, entry := range broker.History() {
+		if request, ok := entry.Request.(*kafka.OffsetCommitRequest); ok {
+			if offset, _, err := request.Offset(topic, 0); err == nil {
+				committed = offset
+			}
+		}
+	}
+	return committed
+}
+
+func newBrokerQueue(broker *kafka.MockBroker) *queues.KafkaMessageQueue {
+	queue := queues.NewKafkaMessageQueue("TestQueue")
+	queue.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"topic", "test",
+		"group_id", "group",
+		"connection.uri", broker.Addr(),
+		"autocommit", false,
+		"options.rtt_interval", 0,
+	))
+	return queue
+}
+
+func receiveOffset(t *testing.T, queue *queues.KafkaMessageQueue) (int64, bool) {
+	message, err := queue.Receive(context.Background(), "", 5*time.Second)
+	assert.Nil(t, err)
+	if message == nil {
+		return -1, false
+	}
+	msg := message.GetReference().(*connect.KafkaMessage)
+	assert.Nil(t, queue.Complete(context.Background(), message))
+	return msg.Message.Offset, true
+}
+
+func TestKafkaMessageQueueCompleteRestart(t *testing.T) {
+	broker := kafka.NewMockBroker(t, 1)
+	defer broker.Close()
+	setGroupResponses(t, broker, "test", "group", 0)
+
+	queue := newBrokerQueue(broker)
+	assert.Nil(t, queue.Open(context.Background(), ""))
+	offset, ok := receiveOffset(t, queue)
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), offset)
+
+	// Completed messages commit the next offset to consume
+	assert.Eventually(t, func() bool {
+		return getCommittedOffset(broker, "test") == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Nil(t, queue.Close(context.Background(), ""))
+
+	// The restarted consumer continues after the completed message
+	setGroupResponses(t, broker, "test", "group", getCommittedOffset(broker, "test"))
+	queue = newBrokerQueue(broker)
+	assert.Nil(t, queue.Open(context.Background(), ""))
+	defer queue.Close(context.Background(), "")
+	offset, ok = receiveOffset(t, queue)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), offset)
+}
@@ -6,52 +6,29 @@ import (
 	"testing"
 
 	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
 	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	test_containers "github.com/pip-services3-gox/pip-services3-kafka-gox/test/containers"
 )
 
 type kafkaMessageQueueTest struct {
 	queue   *queues.KafkaMessageQueue
-	fixture *MessageQueueFixture
+	fixture *fixtures.MessageQueueFixture
 }
 
 func newKafkaMessageQueueTest() *kafkaMessageQueueTest {
-	kafkaUri := os.Getenv("KAFKA_SERVICE_URI")
-	kafkaHost := os.Getenv("KAFKA_SERVICE_HOST")
-	if kafkaHost == "" {
-		kafkaHost = "localhost"
-	}
-
-	kafkaPort := os.Getenv("KAFKA_SERVICE_PORT")
-	if kafkaPort == "" {
-		kafkaPort = "9092"
-	}
-
 	kafkaTopic := os.Getenv("KAFKA_TOPIC")
 	if kafkaTopic == "" {
 		kafkaTopic = "test"
 	}
 
-	kafkaUser := os.Getenv("KAFKA_USER")
-	// if kafkaUser == "" {
-	// 	kafkaUser = "kafka"
-	// }
-	kafkaPassword := os.Getenv("KAFKA_PASS")
-	// if kafkaPassword == "" {
-	// 	kafkaPassword = "pass123"
-	// }
-
-	if kafkaUri == "" && kafkaHost == "" {
+	config := test_containers.GetKafkaConfig()
+	if config == nil {
 		return nil
 	}
 
 	queue := queues.NewKafkaMessageQueue(kafkaTopic)
-	queue.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
-		"connection.uri", kafkaUri,
-		"connection.host", kafkaHost,
-		"connection.port", kafkaPort,
-		"credential.mechanism", "plain",
-		"credential.username", kafkaUser,
-		"credential.password", kafkaPassword,
+	queue.Configure(context.Background(), config.Override(cconf.NewConfigParamsFromTuples(
 		"options.autosubscribe", true,
 		"options.num_partitions", 2,
 		"options.read_partitions", "1",
@@ -59,9 +36,9 @@ func newKafkaMessageQueueTest() *kafkaMessageQueueTest {
 		"options.read_partitions", "1",
 		"options.write_partition", "1",
 		"options.listen_connection", true,
-	))
+	)))
 
-	fixture := NewMessageQueueFixture(queue)
+	fixture := fixtures.NewMessageQueueFixture(queue)
 
 	return &kafkaMessageQueueTest{
 		queue:   queue,
@@ -90,10 +67,16 @@ func (c *kafkaMessageQueueTest) teardown(t *testing.T) {
 	}
 }
 
+func TestMain(m *testing.M) {
+	code := m.Run()
+	test_containers.StopKafka()
+	os.Exit(code)
+}
+
 func TestKafkaMessageQueue(t *testing.T) {
 	c := newKafkaMessageQueueTest()
 	if c == nil {
-		return
+		t.Skip("Kafka broker is not available")
 	}
 
 	c.setup(t)
@@ -115,4 +98,8 @@ func TestKafkaMessageQueue(t *testing.T) {
 	c.setup(t)
 	t.Run("On Message", c.fixture.TestOnMessage)
 	c.teardown(t)
+
+	c.setup(t)
+	t.Run("Message Count", c.fixture.TestMessageCount)
+	c.teardown(t)
 }
@@ -0,0 +1,94 @@
+package test_queues
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaMessageRouteExpressions(t *testing.T) {
+	envelope := newReceivedEnvelope("order", map[string]string{"tenant": "abc"})
+	envelope.Message = []byte(`{"total": 100, "customer": {"vip": true}, "items": [{"sku": "A1"}]}`)
+
+	matches := map[string]bool{
+		`type == "order"`:                         true,
+		`type != order`:                           false,
+		`header.tenant == 'abc'`:                  true,
+		`header.source`:                           false,
+		`$.total == 100`:                          true,
+		`$.customer.vip == true && $.total != 0`:  true,
+		`$.items.0.sku == "A1"`:                   true,
+		`$.items.1.sku`:                           false,
+		`type == "invoice" || header.tenant==abc`: true,
+		`type == "invoice" || $.total == 5`:       false,
+	}
+
+	for expression, expected := range matches {
+		route, err := queues.NewKafkaMessageRoute("handler", expression)
+		assert.Nil(t, err, expression)
+		assert.Equal(t, expected, route.Match(envelope), expression)
+	}
+
+	for _, expression := range []string{"", "type ==", "== order", "type == order &&", `header.x == "abc`} {
+		_, err := queues.NewKafkaMessageRoute("handler", expression)
+		assert.NotNil(t, err, expression)
+	}
+}
+
+func TestKafkaMessageRoutesFromConfig(t *testing.T) {
+	routes, err := queues.NewKafkaMessageRoutesFromConfig(cconf.NewConfigParamsFromTuples(
+		"routes.1.handler", "orders",
+		"routes.1.when", `type == "order"`,
+		"routes.0.when", `header.test == "true"`,
+	))
+	assert.Nil(t, err)
+	assert.Len(t, routes, 2)
+	assert.True(t, routes[0].IsSkip())
+	assert.Equal(t, "orders", routes[1].Handler)
+}
+
+func TestKafkaMessageQueueUnregisteredRoute(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection,
+		"routes.0.handler", "vip",
+		"routes.0.when", `header.customer == "vip"`,
+	)
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	receiver := &countingReceiver{received: make(chan *cqueues.MessageEnvelope, 2)}
+	queue.BeginListen(context.Background(), "", receiver)
+	defer queue.EndListen(context.Background(), "")
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 2)}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "test", Offset: 0, Headers: []*kafka.RecordHeader{
+		{Key: []byte("customer"), Value: []byte("vip")},
+	}}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "test", Offset: 1}
+	session := &offsetSession{ctx: ctx}
+	done := make(chan error, 1)
+	go func() {
+		done <- queue.ConsumeClaim(session, claim)
+	}()
+
+	// The partition is stopped at the routed message without committing it
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail(t, "Partition is not stopped at the unroutable message")
+	}
+	assert.Len(t, receiver.received, 0)
+	assert.Len(t, session.getMarked(), 0)
+	assert.Len(t, claim.messages, 1)
+}
@@ -0,0 +1,100 @@
+package test_queues
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaMessageTapTopic(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection,
+		"options.tap", "sent;received",
+		"options.tap_topic", "debug",
+	)
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	err = queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "Test", []byte("abc")))
+	assert.Nil(t, err)
+	assert.Len(t, connection.GetPublished("test"), 1)
+	assert.Len(t, connection.GetPublished("debug"), 1)
+	assert.Equal(t, queues.TapSent, getProducerHeader(connection.GetPublished("debug")[0], queues.TapDirectionHeader))
+	assert.Equal(t, "test", getProducerHeader(connection.GetPublished("debug")[0], queues.TapSourceTopicHeader))
+	assert.Equal(t, "Test", getProducerHeader(connection.GetPublished("debug")[0], "message_type"))
+
+	queue.OnMessage(context.Background(), &connect.KafkaMessage{Message: &kafka.ConsumerMessage{
+		Topic:   "test",
+		Key:     []byte("key1"),
+		Value:   []byte("def"),
+		Headers: []*kafka.RecordHeader{{Key: []byte("message_type"), Value: []byte("Test")}},
+	}})
+
+	assert.Len(t, connection.GetPublished("debug"), 2)
+	tapped := connection.GetPublished("debug")[1]
+	assert.Equal(t, queues.TapReceived, getProducerHeader(tapped, queues.TapDirectionHeader))
+	value, _ := tapped.Value.Encode()
+	assert.Equal(t, "def", string(value))
+	key, _ := tapped.Key.Encode()
+	assert.Equal(t, "key1", string(key))
+
+	// Tapped messages are still delivered
+	message, err := queue.Receive(context.Background(), "", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "def", string(message.Message))
+}
+
+func TestKafkaMessageTapCallback(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection)
+
+	tapped := []string{}
+	tap := queues.NewKafkaMessageTap(queues.TapSent)
+	tap.Predicate = func(direction string, message *cqueues.MessageEnvelope) bool {
+		return message.MessageType == "Order"
+	}
+	tap.Callback = func(ctx context.Context, direction string, message *cqueues.MessageEnvelope) {
+		tapped = append(tapped, direction+":"+message.MessageId)
+	}
+	queue.AddSendInterceptor(tap)
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	order := cqueues.NewMessageEnvelope("123", "Order", []byte("abc"))
+	err = queue.Send(context.Background(), "", order)
+	assert.Nil(t, err)
+	err = queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "Test", []byte("abc")))
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{queues.TapSent + ":" + order.MessageId}, tapped)
+
+	// Zero rate disables tapping
+	tap.Rate = 0
+	err = queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "Order", []byte("abc")))
+	assert.Nil(t, err)
+	assert.Len(t, tapped, 1)
+}
+
+func TestKafkaMessageTapConfig(t *testing.T) {
+	queue := queues.NewKafkaMessageQueue("TestQueue")
+	queue.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"topic", "test",
+		"options.tap", "received",
+	))
+	queue.Connection = fixtures.NewFakeKafkaConnection("test")
+
+	err := queue.Open(context.Background(), "")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,267 @@
+package test_queues
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	connect "github.com/pip-services3-gox/pip-services3-kafka-gox/connect"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryOffsetStore struct {
+	lock    sync.Mutex
+	offsets map[int32]int64
+}
+
+func (c *memoryOffsetStore) ReadOffsets(ctx context.Context, correlationId string, topic string, groupId string) (map[int32]int64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	offsets := make(map[int32]int64)
+	for partition, offset := range c.offsets {
+		offsets[partition] = offset
+	}
+	return offsets, nil
+}
+
+func (c *memoryOffsetStore) SaveOffset(ctx context.Context, correlationId string, topic string, groupId string, partition int32, offset int64) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.offsets[partition] = offset
+	return nil
+}
+
+type offsetSession struct {
+	ctx       context.Context
+	lock      sync.Mutex
+	marked    []int64
+	reset     []int64
+	committed int
+	metadata  string
+}
+
+func (c *offsetSession) Claims() map[string][]int32 { return map[string][]int32{"test": {0}} }
+func (c *offsetSession) MemberID() string           { return "" }
+func (c *offsetSession) GenerationID() int32        { return 0 }
+func (c *offsetSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.marked = append(c.marked, offset)
+	c.metadata = metadata
+}
+func (c *offsetSession) Commit() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.committed++
+}
+func (c *offsetSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.reset = append(c.reset, offset)
+	c.metadata = metadata
+}
+func (c *offsetSession) MarkMessage(msg *kafka.ConsumerMessage, metadata string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.marked = append(c.marked, msg.Offset+1)
+	c.metadata = metadata
+}
+func (c *offsetSession) getMarked() []int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]int64(nil), c.marked...)
+}
+func (c *offsetSession) getReset() []int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]int64(nil), c.reset...)
+}
+func (c *offsetSession) getCommitted() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.committed
+}
+func (c *offsetSession) getMetadata() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.metadata
+}
+func (c *offsetSession) Context() context.Context { return c.ctx }
+
+type offsetClaim struct {
+	messages chan *kafka.ConsumerMessage
+}
+
+func (c *offsetClaim) Topic() string                           { return "test" }
+func (c *offsetClaim) Partition() int32                        { return 0 }
+func (c *offsetClaim) InitialOffset() int64                    { return 0 }
+func (c *offsetClaim) HighWaterMarkOffset() int64              { return 0 }
+func (c *offsetClaim) Messages() <-chan *kafka.ConsumerMessage { return c.messages }
+
+func TestKafkaMessageQueueOffsetStore(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection, "autocommit", false, "options.commit_metadata", "host1")
+	store := &memoryOffsetStore{offsets: map[int32]int64{0: 5}}
+	queue.OffsetStore = store
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := &offsetSession{ctx: ctx}
+
+	// Stored offsets are restored on the session setup with the commit metadata
+	err = queue.Setup(session)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{5}, session.getReset())
+	assert.Equal(t, "host1", session.getMetadata())
+
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 1)}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "test", Partition: 0, Offset: 5, Value: []byte("abc")}
+	go queue.ConsumeClaim(session, claim)
+
+	message, err := queue.Receive(context.Background(), "", 1000*time.Millisecond)
+	assert.Nil(t, err)
+	assert.NotNil(t, message)
+
+	// Completed offsets are saved to the store instead of Kafka
+	err = queue.Complete(context.Background(), message)
+	assert.Nil(t, err)
+
+	offsets, _ := store.ReadOffsets(context.Background(), "", "test", "default")
+	assert.Equal(t, int64(6), offsets[0])
+	assert.Equal(t, 0, session.getCommitted())
+}
+
+func TestKafkaMessageQueueAsyncCommits(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection,
+		"options.commit_mode", queues.CommitAsync,
+		"options.commit_sync_interval", 0,
+	)
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	var lock sync.Mutex
+	committed := map[int32]int64{}
+	queue.SetCommitCallback(func(ctx context.Context, offsets map[string]map[int32]int64, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		assert.Nil(t, err)
+		for partition, offset := range offsets["test"] {
+			committed[partition] = offset
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session := &offsetSession{ctx: ctx}
+	err = queue.Setup(session)
+	assert.Nil(t, err)
+
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 2)}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "test", Offset: 0, Value: []byte("abc")}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "test", Offset: 1, Value: []byte("def")}
+	stopped := make(chan bool, 1)
+	go func() {
+		queue.ConsumeClaim(session, claim)
+		stopped <- true
+	}()
+
+	// Offsets are marked in the consume loop and committed in the background
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []int64{1, 2}, session.getMarked())
+
+	cancel()
+	<-stopped
+	err = queue.Cleanup(session)
+	assert.Nil(t, err)
+
+	assert.GreaterOrEqual(t, session.getCommitted(), 1)
+	lock.Lock()
+	assert.Equal(t, int64(2), committed[0])
+	lock.Unlock()
+}
+
+func TestKafkaMessageQueueCommitRetry(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection,
+		"options.commit_retries", 2,
+		"options.commit_retry_backoff", 1,
+	)
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	results := make(chan error, 10)
+	queue.SetCommitCallback(func(ctx context.Context, offsets map[string]map[int32]int64, err error) {
+		results <- err
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := &offsetSession{ctx: ctx}
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 1)}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "test", Offset: 0, Value: []byte("abc")}
+	go queue.ConsumeClaim(session, claim)
+
+	message, err := queue.Receive(context.Background(), "", time.Second)
+	assert.Nil(t, err)
+	assert.NotNil(t, message)
+	assert.Nil(t, <-results)
+
+	// The commit is sent again and reported as failed when the offset is not committed
+	queue.OnError(&kafka.ConsumerError{Topic: "test", Partition: 0, Err: kafka.ErrRebalanceInProgress})
+	select {
+	case err = <-results:
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "Failed to commit")
+	case <-time.After(time.Second):
+		assert.Fail(t, "Commit failure was not reported")
+	}
+	assert.Equal(t, 3, session.getCommitted())
+
+	// The retry succeeds when the offset reaches the broker
+	_ = connection.ImportOffsets(&connect.KafkaOffsetSnapshot{Topic: "test", GroupId: "default", Offsets: map[int32]int64{0: 1}})
+	queue.OnError(&kafka.ConsumerError{Topic: "test", Partition: 0, Err: kafka.ErrNotCoordinatorForConsumer})
+	select {
+	case err = <-results:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "Commit retry was not reported")
+	}
+}
+
+func TestKafkaMessageQueueCommitMetadata(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection, "options.commit_metadata", "host1")
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+	assert.Equal(t, "host1", queue.GetCommitMetadata())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := &offsetSession{ctx: ctx}
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 1)}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "test", Offset: 0, Value: []byte("abc")}
+	go queue.ConsumeClaim(session, claim)
+
+	message, err := queue.Receive(context.Background(), "", time.Second)
+	assert.Nil(t, err)
+	assert.NotNil(t, message)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "host1", session.getMetadata())
+
+	// Metadata can be changed, like checkpoint ids
+	queue.SetCommitMetadata("checkpoint2")
+	assert.Equal(t, "checkpoint2", queue.GetCommitMetadata())
+}
@@ -0,0 +1,123 @@
+package test_queues
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaTenantContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, "", queues.GetTenant(ctx))
+
+	ctx = queues.WithTenant(ctx, "tenantA")
+	assert.Equal(t, "tenantA", queues.GetTenant(ctx))
+}
+
+func TestKafkaTenancyTopics(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection()
+	queue := newFakeConnectedQueue(connection,
+		"options.tenancy", queues.TenancyTopic,
+		"options.tenant_field", "$.tenant",
+	)
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+	assert.Contains(t, connection.Topics, "test")
+
+	// Messages are routed to tenant topics, which are created on first sends
+	ctx := queues.WithTenant(context.Background(), "a")
+	err = queue.Send(ctx, "", cqueues.NewMessageEnvelope("123", "Test", []byte("{}")))
+	assert.Nil(t, err)
+	assert.Contains(t, connection.Topics, "test.a")
+	assert.Len(t, connection.GetPublished("test.a"), 1)
+	assert.Equal(t, "a", getProducerHeader(connection.GetPublished("test.a")[0], queues.TenantHeader))
+
+	// Tenants are taken from payloads when the context has none
+	err = queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "Test", []byte(`{"tenant": "b"}`)))
+	assert.Nil(t, err)
+	assert.Len(t, connection.GetPublished("test.b"), 1)
+
+	// Messages without tenants are sent to the base topic
+	err = queue.Send(context.Background(), "", cqueues.NewMessageEnvelope("123", "Test", []byte("{}")))
+	assert.Nil(t, err)
+	assert.Len(t, connection.GetPublished("test"), 1)
+	assert.Equal(t, "", getProducerHeader(connection.GetPublished("test")[0], queues.TenantHeader))
+
+	// Tenant topics are not created without autocreate
+	strict := newFakeConnectedQueue(connection,
+		"options.tenancy", queues.TenancyTopic,
+		"options.autocreate", false,
+	)
+	err = strict.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer strict.Close(context.Background(), "")
+
+	err = strict.Send(queues.WithTenant(context.Background(), "c"), "", cqueues.NewMessageEnvelope("123", "Test", []byte("{}")))
+	assert.NotNil(t, err)
+	assert.Equal(t, "TOPIC_NOT_FOUND", err.(*cerr.ApplicationError).Code)
+	assert.NotContains(t, connection.Topics, "test.c")
+}
+
+func TestKafkaTenancyTopicConsumer(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection()
+	queue := newFakeConnectedQueue(connection,
+		"options.tenancy", queues.TenancyTopic,
+		"options.tenant_id", "a",
+		"options.autosubscribe", true,
+	)
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	// Consumers of a tenant read its topic
+	assert.Contains(t, connection.Topics, "test.a")
+	assert.NotNil(t, connection.GetListener("test.a"))
+	assert.Nil(t, connection.GetListener("test"))
+}
+
+func TestKafkaTenancyHeaders(t *testing.T) {
+	connection := fixtures.NewFakeKafkaConnection("test")
+	queue := newFakeConnectedQueue(connection,
+		"options.tenancy", queues.TenancyHeader,
+		"options.tenant_id", "a",
+	)
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	// Tenants share the topic and are marked by headers
+	err = queue.Send(queues.WithTenant(context.Background(), "b"), "", cqueues.NewMessageEnvelope("123", "Test", []byte("{}")))
+	assert.Nil(t, err)
+	assert.Len(t, connection.GetPublished("test"), 1)
+	assert.Equal(t, "b", getProducerHeader(connection.GetPublished("test")[0], queues.TenantHeader))
+
+	// Messages of other tenants are skipped
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	claim := &offsetClaim{messages: make(chan *kafka.ConsumerMessage, 2)}
+	for offset, tenantId := range []string{"b", "a"} {
+		claim.messages <- &kafka.ConsumerMessage{Topic: "test", Partition: 0, Offset: int64(offset),
+			Value: []byte(tenantId), Headers: []*kafka.RecordHeader{
+				{Key: []byte(queues.TenantHeader), Value: []byte(tenantId)},
+			}}
+	}
+	close(claim.messages)
+	go queue.ConsumeClaim(&offsetSession{ctx: ctx}, claim)
+
+	message, err := queue.Receive(context.Background(), "", time.Second)
+	assert.Nil(t, err)
+	assert.NotNil(t, message)
+	assert.Equal(t, "a", message.GetMessageAsString())
+
+	message, err = queue.Receive(context.Background(), "", 100*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Nil(t, message)
+}
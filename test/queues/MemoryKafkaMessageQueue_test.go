@@ -0,0 +1,85 @@
+package test_queues
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMemoryKafkaMessageQueue(topic string, groupId string, options ...any) *queues.MemoryKafkaMessageQueue {
+	queue := queues.NewMemoryKafkaMessageQueue(topic)
+	queue.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		append([]any{"topic", topic, "group_id", groupId}, options...)...,
+	))
+	_ = queue.Open(context.Background(), "")
+	return queue
+}
+
+func TestMemoryKafkaMessageQueue(t *testing.T) {
+	queue := newMemoryKafkaMessageQueue("memory_test", "default", "autocommit", false)
+	defer queue.Close(context.Background(), "")
+	fixture := fixtures.NewMessageQueueFixture(queue)
+
+	t.Run("Send Receive Message", fixture.TestSendReceiveMessage)
+	t.Run("Receive Send Message", fixture.TestReceiveSendMessage)
+	t.Run("Receive And Complete Message", fixture.TestReceiveCompleteMessage)
+	t.Run("Complete No Redelivery", fixture.TestCompleteNoRedelivery)
+	t.Run("Receive And Abandon Message", fixture.TestReceiveAbandonMessage)
+	t.Run("Send Peek Message", fixture.TestSendPeekMessage)
+	t.Run("Peek No Message", fixture.TestPeekNoMessage)
+	t.Run("Message Count", fixture.TestMessageCount)
+	_ = queue.Clear(context.Background(), "")
+	t.Run("On Message", fixture.TestOnMessage)
+}
+
+func TestMemoryKafkaMessageQueueGroups(t *testing.T) {
+	ctx := context.Background()
+	consumer1 := newMemoryKafkaMessageQueue("memory_groups", "group1", "options.num_partitions", 4)
+	consumer2 := newMemoryKafkaMessageQueue("memory_groups", "group1")
+	other := newMemoryKafkaMessageQueue("memory_groups", "group2")
+	defer consumer1.Close(ctx, "")
+	defer other.Close(ctx, "")
+
+	for i := 0; i < 20; i++ {
+		id := strconv.Itoa(i)
+		envelope := cqueues.NewMessageEnvelope(id, "Test", []byte(id))
+		envelope.MessageId = "key" + strconv.Itoa(i%3)
+		_ = consumer1.Send(ctx, "", envelope)
+	}
+
+	// Members of a group split messages, and every key keeps its order
+	received := map[string][]int{}
+	for _, consumer := range []*queues.MemoryKafkaMessageQueue{consumer1, consumer2} {
+		for {
+			message, err := consumer.Receive(ctx, "", 10*time.Millisecond)
+			assert.Nil(t, err)
+			if message == nil {
+				break
+			}
+			value, _ := strconv.Atoi(message.CorrelationId)
+			received[message.MessageId] = append(received[message.MessageId], value)
+		}
+	}
+	total := 0
+	for _, values := range received {
+		total += len(values)
+		for i := 1; i < len(values); i++ {
+			assert.Less(t, values[i-1], values[i])
+		}
+	}
+	assert.Equal(t, 20, total)
+
+	// Other groups receive all messages
+	count, err := other.ReadMessageCount()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(20), count)
+
+	_ = consumer2.Close(ctx, "")
+}
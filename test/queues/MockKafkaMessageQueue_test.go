@@ -0,0 +1,62 @@
+package test_queues
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ queues.IKafkaMessageQueue = (*queues.KafkaMessageQueue)(nil)
+var _ queues.IKafkaMessageQueue = (*queues.MockKafkaMessageQueue)(nil)
+
+func TestMockKafkaMessageQueue(t *testing.T) {
+	queue := queues.NewMockKafkaMessageQueue("TestQueue")
+	fixture := fixtures.NewMessageQueueFixture(queue)
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	t.Run("Send Receive Message", fixture.TestSendReceiveMessage)
+	t.Run("Receive Send Message", fixture.TestReceiveSendMessage)
+	t.Run("Receive And Complete Message", fixture.TestReceiveCompleteMessage)
+	t.Run("Receive And Abandon Message", fixture.TestReceiveAbandonMessage)
+	t.Run("Send Peek Message", fixture.TestSendPeekMessage)
+	t.Run("Peek No Message", fixture.TestPeekNoMessage)
+	t.Run("On Message", fixture.TestOnMessage)
+}
+
+func TestMockKafkaMessageQueueDispatch(t *testing.T) {
+	ctx := context.Background()
+	queue := queues.NewMockKafkaMessageQueue("TestQueue")
+	_ = queue.Open(ctx, "")
+	defer queue.Close(ctx, "")
+
+	typed := &fixtures.TestMsgReceiver{}
+	queue.RegisterHandler("Typed", typed)
+	receiver := &fixtures.TestMsgReceiver{}
+	queue.BeginListen(ctx, "", receiver)
+	time.Sleep(50 * time.Millisecond)
+
+	// Listening queues deliver synchronously within Send
+	err := queue.Send(ctx, "", cqueues.NewMessageEnvelope("1", "Typed", []byte("A")))
+	assert.Nil(t, err)
+	assert.Equal(t, "1", typed.GetEnvelope().CorrelationId)
+
+	_ = queue.Pause(ctx, "", 0)
+	err = queue.Send(ctx, "", cqueues.NewMessageEnvelope("2", "Other", []byte("B")))
+	assert.Nil(t, err)
+	count, _ := queue.ReadMessageCount()
+	assert.Equal(t, int64(1), count)
+
+	_ = queue.Resume(ctx, "")
+	assert.Equal(t, "2", receiver.GetEnvelope().CorrelationId)
+	assert.Len(t, queue.SentMessages(), 2)
+
+	queue.EndListen(ctx, "")
+}
@@ -0,0 +1,102 @@
+package test_queues
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMemoryPriorityQueue(topic string, options ...any) *queues.PriorityKafkaMessageQueue {
+	queue := queues.NewPriorityKafkaMessageQueue("TestQueue")
+	for _, priority := range []string{queues.PriorityHigh, queues.PriorityNormal, queues.PriorityLow} {
+		queue.SetLane(priority, queues.NewMemoryKafkaMessageQueue("TestQueue."+priority))
+	}
+	queue.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		append([]any{"topic", topic, "options.poll_interval", 10}, options...)...,
+	))
+	return queue
+}
+
+func TestPriorityKafkaMessageQueueReceive(t *testing.T) {
+	queue := newMemoryPriorityQueue("priority_receive", "options.priority_types", "alert=high")
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	ctx := context.Background()
+	assert.Nil(t, queue.Send(queues.WithPriority(ctx, queues.PriorityLow), "", cqueues.NewMessageEnvelope("", "report", []byte("1"))))
+	assert.Nil(t, queue.Send(ctx, "", cqueues.NewMessageEnvelope("", "order", []byte("2"))))
+	assert.Nil(t, queue.Send(ctx, "", cqueues.NewMessageEnvelope("", "alert", []byte("3"))))
+
+	count, err := queue.ReadMessageCount()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), count)
+
+	// Higher lanes are drained first
+	for _, expected := range []string{"3", "2", "1"} {
+		message, err := queue.Receive(ctx, "", time.Second)
+		assert.Nil(t, err)
+		assert.NotNil(t, message)
+		assert.Equal(t, expected, string(message.Message))
+		assert.Nil(t, queue.Complete(ctx, message))
+	}
+
+	message, err := queue.Receive(ctx, "", 50*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Nil(t, message)
+
+	err = queue.Send(queues.WithPriority(ctx, "urgent"), "", cqueues.NewMessageEnvelope("", "order", []byte("4")))
+	assert.NotNil(t, err)
+}
+
+func TestPriorityKafkaMessageQueueListen(t *testing.T) {
+	queue := newMemoryPriorityQueue("priority_listen")
+
+	err := queue.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer queue.Close(context.Background(), "")
+
+	ctx := context.Background()
+	assert.Nil(t, queue.Send(queues.WithPriority(ctx, queues.PriorityLow), "", cqueues.NewMessageEnvelope("", "", []byte("1"))))
+	assert.Nil(t, queue.Send(queues.WithPriority(ctx, queues.PriorityHigh), "", cqueues.NewMessageEnvelope("", "", []byte("2"))))
+
+	receiver := &countingReceiver{received: make(chan *cqueues.MessageEnvelope, 2)}
+	queue.BeginListen(ctx, "", receiver)
+	defer queue.EndListen(ctx, "")
+
+	assert.Eventually(t, func() bool {
+		return len(receiver.received) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "2", string((<-receiver.received).Message))
+	assert.Equal(t, "1", string((<-receiver.received).Message))
+}
+
+func TestPriorityKafkaMessageQueueInvalidConfig(t *testing.T) {
+	queue := newMemoryPriorityQueue("priority_invalid", "options.default_priority", "urgent")
+	err := queue.Open(context.Background(), "")
+	assert.NotNil(t, err)
+}
+
+func TestPriorityKafkaMessageQueueCapabilities(t *testing.T) {
+	queue := newMemoryPriorityQueue("priority_capabilities")
+	capabilities := queue.Capabilities()
+	assert.True(t, capabilities.CanPeekBatch())
+	assert.True(t, capabilities.CanClear())
+	assert.False(t, capabilities.CanAbandon())
+
+	// Capabilities are supported only when all lanes support them
+	queue = newMemoryPriorityQueue("priority_capabilities", "autocommit", false)
+	assert.True(t, queue.Capabilities().CanAbandon())
+	lane := queues.NewKafkaMessageQueue("TestQueue.low")
+	lane.Configure(context.Background(), cconf.NewConfigParamsFromTuples("topic", "priority_capabilities.low"))
+	queue.SetLane(queues.PriorityLow, lane)
+	assert.False(t, queue.Capabilities().CanAbandon())
+	assert.False(t, queue.Capabilities().CanClear())
+	assert.True(t, queue.Capabilities().CanPeek())
+}
@@ -0,0 +1,70 @@
+package test_queues
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	queues "github.com/pip-services3-gox/pip-services3-kafka-gox/queues"
+	cqueues "github.com/pip-services3-gox/pip-services3-messaging-gox/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueBridgeForward(t *testing.T) {
+	ctx := context.Background()
+
+	source := queues.NewMockKafkaMessageQueue("source")
+	_ = source.Open(ctx, "")
+	defer source.Close(ctx, "")
+	target := cqueues.NewMemoryMessageQueue("target")
+	_ = target.Open(ctx, "")
+	defer target.Close(ctx, "")
+
+	bridge := queues.NewQueueBridge("test")
+	bridge.Source = source
+	bridge.Target = target
+
+	err := bridge.Open(ctx, "")
+	assert.Nil(t, err)
+	defer bridge.Close(ctx, "")
+
+	envelope := cqueues.NewMessageEnvelope("123", "order", []byte("A"))
+	// Wait for the bridge to start listening
+	assert.Eventually(t, func() bool {
+		_ = source.Send(ctx, "", envelope)
+		return len(source.CompletedMessages()) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	received, err := target.Receive(ctx, "", time.Second)
+	assert.Nil(t, err)
+	assert.NotNil(t, received)
+	assert.Equal(t, envelope.MessageId, received.MessageId)
+	assert.Equal(t, "order", received.MessageType)
+	assert.Equal(t, "123", received.CorrelationId)
+	assert.Equal(t, "A", received.GetMessageAsString())
+}
+
+func TestQueueBridgeTargetUnavailable(t *testing.T) {
+	ctx := context.Background()
+
+	source := queues.NewMockKafkaMessageQueue("source")
+	_ = source.Open(ctx, "")
+	defer source.Close(ctx, "")
+	// The target is not opened and fails to send
+	target := queues.NewMockKafkaMessageQueue("target")
+
+	bridge := queues.NewQueueBridge("test")
+	bridge.Source = source
+	bridge.Target = target
+	_ = bridge.Open(ctx, "")
+	defer bridge.Close(ctx, "")
+
+	assert.Eventually(t, func() bool {
+		_ = source.Send(ctx, "", cqueues.NewMessageEnvelope("123", "order", []byte("A")))
+		return source.IsPaused()
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Len(t, source.CompletedMessages(), 0)
+	count, _ := source.ReadMessageCount()
+	assert.True(t, count > 0)
+}
@@ -0,0 +1,75 @@
+package test_retention
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	retention "github.com/pip-services3-gox/pip-services3-kafka-gox/retention"
+	"github.com/stretchr/testify/assert"
+)
+
+func publish(ctx context.Context, connection *fixtures.FakeKafkaConnection, topic string, age time.Duration, count int) {
+	for i := 0; i < count; i++ {
+		_ = connection.Publish(ctx, topic, []*kafka.ProducerMessage{{
+			Value:     kafka.StringEncoder("message"),
+			Timestamp: time.Now().Add(-age),
+		}})
+	}
+}
+
+func TestKafkaDeadLetterRetentionByAge(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection("orders", "orders.dlq")
+	_ = connection.Open(ctx, "")
+	publish(ctx, connection, "orders.dlq", 10*24*time.Hour, 3)
+	publish(ctx, connection, "orders.dlq", time.Hour, 2)
+	publish(ctx, connection, "orders", 10*24*time.Hour, 3)
+
+	dlqRetention := retention.NewKafkaDeadLetterRetention()
+	dlqRetention.Configure(ctx, cconf.NewConfigParamsFromTuples("options.interval", 0))
+	dlqRetention.Connection = connection
+	err := dlqRetention.Open(ctx, "")
+	assert.Nil(t, err)
+	defer dlqRetention.Close(ctx, "")
+
+	purges, err := dlqRetention.Purge(ctx, "")
+	assert.Nil(t, err)
+	assert.Len(t, purges, 1)
+	assert.Equal(t, "orders.dlq", purges[0].Topic)
+	assert.Equal(t, int64(3), purges[0].PurgedRecords)
+	assert.Equal(t, map[int32]int64{0: 3}, connection.Deleted["orders.dlq"])
+	assert.NotContains(t, connection.Deleted, "orders")
+
+	// Purged records are not purged again
+	purges, err = dlqRetention.Purge(ctx, "")
+	assert.Nil(t, err)
+	assert.Len(t, purges, 0)
+}
+
+func TestKafkaDeadLetterRetentionByCount(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection("payments.failed")
+	_ = connection.Open(ctx, "")
+	publish(ctx, connection, "payments.failed", time.Hour, 10)
+
+	dlqRetention := retention.NewKafkaDeadLetterRetention()
+	dlqRetention.Configure(ctx, cconf.NewConfigParamsFromTuples(
+		"topics", "payments.failed",
+		"options.interval", 0,
+		"options.max_messages", 4,
+	))
+	dlqRetention.Connection = connection
+	err := dlqRetention.Open(ctx, "")
+	assert.Nil(t, err)
+	defer dlqRetention.Close(ctx, "")
+
+	purges, err := dlqRetention.Purge(ctx, "")
+	assert.Nil(t, err)
+	assert.Len(t, purges, 1)
+	assert.Equal(t, int64(6), purges[0].PurgedRecords)
+	assert.Equal(t, map[int32]int64{0: 6}, connection.Deleted["payments.failed"])
+}
@@ -0,0 +1,103 @@
+package test_scaling
+
+import (
+	"context"
+	"testing"
+
+	kafka "github.com/Shopify/sarama"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	scaling "github.com/pip-services3-gox/pip-services3-kafka-gox/scaling"
+	"github.com/stretchr/testify/assert"
+)
+
+func publish(ctx context.Context, connection *fixtures.FakeKafkaConnection, topic string, count int) {
+	for i := 0; i < count; i++ {
+		_ = connection.Publish(ctx, topic, []*kafka.ProducerMessage{{Value: kafka.StringEncoder("message")}})
+	}
+}
+
+func newAdvisor(ctx context.Context, connection *fixtures.FakeKafkaConnection,
+	options ...interface{}) *scaling.KafkaPartitionAdvisor {
+
+	advisor := scaling.NewKafkaPartitionAdvisor()
+	config := cconf.NewConfigParamsFromTuples(
+		"topics", "orders",
+		"group_id", "billing",
+		"options.interval", 0,
+		"options.sustain_count", 2,
+		"options.max_partitions", 4,
+	)
+	advisor.Configure(ctx, config.Override(cconf.NewConfigParamsFromTuples(options...)))
+	advisor.SetReferences(ctx, cref.NewReferencesFromTuples(ctx,
+		cref.NewDescriptor("pip-services", "connection", "kafka", "default", "1.0"), connection,
+	))
+	return advisor
+}
+
+func TestKafkaPartitionAdvisorRecommends(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection("orders")
+	_ = connection.Open(ctx, "")
+
+	advisor := newAdvisor(ctx, connection)
+	err := advisor.Open(ctx, "")
+	assert.Nil(t, err)
+	defer advisor.Close(ctx, "")
+
+	// The first observation is a baseline
+	recommendations, err := advisor.Observe(ctx, "")
+	assert.Nil(t, err)
+	assert.Len(t, recommendations, 0)
+
+	// Idle partitions need no scaling
+	recommendations, err = advisor.Observe(ctx, "")
+	assert.Nil(t, err)
+	assert.Len(t, recommendations, 0)
+
+	// A single saturated observation is not sustained
+	publish(ctx, connection, "orders", 100)
+	recommendations, err = advisor.Observe(ctx, "")
+	assert.Nil(t, err)
+	assert.Len(t, recommendations, 0)
+
+	publish(ctx, connection, "orders", 100)
+	recommendations, err = advisor.Observe(ctx, "")
+	assert.Nil(t, err)
+	assert.Len(t, recommendations, 1)
+	assert.Equal(t, "orders", recommendations[0].Topic)
+	assert.Equal(t, 1, recommendations[0].Partitions)
+	assert.Equal(t, 4, recommendations[0].RecommendedPartitions)
+	assert.False(t, recommendations[0].Applied)
+	assert.Equal(t, int32(1), connection.Topics["orders"])
+	assert.Len(t, advisor.GetRecommendations(), 1)
+}
+
+func TestKafkaPartitionAdvisorScalesByLag(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection("orders")
+	connection.Lags = map[int32]int64{0: 250}
+	_ = connection.Open(ctx, "")
+
+	advisor := newAdvisor(ctx, connection,
+		"options.max_partition_lag", 100,
+		"options.auto_scale", true,
+	)
+	err := advisor.Open(ctx, "")
+	assert.Nil(t, err)
+	defer advisor.Close(ctx, "")
+
+	for i := 0; i < 2; i++ {
+		recommendations, err := advisor.Observe(ctx, "")
+		assert.Nil(t, err)
+		assert.Len(t, recommendations, 0)
+	}
+
+	recommendations, err := advisor.Observe(ctx, "")
+	assert.Nil(t, err)
+	assert.Len(t, recommendations, 1)
+	assert.Equal(t, 3, recommendations[0].RecommendedPartitions)
+	assert.True(t, recommendations[0].Applied)
+	assert.Equal(t, int32(3), connection.Topics["orders"])
+}
@@ -0,0 +1,116 @@
+package test_services
+
+import (
+	"context"
+	"testing"
+
+	kafka "github.com/Shopify/sarama"
+	ccomands "github.com/pip-services3-gox/pip-services3-commons-gox/commands"
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	crun "github.com/pip-services3-gox/pip-services3-commons-gox/run"
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	services "github.com/pip-services3-gox/pip-services3-kafka-gox/services"
+	"github.com/stretchr/testify/assert"
+)
+
+type testController struct {
+	commandSet *ccomands.CommandSet
+}
+
+func newTestController() *testController {
+	c := &testController{commandSet: ccomands.NewCommandSet()}
+	c.commandSet.AddCommand(ccomands.NewCommand("get_price", nil,
+		func(ctx context.Context, correlationId string, args *crun.Parameters) (any, error) {
+			return map[string]any{"product_id": args.GetAsString("product_id"), "price": 10}, nil
+		}))
+	return c
+}
+
+func (c *testController) GetCommandSet() *ccomands.CommandSet {
+	return c.commandSet
+}
+
+type testSession struct {
+	ctx context.Context
+}
+
+func (c *testSession) Claims() map[string][]int32                                               { return nil }
+func (c *testSession) MemberID() string                                                         { return "" }
+func (c *testSession) GenerationID() int32                                                      { return 0 }
+func (c *testSession) MarkOffset(topic string, partition int32, offset int64, metadata string)  {}
+func (c *testSession) Commit()                                                                  {}
+func (c *testSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {}
+func (c *testSession) MarkMessage(msg *kafka.ConsumerMessage, metadata string)                  {}
+func (c *testSession) Context() context.Context                                                 { return c.ctx }
+
+type testClaim struct {
+	messages chan *kafka.ConsumerMessage
+}
+
+func (c *testClaim) Topic() string                           { return "" }
+func (c *testClaim) Partition() int32                        { return 0 }
+func (c *testClaim) InitialOffset() int64                    { return 0 }
+func (c *testClaim) HighWaterMarkOffset() int64              { return 0 }
+func (c *testClaim) Messages() <-chan *kafka.ConsumerMessage { return c.messages }
+
+// Delivers a request to the subscribed service
+func deliverRequest(connection *fixtures.FakeKafkaConnection, topic string, command string, args string) error {
+	claim := &testClaim{messages: make(chan *kafka.ConsumerMessage, 1)}
+	claim.messages <- &kafka.ConsumerMessage{
+		Topic: topic,
+		Value: []byte(args),
+		Headers: []*kafka.RecordHeader{
+			{Key: []byte(clients.CommandHeader), Value: []byte(command)},
+			{Key: []byte(clients.RequestIdHeader), Value: []byte("1")},
+			{Key: []byte(clients.ReplyTopicHeader), Value: []byte(topic + ".replies")},
+		},
+	}
+	close(claim.messages)
+	return connection.GetListener(topic).ConsumeClaim(&testSession{ctx: context.Background()}, claim)
+}
+
+func TestKafkaCommandableService(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	_ = connection.Open(ctx, "")
+
+	service := services.NewKafkaCommandableService("v1.prices")
+	service.Configure(ctx, cconf.NewConfigParamsFromTuples(
+		"dependencies.controller", "test:controller:default:default:1.0",
+	))
+	service.SetReferences(ctx, cref.NewReferencesFromTuples(ctx,
+		cref.NewDescriptor("test", "controller", "default", "default", "1.0"), newTestController(),
+		cref.NewDescriptor("pip-services", "connection", "kafka", "default", "1.0"), connection,
+	))
+
+	err := service.Open(ctx, "")
+	assert.Nil(t, err)
+	defer service.Close(ctx, "")
+
+	err = deliverRequest(connection, "v1.prices", "get_price", `{"product_id": "1"}`)
+	assert.Nil(t, err)
+	err = deliverRequest(connection, "v1.prices", "get_cost", "")
+	assert.Nil(t, err)
+
+	replies := connection.GetPublished("v1.prices.replies")
+	assert.Len(t, replies, 2)
+
+	value, _ := replies[0].Value.Encode()
+	assert.JSONEq(t, `{"product_id": "1", "price": 10}`, string(value))
+	hasError := false
+	for _, header := range replies[1].Headers {
+		hasError = hasError || string(header.Key) == clients.ReplyErrorHeader
+	}
+	assert.True(t, hasError)
+}
+
+func TestKafkaCommandableServiceNoController(t *testing.T) {
+	ctx := context.Background()
+	service := services.NewKafkaCommandableService("v1.prices")
+	service.SetReferences(ctx, cref.NewEmptyReferences())
+
+	err := service.Open(ctx, "")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,38 @@
+package test_store
+
+import (
+	"context"
+	"testing"
+
+	kafka "github.com/Shopify/sarama"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	store "github.com/pip-services3-gox/pip-services3-kafka-gox/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadCompactedTopic(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	connection.Topics["settings"] = 2
+
+	_, err := store.LoadCompactedTopic(ctx, "", connection, "settings", nil)
+	assert.NotNil(t, err)
+
+	_ = connection.Open(ctx, "")
+	_ = connection.Publish(ctx, "settings", []*kafka.ProducerMessage{
+		{Partition: 0, Key: kafka.StringEncoder("a"), Value: kafka.StringEncoder("1")},
+		{Partition: 1, Key: kafka.StringEncoder("b"), Value: kafka.StringEncoder("2")},
+		{Partition: 0, Key: kafka.StringEncoder("a"), Value: kafka.StringEncoder("3")},
+		{Partition: 1, Key: kafka.StringEncoder("c"), Value: kafka.StringEncoder("4")},
+		{Partition: 1, Key: kafka.StringEncoder("b")},
+	})
+
+	reports := [][2]int64{}
+	values, err := store.LoadCompactedTopic(ctx, "", connection, "settings", func(loaded int64, total int64) {
+		reports = append(reports, [2]int64{loaded, total})
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"a": []byte("3"), "c": []byte("4")}, values)
+	assert.Equal(t, [2]int64{0, 5}, reports[0])
+	assert.Equal(t, [2]int64{5, 5}, reports[len(reports)-1])
+}
@@ -0,0 +1,78 @@
+package test_store
+
+import (
+	"context"
+	"testing"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	store "github.com/pip-services3-gox/pip-services3-kafka-gox/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestEventLog(connection *fixtures.FakeKafkaConnection) *store.KafkaEventLog {
+	log := store.NewKafkaEventLog()
+	log.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"topic", "events",
+	))
+	log.Connection = connection
+	return log
+}
+
+func TestKafkaEventLog(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	connection.Topics["events"] = 2
+	_ = connection.Open(ctx, "")
+
+	log1 := newTestEventLog(connection)
+	assert.Nil(t, log1.Open(ctx, ""))
+	defer log1.Close(ctx, "")
+	log2 := newTestEventLog(connection)
+	assert.Nil(t, log2.Open(ctx, ""))
+	defer log2.Close(ctx, "")
+
+	version, err := log1.Append(ctx, "", "order1", 0, []*store.KafkaEvent{
+		store.NewKafkaEvent("created", []byte("A")),
+		store.NewKafkaEvent("paid", []byte("B")),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), version)
+
+	// The second writer has an outdated version
+	_, err = log2.Append(ctx, "", "order1", 0, []*store.KafkaEvent{
+		store.NewKafkaEvent("created", []byte("X")),
+	})
+	assert.NotNil(t, err)
+
+	version, err = log2.Append(ctx, "", "order1", 2, []*store.KafkaEvent{
+		store.NewKafkaEvent("shipped", []byte("C")),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), version)
+
+	version, err = log1.Append(ctx, "", "order2", store.AnyVersion, []*store.KafkaEvent{
+		store.NewKafkaEvent("created", []byte("D")),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), version)
+
+	events, err := log1.ReadStream(ctx, "", "order1", 1)
+	assert.Nil(t, err)
+	assert.Len(t, events, 3)
+	assert.Equal(t, "created", events[0].Type)
+	assert.Equal(t, int64(3), events[2].Version)
+	assert.Equal(t, "C", string(events[2].Data))
+
+	events, err = log1.ReadStream(ctx, "", "order1", 2)
+	assert.Nil(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, int64(2), events[0].Version)
+
+	// A new instance rebuilds versions from the topic
+	log3 := newTestEventLog(connection)
+	assert.Nil(t, log3.Open(ctx, ""))
+	defer log3.Close(ctx, "")
+	assert.Equal(t, int64(3), log3.GetVersion("order1"))
+	assert.Equal(t, int64(1), log3.GetVersion("order2"))
+}
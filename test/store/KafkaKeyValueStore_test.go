@@ -0,0 +1,78 @@
+package test_store
+
+import (
+	"context"
+	"testing"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	store "github.com/pip-services3-gox/pip-services3-kafka-gox/store"
+	"github.com/stretchr/testify/assert"
+)
+
+type testKeyListener struct {
+	changes []string
+}
+
+func (c *testKeyListener) OnKeyChanged(ctx context.Context, key string, value []byte) {
+	if value == nil {
+		c.changes = append(c.changes, key+"=nil")
+	} else {
+		c.changes = append(c.changes, key+"="+string(value))
+	}
+}
+
+func newTestStore(connection *fixtures.FakeKafkaConnection) *store.KafkaKeyValueStore {
+	s := store.NewKafkaKeyValueStore()
+	s.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"topic", "settings",
+		"options.refresh_interval", 0,
+	))
+	s.Connection = connection
+	return s
+}
+
+func TestKafkaKeyValueStore(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	connection.Topics["settings"] = 2
+	_ = connection.Open(ctx, "")
+
+	store1 := newTestStore(connection)
+	assert.Nil(t, store1.Open(ctx, ""))
+	defer store1.Close(ctx, "")
+
+	err := store1.Put(ctx, "", "key1", []byte("A"))
+	assert.Nil(t, err)
+	err = store1.Put(ctx, "", "key2", []byte("B"))
+	assert.Nil(t, err)
+
+	value, ok := store1.Get("key1")
+	assert.True(t, ok)
+	assert.Equal(t, "A", string(value))
+
+	// A new instance loads the topic on open
+	store2 := newTestStore(connection)
+	listener := &testKeyListener{}
+	store2.Subscribe(listener)
+	assert.Nil(t, store2.Open(ctx, ""))
+	defer store2.Close(ctx, "")
+
+	assert.ElementsMatch(t, []string{"key1", "key2"}, store2.Keys())
+
+	err = store1.Put(ctx, "", "key1", []byte("C"))
+	assert.Nil(t, err)
+	err = store1.Remove(ctx, "", "key2")
+	assert.Nil(t, err)
+
+	err = store2.Refresh(ctx, "")
+	assert.Nil(t, err)
+
+	value, _ = store2.Get("key1")
+	assert.Equal(t, "C", string(value))
+	_, ok = store2.Get("key2")
+	assert.False(t, ok)
+
+	assert.Contains(t, listener.changes, "key1=C")
+	assert.Contains(t, listener.changes, "key2=nil")
+}
@@ -0,0 +1,79 @@
+package test_streams
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	streams "github.com/pip-services3-gox/pip-services3-kafka-gox/streams"
+	"github.com/stretchr/testify/assert"
+)
+
+func concatRecords(ctx context.Context, left *clients.KafkaRecord, right *clients.KafkaRecord) (*clients.KafkaRecord, error) {
+	value := string(left.Value) + "+"
+	if right != nil {
+		value += string(right.Value)
+	}
+	return &clients.KafkaRecord{Key: left.Key, Value: []byte(value)}, nil
+}
+
+func TestKafkaStreamJoin(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	_ = connection.Open(ctx, "")
+
+	builder := streams.NewKafkaTopologyBuilder()
+	builder.Stream("orders").
+		Join(builder.Stream("payments"), time.Minute, concatRecords).
+		To("paid_orders")
+
+	processor := streams.NewKafkaStreamProcessor(builder)
+	processor.Connection = connection
+	assert.Nil(t, processor.Open(ctx, ""))
+	defer processor.Close(ctx, "")
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	consumeRecords(t, connection, "orders",
+		&kafka.ConsumerMessage{Topic: "orders", Key: []byte("1"), Value: []byte("o1"), Timestamp: start},
+		&kafka.ConsumerMessage{Topic: "orders", Key: []byte("2"), Value: []byte("o2"), Timestamp: start},
+	)
+	consumeRecords(t, connection, "payments",
+		&kafka.ConsumerMessage{Topic: "payments", Key: []byte("1"), Value: []byte("p1"), Timestamp: start.Add(30 * time.Second)},
+		// Payment is out of the window
+		&kafka.ConsumerMessage{Topic: "payments", Key: []byte("2"), Value: []byte("p2"), Timestamp: start.Add(2 * time.Minute)},
+	)
+
+	assert.Equal(t, []string{"o1+p1"}, publishedValues(connection, "paid_orders"))
+}
+
+func TestKafkaStreamJoinTable(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	connection.Topics["customers"] = 1
+	_ = connection.Open(ctx, "")
+
+	builder := streams.NewKafkaTopologyBuilder()
+	customers := builder.Table("customers")
+	orders := builder.Stream("orders")
+	orders.JoinTable(customers, concatRecords).To("enriched_orders")
+	orders.LeftJoinTable(customers, concatRecords).To("all_orders")
+
+	processor := streams.NewKafkaStreamProcessor(builder)
+	processor.Connection = connection
+	assert.Nil(t, processor.Open(ctx, ""))
+	defer processor.Close(ctx, "")
+
+	err := customers.Put(ctx, "", "1", []byte("c1"))
+	assert.Nil(t, err)
+
+	consumeRecords(t, connection, "orders",
+		&kafka.ConsumerMessage{Topic: "orders", Key: []byte("1"), Value: []byte("o1")},
+		&kafka.ConsumerMessage{Topic: "orders", Key: []byte("2"), Value: []byte("o2")},
+	)
+
+	assert.Equal(t, []string{"o1+c1"}, publishedValues(connection, "enriched_orders"))
+	assert.Equal(t, []string{"o1+c1", "o2+"}, publishedValues(connection, "all_orders"))
+}
@@ -0,0 +1,95 @@
+package test_streams
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	kafka "github.com/Shopify/sarama"
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	streams "github.com/pip-services3-gox/pip-services3-kafka-gox/streams"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSession struct {
+	ctx    context.Context
+	marked []int64
+}
+
+func (c *fakeSession) Claims() map[string][]int32 { return nil }
+func (c *fakeSession) MemberID() string           { return "" }
+func (c *fakeSession) GenerationID() int32        { return 0 }
+func (c *fakeSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+}
+func (c *fakeSession) Commit() {}
+func (c *fakeSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+}
+func (c *fakeSession) MarkMessage(msg *kafka.ConsumerMessage, metadata string) {
+	c.marked = append(c.marked, msg.Offset)
+}
+func (c *fakeSession) Context() context.Context { return c.ctx }
+
+type fakeClaim struct {
+	messages chan *kafka.ConsumerMessage
+}
+
+func (c *fakeClaim) Topic() string                           { return "" }
+func (c *fakeClaim) Partition() int32                        { return 0 }
+func (c *fakeClaim) InitialOffset() int64                    { return 0 }
+func (c *fakeClaim) HighWaterMarkOffset() int64              { return 0 }
+func (c *fakeClaim) Messages() <-chan *kafka.ConsumerMessage { return c.messages }
+
+func TestKafkaStreamProcessor(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	_ = connection.Open(ctx, "")
+
+	builder := streams.NewKafkaTopologyBuilder()
+	orders := builder.Stream("orders").
+		Filter(func(ctx context.Context, record *clients.KafkaRecord) bool {
+			return len(record.Value) > 0
+		}).
+		Map(func(ctx context.Context, record *clients.KafkaRecord) (*clients.KafkaRecord, error) {
+			mapped := *record
+			mapped.Value = []byte(strings.ToUpper(string(record.Value)))
+			return &mapped, nil
+		})
+	branches := orders.Branch(
+		func(ctx context.Context, record *clients.KafkaRecord) bool {
+			return strings.HasPrefix(string(record.Value), "D")
+		},
+		func(ctx context.Context, record *clients.KafkaRecord) bool {
+			return true
+		},
+	)
+	branches[0].To("domestic")
+	branches[1].To("international")
+
+	processor := streams.NewKafkaStreamProcessor(builder)
+	processor.Connection = connection
+	err := processor.Open(ctx, "")
+	assert.Nil(t, err)
+
+	session := &fakeSession{ctx: ctx}
+	claim := &fakeClaim{messages: make(chan *kafka.ConsumerMessage, 3)}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "orders", Offset: 1, Value: []byte("d1")}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "orders", Offset: 2, Value: []byte("")}
+	claim.messages <- &kafka.ConsumerMessage{Topic: "orders", Offset: 3, Value: []byte("i1")}
+	close(claim.messages)
+
+	err = connection.Listeners["orders"].ConsumeClaim(session, claim)
+	assert.Nil(t, err)
+
+	assert.Len(t, connection.GetPublished("domestic"), 1)
+	assert.Len(t, connection.GetPublished("international"), 1)
+	value, _ := connection.GetPublished("international")[0].Value.Encode()
+	assert.Equal(t, "I1", string(value))
+
+	// Filtered records are committed too
+	assert.Equal(t, []int64{1, 2, 3}, session.marked)
+
+	err = processor.Close(ctx, "")
+	assert.Nil(t, err)
+	assert.Len(t, connection.Listeners, 0)
+}
@@ -0,0 +1,113 @@
+package test_streams
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	clients "github.com/pip-services3-gox/pip-services3-kafka-gox/clients"
+	fixtures "github.com/pip-services3-gox/pip-services3-kafka-gox/fixtures"
+	streams "github.com/pip-services3-gox/pip-services3-kafka-gox/streams"
+	"github.com/stretchr/testify/assert"
+)
+
+func countRecords(ctx context.Context, aggregate []byte, record *clients.KafkaRecord) ([]byte, error) {
+	count, _ := strconv.Atoi(string(aggregate))
+	return []byte(strconv.Itoa(count + 1)), nil
+}
+
+func newCountTopology(window *streams.KafkaWindow) *streams.KafkaTopologyBuilder {
+	builder := streams.NewKafkaTopologyBuilder()
+	builder.Stream("clicks").
+		WindowedBy(window).
+		Aggregate("clicks_changelog", countRecords).
+		To("click_counts")
+	return builder
+}
+
+func consumeRecords(t *testing.T, connection *fixtures.FakeKafkaConnection, topic string, messages ...*kafka.ConsumerMessage) {
+	claim := &fakeClaim{messages: make(chan *kafka.ConsumerMessage, len(messages))}
+	for _, msg := range messages {
+		claim.messages <- msg
+	}
+	close(claim.messages)
+
+	err := connection.Listeners[topic].ConsumeClaim(&fakeSession{ctx: context.Background()}, claim)
+	assert.Nil(t, err)
+}
+
+func publishedValues(connection *fixtures.FakeKafkaConnection, topic string) []string {
+	values := []string{}
+	for _, msg := range connection.Published[topic] {
+		value, _ := msg.Value.Encode()
+		values = append(values, string(value))
+	}
+	return values
+}
+
+func TestKafkaTumblingWindowAggregate(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	connection.Topics["clicks_changelog"] = 1
+	_ = connection.Open(ctx, "")
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	window := streams.NewTumblingWindow(time.Minute)
+
+	processor := streams.NewKafkaStreamProcessor(newCountTopology(window))
+	processor.Connection = connection
+	assert.Nil(t, processor.Open(ctx, ""))
+
+	consumeRecords(t, connection, "clicks",
+		&kafka.ConsumerMessage{Topic: "clicks", Offset: 1, Key: []byte("a"), Timestamp: start},
+		&kafka.ConsumerMessage{Topic: "clicks", Offset: 2, Key: []byte("a"), Timestamp: start.Add(10 * time.Second)},
+		&kafka.ConsumerMessage{Topic: "clicks", Offset: 3, Key: []byte("b"), Timestamp: start.Add(20 * time.Second)},
+	)
+	assert.Equal(t, []string{"1", "2", "1"}, publishedValues(connection, "click_counts"))
+
+	// Headers are taken from a map, so they are found by keys
+	headers := map[string]string{}
+	for _, header := range connection.GetPublished("click_counts")[1].Headers {
+		headers[string(header.Key)] = string(header.Value)
+	}
+	assert.Equal(t, strconv.FormatInt(start.UnixMilli(), 10), headers[streams.WindowStartHeader])
+
+	assert.Nil(t, processor.Close(ctx, ""))
+
+	// The state is recovered by a new processor
+	processor = streams.NewKafkaStreamProcessor(newCountTopology(window))
+	processor.Connection = connection
+	assert.Nil(t, processor.Open(ctx, ""))
+	defer processor.Close(ctx, "")
+
+	consumeRecords(t, connection, "clicks",
+		&kafka.ConsumerMessage{Topic: "clicks", Offset: 4, Key: []byte("a"), Timestamp: start.Add(30 * time.Second)},
+		&kafka.ConsumerMessage{Topic: "clicks", Offset: 5, Key: []byte("a"), Timestamp: start.Add(70 * time.Second)},
+		// Late record of the closed window is dropped
+		&kafka.ConsumerMessage{Topic: "clicks", Offset: 6, Key: []byte("a"), Timestamp: start.Add(40 * time.Second)},
+	)
+	assert.Equal(t, []string{"1", "2", "1", "3", "1"}, publishedValues(connection, "click_counts"))
+}
+
+func TestKafkaHoppingWindowAggregate(t *testing.T) {
+	ctx := context.Background()
+	connection := fixtures.NewFakeKafkaConnection()
+	connection.Topics["clicks_changelog"] = 1
+	_ = connection.Open(ctx, "")
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	window := streams.NewHoppingWindow(time.Minute, 30*time.Second)
+
+	processor := streams.NewKafkaStreamProcessor(newCountTopology(window))
+	processor.Connection = connection
+	assert.Nil(t, processor.Open(ctx, ""))
+	defer processor.Close(ctx, "")
+
+	consumeRecords(t, connection, "clicks",
+		&kafka.ConsumerMessage{Topic: "clicks", Offset: 1, Key: []byte("a"), Timestamp: start.Add(40 * time.Second)},
+	)
+	// The record belongs to two overlapping windows
+	assert.Equal(t, []string{"1", "1"}, publishedValues(connection, "click_counts"))
+}